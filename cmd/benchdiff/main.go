@@ -0,0 +1,342 @@
+// Package main реализует benchdiff - сравнение результатов бенчмарков с базовой линией.
+//
+// Примеры:
+//
+//	benchdiff -save bench/baseline.json                    # запустить бенчмарки и сохранить базовую линию
+//	benchdiff -baseline bench/baseline.json                # запустить и сравнить
+//	benchdiff -input bench.txt -baseline base.json -format markdown
+//
+// Код выхода 1 означает регрессию выше порога, 2 - ошибку запуска.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result усредненный результат одного бенчмарка
+type Result struct {
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  float64 `json:"bytes_per_op"`
+	AllocsPerOp float64 `json:"allocs_per_op"`
+	Runs        int     `json:"runs"`
+}
+
+// Snapshot набор результатов, сохраняемый на диск
+type Snapshot struct {
+	CreatedAt  time.Time         `json:"created_at"`
+	GoVersion  string            `json:"go_version,omitempty"`
+	Benchmarks map[string]Result `json:"benchmarks"`
+}
+
+// Delta сравнение одного бенчмарка с базовой линией
+type Delta struct {
+	Name       string
+	Old, New   Result
+	TimeChange float64 // относительное изменение ns/op, 0.1 = +10%
+	AllocDelta float64 // абсолютное изменение allocs/op
+	Regressed  bool
+	Missing    bool // бенчмарк есть в базовой линии, но отсутствует в текущем запуске
+}
+
+func main() {
+	var (
+		pkgs           = flag.String("pkg", "./...", "packages to benchmark")
+		bench          = flag.String("bench", ".", "benchmark regexp passed to go test -bench")
+		count          = flag.Int("count", 5, "number of runs per benchmark")
+		benchtime      = flag.String("benchtime", "", "go test -benchtime value")
+		input          = flag.String("input", "", "parse existing `go test -bench` output instead of running (- for stdin)")
+		save           = flag.String("save", "", "write current results to this file")
+		baseline       = flag.String("baseline", "", "baseline file to compare against")
+		threshold      = flag.Float64("threshold", 10, "allowed ns/op regression in percent")
+		allocThreshold = flag.Float64("alloc-threshold", 0, "allowed increase of allocs/op")
+		format         = flag.String("format", "text", "report format: text or markdown")
+	)
+	flag.Parse()
+
+	output, err := benchOutput(*input, *pkgs, *bench, *benchtime, *count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchdiff: %v\n", err)
+		os.Exit(2)
+	}
+
+	current := Snapshot{
+		CreatedAt:  time.Now().UTC(),
+		GoVersion:  goVersion(),
+		Benchmarks: ParseBenchOutput(bytes.NewReader(output)),
+	}
+	if len(current.Benchmarks) == 0 {
+		fmt.Fprintln(os.Stderr, "benchdiff: no benchmark results found")
+		os.Exit(2)
+	}
+
+	if *save != "" {
+		if err := writeSnapshot(*save, current); err != nil {
+			fmt.Fprintf(os.Stderr, "benchdiff: %v\n", err)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "Saved %d benchmarks to %s\n", len(current.Benchmarks), *save)
+	}
+
+	if *baseline == "" {
+		return
+	}
+
+	base, err := readSnapshot(*baseline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchdiff: %v\n", err)
+		os.Exit(2)
+	}
+
+	deltas := Compare(base, current, *threshold/100, *allocThreshold)
+	if *format == "markdown" {
+		WriteMarkdown(os.Stdout, deltas, *threshold)
+	} else {
+		WriteText(os.Stdout, deltas, *threshold)
+	}
+
+	for _, d := range deltas {
+		if d.Regressed {
+			os.Exit(1)
+		}
+	}
+}
+
+// benchOutput запускает бенчмарки или читает готовый вывод
+func benchOutput(input, pkgs, bench, benchtime string, count int) ([]byte, error) {
+	switch input {
+	case "":
+	case "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(input)
+	}
+
+	args := []string{"test", "-run=^$", "-bench=" + bench, "-benchmem", "-count=" + strconv.Itoa(count)}
+	if benchtime != "" {
+		args = append(args, "-benchtime="+benchtime)
+	}
+	args = append(args, strings.Fields(pkgs)...)
+
+	cmd := exec.Command("go", args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go test failed: %w\n%s", err, out)
+	}
+	return out, nil
+}
+
+// ParseBenchOutput разбирает вывод `go test -bench -benchmem`.
+// Имена дополняются пакетом, суффикс GOMAXPROCS отбрасывается, повторные запуски усредняются
+func ParseBenchOutput(r io.Reader) map[string]Result {
+	type acc struct {
+		ns, bytes, allocs float64
+		runs              int
+	}
+	sums := make(map[string]*acc)
+
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "pkg: ") {
+			pkg = strings.TrimPrefix(line, "pkg: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "Benchmark") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		name := fields[0]
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+		if pkg != "" {
+			name = pkg + "." + name
+		}
+
+		a := sums[name]
+		if a == nil {
+			a = &acc{}
+			sums[name] = a
+		}
+		parsed := false
+		// После имени и числа итераций идут пары "значение единица"
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				a.ns += v
+				parsed = true
+			case "B/op":
+				a.bytes += v
+			case "allocs/op":
+				a.allocs += v
+			}
+		}
+		if parsed {
+			a.runs++
+		}
+	}
+
+	results := make(map[string]Result, len(sums))
+	for name, a := range sums {
+		if a.runs == 0 {
+			continue
+		}
+		n := float64(a.runs)
+		results[name] = Result{NsPerOp: a.ns / n, BytesPerOp: a.bytes / n, AllocsPerOp: a.allocs / n, Runs: a.runs}
+	}
+	return results
+}
+
+// Compare сравнивает текущие результаты с базовой линией.
+// timeThreshold задается долей (0.1 = 10%), allocThreshold - абсолютным числом аллокаций
+func Compare(base, current Snapshot, timeThreshold, allocThreshold float64) []Delta {
+	names := make(map[string]bool)
+	for name := range base.Benchmarks {
+		names[name] = true
+	}
+	for name := range current.Benchmarks {
+		names[name] = true
+	}
+
+	deltas := make([]Delta, 0, len(names))
+	for name := range names {
+		oldRes, inBase := base.Benchmarks[name]
+		newRes, inCurrent := current.Benchmarks[name]
+
+		d := Delta{Name: name, Old: oldRes, New: newRes}
+		switch {
+		case !inCurrent:
+			d.Missing = true
+		case inBase:
+			if oldRes.NsPerOp > 0 {
+				d.TimeChange = (newRes.NsPerOp - oldRes.NsPerOp) / oldRes.NsPerOp
+			}
+			d.AllocDelta = newRes.AllocsPerOp - oldRes.AllocsPerOp
+			d.Regressed = d.TimeChange > timeThreshold || d.AllocDelta > allocThreshold
+		}
+		deltas = append(deltas, d)
+	}
+
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Name < deltas[j].Name })
+	return deltas
+}
+
+// WriteText печатает отчет в виде таблицы для терминала
+func WriteText(w io.Writer, deltas []Delta, threshold float64) {
+	fmt.Fprintf(w, "%-60s %14s %14s %9s %14s\n", "benchmark", "old ns/op", "new ns/op", "delta", "allocs/op")
+	for _, d := range deltas {
+		fmt.Fprintf(w, "%-60s %14s %14s %9s %14s %s\n", d.Name,
+			formatNs(d.Old.NsPerOp), formatNs(d.New.NsPerOp), formatChange(d), formatAllocs(d), status(d))
+	}
+	fmt.Fprintf(w, "\n%d regression(s) above %.1f%% threshold\n", countRegressions(deltas), threshold)
+}
+
+// WriteMarkdown печатает отчет в формате Markdown для комментариев в PR
+func WriteMarkdown(w io.Writer, deltas []Delta, threshold float64) {
+	fmt.Fprintf(w, "### Benchmark comparison\n\n")
+	fmt.Fprintf(w, "| benchmark | old ns/op | new ns/op | delta | allocs/op | |\n")
+	fmt.Fprintf(w, "|---|---:|---:|---:|---:|---|\n")
+	for _, d := range deltas {
+		fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s | %s |\n", d.Name,
+			formatNs(d.Old.NsPerOp), formatNs(d.New.NsPerOp), formatChange(d), formatAllocs(d), status(d))
+	}
+	fmt.Fprintf(w, "\n**%d regression(s)** above %.1f%% threshold\n", countRegressions(deltas), threshold)
+}
+
+func formatNs(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+func formatChange(d Delta) string {
+	if d.Missing || d.Old.NsPerOp == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", d.TimeChange*100)
+}
+
+func formatAllocs(d Delta) string {
+	if d.Missing {
+		return "-"
+	}
+	return fmt.Sprintf("%g→%g", d.Old.AllocsPerOp, d.New.AllocsPerOp)
+}
+
+func status(d Delta) string {
+	switch {
+	case d.Missing:
+		return "missing"
+	case d.Old.Runs == 0:
+		return "new"
+	case d.Regressed:
+		return "REGRESSION"
+	}
+	return "ok"
+}
+
+func countRegressions(deltas []Delta) int {
+	n := 0
+	for _, d := range deltas {
+		if d.Regressed {
+			n++
+		}
+	}
+	return n
+}
+
+func readSnapshot(path string) (Snapshot, error) {
+	var s Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func writeSnapshot(path string, s Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func goVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleOutput = `goos: linux
+goarch: amd64
+pkg: highload-service/internal/analytics
+BenchmarkAnalyzeSync-8        	 5000000	       240.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkAnalyzeSync-8        	 5000000	       260.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkSlidingWindowAdd-8   	100000000	        10.5 ns/op
+PASS
+ok  	highload-service/internal/analytics	3.1s
+`
+
+func TestParseBenchOutput(t *testing.T) {
+	results := ParseBenchOutput(strings.NewReader(sampleOutput))
+
+	sync, ok := results["highload-service/internal/analytics.BenchmarkAnalyzeSync"]
+	if !ok {
+		t.Fatalf("Expected AnalyzeSync result, got %v", results)
+	}
+	if sync.NsPerOp != 250 || sync.Runs != 2 {
+		t.Errorf("Expected averaged 250 ns/op over 2 runs, got %+v", sync)
+	}
+
+	add := results["highload-service/internal/analytics.BenchmarkSlidingWindowAdd"]
+	if add.NsPerOp != 10.5 || add.AllocsPerOp != 0 {
+		t.Errorf("Unexpected SlidingWindowAdd result %+v", add)
+	}
+}
+
+func TestCompare_Thresholds(t *testing.T) {
+	base := Snapshot{Benchmarks: map[string]Result{
+		"fast":    {NsPerOp: 100, AllocsPerOp: 1, Runs: 1},
+		"slow":    {NsPerOp: 100, AllocsPerOp: 1, Runs: 1},
+		"allocs":  {NsPerOp: 100, AllocsPerOp: 1, Runs: 1},
+		"removed": {NsPerOp: 100, Runs: 1},
+	}}
+	current := Snapshot{Benchmarks: map[string]Result{
+		"fast":   {NsPerOp: 105, AllocsPerOp: 1, Runs: 1},
+		"slow":   {NsPerOp: 120, AllocsPerOp: 1, Runs: 1},
+		"allocs": {NsPerOp: 90, AllocsPerOp: 2, Runs: 1},
+		"added":  {NsPerOp: 50, Runs: 1},
+	}}
+
+	want := map[string]bool{"fast": false, "slow": true, "allocs": true, "removed": false, "added": false}
+	for _, d := range Compare(base, current, 0.10, 0) {
+		if d.Regressed != want[d.Name] {
+			t.Errorf("%s: expected regressed=%v, got %v (%+v)", d.Name, want[d.Name], d.Regressed, d)
+		}
+		if d.Name == "removed" && !d.Missing {
+			t.Errorf("Expected removed benchmark to be reported missing")
+		}
+	}
+}
@@ -0,0 +1,621 @@
+// Package main реализует jsongen - генератор кодировщиков и разборщиков JSON
+// для структур пакета без reflect. Генератор читает исходники пакета (go/ast),
+// поэтому не зависит от того, собирается ли пакет с устаревшим сгенерированным
+// файлом.
+//
+// Сгенерированный файл собирается без тега stdjson; с тегом вместо него
+// собирается файл-заглушка (суффикс _std), и значения кодирует encoding/json.
+//
+// Пример (из go:generate в каталоге пакета):
+//
+//	jsongen -types Metric,MetricsBatch -out metrics_json.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generatedPrefix начало комментария сгенерированных файлов (go help generate)
+const generatedPrefix = "// Code generated "
+
+func main() {
+	var (
+		dir   = flag.String("dir", ".", "package directory")
+		types = flag.String("types", "", "comma-separated struct types to generate")
+		out   = flag.String("out", "", "output file in the package directory; the stdjson stub gets the _std suffix")
+	)
+	flag.Parse()
+	if *types == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "jsongen: -types and -out are required")
+		os.Exit(2)
+	}
+
+	fast, std, err := Generate(*dir, strings.Split(*types, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jsongen: %v\n", err)
+		os.Exit(1)
+	}
+	for name, src := range map[string][]byte{*out: fast, StubName(*out): std} {
+		if err := os.WriteFile(filepath.Join(*dir, name), src, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "jsongen: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// StubName имя файла-заглушки для тега stdjson
+func StubName(out string) string {
+	return strings.TrimSuffix(out, ".go") + "_std.go"
+}
+
+// kind вид значения поля
+type kind int
+
+const (
+	kindString kind = iota
+	kindBool
+	kindInt
+	kindUint64
+	kindFloat
+	kindTime
+	kindStruct
+	kindMap
+	kindSlice
+)
+
+// goType тип поля; elem — тип значений карты или элементов среза
+type goType struct {
+	kind kind
+	name string
+	elem *goType
+}
+
+// field поле структуры, которое попадает в JSON
+type field struct {
+	goName    string
+	jsonName  string
+	omitEmpty bool
+	typ       *goType
+}
+
+// structDef структура с полями в порядке объявления
+type structDef struct {
+	name   string
+	fields []field
+}
+
+// generator состояние генерации одного пакета
+type generator struct {
+	pkg     string
+	structs []*structDef
+	buf     bytes.Buffer
+	// imports пакеты, которые понадобились сгенерированному коду
+	imports map[string]bool
+}
+
+// Generate возвращает сгенерированный файл и заглушку для тега stdjson
+func Generate(dir string, types []string) (fast, std []byte, err error) {
+	pkg, decls, err := parseStructs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]bool, len(types))
+	for _, name := range types {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	g := &generator{pkg: pkg, imports: map[string]bool{"highload-service/internal/jsonfast": true}}
+	for _, name := range types {
+		name = strings.TrimSpace(name)
+		st, ok := decls[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("struct type %s not found in %s", name, dir)
+		}
+		def, err := newStructDef(name, st, wanted)
+		if err != nil {
+			return nil, nil, err
+		}
+		g.structs = append(g.structs, def)
+	}
+
+	header := generatedPrefix + "by jsongen -types " + strings.Join(types, ",") + "; DO NOT EDIT.\n\n"
+	if fast, err = g.fast(header); err != nil {
+		return nil, nil, err
+	}
+	std, err = format.Source([]byte(header + "//go:build stdjson\n\npackage " + pkg + stubBody))
+	return fast, std, err
+}
+
+// stubBody заглушка: без сгенерированного кода значения кодирует encoding/json
+const stubBody = `
+
+// AppendJSON со сборочным тегом stdjson сгенерированных кодировщиков нет:
+// ok всегда false, и значения кодирует encoding/json
+func AppendJSON(dst []byte, v interface{}) ([]byte, bool, error) {
+	return dst, false, nil
+}
+
+// DecodeJSON со сборочным тегом stdjson всегда возвращает ok == false
+func DecodeJSON(data []byte, v interface{}) (bool, error) {
+	return false, nil
+}
+`
+
+// parseStructs читает структуры пакета, кроме тестов и сгенерированных файлов
+func parseStructs(dir string) (string, map[string]*ast.StructType, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pkgs) != 1 {
+		return "", nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+	decls := make(map[string]*ast.StructType)
+	var name string
+	for pkgName, pkg := range pkgs {
+		name = pkgName
+		for _, f := range pkg.Files {
+			if len(f.Comments) > 0 && strings.HasPrefix(f.Comments[0].Text(), strings.TrimPrefix(generatedPrefix, "// ")) {
+				continue
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				if ts, ok := n.(*ast.TypeSpec); ok {
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						decls[ts.Name.Name] = st
+					}
+				}
+				return true
+			})
+		}
+	}
+	return name, decls, nil
+}
+
+// newStructDef собирает поля структуры по правилам encoding/json
+func newStructDef(name string, st *ast.StructType, wanted map[string]bool) (*structDef, error) {
+	def := &structDef{name: name}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			raw, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			tag = reflect.StructTag(raw)
+		}
+		jsonTag := tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		jsonName, opts, _ := strings.Cut(jsonTag, ",")
+		for _, opt := range strings.Split(opts, ",") {
+			if opt != "" && opt != "omitempty" {
+				return nil, fmt.Errorf("%s: json option %q is not supported", name, opt)
+			}
+		}
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			typ, err := resolveType(f.Type, wanted)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", name, ident.Name, err)
+			}
+			fieldName := jsonName
+			if fieldName == "" {
+				fieldName = ident.Name
+			}
+			def.fields = append(def.fields, field{
+				goName:    ident.Name,
+				jsonName:  fieldName,
+				omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+				typ:       typ,
+			})
+		}
+	}
+	return def, nil
+}
+
+// resolveType переводит тип поля; структуры должны генерироваться тем же вызовом
+func resolveType(expr ast.Expr, wanted map[string]bool) (*goType, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return &goType{kind: kindString, name: t.Name}, nil
+		case "bool":
+			return &goType{kind: kindBool, name: t.Name}, nil
+		case "int":
+			return &goType{kind: kindInt, name: t.Name}, nil
+		case "uint64":
+			return &goType{kind: kindUint64, name: t.Name}, nil
+		case "float64":
+			return &goType{kind: kindFloat, name: t.Name}, nil
+		}
+		if !wanted[t.Name] {
+			return nil, fmt.Errorf("type %s is not generated, add it to -types", t.Name)
+		}
+		return &goType{kind: kindStruct, name: t.Name}, nil
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			return &goType{kind: kindTime, name: "time.Time"}, nil
+		}
+	case *ast.MapType:
+		if key, ok := t.Key.(*ast.Ident); !ok || key.Name != "string" {
+			return nil, errors.New("only maps with string keys are supported")
+		}
+		elem, err := resolveType(t.Value, wanted)
+		if err != nil {
+			return nil, err
+		}
+		return &goType{kind: kindMap, elem: elem}, nil
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return nil, errors.New("arrays are not supported")
+		}
+		elem, err := resolveType(t.Elt, wanted)
+		if err != nil {
+			return nil, err
+		}
+		return &goType{kind: kindSlice, elem: elem}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", expr)
+}
+
+// source тип в исходном коде пакета
+func (t *goType) source() string {
+	switch t.kind {
+	case kindMap:
+		return "map[string]" + t.elem.source()
+	case kindSlice:
+		return "[]" + t.elem.source()
+	}
+	return t.name
+}
+
+// display тип для сообщений об ошибках, как его называет encoding/json
+func (t *goType) display(pkg string) string {
+	switch t.kind {
+	case kindStruct:
+		return pkg + "." + t.name
+	case kindMap:
+		return "map[string]" + t.elem.display(pkg)
+	case kindSlice:
+		return "[]" + t.elem.display(pkg)
+	}
+	return t.name
+}
+
+// canFail кодирование значения может вернуть ошибку (NaN, год вне диапазона)
+func (t *goType) canFail() bool {
+	switch t.kind {
+	case kindFloat, kindTime, kindStruct:
+		return true
+	case kindMap, kindSlice:
+		return t.elem.canFail()
+	}
+	return false
+}
+
+// nonEmpty условие записи поля с omitempty; пусто — поле пишется всегда
+// (структуры и время encoding/json не считает пустыми)
+func (t *goType) nonEmpty(expr string) string {
+	switch t.kind {
+	case kindString:
+		return expr + ` != ""`
+	case kindBool:
+		return expr
+	case kindInt, kindUint64, kindFloat:
+		return expr + " != 0"
+	case kindMap, kindSlice:
+		return "len(" + expr + ") != 0"
+	}
+	return ""
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// fast сгенерированный файл с кодировщиками и разборщиками
+func (g *generator) fast(header string) ([]byte, error) {
+	g.buf.Reset()
+	g.dispatch()
+	for _, def := range g.structs {
+		g.appendFunc(def)
+		g.decodeFunc(def)
+		g.fieldFunc(def)
+	}
+
+	// Стандартная библиотека отделяется от пакетов модуля, как в остальном коде
+	var std, local []string
+	for path := range g.imports {
+		if strings.Contains(path, ".") || strings.Contains(path, "/internal/") {
+			local = append(local, strconv.Quote(path))
+		} else {
+			std = append(std, strconv.Quote(path))
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(local)
+	imports := strings.Join(std, "\n")
+	if len(std) > 0 && len(local) > 0 {
+		imports += "\n\n"
+	}
+	imports += strings.Join(local, "\n")
+	src := header + "//go:build !stdjson\n\npackage " + g.pkg + "\n\nimport (\n" + imports + "\n)\n\n" + g.buf.String()
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return nil, fmt.Errorf("generated code does not compile: %v\n%s", err, src)
+	}
+	return formatted, nil
+}
+
+// dispatch функции AppendJSON и DecodeJSON, выбирающие код по типу значения
+func (g *generator) dispatch() {
+	g.p("// AppendJSON дописывает в dst JSON значения v сгенерированным кодировщиком.")
+	g.p("// ok == false — для типа v кодировщика нет, и dst возвращается без изменений")
+	g.p("func AppendJSON(dst []byte, v interface{}) (b []byte, ok bool, err error) {")
+	g.p("switch v := v.(type) {")
+	for _, def := range g.structs {
+		g.p("case *%s:", def.name)
+		g.p("if v == nil {")
+		g.p(`return append(dst, "null"...), true, nil`)
+		g.p("}")
+		g.p("b, err = v.appendJSON(dst)")
+		g.p("case %s:", def.name)
+		g.p("b, err = v.appendJSON(dst)")
+	}
+	g.p("default:")
+	g.p("return dst, false, nil")
+	g.p("}")
+	g.p("return b, true, err")
+	g.p("}")
+	g.p("")
+	g.p("// DecodeJSON разбирает документ data в v сгенерированным разборщиком.")
+	g.p("// ok == false — для типа v разборщика нет")
+	g.p("func DecodeJSON(data []byte, v interface{}) (ok bool, err error) {")
+	g.p("var d jsonfast.Decoder")
+	g.p("switch v := v.(type) {")
+	for _, def := range g.structs {
+		g.p("case *%s:", def.name)
+		g.p("if v == nil {")
+		g.p("return false, nil")
+		g.p("}")
+		g.p("d.Reset(data)")
+		g.p("v.decodeJSON(&d)")
+	}
+	g.p("default:")
+	g.p("return false, nil")
+	g.p("}")
+	g.p("return true, d.End()")
+	g.p("}")
+}
+
+// appendFunc кодировщик структуры
+func (g *generator) appendFunc(def *structDef) {
+	canFail := false
+	for _, f := range def.fields {
+		canFail = canFail || f.typ.canFail()
+	}
+	g.p("")
+	g.p("func (v *%s) appendJSON(dst []byte) ([]byte, error) {", def.name)
+	if canFail {
+		g.p("var err error")
+	}
+	g.p("dst = append(dst, '{')")
+	// wrote поле уже точно записано, и перед следующим нужна запятая
+	wrote := false
+	for i, f := range def.fields {
+		expr := "v." + f.goName
+		check := ""
+		if f.omitEmpty {
+			check = f.typ.nonEmpty(expr)
+		}
+		if check != "" {
+			g.p("if %s {", check)
+		}
+		key := strconv.Quote(f.jsonName)
+		switch {
+		case wrote:
+			g.p("dst = append(dst, `,%s:`...)", key)
+		case i == 0:
+			g.p("dst = append(dst, `%s:`...)", key)
+		default:
+			// Предыдущие поля могли быть пропущены
+			g.p("if dst[len(dst)-1] != '{' {")
+			g.p("dst = append(dst, ',')")
+			g.p("}")
+			g.p("dst = append(dst, `%s:`...)", key)
+		}
+		g.appendValue(f.typ, expr, 0)
+		if check != "" {
+			g.p("}")
+		} else {
+			wrote = true
+		}
+	}
+	g.p("dst = append(dst, '}')")
+	if canFail {
+		g.p("return dst, err")
+	} else {
+		g.p("return dst, nil")
+	}
+	g.p("}")
+}
+
+// appendValue дописывает значение expr; depth различает переменные вложенных циклов
+func (g *generator) appendValue(t *goType, expr string, depth int) {
+	switch t.kind {
+	case kindString:
+		g.p("dst = jsonfast.AppendString(dst, %s)", expr)
+	case kindBool:
+		g.imports["strconv"] = true
+		g.p("dst = strconv.AppendBool(dst, %s)", expr)
+	case kindInt:
+		g.imports["strconv"] = true
+		g.p("dst = strconv.AppendInt(dst, int64(%s), 10)", expr)
+	case kindUint64:
+		g.imports["strconv"] = true
+		g.p("dst = strconv.AppendUint(dst, %s, 10)", expr)
+	case kindFloat:
+		g.p("if dst, err = jsonfast.AppendFloat(dst, %s); err != nil {", expr)
+		g.p("return dst, err")
+		g.p("}")
+	case kindTime:
+		g.p("if dst, err = jsonfast.AppendTime(dst, %s); err != nil {", expr)
+		g.p("return dst, err")
+		g.p("}")
+	case kindStruct:
+		g.p("if dst, err = %s.appendJSON(dst); err != nil {", expr)
+		g.p("return dst, err")
+		g.p("}")
+	case kindMap:
+		i, k, e := fmt.Sprintf("i%d", depth), fmt.Sprintf("k%d", depth), fmt.Sprintf("e%d", depth)
+		g.p("if %s == nil {", expr)
+		g.p(`dst = append(dst, "null"...)`)
+		g.p("} else {")
+		g.p("dst = append(dst, '{')")
+		g.p("for %s, %s := range jsonfast.SortedKeys(%s) {", i, k, expr)
+		g.p("if %s > 0 {", i)
+		g.p("dst = append(dst, ',')")
+		g.p("}")
+		g.p("dst = jsonfast.AppendString(dst, %s)", k)
+		g.p("dst = append(dst, ':')")
+		g.p("%s := %s[%s]", e, expr, k)
+		g.appendValue(t.elem, e, depth+1)
+		g.p("}")
+		g.p("dst = append(dst, '}')")
+		g.p("}")
+	case kindSlice:
+		i := fmt.Sprintf("i%d", depth)
+		g.p("if %s == nil {", expr)
+		g.p(`dst = append(dst, "null"...)`)
+		g.p("} else {")
+		g.p("dst = append(dst, '[')")
+		g.p("for %s := range %s {", i, expr)
+		g.p("if %s > 0 {", i)
+		g.p("dst = append(dst, ',')")
+		g.p("}")
+		g.appendValue(t.elem, expr+"["+i+"]", depth+1)
+		g.p("}")
+		g.p("dst = append(dst, ']')")
+		g.p("}")
+	}
+}
+
+// decodeFunc разборщик структуры
+func (g *generator) decodeFunc(def *structDef) {
+	g.p("")
+	g.p("func (v *%s) decodeJSON(d *jsonfast.Decoder) {", def.name)
+	g.p("if d.Null() || !d.BeginObject(%q) {", g.pkg+"."+def.name)
+	g.p("return")
+	g.p("}")
+	g.p("for d.More('}') {")
+	g.p("switch %s(d.Key()) {", fieldFuncName(def.name))
+	for i, f := range def.fields {
+		g.p("case %d:", i)
+		g.p("d.SetField(%q, %q)", def.name, f.jsonName)
+		g.decodeValue(f.typ, "v."+f.goName, 0)
+	}
+	g.p("default:")
+	g.p("d.Skip()")
+	g.p("}")
+	g.p("}")
+	g.p("}")
+}
+
+// decodeValue разбирает значение в target
+func (g *generator) decodeValue(t *goType, target string, depth int) {
+	read := map[kind]string{
+		kindString: "String", kindBool: "Bool", kindInt: "Int",
+		kindUint64: "Uint64", kindFloat: "Float64", kindTime: "Time",
+	}
+	switch t.kind {
+	case kindStruct:
+		g.p("%s.decodeJSON(d)", target)
+	case kindMap:
+		k, e := fmt.Sprintf("k%d", depth), fmt.Sprintf("e%d", depth)
+		g.p("if d.Null() {")
+		g.p("%s = nil", target)
+		g.p("} else if d.BeginObject(%q) {", t.display(g.pkg))
+		g.p("if %s == nil {", target)
+		g.p("%s = make(%s)", target, t.source())
+		g.p("}")
+		g.p("for d.More('}') {")
+		g.p("%s := d.KeyString()", k)
+		g.p("var %s %s", e, t.elem.source())
+		g.decodeValue(t.elem, e, depth+1)
+		g.p("%s[%s] = %s", target, k, e)
+		g.p("}")
+		g.p("}")
+	case kindSlice:
+		s, e := fmt.Sprintf("s%d", depth), fmt.Sprintf("e%d", depth)
+		g.p("if d.Null() {")
+		g.p("%s = nil", target)
+		g.p("} else if d.BeginArray(%q) {", t.display(g.pkg))
+		// Емкость среза переиспользуется, как в encoding/json
+		g.p("%s := %s[:0]", s, target)
+		g.p("for d.More(']') {")
+		g.p("var %s %s", e, t.elem.source())
+		g.p("%s = append(%s, %s)", s, s, e)
+		g.decodeValue(t.elem, s+"[len("+s+")-1]", depth+1)
+		g.p("}")
+		g.p("if %s == nil {", s)
+		g.p("%s = %s{}", s, t.source())
+		g.p("}")
+		g.p("%s = %s", target, s)
+		g.p("}")
+	default:
+		g.p("if !d.Null() {")
+		g.p("%s = d.%s()", target, read[t.kind])
+		g.p("}")
+	}
+}
+
+// fieldFunc поиск поля по ключу: сначала точное совпадение, затем без учета
+// регистра, как в encoding/json
+func (g *generator) fieldFunc(def *structDef) {
+	name := fieldFuncName(def.name)
+	g.p("")
+	g.p("// %s номер поля %s по ключу JSON; -1 — неизвестный ключ", name, def.name)
+	g.p("func %s(key []byte) int {", name)
+	g.p("switch string(key) {")
+	for i, f := range def.fields {
+		g.p("case %q:", f.jsonName)
+		g.p("return %d", i)
+	}
+	g.p("}")
+	g.p("switch {")
+	for i, f := range def.fields {
+		g.p("case jsonfast.EqualFold(key, %q):", f.jsonName)
+		g.p("return %d", i)
+	}
+	g.p("}")
+	g.p("return -1")
+	g.p("}")
+}
+
+// fieldFuncName имя функции поиска поля: metricField для Metric
+func fieldFuncName(typeName string) string {
+	return strings.ToLower(typeName[:1]) + typeName[1:] + "Field"
+}
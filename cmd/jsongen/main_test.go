@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// modelsDir holds the checked-in generated files; see go:generate in models
+const modelsDir = "../../internal/models"
+
+func TestGenerate_ModelsAreUpToDate(t *testing.T) {
+	types := []string{"Metric", "MetricsBatch", "AnalysisResult", "ValueResult", "BatchStats", "FieldStats"}
+	fast, std, err := Generate(modelsDir, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"metrics_json.go": fast, StubName("metrics_json.go"): std} {
+		got, err := os.ReadFile(filepath.Join(modelsDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is stale, run go generate ./internal/models", name)
+		}
+	}
+}
+
+func TestGenerate_RejectsUnsupportedFields(t *testing.T) {
+	dir := t.TempDir()
+	src := `package sample
+
+type Embedded struct{ A int }
+
+type WithEmbedded struct {
+	Embedded
+}
+
+type WithPointer struct {
+	P *int ` + "`json:\"p\"`" + `
+}
+
+type WithUnknown struct {
+	E Embedded ` + "`json:\"e\"`" + `
+}
+
+type WithString struct {
+	N int ` + "`json:\"n,string\"`" + `
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "sample.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for typ, want := range map[string]string{
+		"WithEmbedded": "embedded fields",
+		"WithPointer":  "unsupported type",
+		"WithUnknown":  "add it to -types",
+		"WithString":   `option "string"`,
+		"Missing":      "not found",
+	} {
+		if _, _, err := Generate(dir, []string{typ}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", typ, want, err)
+		}
+	}
+}
@@ -9,57 +9,138 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"highload-service/internal/accesslog"
+	"highload-service/internal/admin"
+	"highload-service/internal/admission"
 	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/audit"
+	"highload-service/internal/autoscale"
+	"highload-service/internal/availability"
+	"highload-service/internal/backpressure"
 	"highload-service/internal/cache"
+	"highload-service/internal/cadence"
+	"highload-service/internal/clock"
+	"highload-service/internal/config"
+	"highload-service/internal/counters"
+	"highload-service/internal/dedup"
+	"highload-service/internal/devices"
+	"highload-service/internal/devicestate"
+	"highload-service/internal/devicestream"
+	"highload-service/internal/dlq"
+	"highload-service/internal/events"
+	"highload-service/internal/flags"
+	"highload-service/internal/groups"
+	"highload-service/internal/grpcapi"
 	"highload-service/internal/handlers"
+	"highload-service/internal/handover"
+	"highload-service/internal/health"
+	"highload-service/internal/importer"
+	"highload-service/internal/incidents"
+	"highload-service/internal/journal"
+	"highload-service/internal/listeners"
+	"highload-service/internal/loglevel"
 	"highload-service/internal/metrics"
+	"highload-service/internal/middleware"
+	"highload-service/internal/migrations"
+	"highload-service/internal/outbox"
+	"highload-service/internal/profiler"
+	"highload-service/internal/quota"
+	"highload-service/internal/regions"
+	"highload-service/internal/replay"
+	"highload-service/internal/rollup"
+	"highload-service/internal/sampling"
+	"highload-service/internal/scheduler"
+	"highload-service/internal/score"
+	"highload-service/internal/selfmon"
+	"highload-service/internal/sequencer"
+	"highload-service/internal/snooze"
+	"highload-service/internal/watchdog"
 )
 
-// Config содержит конфигурацию сервиса
-type Config struct {
-	ServerAddr     string
-	RedisAddr      string
-	RedisPassword  string
-	RedisDB        int
-	WorkerCount    int
-	BufferSize     int
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	IdleTimeout    time.Duration
-}
-
 func main() {
 	log.Println("Starting Highload Service...")
 	log.Printf("Go version: %s", runtime.Version())
 	log.Printf("NumCPU: %d", runtime.NumCPU())
 
 	// Загружаем конфигурацию
-	cfg := loadConfig()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	loglevel.Set(cfg.LogLevel)
+
+	// Единый источник времени для всех компонентов
+	clk := clock.Real()
+
+	// Реестр устройств: классы для параметров детектора, группы для аналитики групп,
+	// тенанты и группы для маршрутов outbox
+	registry := devices.NewRegistry(cfg.Devices...)
 
 	// Инициализируем анализатор метрик
-	analyzer := analytics.NewAnalyzer(cfg.BufferSize)
+	analyzerOpts := []analytics.Option{analytics.WithClock(clk)}
+	if cfg.DeviceWindows != nil {
+		analyzerOpts = append(analyzerOpts, analytics.WithDeviceWindows(*cfg.DeviceWindows), analytics.WithDeviceClasses(registry))
+		if len(cfg.DeviceWindows.Classes) > 0 {
+			log.Printf("Detector settings for %d device classes", len(cfg.DeviceWindows.Classes))
+		}
+	}
+	if len(cfg.CounterFields) > 0 {
+		analyzerOpts = append(analyzerOpts, analytics.WithCounters(cfg.CounterFields...))
+		log.Printf("Counter fields analyzed as rates: %v", cfg.CounterFields)
+	}
+	analyzerOpts = append(analyzerOpts, analytics.WithShards(cfg.AnalyticsShards))
+	analyzer := analytics.NewAnalyzer(cfg.BufferSize, append(analyzerOpts, analytics.WithDetectorConfig(cfg.Detector))...)
 	analyzer.Start(cfg.WorkerCount)
-	log.Printf("Analytics engine started with %d workers", cfg.WorkerCount)
+	log.Printf("Analytics engine started with %d workers and %d shards", cfg.WorkerCount, analyzer.Shards())
+	metrics.RegisterAnalyzerWorkers(analyzer.Workers)
+	if len(cfg.CounterFields) > 0 {
+		metrics.RegisterCounterResets(analyzer.CounterResets)
+	}
+	if cfg.DeviceWindows != nil {
+		metrics.RegisterDeviceWindows(
+			func() int64 { return analyzer.DeviceWindowStats().Devices },
+			func() int64 { return analyzer.DeviceWindowStats().Bytes },
+			func() int64 { return analyzer.DeviceWindowStats().Overflow },
+			func(reason string) int64 { return analyzer.DeviceWindowStats().Evicted[reason] },
+			analytics.EvictionReasons...)
+		log.Printf("Device windows: up to %d devices and %d MiB, evicting devices idle for %v",
+			cfg.DeviceWindows.MaxDevices, cfg.DeviceWindows.MaxMemory>>20, cfg.DeviceWindows.EvictAfter)
+	}
 
 	// Инициализируем Redis кэш
 	var redisCache *cache.RedisCache
-	var err error
+	if cfg.Encryption != nil {
+		log.Printf("Encryption at rest enabled (primary key %q)", cfg.Encryption.Primary())
+	}
 
 	// Пробуем подключиться к Redis с повторами
 	for i := 0; i < 5; i++ {
-		redisCache, err = cache.NewRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+		redisCache, err = cache.NewRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cache.WithEncryption(cfg.Encryption))
 		if err == nil {
 			log.Printf("Connected to Redis at %s", cfg.RedisAddr)
 			break
@@ -70,24 +151,478 @@ func main() {
 		}
 	}
 
+	// Интерфейс остается nil, если Redis недоступен
+	var metricsCache cache.Cache
 	if err != nil {
 		log.Printf("Warning: Failed to connect to Redis, running without cache: %v", err)
-		redisCache = nil
+	} else {
+		metricsCache = redisCache
+		if cfg.Migrations.Enabled {
+			migrateRedis(redisCache, cfg.Migrations, cfg.NodeID)
+		}
+	}
+
+	// Фоновые задачи останавливаются в начале graceful shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Feature-флаги: значения из конфигурации, переопределения перечитываются из Redis
+	flagSet := flags.New(cfg.Flags)
+	if metricsCache != nil {
+		go flagSet.Watch(bgCtx, metricsCache, cfg.FlagsRedisKey, cfg.FlagsRefreshInterval)
+	}
+
+	// Отложенные устройства: общие для обработчиков и массовых операций
+	// администратора, а с Redis — для всех реплик
+	var snoozeOpts []snooze.Option
+	if metricsCache != nil {
+		snoozeOpts = append(snoozeOpts, snooze.WithStore(redisCache))
+	}
+	snoozes := snooze.New(clk, snoozeOpts...)
+	if metricsCache != nil {
+		go snoozes.Watch(bgCtx, snooze.DefaultRefreshInterval)
 	}
 
 	// Создаем обработчики
-	handler := handlers.NewHandler(analyzer, redisCache)
+	handlerOpts := []handlers.Option{
+		handlers.WithClock(clk),
+		handlers.WithFlags(flagSet),
+		handlers.WithWarmup(int64(cfg.WarmupSamples), cfg.WarmupDuration),
+		handlers.WithSnoozes(snoozes),
+	}
+
+	// Доступность: минуты готовности реплик отмечаются в Redis, поэтому простой
+	// учитывается и после перезапуска; без Redis — только время жизни процесса
+	var availabilityStore availability.Store = cache.NewMemoryCache(clk)
+	if metricsCache != nil {
+		availabilityStore = redisCache
+	}
+	availabilityTracker := availability.New(availabilityStore, cfg.NodeID,
+		availability.WithClock(clk), availability.WithInterval(cfg.AvailabilityInterval))
+	handlerOpts = append(handlerOpts, handlers.WithAvailability(availabilityTracker))
+
+	// Квоты на API-ключ: без Redis счетчики ведутся локально в каждой реплике.
+	// При включенном административном API ограничитель устанавливается всегда,
+	// чтобы квоты можно было включить на лету
+	var limiter *quota.Limiter
+	if cfg.Quota.Enabled() || cfg.AdminToken != "" {
+		var quotaStore quota.Store = cache.NewMemoryCache(clk)
+		if metricsCache != nil {
+			quotaStore = redisCache
+		}
+		limiter = quota.NewLimiter(quotaStore, clk, cfg.Quota)
+		// С middleware quota в цепочке квоты действуют на все маршруты, и прием не считается дважды
+		if !slices.Contains(cfg.Middleware, middleware.NameQuota) {
+			handlerOpts = append(handlerOpts, handlers.WithIngestMiddleware(limiter.Middleware))
+		}
+		if cfg.Quota.Enabled() {
+			log.Printf("Ingestion quotas enabled: daily=%d rolling=%d per %s",
+				cfg.Quota.Daily, cfg.Quota.Rolling, cfg.Quota.RollingWindow)
+		}
+	}
+
+	// Повторы пакетов от шлюзов узнаются по хешу тела на всех репликах
+	if cfg.BatchDedupWindow > 0 {
+		var dedupStore dedup.Locker = cache.NewMemoryCache(clk)
+		if metricsCache != nil {
+			dedupStore = redisCache
+		}
+		handlerOpts = append(handlerOpts, handlers.WithBatchDedup(dedup.New(dedupStore, cfg.BatchDedupWindow, cfg.NodeID)))
+		log.Printf("Duplicate batch detection enabled (window %s)", cfg.BatchDedupWindow)
+	}
+
+	// Скетчи квантилей по минутным интервалам: p99 за любой период без хранения значений
+	if cfg.Quantiles.Resolution > 0 {
+		handlerOpts = append(handlerOpts, handlers.WithQuantiles(analytics.NewQuantiles(cfg.Quantiles)))
+	}
+
+	// Метрики устройств с номером анализируются по порядку; задержанные метрики,
+	// не дождавшиеся пропущенных номеров, выпускаются в фоне после создания обработчика
+	var seq *sequencer.Sequencer
+	if cfg.Sequencing.Mode != sequencer.ModeOff {
+		seq = sequencer.New(cfg.Sequencing, sequencer.WithClock(clk))
+		handlerOpts = append(handlerOpts, handlers.WithSequencer(seq))
+		log.Printf("Metric sequencing enabled: %+v", cfg.Sequencing)
+	}
+
+	// Обычные метрики сохраняются выборочно, аномалии и их соседи — всегда
+	if cfg.Sampling.Enabled() {
+		sampler := sampling.New(cfg.Sampling, sampling.WithClock(clk), sampling.WithDirectory(registry))
+		handlerOpts = append(handlerOpts, handlers.WithSampling(sampler))
+		log.Printf("Raw metric sampling enabled: default %+v, %d tenant policies", cfg.Sampling.Default, len(cfg.Sampling.Tenants))
+	}
+
+	// Устройства сторонних моделей присылают собственный JSON: он переводится в метрики по правилам
+	if cfg.PayloadMappings != nil {
+		handlerOpts = append(handlerOpts, handlers.WithPayloadMappings(cfg.PayloadMappings))
+		log.Printf("Payload mappings for device models: %v", cfg.PayloadMappings.Models())
+	}
+
+	// Маркерная корзина сглаживает всплески пакетов перед анализатором
+	if cfg.Admission.Enabled() {
+		admissionOpts := []admission.Option{admission.WithClock(clk)}
+		scope := "per replica"
+		if cfg.Admission.Shared {
+			// Общая корзина в Redis ограничивает все реплики вместе и переживает перезапуск
+			if metricsCache != nil {
+				admissionOpts = append(admissionOpts, admission.WithStore(redisCache, admission.BucketKey))
+				scope = "shared by all replicas"
+			} else {
+				log.Printf("Warning: Redis is unavailable, ADMISSION_SHARED is ignored and each replica is limited alone")
+			}
+		}
+		handlerOpts = append(handlerOpts, handlers.WithAdmission(admission.New(cfg.Admission, admissionOpts...)))
+		log.Printf("Ingest admission enabled: %.0f metrics/s, burst %d, max wait %s, %s",
+			cfg.Admission.Rate, cfg.Admission.Burst, cfg.Admission.MaxWait, scope)
+	}
+
+	// A/B-сравнение конфигураций детектора на том же потоке метрик.
+	// Конфигурацию B можно продвинуть в основную через /admin/detector/promote
+	var canary *analytics.Canary
+	if cfg.Experiment.Enabled {
+		experiment := analytics.NewExperiment(cfg.Experiment.A, cfg.Experiment.B, cfg.BufferSize, analyzerOpts...)
+		defer experiment.Stop()
+		canary = analytics.NewCanary(analyzer, experiment)
+		handlerOpts = append(handlerOpts, handlers.WithExperiment(experiment))
+		log.Printf("Detector comparison enabled: A=%+v B=%+v", cfg.Experiment.A, cfg.Experiment.B)
+	}
+
+	// Показатели устройств копятся в памяти и сбрасываются в хеши Redis одним конвейером
+	var deviceState *devicestate.Tracker
+	if cfg.DeviceState.Enabled {
+		var stateStore devicestate.Store = cache.NewMemoryCache(clk)
+		if metricsCache != nil {
+			stateStore = redisCache
+		}
+		deviceState = devicestate.New(stateStore, cfg.DeviceState.TTL)
+		go deviceState.Run(bgCtx, cfg.DeviceState.FlushInterval)
+		handlerOpts = append(handlerOpts, handlers.WithDeviceState(deviceState))
+	}
+
+	// Глобальные счетчики: каждая реплика пишет свое слагаемое, итог суммируется при чтении
+	var nodeCounters *counters.Counters
+	if metricsCache != nil {
+		nodeCounters = counters.New(metricsCache, cfg.NodeID)
+		handlerOpts = append(handlerOpts, handlers.WithCounters(nodeCounters))
+	}
+
+	// Шина событий: обработчики приема, учет аномалий и административный API
+	// публикуют события, не зная получателей, а получатели подписываются ниже
+	bus := events.New()
+	handlerOpts = append(handlerOpts, handlers.WithEvents(bus))
+
+	// Outbox: аномалии сначала записываются в поток, затем доставляются получателям
+	var anomalyOutbox *outbox.Outbox
+	trackerOpts := []anomalies.Option{anomalies.WithClock(clk), anomalies.WithNotifier(func(a anomalies.Anomaly) {
+		events.Publish(bus, events.AnomalyDetected, a)
+	})}
+	// Коррелятор объединяет одновременные аномалии разных устройств в инциденты
+	correlator := incidents.New(incidents.WithClock(clk), incidents.WithGap(cfg.IncidentGap),
+		incidents.WithThreshold(cfg.Detector.ZScoreThreshold))
+	events.Subscribe(bus, events.AnomalyDetected, anomalies.LogAlert)
+	events.Subscribe(bus, events.AnomalyDetected, correlator.Observe)
+	if len(cfg.OutboxWebhooks) > 0 {
+		var outboxLog outbox.Log
+		if metricsCache != nil {
+			outboxLog = redisCache
+		} else {
+			log.Printf("Warning: Redis is unavailable, outbox events are kept in memory and lost on restart")
+			outboxLog = cache.NewMemoryCache(clk)
+		}
+		sinks := make([]outbox.Sink, 0, len(cfg.OutboxWebhooks))
+		for name, url := range cfg.OutboxWebhooks {
+			var hookOpts []outbox.WebhookOption
+			if t, ok := cfg.OutboxTemplates[name]; ok {
+				hookOpts = append(hookOpts, outbox.WithTemplate(t))
+			}
+			if cfg.OutboxFormats[name] == outbox.FormatAlertmanager {
+				hookOpts = append(hookOpts, outbox.WithAlertmanager())
+			}
+			sinks = append(sinks, outbox.NewWebhook(name, url, outbox.DefaultWebhookTimeout, hookOpts...))
+		}
+		// Маршруты решают, какие получатели получают какие события; документ в Redis
+		// заменяет OUTBOX_ROUTES без перезапуска
+		sinkNames := make([]string, 0, len(sinks))
+		for _, s := range sinks {
+			sinkNames = append(sinkNames, s.Name())
+		}
+		router, err := outbox.NewRouter(cfg.OutboxRoutes, sinkNames,
+			outbox.WithDirectory(registry), outbox.WithCriticalZScore(cfg.OutboxCriticalZScore))
+		if err != nil {
+			log.Fatalf("Invalid outbox routes: %v", err)
+		}
+		if metricsCache != nil {
+			go router.Watch(bgCtx, metricsCache, cfg.OutboxRoutesRedisKey, cfg.OutboxRoutesRefreshInterval)
+		}
+		anomalyOutbox = outbox.New(outboxLog, cfg.NodeID, sinks, outbox.WithClock(clk),
+			outbox.WithRetryAfter(cfg.OutboxRetryAfter), outbox.WithRouter(router))
+		events.Subscribe(bus, events.AnomalyDetected, anomalyOutbox.Record)
+		go anomalyOutbox.Run(bgCtx)
+		log.Printf("Anomaly outbox enabled, sinks: %v, routing rules: %d", anomalyOutbox.Sinks(), len(cfg.OutboxRoutes.Rules))
+	}
+
+	// Потоки устройств получают новые аномалии и изменения их состояния
+	var streamHub *devicestream.Hub
+	if cfg.StreamAddr != "" {
+		streamHub = devicestream.NewHub(devicestream.NewDeviceConfig(cfg.StreamReportingInterval))
+		events.Subscribe(bus, events.AnomalyDetected, streamHub.NotifyAnomaly)
+		trackerOpts = append(trackerOpts, anomalies.WithTransitions(streamHub.NotifyAnomaly))
+	}
+
+	// Агрегаты 1m/5m/1h для графиков: с Redis общие интервалы всех реплик сливаются
+	// в хеши и переживают перезапуск, иначе хранятся в памяти реплики
+	series := rollup.New()
+	if metricsCache != nil && cfg.RollupFlushInterval > 0 {
+		series = rollup.NewPersistent(redisCache)
+		go series.Run(bgCtx, cfg.RollupFlushInterval)
+	}
+
+	// Результаты с аномалией закрепляются за незакрытой аномалией и после ее
+	// закрытия хранятся ANOMALY_RESULT_TTL
+	handlerOpts = append(handlerOpts, handlers.WithResultTTL(cfg.ResultTTL))
+	if metricsCache != nil {
+		trackerOpts = append(trackerOpts, anomalies.WithUnpin(func(keys []string) {
+			if err := redisCache.ExpireKeys(keys, cfg.ResultTTL.Anomaly); err != nil {
+				log.Printf("Failed to unpin %d analysis results: %v", len(keys), err)
+			}
+		}))
+	}
+
+	// Учет подтверждения аномалий
+	anomalyTracker := anomalies.NewTracker(trackerOpts...)
+	// События аномалий с объяснениями: с Redis общий поток всех реплик, иначе память реплики
+	if cfg.AnomalyEventsMaxLen > 0 {
+		var eventLog anomalies.EventLog = cache.NewMemoryCache(clk)
+		if metricsCache != nil {
+			eventLog = redisCache
+		}
+		handlerOpts = append(handlerOpts, handlers.WithAnomalyEvents(
+			anomalies.NewEvents(eventLog, anomalies.WithEventMaxLen(int64(cfg.AnomalyEventsMaxLen)))))
+	}
+	handlerOpts = append(handlerOpts,
+		handlers.WithRollup(series),
+		handlers.WithAnomalies(anomalyTracker),
+		handlers.WithIncidents(correlator),
+		handlers.WithRegions(regions.New(cfg.Detector.WindowSize, regions.WithClock(clk))),
+		handlers.WithScorer(score.New(score.WithClock(clk), score.WithWindow(cfg.ScoreWindow),
+			score.WithExpectedInterval(cfg.ScoreExpectedInterval))),
+	)
+	if cfg.Cadence.Enabled {
+		handlerOpts = append(handlerOpts, handlers.WithCadence(
+			cadence.New(cfg.Cadence, cadence.WithClock(clk), cadence.WithDirectory(registry))))
+	}
+
+	// Распределение входящих значений для тепловых карт
+	valueHistograms, err := metrics.NewValueHistograms(prometheus.DefaultRegisterer, cfg.Histograms)
+	if err != nil {
+		log.Fatalf("Failed to create value histograms: %v", err)
+	}
+	handlerOpts = append(handlerOpts, handlers.WithValueHistograms(valueHistograms))
+
+	// Метрики по устройствам для небольших парков; лишние устройства попадают в "other"
+	if cfg.DeviceMetrics.Enabled {
+		deviceMetrics, err := metrics.NewDeviceMetrics(prometheus.DefaultRegisterer, cfg.DeviceMetrics)
+		if err != nil {
+			log.Fatalf("Failed to create device metrics: %v", err)
+		}
+		events.Subscribe(bus, events.MetricIngested, func(e events.Ingested) {
+			deviceMetrics.Observe(e.Metric.DeviceID, e.Result.ZScoreCPU, e.Result.ZScoreRPS, e.Result.AnomalyDetected)
+		})
+		log.Printf("Per-device metrics enabled for up to %d devices", cfg.DeviceMetrics.MaxDevices)
+	}
+
+	// Аналитика групп устройств из реестра; с административным API реестр может
+	// заполняться массовыми операциями и при пустом DEVICE_REGISTRY
+	if len(cfg.Devices) > 0 || cfg.AdminToken != "" {
+		groupAnalytics := groups.New(registry, cfg.Detector, groups.WithClock(clk), groups.WithAlerts(anomalyTracker))
+		handlerOpts = append(handlerOpts, handlers.WithGroups(groupAnalytics))
+		log.Printf("Device registry: %d devices in %d groups", len(cfg.Devices), len(registry.Groups()))
+	}
+
+	// Фоновые задачи по расписанию; общие задачи выполняет только лидер
+	schedOpts := []scheduler.Option{scheduler.WithClock(clk), scheduler.WithJitter(cfg.SchedulerJitter)}
+	if metricsCache != nil {
+		schedOpts = append(schedOpts, scheduler.WithLeaderElection(redisCache, cfg.NodeID, cfg.SchedulerLeaderTTL))
+	}
+	sched := scheduler.New(schedOpts...)
+	sched.RegisterLocal("anomalies.prune", func(context.Context) error {
+		if n := anomalyTracker.Prune(24 * time.Hour); n > 0 {
+			log.Printf("Pruned %d resolved anomalies", n)
+		}
+		return nil
+	})
+	// Отдельные ключи metric:<ts> закончившихся часов сжимаются в почасовые блоки
+	sched.Register("metrics.compact", func(context.Context) error {
+		if metricsCache == nil {
+			return nil
+		}
+		stats, err := redisCache.CompactMetrics(clk.Now().Add(-cache.CompactionGrace))
+		if stats.Keys > 0 {
+			log.Printf("Compacted %d metric keys into %d hourly blobs", stats.Keys, stats.Hours)
+		}
+		if stats.Unreadable > 0 {
+			log.Printf("Kept %d metric keys that could not be decrypted or decoded; check ENCRYPTION_KEYS", stats.Unreadable)
+		}
+		return err
+	})
+	sched.Register("stats.report", func(context.Context) error {
+		return reportStats(nodeCounters, availabilityTracker)
+	})
+	if err := sched.ScheduleAll(cfg.Schedule); err != nil {
+		log.Fatalf("Invalid schedule: %v", err)
+	}
+	go sched.Run(bgCtx)
+
+	// Очередь недоставленных метрик: файл DLQ_FILE, иначе поток Redis
+	var deadLetterLog dlq.Log
+	switch {
+	case cfg.DLQFile != "":
+		fileLog, err := dlq.OpenFileLog(cfg.DLQFile, clk, dlq.WithFileEncryption(cfg.Encryption))
+		if err != nil {
+			log.Fatalf("Failed to open dead-letter file: %v", err)
+		}
+		deadLetterLog = fileLog
+	case metricsCache != nil:
+		deadLetterLog = redisCache
+	default:
+		log.Printf("Warning: Redis is unavailable and DLQ_FILE is not set, dead letters are kept in memory")
+		deadLetterLog = cache.NewMemoryCache(clk)
+	}
+	deadLetters := dlq.New(deadLetterLog, dlq.WithClock(clk))
+	handlerOpts = append(handlerOpts, handlers.WithDeadLetters(deadLetters))
+
+	// Журнал результатов анализа: клиенты GET /journal продолжают чтение с курсора
+	var journalLog journal.Log
+	switch cfg.Journal.Backend {
+	case "file":
+		segments, err := journal.OpenSegmentLog(cfg.Journal.Dir, cfg.Journal.SegmentSize, clk, journal.WithSegmentEncryption(cfg.Encryption))
+		if err != nil {
+			log.Fatalf("Failed to open results journal: %v", err)
+		}
+		defer segments.Close()
+		journalLog = segments
+	case "redis":
+		if metricsCache != nil {
+			journalLog = redisCache
+		} else {
+			log.Printf("Warning: Redis is unavailable, results journal is disabled")
+		}
+	}
+	var results *journal.Journal
+	if journalLog != nil {
+		results = journal.New(journalLog, journal.WithMaxLen(int64(cfg.Journal.MaxLen)))
+		handlerOpts = append(handlerOpts, handlers.WithJournal(results))
+		log.Printf("Results journal enabled (%s, max %d entries)", cfg.Journal.Backend, cfg.Journal.MaxLen)
+	}
+
+	// Самонаблюдение: CPU, память и горутины сервиса проходят через детектор
+	// как псевдоустройства, и их аномалии оповещаются так же, как аномалии устройств
+	if cfg.SelfMonitor.Enabled {
+		monitorOpts := []selfmon.Option{selfmon.WithClock(clk), selfmon.WithRecorder(anomalyTracker.Record)}
+		if results != nil {
+			monitorOpts = append(monitorOpts, selfmon.WithRecorder(results.Append))
+		}
+		go selfmon.New(cfg.SelfMonitor, cfg.Detector, monitorOpts...).Run(bgCtx)
+		log.Printf("Self-monitoring enabled every %s as %s:{cpu,rss,goroutines}", cfg.SelfMonitor.Interval, cfg.SelfMonitor.Device)
+	}
+
+	// Сторож перезапускает упавшие и зависшие воркеры анализатора
+	if cfg.Watchdog.Enabled {
+		go watchdog.New(cfg.Watchdog, analyzer, watchdog.WithClock(clk)).Run(bgCtx)
+		log.Printf("Worker watchdog enabled: interval %s, stuck after %s, goroutine slack %d",
+			cfg.Watchdog.Interval, cfg.Watchdog.StuckAfter, cfg.Watchdog.GoroutineSlack)
+	}
+
+	// Количество воркеров подбирается по очереди и загрузке в пределах WORKER_MIN..WORKER_MAX
+	var scaler *autoscale.Scaler
+	if cfg.Autoscale.Enabled {
+		scaler = autoscale.New(cfg.Autoscale, analyzer, autoscale.WithClock(clk))
+		go scaler.Run(bgCtx)
+		log.Printf("Worker autoscaling enabled: %d..%d workers, checked every %s",
+			cfg.Autoscale.Min, cfg.Autoscale.Max, cfg.Autoscale.Interval)
+	}
+
+	// Сводный статус /health: кроме хранилища учитываются очередь анализатора и получатели outbox
+	healthChecks := []health.Check{health.Saturation("analyzer_queue", cfg.Health, func() (int, int) {
+		return analyzer.QueueLength(), analyzer.QueueCapacity()
+	})}
+	if anomalyOutbox != nil {
+		healthChecks = append(healthChecks, health.SinkFailures(anomalyOutbox.Failures))
+	}
+	handlerOpts = append(handlerOpts, handlers.WithHealthChecks(healthChecks...))
+
+	// HTTP-прием отвечает 429, а потоки устройств перестают читать сообщения,
+	// пока очередь анализатора переполнена
+	metrics.RegisterAnalyzerQueue(analyzer.QueueLength, analyzer.QueueCapacity)
+	gate := backpressure.New(cfg.Backpressure, []backpressure.Source{{
+		Name:  "analyzer_queue",
+		Level: func() (int, int) { return analyzer.QueueLength(), analyzer.QueueCapacity() },
+	}})
+	handlerOpts = append(handlerOpts, handlers.WithBackpressure(gate, cfg.BackpressureRetryAfter))
+
+	handler := handlers.NewHandler(analyzer, metricsCache, handlerOpts...)
+	go availabilityTracker.Run(bgCtx, handler.Ready)
+	if seq != nil {
+		go seq.Run(bgCtx, handler.AnalyzeReleased)
+	}
 
 	// Настраиваем маршруты
 	router := mux.NewRouter()
 
 	// API эндпоинты
-	router.HandleFunc("/metrics", handler.MetricsHandler).Methods("POST")
-	router.HandleFunc("/metrics/batch", handler.BatchMetricsHandler).Methods("POST")
-	router.HandleFunc("/metrics/latest", handler.LatestMetricsHandler).Methods("GET")
-	router.HandleFunc("/analyze", handler.AnalyzeHandler).Methods("GET")
-	router.HandleFunc("/health", handler.HealthHandler).Methods("GET")
-	router.HandleFunc("/stats", handler.StatsHandler).Methods("GET")
+	handler.RegisterRoutes(router)
+
+	// Административный API с журналом аудита
+	if cfg.AdminToken != "" {
+		auditOut := io.Writer(os.Stdout)
+		if cfg.AuditLogOutput != accesslog.Stdout {
+			auditFile, err := accesslog.NewRotatingFile(cfg.AuditLogOutput, 0, 0)
+			if err != nil {
+				log.Fatalf("Failed to open audit log: %v", err)
+			}
+			defer auditFile.Close()
+			auditOut = auditFile
+		}
+		auditLog := audit.New(auditOut, clk, audit.DefaultCapacity, audit.WithSubscriber(func(e audit.Event) {
+			events.Publish(bus, events.ConfigChanged, e)
+		}))
+		events.Subscribe(bus, events.ConfigChanged, func(e audit.Event) {
+			metrics.AdminChanges.WithLabelValues(e.Action).Inc()
+		})
+		adminOpts := []admin.Option{admin.WithRateLimiter(limiter), admin.WithFlags(flagSet)}
+		if canary != nil {
+			adminOpts = append(adminOpts, admin.WithDetectorRollout(canary))
+		}
+		if metricsCache != nil {
+			adminOpts = append(adminOpts, admin.WithReplay(replay.New(metricsCache, metricsCache,
+				replay.WithClock(clk), replay.WithDetector(cfg.Detector))))
+		}
+		if metricsCache != nil && cfg.ImportSQL.DSN != "" {
+			// Соединение открывается при первом импорте
+			importDB, err := sql.Open(cfg.ImportSQL.Driver, cfg.ImportSQL.DSN)
+			if err != nil {
+				log.Fatalf("Failed to open import source: %v", err)
+			}
+			defer importDB.Close()
+			adminOpts = append(adminOpts, admin.WithImporter(importer.New(importDB, cfg.ImportSQL.Query, redisCache,
+				importer.WithClock(clk), importer.WithRetroAnalysis(metricsCache, cfg.Detector))))
+			log.Printf("SQL import enabled (%s)", cfg.ImportSQL.Driver)
+		}
+		if anomalyOutbox != nil {
+			adminOpts = append(adminOpts, admin.WithOutbox(anomalyOutbox))
+		}
+		adminOpts = append(adminOpts, admin.WithDeadLetters(deadLetters, handler.Reprocess))
+		if streamHub != nil {
+			adminOpts = append(adminOpts, admin.WithDeviceStreams(streamHub))
+		}
+		adminOpts = append(adminOpts, admin.WithWindows(analyzer))
+		adminOpts = append(adminOpts, admin.WithDevices(registry, snoozes))
+		if scaler != nil {
+			adminOpts = append(adminOpts, admin.WithWorkerScaler(scaler))
+		}
+		admin.NewHandler(cfg.AdminToken, analyzer, auditLog, adminOpts...).RegisterRoutes(router)
+		log.Printf("Admin API enabled, audit log: %s", cfg.AuditLogOutput)
+	}
 
 	// Prometheus метрики
 	router.Handle("/prometheus", promhttp.Handler())
@@ -95,117 +630,361 @@ func main() {
 	// pprof для профилирования
 	router.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 
-	// Middleware для логирования и метрик
-	router.Use(loggingMiddleware)
-	router.Use(metricsMiddleware)
+	// Middleware собираются в порядке MIDDLEWARE; фабрика вызывается только для
+	// включенных, nil — middleware выключено своими настройками
+	var accessLog *accesslog.Logger
+	factories := map[string]middleware.Factory{
+		middleware.NameTracing: func() (func(http.Handler) http.Handler, error) {
+			return middleware.Tracing, nil
+		},
+		middleware.NameAccessLog: func() (func(http.Handler) http.Handler, error) {
+			if !cfg.AccessLog.Enabled {
+				return nil, nil
+			}
+			var err error
+			if accessLog, err = accesslog.New(cfg.AccessLog); err != nil {
+				return nil, fmt.Errorf("failed to open access log: %w", err)
+			}
+			log.Printf("Access log (%s) enabled: %s", cfg.AccessLog.Format, cfg.AccessLog.Output)
+			return middleware.AccessLog(accessLog), nil
+		},
+		middleware.NameLogging: func() (func(http.Handler) http.Handler, error) {
+			return middleware.RequestLogging(cfg.Logging), nil
+		},
+		// Автоматическое снятие профилей при всплесках задержки или росте очереди
+		middleware.NameProfiler: func() (func(http.Handler) http.Handler, error) {
+			if !cfg.Profiler.Enabled {
+				return nil, nil
+			}
+			store, err := profiler.NewStore(cfg.Profiler)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize profile store: %w", err)
+			}
+			watchdog := profiler.New(cfg.Profiler, store, analyzer, profiler.WithClock(clk))
+			go watchdog.Run(bgCtx)
+			log.Printf("Profile capture enabled: p99>=%s queue>=%d -> %s",
+				cfg.Profiler.P99Threshold, cfg.Profiler.QueueThreshold, cfg.Profiler.Output)
+			return watchdog.Middleware, nil
+		},
+		middleware.NameMetrics: func() (func(http.Handler) http.Handler, error) {
+			return metricsMiddleware, nil
+		},
+		middleware.NameCORS: func() (func(http.Handler) http.Handler, error) {
+			// Preflight не совпадает с методами маршрутов: без этого маршрута
+			// маршрутизатор ответил бы 405, не вызывая middleware
+			router.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			log.Printf("CORS enabled for origins %v", cfg.CORS.AllowedOrigins)
+			return middleware.CORS(cfg.CORS), nil
+		},
+		middleware.NameAuth: func() (func(http.Handler) http.Handler, error) {
+			log.Printf("API key authentication enabled (%d keys), exempt paths: %v", len(cfg.APIKeys), cfg.AuthExemptPaths)
+			return middleware.APIKeyAuth(cfg.APIKeys, cfg.AuthExemptPaths), nil
+		},
+		middleware.NameQuota: func() (func(http.Handler) http.Handler, error) {
+			if limiter == nil {
+				return nil, nil
+			}
+			return limiter.Middleware, nil
+		},
+		middleware.NameConcurrency: func() (func(http.Handler) http.Handler, error) {
+			if len(cfg.ConcurrencyLimits) == 0 {
+				return nil, nil
+			}
+			log.Printf("Concurrency limits: %v", cfg.ConcurrencyLimits)
+			return middleware.ConcurrencyLimit(cfg.ConcurrencyLimits, cfg.ConcurrencyRetryAfter), nil
+		},
+		middleware.NameCompression: func() (func(http.Handler) http.Handler, error) {
+			return middleware.Compress, nil
+		},
+	}
+	chain, chainNames, err := middleware.Build(cfg.Middleware, factories)
+	if err != nil {
+		log.Fatalf("Failed to build middleware chain: %v", err)
+	}
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
+	for _, mw := range chain {
+		router.Use(mw)
+	}
+	log.Printf("Middleware chain: %s", strings.Join(chainNames, " -> "))
+
+	// Сокеты, унаследованные от предыдущего процесса (SIGUSR2) или systemd;
+	// без имен они сопоставляются с точками приема по порядку
+	listenerNames := make([]string, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		listenerNames[i] = l.Name
+	}
+	inherited, err := handover.Inherited(listenerNames...)
+	if err != nil {
+		log.Fatalf("Invalid inherited sockets: %v", err)
+	}
+	stateChannel := inherited[handover.StateFD]
+	delete(inherited, handover.StateFD)
+	for name, f := range inherited {
+		if !slices.Contains(listenerNames, name) {
+			log.Printf("Closing inherited socket %q: no listener with this name", name)
+			f.Close()
+			delete(inherited, name)
+		}
+	}
 
-	// Создаем HTTP сервер с настройками таймаутов
-	server := &http.Server{
-		Addr:         cfg.ServerAddr,
-		Handler:      router,
-		ReadTimeout:  cfg.ReadTimeout,
-		WriteTimeout: cfg.WriteTimeout,
-		IdleTimeout:  cfg.IdleTimeout,
+	// Открываем точки приема (TCP, TLS, unix-сокеты) с собственными таймаутами
+	servers, err := listeners.Listen(cfg.Listeners, router, listeners.WithInherited(inherited))
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	if len(inherited) > 0 {
+		log.Printf("Accepting on %d inherited sockets", len(inherited))
+	}
+	if stateChannel != nil {
+		// Окна приходят, когда предыдущий процесс перестал принимать метрики и
+		// доработал очередь; до этого соединения ждут в очереди сокетов
+		restoreWindows(analyzer, stateChannel, cfg.HandoverTimeout)
+	}
+
+	// Двунаправленные gRPC-потоки устройств
+	var streamServer *grpc.Server
+	if streamHub != nil {
+		lis, err := net.Listen("tcp", cfg.StreamAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for device streams: %v", err)
+		}
+		streamServer = grpc.NewServer(grpc.ForceServerCodec(devicestream.Codec{}))
+		devicestream.NewServer(streamHub, handler, anomalyTracker, devicestream.WithThrottle(gate)).Register(streamServer)
+		go func() {
+			log.Printf("Device streams listening on %s", lis.Addr())
+			if err := streamServer.Serve(lis); err != nil {
+				log.Fatalf("Device stream server error: %v", err)
+			}
+		}()
+	}
+
+	// gRPC-сервис приема и запросов для серверных производителей
+	var apiServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC API: %v", err)
+		}
+		apiOpts := []grpcapi.ServerOption{grpcapi.WithThrottle(gate)}
+		if nodeCounters != nil {
+			apiOpts = append(apiOpts, grpcapi.WithTotals(nodeCounters))
+		}
+		if metricsCache != nil {
+			apiOpts = append(apiOpts, grpcapi.WithLatest(metricsCache))
+		}
+		serverOpts := []grpc.ServerOption{grpc.ForceServerCodec(grpcapi.Codec{})}
+		// Порт API обходит middleware HTTP, поэтому ключи и TLS проверяются на нем самом
+		if slices.Contains(cfg.Middleware, middleware.NameAuth) {
+			serverOpts = append(serverOpts, grpc.UnaryInterceptor(grpcapi.APIKeyInterceptor(cfg.APIKeys)))
+		}
+		if cfg.GRPCTLSCert != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.GRPCTLSCert, cfg.GRPCTLSKey)
+			if err != nil {
+				log.Fatalf("Failed to load gRPC API TLS certificate: %v", err)
+			}
+			serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})))
+		}
+		apiServer = grpc.NewServer(serverOpts...)
+		grpcapi.NewServer(handler, analyzer, apiOpts...).Register(apiServer)
+		go func() {
+			log.Printf("gRPC API (%s) listening on %s", grpcapi.ServiceName, lis.Addr())
+			if err := apiServer.Serve(lis); err != nil {
+				log.Fatalf("gRPC API server error: %v", err)
+			}
+		}()
 	}
 
 	// Запускаем горутину для обновления метрик
 	go updateMetricsLoop(analyzer)
 
 	// Запускаем горутину для обработки результатов анализа
-	go processAnalysisResults(analyzer, redisCache)
+	resultsDone := make(chan struct{})
+	go func() {
+		defer close(resultsDone)
+		processAnalysisResults(analyzer, handler)
+	}()
 
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	if cfg.HandoverEnabled {
+		signal.Notify(stop, syscall.SIGUSR2)
+	}
 
 	// Запускаем сервер в горутине
 	go func() {
-		log.Printf("Server listening on %s", cfg.ServerAddr)
 		log.Printf("Endpoints:")
 		log.Printf("  POST /metrics       - Submit metric data")
 		log.Printf("  POST /metrics/batch - Submit batch metrics")
+		log.Printf("  POST /metrics/stream - Stream NDJSON metrics over one connection")
 		log.Printf("  GET  /metrics/latest- Get latest metrics")
 		log.Printf("  GET  /analyze       - Get analysis statistics")
+		log.Printf("  GET  /analyze/compare - A/B detector comparison")
 		log.Printf("  GET  /health        - Health check")
+		log.Printf("  GET  /readyz        - Readiness (analyzer warmup)")
 		log.Printf("  GET  /stats         - Service statistics")
+		log.Printf("  GET  /series        - Downsampled chart series")
+		log.Printf("  GET  /forecast      - Holt-Winters CPU/RPS forecast")
+		log.Printf("  GET  /query         - Query expressions over rollups")
+		log.Printf("  GET  /anomalies     - Anomalies (POST /anomalies/{id}/ack|resolve)")
+		log.Printf("  GET  /incidents     - Correlated anomaly incidents (GET /incidents/{id})")
+		log.Printf("  GET  /groups        - Device groups (GET /groups/{id}/stats)")
+		log.Printf("  GET  /regions       - Per-region aggregates")
+		log.Printf("  GET  /score         - Composite health score per device and fleet")
+		log.Printf("  GET  /cadence       - Reporting interval percentiles and cadence SLA violators")
+		log.Printf("  GET  /journal       - Analysis results after a cursor (SSE with Accept: text/event-stream)")
+		log.Printf("  GET  /openapi.json  - OpenAPI specification")
 		log.Printf("  GET  /prometheus    - Prometheus metrics")
+		if cfg.AdminToken != "" {
+			log.Printf("  GET|PATCH /admin/runtime - Runtime tunables (admin token)")
+		}
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := servers.Serve(); err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
-	// Ожидаем сигнал завершения
-	<-stop
-	log.Println("Shutting down server...")
+	// Ожидаем сигнал завершения или передачи новому процессу
+	successor := awaitShutdown(stop, servers, cfg.HandoverTimeout)
+	log.Printf("Shutting down server (drain budget %s)...", cfg.DrainTimeout)
 
-	// Контекст с таймаутом для завершения
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Общий бюджет на остановку всех компонентов
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
 	defer cancel()
 
-	// Останавливаем анализатор
-	analyzer.Stop()
-
-	// Закрываем Redis
-	if redisCache != nil {
-		redisCache.Close()
+	// 1. Прекращаем прием новых метрик. При передаче сокетов запросы на уже
+	// открытых соединениях принимаются: после ответа соединение закрывается,
+	// и клиент переподключается к новому процессу без 503
+	stopBackground()
+	if err := availabilityTracker.Stop(); err != nil {
+		log.Printf("Availability: %v", err)
+	}
+	if successor == nil {
+		handler.StartDraining()
 	}
 
-	// Завершаем HTTP сервер
-	if err := server.Shutdown(ctx); err != nil {
+	// 2. Закрываем все точки приема и дожидаемся завершения текущих запросов
+	if err := servers.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
+	if apiServer != nil {
+		// Унарные вызовы короткие: дожидаемся их, пока позволяет бюджет остановки
+		stopped := make(chan struct{})
+		go func() {
+			apiServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			apiServer.Stop()
+		}
+	}
+	handler.StartDraining()
+	if streamServer != nil {
+		// Потоки устройств бесконечны: закрываем их, чтобы устройства переподключились к другим экземплярам
+		streamHub.Close()
+		stopped := make(chan struct{})
+		go func() {
+			streamServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			streamServer.Stop()
+		}
+	}
 
-	log.Println("Server stopped")
-}
+	// 3. Обрабатываем очередь анализатора
+	report := analyzer.Drain(ctx)
+	if successor != nil {
+		// Новый процесс ждет окна, чтобы начать прием
+		windows := analyzer.ExportWindows()
+		if err := successor.Send(handover.State{Windows: windows}); err != nil {
+			log.Printf("Handover failed: %v", err)
+		} else {
+			log.Printf("Handed %d analysis windows over to process %d", len(windows), successor.Pid())
+		}
+	}
 
-// loadConfig загружает конфигурацию из переменных окружения
-func loadConfig() Config {
-	return Config{
-		ServerAddr:     getEnv("SERVER_ADDR", ":8080"),
-		RedisAddr:      getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:  getEnv("REDIS_PASSWORD", ""),
-		RedisDB:        getEnvInt("REDIS_DB", 0),
-		WorkerCount:    getEnvInt("WORKER_COUNT", runtime.NumCPU()),
-		BufferSize:     getEnvInt("BUFFER_SIZE", 10000),
-		ReadTimeout:    15 * time.Second,
-		WriteTimeout:   15 * time.Second,
-		IdleTimeout:    60 * time.Second,
+	// 4. Дожидаемся, пока обработчик результатов запишет все в Redis
+	select {
+	case <-resultsDone:
+	case <-ctx.Done():
+		log.Printf("Drain budget exceeded before analysis results were flushed")
+	}
+
+	log.Printf("Drain report: processed=%d dropped_queued=%d rejected=%d dropped_results=%d",
+		report.Processed, report.DroppedQueued, report.Rejected, report.DroppedResults)
+
+	// Показатели устройств и агрегаты из запросов, завершившихся после остановки фоновых задач
+	if deviceState != nil {
+		if err := deviceState.Flush(); err != nil {
+			log.Printf("Failed to flush device state: %v", err)
+		}
+	}
+	if err := series.Flush(); err != nil {
+		log.Printf("Failed to flush rollups: %v", err)
 	}
-}
 
-// getEnv получает переменную окружения с значением по умолчанию
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	// 5. Закрываем Redis
+	if metricsCache != nil {
+		metricsCache.Close()
 	}
-	return defaultValue
+
+	log.Println("Server stopped")
 }
 
-// getEnvInt получает целочисленную переменную окружения
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		var result int
-		_, err := time.ParseDuration(value)
-		if err == nil {
-			return defaultValue
+// awaitShutdown ждет сигнала остановки. По SIGUSR2 запускается новый экземпляр
+// с сокетами этого процесса; когда он готов, процесс останавливается, как по
+// SIGTERM, и возвращает нового, которому нужно передать окна. Если новый
+// экземпляр не запустился, процесс продолжает работу
+func awaitShutdown(stop <-chan os.Signal, servers *listeners.Group, timeout time.Duration) *handover.Successor {
+	for sig := range stop {
+		if sig != syscall.SIGUSR2 {
+			return nil
 		}
-		n, _ := time.ParseDuration("0")
-		result = int(n.Seconds())
-		if result > 0 {
-			return result
+		files, names, err := servers.Files()
+		if err != nil {
+			log.Printf("Handover failed, continuing to serve: %v", err)
+			continue
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		successor, err := handover.Start(ctx, files, names)
+		cancel()
+		for _, f := range files {
+			f.Close()
+		}
+		if err != nil {
+			log.Printf("Handover failed, continuing to serve: %v", err)
+			continue
+		}
+		log.Printf("Process %d is ready, handing sockets over", successor.Pid())
+		return successor
 	}
-	return defaultValue
+	return nil
 }
 
-// loggingMiddleware логирует HTTP запросы
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
-	})
+// restoreWindows заполняет окна анализатора окнами предыдущего процесса
+func restoreWindows(analyzer *analytics.Analyzer, channel *os.File, timeout time.Duration) {
+	state, err := handover.Receive(channel, timeout)
+	if err != nil {
+		log.Printf("Starting with empty windows, handover state not received: %v", err)
+		return
+	}
+	restored, err := analyzer.ImportWindows(state.Windows)
+	if err != nil {
+		log.Printf("Some handed over windows were not restored: %v", err)
+	}
+	if restored > 0 {
+		analyzer.MarkRestored()
+	}
+	log.Printf("Restored %d analysis windows from the previous process", restored)
 }
 
 // metricsMiddleware обновляет метрики для каждого запроса
@@ -222,23 +1001,62 @@ func updateMetricsLoop(analyzer *analytics.Analyzer) {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		avgCPU, avgRPS, _, _ := analyzer.GetStats()
+		avgCPU, avgRPS, _, _ := analyzer.GetStats("")
 		metrics.RollingAvgCPU.Set(avgCPU)
 		metrics.RollingAvgRPS.Set(avgRPS)
 		metrics.ActiveGoroutines.Set(float64(runtime.NumGoroutine()))
 	}
 }
 
-// processAnalysisResults обрабатывает результаты анализа
-func processAnalysisResults(analyzer *analytics.Analyzer, redisCache *cache.RedisCache) {
-	for result := range analyzer.GetResults() {
-		if result.AnomalyDetected {
-			metrics.AnomaliesDetected.Inc()
-			if redisCache != nil {
-				redisCache.IncrementCounter("anomalies:total")
-			}
+// reportStats пишет в лог глобальные счетчики кластера и доступность сервиса
+func reportStats(nodeCounters *counters.Counters, tracker *availability.Tracker) error {
+	if nodeCounters != nil {
+		total, err := nodeCounters.Total(counters.MetricsTotal)
+		if err != nil {
+			return err
+		}
+		anomaliesTotal, err := nodeCounters.Total(counters.AnomaliesTotal)
+		if err != nil {
+			return err
+		}
+		log.Printf("Stats report: %d metrics, %d anomalies", total, anomaliesTotal)
+	}
+	a, err := tracker.Report()
+	if err != nil {
+		return err
+	}
+	log.Printf("Availability report: today %.3f%% (downtime %s), this month %.3f%% (downtime %s) since %s",
+		a.Daily.Percent, a.Daily.Downtime, a.Monthly.Percent, a.Monthly.Downtime, a.Since.Format(time.RFC3339))
+	return nil
+}
+
+// processAnalysisResults обрабатывает результаты анализа метрик, принятых
+// асинхронно, теми же получателями, что и синхронный прием
+func processAnalysisResults(analyzer *analytics.Analyzer, handler *handlers.Handler) {
+	for analyzed := range analyzer.GetResults() {
+		handler.RecordAsync(analyzed.Metric, analyzed.Result)
+		if result := analyzed.Result; result.AnomalyDetected {
 			log.Printf("Anomaly detected! CPU z-score: %.2f, RPS z-score: %.2f",
 				result.ZScoreCPU, result.ZScoreRPS)
 		}
 	}
 }
+
+// migrateRedis доводит раскладку ключей Redis до версии этой сборки до начала
+// работы с ней. Реплики запускаются параллельно: миграции выполняет одна,
+// остальные ждут ее
+func migrateRedis(redisCache *cache.RedisCache, cfg config.MigrationsConfig, node string) {
+	runner, err := migrations.New("redis", migrations.NewCacheStore(redisCache, migrations.RedisVersionKey),
+		migrations.Redis(redisCache),
+		migrations.WithLock(redisCache, migrations.RedisLockKey, node, cfg.LockTTL))
+	if err != nil {
+		log.Fatalf("Invalid migrations: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	version, err := runner.Run(ctx)
+	if err != nil {
+		log.Fatalf("Failed to migrate Redis: %v", err)
+	}
+	log.Printf("Redis key layout at version %d", version)
+}
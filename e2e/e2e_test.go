@@ -0,0 +1,111 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestE2E_LoadAndObserve(t *testing.T) {
+	before := scrapeMetric(t, "highload_metrics_received_total")
+
+	const total = 2000
+	res := generateLoad(total, 20, 100)
+	t.Logf("Sent %d metrics in %s (%.0f RPS), statuses: %v",
+		res.Sent, res.Elapsed, float64(res.Sent)/res.Elapsed.Seconds(), res.Statuses)
+
+	if res.Failed != 0 {
+		t.Fatalf("Expected no failed requests, got %d (%v)", res.Failed, res.Statuses)
+	}
+
+	after := scrapeMetric(t, "highload_metrics_received_total")
+	if after-before != total {
+		t.Errorf("Expected highload_metrics_received_total to grow by %d, grew by %.0f", total, after-before)
+	}
+	if anomalies := scrapeMetric(t, "highload_anomalies_detected_total"); anomalies == 0 {
+		t.Error("Expected injected CPU spikes to be detected as anomalies")
+	}
+
+	var latest []map[string]interface{}
+	if err := getJSON("/metrics/latest?count=100", &latest); err != nil {
+		t.Fatalf("GET /metrics/latest failed: %v", err)
+	}
+	if len(latest) != 100 {
+		t.Errorf("Expected 100 cached metrics from Redis, got %d", len(latest))
+	}
+
+	var analysis struct {
+		RollingAvg map[string]float64 `json:"rolling_avg"`
+	}
+	if err := getJSON("/analyze", &analysis); err != nil {
+		t.Fatalf("GET /analyze failed: %v", err)
+	}
+	if cpu := analysis.RollingAvg["cpu"]; cpu < 40 || cpu > 70 {
+		t.Errorf("Rolling average CPU %.2f is outside the generated range", cpu)
+	}
+}
+
+func TestE2E_BatchIngestion(t *testing.T) {
+	body := []byte(`{"metrics":[{"cpu":50,"rps":500},{"cpu":51,"rps":505},{"cpu":49,"rps":495}]}`)
+	resp, err := httpClient.Post(baseURL+"/metrics/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /metrics/batch failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Processed int `json:"processed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode batch response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Processed != 3 {
+		t.Errorf("Expected 200 with 3 processed, got %d with %d", resp.StatusCode, out.Processed)
+	}
+}
+
+// getJSON выполняет GET и декодирует JSON-ответ
+func getJSON(path string, dest interface{}) error {
+	resp, err := httpClient.Get(baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// scrapeMetric возвращает значение метрики без меток из /prometheus
+func scrapeMetric(t *testing.T, name string) float64 {
+	t.Helper()
+
+	resp, err := httpClient.Get(baseURL + "/prometheus")
+	if err != nil {
+		t.Fatalf("GET /prometheus failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, name+" ") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(line, name)), 64)
+		if err != nil {
+			t.Fatalf("Bad value for %s: %q", name, line)
+		}
+		return v
+	}
+	t.Fatalf("Metric %s not found", name)
+	return 0
+}
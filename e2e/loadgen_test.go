@@ -0,0 +1,79 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadResult итог прогона генератора нагрузки
+type loadResult struct {
+	Sent     int64
+	Failed   int64
+	Statuses map[int]int64
+	Elapsed  time.Duration
+}
+
+// generateLoad отправляет total метрик на POST /metrics в concurrency потоков.
+// Значения CPU/RPS нормальные, каждая spikeEvery-я метрика - всплеск CPU
+func generateLoad(total, concurrency, spikeEvery int) loadResult {
+	var (
+		sent, failed int64
+		mu           sync.Mutex
+		statuses           = make(map[int]int64)
+		next         int64 = -1
+		wg           sync.WaitGroup
+	)
+
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(worker)))
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= int64(total) {
+					return
+				}
+
+				cpu := 50 + rnd.NormFloat64()*3
+				if spikeEvery > 0 && i%int64(spikeEvery) == 0 {
+					cpu = 99
+				}
+				body, _ := json.Marshal(map[string]interface{}{
+					"cpu":       cpu,
+					"rps":       500 + rnd.NormFloat64()*20,
+					"device_id": fmt.Sprintf("e2e-device-%d", worker),
+				})
+
+				resp, err := httpClient.Post(baseURL+"/metrics", "application/json", bytes.NewReader(body))
+				atomic.AddInt64(&sent, 1)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				mu.Lock()
+				statuses[resp.StatusCode]++
+				mu.Unlock()
+				if resp.StatusCode != http.StatusOK {
+					atomic.AddInt64(&failed, 1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return loadResult{Sent: sent, Failed: failed, Statuses: statuses, Elapsed: time.Since(start)}
+}
@@ -0,0 +1,141 @@
+//go:build e2e
+
+// Package e2e содержит сквозные тесты: настоящий Redis в контейнере
+// (testcontainers-go), собранный бинарник сервера и нагрузка по HTTP.
+//
+// Запуск: make test-e2e (нужен доступный Docker).
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// baseURL адрес запущенного сервера, общий для всех тестов
+var baseURL string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ctx := context.Background()
+
+	redisC, redisAddr, err := startRedis(ctx)
+	if err != nil {
+		log.Printf("e2e: failed to start Redis container: %v", err)
+		return 1
+	}
+	defer redisC.Terminate(ctx)
+
+	stop, err := startServer(redisAddr)
+	if err != nil {
+		log.Printf("e2e: failed to start server: %v", err)
+		return 1
+	}
+	defer stop()
+
+	return m.Run()
+}
+
+// startRedis запускает контейнер Redis и возвращает его адрес
+func startRedis(ctx context.Context) (testcontainers.Container, string, error) {
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:7-alpine",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	endpoint, err := c.PortEndpoint(ctx, "6379/tcp", "")
+	if err != nil {
+		c.Terminate(ctx)
+		return nil, "", err
+	}
+	return c, endpoint, nil
+}
+
+// startServer собирает и запускает бинарник сервера, дожидаясь подключения к Redis
+func startServer(redisAddr string) (func(), error) {
+	dir, err := os.MkdirTemp("", "highload-e2e")
+	if err != nil {
+		return nil, err
+	}
+	binary := filepath.Join(dir, "highload-service")
+
+	build := exec.Command("go", "build", "-o", binary, "../cmd/server")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("build failed: %w", err)
+	}
+
+	addr, err := freeAddr()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	baseURL = "http://" + addr
+
+	server := exec.Command(binary)
+	server.Env = append(os.Environ(), "SERVER_ADDR="+addr, "REDIS_ADDR="+redisAddr)
+	server.Stdout, server.Stderr = os.Stdout, os.Stderr
+	if err := server.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	stop := func() {
+		server.Process.Signal(os.Interrupt)
+		server.Wait()
+		os.RemoveAll(dir)
+	}
+
+	if err := waitHealthy(30 * time.Second); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+// waitHealthy ждет, пока /health сообщит о подключенном Redis
+func waitHealthy(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		var status struct {
+			Redis string `json:"redis"`
+		}
+		if err := getJSON("/health", &status); err == nil && status.Redis == "connected" {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("server at %s did not become healthy within %s", baseURL, timeout)
+}
+
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
@@ -0,0 +1,200 @@
+// Package accesslog реализует журнал доступа: записи о каждом HTTP-запросе
+// в формате JSON или Common Log Format, выводимые отдельно от логов приложения
+// в ротируемый файл или сокет
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format формат записей журнала
+type Format string
+
+const (
+	// FormatJSON одна JSON-запись на строку
+	FormatJSON Format = "json"
+	// FormatCLF Common Log Format (формат Apache/NGINX)
+	FormatCLF Format = "clf"
+)
+
+// Config настройки журнала доступа
+type Config struct {
+	Enabled bool
+	Format  Format
+	// Output stdout, путь к файлу или адрес сокета: unix:///path, udp://host:port, tcp://host:port
+	Output string
+	// MaxSizeMB размер файла, после которого выполняется ротация
+	MaxSizeMB int
+	// MaxBackups количество хранимых ротированных файлов
+	MaxBackups int
+	// BufferSize размер очереди записей; при переполнении записи отбрасываются
+	BufferSize int
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	if c.Format != FormatJSON && c.Format != FormatCLF {
+		return fmt.Errorf("ACCESS_LOG_FORMAT: unknown format %q (want json or clf)", c.Format)
+	}
+	if c.Output == "" {
+		return fmt.Errorf("ACCESS_LOG_OUTPUT: must not be empty")
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("ACCESS_LOG_BUFFER: must be positive")
+	}
+	return nil
+}
+
+// Entry запись о запросе
+type Entry struct {
+	Time         time.Time     `json:"time"`
+	RemoteAddr   string        `json:"remote_addr"`
+	Method       string        `json:"method"`
+	Path         string        `json:"path"`
+	Query        string        `json:"query,omitempty"`
+	Proto        string        `json:"proto"`
+	Status       int           `json:"status"`
+	RequestBytes int64         `json:"request_bytes"`
+	Bytes        int64         `json:"bytes"`
+	Duration     time.Duration `json:"-"`
+	DurationMs   float64       `json:"duration_ms"`
+	UserAgent    string        `json:"user_agent,omitempty"`
+	DeviceID     string        `json:"device_id,omitempty"`
+}
+
+// Logger асинхронно записывает журнал доступа
+type Logger struct {
+	format  Format
+	out     io.WriteCloser
+	entries chan Entry
+	done    chan struct{}
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// New открывает вывод журнала и запускает фоновую запись
+func New(cfg Config) (*Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	out, err := openOutput(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithWriter(cfg.Format, out, cfg.BufferSize), nil
+}
+
+// NewWithWriter создает журнал поверх произвольного writer
+func NewWithWriter(format Format, out io.WriteCloser, bufferSize int) *Logger {
+	l := &Logger{
+		format:  format,
+		out:     out,
+		entries: make(chan Entry, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log ставит запись в очередь. Не блокирует: при переполнении запись отбрасывается
+func (l *Logger) Log(e Entry) {
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped возвращает количество отброшенных записей
+func (l *Logger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close дописывает очередь и закрывает вывод
+func (l *Logger) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.entries)
+		<-l.done
+		err = l.out.Close()
+	})
+	return err
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	var buf []byte
+	failing := false
+	for e := range l.entries {
+		buf = l.appendEntry(buf[:0], e)
+		_, err := l.out.Write(buf)
+		// Логируем только смену состояния, чтобы недоступный приемник не засыпал лог приложения
+		switch {
+		case err != nil && !failing:
+			log.Printf("Access log write failed: %v", err)
+			failing = true
+		case err == nil && failing:
+			log.Printf("Access log writes recovered")
+			failing = false
+		}
+		if err != nil {
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// appendEntry форматирует запись в buf
+func (l *Logger) appendEntry(buf []byte, e Entry) []byte {
+	if l.format == FormatCLF {
+		return appendCLF(buf, e)
+	}
+	e.DurationMs = float64(e.Duration.Microseconds()) / 1000
+	data, err := json.Marshal(e)
+	if err != nil {
+		return buf
+	}
+	return append(append(buf, data...), '\n')
+}
+
+// appendCLF форматирует запись в Common Log Format:
+// host - - [10/Oct/2000:13:55:36 -0700] "GET /path HTTP/1.1" 200 2326
+func appendCLF(buf []byte, e Entry) []byte {
+	host := e.RemoteAddr
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		host = host[:i]
+	}
+	if host == "" {
+		host = "-"
+	}
+	uri := e.Path
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	buf = append(buf, host...)
+	buf = append(buf, " - - ["...)
+	buf = e.Time.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, `] "`...)
+	buf = append(buf, e.Method...)
+	buf = append(buf, ' ')
+	buf = append(buf, uri...)
+	buf = append(buf, ' ')
+	buf = append(buf, e.Proto...)
+	buf = append(buf, `" `...)
+	buf = strconv.AppendInt(buf, int64(e.Status), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, size...)
+	return append(buf, '\n')
+}
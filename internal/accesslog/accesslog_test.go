@@ -0,0 +1,82 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type bufferCloser struct{ bytes.Buffer }
+
+func (b *bufferCloser) Close() error { return nil }
+
+func testEntry() Entry {
+	return Entry{
+		Time:       time.Date(2024, 1, 2, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RemoteAddr: "10.0.0.7:51234",
+		Method:     "POST",
+		Path:       "/metrics",
+		Query:      "async=true",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      231,
+		Duration:   1500 * time.Microsecond,
+		DeviceID:   "sensor-1",
+	}
+}
+
+func TestLogger_CLFFormat(t *testing.T) {
+	out := &bufferCloser{}
+	l := NewWithWriter(FormatCLF, out, 10)
+	l.Log(testEntry())
+	l.Close()
+
+	want := `10.0.0.7 - - [02/Jan/2024:13:55:36 -0700] "POST /metrics?async=true HTTP/1.1" 200 231` + "\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestLogger_JSONFormat(t *testing.T) {
+	out := &bufferCloser{}
+	l := NewWithWriter(FormatJSON, out, 10)
+	l.Log(testEntry())
+	l.Close()
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", out.String(), err)
+	}
+	if got["device_id"] != "sensor-1" || got["duration_ms"] != 1.5 || got["status"] != float64(200) {
+		t.Errorf("Unexpected entry %v", got)
+	}
+}
+
+func TestRotatingFile_RotatesAndKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	expect := map[string]string{path: "dddddddd\n", path + ".1": "cccccccc\n", path + ".2": "bbbbbbbb\n"}
+	for file, want := range expect {
+		data, err := os.ReadFile(file)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", filepath.Base(file), want, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 backups")
+	}
+}
@@ -0,0 +1,151 @@
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stdout значение Output для записи в стандартный вывод
+const Stdout = "stdout"
+
+// openOutput открывает файл или сокет согласно cfg.Output
+func openOutput(cfg Config) (io.WriteCloser, error) {
+	if cfg.Output == Stdout {
+		return nopCloser{os.Stdout}, nil
+	}
+	for _, scheme := range []string{"unix", "udp", "tcp"} {
+		if addr, ok := strings.CutPrefix(cfg.Output, scheme+"://"); ok {
+			return newSocketWriter(scheme, addr), nil
+		}
+	}
+	return NewRotatingFile(cfg.Output, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+}
+
+// nopCloser не закрывает стандартный вывод вместе с журналом
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// RotatingFile файл с ротацией по размеру: path -> path.1 -> ... -> path.N
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile открывает файл для дозаписи. maxSize <= 0 отключает ротацию
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create access log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat access log: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write дописывает данные, выполняя ротацию при превышении размера
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate сдвигает резервные копии и открывает новый файл; вызывается под блокировкой
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups <= 0 {
+		os.Remove(f.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate access log: %w", err)
+		}
+	}
+	return f.open()
+}
+
+// Close закрывает файл
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// socketWriter пишет в сокет, переподключаясь после ошибок
+type socketWriter struct {
+	network, addr string
+	conn          net.Conn
+	nextDial      time.Time
+}
+
+func newSocketWriter(network, addr string) *socketWriter {
+	return &socketWriter{network: network, addr: addr}
+}
+
+// Write отправляет запись; при недоступном приемнике повторное подключение не чаще раза в секунду
+func (s *socketWriter) Write(p []byte) (int, error) {
+	if s.conn == nil {
+		if time.Now().Before(s.nextDial) {
+			return 0, fmt.Errorf("access log socket %s://%s unavailable", s.network, s.addr)
+		}
+		conn, err := net.DialTimeout(s.network, s.addr, time.Second)
+		if err != nil {
+			s.nextDial = time.Now().Add(time.Second)
+			return 0, err
+		}
+		s.conn = conn
+	}
+
+	n, err := s.conn.Write(p)
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return n, err
+}
+
+// Close закрывает соединение
+func (s *socketWriter) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,841 @@
+// Package admin реализует административный API для изменения настроек
+// работающего сервиса. Все эндпоинты требуют токен администратора,
+// а каждое изменение записывается в журнал аудита
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/audit"
+	"highload-service/internal/autoscale"
+	"highload-service/internal/devicestream"
+	"highload-service/internal/dlq"
+	"highload-service/internal/flags"
+	"highload-service/internal/importer"
+	"highload-service/internal/loglevel"
+	"highload-service/internal/outbox"
+	"highload-service/internal/quota"
+	"highload-service/internal/replay"
+)
+
+// MaxWorkers верхняя граница количества воркеров анализатора
+const MaxWorkers = 1024
+
+// WorkerPool пул воркеров с изменяемым размером (реализуется analytics.Analyzer)
+type WorkerPool interface {
+	Workers() int
+	SetWorkers(n int) error
+}
+
+// WorkerScaler автомасштабирование воркеров (реализуется autoscale.Scaler)
+type WorkerScaler interface {
+	Status() autoscale.Status
+}
+
+// RateLimiter ограничитель с изменяемыми лимитами (реализуется quota.Limiter)
+type RateLimiter interface {
+	Limits() quota.Limits
+	SetLimits(quota.Limits)
+}
+
+// DetectorRollout продвижение теневой конфигурации детектора (реализуется analytics.Canary)
+type DetectorRollout interface {
+	Status() analytics.CanaryStatus
+	Promote(actor string) (analytics.Transition, error)
+	Rollback(actor string) (analytics.Transition, error)
+	History() []analytics.Transition
+}
+
+// Replayer ретроспективный прогон сохраненных метрик (реализуется replay.Replayer)
+type Replayer interface {
+	Run(req replay.Request) (replay.Report, error)
+	Get(id string) (replay.Report, error)
+}
+
+// Importer импорт исторических метрик из внешней SQL-базы (реализуется importer.Importer)
+type Importer interface {
+	Run(ctx context.Context, req importer.Request) (importer.Report, error)
+}
+
+// OutboxReplayer повторная доставка событий об аномалиях (реализуется outbox.Outbox)
+type OutboxReplayer interface {
+	Sinks() []string
+	Replay(ctx context.Context, sink, from string) (int, error)
+}
+
+// OutboxReplayRequest параметры повторной доставки
+type OutboxReplayRequest struct {
+	Sink string `json:"sink"`
+	// From идентификатор первого события; "-" или пустое значение — с начала журнала
+	From string `json:"from"`
+}
+
+// DeadLetterQueue очередь недоставленных метрик (реализуется dlq.Queue)
+type DeadLetterQueue interface {
+	List(reason dlq.Reason, limit int) ([]dlq.Entry, error)
+	Requeue(ids []string, process dlq.Processor) (dlq.RequeueResult, error)
+}
+
+// RequeueRequest записи для повторной обработки: перечисленные в IDs или,
+// если список пуст, первые Limit записей с причиной Reason
+type RequeueRequest struct {
+	IDs    []string `json:"ids,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Limit  int      `json:"limit,omitempty"`
+}
+
+// DeviceStreams потоки подключенных устройств (реализуется devicestream.Hub)
+type DeviceStreams interface {
+	Connected() []devicestream.Connection
+	Config(deviceID string) devicestream.DeviceConfig
+	SetConfig(deviceID string, c devicestream.DeviceConfig) (int, error)
+}
+
+// AnalysisWindows сброс и заполнение окон анализа (реализуется analytics.Analyzer)
+type AnalysisWindows interface {
+	ResetWindows(deviceID string) (before, after analytics.Snapshot, err error)
+	SeedWindows(deviceID string, seed analytics.WindowSeed) (before, after analytics.Snapshot, err error)
+}
+
+// WindowState статистика окон в ответе и журнале аудита
+type WindowState struct {
+	Count     int     `json:"count"`
+	AvgCPU    float64 `json:"avg_cpu"`
+	AvgRPS    float64 `json:"avg_rps"`
+	StdDevCPU float64 `json:"std_dev_cpu"`
+	StdDevRPS float64 `json:"std_dev_rps"`
+}
+
+// WindowChange результат сброса или заполнения окон; пустой Device — общие окна
+type WindowChange struct {
+	Device string      `json:"device,omitempty"`
+	Before WindowState `json:"before"`
+	After  WindowState `json:"after"`
+}
+
+// QuotaSettings лимиты квот в представлении API
+type QuotaSettings struct {
+	Daily         int64  `json:"daily"`
+	Rolling       int64  `json:"rolling"`
+	RollingWindow string `json:"rolling_window"`
+}
+
+// RuntimeSettings текущие настройки сервиса
+type RuntimeSettings struct {
+	GOGC     int            `json:"gogc"`
+	LogLevel string         `json:"log_level"`
+	Workers  int            `json:"workers"`
+	Quota    *QuotaSettings `json:"quota,omitempty"`
+}
+
+// RuntimeUpdate изменение настроек; отсутствующие поля не меняются
+type RuntimeUpdate struct {
+	GOGC     *int           `json:"gogc"`
+	LogLevel *string        `json:"log_level"`
+	Workers  *int           `json:"workers"`
+	Quota    *QuotaSettings `json:"quota"`
+}
+
+// WorkersStatus воркеры анализатора и состояние автомасштабирования
+type WorkersStatus struct {
+	Workers int `json:"workers"`
+	// Autoscale nil, если автомасштабирование выключено
+	Autoscale *autoscale.Status `json:"autoscale,omitempty"`
+}
+
+// Option настраивает Handler
+type Option func(*Handler)
+
+// WithRateLimiter позволяет менять лимиты квот через API
+func WithRateLimiter(l RateLimiter) Option {
+	return func(h *Handler) {
+		h.limiter = l
+	}
+}
+
+// WithWorkerScaler позволяет просматривать состояние автомасштабирования воркеров
+func WithWorkerScaler(s WorkerScaler) Option {
+	return func(h *Handler) {
+		h.scaler = s
+	}
+}
+
+// WithFlags позволяет просматривать действующие feature-флаги
+func WithFlags(f *flags.Set) Option {
+	return func(h *Handler) {
+		h.flags = f
+	}
+}
+
+// WithDetectorRollout позволяет продвигать и откатывать конфигурацию детектора
+func WithDetectorRollout(r DetectorRollout) Option {
+	return func(h *Handler) {
+		h.rollout = r
+	}
+}
+
+// WithReplay позволяет прогонять сохраненные метрики через другую конфигурацию детектора
+func WithReplay(r Replayer) Option {
+	return func(h *Handler) {
+		h.replay = r
+	}
+}
+
+// WithImporter позволяет импортировать исторические метрики из внешней SQL-базы
+func WithImporter(i Importer) Option {
+	return func(h *Handler) {
+		h.importer = i
+	}
+}
+
+// WithOutbox позволяет повторно доставлять события об аномалиях получателям
+func WithOutbox(o OutboxReplayer) Option {
+	return func(h *Handler) {
+		h.outbox = o
+	}
+}
+
+// WithDeadLetters позволяет просматривать очередь недоставленных метрик и
+// повторно обрабатывать ее записи функцией process
+func WithDeadLetters(q DeadLetterQueue, process dlq.Processor) Option {
+	return func(h *Handler) {
+		h.deadLetters = q
+		h.reprocess = process
+	}
+}
+
+// WithDeviceStreams позволяет просматривать потоки устройств и менять их настройки
+func WithDeviceStreams(s DeviceStreams) Option {
+	return func(h *Handler) {
+		h.streams = s
+	}
+}
+
+// WithWindows позволяет сбрасывать окна анализа и заполнять их заданными значениями
+func WithWindows(w AnalysisWindows) Option {
+	return func(h *Handler) {
+		h.windows = w
+	}
+}
+
+// Handler обработчики административного API
+type Handler struct {
+	token    string
+	workers  WorkerPool
+	scaler   WorkerScaler
+	limiter  RateLimiter
+	flags    *flags.Set
+	rollout  DetectorRollout
+	replay   Replayer
+	importer Importer
+	outbox   OutboxReplayer
+	streams  DeviceStreams
+	windows  AnalysisWindows
+	devices  DeviceRegistry
+	snoozes  DeviceSnoozes
+	audit    *audit.Log
+
+	deadLetters DeadLetterQueue
+	reprocess   dlq.Processor
+
+	// mu сериализует изменения, чтобы записи аудита отражали реальный порядок
+	mu   sync.Mutex
+	gogc int
+}
+
+// NewHandler создает обработчики. Пустой token запрещает любой доступ
+func NewHandler(token string, workers WorkerPool, auditLog *audit.Log, opts ...Option) *Handler {
+	// Узнать GOGC можно только установив новое значение, поэтому читаем его один раз
+	gogc := debug.SetGCPercent(100)
+	debug.SetGCPercent(gogc)
+
+	h := &Handler{
+		token:   token,
+		workers: workers,
+		audit:   auditLog,
+		gogc:    gogc,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// RegisterRoutes регистрирует эндпоинты под /admin с проверкой токена
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	sub := router.PathPrefix("/admin").Subrouter()
+	sub.Use(h.authenticate)
+	sub.HandleFunc("/runtime", h.GetRuntimeHandler).Methods("GET")
+	sub.HandleFunc("/runtime", h.UpdateRuntimeHandler).Methods("PATCH")
+	sub.HandleFunc("/workers", h.WorkersHandler).Methods("GET")
+	sub.HandleFunc("/audit", h.AuditHandler).Methods("GET")
+	sub.HandleFunc("/flags", h.FlagsHandler).Methods("GET")
+	sub.HandleFunc("/detector", h.DetectorStatusHandler).Methods("GET")
+	sub.HandleFunc("/detector/history", h.DetectorHistoryHandler).Methods("GET")
+	sub.HandleFunc("/detector/promote", h.DetectorTransitionHandler("promote")).Methods("POST")
+	sub.HandleFunc("/detector/rollback", h.DetectorTransitionHandler("rollback")).Methods("POST")
+	sub.HandleFunc("/replay", h.ReplayHandler).Methods("POST")
+	sub.HandleFunc("/replay/{id}", h.ReplayReportHandler).Methods("GET")
+	sub.HandleFunc("/import", h.ImportHandler).Methods("POST")
+	sub.HandleFunc("/outbox", h.OutboxHandler).Methods("GET")
+	sub.HandleFunc("/outbox/replay", h.OutboxReplayHandler).Methods("POST")
+	sub.HandleFunc("/dlq", h.DeadLettersHandler).Methods("GET")
+	sub.HandleFunc("/dlq/requeue", h.RequeueHandler).Methods("POST")
+	sub.HandleFunc("/streams", h.StreamsHandler).Methods("GET")
+	sub.HandleFunc("/streams/{device}/config", h.StreamConfigHandler).Methods("PUT")
+	sub.HandleFunc("/windows/reset", h.WindowsHandler("reset")).Methods("POST")
+	sub.HandleFunc("/windows/seed", h.WindowsHandler("seed")).Methods("POST")
+	sub.HandleFunc("/devices/bulk", h.BulkDevicesHandler).Methods("POST")
+}
+
+// authenticate проверяет заголовок Authorization: Bearer <token>
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.token == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			respondError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRuntimeHandler обрабатывает GET /admin/runtime - текущие настройки
+func (h *Handler) GetRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	settings := h.snapshotLocked()
+	h.mu.Unlock()
+	respondJSON(w, settings, http.StatusOK)
+}
+
+// WorkersHandler обрабатывает GET /admin/workers - количество воркеров
+// анализатора и, если включено, загрузку и решения автомасштабирования
+func (h *Handler) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	status := WorkersStatus{Workers: h.workers.Workers()}
+	if h.scaler != nil {
+		scaling := h.scaler.Status()
+		status.Autoscale = &scaling
+	}
+	respondJSON(w, status, http.StatusOK)
+}
+
+// UpdateRuntimeHandler обрабатывает PATCH /admin/runtime - изменение настроек.
+// Изменения применяются, только если все поля корректны
+func (h *Handler) UpdateRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var update RuntimeUpdate
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&update); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	level, limits, err := h.validate(update)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	before := h.snapshotLocked()
+
+	if update.Workers != nil {
+		if err := h.workers.SetWorkers(*update.Workers); err != nil {
+			respondError(w, "Failed to resize workers: "+err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	if update.GOGC != nil {
+		debug.SetGCPercent(*update.GOGC)
+		h.gogc = *update.GOGC
+	}
+	if update.LogLevel != nil {
+		loglevel.Set(level)
+	}
+	if update.Quota != nil {
+		h.limiter.SetLimits(limits)
+	}
+
+	after := h.snapshotLocked()
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "runtime.update",
+		Before: before,
+		After:  after,
+	})
+	respondJSON(w, after, http.StatusOK)
+}
+
+// AuditHandler обрабатывает GET /admin/audit - последние записи журнала аудита
+func (h *Handler) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, h.audit.Recent(), http.StatusOK)
+}
+
+// FlagsHandler обрабатывает GET /admin/flags - действующие feature-флаги
+// с учетом переопределений из Redis
+func (h *Handler) FlagsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.flags == nil {
+		respondJSON(w, flags.Defaults(), http.StatusOK)
+		return
+	}
+	respondJSON(w, h.flags.Snapshot(), http.StatusOK)
+}
+
+// DetectorStatusHandler обрабатывает GET /admin/detector - основная и теневая
+// конфигурации, их отличия и результаты сравнения
+func (h *Handler) DetectorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if h.rollout == nil {
+		respondError(w, "No shadow detector is running", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, h.rollout.Status(), http.StatusOK)
+}
+
+// DetectorHistoryHandler обрабатывает GET /admin/detector/history - история
+// продвижений и откатов с отличиями конфигураций
+func (h *Handler) DetectorHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if h.rollout == nil {
+		respondError(w, "No shadow detector is running", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, h.rollout.History(), http.StatusOK)
+}
+
+// DetectorTransitionHandler обрабатывает POST /admin/detector/promote и /admin/detector/rollback
+func (h *Handler) DetectorTransitionHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.rollout == nil {
+			respondError(w, "No shadow detector is running", http.StatusNotFound)
+			return
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		transition := h.rollout.Promote
+		if action == "rollback" {
+			transition = h.rollout.Rollback
+		}
+		t, err := transition(r.RemoteAddr)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		h.audit.Record(audit.Event{
+			Actor:  r.RemoteAddr,
+			Action: "detector." + action,
+			Before: t.From,
+			After:  t.To,
+		})
+		respondJSON(w, t, http.StatusOK)
+	}
+}
+
+// ReplayHandler обрабатывает POST /admin/replay - прогон сохраненного диапазона
+// метрик через заданную конфигурацию детектора без оповещений
+func (h *Handler) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		respondError(w, "Replay requires the metrics archive (Redis)", http.StatusNotFound)
+		return
+	}
+
+	var req replay.Request
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.replay.Run(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, replay.ErrInvalidRequest) {
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "replay.run",
+		After: map[string]interface{}{
+			"id":        report.ID,
+			"from":      report.From,
+			"to":        report.To,
+			"detector":  report.Detector,
+			"metrics":   report.Metrics,
+			"anomalies": report.Anomalies,
+		},
+	})
+	respondJSON(w, report, http.StatusOK)
+}
+
+// ReplayReportHandler обрабатывает GET /admin/replay/{id} - сохраненный отчет прогона
+func (h *Handler) ReplayReportHandler(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		respondError(w, "Replay requires the metrics archive (Redis)", http.StatusNotFound)
+		return
+	}
+	report, err := h.replay.Get(mux.Vars(r)["id"])
+	if errors.Is(err, replay.ErrNotFound) {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, report, http.StatusOK)
+}
+
+// ImportHandler обрабатывает POST /admin/import - импорт диапазона исторических
+// метрик из внешней SQL-базы с необязательным ретроанализом
+func (h *Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if h.importer == nil {
+		respondError(w, "Import requires IMPORT_SQL_DSN and the metrics archive (Redis)", http.StatusNotFound)
+		return
+	}
+
+	var req importer.Request
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.importer.Run(r.Context(), req)
+	if errors.Is(err, importer.ErrInvalidRequest) {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after := map[string]interface{}{
+		"from":     report.From,
+		"to":       report.To,
+		"rows":     report.Rows,
+		"imported": report.Imported,
+	}
+	if report.Replay != nil {
+		after["replay"] = report.Replay.ID
+	}
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "import.sql",
+		After:  after,
+	})
+	if err != nil {
+		// Часть метрик могла сохраниться до ошибки; повторный импорт безопасен
+		respondJSON(w, map[string]interface{}{"report": report, "error": err.Error()}, http.StatusBadGateway)
+		return
+	}
+	respondJSON(w, report, http.StatusOK)
+}
+
+// OutboxHandler обрабатывает GET /admin/outbox - получатели событий об аномалиях
+func (h *Handler) OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	if h.outbox == nil {
+		respondError(w, "Outbox is not configured", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, map[string][]string{"sinks": h.outbox.Sinks()}, http.StatusOK)
+}
+
+// OutboxReplayHandler обрабатывает POST /admin/outbox/replay - повторная доставка
+// событий получателю начиная с заданного идентификатора
+func (h *Handler) OutboxReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if h.outbox == nil {
+		respondError(w, "Outbox is not configured", http.StatusNotFound)
+		return
+	}
+
+	var req OutboxReplayRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.From == "" {
+		req.From = "-"
+	}
+
+	replayed, err := h.outbox.Replay(r.Context(), req.Sink, req.From)
+	if errors.Is(err, outbox.ErrUnknownSink) {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "outbox.replay",
+		After:  map[string]interface{}{"sink": req.Sink, "from": req.From, "replayed": replayed},
+	})
+	if err != nil {
+		// Часть событий могла быть доставлена до ошибки
+		respondJSON(w, map[string]interface{}{"replayed": replayed, "error": err.Error()}, http.StatusBadGateway)
+		return
+	}
+	respondJSON(w, map[string]interface{}{"replayed": replayed}, http.StatusOK)
+}
+
+// DeadLettersHandler обрабатывает GET /admin/dlq - просмотр очереди недоставленных
+// метрик с фильтром reason и ограничением limit
+func (h *Handler) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if h.deadLetters == nil {
+		respondError(w, "Dead-letter queue is not configured", http.StatusNotFound)
+		return
+	}
+	reason, err := dlq.ParseReason(r.URL.Query().Get("reason"))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > dlq.MaxList {
+			respondError(w, fmt.Sprintf("limit must be between 1 and %d", dlq.MaxList), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := h.deadLetters.List(reason, limit)
+	if err != nil {
+		respondError(w, "Failed to read dead-letter queue: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]interface{}{"entries": entries, "count": len(entries)}, http.StatusOK)
+}
+
+// RequeueHandler обрабатывает POST /admin/dlq/requeue - повторная обработка записей
+// очереди недоставленных
+func (h *Handler) RequeueHandler(w http.ResponseWriter, r *http.Request) {
+	if h.deadLetters == nil {
+		respondError(w, "Dead-letter queue is not configured", http.StatusNotFound)
+		return
+	}
+
+	var req RequeueRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	reason, err := dlq.ParseReason(req.Reason)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > dlq.MaxList || req.Limit < 0 || req.Limit > dlq.MaxList {
+		respondError(w, fmt.Sprintf("at most %d entries can be requeued at once", dlq.MaxList), http.StatusBadRequest)
+		return
+	}
+
+	ids := req.IDs
+	if len(ids) == 0 {
+		entries, err := h.deadLetters.List(reason, req.Limit)
+		if err != nil {
+			respondError(w, "Failed to read dead-letter queue: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+	}
+
+	result, err := h.deadLetters.Requeue(ids, h.reprocess)
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "dlq.requeue",
+		After:  map[string]interface{}{"ids": len(ids), "requeued": result.Requeued, "failed": len(result.Failed)},
+	})
+	if err != nil {
+		respondError(w, "Requeue stopped: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, result, http.StatusOK)
+}
+
+// StreamsHandler обрабатывает GET /admin/streams - подключенные устройства и их настройки
+func (h *Handler) StreamsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.streams == nil {
+		respondError(w, "Device streams are not enabled", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, h.streams.Connected(), http.StatusOK)
+}
+
+// StreamConfigHandler обрабатывает PUT /admin/streams/{device}/config - новые
+// настройки устройства. Настройки сразу отправляются в открытые потоки устройства
+// и действуют при следующих подключениях
+func (h *Handler) StreamConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if h.streams == nil {
+		respondError(w, "Device streams are not enabled", http.StatusNotFound)
+		return
+	}
+
+	var config devicestream.DeviceConfig
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	device := mux.Vars(r)["device"]
+	before := h.streams.Config(device)
+	notified, err := h.streams.SetConfig(device, config)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "stream.config",
+		Before: map[string]interface{}{"device": device, "config": before},
+		After:  map[string]interface{}{"device": device, "config": config},
+	})
+	respondJSON(w, map[string]interface{}{"device_id": device, "config": config, "notified": notified}, http.StatusOK)
+}
+
+// WindowsHandler обрабатывает POST /admin/windows/reset и /admin/windows/seed -
+// сброс окон анализа устройства ?device= или общих окон и их заполнение
+// значениями из тела ({"cpu": [...], "rps": [...]}). Изменение сразу видно в /analyze
+func (h *Handler) WindowsHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.windows == nil {
+			respondError(w, "Analysis windows are not available", http.StatusNotFound)
+			return
+		}
+
+		var seed analytics.WindowSeed
+		if action == "seed" {
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&seed); err != nil {
+				respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := seed.Validate(); err != nil {
+				respondError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		device := r.URL.Query().Get("device")
+		var before, after analytics.Snapshot
+		var err error
+		if action == "seed" {
+			before, after, err = h.windows.SeedWindows(device, seed)
+		} else {
+			before, after, err = h.windows.ResetWindows(device)
+		}
+		switch {
+		case errors.Is(err, analytics.ErrNoDeviceWindows):
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			respondError(w, "Failed to "+action+" windows: "+err.Error(), http.StatusConflict)
+			return
+		}
+
+		change := WindowChange{Device: device, Before: windowState(before), After: windowState(after)}
+		h.audit.Record(audit.Event{
+			Actor:  r.RemoteAddr,
+			Action: "windows." + action,
+			Before: map[string]interface{}{"device": device, "windows": change.Before},
+			After:  map[string]interface{}{"device": device, "windows": change.After},
+		})
+		respondJSON(w, change, http.StatusOK)
+	}
+}
+
+func windowState(s analytics.Snapshot) WindowState {
+	return WindowState{Count: s.Count, AvgCPU: s.AvgCPU, AvgRPS: s.AvgRPS, StdDevCPU: s.StdDevCPU, StdDevRPS: s.StdDevRPS}
+}
+
+// validate проверяет изменение целиком до применения
+func (h *Handler) validate(u RuntimeUpdate) (loglevel.Level, quota.Limits, error) {
+	level := loglevel.Get()
+	var limits quota.Limits
+
+	if u.GOGC != nil && *u.GOGC < -1 {
+		return level, limits, fmt.Errorf("gogc must be >= -1 (-1 disables GC), got %d", *u.GOGC)
+	}
+	if u.Workers != nil && (*u.Workers < 1 || *u.Workers > MaxWorkers) {
+		return level, limits, fmt.Errorf("workers must be within [1, %d], got %d", MaxWorkers, *u.Workers)
+	}
+	if u.LogLevel != nil {
+		l, err := loglevel.Parse(*u.LogLevel)
+		if err != nil {
+			return level, limits, err
+		}
+		level = l
+	}
+	if u.Quota != nil {
+		if h.limiter == nil {
+			return level, limits, fmt.Errorf("quotas are not configured on this instance")
+		}
+		if u.Quota.Daily < 0 || u.Quota.Rolling < 0 {
+			return level, limits, fmt.Errorf("quota limits must be non-negative")
+		}
+		limits = quota.Limits{Daily: u.Quota.Daily, Rolling: u.Quota.Rolling}
+		if u.Quota.RollingWindow != "" {
+			d, err := time.ParseDuration(u.Quota.RollingWindow)
+			if err != nil || d <= 0 {
+				return level, limits, fmt.Errorf("invalid quota rolling_window %q", u.Quota.RollingWindow)
+			}
+			limits.RollingWindow = d
+		} else {
+			limits.RollingWindow = h.limiter.Limits().RollingWindow
+		}
+	}
+	return level, limits, nil
+}
+
+// snapshotLocked собирает текущие настройки; вызывается под mu
+func (h *Handler) snapshotLocked() RuntimeSettings {
+	s := RuntimeSettings{
+		GOGC:     h.gogc,
+		LogLevel: loglevel.Get().String(),
+		Workers:  h.workers.Workers(),
+	}
+	if h.limiter != nil {
+		l := h.limiter.Limits()
+		s.Quota = &QuotaSettings{Daily: l.Daily, Rolling: l.Rolling, RollingWindow: l.RollingWindow.String()}
+	}
+	return s
+}
+
+func respondJSON(w http.ResponseWriter, data interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func respondError(w http.ResponseWriter, message string, status int) {
+	respondJSON(w, map[string]string{"error": message}, status)
+}
@@ -0,0 +1,273 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/audit"
+	"highload-service/internal/autoscale"
+	"highload-service/internal/cache"
+	"highload-service/internal/loglevel"
+	"highload-service/internal/models"
+	"highload-service/internal/quota"
+	"highload-service/internal/replay"
+)
+
+type fakePool struct{ n int }
+
+func (p *fakePool) Workers() int { return p.n }
+
+func (p *fakePool) SetWorkers(n int) error {
+	if n == 13 {
+		return errors.New("unlucky")
+	}
+	p.n = n
+	return nil
+}
+
+func newTestRouter(t *testing.T) (*mux.Router, *fakePool, *quota.Limiter, *audit.Log) {
+	t.Helper()
+	t.Cleanup(func() { loglevel.Set(loglevel.Info) })
+
+	pool := &fakePool{n: 4}
+	limiter := quota.NewLimiter(nil, nil, quota.Limits{RollingWindow: time.Minute})
+	auditLog := audit.New(nil, nil, 10)
+	router := mux.NewRouter()
+	NewHandler("secret", pool, auditLog, WithRateLimiter(limiter)).RegisterRoutes(router)
+	return router, pool, limiter, auditLog
+}
+
+func do(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	router, _, _, _ := newTestRouter(t)
+
+	for _, token := range []string{"", "wrong"} {
+		rec := do(router, http.MethodGet, "/admin/runtime", token, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected 401, got %d", token, rec.Code)
+		}
+	}
+	if rec := do(router, http.MethodGet, "/admin/runtime", "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with valid token, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_UpdateAppliesAndAudits(t *testing.T) {
+	router, pool, limiter, auditLog := newTestRouter(t)
+
+	rec := do(router, http.MethodPatch, "/admin/runtime", "secret",
+		`{"workers": 8, "log_level": "debug", "quota": {"daily": 1000, "rolling": 10}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var settings RuntimeSettings
+	if err := json.Unmarshal(rec.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Workers != 8 || pool.n != 8 {
+		t.Errorf("Expected 8 workers, got response=%d pool=%d", settings.Workers, pool.n)
+	}
+	if loglevel.Get() != loglevel.Debug || settings.LogLevel != "debug" {
+		t.Errorf("Expected debug log level, got %s", loglevel.Get())
+	}
+	if got := limiter.Limits(); got.Daily != 1000 || got.Rolling != 10 || got.RollingWindow != time.Minute {
+		t.Errorf("Unexpected limits %+v", got)
+	}
+
+	events := auditLog.Recent()
+	if len(events) != 1 || events[0].Action != "runtime.update" {
+		t.Fatalf("Expected one audit event, got %+v", events)
+	}
+	if before := events[0].Before.(RuntimeSettings); before.Workers != 4 || before.LogLevel != "info" {
+		t.Errorf("Audit event should record previous settings, got %+v", before)
+	}
+}
+
+type fakeScaler struct{ status autoscale.Status }
+
+func (s fakeScaler) Status() autoscale.Status { return s.status }
+
+func TestAdmin_WorkersReportsAutoscaling(t *testing.T) {
+	router, _, _, _ := newTestRouter(t)
+	rec := do(router, http.MethodGet, "/admin/workers", "secret", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"workers":4}` {
+		t.Fatalf("Expected the worker count without autoscaling, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	router = mux.NewRouter()
+	scaler := fakeScaler{autoscale.Status{Workers: 4, Min: 2, Max: 8, Utilization: 0.5, LastReason: autoscale.ReasonIdle}}
+	NewHandler("secret", &fakePool{n: 4}, audit.New(nil, nil, 10), WithWorkerScaler(scaler)).RegisterRoutes(router)
+	var status WorkersStatus
+	if err := json.Unmarshal(do(router, http.MethodGet, "/admin/workers", "secret", "").Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Workers != 4 || status.Autoscale == nil || status.Autoscale.Max != 8 || status.Autoscale.LastReason != autoscale.ReasonIdle {
+		t.Errorf("Unexpected workers status %+v", status)
+	}
+}
+
+func TestAdmin_InvalidUpdateChangesNothing(t *testing.T) {
+	router, pool, _, auditLog := newTestRouter(t)
+
+	for _, body := range []string{
+		`{"workers": 8, "log_level": "verbose"}`,
+		`{"workers": 0}`,
+		`{"gogc": -5}`,
+		`{"quota": {"daily": 5, "rolling_window": "soon"}}`,
+		`{"threads": 3}`,
+	} {
+		rec := do(router, http.MethodPatch, "/admin/runtime", "secret", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if pool.n != 4 || loglevel.Get() != loglevel.Info {
+		t.Errorf("Invalid updates must not be applied partially: workers=%d level=%s", pool.n, loglevel.Get())
+	}
+	if len(auditLog.Recent()) != 0 {
+		t.Error("Rejected updates must not be audited")
+	}
+}
+
+func TestAdmin_DetectorPromotionIsAudited(t *testing.T) {
+	primaryCfg := analytics.DefaultDetectorConfig()
+	shadowCfg := analytics.DetectorConfig{WindowSize: 200, ZScoreThreshold: 2}
+	primary := analytics.NewAnalyzer(1)
+	experiment := analytics.NewExperiment(primaryCfg, shadowCfg, 1)
+	defer experiment.Stop()
+
+	auditLog := audit.New(nil, nil, 10)
+	router := mux.NewRouter()
+	NewHandler("secret", &fakePool{n: 1}, auditLog,
+		WithDetectorRollout(analytics.NewCanary(primary, experiment))).RegisterRoutes(router)
+
+	if rec := do(router, http.MethodPost, "/admin/detector/rollback", "secret", ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for rollback without promotion, got %d", rec.Code)
+	}
+
+	rec := do(router, http.MethodPost, "/admin/detector/promote", "secret", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tr analytics.Transition
+	json.Unmarshal(rec.Body.Bytes(), &tr)
+	if tr.From != primaryCfg || tr.To != shadowCfg || len(tr.Diff) != 1 || tr.Diff[0].Field != "window_size" {
+		t.Errorf("Unexpected transition %+v", tr)
+	}
+
+	events := auditLog.Recent()
+	if len(events) != 1 || events[0].Action != "detector.promote" {
+		t.Errorf("Expected promotion in audit log, got %+v", events)
+	}
+
+	rec = do(router, http.MethodGet, "/admin/detector/history", "secret", "")
+	var history []analytics.Transition
+	json.Unmarshal(rec.Body.Bytes(), &history)
+	if len(history) != 1 {
+		t.Errorf("Expected 1 transition in history, got %d", len(history))
+	}
+}
+
+func TestAdmin_ReplayIsAudited(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	base := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		_ = store.CacheMetric(models.Metric{Timestamp: base.Add(time.Duration(i) * time.Second), CPU: 40})
+	}
+
+	auditLog := audit.New(nil, nil, 10)
+	router := mux.NewRouter()
+	NewHandler("secret", &fakePool{n: 1}, auditLog, WithReplay(replay.New(store, store))).RegisterRoutes(router)
+
+	if rec := do(router, http.MethodPost, "/admin/replay", "secret", `{"from":"2024-01-02T00:00:00Z","to":"2024-01-01T00:00:00Z"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for inverted range, got %d", rec.Code)
+	}
+
+	body := `{"from":"` + base.Format(time.RFC3339Nano) + `","to":"` + base.Add(time.Minute).Format(time.RFC3339Nano) +
+		`","detector":{"window_size":10,"z_score_threshold":3}}`
+	rec := do(router, http.MethodPost, "/admin/replay", "secret", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report replay.Report
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if report.Metrics != 5 {
+		t.Errorf("Expected 5 replayed metrics, got %+v", report)
+	}
+
+	if rec := do(router, http.MethodGet, "/admin/replay/"+report.ID, "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected stored report, got %d", rec.Code)
+	}
+	if rec := do(router, http.MethodGet, "/admin/replay/missing", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown report, got %d", rec.Code)
+	}
+
+	events := auditLog.Recent()
+	if len(events) != 1 || events[0].Action != "replay.run" {
+		t.Errorf("Expected replay in audit log, got %+v", events)
+	}
+}
+
+func TestAdmin_WindowResetAndSeedAreAudited(t *testing.T) {
+	analyzer := analytics.NewAnalyzer(1, analytics.WithDeviceWindows(analytics.DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 10}))
+	defer analyzer.Stop()
+	for i := 0; i < 10; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100})
+	}
+
+	auditLog := audit.New(nil, nil, 10)
+	router := mux.NewRouter()
+	NewHandler("secret", &fakePool{n: 1}, auditLog, WithWindows(analyzer)).RegisterRoutes(router)
+
+	for _, body := range []string{`{"cpu":[1,2],"rps":[1]}`, `{"cpu":[]}`, `{"cpu":[1],"rps":[1],"ram":[1]}`} {
+		if rec := do(router, http.MethodPost, "/admin/windows/seed", "secret", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if rec := do(router, http.MethodPost, "/admin/windows/reset?device=missing", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a device without windows, got %d", rec.Code)
+	}
+
+	rec := do(router, http.MethodPost, "/admin/windows/seed?device=sensor-1", "secret", `{"cpu":[70,80,90],"rps":[10,20,30]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var change WindowChange
+	json.Unmarshal(rec.Body.Bytes(), &change)
+	if change.Before.Count != 10 || change.Before.AvgCPU != 40 || change.After.Count != 3 || change.After.AvgCPU != 80 {
+		t.Errorf("Unexpected window change %+v", change)
+	}
+	// The seeded windows are used by the next analysis
+	if avgCPU, _, _, _ := analyzer.GetStats("sensor-1"); avgCPU != 80 {
+		t.Errorf("Expected seeded windows in stats, got avg CPU %v", avgCPU)
+	}
+
+	rec = do(router, http.MethodPost, "/admin/windows/reset", "secret", "")
+	json.Unmarshal(rec.Body.Bytes(), &change)
+	if rec.Code != http.StatusOK || change.Before.Count != 10 || change.After.Count != 0 {
+		t.Errorf("Expected the global windows to be reset, got %d %+v", rec.Code, change)
+	}
+
+	events := auditLog.Recent()
+	if len(events) != 2 || events[0].Action != "windows.seed" || events[1].Action != "windows.reset" {
+		t.Errorf("Expected seed and reset in audit log, got %+v", events)
+	}
+}
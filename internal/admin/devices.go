@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"highload-service/internal/audit"
+	"highload-service/internal/devices"
+	"highload-service/internal/models"
+	"highload-service/internal/snooze"
+)
+
+const (
+	// MaxBulkDeviceOps наибольшее количество операций в одном запросе
+	MaxBulkDeviceOps = 10000
+	// maxBulkLine наибольшая длина строки NDJSON
+	maxBulkLine = 64 << 10
+	// maxBulkBody наибольший размер тела запроса
+	maxBulkBody = 16 << 20
+)
+
+// Операции над устройствами (поле op)
+const (
+	// BulkRegister добавляет устройство в реестр или заменяет его запись
+	BulkRegister = "register"
+	// BulkTag добавляет зарегистрированное устройство в группы
+	BulkTag = "tag"
+	// BulkSnooze откладывает аномалии устройства на duration
+	BulkSnooze = "snooze"
+	// BulkRetire удаляет устройство из реестра и снимает его отложение
+	BulkRetire = "retire"
+)
+
+// DeviceRegistry реестр устройств (реализуется devices.Registry)
+type DeviceRegistry interface {
+	Get(id string) (devices.Device, bool)
+	Register(d devices.Device)
+	Retire(id string) (devices.Device, bool)
+}
+
+// DeviceSnoozes отложение аномалий устройств (реализуется snooze.Registry)
+type DeviceSnoozes interface {
+	Snooze(deviceID string, d time.Duration) (models.Snooze, error)
+	Cancel(deviceID string) (models.Snooze, bool)
+}
+
+// WithDevices позволяет массово регистрировать, группировать, откладывать и
+// выводить из эксплуатации устройства
+func WithDevices(r DeviceRegistry, s DeviceSnoozes) Option {
+	return func(h *Handler) {
+		h.devices = r
+		h.snoozes = s
+	}
+}
+
+// BulkDeviceOp строка тела POST /admin/devices/bulk
+type BulkDeviceOp struct {
+	Op string `json:"op"`
+	ID string `json:"id"`
+	// Groups группы новой записи (register) или добавляемые группы (tag)
+	Groups   []string `json:"groups,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Class    string   `json:"class,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	// Duration длительность отложения (snooze), по умолчанию snooze.DefaultDuration
+	Duration string `json:"duration,omitempty"`
+}
+
+// BulkDeviceResult результат одной операции; Status — код HTTP, который
+// вернул бы отдельный запрос
+type BulkDeviceResult struct {
+	// Line номер строки тела, с единицы
+	Line   int    `json:"line"`
+	Op     string `json:"op,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkDeviceReport результаты всех операций в порядке строк тела
+type BulkDeviceReport struct {
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Results   []BulkDeviceResult `json:"results"`
+}
+
+// BulkDevicesHandler обрабатывает POST /admin/devices/bulk - операции над
+// устройствами по одной на строку NDJSON. Тело разбирается целиком до
+// применения: при ошибке чтения или превышении MaxBulkDeviceOps не
+// применяется ничего. Ошибка отдельной строки не останавливает остальные,
+// ее код и причина возвращаются в результате строки
+func (h *Handler) BulkDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.devices == nil {
+		respondError(w, "Device registry is not configured", http.StatusNotFound)
+		return
+	}
+
+	type line struct {
+		n   int
+		op  BulkDeviceOp
+		err error
+	}
+	var lines []line
+	scanner := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxBulkBody))
+	scanner.Buffer(make([]byte, 0, 4096), maxBulkLine)
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+		if len(lines) == MaxBulkDeviceOps {
+			respondError(w, fmt.Sprintf("Too many operations, at most %d are allowed", MaxBulkDeviceOps), http.StatusRequestEntityTooLarge)
+			return
+		}
+		l := line{n: n}
+		if err := json.Unmarshal(raw, &l.op); err != nil {
+			l.err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		lines = append(lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, bufio.ErrTooLong) {
+			respondError(w, "Request body or line too large: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondError(w, "Failed to read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(lines) == 0 {
+		respondError(w, "Request body has no operations", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := BulkDeviceReport{Results: make([]BulkDeviceResult, 0, len(lines))}
+	applied := make(map[string]int)
+	for _, l := range lines {
+		res := BulkDeviceResult{Line: l.n, Op: l.op.Op, ID: l.op.ID, Status: http.StatusBadRequest}
+		err := l.err
+		if err == nil {
+			res.Status, err = h.applyDeviceOp(l.op)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			report.Failed++
+		} else {
+			report.Succeeded++
+			applied[l.op.Op]++
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	h.audit.Record(audit.Event{
+		Actor:  r.RemoteAddr,
+		Action: "devices.bulk",
+		After:  map[string]interface{}{"applied": applied, "failed": report.Failed},
+	})
+	respondJSON(w, report, http.StatusOK)
+}
+
+// applyDeviceOp применяет операцию и возвращает код HTTP ее результата
+func (h *Handler) applyDeviceOp(op BulkDeviceOp) (int, error) {
+	if op.ID == "" {
+		return http.StatusBadRequest, errors.New("id is required")
+	}
+
+	switch op.Op {
+	case BulkRegister:
+		d := devices.Device{ID: op.ID, Groups: op.Groups, Tenant: op.Tenant, Class: op.Class, Interval: op.Interval}
+		if err := d.Validate(); err != nil {
+			return http.StatusBadRequest, err
+		}
+		_, existed := h.devices.Get(op.ID)
+		h.devices.Register(d)
+		if existed {
+			return http.StatusOK, nil
+		}
+		return http.StatusCreated, nil
+
+	case BulkTag:
+		if len(op.Groups) == 0 {
+			return http.StatusBadRequest, errors.New("groups are required")
+		}
+		d, ok := h.devices.Get(op.ID)
+		if !ok {
+			return http.StatusNotFound, errors.New("device is not registered")
+		}
+		groups := append([]string(nil), d.Groups...)
+		for _, g := range op.Groups {
+			if !slices.Contains(groups, g) {
+				groups = append(groups, g)
+			}
+		}
+		d.Groups = groups
+		h.devices.Register(d)
+		return http.StatusOK, nil
+
+	case BulkSnooze:
+		if h.snoozes == nil {
+			return http.StatusNotFound, errors.New("snoozing is not enabled")
+		}
+		d := snooze.DefaultDuration
+		if op.Duration != "" {
+			parsed, err := time.ParseDuration(op.Duration)
+			if err != nil {
+				return http.StatusBadRequest, fmt.Errorf("invalid duration: %v", err)
+			}
+			d = parsed
+		}
+		if _, err := h.snoozes.Snooze(op.ID, d); err != nil {
+			return http.StatusBadRequest, err
+		}
+		return http.StatusOK, nil
+
+	case BulkRetire:
+		if _, ok := h.devices.Retire(op.ID); !ok {
+			return http.StatusNotFound, errors.New("device is not registered")
+		}
+		if h.snoozes != nil {
+			h.snoozes.Cancel(op.ID)
+		}
+		return http.StatusOK, nil
+
+	default:
+		return http.StatusBadRequest, fmt.Errorf("unknown op %q, expected register, tag, snooze or retire", op.Op)
+	}
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/audit"
+	"highload-service/internal/devices"
+	"highload-service/internal/snooze"
+)
+
+func newDevicesRouter(t *testing.T) (*mux.Router, *devices.Registry, *snooze.Registry, *audit.Log) {
+	t.Helper()
+	registry := devices.NewRegistry(devices.Device{ID: "old-1", Groups: []string{"rack-1"}})
+	snoozes := snooze.New(nil)
+	auditLog := audit.New(nil, nil, 10)
+	router := mux.NewRouter()
+	NewHandler("secret", &fakePool{n: 1}, auditLog, WithDevices(registry, snoozes)).RegisterRoutes(router)
+	return router, registry, snoozes, auditLog
+}
+
+func TestAdmin_BulkDevicesReportsEachLine(t *testing.T) {
+	router, registry, snoozes, auditLog := newDevicesRouter(t)
+
+	body := strings.Join([]string{
+		`{"op":"register","id":"gw-1","groups":["site-msk"],"class":"gateway","interval":"1m"}`,
+		`{"op":"register","id":"old-1","groups":["rack-2"]}`,
+		``,
+		`{"op":"tag","id":"gw-1","groups":["rack-2","site-msk"]}`,
+		`{"op":"tag","id":"missing","groups":["rack-2"]}`,
+		`{"op":"snooze","id":"gw-1","duration":"2h"}`,
+		`{"op":"register","id":"bad","interval":"soon"}`,
+		`{"op":"retire","id":"old-1"}`,
+		`{"op":"reboot","id":"gw-1"}`,
+		`not json`,
+	}, "\n")
+	rec := do(router, http.MethodPost, "/admin/devices/bulk", "secret", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report BulkDeviceReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	for _, res := range report.Results {
+		statuses = append(statuses, res.Status)
+	}
+	want := []int{201, 200, 200, 404, 200, 400, 200, 400, 400}
+	if !reflect.DeepEqual(statuses, want) || report.Succeeded != 5 || report.Failed != 4 {
+		t.Errorf("Expected statuses %v, got %v (%+v)", want, statuses, report)
+	}
+	// Blank lines are skipped but still counted in line numbers
+	if report.Results[2].Line != 4 || report.Results[8].Line != 10 || report.Results[8].Error == "" {
+		t.Errorf("Unexpected line results %+v", report.Results)
+	}
+
+	if d, _ := registry.Get("gw-1"); !reflect.DeepEqual(d.Groups, []string{"site-msk", "rack-2"}) || d.Class != "gateway" {
+		t.Errorf("Expected gw-1 registered and tagged, got %+v", d)
+	}
+	if _, ok := registry.Get("old-1"); ok {
+		t.Error("Expected old-1 to be retired")
+	}
+	if !snoozes.Snoozed("gw-1") {
+		t.Error("Expected gw-1 to be snoozed")
+	}
+
+	events := auditLog.Recent()
+	if len(events) != 1 || events[0].Action != "devices.bulk" {
+		t.Errorf("Expected one bulk audit event, got %+v", events)
+	}
+}
+
+func TestAdmin_BulkDevicesRejectsOversizedRequests(t *testing.T) {
+	router, registry, _, _ := newDevicesRouter(t)
+
+	body := strings.Repeat(`{"op":"register","id":"s"}`+"\n", MaxBulkDeviceOps+1)
+	if rec := do(router, http.MethodPost, "/admin/devices/bulk", "secret", body); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for too many operations, got %d", rec.Code)
+	}
+	long := `{"op":"register","id":"` + strings.Repeat("x", maxBulkLine) + `"}`
+	if rec := do(router, http.MethodPost, "/admin/devices/bulk", "secret", long); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an overlong line, got %d", rec.Code)
+	}
+	if rec := do(router, http.MethodPost, "/admin/devices/bulk", "secret", "\n\n"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty body, got %d", rec.Code)
+	}
+	// Nothing is applied from a rejected request
+	if _, ok := registry.Get("s"); ok {
+		t.Error("Expected no devices registered from rejected requests")
+	}
+}
@@ -0,0 +1,221 @@
+// Package admission сглаживает поток метрик в анализатор маркерной корзиной
+// (token bucket). Корзина пополняется со скоростью Rate метрик в секунду и
+// вмещает Burst; запрос, которому не хватает маркеров, ждет их не дольше
+// MaxWait, а если ждать пришлось бы дольше — отклоняется. Так всплеск пакетов
+// при массовом переподключении устройств растягивается во времени, и задержка
+// анализа и нагрузка на Redis остаются стабильными.
+//
+// Ограничение действует в пределах одной реплики или, с общей корзиной в
+// Redis (WithStore), для всех реплик вместе: тогда состояние корзины
+// переживает перезапуск, а пока Redis недоступен, реплика ограничивает себя
+// собственной корзиной
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+const (
+	// DefaultMaxWait наибольшее ожидание маркеров по умолчанию
+	DefaultMaxWait = 2 * time.Second
+	// BucketKey ключ общей корзины в Redis
+	BucketKey = "admission:bucket"
+)
+
+// ErrRejected запрос отклонен: маркеров не хватит в пределах MaxWait
+var ErrRejected = errors.New("ingest rate limit exceeded")
+
+// RejectedError отказ с временем, через которое маркеров хватит
+type RejectedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrRejected, e.RetryAfter)
+}
+
+// Is позволяет проверять отказ через errors.Is(err, ErrRejected)
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Config параметры корзины
+type Config struct {
+	// Rate устойчивая скорость приема, метрик в секунду; 0 отключает ограничение
+	Rate float64
+	// Burst емкость корзины: столько метрик принимается без ожидания после паузы
+	Burst int
+	// MaxWait наибольшее ожидание маркеров; 0 — избыток сразу отклоняется
+	MaxWait time.Duration
+	// Shared корзина хранится в Redis и общая для всех реплик: Rate и Burst
+	// ограничивают прием всего сервиса, а не реплики
+	Shared bool
+}
+
+// Enabled сообщает, задано ли ограничение
+func (c Config) Enabled() bool {
+	return c.Rate > 0
+}
+
+// Validate проверяет параметры
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if math.IsInf(c.Rate, 0) || math.IsNaN(c.Rate) {
+		return fmt.Errorf("rate must be finite, got %v", c.Rate)
+	}
+	if c.Burst < 1 {
+		return fmt.Errorf("burst must be positive, got %d", c.Burst)
+	}
+	if c.MaxWait < 0 {
+		return fmt.Errorf("max wait must not be negative, got %s", c.MaxWait)
+	}
+	return nil
+}
+
+// Store общая корзина (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	TakeTokens(key string, n int, rate float64, burst int, maxWait time.Duration) (wait time.Duration, taken bool, err error)
+	ReturnTokens(key string, n int) error
+}
+
+// Option настраивает Controller
+type Option func(*Controller)
+
+// WithStore хранит корзину в s под ключом key вместо памяти реплики
+func WithStore(s Store, key string) Option {
+	return func(ctrl *Controller) {
+		ctrl.store = s
+		ctrl.key = key
+	}
+}
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(ctrl *Controller) {
+		ctrl.clock = c
+	}
+}
+
+// Controller маркерная корзина. Безопасен для конкурентного использования
+type Controller struct {
+	cfg   Config
+	clock clock.Clock
+	// sleep ждет d или отмены ctx; подменяется в тестах
+	sleep func(ctx context.Context, d time.Duration) error
+	store Store
+	key   string
+	// storeFailing общая корзина недоступна, и действует корзина реплики
+	storeFailing atomic.Bool
+
+	mu sync.Mutex
+	// tokens может быть отрицательным: маркеры уже обещаны ожидающим запросам
+	tokens float64
+	last   time.Time
+}
+
+// New создает корзину, изначально полную
+func New(cfg Config, opts ...Option) *Controller {
+	c := &Controller{cfg: cfg, clock: clock.Real(), sleep: sleep, tokens: float64(cfg.Burst)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.last = c.clock.Now()
+	return c
+}
+
+// Admit пропускает n метрик, при необходимости дожидаясь маркеров. Возвращает
+// *RejectedError, если ждать пришлось бы дольше MaxWait, и ошибку ctx, если
+// запрос отменен во время ожидания
+func (c *Controller) Admit(ctx context.Context, n int) error {
+	if !c.cfg.Enabled() || n <= 0 {
+		return nil
+	}
+
+	wait, taken, shared := c.take(n)
+	if !taken {
+		metrics.AdmissionDecisions.WithLabelValues("rejected").Add(float64(n))
+		// Повтор имеет смысл, когда ожидание уложится в MaxWait
+		return &RejectedError{RetryAfter: wait - c.cfg.MaxWait}
+	}
+
+	if wait <= 0 {
+		metrics.AdmissionDecisions.WithLabelValues("admitted").Add(float64(n))
+		return nil
+	}
+	metrics.AdmissionDecisions.WithLabelValues("queued").Add(float64(n))
+	metrics.AdmissionWait.Observe(wait.Seconds())
+	if err := c.sleep(ctx, wait); err != nil {
+		// Обещанные маркеры возвращаются, чтобы отмененный запрос не задерживал остальных
+		c.giveBack(n, shared)
+		return err
+	}
+	return nil
+}
+
+// take резервирует n маркеров, если их придется ждать не дольше MaxWait, и
+// возвращает ожидание. shared — маркеры взяты из общей корзины; при ее
+// недоступности они берутся из корзины реплики
+func (c *Controller) take(n int) (wait time.Duration, taken, shared bool) {
+	if c.store != nil {
+		wait, taken, err := c.store.TakeTokens(c.key, n, c.cfg.Rate, c.cfg.Burst, c.cfg.MaxWait)
+		if err == nil {
+			if c.storeFailing.Swap(false) {
+				log.Printf("Shared admission bucket is available again")
+			}
+			return wait, taken, true
+		}
+		// Логируем только смену состояния, чтобы недоступный Redis не засыпал лог
+		if !c.storeFailing.Swap(true) {
+			log.Printf("Shared admission bucket is unavailable, limiting this replica alone: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	c.tokens = math.Min(float64(c.cfg.Burst), c.tokens+now.Sub(c.last).Seconds()*c.cfg.Rate)
+	c.last = now
+	wait = time.Duration((float64(n) - c.tokens) / c.cfg.Rate * float64(time.Second))
+	if wait > c.cfg.MaxWait {
+		return wait, false, false
+	}
+	c.tokens -= float64(n)
+	return wait, true, false
+}
+
+// giveBack возвращает n маркеров в корзину, из которой они взяты
+func (c *Controller) giveBack(n int, shared bool) {
+	if shared {
+		if err := c.store.ReturnTokens(c.key, n); err != nil {
+			log.Printf("Failed to return %d admission tokens: %v", n, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.tokens += float64(n)
+	c.mu.Unlock()
+}
+
+// sleep ждет d или отмены ctx
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,116 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func TestController_QueuesThenRejects(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := New(Config{Rate: 100, Burst: 100, MaxWait: time.Second}, WithClock(clk))
+	var waited []time.Duration
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		waited = append(waited, d)
+		return nil
+	}
+	ctx := context.Background()
+
+	// A full bucket admits the burst without waiting
+	if err := c.Admit(ctx, 100); err != nil || len(waited) != 0 {
+		t.Fatalf("Expected the burst to pass immediately, got %v, waited %v", err, waited)
+	}
+	// The next 50 metrics wait for tokens at 100/s
+	if err := c.Admit(ctx, 50); err != nil || len(waited) != 1 || waited[0] != 500*time.Millisecond {
+		t.Fatalf("Expected a 500ms wait, got %v, waited %v", err, waited)
+	}
+	// Tokens are already promised to the queued request, so 100 more would wait 1.5s
+	err := c.Admit(ctx, 100)
+	var rejected *RejectedError
+	if !errors.Is(err, ErrRejected) || !errors.As(err, &rejected) || rejected.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Expected a rejection with retry after 500ms, got %v", err)
+	}
+
+	// After a pause the bucket refills, but never above the burst
+	clk.Advance(time.Hour)
+	waited = nil
+	if err := c.Admit(ctx, 100); err != nil || len(waited) != 0 {
+		t.Errorf("Expected a refilled bucket, got %v, waited %v", err, waited)
+	}
+	if err := c.Admit(ctx, 1); err != nil || len(waited) != 1 {
+		t.Errorf("Expected the bucket to be capped at the burst, got %v, waited %v", err, waited)
+	}
+}
+
+func TestController_CancelledWaitReturnsTokens(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := New(Config{Rate: 10, Burst: 10, MaxWait: time.Second}, WithClock(clk))
+	c.sleep = func(context.Context, time.Duration) error { return context.Canceled }
+
+	c.Admit(context.Background(), 10)
+	if err := c.Admit(context.Background(), 5); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	// The cancelled request gave its 5 tokens back: 10 more wait exactly 1s and fit MaxWait
+	if err := c.Admit(context.Background(), 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the request to be queued, got %v", err)
+	}
+
+	if err := New(Config{}).Admit(context.Background(), 1e9); err != nil {
+		t.Errorf("Expected a disabled controller to admit everything, got %v", err)
+	}
+}
+
+// brokenStore is a shared bucket whose Redis is down
+type brokenStore struct{}
+
+func (brokenStore) TakeTokens(string, int, float64, int, time.Duration) (time.Duration, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+func (brokenStore) ReturnTokens(string, int) error { return errors.New("connection refused") }
+
+func TestController_SharedBucketLimitsAllReplicas(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	cfg := Config{Rate: 10, Burst: 10, Shared: true}
+	replicaA := New(cfg, WithClock(clk), WithStore(store, BucketKey))
+	replicaB := New(cfg, WithClock(clk), WithStore(store, BucketKey))
+
+	if err := replicaA.Admit(context.Background(), 6); err != nil {
+		t.Fatalf("Expected the first replica admitted, got %v", err)
+	}
+	// The other replica sees the tokens already taken
+	if err := replicaB.Admit(context.Background(), 6); !errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected the shared bucket to reject, got %v", err)
+	}
+	if err := replicaB.Admit(context.Background(), 4); err != nil {
+		t.Errorf("Expected the rest of the burst admitted, got %v", err)
+	}
+
+	// A restarted replica starts from the stored bucket, not a full one
+	restarted := New(cfg, WithClock(clk), WithStore(store, BucketKey))
+	if err := restarted.Admit(context.Background(), 1); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected an empty bucket after restart, got %v", err)
+	}
+	clk.Advance(time.Second)
+	if err := restarted.Admit(context.Background(), 10); err != nil {
+		t.Errorf("Expected the bucket refilled after a second, got %v", err)
+	}
+}
+
+func TestController_FallsBackToReplicaBucket(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := New(Config{Rate: 10, Burst: 10, Shared: true}, WithClock(clk), WithStore(brokenStore{}, BucketKey))
+
+	if err := c.Admit(context.Background(), 10); err != nil {
+		t.Fatalf("Expected the replica bucket to admit the burst, got %v", err)
+	}
+	if err := c.Admit(context.Background(), 1); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected the replica bucket to keep limiting, got %v", err)
+	}
+}
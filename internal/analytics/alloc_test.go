@@ -0,0 +1,40 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// Allocation budgets for the ingest hot path. Raise a budget only together
+// with a justification in the commit that needs it.
+const (
+	analyzeSyncAllocBudget   = 0
+	slidingWindowAllocBudget = 0
+)
+
+func TestAllocBudget_AnalyzeSync(t *testing.T) {
+	analyzer := NewAnalyzer(1)
+	metric := models.Metric{Timestamp: time.Now(), CPU: 55, RPS: 500, DeviceID: "sensor-1"}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		analyzer.AnalyzeSync(metric)
+	})
+	if allocs > analyzeSyncAllocBudget {
+		t.Errorf("AnalyzeSync allocates %.1f times per call, budget is %d", allocs, analyzeSyncAllocBudget)
+	}
+}
+
+func TestAllocBudget_SlidingWindowAdd(t *testing.T) {
+	sw := NewSlidingWindow(WindowSize)
+	v := 0.0
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		v++
+		sw.Add(v)
+	})
+	if allocs > slidingWindowAllocBudget {
+		t.Errorf("SlidingWindow.Add allocates %.1f times per call, budget is %d", allocs, slidingWindowAllocBudget)
+	}
+}
@@ -3,9 +3,18 @@
 package analytics
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"highload-service/internal/clock"
 	"highload-service/internal/models"
 )
 
@@ -14,17 +23,315 @@ const (
 	WindowSize = 50
 	// ZScoreThreshold порог для детекции аномалий (> 2σ)
 	ZScoreThreshold = 2.0
+	// MaxAbsValue максимальное по модулю значение, принимаемое окном.
+	// Квадрат суммы таких значений гарантированно остается конечным
+	MaxAbsValue = 1e150
 )
 
-// Analyzer выполняет статистический анализ метрик
+// Analyzer выполняет статистический анализ метрик. Окна принадлежат горутинам
+// шардов (shard), поэтому анализ, снимки статистики и смена конфигурации
+// выполняются сообщениями без общей блокировки. Устройства распределяются
+// между шардами по хешу DeviceID (WithShards)
 type Analyzer struct {
-	mu          sync.RWMutex
-	cpuWindow   *SlidingWindow
-	rpsWindow   *SlidingWindow
-	metricsChan chan models.Metric
-	resultsChan chan models.AnalysisResult
+	shards      []*shard
+	shardCount  int
+	queue       *ring
+	resultsChan chan Analyzed
 	stopChan    chan struct{}
+	stopOnce    sync.Once
 	wg          sync.WaitGroup
+	clock       clock.Clock
+	// detector начальная конфигурация; текущая хранится в шардах
+	detector DetectorConfig
+	// devices настройки окон по устройствам; nil — только общие окна
+	devices *DeviceWindowsConfig
+	// classes определяет класс устройства для devices.Classes
+	classes ClassResolver
+
+	workersMu sync.Mutex
+	workers   []*worker
+	// nextWorker номер следующего запущенного воркера
+	nextWorker int
+	// stalled воркеры, замененные из-за зависания и еще не завершившиеся
+	stalled atomic.Int32
+	// busyTime суммарное время обработки пачек воркерами (нс); processed
+	// количество метрик, разобранных воркерами
+	busyTime  atomic.Int64
+	processed atomic.Int64
+	// onBatch вызывается воркером перед анализом пачки; задается в тестах
+	onBatch func([]models.Metric)
+	// wake будит один уснувший воркер; idle количество уснувших воркеров.
+	// Submit обращается к каналу, только когда есть уснувшие
+	wake chan struct{}
+	idle atomic.Int32
+	// running количество запущенных воркеров; без них Analyze анализирует сам
+	running atomic.Int32
+
+	draining       atomic.Bool
+	rejected       atomic.Int64
+	droppedResults atomic.Int64
+	samples        atomic.Int64
+	restored       atomic.Bool
+	deviceCounters deviceCounters
+	// counterRates поля-счетчики (WithCounters); nil — счетчиков нет
+	counterRates *counterRates
+}
+
+// ErrStopped возвращается при попытке изменить остановленный анализатор
+var ErrStopped = errors.New("analyzer is stopped")
+
+// DrainReport итог остановки анализатора
+type DrainReport struct {
+	// Processed количество метрик из очереди, обработанных во время остановки
+	Processed int
+	// DroppedQueued метрики, оставшиеся в очереди после истечения бюджета
+	DroppedQueued int
+	// Rejected метрики, отклоненные Submit с момента начала остановки
+	Rejected int64
+	// DroppedResults результаты, отброшенные из-за переполненного канала результатов
+	DroppedResults int64
+}
+
+// Option настраивает анализатор
+type Option func(*Analyzer)
+
+// WithClock задает источник времени анализатора
+func WithClock(c clock.Clock) Option {
+	return func(a *Analyzer) {
+		a.clock = c
+	}
+}
+
+// DetectorConfig параметры детектора аномалий
+type DetectorConfig struct {
+	// WindowSize размер окна для rolling average и z-score
+	WindowSize int `json:"window_size"`
+	// ZScoreThreshold порог |z|, выше которого значение считается аномальным
+	ZScoreThreshold float64 `json:"z_score_threshold"`
+	// Smoothing способ сглаживания: SmoothingSMA (по умолчанию) или SmoothingEWMA
+	Smoothing string `json:"smoothing,omitempty"`
+	// Alpha коэффициент сглаживания EWMA; 0 — 2/(WindowSize+1), что по
+	// эффективной длине памяти соответствует окну WindowSize
+	Alpha float64 `json:"alpha,omitempty"`
+	// Seasonality сезонная базовая линия: SeasonalityHourOfDay или SeasonalityHourOfWeek.
+	// z-score считается относительно ожидания для часа метрики; пустое значение —
+	// относительно окна
+	Seasonality string `json:"seasonality,omitempty"`
+	// WindowSeconds длительность окна по времени в секундах: окно содержит значения
+	// за последние WindowSeconds, сколько бы их ни было. 0 — окно из WindowSize
+	// последних значений. Только для сглаживания SMA
+	WindowSeconds float64 `json:"window_seconds,omitempty"`
+	// OpenAfter сколько событий подряд должно превысить порог, чтобы открылся
+	// эпизод аномалии; 0 и 1 — первое же превышение
+	OpenAfter int `json:"open_after,omitempty"`
+	// CloseAfter сколько нормальных событий подряд закрывают эпизод; 0 и 1 — первое же
+	CloseAfter int `json:"close_after,omitempty"`
+	// WarmupFill доля окна (от WindowSize значений, в том числе для окна по
+	// времени), которую нужно заполнить, прежде чем превышения порога считаются
+	// аномалиями: на почти пустом окне стандартное отклонение неустойчиво.
+	// 0 — без прогрева
+	WarmupFill float64 `json:"warmup_fill,omitempty"`
+}
+
+// warm сообщает, прогрето ли окно, в котором count значений
+func (c DetectorConfig) warm(count int) bool {
+	return float64(count) >= math.Ceil(c.WarmupFill*float64(c.WindowSize))
+}
+
+// WindowDuration возвращает длительность окна по времени; 0 — окно по количеству
+func (c DetectorConfig) WindowDuration() time.Duration {
+	return time.Duration(c.WindowSeconds * float64(time.Second))
+}
+
+// DefaultDetectorConfig параметры по умолчанию: окно 50 событий, порог 2σ
+func DefaultDetectorConfig() DetectorConfig {
+	return DetectorConfig{WindowSize: WindowSize, ZScoreThreshold: ZScoreThreshold}
+}
+
+// Validate проверяет параметры детектора
+func (c DetectorConfig) Validate() error {
+	if c.WindowSize < 2 {
+		return fmt.Errorf("window size must be at least 2, got %d", c.WindowSize)
+	}
+	if !(c.ZScoreThreshold > 0) || math.IsInf(c.ZScoreThreshold, 0) {
+		return fmt.Errorf("z-score threshold must be positive and finite, got %v", c.ZScoreThreshold)
+	}
+	switch c.Smoothing {
+	case "", SmoothingSMA, SmoothingEWMA:
+	default:
+		return fmt.Errorf("smoothing must be %q or %q, got %q", SmoothingSMA, SmoothingEWMA, c.Smoothing)
+	}
+	if !(c.Alpha >= 0 && c.Alpha <= 1) {
+		return fmt.Errorf("alpha must be in (0, 1] or 0 for the default, got %v", c.Alpha)
+	}
+	switch c.Seasonality {
+	case "", SeasonalityHourOfDay, SeasonalityHourOfWeek:
+	default:
+		return fmt.Errorf("seasonality must be empty, %q or %q, got %q", SeasonalityHourOfDay, SeasonalityHourOfWeek, c.Seasonality)
+	}
+	if !(c.WindowSeconds >= 0) || c.WindowSeconds > 30*24*3600 {
+		return fmt.Errorf("window seconds must be within [0, 30 days], got %v", c.WindowSeconds)
+	}
+	if c.WindowSeconds > 0 && c.WindowDuration() < time.Millisecond {
+		return fmt.Errorf("window duration must be at least 1ms, got %v", c.WindowSeconds)
+	}
+	if c.WindowSeconds > 0 && c.Smoothing == SmoothingEWMA {
+		return fmt.Errorf("window seconds apply to %q smoothing only", SmoothingSMA)
+	}
+	if c.OpenAfter < 0 || c.OpenAfter > MaxEpisodeEvents || c.CloseAfter < 0 || c.CloseAfter > MaxEpisodeEvents {
+		return fmt.Errorf("open_after and close_after must be within [0, %d], got %d and %d", MaxEpisodeEvents, c.OpenAfter, c.CloseAfter)
+	}
+	if !(c.WarmupFill >= 0 && c.WarmupFill <= 1) {
+		return fmt.Errorf("warmup fill must be within [0, 1], got %v", c.WarmupFill)
+	}
+	return nil
+}
+
+// EffectiveAlpha коэффициент сглаживания EWMA с учетом значения по умолчанию
+func (c DetectorConfig) EffectiveAlpha() float64 {
+	if c.Alpha > 0 {
+		return c.Alpha
+	}
+	return 2 / (float64(c.WindowSize) + 1)
+}
+
+// WithDetectorConfig задает параметры детектора вместо значений по умолчанию
+func WithDetectorConfig(c DetectorConfig) Option {
+	return func(a *Analyzer) {
+		a.detector = c
+	}
+}
+
+// Inherit дополняет незаданные (нулевые) параметры c параметрами base. Так
+// конфигурация класса устройств задает только то, чем класс отличается
+func (c DetectorConfig) Inherit(base DetectorConfig) DetectorConfig {
+	if c.WindowSize == 0 {
+		c.WindowSize = base.WindowSize
+	}
+	if c.ZScoreThreshold == 0 {
+		c.ZScoreThreshold = base.ZScoreThreshold
+	}
+	if c.Smoothing == "" {
+		c.Smoothing = base.Smoothing
+	}
+	if c.Alpha == 0 {
+		c.Alpha = base.Alpha
+	}
+	if c.Seasonality == "" {
+		c.Seasonality = base.Seasonality
+	}
+	if c.WindowSeconds == 0 && c.Smoothing != SmoothingEWMA {
+		c.WindowSeconds = base.WindowSeconds
+	}
+	if c.OpenAfter == 0 {
+		c.OpenAfter = base.OpenAfter
+	}
+	if c.CloseAfter == 0 {
+		c.CloseAfter = base.CloseAfter
+	}
+	if c.WarmupFill == 0 {
+		c.WarmupFill = base.WarmupFill
+	}
+	return c
+}
+
+// DeviceWindowsConfig настройки окон по устройствам
+type DeviceWindowsConfig struct {
+	// IdleTTL через сколько окна молчащего устройства удаляются
+	IdleTTL time.Duration
+	// MaxDevices наибольшее количество устройств с окнами. Новое устройство сверх
+	// лимита вытесняет окна устройства, которое дольше всех не присылало метрик
+	// (см. EvictAfter)
+	MaxDevices int
+	// MaxMemory лимит оценки памяти окон устройств в байтах; 0 — без лимита.
+	// При его исчерпании новые устройства вытесняют старые так же, как по MaxDevices
+	MaxMemory int64
+	// EvictAfter сколько устройство должно молчать, чтобы его окна можно было
+	// вытеснить ради нового. Если таких нет, метрики новых устройств
+	// анализируются по общим окнам: поток случайных идентификаторов не
+	// вытесняет окна работающих устройств. 0 — окна ради новых устройств не
+	// вытесняются, и сверх лимита они всегда анализируются по общим окнам
+	EvictAfter time.Duration
+	// Classes параметры детектора по классам устройств (шлюзы, датчики на батарейках);
+	// незаданные параметры класса берутся из общей конфигурации детектора.
+	// Класс устройства определяет ClassResolver (WithDeviceClasses)
+	Classes map[string]DetectorConfig
+}
+
+// ParseDeviceClasses разбирает JSON-объект "класс": {параметры детектора}
+// (значение DEVICE_CLASSES)
+func ParseDeviceClasses(raw string) (map[string]DetectorConfig, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var classes map[string]DetectorConfig
+	if err := json.Unmarshal([]byte(raw), &classes); err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// ClassResolver определяет класс устройства (реализуется devices.Registry).
+// Пустой класс — общая конфигурация детектора
+type ClassResolver interface {
+	ClassOf(deviceID string) string
+}
+
+// WithDeviceClasses задает, откуда берется класс устройства для
+// DeviceWindowsConfig.Classes. Класс проверяется при каждом анализе, поэтому
+// перенос устройства в другой класс перестраивает его окна на лету
+func WithDeviceClasses(r ClassResolver) Option {
+	return func(a *Analyzer) {
+		a.classes = r
+	}
+}
+
+const (
+	// DefaultDeviceIdleTTL время хранения окон молчащего устройства
+	DefaultDeviceIdleTTL = time.Hour
+	// DefaultMaxDeviceWindows лимит устройств с собственными окнами
+	DefaultMaxDeviceWindows = 10000
+	// DefaultMaxDeviceWindowMemory лимит памяти окон устройств
+	DefaultMaxDeviceWindowMemory = 256 << 20
+	// DefaultDeviceEvictAfter молчание, после которого окна устройства уступают
+	// место новому при заполненном лимите
+	DefaultDeviceEvictAfter = time.Minute
+)
+
+// Validate проверяет настройки окон по устройствам
+func (c DeviceWindowsConfig) Validate() error {
+	if c.IdleTTL <= 0 {
+		return fmt.Errorf("device window idle TTL must be positive, got %v", c.IdleTTL)
+	}
+	if c.MaxDevices < 1 {
+		return fmt.Errorf("max devices must be positive, got %d", c.MaxDevices)
+	}
+	if c.MaxMemory < 0 {
+		return fmt.Errorf("max memory must not be negative, got %d", c.MaxMemory)
+	}
+	if c.EvictAfter < 0 || c.EvictAfter > c.IdleTTL {
+		return fmt.Errorf("evict after must be between 0 and idle TTL %v, got %v", c.IdleTTL, c.EvictAfter)
+	}
+	// Параметры проверяются по отдельности, поэтому класс, корректный поверх
+	// конфигурации по умолчанию, корректен поверх любой корректной
+	for name, class := range c.Classes {
+		if name == "" {
+			return fmt.Errorf("device class name must not be empty")
+		}
+		if err := class.Inherit(DefaultDetectorConfig()).Validate(); err != nil {
+			return fmt.Errorf("device class %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// WithDeviceWindows включает окна по устройствам: z-score метрики считается по
+// окнам ее устройства, поэтому значения одного устройства не маскируют аномалии
+// другого. Общие окна продолжают получать все метрики для сводной статистики
+func WithDeviceWindows(c DeviceWindowsConfig) Option {
+	return func(a *Analyzer) {
+		a.devices = &c
+	}
 }
 
 // SlidingWindow реализует скользящее окно для хранения значений
@@ -49,8 +356,13 @@ func NewSlidingWindow(size int) *SlidingWindow {
 	}
 }
 
-// Add добавляет новое значение в окно
+// Add добавляет новое значение в окно.
+// NaN, ±Inf и значения больше MaxAbsValue отбрасываются, чтобы не испортить статистику
 func (sw *SlidingWindow) Add(value float64) {
+	if !IsValidValue(value) {
+		return
+	}
+
 	if sw.count >= sw.size {
 		// Удаляем старое значение из статистики
 		oldValue := sw.values[sw.index]
@@ -66,6 +378,21 @@ func (sw *SlidingWindow) Add(value float64) {
 	sw.sumSq += value * value
 
 	sw.index = (sw.index + 1) % sw.size
+
+	// Раз за полный оборот пересчитываем суммы с нуля, чтобы ошибка
+	// округления от вытесненных значений не накапливалась (амортизированно O(1))
+	if sw.index == 0 && sw.count == sw.size {
+		sw.resync()
+	}
+}
+
+// resync пересчитывает сумму и сумму квадратов по значениям в окне
+func (sw *SlidingWindow) resync() {
+	sw.sum, sw.sumSq = 0, 0
+	for _, v := range sw.values[:sw.count] {
+		sw.sum += v
+		sw.sumSq += v * v
+	}
 }
 
 // Mean возвращает среднее значение (rolling average)
@@ -91,11 +418,38 @@ func (sw *SlidingWindow) StdDev() float64 {
 
 // ZScore вычисляет z-score для заданного значения
 func (sw *SlidingWindow) ZScore(value float64) float64 {
-	stdDev := sw.StdDev()
-	if stdDev == 0 {
+	if !IsValidValue(value) {
 		return 0
 	}
-	return (value - sw.Mean()) / stdDev
+	return zScore(value, sw.Mean(), sw.StdDev())
+}
+
+// IsValidValue проверяет, что значение конечно и не превышает MaxAbsValue
+func IsValidValue(value float64) bool {
+	return !math.IsNaN(value) && math.Abs(value) <= MaxAbsValue
+}
+
+// Values возвращает значения окна от самого старого к самому новому
+func (sw *SlidingWindow) Values() []float64 {
+	out := make([]float64, 0, sw.count)
+	if sw.count < sw.size {
+		return append(out, sw.values[:sw.count]...)
+	}
+	out = append(out, sw.values[sw.index:]...)
+	return append(out, sw.values[:sw.index]...)
+}
+
+// resize возвращает окно нового размера с самыми свежими значениями текущего
+func (sw *SlidingWindow) resize(size int) *SlidingWindow {
+	values := sw.Values()
+	if len(values) > size {
+		values = values[len(values)-size:]
+	}
+	resized := NewSlidingWindow(size)
+	for _, v := range values {
+		resized.Add(v)
+	}
+	return resized
 }
 
 // Count возвращает количество элементов в окне
@@ -103,103 +457,482 @@ func (sw *SlidingWindow) Count() int {
 	return sw.count
 }
 
+// Percentiles перцентили значений окна
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// Percentile возвращает перцентиль q из [0, 1] значений окна с линейной
+// интерполяцией между соседними рангами; для пустого окна — 0
+func (sw *SlidingWindow) Percentile(q float64) float64 {
+	return percentile(sw.sorted(), q)
+}
+
+// Percentiles возвращает p50, p95 и p99 окна. Окно сортируется при каждом
+// вызове (O(n log n)), поэтому перцентили считаются при запросе статистики,
+// а не при добавлении значений
+func (sw *SlidingWindow) Percentiles() Percentiles {
+	sorted := sw.sorted()
+	return Percentiles{P50: percentile(sorted, 0.5), P95: percentile(sorted, 0.95), P99: percentile(sorted, 0.99)}
+}
+
+// sorted возвращает отсортированную копию значений окна
+func (sw *SlidingWindow) sorted() []float64 {
+	sorted := append([]float64(nil), sw.values[:sw.count]...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// percentile перцентиль q отсортированных значений
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	q = math.Min(math.Max(q, 0), 1)
+	rank := q * float64(len(sorted)-1)
+	lo := int(rank)
+	if lo == len(sorted)-1 {
+		return sorted[lo]
+	}
+	return sorted[lo] + (rank-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
 // NewAnalyzer создает новый анализатор метрик
-func NewAnalyzer(bufferSize int) *Analyzer {
-	return &Analyzer{
-		cpuWindow:   NewSlidingWindow(WindowSize),
-		rpsWindow:   NewSlidingWindow(WindowSize),
-		metricsChan: make(chan models.Metric, bufferSize),
-		resultsChan: make(chan models.AnalysisResult, bufferSize),
+func NewAnalyzer(bufferSize int, opts ...Option) *Analyzer {
+	a := &Analyzer{
+		queue:       newRing(bufferSize),
+		wake:        make(chan struct{}, 1),
+		resultsChan: make(chan Analyzed, bufferSize),
 		stopChan:    make(chan struct{}),
+		clock:       clock.Real(),
+		detector:    DefaultDetectorConfig(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	n := min(max(a.shardCount, 1), MaxShards)
+	devices := a.devices
+	if devices != nil && n > 1 {
+		// Лимит устройств делится между шардами с округлением вверх
+		perShard := *devices
+		perShard.MaxDevices = (devices.MaxDevices + n - 1) / n
+		perShard.MaxMemory = (devices.MaxMemory + int64(n) - 1) / int64(n)
+		devices = &perShard
 	}
+	a.shards = make([]*shard, n)
+	for i := range a.shards {
+		a.shards[i] = newShard(a.detector, devices, a.classes, a.clock, &a.samples, &a.deviceCounters, a.counterRates, n > 1)
+	}
+	return a
 }
 
 // Start запускает горутины для обработки метрик
 func (a *Analyzer) Start(numWorkers int) {
-	for i := 0; i < numWorkers; i++ {
-		a.wg.Add(1)
-		go a.worker()
+	a.workersMu.Lock()
+	defer a.workersMu.Unlock()
+	a.startWorkersLocked(numWorkers)
+}
+
+// startWorkersLocked запускает n воркеров; вызывается под workersMu
+func (a *Analyzer) startWorkersLocked(n int) {
+	for i := 0; i < n; i++ {
+		a.workers = append(a.workers, a.startWorkerLocked())
 	}
 }
 
-// worker горутина для обработки метрик
-func (a *Analyzer) worker() {
+// startWorkerLocked запускает один воркер; вызывается под workersMu
+func (a *Analyzer) startWorkerLocked() *worker {
+	w := &worker{id: a.nextWorker, quit: make(chan struct{})}
+	a.nextWorker++
+	w.beat.Store(a.clock.Now().UnixNano())
+	a.wg.Add(1)
+	// Учитывается до запуска горутины: Analyze сразу после Start ждет воркеры
+	a.running.Add(1)
+	go a.worker(w)
+	return w
+}
+
+// Workers возвращает текущее количество воркеров
+func (a *Analyzer) Workers() int {
+	a.workersMu.Lock()
+	defer a.workersMu.Unlock()
+	return len(a.workers)
+}
+
+// SetWorkers изменяет количество воркеров на лету. Лишние воркеры
+// завершаются после обработки текущей метрики
+func (a *Analyzer) SetWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("worker count must be positive, got %d", n)
+	}
+	a.workersMu.Lock()
+	defer a.workersMu.Unlock()
+	if a.draining.Load() {
+		return ErrStopped
+	}
+
+	if current := len(a.workers); n > current {
+		a.startWorkersLocked(n - current)
+	} else {
+		for _, w := range a.workers[n:] {
+			close(w.quit)
+		}
+		a.workers = a.workers[:n]
+	}
+	return nil
+}
+
+// worker горутина для обработки метрик. Метрики, уже ожидающие в очереди,
+// передаются владельцам окон одним сообщением на шард (до maxBatch штук).
+// Паника завершает только этот воркер: ожидающие его пачку получают ошибку,
+// а воркер отмечается мертвым, чтобы сторож (watchdog) запустил замену
+func (a *Analyzer) worker(w *worker) {
 	defer a.wg.Done()
+	defer a.running.Add(-1)
+	quit := w.quit
+	jobs := make([]job, 0, maxBatch)
+	defer func() {
+		w.exited.Store(true)
+		if w.stalled.CompareAndSwap(true, false) {
+			a.stalled.Add(-1)
+		}
+		p := recover()
+		if p == nil {
+			return
+		}
+		w.dead.Store(true)
+		log.Printf("Analyzer worker %d panicked: %v", w.id, p)
+		err := fmt.Errorf("%w: %v", ErrWorkerFailed, p)
+		for _, j := range jobs {
+			if j.reply != nil {
+				j.reply <- outcome{err: err}
+			}
+		}
+	}()
+	batch := make([]models.Metric, 0, maxBatch)
+	results := make([]models.AnalysisResult, maxBatch)
+	r := newRouter(len(a.shards))
 	for {
+		now := a.clock.Now().UnixNano()
+		if start := w.busy.Swap(0); start != 0 {
+			a.busyTime.Add(now - start)
+		}
+		w.beat.Store(now)
 		select {
-		case metric := <-a.metricsChan:
-			result := a.analyze(metric)
+		case <-quit:
+			return
+		case <-a.stopChan:
+			return
+		default:
+		}
+		jobs = a.queue.popBatch(jobs[:0], maxBatch)
+		if len(jobs) == 0 {
+			if !a.sleep(quit) {
+				return
+			}
+			continue
+		}
+		w.busy.Store(a.clock.Now().UnixNano())
+		if a.queue.len() > 0 {
+			// Остаток очереди разбирают другие воркеры
+			a.wakeOne()
+		}
+		batch = batch[:0]
+		for _, j := range jobs {
+			batch = append(batch, j.metric)
+		}
+		if a.onBatch != nil {
+			a.onBatch(batch)
+		}
+		if !a.analyzeBatch(r, batch, results) {
+			continue
+		}
+		a.processed.Add(int64(len(batch)))
+		for i, result := range results[:len(batch)] {
+			if reply := jobs[i].reply; reply != nil {
+				// У канала ответа одно место, и его ждет ровно одна метрика
+				jobs[i].reply = nil
+				reply <- outcome{result: result}
+				continue
+			}
 			select {
-			case a.resultsChan <- result:
+			case a.resultsChan <- Analyzed{Metric: batch[i], Result: result}:
 			default:
 				// Канал результатов переполнен, пропускаем
+				a.droppedResults.Add(1)
 			}
-		case <-a.stopChan:
-			return
 		}
 	}
 }
 
-// analyze выполняет анализ одной метрики
-func (a *Analyzer) analyze(m models.Metric) models.AnalysisResult {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	// Вычисляем z-score до добавления в окно
-	zScoreCPU := a.cpuWindow.ZScore(m.CPU)
-	zScoreRPS := a.rpsWindow.ZScore(m.RPS)
-
-	// Добавляем значения в окна
-	a.cpuWindow.Add(m.CPU)
-	a.rpsWindow.Add(m.RPS)
-
-	// Определяем аномалии по z-score (threshold > 2σ)
-	isAnomalyCPU := math.Abs(zScoreCPU) > ZScoreThreshold
-	isAnomalyRPS := math.Abs(zScoreRPS) > ZScoreThreshold
-
-	return models.AnalysisResult{
-		Timestamp:       m.Timestamp,
-		RollingAvgCPU:   a.cpuWindow.Mean(),
-		RollingAvgRPS:   a.rpsWindow.Mean(),
-		ZScoreCPU:       zScoreCPU,
-		ZScoreRPS:       zScoreRPS,
-		IsAnomalyCPU:    isAnomalyCPU,
-		IsAnomalyRPS:    isAnomalyRPS,
-		AnomalyDetected: isAnomalyCPU || isAnomalyRPS,
+// sleep усыпляет воркер до появления метрик. false — воркер должен завершиться.
+// Воркер сначала объявляет о сне, затем проверяет очередь, а Submit сначала
+// добавляет метрику, затем проверяет уснувших, поэтому метрика не остается
+// в очереди, пока все воркеры спят
+func (a *Analyzer) sleep(quit <-chan struct{}) bool {
+	a.idle.Add(1)
+	defer a.idle.Add(-1)
+	if a.queue.len() > 0 {
+		return true
+	}
+	select {
+	case <-a.wake:
+		return true
+	case <-quit:
+		return false
+	case <-a.stopChan:
+		return false
 	}
 }
 
-// Submit отправляет метрику на обработку
-func (a *Analyzer) Submit(m models.Metric) bool {
+// wakeOne будит один уснувший воркер, если такие есть
+func (a *Analyzer) wakeOne() {
+	if a.idle.Load() == 0 {
+		return
+	}
 	select {
-	case a.metricsChan <- m:
-		return true
+	case a.wake <- struct{}{}:
 	default:
+	}
+}
+
+// Submit отправляет метрику на обработку.
+// Возвращает false, если очередь заполнена или анализатор останавливается
+func (a *Analyzer) Submit(m models.Metric) bool {
+	if a.draining.Load() {
+		a.rejected.Add(1)
 		return false
 	}
+	if !a.queue.push(job{metric: m}) {
+		return false
+	}
+	a.wakeOne()
+	return true
 }
 
-// AnalyzeSync синхронно анализирует метрику
+// replies каналы ответов Analyze, переиспользуемые между вызовами
+var replies = sync.Pool{New: func() interface{} { return make(chan outcome, 1) }}
+
+// Analyze анализирует метрику воркерами через очередь и ждет результат, поэтому
+// очередь отражает нагрузку приема и воркеры разбирают ее пачками. Без
+// запущенных воркеров, во время остановки и при заполненной очереди метрика
+// анализируется сразу, как AnalyzeSync: вызывающий уже принял ее, и решать,
+// принимать ли метрики при заполненной очереди, нужно до вызова (QueueLength).
+// Ошибка ErrWorkerFailed — воркер, разбиравший метрику, упал
+func (a *Analyzer) Analyze(m models.Metric) (models.AnalysisResult, error) {
+	if a.running.Load() == 0 || a.draining.Load() {
+		return a.AnalyzeSync(m), nil
+	}
+	reply := replies.Get().(chan outcome)
+	if !a.queue.push(job{metric: m, reply: reply}) {
+		replies.Put(reply)
+		return a.AnalyzeSync(m), nil
+	}
+	a.wakeOne()
+	select {
+	case o := <-reply:
+		replies.Put(reply)
+		return o.result, o.err
+	case <-a.stopChan:
+		// Воркеры остановлены и метрику уже не разберут; канал не переиспользуется
+		return a.AnalyzeSync(m), nil
+	}
+}
+
+// AnalyzeSync синхронно анализирует метрику. После Stop метрика не анализируется
+// и результат содержит только время
 func (a *Analyzer) AnalyzeSync(m models.Metric) models.AnalysisResult {
-	return a.analyze(m)
+	resp, ok := a.shardFor(m.DeviceID).call(request{kind: analyzeRequest, metric: m})
+	if !ok {
+		if m.Timestamp.IsZero() {
+			m.Timestamp = a.clock.Now()
+		}
+		return models.AnalysisResult{Timestamp: m.Timestamp}
+	}
+	return resp.result
+}
+
+// Analyzed метрика, принятая Submit, и результат ее анализа
+type Analyzed struct {
+	Metric models.Metric
+	Result models.AnalysisResult
 }
 
-// GetResults возвращает канал результатов
-func (a *Analyzer) GetResults() <-chan models.AnalysisResult {
+// GetResults возвращает канал результатов метрик, принятых Submit
+func (a *Analyzer) GetResults() <-chan Analyzed {
 	return a.resultsChan
 }
 
-// GetStats возвращает текущую статистику
-func (a *Analyzer) GetStats() (avgCPU, avgRPS, stdDevCPU, stdDevRPS float64) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+// Snapshot возвращает согласованный срез статистики окон и конфигурации
+// детектора. При нескольких шардах срезы их общих окон объединяются; каждый
+// срез согласован, но снимаются они по очереди. После Stop возвращается
+// состояние на момент остановки
+func (a *Analyzer) Snapshot() Snapshot {
+	parts := make([]Snapshot, len(a.shards))
+	for i, s := range a.shards {
+		resp, ok := s.call(request{kind: snapshotRequest})
+		if !ok {
+			<-s.exited
+			resp.snapshot = s.final
+		}
+		parts[i] = resp.snapshot
+	}
+	return mergeSnapshots(parts)
+}
 
-	return a.cpuWindow.Mean(), a.rpsWindow.Mean(),
-		a.cpuWindow.StdDev(), a.rpsWindow.StdDev()
+// DeviceSnapshot возвращает срез окон устройства. ok == false, если окна по
+// устройствам выключены, у устройства нет окон или анализатор остановлен
+func (a *Analyzer) DeviceSnapshot(deviceID string) (Snapshot, bool) {
+	resp, ok := a.shardFor(deviceID).call(request{kind: deviceSnapshotRequest, metric: models.Metric{DeviceID: deviceID}})
+	if !ok || !resp.found {
+		return Snapshot{}, false
+	}
+	return resp.snapshot, true
 }
 
-// Stop останавливает анализатор
+// NamedStats возвращает статистику окон именованных показателей устройства
+// deviceID или, для пустого deviceID, общих окон. ok == false, если у устройства
+// нет окон или анализатор остановлен
+func (a *Analyzer) NamedStats(deviceID string) (map[string]WindowStats, bool) {
+	req := request{kind: namedStatsRequest, metric: models.Metric{DeviceID: deviceID}}
+	if deviceID == "" {
+		resps, ok := a.broadcast(req)
+		if !ok {
+			return nil, false
+		}
+		parts := make([]map[string]WindowStats, len(resps))
+		for i, resp := range resps {
+			parts[i] = resp.named
+		}
+		return mergeNamed(parts), true
+	}
+	resp, ok := a.shardFor(deviceID).call(req)
+	if !ok || !resp.found {
+		return nil, false
+	}
+	return resp.named, true
+}
+
+// GetStats возвращает текущую статистику окон устройства deviceID или, для
+// пустого deviceID, общих окон. Для устройства без окон возвращаются нули
+func (a *Analyzer) GetStats(deviceID string) (avgCPU, avgRPS, stdDevCPU, stdDevRPS float64) {
+	s := a.statsSnapshot(deviceID)
+	return s.AvgCPU, s.AvgRPS, s.StdDevCPU, s.StdDevRPS
+}
+
+// GetPercentiles возвращает перцентили окон устройства deviceID или, для пустого
+// deviceID, общих окон. ok == false при сглаживании EWMA: оно не хранит значений
+func (a *Analyzer) GetPercentiles(deviceID string) (cpu, rps Percentiles, ok bool) {
+	s := a.statsSnapshot(deviceID)
+	return s.PercentilesCPU, s.PercentilesRPS, s.HasPercentiles
+}
+
+// GetCorrelation возвращает корреляцию Пирсона CPU и RPS окон устройства
+// deviceID или, для пустого deviceID, общих окон. ok == false, пока пар меньше
+// трех или одно из значений не менялось
+func (a *Analyzer) GetCorrelation(deviceID string) (r float64, ok bool) {
+	s := a.statsSnapshot(deviceID)
+	return s.Correlation, s.HasCorrelation
+}
+
+// statsSnapshot срез окон устройства deviceID или общих окон
+func (a *Analyzer) statsSnapshot(deviceID string) Snapshot {
+	if deviceID != "" {
+		s, _ := a.DeviceSnapshot(deviceID)
+		return s
+	}
+	return a.Snapshot()
+}
+
+// Samples возвращает количество метрик, проанализированных с момента запуска
+func (a *Analyzer) Samples() int64 {
+	return a.samples.Load()
+}
+
+// MarkRestored отмечает, что окна восстановлены из сохраненного снимка
+// и анализатор готов выдавать осмысленные z-score без прогрева
+func (a *Analyzer) MarkRestored() {
+	a.restored.Store(true)
+}
+
+// Restored сообщает, были ли окна восстановлены из снимка
+func (a *Analyzer) Restored() bool {
+	return a.restored.Load()
+}
+
+// DetectorConfig возвращает параметры детектора
+func (a *Analyzer) DetectorConfig() DetectorConfig {
+	return a.Snapshot().Detector
+}
+
+// SetDetectorConfig меняет параметры детектора на лету. При смене размера
+// окна в новом окне сохраняются самые свежие значения, поэтому статистика не обнуляется.
+// При переходе с SMA на EWMA среднее и дисперсия берутся из окна, обратный
+// переход начинает окно заново
+func (a *Analyzer) SetDetectorConfig(c DetectorConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if _, ok := a.broadcast(request{kind: configureRequest, config: c}); !ok {
+		return ErrStopped
+	}
+	return nil
+}
+
+// QueueLength возвращает количество метрик, ожидающих обработки
+func (a *Analyzer) QueueLength() int {
+	return a.queue.len()
+}
+
+// QueueCapacity возвращает емкость очереди метрик
+func (a *Analyzer) QueueCapacity() int {
+	return a.queue.capacity()
+}
+
+// Stop останавливает анализатор без ожидания очереди.
+// Канал результатов закрывается после завершения воркеров
 func (a *Analyzer) Stop() {
-	close(a.stopChan)
-	a.wg.Wait()
+	a.workersMu.Lock()
+	a.draining.Store(true)
+	a.workersMu.Unlock()
+	a.stopOnce.Do(func() {
+		close(a.stopChan)
+		a.wg.Wait()
+		// Владельцы окон останавливаются последними: воркеры дорабатывают с ними текущие пачки
+		for _, s := range a.shards {
+			s.stop()
+		}
+		close(a.resultsChan)
+	})
+}
+
+// Drain прекращает прием новых метрик, дожидается обработки очереди
+// в пределах ctx и останавливает воркеры
+func (a *Analyzer) Drain(ctx context.Context) DrainReport {
+	a.draining.Store(true)
+	queued := a.queue.len()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+wait:
+	for a.queue.len() > 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	a.Stop()
+
+	remaining := a.queue.len()
+	return DrainReport{
+		Processed:      queued - remaining,
+		DroppedQueued:  remaining,
+		Rejected:       a.rejected.Load(),
+		DroppedResults: a.droppedResults.Load(),
+	}
 }
@@ -1,10 +1,15 @@
 package analytics
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
+	"sync"
 	"testing"
 	"time"
 
+	"highload-service/internal/clock"
 	"highload-service/internal/models"
 )
 
@@ -103,6 +108,26 @@ func TestSlidingWindow_ZScore(t *testing.T) {
 	}
 }
 
+func TestSlidingWindow_Percentiles(t *testing.T) {
+	sw := NewSlidingWindow(100)
+	if p := sw.Percentiles(); p != (Percentiles{}) {
+		t.Errorf("Expected zero percentiles for an empty window, got %+v", p)
+	}
+
+	// 1..100 in shuffled order; the evicted values must not count
+	sw.Add(1000)
+	for i := 0; i < 100; i++ {
+		sw.Add(float64((i*37)%100 + 1))
+	}
+	p := sw.Percentiles()
+	if math.Abs(p.P50-50.5) > 1e-9 || math.Abs(p.P95-95.05) > 1e-9 || math.Abs(p.P99-99.01) > 1e-9 {
+		t.Errorf("Unexpected percentiles %+v", p)
+	}
+	if got := sw.Percentile(1); got != 100 {
+		t.Errorf("Expected p100 to be the maximum, got %v", got)
+	}
+}
+
 func TestAnalyzer_AnomalyDetection(t *testing.T) {
 	analyzer := NewAnalyzer(100)
 
@@ -154,7 +179,7 @@ func TestAnalyzer_RollingAverage(t *testing.T) {
 		analyzer.AnalyzeSync(metric)
 	}
 
-	avgCPU, avgRPS, _, _ := analyzer.GetStats()
+	avgCPU, avgRPS, _, _ := analyzer.GetStats("")
 
 	// Check rolling averages are computed
 	if avgCPU == 0 {
@@ -198,7 +223,7 @@ func TestAnalyzer_Concurrency(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Check stats are available
-	avgCPU, avgRPS, stdDevCPU, stdDevRPS := analyzer.GetStats()
+	avgCPU, avgRPS, stdDevCPU, stdDevRPS := analyzer.GetStats("")
 	t.Logf("Stats after concurrent processing - AvgCPU: %.2f, AvgRPS: %.2f, StdDevCPU: %.2f, StdDevRPS: %.2f",
 		avgCPU, avgRPS, stdDevCPU, stdDevRPS)
 }
@@ -226,3 +251,459 @@ func BenchmarkSlidingWindowAdd(b *testing.B) {
 		sw.Add(float64(i % 100))
 	}
 }
+
+func FuzzSlidingWindow(f *testing.F) {
+	f.Add(50.0, 60.0, 70.0)
+	f.Add(math.NaN(), 1.0, 2.0)
+	f.Add(math.Inf(1), math.Inf(-1), 0.0)
+	f.Add(math.MaxFloat64, -math.MaxFloat64, 1e300)
+	f.Add(1e-300, 5e-324, -0.0)
+
+	f.Fuzz(func(t *testing.T, a, b, c float64) {
+		sw := NewSlidingWindow(3)
+		for i, v := range []float64{a, b, c, a, b, c, 42} {
+			sw.Add(v)
+			assertFinite(t, i, "mean", sw.Mean())
+			assertFinite(t, i, "stddev", sw.StdDev())
+			assertFinite(t, i, "z-score", sw.ZScore(v))
+			if sw.Count() > 3 {
+				t.Fatalf("step %d: count %d exceeds window size", i, sw.Count())
+			}
+		}
+	})
+}
+
+func FuzzAnalyzer_AnalyzeSync(f *testing.F) {
+	f.Add(50.0, 500.0)
+	f.Add(math.NaN(), math.Inf(1))
+	f.Add(-math.MaxFloat64, 1e200)
+
+	f.Fuzz(func(t *testing.T, cpu, rps float64) {
+		analyzer := NewAnalyzer(1)
+		for i := 0; i < 5; i++ {
+			analyzer.AnalyzeSync(models.Metric{Timestamp: time.Now(), CPU: 50 + float64(i), RPS: 500})
+		}
+
+		result := analyzer.AnalyzeSync(models.Metric{Timestamp: time.Now(), CPU: cpu, RPS: rps})
+		assertFinite(t, 0, "z-score cpu", result.ZScoreCPU)
+		assertFinite(t, 0, "z-score rps", result.ZScoreRPS)
+
+		// A regular metric after the fuzzed one must not be poisoned
+		result = analyzer.AnalyzeSync(models.Metric{Timestamp: time.Now(), CPU: 52, RPS: 500})
+		avgCPU, avgRPS, stdDevCPU, stdDevRPS := analyzer.GetStats("")
+		for _, v := range []float64{result.RollingAvgCPU, result.RollingAvgRPS, avgCPU, avgRPS, stdDevCPU, stdDevRPS} {
+			assertFinite(t, 1, "stats", v)
+		}
+	})
+}
+
+func assertFinite(t *testing.T, step int, name string, v float64) {
+	t.Helper()
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		t.Fatalf("step %d: %s is not finite: %v", step, name, v)
+	}
+}
+
+func TestAnalyzer_DrainProcessesQueue(t *testing.T) {
+	analyzer := NewAnalyzer(100)
+	for i := 0; i < 50; i++ {
+		analyzer.Submit(models.Metric{Timestamp: time.Now(), CPU: 50, RPS: 500})
+	}
+	analyzer.Start(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	report := analyzer.Drain(ctx)
+
+	if report.Processed != 50 || report.DroppedQueued != 0 {
+		t.Errorf("Expected 50 processed and none dropped, got %+v", report)
+	}
+	if analyzer.Submit(models.Metric{CPU: 1}) {
+		t.Error("Submit must be rejected after Drain")
+	}
+
+	results := 0
+	for range analyzer.GetResults() {
+		results++
+	}
+	if results != 50 {
+		t.Errorf("Expected 50 results before the channel closed, got %d", results)
+	}
+}
+
+func TestAnalyzer_DrainReportsDroppedOnTimeout(t *testing.T) {
+	analyzer := NewAnalyzer(10)
+	for i := 0; i < 10; i++ {
+		analyzer.Submit(models.Metric{CPU: 50, RPS: 500})
+	}
+
+	// No workers are started, so nothing can be processed within the budget
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report := analyzer.Drain(ctx)
+
+	if report.DroppedQueued != 10 || report.Processed != 0 {
+		t.Errorf("Expected all 10 queued metrics dropped, got %+v", report)
+	}
+}
+
+func TestAnalyzer_SetWorkers(t *testing.T) {
+	analyzer := NewAnalyzer(100)
+	analyzer.Start(2)
+
+	if err := analyzer.SetWorkers(5); err != nil || analyzer.Workers() != 5 {
+		t.Fatalf("Expected 5 workers, got %d (%v)", analyzer.Workers(), err)
+	}
+	if err := analyzer.SetWorkers(1); err != nil || analyzer.Workers() != 1 {
+		t.Fatalf("Expected 1 worker, got %d (%v)", analyzer.Workers(), err)
+	}
+	if err := analyzer.SetWorkers(0); err == nil {
+		t.Error("Expected error for zero workers")
+	}
+
+	// The remaining worker still processes the queue
+	for i := 0; i < 20; i++ {
+		analyzer.Submit(models.Metric{CPU: 50, RPS: 500})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if report := analyzer.Drain(ctx); report.Processed != 20 {
+		t.Errorf("Expected 20 processed after shrinking, got %+v", report)
+	}
+
+	if err := analyzer.SetWorkers(3); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped after Drain, got %v", err)
+	}
+}
+
+func TestAnalyzer_ConcurrentSnapshotsAndReconfiguration(t *testing.T) {
+	analyzer := NewAnalyzer(1000)
+	analyzer.Start(4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				analyzer.AnalyzeSync(models.Metric{CPU: 50, RPS: 500})
+				analyzer.Submit(models.Metric{CPU: 50, RPS: 500})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if s := analyzer.Snapshot(); s.Count > s.Detector.WindowSize {
+					t.Errorf("Window holds %d values, more than its size %d", s.Count, s.Detector.WindowSize)
+					return
+				}
+			}
+		}()
+		go func(size int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c := DefaultDetectorConfig()
+				c.WindowSize = size + j
+				if err := analyzer.SetDetectorConfig(c); err != nil {
+					t.Errorf("SetDetectorConfig: %v", err)
+					return
+				}
+			}
+		}(10 + i*10)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	analyzer.Drain(ctx)
+
+	// The state at shutdown stays readable, but the analyzer no longer changes
+	before := analyzer.Snapshot()
+	if before.Count == 0 {
+		t.Fatal("Expected windows to hold values after the run")
+	}
+	result := analyzer.AnalyzeSync(models.Metric{CPU: 99, RPS: 9999})
+	if result.AnomalyDetected || result.Timestamp.IsZero() {
+		t.Errorf("Expected an empty timestamped result after stop, got %+v", result)
+	}
+	if after := analyzer.Snapshot(); after != before {
+		t.Errorf("Expected snapshot to stay %+v after stop, got %+v", before, after)
+	}
+	if err := analyzer.SetDetectorConfig(DefaultDetectorConfig()); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped after stop, got %v", err)
+	}
+}
+
+func TestAnalyzer_DeviceWindows(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	analyzer := NewAnalyzer(1, WithClock(clk), WithDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 2}))
+	defer analyzer.Stop()
+
+	// A noisy device must not mask a jump on a steady one
+	for i := 0; i < 10; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "noisy", CPU: float64(i%2) * 90, RPS: 100})
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "steady", CPU: 10 + float64(i%2), RPS: 100})
+	}
+	if result := analyzer.AnalyzeSync(models.Metric{DeviceID: "steady", CPU: 60, RPS: 100}); !result.IsAnomalyCPU {
+		t.Errorf("Expected an anomaly against the steady device's own window, got z=%.2f", result.ZScoreCPU)
+	}
+
+	if avgCPU, _, _, _ := analyzer.GetStats("noisy"); avgCPU != 45 {
+		t.Errorf("Expected noisy average 45, got %v", avgCPU)
+	}
+	if cpu, _, ok := analyzer.GetPercentiles("noisy"); !ok || cpu.P50 != 45 || cpu.P99 != 90 {
+		t.Errorf("Expected noisy percentiles p50=45 p99=90, got %+v (ok %v)", cpu, ok)
+	}
+	if avgCPU, _, _, _ := analyzer.GetStats("missing"); avgCPU != 0 {
+		t.Errorf("Expected zeros for an unknown device, got %v", avgCPU)
+	}
+	if s := analyzer.Snapshot(); s.Devices != 2 || s.Count != 10 {
+		t.Errorf("Expected 2 devices and a full shared window, got %+v", s)
+	}
+
+	// Over the limit new devices fall back to the shared windows
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "third", CPU: 1})
+	if _, ok := analyzer.DeviceSnapshot("third"); ok {
+		t.Error("Expected no windows for a device over the limit")
+	}
+
+	// Resizing applies to device windows too; idle devices are evicted
+	if err := analyzer.SetDetectorConfig(DetectorConfig{WindowSize: 4, ZScoreThreshold: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := analyzer.DeviceSnapshot("steady"); !ok || s.Count != 4 {
+		t.Errorf("Expected resized steady windows, got %+v", s)
+	}
+	clk.Advance(30 * time.Minute)
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "steady", CPU: 10})
+	clk.Advance(45 * time.Minute)
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "third", CPU: 1})
+	if _, ok := analyzer.DeviceSnapshot("noisy"); ok {
+		t.Error("Expected idle device windows to be evicted")
+	}
+	if _, ok := analyzer.DeviceSnapshot("third"); !ok {
+		t.Error("Expected a new device to get windows after eviction")
+	}
+}
+
+// classMap resolves device classes from a map
+type classMap map[string]string
+
+func (m classMap) ClassOf(id string) string { return m[id] }
+
+func TestAnalyzer_DeviceClasses(t *testing.T) {
+	classes := classMap{"gw-1": "gateway"}
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 2}),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 10, Classes: map[string]DetectorConfig{
+			"gateway": {WindowSize: 4, ZScoreThreshold: 50},
+		}}),
+		WithDeviceClasses(classes))
+	defer analyzer.Stop()
+
+	for i := 0; i < 10; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "gw-1", CPU: float64(40 + i%2), RPS: 100})
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: float64(40 + i%2), RPS: 100})
+	}
+	if r := analyzer.AnalyzeSync(models.Metric{DeviceID: "gw-1", CPU: 60, RPS: 100}); r.AnomalyDetected {
+		t.Errorf("Expected the gateway threshold to tolerate the jump, got z=%.2f", r.ZScoreCPU)
+	}
+	if r := analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 60, RPS: 100}); !r.IsAnomalyCPU {
+		t.Errorf("Expected the default threshold to flag the jump, got z=%.2f", r.ZScoreCPU)
+	}
+	if s, _ := analyzer.DeviceSnapshot("gw-1"); s.Count != 4 || s.Detector.WindowSize != 4 || s.Detector.ZScoreThreshold != 50 {
+		t.Errorf("Expected gateway windows of 4 with threshold 50, got %+v", s)
+	}
+
+	// Unset class parameters follow the global configuration
+	if err := analyzer.SetDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 2, Smoothing: SmoothingEWMA}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := analyzer.DeviceSnapshot("gw-1"); s.Detector.Smoothing != SmoothingEWMA || s.Detector.WindowSize != 4 {
+		t.Errorf("Expected the gateway class to inherit smoothing, got %+v", s.Detector)
+	}
+
+	// Moving a device to another class reconfigures its windows on the next metric
+	delete(classes, "gw-1")
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "gw-1", CPU: 40, RPS: 100})
+	if s, _ := analyzer.DeviceSnapshot("gw-1"); s.Detector.ZScoreThreshold != 2 || s.Detector.WindowSize != 10 {
+		t.Errorf("Expected the global detector after leaving the class, got %+v", s.Detector)
+	}
+
+	bad := DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 1, Classes: map[string]DetectorConfig{"gateway": {WindowSize: 1}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("Expected a class with window size 1 to be rejected")
+	}
+}
+
+func TestAnalyzer_NamedValues(t *testing.T) {
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 10}))
+	defer analyzer.Stop()
+
+	// A new metric name gets its own window without any registration
+	for i := 0; i < 10; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100,
+			Values: map[string]float64{"temperature": float64(40 + i%2), "memory": 512}})
+	}
+	r := analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100,
+		Values: map[string]float64{"temperature": 90, "memory": 512}})
+	if !r.Values["temperature"].IsAnomaly || r.Values["memory"].IsAnomaly || !r.AnomalyDetected {
+		t.Errorf("Expected only the temperature spike to be anomalous, got %+v", r.Values)
+	}
+	if r.IsAnomalyCPU || r.IsAnomalyRPS {
+		t.Error("Expected cpu and rps to stay normal")
+	}
+
+	named, ok := analyzer.NamedStats("sensor-1")
+	if !ok || len(named) != 2 || named["memory"].Count != 10 || named["memory"].Avg != 512 {
+		t.Errorf("Expected per-device windows for both names, got %+v", named)
+	}
+	if shared, _ := analyzer.NamedStats(""); shared["temperature"].Count != 10 {
+		t.Errorf("Expected the shared window to receive the values too, got %+v", shared)
+	}
+	if _, ok := analyzer.NamedStats("unknown"); ok {
+		t.Error("Expected no stats for a device without windows")
+	}
+
+	// Names beyond the cap are not analyzed
+	values := make(map[string]float64)
+	for i := 0; i < MaxNamedWindows; i++ {
+		values[fmt.Sprintf("sensor_%d", i)] = 1
+	}
+	r = analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100, Values: values})
+	if len(r.Values) != MaxNamedWindows-2 {
+		t.Errorf("Expected %d analyzed names, got %d", MaxNamedWindows-2, len(r.Values))
+	}
+
+	// Metrics without named values do not produce the map
+	if r := analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100}); r.Values != nil {
+		t.Error("Expected no values in the result")
+	}
+}
+
+func TestAnalyzer_DeviceWindowEviction(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	detector := DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}
+	analyzer := NewAnalyzer(1, WithClock(clk), WithDetectorConfig(detector),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 2, EvictAfter: time.Minute}))
+	defer analyzer.Stop()
+
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "a", CPU: 1})
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "b", CPU: 1})
+	clk.Advance(2 * time.Minute)
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "b", CPU: 1})
+
+	// The least recently seen device gives way to a new one
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "c", CPU: 1})
+	if _, ok := analyzer.DeviceSnapshot("a"); ok {
+		t.Error("Expected the least recently seen device evicted")
+	}
+	// A burst of new IDs does not evict devices that reported recently
+	for i := 0; i < 5; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: fmt.Sprintf("uuid-%d", i), CPU: 1})
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, ok := analyzer.DeviceSnapshot(id); !ok {
+			t.Errorf("Expected recent device %s kept", id)
+		}
+	}
+	stats := analyzer.DeviceWindowStats()
+	if stats.Devices != 2 || stats.Evicted[EvictionCapacity] != 1 || stats.Overflow != 5 || stats.Bytes <= 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	clk.Advance(2 * time.Hour)
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "d", CPU: 1})
+	if stats := analyzer.DeviceWindowStats(); stats.Devices != 1 || stats.Evicted[EvictionIdle] != 2 {
+		t.Errorf("Expected idle devices evicted, got %+v", stats)
+	}
+
+	// The memory limit evicts like the device limit, by its own estimate
+	limited := NewAnalyzer(1, WithClock(clk), WithDetectorConfig(detector), WithDeviceWindows(DeviceWindowsConfig{
+		IdleTTL: time.Hour, MaxDevices: 100, EvictAfter: time.Minute, MaxMemory: 3 * newDeviceBytes("x", detector) / 2,
+	}))
+	defer limited.Stop()
+	limited.AnalyzeSync(models.Metric{DeviceID: "x", CPU: 1})
+	clk.Advance(2 * time.Minute)
+	limited.AnalyzeSync(models.Metric{DeviceID: "y", CPU: 1})
+	if stats := limited.DeviceWindowStats(); stats.Devices != 1 || stats.Evicted[EvictionMemory] != 1 {
+		t.Errorf("Expected an eviction by memory, got %+v", stats)
+	}
+	if _, ok := limited.DeviceSnapshot("y"); !ok {
+		t.Error("Expected the new device to get windows")
+	}
+}
+
+func TestAnalyzer_Counters(t *testing.T) {
+	analyzer := NewAnalyzer(1, WithCounters(CounterRPS, "requests"))
+	defer analyzer.Stop()
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metric := func(offset time.Duration, rps, requests float64) models.Metric {
+		return models.Metric{DeviceID: "d1", Timestamp: t0.Add(offset), CPU: 10, RPS: rps,
+			Values: map[string]float64{"requests": requests, "temperature": 20}}
+	}
+
+	// The first metric only primes the counters
+	if r := analyzer.AnalyzeSync(metric(0, 1000, 5)); !r.WarmingUp || r.RollingAvgRPS != 0 || analyzer.Samples() != 0 {
+		t.Errorf("Expected the first metric to prime the counters, got %+v", r)
+	}
+	m := metric(10*time.Second, 1500, 25)
+	r := analyzer.AnalyzeSync(m)
+	if r.RollingAvgRPS != 50 || r.Values["requests"].RollingAvg != 2 || r.Values["temperature"].RollingAvg != 20 {
+		t.Errorf("Expected rates per second, got %+v", r)
+	}
+	if m.Values["requests"] != 25 {
+		t.Error("The caller's values must not be modified")
+	}
+
+	// A device restart resets the counter: the increase counts from zero
+	if r := analyzer.AnalyzeSync(metric(20*time.Second, 100, 5)); r.RollingAvgRPS != 30 || analyzer.CounterResets() != 2 {
+		t.Errorf("Expected a reset to count from zero, got %+v and %d resets", r, analyzer.CounterResets())
+	}
+	// A repeated timestamp has no interval to spread the increase over
+	if r := analyzer.AnalyzeSync(metric(20*time.Second, 200, 6)); !r.WarmingUp || r.RollingAvgRPS != 30 {
+		t.Errorf("Expected a stale metric to be skipped, got %+v", r)
+	}
+	if r := analyzer.AnalyzeSync(metric(30*time.Second, 200, 15)); math.Abs(r.RollingAvgRPS-70.0/3) > 1e-9 || math.Abs(r.Values["requests"].RollingAvg-3.5/3) > 1e-9 {
+		t.Errorf("Expected the counters to continue after the stale metric, got %+v", r)
+	}
+
+	if err := ValidateCounterFields([]string{"rps", "rps"}); err == nil {
+		t.Error("Expected duplicate counter fields to be rejected")
+	}
+}
+
+func TestAnalyzer_CountersFollowDeviceWindows(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	analyzer := NewAnalyzer(1, WithClock(clk), WithCounters(CounterRPS),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: 24 * time.Hour, MaxDevices: 1, EvictAfter: time.Minute}))
+	defer analyzer.Stop()
+	counted := func() int {
+		return len(analyzer.shards[0].counterValues)
+	}
+
+	plain := NewAnalyzer(1, WithClock(clk), WithDeviceWindows(DeviceWindowsConfig{IdleTTL: 24 * time.Hour, MaxDevices: 1}))
+	defer plain.Stop()
+	for _, a := range []*Analyzer{analyzer, plain} {
+		a.AnalyzeSync(models.Metric{DeviceID: "a", Timestamp: clk.Now(), RPS: 100})
+	}
+	withCounters := analyzer.DeviceWindowStats().Bytes
+	if without := plain.DeviceWindowStats().Bytes; withCounters <= without {
+		t.Errorf("Expected the counter values in the memory estimate, got %d bytes, %d without counters", withCounters, without)
+	}
+
+	// Evicting the windows drops the counter values with them
+	clk.Advance(2 * time.Minute)
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "b", Timestamp: clk.Now(), RPS: 100})
+	if _, ok := analyzer.shards[0].counterValues["a"]; ok || counted() != 1 {
+		t.Errorf("Expected only the counters of b kept, got %d devices", counted())
+	}
+	// Devices left without windows keep no counter values either
+	for i := 0; i < 100; i++ {
+		analyzer.AnalyzeSync(models.Metric{DeviceID: fmt.Sprintf("uuid-%d", i), Timestamp: clk.Now(), RPS: 1})
+	}
+	if stats := analyzer.DeviceWindowStats(); counted() != 1 || stats.Overflow != 100 || stats.Bytes != withCounters {
+		t.Errorf("Expected the overflowing devices to keep no counters, got %d devices and %+v", counted(), stats)
+	}
+}
@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxTransitions количество хранимых переходов в истории Canary
+const maxTransitions = 100
+
+var (
+	// ErrNothingToPromote теневая конфигурация совпадает с основной
+	ErrNothingToPromote = errors.New("shadow configuration is identical to primary")
+	// ErrNoRollback нет предыдущей конфигурации для отката
+	ErrNoRollback = errors.New("no previous primary configuration to roll back to")
+)
+
+// ConfigChange отличие одного параметра детектора
+type ConfigChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// DiffConfigs перечисляет отличающиеся параметры двух конфигураций
+func DiffConfigs(old, new DetectorConfig) []ConfigChange {
+	changes := []ConfigChange{}
+	if old.WindowSize != new.WindowSize {
+		changes = append(changes, ConfigChange{Field: "window_size", Old: old.WindowSize, New: new.WindowSize})
+	}
+	if old.ZScoreThreshold != new.ZScoreThreshold {
+		changes = append(changes, ConfigChange{Field: "z_score_threshold", Old: old.ZScoreThreshold, New: new.ZScoreThreshold})
+	}
+	return changes
+}
+
+// Transition запись о смене основной конфигурации
+type Transition struct {
+	Time   time.Time      `json:"time"`
+	Action string         `json:"action"`
+	Actor  string         `json:"actor"`
+	From   DetectorConfig `json:"from"`
+	To     DetectorConfig `json:"to"`
+	Diff   []ConfigChange `json:"diff"`
+}
+
+// CanaryStatus текущее состояние основной и теневой конфигураций
+type CanaryStatus struct {
+	Primary     DetectorConfig   `json:"primary"`
+	Shadow      DetectorConfig   `json:"shadow"`
+	Diff        []ConfigChange   `json:"diff"`
+	Comparison  ExperimentReport `json:"comparison"`
+	CanRollback bool             `json:"can_rollback"`
+}
+
+// Canary управляет продвижением теневой конфигурации детектора в основную.
+// Теневая конфигурация — B эксперимента, A должна совпадать с основной.
+// При продвижении A и B меняются местами, так что бывшая основная
+// конфигурация продолжает работать в тени и к ней можно откатиться
+type Canary struct {
+	mu       sync.Mutex
+	primary  *Analyzer
+	shadow   *Experiment
+	previous []DetectorConfig
+	history  []Transition
+}
+
+// NewCanary связывает основной анализатор с экспериментом
+func NewCanary(primary *Analyzer, shadow *Experiment) *Canary {
+	return &Canary{primary: primary, shadow: shadow}
+}
+
+// Status возвращает текущие конфигурации и результаты сравнения
+func (c *Canary) Status() CanaryStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	primary := c.primary.DetectorConfig()
+	_, shadow := c.shadow.Configs()
+	return CanaryStatus{
+		Primary:     primary,
+		Shadow:      shadow,
+		Diff:        DiffConfigs(primary, shadow),
+		Comparison:  c.shadow.Report(),
+		CanRollback: len(c.previous) > 0,
+	}
+}
+
+// Promote делает теневую конфигурацию основной
+func (c *Canary) Promote(actor string) (Transition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from := c.primary.DetectorConfig()
+	_, to := c.shadow.Configs()
+	if from == to {
+		return Transition{}, ErrNothingToPromote
+	}
+	if err := c.primary.SetDetectorConfig(to); err != nil {
+		return Transition{}, err
+	}
+	c.shadow.Swap()
+	c.previous = append(c.previous, from)
+	return c.recordLocked("promote", actor, from, to), nil
+}
+
+// Rollback возвращает основную конфигурацию, действовавшую до последнего продвижения
+func (c *Canary) Rollback(actor string) (Transition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.previous) == 0 {
+		return Transition{}, ErrNoRollback
+	}
+	from := c.primary.DetectorConfig()
+	to := c.previous[len(c.previous)-1]
+	if err := c.primary.SetDetectorConfig(to); err != nil {
+		return Transition{}, err
+	}
+	c.shadow.Swap()
+	c.previous = c.previous[:len(c.previous)-1]
+	return c.recordLocked("rollback", actor, from, to), nil
+}
+
+// History возвращает переходы от старых к новым
+func (c *Canary) History() []Transition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(make([]Transition, 0, len(c.history)), c.history...)
+}
+
+// recordLocked добавляет переход в историю и лог; вызывается под mu
+func (c *Canary) recordLocked(action, actor string, from, to DetectorConfig) Transition {
+	t := Transition{
+		Time:   c.primary.clock.Now().UTC(),
+		Action: action,
+		Actor:  actor,
+		From:   from,
+		To:     to,
+		Diff:   DiffConfigs(from, to),
+	}
+	c.history = append(c.history, t)
+	if len(c.history) > maxTransitions {
+		c.history = c.history[len(c.history)-maxTransitions:]
+	}
+	log.Printf("Detector %s by %s: %+v -> %+v", action, actor, from, to)
+	return t
+}
@@ -0,0 +1,68 @@
+package analytics
+
+import (
+	"errors"
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestCanary_PromoteAndRollback(t *testing.T) {
+	primaryCfg := DetectorConfig{WindowSize: 5, ZScoreThreshold: 2}
+	shadowCfg := DetectorConfig{WindowSize: 3, ZScoreThreshold: 3}
+
+	primary := NewAnalyzer(1, WithDetectorConfig(primaryCfg))
+	experiment := NewExperiment(primaryCfg, shadowCfg, 10)
+	defer experiment.Stop()
+	canary := NewCanary(primary, experiment)
+
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		primary.AnalyzeSync(models.Metric{CPU: v, RPS: v})
+	}
+
+	if _, err := canary.Rollback("ops"); !errors.Is(err, ErrNoRollback) {
+		t.Fatalf("Expected ErrNoRollback before any promotion, got %v", err)
+	}
+
+	tr, err := canary.Promote("ops")
+	if err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	if primary.DetectorConfig() != shadowCfg || len(tr.Diff) != 2 {
+		t.Fatalf("Expected shadow config to become primary with 2 changes, got %+v", tr)
+	}
+	// The resized window keeps the 3 most recent values (3, 4, 5)
+	if avg, _, _, _ := primary.GetStats(""); avg != 4 {
+		t.Errorf("Expected window to keep recent values, got mean %v", avg)
+	}
+
+	status := canary.Status()
+	if status.Primary != shadowCfg || status.Shadow != primaryCfg || !status.CanRollback {
+		t.Errorf("Expected old primary to run in shadow, got %+v", status)
+	}
+
+	if _, err := canary.Rollback("ops"); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if primary.DetectorConfig() != primaryCfg {
+		t.Errorf("Expected original config after rollback, got %+v", primary.DetectorConfig())
+	}
+	if _, shadow := experiment.Configs(); shadow != shadowCfg {
+		t.Errorf("Expected candidate back in shadow, got %+v", shadow)
+	}
+
+	history := canary.History()
+	if len(history) != 2 || history[0].Action != "promote" || history[1].Action != "rollback" {
+		t.Errorf("Unexpected history %+v", history)
+	}
+}
+
+func TestCanary_NothingToPromote(t *testing.T) {
+	cfg := DefaultDetectorConfig()
+	experiment := NewExperiment(cfg, cfg, 1)
+	defer experiment.Stop()
+
+	if _, err := NewCanary(NewAnalyzer(1), experiment).Promote("ops"); !errors.Is(err, ErrNothingToPromote) {
+		t.Errorf("Expected ErrNothingToPromote, got %v", err)
+	}
+}
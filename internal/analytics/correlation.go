@@ -0,0 +1,134 @@
+package analytics
+
+import "math"
+
+// minCorrelationPairs наименьшее количество пар, по которому считается корреляция
+const minCorrelationPairs = 3
+
+// varianceEpsilon относительная дисперсия, ниже которой значение считается постоянным
+const varianceEpsilon = 1e-12
+
+// Correlation скользящий коэффициент корреляции Пирсона между CPU и RPS по
+// последним size парам значений одной метрики. Пары хранятся отдельно от окон
+// детектора, поэтому корреляция одинакова для SMA, EWMA и окна по времени.
+// Падение корреляции (RPS снижается, а CPU остается высоким) — признак
+// процесса, который потребляет CPU без полезной нагрузки
+type Correlation struct {
+	cpu, rps []float64
+	size     int
+	index    int
+	count    int
+	// Суммы значений, квадратов и произведений пар
+	sumCPU, sumRPS, sumCPU2, sumRPS2, sumCross float64
+}
+
+// NewCorrelation создает корреляцию по последним size парам
+func NewCorrelation(size int) *Correlation {
+	return &Correlation{cpu: make([]float64, size), rps: make([]float64, size), size: size}
+}
+
+// Add добавляет пару значений. Пара, в которой хотя бы одно значение
+// некорректно, отбрасывается целиком
+func (c *Correlation) Add(cpu, rps float64) {
+	if !IsValidValue(cpu) || !IsValidValue(rps) {
+		return
+	}
+	if c.count >= c.size {
+		oldCPU, oldRPS := c.cpu[c.index], c.rps[c.index]
+		c.sumCPU -= oldCPU
+		c.sumRPS -= oldRPS
+		c.sumCPU2 -= oldCPU * oldCPU
+		c.sumRPS2 -= oldRPS * oldRPS
+		c.sumCross -= oldCPU * oldRPS
+	} else {
+		c.count++
+	}
+	c.cpu[c.index], c.rps[c.index] = cpu, rps
+	c.sumCPU += cpu
+	c.sumRPS += rps
+	c.sumCPU2 += cpu * cpu
+	c.sumRPS2 += rps * rps
+	c.sumCross += cpu * rps
+
+	c.index = (c.index + 1) % c.size
+	// Как и в SlidingWindow, суммы пересчитываются раз за полный оборот
+	if c.index == 0 && c.count == c.size {
+		c.resync()
+	}
+}
+
+func (c *Correlation) resync() {
+	c.sumCPU, c.sumRPS, c.sumCPU2, c.sumRPS2, c.sumCross = 0, 0, 0, 0, 0
+	for i := 0; i < c.count; i++ {
+		c.sumCPU += c.cpu[i]
+		c.sumRPS += c.rps[i]
+		c.sumCPU2 += c.cpu[i] * c.cpu[i]
+		c.sumRPS2 += c.rps[i] * c.rps[i]
+		c.sumCross += c.cpu[i] * c.rps[i]
+	}
+}
+
+// Coefficient возвращает коэффициент из [-1, 1]. ok == false, пока пар меньше
+// minCorrelationPairs или одно из значений не менялось: тогда корреляция не определена
+func (c *Correlation) Coefficient() (r float64, ok bool) {
+	return c.sums().coefficient()
+}
+
+// correlationSums количество пар и суммы, по которым считается корреляция.
+// Суммы непересекающихся наборов пар складываются, поэтому корреляция
+// нескольких шардов считается по объединению их пар
+type correlationSums struct {
+	n, cpu, rps, cpu2, rps2, cross float64
+}
+
+// sums возвращает суммы текущих пар
+func (c *Correlation) sums() correlationSums {
+	return correlationSums{n: float64(c.count), cpu: c.sumCPU, rps: c.sumRPS, cpu2: c.sumCPU2, rps2: c.sumRPS2, cross: c.sumCross}
+}
+
+// add добавляет суммы другого набора пар
+func (p *correlationSums) add(o correlationSums) {
+	p.n += o.n
+	p.cpu += o.cpu
+	p.rps += o.rps
+	p.cpu2 += o.cpu2
+	p.rps2 += o.rps2
+	p.cross += o.cross
+}
+
+func (p correlationSums) coefficient() (r float64, ok bool) {
+	if p.n < minCorrelationPairs {
+		return 0, false
+	}
+	n := p.n
+	varCPU := n*p.cpu2 - p.cpu*p.cpu
+	varRPS := n*p.rps2 - p.rps*p.rps
+	// Ошибка округления не должна выдавать постоянное значение за меняющееся
+	if varCPU <= varianceEpsilon*n*p.cpu2 || varRPS <= varianceEpsilon*n*p.rps2 {
+		return 0, false
+	}
+	r = (n*p.cross - p.cpu*p.rps) / math.Sqrt(varCPU*varRPS)
+	return math.Max(-1, math.Min(1, r)), true
+}
+
+// Count возвращает количество пар
+func (c *Correlation) Count() int {
+	return c.count
+}
+
+// resize возвращает корреляцию по последним size парам с самыми свежими парами текущей
+func (c *Correlation) resize(size int) *Correlation {
+	if size == c.size {
+		return c
+	}
+	resized := NewCorrelation(size)
+	start := c.index
+	if c.count < c.size {
+		start = 0
+	}
+	for i := max(0, c.count-size); i < c.count; i++ {
+		j := (start + i) % c.size
+		resized.Add(c.cpu[j], c.rps[j])
+	}
+	return resized
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestCorrelation_Coefficient(t *testing.T) {
+	c := NewCorrelation(4)
+	if _, ok := c.Coefficient(); ok {
+		t.Error("Expected no correlation for an empty window")
+	}
+
+	for i := 1; i <= 4; i++ {
+		c.Add(float64(10*i), float64(100*i))
+	}
+	if r, ok := c.Coefficient(); !ok || math.Abs(r-1) > 1e-9 {
+		t.Errorf("Expected a perfect positive correlation, got %v %v", r, ok)
+	}
+
+	// An invalid value drops the whole pair
+	c.Add(math.NaN(), 1000)
+	if c.Count() != 4 {
+		t.Errorf("Expected the invalid pair to be dropped, got %d pairs", c.Count())
+	}
+
+	// The window slides: after four opposite pairs the correlation is negative
+	for i := 1; i <= 4; i++ {
+		c.Add(float64(10*i), float64(400-100*i))
+	}
+	if r, ok := c.Coefficient(); !ok || math.Abs(r+1) > 1e-9 {
+		t.Errorf("Expected a perfect negative correlation, got %v %v", r, ok)
+	}
+
+	// A constant value leaves the correlation undefined
+	for i := 0; i < 4; i++ {
+		c.Add(0.1, float64(i))
+	}
+	if r, ok := c.Coefficient(); ok {
+		t.Errorf("Expected no correlation for a constant CPU, got %v", r)
+	}
+
+	resized := NewCorrelation(8)
+	for i := 1; i <= 8; i++ {
+		resized.Add(float64(i), float64(i*i))
+	}
+	if got := resized.resize(3); got.Count() != 3 || got.cpu[0] != 6 {
+		t.Errorf("Expected the three most recent pairs to be kept, got %+v", got)
+	}
+}
+
+func TestAnalyzer_ReportsDecorrelation(t *testing.T) {
+	analyzer := NewAnalyzer(10)
+	defer analyzer.Stop()
+
+	// CPU follows the load while the service is healthy
+	for i := 0; i < WindowSize; i++ {
+		load := float64(i % 10)
+		analyzer.AnalyzeSync(models.Metric{CPU: 40 + 2*load, RPS: 1000 + 100*load})
+	}
+	healthy, ok := analyzer.GetCorrelation("")
+	if !ok || healthy < 0.99 {
+		t.Fatalf("Expected CPU and RPS to be correlated, got %v %v", healthy, ok)
+	}
+
+	// A runaway process keeps CPU growing while RPS falls
+	var result models.AnalysisResult
+	for i := 0; i < WindowSize; i++ {
+		result = analyzer.AnalyzeSync(models.Metric{CPU: 60 + float64(i)/10, RPS: 1000 - 10*float64(i)})
+	}
+	if result.Correlation > -0.9 {
+		t.Errorf("Expected the decorrelation to show in the result, got %v", result.Correlation)
+	}
+}
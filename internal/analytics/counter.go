@@ -0,0 +1,153 @@
+package analytics
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// Имена основных полей метрики для WithCounters; остальные имена относятся к
+// показателям values
+const (
+	CounterCPU = "cpu"
+	CounterRPS = "rps"
+)
+
+// counterIdleTTL через сколько забываются значения счетчиков молчащего устройства:
+// следующая метрика устройства снова только запоминает значения
+const counterIdleTTL = time.Hour
+
+// WithCounters объявляет поля метрики счетчиками: cpu, rps или имена показателей
+// values. Устройство присылает в них накопленное значение (запросов с запуска), и
+// перед окнами оно переводится в скорость — прирост в секунду со времени прошлой
+// метрики устройства. Уменьшение значения считается сбросом счетчика (перезапуск
+// устройства), и прирост отсчитывается от нуля. Первая метрика устройства только
+// запоминает значения: показатели-счетчики в ее результате отсутствуют, а при
+// счетчике cpu или rps метрика не анализируется и получает WarmingUp. С
+// WithDeviceWindows значения счетчиков учитываются в памяти окон устройства и
+// вытесняются вместе с ними; устройство, которому не хватило места для окон,
+// значений не хранит
+func WithCounters(fields ...string) Option {
+	return func(a *Analyzer) {
+		if len(fields) == 0 {
+			return
+		}
+		a.counterRates = &counterRates{fields: make(map[string]bool, len(fields))}
+		for _, f := range fields {
+			a.counterRates.fields[f] = true
+		}
+	}
+}
+
+// ValidateCounterFields проверяет имена полей-счетчиков
+func ValidateCounterFields(fields []string) error {
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if f == "" || len(f) > models.MaxValueNameLength {
+			return fmt.Errorf("invalid counter field %q", f)
+		}
+		if seen[f] {
+			return fmt.Errorf("duplicate counter field %q", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
+
+// CounterResets количество сбросов счетчиков с запуска; 0 без WithCounters
+func (a *Analyzer) CounterResets() int64 {
+	if a.counterRates == nil {
+		return 0
+	}
+	return a.counterRates.resets.Load()
+}
+
+// counterRates поля-счетчики и счетчик сбросов, общие для шардов анализатора
+type counterRates struct {
+	fields map[string]bool
+	resets atomic.Int64
+}
+
+// counterValues последние значения счетчиков устройства
+type counterValues struct {
+	// at время метрики, с которой сняты values
+	at       time.Time
+	values   map[string]float64
+	lastSeen time.Time
+}
+
+// toRates заменяет значения полей-счетчиков метрики скоростью. Карта values
+// копируется: она принадлежит отправителю. false — скорость cpu или rps еще не
+// известна (первая метрика устройства или метрика не новее предыдущей), и
+// метрика не анализируется
+func (s *shard) toRates(m *models.Metric) bool {
+	now := s.clock.Now()
+	if now.Sub(s.counterSweep) >= counterIdleTTL/sweepsPerTTL {
+		s.sweepCounters(now)
+	}
+	c, seen := s.counterValues[m.DeviceID]
+	if !seen {
+		c = &counterValues{values: make(map[string]float64)}
+		s.counterValues[m.DeviceID] = c
+	}
+	c.lastSeen = now
+	// Метрика не новее предыдущей (повтор, опоздание): прирост не к чему
+	// отнести, и значения не запоминаются, чтобы не принять их за сброс
+	stale := seen && !m.Timestamp.After(c.at)
+	dt := m.Timestamp.Sub(c.at).Seconds()
+	fields := s.counterRates.fields
+	rate := func(name string, v float64) (float64, bool) {
+		if stale {
+			return 0, false
+		}
+		prev, ok := c.values[name]
+		c.values[name] = v
+		if !ok {
+			return 0, false
+		}
+		delta := v - prev
+		if delta < 0 {
+			s.counterRates.resets.Add(1)
+			delta = v
+		}
+		return delta / dt, true
+	}
+
+	ready := true
+	var ok bool
+	if fields[CounterCPU] {
+		m.CPU, ok = rate(CounterCPU, m.CPU)
+		ready = ready && ok
+	}
+	if fields[CounterRPS] {
+		m.RPS, ok = rate(CounterRPS, m.RPS)
+		ready = ready && ok
+	}
+	if len(m.Values) > 0 {
+		values := make(map[string]float64, len(m.Values))
+		for name, v := range m.Values {
+			if !fields[name] {
+				values[name] = v
+			} else if r, ok := rate(name, v); ok {
+				values[name] = r
+			}
+		}
+		m.Values = values
+	}
+	if !stale {
+		c.at = m.Timestamp
+	}
+	return ready
+}
+
+// sweepCounters забывает значения счетчиков устройств, молчащих дольше counterIdleTTL
+func (s *shard) sweepCounters(now time.Time) {
+	s.counterSweep = now
+	for id, c := range s.counterValues {
+		if now.Sub(c.lastSeen) > counterIdleTTL {
+			delete(s.counterValues, id)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// MaxEpisodeEvents наибольшее значение OpenAfter и CloseAfter
+const MaxEpisodeEvents = 1000
+
+// Episode эпизод аномалии: серия событий от открытия до закрытия
+type Episode struct {
+	// Open эпизод продолжается
+	Open bool
+	// Start время события, открывшего эпизод
+	Start time.Time
+	// Events количество событий с начала эпизода
+	Events int
+}
+
+// hysteresis открывает эпизод после OpenAfter превышений порога подряд и
+// закрывает после CloseAfter нормальных событий подряд. Значение, колеблющееся
+// около порога, дает один долгий эпизод, а не аномалию через событие
+type hysteresis struct {
+	Episode
+	breaches int
+	normals  int
+}
+
+// update учитывает событие со временем t и возвращает переход эпизода
+// (models.EpisodeOpened, EpisodeOngoing, EpisodeClosed) или "" вне эпизода
+func (h *hysteresis) update(t time.Time, breach bool, c DetectorConfig) string {
+	if !h.Open {
+		if !breach {
+			h.breaches = 0
+			return ""
+		}
+		h.breaches++
+		if h.breaches < max(c.OpenAfter, 1) {
+			return ""
+		}
+		h.Episode = Episode{Open: true, Start: t, Events: 1}
+		h.breaches, h.normals = 0, 0
+		return models.EpisodeOpened
+	}
+
+	h.Events++
+	if breach {
+		h.normals = 0
+		return models.EpisodeOngoing
+	}
+	h.normals++
+	if h.normals < max(c.CloseAfter, 1) {
+		return models.EpisodeOngoing
+	}
+	h.Open = false
+	h.normals = 0
+	return models.EpisodeClosed
+}
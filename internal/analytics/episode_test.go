@@ -0,0 +1,85 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestAnalyzer_EpisodeHysteresis(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 1000, ZScoreThreshold: 3, OpenAfter: 3, CloseAfter: 2}))
+	defer analyzer.Stop()
+
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+	i := 0
+	analyze := func(cpu float64) models.AnalysisResult {
+		i++
+		return analyzer.AnalyzeSync(models.Metric{Timestamp: at(i), CPU: cpu, RPS: 100})
+	}
+	for i < 200 {
+		analyze(float64(40 + i%2))
+	}
+
+	// Breaches interrupted by a normal event do not open an episode
+	var transitions []string
+	for _, cpu := range []float64{90, 90, 40, 90, 90} {
+		r := analyze(cpu)
+		if r.AnomalyDetected || r.Episode != "" {
+			t.Fatalf("Expected no episode before three breaches in a row, got %+v", r)
+		}
+	}
+
+	// Oscillation around the threshold after opening stays one episode
+	opened := i + 1
+	for _, cpu := range []float64{90, 40, 90, 40, 90, 40, 40, 40} {
+		r := analyze(cpu)
+		transitions = append(transitions, r.Episode)
+		if r.Episode == models.EpisodeOpened && !r.IsAnomalyCPU {
+			t.Error("Expected the opening event to breach the threshold")
+		}
+	}
+	want := []string{"opened", "ongoing", "ongoing", "ongoing", "ongoing", "ongoing", "closed", ""}
+	for k := range want {
+		if transitions[k] != want[k] {
+			t.Fatalf("Expected transitions %v, got %v", want, transitions)
+		}
+	}
+
+	s := analyzer.Snapshot()
+	if s.Episode.Open || !s.Episode.Start.Equal(at(opened)) || s.Episode.Events != 7 {
+		t.Errorf("Expected a closed episode of 7 events started at the opening breach, got %+v", s.Episode)
+	}
+
+	if (DetectorConfig{WindowSize: 10, ZScoreThreshold: 3, OpenAfter: -1}).Validate() == nil {
+		t.Error("Expected a negative open_after to be rejected")
+	}
+}
+
+func TestAnalyzer_WarmupSuppressesAnomalies(t *testing.T) {
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 20, ZScoreThreshold: 2, WarmupFill: 0.5}))
+	defer analyzer.Stop()
+
+	// The first values spread wildly, yet nothing is flagged until 10 values are in the window
+	for i, cpu := range []float64{10, 11, 90, 10, 12, 95, 11, 10, 90, 12} {
+		r := analyzer.AnalyzeSync(models.Metric{CPU: cpu, RPS: 100, Values: map[string]float64{"temp": cpu}})
+		if !r.WarmingUp || r.AnomalyDetected || r.Episode != "" {
+			t.Fatalf("event %d: expected a suppressed warming-up result, got %+v", i, r)
+		}
+	}
+
+	r := analyzer.AnalyzeSync(models.Metric{CPU: 500, RPS: 100})
+	if r.WarmingUp || !r.AnomalyDetected || r.Episode != models.EpisodeOpened {
+		t.Errorf("Expected detection once the window is half full, got %+v", r)
+	}
+	// The baseline is the window before the spike, so it explains the z-score
+	if b := r.BaselineCPU; b.Count != 10 || r.Threshold != 2 || math.Abs((500-b.Mean)/b.StdDev-r.ZScoreCPU) > 1e-9 {
+		t.Errorf("Expected the baseline of the 10 earlier values, got %+v and z-score %v", b, r.ZScoreCPU)
+	}
+
+	if (DetectorConfig{WindowSize: 10, ZScoreThreshold: 3, WarmupFill: 1.5}).Validate() == nil {
+		t.Error("Expected a warmup fill above 1 to be rejected")
+	}
+}
@@ -0,0 +1,205 @@
+package analytics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Причины вытеснения окон устройства
+const (
+	// EvictionIdle устройство молчало дольше IdleTTL
+	EvictionIdle = "idle"
+	// EvictionCapacity окна уступили место новому устройству при MaxDevices устройств
+	EvictionCapacity = "capacity"
+	// EvictionMemory окна уступили место новому устройству при исчерпании MaxMemory
+	EvictionMemory = "memory"
+)
+
+// EvictionReasons причины вытеснения
+var EvictionReasons = []string{EvictionIdle, EvictionCapacity, EvictionMemory}
+
+// Индексы причин в EvictionReasons и счетчиках вытеснений
+const (
+	idleEviction = iota
+	capacityEviction
+	memoryEviction
+)
+
+// Оценка памяти окон устройства: значения окон и корреляции плюс постоянная
+// часть на структуры, элемент LRU и запись карты. Оценка нужна для лимита, а
+// не для учета до байта
+const (
+	deviceOverheadBytes  = 512
+	windowOverheadBytes  = 64
+	namedOverheadBytes   = 96
+	seasonalSlotBytes    = 56
+	counterOverheadBytes = 128
+	counterValueBytes    = 48
+)
+
+// DeviceWindowStats состояние окон по устройствам всех шардов
+type DeviceWindowStats struct {
+	// Devices устройств с собственными окнами
+	Devices int64
+	// Bytes оценка памяти их окон
+	Bytes int64
+	// Evicted вытеснения с запуска по причинам EvictionReasons
+	Evicted map[string]int64
+	// Overflow метрики новых устройств, проанализированные по общим окнам:
+	// лимит заполнен, а устройств, молчащих дольше EvictAfter, нет
+	Overflow int64
+}
+
+// deviceCounters счетчики окон по устройствам, общие для шардов анализатора
+type deviceCounters struct {
+	devices  atomic.Int64
+	bytes    atomic.Int64
+	overflow atomic.Int64
+	evicted  [memoryEviction + 1]atomic.Int64
+}
+
+// DeviceWindowStats возвращает количество устройств с окнами, оценку их
+// памяти и счетчики вытеснений. Значения читаются без обращения к шардам
+func (a *Analyzer) DeviceWindowStats() DeviceWindowStats {
+	stats := DeviceWindowStats{
+		Devices:  a.deviceCounters.devices.Load(),
+		Bytes:    a.deviceCounters.bytes.Load(),
+		Overflow: a.deviceCounters.overflow.Load(),
+		Evicted:  make(map[string]int64, len(EvictionReasons)),
+	}
+	for i, reason := range EvictionReasons {
+		stats.Evicted[reason] = a.deviceCounters.evicted[i].Load()
+	}
+	return stats
+}
+
+// addDevice добавляет окна нового устройства в карту и в начало LRU
+func (s *shard) addDevice(id string, w *deviceWindows) {
+	w.id = id
+	w.elem = s.lru.PushFront(w)
+	s.devices[id] = w
+	s.counters.devices.Add(1)
+	s.account(w)
+}
+
+// removeDevice удаляет окна устройства по причине reason (индекс EvictionReasons)
+// вместе с последними значениями его счетчиков
+func (s *shard) removeDevice(w *deviceWindows, reason int) {
+	delete(s.devices, w.id)
+	delete(s.counterValues, w.id)
+	s.lru.Remove(w.elem)
+	s.deviceBytes -= w.bytes
+	s.counters.devices.Add(-1)
+	s.counters.bytes.Add(-w.bytes)
+	s.counters.evicted[reason].Add(1)
+}
+
+// makeRoom освобождает место для окон нового устройства размером size,
+// вытесняя давно не присылавшие метрик устройства. false — места нет, а все
+// устройства присылали метрики за последние EvictAfter: поток новых
+// идентификаторов не вытесняет окна работающих устройств
+func (s *shard) makeRoom(now time.Time, size int64) bool {
+	for {
+		reason := -1
+		switch {
+		case len(s.devices) >= s.deviceCfg.MaxDevices:
+			reason = capacityEviction
+		case s.deviceCfg.MaxMemory > 0 && s.deviceBytes+size > s.deviceCfg.MaxMemory:
+			reason = memoryEviction
+		}
+		if reason < 0 {
+			return true
+		}
+		back := s.lru.Back()
+		if back == nil || s.deviceCfg.EvictAfter == 0 {
+			return false
+		}
+		oldest := back.Value.(*deviceWindows)
+		if now.Sub(oldest.lastSeen) < s.deviceCfg.EvictAfter {
+			return false
+		}
+		s.removeDevice(oldest, reason)
+	}
+}
+
+// evictIdle удаляет окна устройств, молчащих дольше IdleTTL. Устройства в
+// LRU упорядочены по последней метрике, поэтому обход идет с конца до первого
+// недавнего
+func (s *shard) evictIdle(now time.Time) {
+	s.lastSweep = now
+	for e := s.lru.Back(); e != nil; {
+		w := e.Value.(*deviceWindows)
+		if now.Sub(w.lastSeen) <= s.deviceCfg.IdleTTL {
+			return
+		}
+		e = e.Prev()
+		s.removeDevice(w, idleEviction)
+	}
+}
+
+// account пересчитывает оценку памяти окон устройства: окна по времени растут,
+// именованные показатели и счетчики добавляются, смена класса меняет размер окон
+func (s *shard) account(w *deviceWindows) {
+	size := w.footprint() + s.counterBytes(w.id)
+	if delta := size - w.bytes; delta != 0 {
+		w.bytes = size
+		s.deviceBytes += delta
+		s.counters.bytes.Add(delta)
+	}
+}
+
+// footprint оценка памяти окон устройства
+func (w *deviceWindows) footprint() int64 {
+	size := int64(deviceOverheadBytes+len(w.id)) + windowBytes(w.cpu) + windowBytes(w.rps)
+	if w.correlation != nil {
+		size += int64(16 * len(w.correlation.cpu))
+	}
+	if w.cpuSeasonal != nil {
+		size += int64(2 * seasonalSlotBytes * len(w.cpuSeasonal.slots))
+	}
+	for name, nw := range w.named {
+		size += int64(namedOverheadBytes+len(name)) + windowBytes(nw)
+	}
+	return size
+}
+
+// counterBytes оценка памяти последних значений счетчиков устройства id
+func (s *shard) counterBytes(id string) int64 {
+	c, ok := s.counterValues[id]
+	if !ok {
+		return 0
+	}
+	size := int64(counterOverheadBytes)
+	for name := range c.values {
+		size += int64(counterValueBytes + len(name))
+	}
+	return size
+}
+
+// newDeviceBytes оценка памяти окон нового устройства с конфигурацией c,
+// посчитанная без создания окон
+func newDeviceBytes(id string, c DetectorConfig) int64 {
+	size := int64(deviceOverheadBytes+len(id)+2*windowOverheadBytes) + int64(16*c.WindowSize)
+	if c.Smoothing != SmoothingEWMA && c.WindowSeconds == 0 {
+		size += int64(2 * 8 * c.WindowSize)
+	}
+	switch c.Seasonality {
+	case SeasonalityHourOfDay:
+		size += 2 * seasonalSlotBytes * 24
+	case SeasonalityHourOfWeek:
+		size += 2 * seasonalSlotBytes * 24 * 7
+	}
+	return size
+}
+
+// windowBytes оценка памяти окна
+func windowBytes(w window) int64 {
+	switch w := w.(type) {
+	case *SlidingWindow:
+		return windowOverheadBytes + int64(8*len(w.values))
+	case *TimeWindow:
+		return windowOverheadBytes + int64(16*len(w.values))
+	default:
+		return windowOverheadBytes
+	}
+}
@@ -0,0 +1,147 @@
+package analytics
+
+import "math"
+
+const (
+	// SmoothingSMA простое скользящее среднее по окну WindowSize событий
+	SmoothingSMA = "sma"
+	// SmoothingEWMA экспоненциально взвешенное среднее: O(1) памяти на метрику
+	// и более быстрая реакция на смену нагрузки
+	SmoothingEWMA = "ewma"
+)
+
+// window статистика одной метрики, по которой считается z-score
+type window interface {
+	Add(value float64)
+	Mean() float64
+	StdDev() float64
+	ZScore(value float64) float64
+	Count() int
+}
+
+// EWMA экспоненциально взвешенные среднее и дисперсия. Новое значение входит
+// с весом Alpha, вклад старых убывает геометрически
+type EWMA struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	count    int
+}
+
+// NewEWMA создает EWMA с коэффициентом сглаживания alpha из (0, 1]
+func NewEWMA(alpha float64) *EWMA {
+	return &EWMA{alpha: alpha}
+}
+
+// Add учитывает новое значение. NaN, ±Inf и значения больше MaxAbsValue отбрасываются
+func (e *EWMA) Add(value float64) {
+	if !IsValidValue(value) {
+		return
+	}
+	e.count++
+	if e.count == 1 {
+		e.mean = value
+		return
+	}
+	diff := value - e.mean
+	incr := e.alpha * diff
+	e.mean += incr
+	e.variance = (1 - e.alpha) * (e.variance + diff*incr)
+}
+
+// Mean возвращает сглаженное среднее
+func (e *EWMA) Mean() float64 {
+	return e.mean
+}
+
+// StdDev возвращает экспоненциально взвешенное стандартное отклонение
+func (e *EWMA) StdDev() float64 {
+	if e.count < 2 {
+		return 0
+	}
+	return math.Sqrt(e.variance)
+}
+
+// ZScore вычисляет z-score для заданного значения
+func (e *EWMA) ZScore(value float64) float64 {
+	if !IsValidValue(value) {
+		return 0
+	}
+	return zScore(value, e.Mean(), e.StdDev())
+}
+
+// Count возвращает количество учтенных значений
+func (e *EWMA) Count() int {
+	return e.count
+}
+
+// zScore отклонение value от mean в stdDev, ограниченное конечными значениями,
+// чтобы результат оставался сериализуемым в JSON
+func zScore(value, mean, stdDev float64) float64 {
+	if stdDev == 0 {
+		return 0
+	}
+	z := (value - mean) / stdDev
+	return math.Max(-math.MaxFloat64, math.Min(math.MaxFloat64, z))
+}
+
+// newWindow создает статистику метрики по конфигурации детектора
+func newWindow(c DetectorConfig) window {
+	if c.Smoothing == SmoothingEWMA {
+		return NewEWMA(c.EffectiveAlpha())
+	}
+	if c.WindowSeconds > 0 {
+		return NewTimeWindow(c.WindowDuration())
+	}
+	return NewSlidingWindow(c.WindowSize)
+}
+
+// reconfigure переносит статистику w под конфигурацию c. Окно SMA сохраняет
+// самые свежие значения, EWMA получает среднее и дисперсию окна; при переходе
+// с EWMA на SMA значений для окна нет, и оно заполняется заново. У значений
+// окна по количеству нет времени, поэтому окно по времени после него тоже
+// заполняется заново
+func reconfigure(w window, c DetectorConfig) window {
+	switch w := w.(type) {
+	case *TimeWindow:
+		switch {
+		case c.WindowSeconds > 0:
+			// Лишние значения вытесняются при следующем анализе
+			w.duration = c.WindowDuration()
+			return w
+		case c.Smoothing != SmoothingEWMA:
+			values := w.Values()
+			sw := NewSlidingWindow(c.WindowSize)
+			for _, v := range values[max(0, len(values)-c.WindowSize):] {
+				sw.Add(v)
+			}
+			return sw
+		}
+		e := NewEWMA(c.EffectiveAlpha())
+		e.count = w.Count()
+		e.mean = w.Mean()
+		e.variance = w.StdDev() * w.StdDev()
+		return e
+	case *SlidingWindow:
+		if c.WindowSeconds > 0 {
+			break
+		}
+		if c.Smoothing != SmoothingEWMA {
+			if c.WindowSize == w.size {
+				return w
+			}
+			return w.resize(c.WindowSize)
+		}
+		e := NewEWMA(c.EffectiveAlpha())
+		e.count = w.Count()
+		e.mean = w.Mean()
+		e.variance = w.StdDev() * w.StdDev()
+		return e
+	case *EWMA:
+		if c.Smoothing == SmoothingEWMA && c.WindowSeconds == 0 {
+			w.alpha = c.EffectiveAlpha()
+			return w
+		}
+	}
+	return newWindow(c)
+}
@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestEWMA_TracksLevelShift(t *testing.T) {
+	e := NewEWMA(0.5)
+	for _, v := range []float64{10, 10, 10, 10} {
+		e.Add(v)
+	}
+	if e.Mean() != 10 || e.StdDev() != 0 {
+		t.Errorf("Expected mean 10 and no deviation, got %v/%v", e.Mean(), e.StdDev())
+	}
+	e.Add(20)
+	if e.Mean() != 15 {
+		t.Errorf("Expected mean 15 after a shift, got %v", e.Mean())
+	}
+	e.Add(math.NaN())
+	if e.Count() != 5 {
+		t.Errorf("Expected NaN to be ignored, count %d", e.Count())
+	}
+
+	// An SMA window of the same memory length still sits close to the old level
+	sw := NewSlidingWindow(3)
+	for _, v := range []float64{10, 10, 10, 10, 20} {
+		sw.Add(v)
+	}
+	if sw.Mean() >= e.Mean() {
+		t.Errorf("Expected EWMA to react faster: sma %v, ewma %v", sw.Mean(), e.Mean())
+	}
+}
+
+func TestDetectorConfig_Smoothing(t *testing.T) {
+	c := DetectorConfig{WindowSize: 9, ZScoreThreshold: 2, Smoothing: SmoothingEWMA}
+	if err := c.Validate(); err != nil || c.EffectiveAlpha() != 0.2 {
+		t.Errorf("Expected default alpha 0.2, got %v (%v)", c.EffectiveAlpha(), err)
+	}
+	for _, bad := range []DetectorConfig{
+		{WindowSize: 9, ZScoreThreshold: 2, Smoothing: "median"},
+		{WindowSize: 9, ZScoreThreshold: 2, Smoothing: SmoothingEWMA, Alpha: 1.5},
+		{WindowSize: 9, ZScoreThreshold: 2, Smoothing: SmoothingEWMA, Alpha: math.NaN()},
+	} {
+		if bad.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestAnalyzer_SwitchesSmoothing(t *testing.T) {
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}))
+	defer analyzer.Stop()
+	for i := 0; i < 10; i++ {
+		analyzer.AnalyzeSync(models.Metric{CPU: float64(40 + i%2), RPS: 100})
+	}
+	before := analyzer.Snapshot()
+
+	ewma := DetectorConfig{WindowSize: 10, ZScoreThreshold: 3, Smoothing: SmoothingEWMA, Alpha: 0.3}
+	if err := analyzer.SetDetectorConfig(ewma); err != nil {
+		t.Fatal(err)
+	}
+	after := analyzer.Snapshot()
+	if after.AvgCPU != before.AvgCPU || after.StdDevCPU != before.StdDevCPU || after.Detector != ewma {
+		t.Errorf("Expected EWMA seeded from the window, before %+v, after %+v", before, after)
+	}
+	if !before.HasPercentiles || after.HasPercentiles {
+		t.Errorf("Expected percentiles only for the sliding window, before %v, after %v", before.HasPercentiles, after.HasPercentiles)
+	}
+	if r := analyzer.AnalyzeSync(models.Metric{CPU: 90, RPS: 100}); !r.IsAnomalyCPU {
+		t.Errorf("Expected a spike to be detected after the switch, got %+v", r)
+	}
+}
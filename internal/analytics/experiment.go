@@ -0,0 +1,138 @@
+package analytics
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"highload-service/internal/models"
+)
+
+// Experiment параллельно прогоняет поток метрик через две конфигурации детектора
+// (например, окно 50 и 200) и считает, насколько их решения совпадают.
+// Основной анализатор при этом не затрагивается
+type Experiment struct {
+	a, b    *Analyzer
+	metrics chan models.Metric
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+
+	mu         sync.Mutex
+	samples    int64
+	anomaliesA int64
+	anomaliesB int64
+	both       int64
+}
+
+// ExperimentReport сравнение двух конфигураций
+type ExperimentReport struct {
+	A          DetectorConfig `json:"a"`
+	B          DetectorConfig `json:"b"`
+	Samples    int64          `json:"samples"`
+	AnomaliesA int64          `json:"anomalies_a"`
+	AnomaliesB int64          `json:"anomalies_b"`
+	// Both метрики, признанные аномальными обеими конфигурациями
+	Both int64 `json:"both"`
+	// AgreementRate доля метрик, по которым конфигурации приняли одинаковое решение
+	AgreementRate float64 `json:"agreement_rate"`
+	// Dropped метрики, не попавшие в эксперимент из-за переполнения очереди
+	Dropped int64 `json:"dropped"`
+}
+
+// NewExperiment создает эксперимент и запускает фоновую обработку.
+// bufferSize ограничивает очередь: при переполнении метрики отбрасываются,
+// чтобы эксперимент не замедлял прием данных
+func NewExperiment(a, b DetectorConfig, bufferSize int, opts ...Option) *Experiment {
+	e := &Experiment{
+		a:       NewAnalyzer(0, append([]Option{WithDetectorConfig(a)}, opts...)...),
+		b:       NewAnalyzer(0, append([]Option{WithDetectorConfig(b)}, opts...)...),
+		metrics: make(chan models.Metric, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Observe ставит метрику в очередь эксперимента. Не блокирует
+func (e *Experiment) Observe(m models.Metric) {
+	select {
+	case e.metrics <- m:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Stop обрабатывает оставшуюся очередь и останавливает эксперимент
+func (e *Experiment) Stop() {
+	e.once.Do(func() {
+		close(e.metrics)
+		<-e.done
+		e.a.Stop()
+		e.b.Stop()
+	})
+}
+
+func (e *Experiment) run() {
+	defer close(e.done)
+	for m := range e.metrics {
+		e.observe(m)
+	}
+}
+
+// observe анализирует метрику обеими конфигурациями
+func (e *Experiment) observe(m models.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	anomalyA := e.a.AnalyzeSync(m).AnomalyDetected
+	anomalyB := e.b.AnalyzeSync(m).AnomalyDetected
+	e.samples++
+	if anomalyA {
+		e.anomaliesA++
+	}
+	if anomalyB {
+		e.anomaliesB++
+	}
+	if anomalyA && anomalyB {
+		e.both++
+	}
+}
+
+// Swap меняет конфигурации A и B местами и обнуляет счетчики.
+// Окна анализаторов сохраняются, поэтому сравнение продолжается без прогрева
+func (e *Experiment) Swap() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.a, e.b = e.b, e.a
+	e.samples, e.anomaliesA, e.anomaliesB, e.both = 0, 0, 0, 0
+	e.dropped.Store(0)
+}
+
+// Configs возвращает конфигурации A и B
+func (e *Experiment) Configs() (a, b DetectorConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.a.DetectorConfig(), e.b.DetectorConfig()
+}
+
+// Report возвращает текущие результаты сравнения
+func (e *Experiment) Report() ExperimentReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := ExperimentReport{
+		A:          e.a.DetectorConfig(),
+		B:          e.b.DetectorConfig(),
+		Samples:    e.samples,
+		AnomaliesA: e.anomaliesA,
+		AnomaliesB: e.anomaliesB,
+		Both:       e.both,
+		Dropped:    e.dropped.Load(),
+	}
+	if e.samples > 0 {
+		// Расхождения: аномалия только у одной из конфигураций
+		disagreements := e.anomaliesA + e.anomaliesB - 2*e.both
+		r.AgreementRate = float64(e.samples-disagreements) / float64(e.samples)
+	}
+	return r
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestExperiment_ComparesConfigurations(t *testing.T) {
+	strict := DetectorConfig{WindowSize: 50, ZScoreThreshold: 2}
+	lenient := DetectorConfig{WindowSize: 50, ZScoreThreshold: 1000}
+	e := NewExperiment(strict, lenient, 1000)
+
+	for i := 0; i < 200; i++ {
+		cpu := 50.0 + float64(i%5)
+		if i%40 == 39 {
+			cpu = 99 // spike only the strict configuration flags
+		}
+		e.Observe(models.Metric{CPU: cpu, RPS: 500 + float64(i%7)})
+	}
+	e.Stop()
+
+	r := e.Report()
+	if r.Samples != 200 || r.Dropped != 0 {
+		t.Fatalf("Expected all 200 samples observed, got %+v", r)
+	}
+	if r.AnomaliesA == 0 || r.AnomaliesB != 0 || r.Both != 0 {
+		t.Errorf("Expected anomalies only from the strict configuration, got %+v", r)
+	}
+	want := float64(r.Samples-r.AnomaliesA) / float64(r.Samples)
+	if math.Abs(r.AgreementRate-want) > 1e-9 {
+		t.Errorf("Expected agreement rate %v, got %v", want, r.AgreementRate)
+	}
+	if r.A != strict || r.B != lenient {
+		t.Errorf("Report must carry both configurations, got %+v / %+v", r.A, r.B)
+	}
+}
+
+func TestAnalyzer_DetectorConfigWindowSize(t *testing.T) {
+	a := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 3, ZScoreThreshold: 2}))
+	for _, v := range []float64{1, 2, 3, 10} {
+		a.AnalyzeSync(models.Metric{CPU: v, RPS: v})
+	}
+	// Only the last 3 values (2, 3, 10) remain in the window
+	if avgCPU, _, _, _ := a.GetStats(""); avgCPU != 5 {
+		t.Errorf("Expected mean 5 over a window of 3, got %v", avgCPU)
+	}
+}
+
+func TestDetectorConfig_Validate(t *testing.T) {
+	for _, c := range []DetectorConfig{{WindowSize: 1, ZScoreThreshold: 2}, {WindowSize: 50}, {WindowSize: 50, ZScoreThreshold: math.Inf(1)}} {
+		if c.Validate() == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+	if err := DefaultDetectorConfig().Validate(); err != nil {
+		t.Errorf("Default config must be valid: %v", err)
+	}
+}
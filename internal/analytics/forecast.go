@@ -0,0 +1,191 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInsufficientData ряд слишком короткий для прогноза
+var ErrInsufficientData = errors.New("not enough data for forecast")
+
+// Сетки коэффициентов, среди которых FitHoltWinters ищет лучшие
+var (
+	hwAlphas = []float64{0.1, 0.2, 0.3, 0.5, 0.7, 0.9}
+	hwBetas  = []float64{0.01, 0.05, 0.1, 0.2, 0.4}
+	hwGammas = []float64{0.05, 0.1, 0.2, 0.4, 0.6}
+)
+
+// HoltWinters аддитивная модель Холта–Винтерса: уровень, тренд и сезонность,
+// каждый со своим коэффициентом сглаживания из (0, 1)
+type HoltWinters struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+	Gamma float64 `json:"gamma,omitempty"`
+	// Season длина сезона в точках ряда; 0 — без сезонности (линейный метод Холта)
+	Season int `json:"season,omitempty"`
+}
+
+// Prediction прогноз одной точки с доверительным интервалом
+type Prediction struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// hwState состояние модели после прохода по ряду
+type hwState struct {
+	level, trend float64
+	seasonal     []float64
+	// sse сумма квадратов ошибок прогноза на шаг вперед, n — их количество
+	sse float64
+	n   int
+	// length длина ряда: от нее отсчитывается сезонный индекс прогноза
+	length int
+}
+
+// Validate проверяет коэффициенты
+func (hw HoltWinters) Validate() error {
+	for name, v := range map[string]float64{"alpha": hw.Alpha, "beta": hw.Beta} {
+		if !(v > 0 && v < 1) {
+			return fmt.Errorf("%s must be within (0, 1), got %v", name, v)
+		}
+	}
+	if hw.Season < 0 || hw.Season == 1 {
+		return fmt.Errorf("season must be 0 or at least 2 points, got %d", hw.Season)
+	}
+	if hw.Season > 0 && !(hw.Gamma > 0 && hw.Gamma < 1) {
+		return fmt.Errorf("gamma must be within (0, 1), got %v", hw.Gamma)
+	}
+	return nil
+}
+
+// MinPoints наименьшая длина ряда для модели: два сезона, без сезонности — три точки
+func (hw HoltWinters) MinPoints() int {
+	if hw.Season > 0 {
+		return 2 * hw.Season
+	}
+	return 3
+}
+
+// FitHoltWinters подбирает коэффициенты с наименьшей ошибкой прогноза на шаг
+// вперед по ряду series перебором по сетке
+func FitHoltWinters(series []float64, season int) (HoltWinters, error) {
+	if len(series) < (HoltWinters{Season: season}).MinPoints() {
+		return HoltWinters{}, ErrInsufficientData
+	}
+	gammas := hwGammas
+	if season == 0 {
+		gammas = []float64{0}
+	}
+	best, bestSSE := HoltWinters{}, math.Inf(1)
+	for _, alpha := range hwAlphas {
+		for _, beta := range hwBetas {
+			for _, gamma := range gammas {
+				hw := HoltWinters{Alpha: alpha, Beta: beta, Gamma: gamma, Season: season}
+				if st := hw.run(series); st.sse < bestSSE {
+					best, bestSSE = hw, st.sse
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+// Forecast прогнозирует horizon следующих точек ряда. Интервал покрывает
+// значение с вероятностью confidence, если ошибки модели нормальны; его ширина
+// растет с горизонтом. Второе значение — среднеквадратичная ошибка прогноза
+// на шаг вперед по истории
+func (hw HoltWinters) Forecast(series []float64, horizon int, confidence float64) ([]Prediction, float64, error) {
+	if err := hw.Validate(); err != nil {
+		return nil, 0, err
+	}
+	if !(confidence > 0 && confidence < 1) {
+		return nil, 0, fmt.Errorf("confidence must be within (0, 1), got %v", confidence)
+	}
+	if len(series) < hw.MinPoints() {
+		return nil, 0, ErrInsufficientData
+	}
+	for _, v := range series {
+		if !IsValidValue(v) {
+			return nil, 0, fmt.Errorf("series contains invalid value %v", v)
+		}
+	}
+
+	st := hw.run(series)
+	variance := 0.0
+	if st.n > 0 {
+		variance = st.sse / float64(st.n)
+	}
+	z := math.Sqrt2 * math.Erfinv(confidence)
+
+	predictions := make([]Prediction, horizon)
+	// growth сумма из дисперсии прогноза на h шагов аддитивной модели:
+	// σ²·(1 + Σ_{j<h} (α(1+jβ) + γ·[j кратно сезону])²)
+	growth := 1.0
+	for h := 1; h <= horizon; h++ {
+		if j := h - 1; j > 0 {
+			c := hw.Alpha * (1 + float64(j)*hw.Beta)
+			if hw.Season > 0 && j%hw.Season == 0 {
+				c += hw.Gamma
+			}
+			growth += c * c
+		}
+		value := st.level + float64(h)*st.trend
+		if hw.Season > 0 {
+			value += st.seasonal[(st.length+h-1)%hw.Season]
+		}
+		margin := z * math.Sqrt(variance*growth)
+		predictions[h-1] = Prediction{Value: value, Lower: value - margin, Upper: value + margin}
+	}
+	return predictions, math.Sqrt(variance), nil
+}
+
+// run проходит по ряду, обновляя уровень, тренд и сезонные поправки
+func (hw HoltWinters) run(series []float64) hwState {
+	st := hwState{length: len(series)}
+	m := hw.Season
+	start := 1
+	if m > 0 {
+		// Тренд — разница средних двух первых сезонов, поправки — отклонения
+		// первого сезона от линии тренда, уровень — конец этой линии
+		first, second := mean(series[:m]), mean(series[m:2*m])
+		st.trend = (second - first) / float64(m)
+		center := float64(m-1) / 2
+		st.seasonal = make([]float64, m)
+		for i := 0; i < m; i++ {
+			st.seasonal[i] = series[i] - (first + (float64(i)-center)*st.trend)
+		}
+		st.level = first + (float64(m-1)-center)*st.trend
+		start = m
+	} else {
+		st.level, st.trend = series[0], series[1]-series[0]
+	}
+
+	for t := start; t < len(series); t++ {
+		x := series[t]
+		s := 0.0
+		if m > 0 {
+			s = st.seasonal[t%m]
+		}
+		e := x - (st.level + st.trend + s)
+		st.sse += e * e
+		st.n++
+
+		prev := st.level
+		st.level = hw.Alpha*(x-s) + (1-hw.Alpha)*(st.level+st.trend)
+		st.trend = hw.Beta*(st.level-prev) + (1-hw.Beta)*st.trend
+		if m > 0 {
+			st.seasonal[t%m] = hw.Gamma*(x-st.level) + (1-hw.Gamma)*s
+		}
+	}
+	return st
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,77 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// seasonalSeries daily-like load: trend plus a sine season of 24 points plus gaussian noise
+func seasonalSeries(n int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	series := make([]float64, n)
+	for i := range series {
+		series[i] = 50 + 0.1*float64(i) + 20*math.Sin(2*math.Pi*float64(i)/24) + rng.NormFloat64()
+	}
+	return series
+}
+
+func TestHoltWinters_ForecastsSeasonAndTrend(t *testing.T) {
+	history := seasonalSeries(24 * 4)
+	model, err := FitHoltWinters(history, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	predictions, rmse, err := model.Forecast(history, 24, 0.95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rmse < 0.5 || rmse > 2 {
+		t.Errorf("Expected the one-step error to be close to the noise, got %v", rmse)
+	}
+
+	truth := seasonalSeries(24 * 5)[24*4:]
+	outside := 0
+	for i, p := range predictions {
+		// The point forecast follows the noise-free load
+		n := float64(24*4 + i)
+		if want := 50 + 0.1*n + 20*math.Sin(2*math.Pi*n/24); math.Abs(p.Value-want) > 3 {
+			t.Errorf("Point %d: expected about %.1f, got %.1f", i, want, p.Value)
+		}
+		if truth[i] < p.Lower || truth[i] > p.Upper {
+			outside++
+		}
+	}
+	if outside > 2 {
+		t.Errorf("Expected a 95%% interval to cover almost all points, %d of %d are outside", outside, len(predictions))
+	}
+	// Uncertainty grows with the horizon
+	first, last := predictions[0], predictions[len(predictions)-1]
+	if last.Upper-last.Lower <= first.Upper-first.Lower {
+		t.Errorf("Expected a wider interval at the end, got %+v and %+v", first, last)
+	}
+}
+
+func TestHoltWinters_Validation(t *testing.T) {
+	if _, err := FitHoltWinters(seasonalSeries(30), 24); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("Expected ErrInsufficientData for less than two seasons, got %v", err)
+	}
+
+	// Without seasonality a linear trend is continued
+	linear := []float64{10, 12, 14, 16, 18, 20}
+	model, err := FitHoltWinters(linear, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	predictions, _, err := model.Forecast(linear, 2, 0.9)
+	if err != nil || math.Abs(predictions[0].Value-22) > 1e-9 || math.Abs(predictions[1].Value-24) > 1e-9 {
+		t.Errorf("Expected 22 and 24, got %+v (%v)", predictions, err)
+	}
+
+	for _, bad := range []HoltWinters{{Alpha: 0, Beta: 0.1}, {Alpha: 0.5, Beta: 0.1, Season: 1}, {Alpha: 0.5, Beta: 0.1, Season: 4}} {
+		if _, _, err := bad.Forecast(linear, 1, 0.9); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
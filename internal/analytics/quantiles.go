@@ -0,0 +1,153 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// MaxQuantileMetrics предел числа показателей со скетчами: cpu, rps и первые
+// именованные показатели; значения остальных в скетчи не попадают
+const MaxQuantileMetrics = 16
+
+var (
+	// ErrUnknownQuantileMetric у показателя нет скетчей
+	ErrUnknownQuantileMetric = errors.New("unknown metric")
+	// ErrQuantileRange диапазон пуст или превышает время хранения скетчей
+	ErrQuantileRange = errors.New("range must be non-empty and within retention")
+)
+
+// QuantileConfig настройки скетчей квантилей
+type QuantileConfig struct {
+	// Resolution длина интервала одного скетча — точность границ диапазона запроса
+	Resolution time.Duration
+	// Retention сколько хранятся интервалы
+	Retention time.Duration
+	// Compression сжатие t-digest каждого интервала
+	Compression float64
+}
+
+// DefaultQuantileConfig интервалы по минуте за сутки со сжатием DefaultCompression
+func DefaultQuantileConfig() QuantileConfig {
+	return QuantileConfig{Resolution: time.Minute, Retention: 24 * time.Hour, Compression: DefaultCompression}
+}
+
+// Validate проверяет настройки
+func (c QuantileConfig) Validate() error {
+	if c.Resolution <= 0 || c.Retention < c.Resolution {
+		return fmt.Errorf("resolution must be positive and not longer than retention, got %s and %s", c.Resolution, c.Retention)
+	}
+	if c.Retention/c.Resolution > 100000 {
+		return fmt.Errorf("at most 100000 intervals can be kept, got %d", c.Retention/c.Resolution)
+	}
+	if c.Compression < 10 || c.Compression > 1000 {
+		return fmt.Errorf("compression must be within [10, 1000], got %v", c.Compression)
+	}
+	return nil
+}
+
+// quantileBucket скетч одного интервала; index — номер интервала от начала эпохи
+type quantileBucket struct {
+	index  int64
+	digest *TDigest
+}
+
+// Quantiles скетчи t-digest значений каждого показателя по интервалам
+// Resolution. Квантили за произвольный диапазон получаются слиянием скетчей
+// его интервалов. Безопасен для конкурентного использования
+type Quantiles struct {
+	cfg QuantileConfig
+
+	mu     sync.Mutex
+	series map[string][]quantileBucket
+}
+
+// NewQuantiles создает хранилище скетчей
+func NewQuantiles(cfg QuantileConfig) *Quantiles {
+	return &Quantiles{cfg: cfg, series: make(map[string][]quantileBucket)}
+}
+
+// Observe добавляет значения метрики в скетчи интервала ее времени
+func (q *Quantiles) Observe(m models.Metric) {
+	if m.Timestamp.UnixNano() < 0 {
+		return
+	}
+	idx := m.Timestamp.UnixNano() / int64(q.cfg.Resolution)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add("cpu", idx, m.CPU)
+	q.add("rps", idx, m.RPS)
+	for name, v := range m.Values {
+		q.add(name, idx, v)
+	}
+}
+
+// add добавляет значение в скетч интервала idx; вызывается под блокировкой
+func (q *Quantiles) add(name string, idx int64, v float64) {
+	buckets, ok := q.series[name]
+	if !ok {
+		if len(q.series) >= MaxQuantileMetrics {
+			return
+		}
+		buckets = make([]quantileBucket, int(q.cfg.Retention/q.cfg.Resolution))
+		q.series[name] = buckets
+	}
+	b := &buckets[idx%int64(len(buckets))]
+	switch {
+	case b.digest != nil && b.index > idx:
+		// Ячейку уже занял более новый интервал, опоздавшее значение не хранится
+		return
+	case b.digest == nil:
+		b.digest = NewTDigest(q.cfg.Compression)
+	case b.index < idx:
+		// Скетч вытесненного интервала переиспользуется
+		b.digest.reset()
+	}
+	b.index = idx
+	b.digest.Add(v)
+}
+
+// Digest возвращает скетч показателя name за интервалы, начинающиеся внутри
+// [from, to]. Возвращается копия: ее можно сливать с другими скетчами
+func (q *Quantiles) Digest(name string, from, to time.Time) (*TDigest, error) {
+	if !from.Before(to) || to.Sub(from) > q.cfg.Retention || from.UnixNano() < 0 {
+		return nil, ErrQuantileRange
+	}
+	res := int64(q.cfg.Resolution)
+	first := from.UnixNano() / res
+	if first*res < from.UnixNano() {
+		first++
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	buckets, ok := q.series[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQuantileMetric, name)
+	}
+	digest := NewTDigest(q.cfg.Compression)
+	for idx := first; idx <= to.UnixNano()/res; idx++ {
+		b := buckets[idx%int64(len(buckets))]
+		if b.digest != nil && b.index == idx {
+			digest.Merge(b.digest)
+		}
+	}
+	return digest, nil
+}
+
+// Metrics возвращает имена показателей со скетчами
+func (q *Quantiles) Metrics() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	names := make([]string, 0, len(q.series))
+	for name := range q.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
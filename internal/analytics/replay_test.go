@@ -0,0 +1,154 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// replayDetector wraps a detector under evaluation. newDetect returns a fresh,
+// stateful detection function for every replayed stream.
+type replayDetector struct {
+	name      string
+	newDetect func() func(models.Metric) bool
+}
+
+// replayDetectors lists every detector evaluated against the corpus.
+// New detectors must be registered here together with their thresholds.
+var replayDetectors = []replayDetector{
+	{
+		name: "zscore",
+		newDetect: func() func(models.Metric) bool {
+			a := NewAnalyzer(1)
+			return func(m models.Metric) bool { return a.AnalyzeSync(m).AnomalyDetected }
+		},
+	},
+}
+
+// replayThreshold is the minimum detection quality required for a corpus.
+type replayThreshold struct {
+	precision float64
+	recall    float64
+}
+
+// replayThresholds maps corpus file -> detector name -> required quality.
+var replayThresholds = map[string]map[string]replayThreshold{
+	"cpu_rps_spikes.csv": {"zscore": {precision: 0.20, recall: 0.95}},
+	"rps_dips.csv":       {"zscore": {precision: 0.14, recall: 0.95}},
+	"noisy_subtle.csv":   {"zscore": {precision: 0.08, recall: 0.75}},
+}
+
+type labeledMetric struct {
+	metric  models.Metric
+	anomaly bool
+}
+
+func TestReplayCorpus_DetectionQuality(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "replay", "*.csv"))
+	if err != nil {
+		t.Fatalf("Failed to list corpus: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("Replay corpus is empty")
+	}
+
+	for _, file := range files {
+		corpus := filepath.Base(file)
+		stream := loadReplayStream(t, file)
+
+		for _, d := range replayDetectors {
+			threshold, ok := replayThresholds[corpus][d.name]
+			if !ok {
+				t.Errorf("%s/%s: no threshold configured", corpus, d.name)
+				continue
+			}
+
+			t.Run(corpus+"/"+d.name, func(t *testing.T) {
+				precision, recall := replay(stream, d.newDetect())
+				t.Logf("precision=%.3f recall=%.3f", precision, recall)
+
+				if precision < threshold.precision {
+					t.Errorf("Precision %.3f below threshold %.3f", precision, threshold.precision)
+				}
+				if recall < threshold.recall {
+					t.Errorf("Recall %.3f below threshold %.3f", recall, threshold.recall)
+				}
+			})
+		}
+	}
+}
+
+// replay feeds the stream through detect and returns precision and recall
+// against the labels.
+func replay(stream []labeledMetric, detect func(models.Metric) bool) (precision, recall float64) {
+	var truePos, falsePos, falseNeg int
+	for _, lm := range stream {
+		detected := detect(lm.metric)
+		switch {
+		case detected && lm.anomaly:
+			truePos++
+		case detected && !lm.anomaly:
+			falsePos++
+		case !detected && lm.anomaly:
+			falseNeg++
+		}
+	}
+
+	if truePos+falsePos > 0 {
+		precision = float64(truePos) / float64(truePos+falsePos)
+	}
+	if truePos+falseNeg > 0 {
+		recall = float64(truePos) / float64(truePos+falseNeg)
+	}
+	return precision, recall
+}
+
+// loadReplayStream reads a corpus file with the columns
+// timestamp,device_id,cpu,rps,anomaly.
+func loadReplayStream(t *testing.T, path string) []labeledMetric {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("Failed to read header of %s: %v", path, err)
+	}
+
+	var stream []labeledMetric
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s:%d: %v", path, line, err)
+		}
+
+		ts, err := time.Parse(time.RFC3339, rec[0])
+		if err != nil {
+			t.Fatalf("%s:%d: bad timestamp: %v", path, line, err)
+		}
+		cpu, err1 := strconv.ParseFloat(rec[2], 64)
+		rps, err2 := strconv.ParseFloat(rec[3], 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("%s:%d: bad value", path, line)
+		}
+
+		stream = append(stream, labeledMetric{
+			metric:  models.Metric{Timestamp: ts, DeviceID: rec[1], CPU: cpu, RPS: rps},
+			anomaly: rec[4] == "1",
+		})
+	}
+	return stream
+}
@@ -0,0 +1,204 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+
+	"highload-service/internal/models"
+)
+
+// MaxSeedValues наибольшее количество значений каждого показателя при заполнении окон
+const MaxSeedValues = 100000
+
+// ErrNoDeviceWindows возвращается, если у устройства нет окон и создать их нельзя
+var ErrNoDeviceWindows = errors.New("device has no analysis windows")
+
+// WindowSeed значения, которыми заполняются окна, от старых к новым. CPU[i] и
+// RPS[i] — одна метрика, поэтому длины должны совпадать
+type WindowSeed struct {
+	CPU []float64 `json:"cpu"`
+	RPS []float64 `json:"rps"`
+}
+
+// Validate проверяет значения
+func (s WindowSeed) Validate() error {
+	if len(s.CPU) == 0 || len(s.CPU) != len(s.RPS) {
+		return fmt.Errorf("cpu and rps must be non-empty and of equal length, got %d and %d", len(s.CPU), len(s.RPS))
+	}
+	if len(s.CPU) > MaxSeedValues {
+		return fmt.Errorf("at most %d values can be seeded, got %d", MaxSeedValues, len(s.CPU))
+	}
+	for i := range s.CPU {
+		if !IsValidValue(s.CPU[i]) || !IsValidValue(s.RPS[i]) {
+			return fmt.Errorf("value %d is not a finite number within ±%g", i, MaxAbsValue)
+		}
+	}
+	return nil
+}
+
+// ResetWindows очищает окна устройства deviceID или, для пустого deviceID,
+// общие окна: значения, сезонные базовые линии, окна именованных показателей
+// и эпизод аномалии. Возвращает срезы до и после
+func (a *Analyzer) ResetWindows(deviceID string) (before, after Snapshot, err error) {
+	return a.resetWindows(deviceID, WindowSeed{})
+}
+
+// SeedWindows заменяет окна устройства deviceID или общих окон окнами,
+// заполненными значениями seed, например после плановой смены нагрузки.
+// Окна устройства создаются, если их еще нет
+func (a *Analyzer) SeedWindows(deviceID string, seed WindowSeed) (before, after Snapshot, err error) {
+	if err := seed.Validate(); err != nil {
+		return Snapshot{}, Snapshot{}, err
+	}
+	return a.resetWindows(deviceID, seed)
+}
+
+func (a *Analyzer) resetWindows(deviceID string, seed WindowSeed) (before, after Snapshot, err error) {
+	if deviceID == "" && len(a.shards) > 1 {
+		return a.resetShared(seed)
+	}
+	resp, ok := a.shardFor(deviceID).call(request{kind: resetRequest, metric: models.Metric{DeviceID: deviceID}, seed: seed})
+	if !ok {
+		return Snapshot{}, Snapshot{}, ErrStopped
+	}
+	if !resp.found {
+		return Snapshot{}, Snapshot{}, ErrNoDeviceWindows
+	}
+	return resp.previous, resp.snapshot, nil
+}
+
+// resetShared очищает общие окна всех шардов; значения seed получает шард 0,
+// поэтому объединенная статистика совпадает с заполненным окном
+func (a *Analyzer) resetShared(seed WindowSeed) (before, after Snapshot, err error) {
+	befores, afters := make([]Snapshot, len(a.shards)), make([]Snapshot, len(a.shards))
+	for i, s := range a.shards {
+		req := request{kind: resetRequest}
+		if i == 0 {
+			req.seed = seed
+		}
+		resp, ok := s.call(req)
+		if !ok {
+			return Snapshot{}, Snapshot{}, ErrStopped
+		}
+		befores[i], afters[i] = resp.previous, resp.snapshot
+	}
+	return mergeSnapshots(befores), mergeSnapshots(afters), nil
+}
+
+// reset очищает окна и заполняет их значениями seed; found == false — у
+// устройства нет окон (для сброса) или их нельзя создать (для заполнения)
+func (s *shard) reset(deviceID string, seed WindowSeed) (before, after Snapshot, found bool) {
+	if deviceID == "" {
+		before = s.snapshot()
+		s.cpuWindow, s.rpsWindow = newWindow(s.detector), newWindow(s.detector)
+		s.correlation = NewCorrelation(s.detector.WindowSize)
+		s.cpuSeasonal, s.rpsSeasonal = newSeasonal(s.detector), newSeasonal(s.detector)
+		s.named, s.episode = nil, hysteresis{}
+		s.seed(s.cpuWindow, s.rpsWindow, s.correlation, seed)
+		return before, s.snapshot(), true
+	}
+
+	w, ok := s.devices[deviceID]
+	if !ok {
+		if len(seed.CPU) == 0 {
+			return before, after, false
+		}
+		if w = s.deviceWindows(deviceID); w == nil {
+			return before, after, false
+		}
+	}
+	before = s.windowSnapshot(w.cpu, w.rps, w.correlation, w.detector, w.episode.Episode)
+	w.cpu, w.rps = newWindow(w.detector), newWindow(w.detector)
+	w.correlation = NewCorrelation(w.detector.WindowSize)
+	w.cpuSeasonal, w.rpsSeasonal = newSeasonal(w.detector), newSeasonal(w.detector)
+	w.named, w.episode = nil, hysteresis{}
+	s.seed(w.cpu, w.rps, w.correlation, seed)
+	s.account(w)
+	return before, s.windowSnapshot(w.cpu, w.rps, w.correlation, w.detector, w.episode.Episode), true
+}
+
+// seed добавляет значения в окна. Значения получают текущее время, поэтому
+// окно по времени вытеснит их через свою длительность
+func (s *shard) seed(cpu, rps window, correlation *Correlation, seed WindowSeed) {
+	now := s.clock.Now()
+	for i := range seed.CPU {
+		observe(cpu, now, seed.CPU[i])
+		observe(rps, now, seed.RPS[i])
+		correlation.Add(seed.CPU[i], seed.RPS[i])
+	}
+}
+
+// ExportWindows возвращает значения окон CPU и RPS: общих под ключом "" и
+// окон устройств под их идентификаторами, например для передачи окон новому
+// процессу, который заполнит ими свои окна (ImportWindows). Окна EWMA не
+// хранят значений и не экспортируются; сезонные базовые линии, окна
+// именованных показателей и эпизоды тоже не переносятся. После Stop
+// возвращаются окна на момент остановки
+func (a *Analyzer) ExportWindows() map[string]WindowSeed {
+	seeds := make(map[string]WindowSeed)
+	for _, s := range a.shards {
+		resp, ok := s.call(request{kind: exportRequest})
+		if !ok {
+			// После остановки окна больше никто не меняет
+			<-s.exited
+			resp.seeds = s.export()
+		}
+		for id, seed := range resp.seeds {
+			if shared, ok := seeds[id]; ok && id == "" {
+				// Общие окна шардов объединяются в одно
+				seed = WindowSeed{CPU: append(shared.CPU, seed.CPU...), RPS: append(shared.RPS, seed.RPS...)}
+			}
+			seeds[id] = seed
+		}
+	}
+	return seeds
+}
+
+// ImportWindows заполняет окна значениями seeds (результат ExportWindows) и
+// возвращает количество заполненных окон. Заполнение одних окон не мешает
+// другим: ошибки собираются вместе
+func (a *Analyzer) ImportWindows(seeds map[string]WindowSeed) (int, error) {
+	var errs []error
+	imported := 0
+	for id, seed := range seeds {
+		if len(seed.CPU) > MaxSeedValues {
+			seed = WindowSeed{CPU: seed.CPU[len(seed.CPU)-MaxSeedValues:], RPS: seed.RPS[len(seed.RPS)-MaxSeedValues:]}
+		}
+		if _, _, err := a.SeedWindows(id, seed); err != nil {
+			errs = append(errs, fmt.Errorf("device %q: %w", id, err))
+			continue
+		}
+		imported++
+	}
+	return imported, errors.Join(errs...)
+}
+
+// export значения окон шарда, хранящих значения
+func (s *shard) export() map[string]WindowSeed {
+	seeds := make(map[string]WindowSeed, len(s.devices)+1)
+	if seed, ok := exportSeed(s.cpuWindow, s.rpsWindow); ok {
+		seeds[""] = seed
+	}
+	for id, w := range s.devices {
+		if seed, ok := exportSeed(w.cpu, w.rps); ok {
+			seeds[id] = seed
+		}
+	}
+	return seeds
+}
+
+// exportSeed значения окон CPU и RPS; окна могут отбросить разные значения,
+// поэтому длины выравниваются по самым свежим. false — окна не хранят значений или пусты
+func exportSeed(cpu, rps window) (WindowSeed, bool) {
+	cpuValues, cpuOK := cpu.(valuer)
+	rpsValues, rpsOK := rps.(valuer)
+	if !cpuOK || !rpsOK {
+		return WindowSeed{}, false
+	}
+	c, r := cpuValues.Values(), rpsValues.Values()
+	n := min(len(c), len(r))
+	if n == 0 {
+		return WindowSeed{}, false
+	}
+	return WindowSeed{CPU: c[len(c)-n:], RPS: r[len(r)-n:]}, true
+}
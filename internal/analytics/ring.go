@@ -0,0 +1,131 @@
+package analytics
+
+import (
+	"sync/atomic"
+
+	"highload-service/internal/models"
+)
+
+// cacheLine размер строки кеша; счетчики производителей и потребителей
+// разнесены по разным строкам, чтобы не мешать друг другу
+const cacheLine = 64
+
+// job метрика в очереди анализатора. reply получает результат, если метрику
+// ждет обработчик (Analyze); без него результат уходит в GetResults
+type job struct {
+	metric models.Metric
+	reply  chan outcome
+}
+
+// outcome результат метрики для ожидающего Analyze
+type outcome struct {
+	result models.AnalysisResult
+	err    error
+}
+
+// ring ограниченная очередь метрик без блокировок (очередь Вьюкова): каждая
+// ячейка несет номер позиции, для которой она свободна или заполнена, поэтому
+// производители и потребители занимают позиции одним CAS и не ждут друг друга.
+// В отличие от буферизованного канала, Submit не берет общий мьютекс
+type ring struct {
+	slots []ringSlot
+	_     [cacheLine]byte
+	// head следующая позиция записи
+	head atomic.Uint64
+	_    [cacheLine - 8]byte
+	// tail следующая позиция чтения
+	tail atomic.Uint64
+	_    [cacheLine - 8]byte
+}
+
+// ringSlot ячейка очереди. seq == pos — свободна для записи позиции pos,
+// seq == pos+1 — заполнена и ждет чтения
+type ringSlot struct {
+	seq atomic.Uint64
+	job job
+}
+
+// newRing создает очередь на size метрик (не меньше одной)
+func newRing(size int) *ring {
+	r := &ring{slots: make([]ringSlot, max(size, 1))}
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+	}
+	return r
+}
+
+// push добавляет метрику. false — очередь заполнена
+func (r *ring) push(j job) bool {
+	n := uint64(len(r.slots))
+	pos := r.head.Load()
+	for {
+		slot := &r.slots[pos%n]
+		seq := slot.seq.Load()
+		switch {
+		case seq == pos:
+			if r.head.CompareAndSwap(pos, pos+1) {
+				slot.job = j
+				slot.seq.Store(pos + 1)
+				return true
+			}
+			pos = r.head.Load()
+		case seq < pos:
+			// Ячейку круга назад еще не прочитали
+			return false
+		default:
+			pos = r.head.Load()
+		}
+	}
+}
+
+// pop извлекает метрику. false — очередь пуста
+func (r *ring) pop() (job, bool) {
+	n := uint64(len(r.slots))
+	pos := r.tail.Load()
+	for {
+		slot := &r.slots[pos%n]
+		seq := slot.seq.Load()
+		switch {
+		case seq == pos+1:
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				j := slot.job
+				// Строки и карта метрики не удерживаются до следующего круга
+				slot.job = job{}
+				slot.seq.Store(pos + n)
+				return j, true
+			}
+			pos = r.tail.Load()
+		case seq < pos+1:
+			return job{}, false
+		default:
+			pos = r.tail.Load()
+		}
+	}
+}
+
+// popBatch дописывает в jobs до limit метрик и возвращает его
+func (r *ring) popBatch(jobs []job, limit int) []job {
+	for len(jobs) < limit {
+		j, ok := r.pop()
+		if !ok {
+			break
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// len количество метрик в очереди; при конкурентных операциях приближенное
+func (r *ring) len() int {
+	tail := r.tail.Load()
+	head := r.head.Load()
+	if head <= tail {
+		return 0
+	}
+	return min(int(head-tail), len(r.slots))
+}
+
+// capacity емкость очереди
+func (r *ring) capacity() int {
+	return len(r.slots)
+}
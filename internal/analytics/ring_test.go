@@ -0,0 +1,238 @@
+package analytics
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestRing_KeepsOrderAndRejectsWhenFull(t *testing.T) {
+	r := newRing(3)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			if !r.push(job{metric: models.Metric{CPU: float64(i)}}) {
+				t.Fatalf("Round %d: push %d rejected", round, i)
+			}
+		}
+		if r.push(job{}) {
+			t.Fatalf("Round %d: expected a full ring to reject the metric", round)
+		}
+		if r.len() != 3 {
+			t.Fatalf("Round %d: expected length 3, got %d", round, r.len())
+		}
+		for i := 0; i < 3; i++ {
+			j, ok := r.pop()
+			if !ok || j.metric.CPU != float64(i) {
+				t.Fatalf("Round %d: expected metric %d, got %v (ok %v)", round, i, j.metric.CPU, ok)
+			}
+		}
+		if _, ok := r.pop(); ok {
+			t.Fatalf("Round %d: expected an empty ring", round)
+		}
+	}
+}
+
+func TestRing_ConcurrentProducersAndConsumers(t *testing.T) {
+	const producers, perProducer, consumers = 8, 20000, 4
+	r := newRing(64)
+	seen := make([]atomic.Int32, producers*perProducer)
+	var received atomic.Int64
+	var wg sync.WaitGroup
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				j := job{metric: models.Metric{CPU: float64(p*perProducer + i)}}
+				for !r.push(j) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+	var consumed sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		consumed.Add(1)
+		go func() {
+			defer consumed.Done()
+			for received.Load() < producers*perProducer {
+				j, ok := r.pop()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				seen[int(j.metric.CPU)].Add(1)
+				received.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	consumed.Wait()
+
+	for i := range seen {
+		if n := seen[i].Load(); n != 1 {
+			t.Fatalf("Metric %d received %d times", i, n)
+		}
+	}
+}
+
+func TestAnalyzer_AnalyzeWaitsForWorkers(t *testing.T) {
+	analyzer := NewAnalyzer(64, WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 100}))
+	// Without workers the metric is analyzed in place
+	if r, _ := analyzer.Analyze(models.Metric{DeviceID: "sensor-x", CPU: 50, RPS: 100}); r.RollingAvgCPU != 50 {
+		t.Fatalf("Expected an in-place result, got %+v", r)
+	}
+	analyzer.Start(2)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m := models.Metric{DeviceID: fmt.Sprintf("sensor-%d", g), CPU: float64(g), RPS: 100}
+				if r, err := analyzer.Analyze(m); err != nil || r.RollingAvgCPU != float64(g) {
+					t.Errorf("Device %d: expected its own result, got %+v", g, r)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	select {
+	case r := <-analyzer.GetResults():
+		t.Errorf("Expected awaited results to bypass GetResults, got %+v", r)
+	default:
+	}
+	if n := analyzer.Samples(); n != 801 {
+		t.Errorf("Expected 801 analyzed metrics, got %d", n)
+	}
+	analyzer.Stop()
+	if r, _ := analyzer.Analyze(models.Metric{CPU: 1}); r.RollingAvgCPU != 0 {
+		t.Errorf("Expected no analysis after Stop, got %+v", r)
+	}
+}
+
+func TestAnalyzer_WorkersWakeForSubmittedMetrics(t *testing.T) {
+	analyzer := NewAnalyzer(16)
+	analyzer.Start(4)
+	defer analyzer.Stop()
+
+	// Workers fall asleep on an empty queue and must wake for every metric
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond)
+		if !analyzer.Submit(models.Metric{CPU: 50, RPS: 100}) {
+			t.Fatalf("Submit %d rejected", i)
+		}
+		select {
+		case <-analyzer.GetResults():
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for result %d", i)
+		}
+	}
+}
+
+// chanQueue is the buffered channel the ring replaced, kept for comparison
+type chanQueue chan job
+
+func (q chanQueue) push(j job) bool {
+	select {
+	case q <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q chanQueue) pop() (job, bool) {
+	select {
+	case j := <-q:
+		return j, true
+	default:
+		return job{}, false
+	}
+}
+
+// benchmarkIngest measures Submit-like pushes from GOMAXPROCS producers while
+// consumers drain the queue; rejected pushes are retried, as clients do on 503
+func benchmarkIngest(b *testing.B, push func(job) bool, pop func() (job, bool)) {
+	const consumers = 4
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, ok := pop(); ok {
+					continue
+				}
+				select {
+				case <-done:
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+
+	j := job{metric: models.Metric{DeviceID: "sensor-1", CPU: 55, RPS: 500}}
+	start := time.Now()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for !push(j) {
+				runtime.Gosched()
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+	close(done)
+	wg.Wait()
+}
+
+// BenchmarkIngest compares the lock-free ring with the buffered channel it
+// replaced; run with -cpu 1,4,16 to see the effect of contention:
+//
+//	go test ./internal/analytics -run '^$' -bench Ingest -cpu 1,4,16
+func BenchmarkIngest(b *testing.B) {
+	b.Run("ring", func(b *testing.B) {
+		r := newRing(10000)
+		benchmarkIngest(b, r.push, r.pop)
+	})
+	b.Run("channel", func(b *testing.B) {
+		q := make(chanQueue, 10000)
+		benchmarkIngest(b, q.push, q.pop)
+	})
+}
+
+func BenchmarkAnalyzer_Submit(b *testing.B) {
+	analyzer := NewAnalyzer(10000)
+	analyzer.Start(4)
+	defer analyzer.Stop()
+	go func() {
+		for range analyzer.GetResults() {
+		}
+	}()
+
+	m := models.Metric{DeviceID: "sensor-1", CPU: 55, RPS: 500}
+	start := time.Now()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for !analyzer.Submit(m) {
+				runtime.Gosched()
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+}
@@ -0,0 +1,167 @@
+package analytics
+
+import (
+	"math"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+const (
+	// SeasonalityHourOfDay базовая линия на каждый час суток (24 ячейки)
+	SeasonalityHourOfDay = "hour_of_day"
+	// SeasonalityHourOfWeek базовая линия на каждый час недели (168 ячеек): будни
+	// и выходные различаются, но каждая ячейка обучается неделю
+	SeasonalityHourOfWeek = "hour_of_week"
+)
+
+// seasonalAlpha вес последнего завершенного часа в базовой линии ячейки:
+// ячейка помнит примерно последние 2/alpha−1 ≈ 6 дней (или недель)
+const seasonalAlpha = 0.3
+
+// seasonalSlot базовая линия одного часа суток или недели
+type seasonalSlot struct {
+	// mean и variance базовая линия по завершенным часам; periods — их количество
+	mean, variance float64
+	periods        int
+	// Накопление текущего часа (алгоритм Уэлфорда); period — номер часа от эпохи
+	period  int64
+	n       int
+	curMean float64
+	m2      float64
+}
+
+// fold переносит накопленный час в базовую линию. Разброс относительно
+// базовой линии складывается из разброса внутри часа и отклонения среднего
+// часа от базовой линии (закон полной дисперсии)
+func (s *seasonalSlot) fold() {
+	if s.n == 0 {
+		return
+	}
+	variance := s.m2 / float64(s.n)
+	if s.periods == 0 {
+		s.mean, s.variance = s.curMean, variance
+	} else {
+		diff := s.curMean - s.mean
+		s.variance = (1-seasonalAlpha)*s.variance + seasonalAlpha*(variance+diff*diff)
+		s.mean += seasonalAlpha * diff
+	}
+	s.periods++
+	s.n, s.curMean, s.m2 = 0, 0, 0
+}
+
+// Seasonal базовые линии метрики по часам суток или недели (время UTC). Значение
+// сравнивается с ожиданием для своего часа, а не с последними событиями, поэтому
+// ежедневный утренний рост нагрузки не считается аномалией
+type Seasonal struct {
+	mode  string
+	slots []seasonalSlot
+}
+
+// NewSeasonal создает базовые линии для режима SeasonalityHourOfDay или SeasonalityHourOfWeek
+func NewSeasonal(mode string) *Seasonal {
+	n := 24
+	if mode == SeasonalityHourOfWeek {
+		n = 24 * 7
+	}
+	return &Seasonal{mode: mode, slots: make([]seasonalSlot, n)}
+}
+
+// slot возвращает ячейку часа t и номер часа от эпохи
+func (s *Seasonal) slot(t time.Time) (*seasonalSlot, int64) {
+	t = t.UTC()
+	idx := t.Hour()
+	if s.mode == SeasonalityHourOfWeek {
+		idx += int(t.Weekday()) * 24
+	}
+	return &s.slots[idx], int64(math.Floor(float64(t.Unix()) / 3600))
+}
+
+// Add учитывает значение в ячейке его часа. Значения за уже завершенный час
+// (пришедшие с опозданием) в обучении не участвуют
+func (s *Seasonal) Add(t time.Time, value float64) {
+	if !IsValidValue(value) {
+		return
+	}
+	slot, period := s.slot(t)
+	if period < slot.period {
+		return
+	}
+	if period > slot.period {
+		slot.fold()
+		slot.period = period
+	}
+	slot.n++
+	delta := value - slot.curMean
+	slot.curMean += delta / float64(slot.n)
+	slot.m2 += delta * (value - slot.curMean)
+}
+
+// Expected возвращает ожидаемое среднее и стандартное отклонение для часа t.
+// ok == false, пока для этого часа нет ни одного завершенного периода
+func (s *Seasonal) Expected(t time.Time) (mean, stdDev float64, ok bool) {
+	slot, period := s.slot(t)
+	periods := slot.periods
+	if period > slot.period && slot.n > 0 {
+		// Накопленный час уже завершился, но еще не перенесен в базовую линию
+		periods++
+	}
+	if periods == 0 {
+		return 0, 0, false
+	}
+	if slot.periods == 0 {
+		return slot.curMean, math.Sqrt(slot.m2 / float64(slot.n)), true
+	}
+	return slot.mean, math.Sqrt(slot.variance), true
+}
+
+// ZScore вычисляет z-score значения относительно ожидания для часа t
+func (s *Seasonal) ZScore(t time.Time, value float64) (float64, bool) {
+	mean, stdDev, ok := s.Expected(t)
+	if !ok || !IsValidValue(value) {
+		return 0, ok
+	}
+	return zScore(value, mean, stdDev), true
+}
+
+// newSeasonal создает базовые линии по конфигурации детектора; nil — сезонность выключена
+func newSeasonal(c DetectorConfig) *Seasonal {
+	if c.Seasonality == "" {
+		return nil
+	}
+	return NewSeasonal(c.Seasonality)
+}
+
+// reconfigureSeasonal сохраняет обученные базовые линии, если режим не изменился
+func reconfigureSeasonal(s *Seasonal, c DetectorConfig) *Seasonal {
+	if s != nil && s.mode == c.Seasonality {
+		return s
+	}
+	return newSeasonal(c)
+}
+
+// seasonalZScore z-score относительно сезонной базовой линии, а пока она не
+// обучена для часа метрики — относительно окна
+func seasonalZScore(s *Seasonal, w window, t time.Time, value float64) float64 {
+	if s != nil {
+		if z, ok := s.ZScore(t, value); ok {
+			return z
+		}
+	}
+	return w.ZScore(value)
+}
+
+// seasonalBaseline ожидание, относительно которого seasonalZScore считает z-score
+func seasonalBaseline(s *Seasonal, w window, t time.Time) models.Baseline {
+	if s != nil {
+		if mean, stdDev, ok := s.Expected(t); ok {
+			return models.Baseline{Mean: mean, StdDev: stdDev, Count: w.Count()}
+		}
+	}
+	return windowBaseline(w)
+}
+
+// windowBaseline текущая статистика окна
+func windowBaseline(w window) models.Baseline {
+	return models.Baseline{Mean: w.Mean(), StdDev: w.StdDev(), Count: w.Count()}
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// dailyRPS night load of 100 rps, a ramp to 1000 rps at 09:00 UTC, with a little jitter
+func dailyRPS(t time.Time) float64 {
+	base := 100.0
+	if t.Hour() >= 9 && t.Hour() < 18 {
+		base = 1000
+	}
+	return base + float64(t.Minute()%5-2)*base/50
+}
+
+func TestSeasonal_MorningRampIsNotAnomalous(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	plain := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 50, ZScoreThreshold: 3}))
+	defer plain.Stop()
+	seasonal := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 50, ZScoreThreshold: 3, Seasonality: SeasonalityHourOfDay}))
+	defer seasonal.Stop()
+
+	// Three days of history, one metric a minute; day four starts at midnight
+	day4 := start.Add(72 * time.Hour)
+	for ts := start; ts.Before(day4.Add(9 * time.Hour)); ts = ts.Add(time.Minute) {
+		m := models.Metric{Timestamp: ts, CPU: 40, RPS: dailyRPS(ts)}
+		plain.AnalyzeSync(m)
+		seasonal.AnalyzeSync(m)
+	}
+
+	ramp := models.Metric{Timestamp: day4.Add(9*time.Hour + time.Minute), CPU: 40, RPS: 1000}
+	if r := plain.AnalyzeSync(ramp); !r.IsAnomalyRPS {
+		t.Errorf("Expected the window detector to flag the morning ramp, got %+v", r)
+	}
+	if r := seasonal.AnalyzeSync(ramp); r.IsAnomalyRPS {
+		t.Errorf("Expected the seasonal baseline to expect the ramp, got z=%v", r.ZScoreRPS)
+	}
+	spike := models.Metric{Timestamp: day4.Add(9*time.Hour + 2*time.Minute), CPU: 40, RPS: 3000}
+	if r := seasonal.AnalyzeSync(spike); !r.IsAnomalyRPS {
+		t.Errorf("Expected a real spike to be flagged, got z=%v", r.ZScoreRPS)
+	}
+}
+
+func TestSeasonal_FallsBackToWindowUntilLearned(t *testing.T) {
+	s := NewSeasonal(SeasonalityHourOfWeek)
+	monday := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		s.Add(monday.Add(time.Duration(i)*time.Minute), float64(100+i%2))
+	}
+	// The hour is still in progress, so there is no baseline yet
+	if _, _, ok := s.Expected(monday.Add(59 * time.Minute)); ok {
+		t.Error("Expected no baseline before the hour ends")
+	}
+	// Next Monday 10:00 compares against the finished hour, Tuesday is not learned
+	if mean, _, ok := s.Expected(monday.Add(7 * 24 * time.Hour)); !ok || mean != 100.5 {
+		t.Errorf("Expected mean 100.5, got %v (%v)", mean, ok)
+	}
+	if _, _, ok := s.Expected(monday.Add(24 * time.Hour)); ok {
+		t.Error("Expected Tuesday 10:00 to have no baseline")
+	}
+
+	w := NewSlidingWindow(10)
+	for _, v := range []float64{10, 12, 10, 12} {
+		w.Add(v)
+	}
+	if z := seasonalZScore(s, w, monday.Add(24*time.Hour), 15); z != w.ZScore(15) {
+		t.Errorf("Expected the window z-score for an unlearned hour, got %v", z)
+	}
+
+	if (DetectorConfig{WindowSize: 10, ZScoreThreshold: 2, Seasonality: "monthly"}).Validate() == nil {
+		t.Error("Expected an unknown seasonality to be rejected")
+	}
+}
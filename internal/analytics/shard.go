@@ -0,0 +1,596 @@
+package analytics
+
+import (
+	"container/list"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// maxBatch наибольшее количество метрик, которое воркер передает владельцу окон за раз
+	maxBatch = 64
+	// inboxSize размер очереди сообщений владельца окон
+	inboxSize = 1024
+	// replyPoolSize количество переиспользуемых каналов ответа
+	replyPoolSize = 256
+	// sweepsPerTTL сколько раз за IdleTTL ищутся молчащие устройства
+	sweepsPerTTL = 4
+	// MaxNamedWindows наибольшее количество именованных показателей с окнами у
+	// устройства (и в общих окнах); показатели с новыми именами сверх лимита не анализируются
+	MaxNamedWindows = 64
+)
+
+// WindowStats статистика окна именованного показателя
+type WindowStats struct {
+	Avg    float64 `json:"avg"`
+	StdDev float64 `json:"std_dev"`
+	Count  int     `json:"count"`
+}
+
+// Snapshot согласованный срез состояния окон
+type Snapshot struct {
+	AvgCPU    float64
+	AvgRPS    float64
+	StdDevCPU float64
+	StdDevRPS float64
+	// PercentilesCPU и PercentilesRPS перцентили значений окон; HasPercentiles
+	// false при сглаживании EWMA, которое не хранит значений
+	PercentilesCPU Percentiles
+	PercentilesRPS Percentiles
+	HasPercentiles bool
+	// Count количество значений в окне CPU
+	Count    int
+	Detector DetectorConfig
+	// Devices количество устройств с собственными окнами
+	Devices int
+	// Episode текущий или последний эпизод аномалии окон
+	Episode Episode
+	// Correlation корреляция Пирсона CPU и RPS; HasCorrelation false, пока она не определена
+	Correlation    float64
+	HasCorrelation bool
+
+	// shared данные для объединения со срезами других шардов; nil при одном шарде
+	shared *sharedWindows
+}
+
+// sharedWindows значения общих окон шарда и суммы пар его корреляции
+type sharedWindows struct {
+	cpuValues, rpsValues []float64
+	pairs                correlationSums
+}
+
+type requestKind int
+
+const (
+	analyzeRequest requestKind = iota
+	batchRequest
+	snapshotRequest
+	deviceSnapshotRequest
+	namedStatsRequest
+	configureRequest
+	resetRequest
+	exportRequest
+)
+
+// request сообщение владельцу окон. Передается по значению, поэтому отправка
+// не выделяет память
+type request struct {
+	kind   requestKind
+	metric models.Metric
+	// batch и results принадлежат отправителю и заполняются владельцем до ответа
+	batch   []models.Metric
+	results []models.AnalysisResult
+	config  DetectorConfig
+	// seed значения для заполнения окон при resetRequest
+	seed  WindowSeed
+	reply chan response
+}
+
+type response struct {
+	result   models.AnalysisResult
+	snapshot Snapshot
+	// previous срез окон до сброса
+	previous Snapshot
+	// found окна запрошенного устройства существуют
+	found bool
+	named map[string]WindowStats
+	// seeds значения окон при exportRequest
+	seeds map[string]WindowSeed
+}
+
+// deviceWindows окна одного устройства
+type deviceWindows struct {
+	cpu, rps window
+	// correlation корреляция CPU и RPS устройства
+	correlation *Correlation
+	// cpuSeasonal, rpsSeasonal сезонные базовые линии; nil, если сезонность выключена
+	cpuSeasonal, rpsSeasonal *Seasonal
+	lastSeen                 time.Time
+	// named окна именованных показателей
+	named map[string]window
+	// episode эпизод аномалии устройства
+	episode hysteresis
+	// class класс устройства, detector — действующая для него конфигурация
+	class    string
+	detector DetectorConfig
+	// id идентификатор устройства, elem — его элемент в LRU шарда,
+	// bytes — последняя оценка памяти окон
+	id    string
+	elem  *list.Element
+	bytes int64
+}
+
+// configure переносит статистику окон под конфигурацию класса class
+func (w *deviceWindows) configure(class string, c DetectorConfig) {
+	w.cpu = reconfigure(w.cpu, c)
+	w.rps = reconfigure(w.rps, c)
+	w.correlation = w.correlation.resize(c.WindowSize)
+	w.cpuSeasonal = reconfigureSeasonal(w.cpuSeasonal, c)
+	w.rpsSeasonal = reconfigureSeasonal(w.rpsSeasonal, c)
+	reconfigureNamed(w.named, c)
+	w.class, w.detector = class, c
+}
+
+// reconfigureNamed переносит окна именованных показателей под конфигурацию c
+func reconfigureNamed(named map[string]window, c DetectorConfig) {
+	for name, w := range named {
+		named[name] = reconfigure(w, c)
+	}
+}
+
+// namedWindow возвращает окно показателя name из named, создавая его. nil —
+// у владельца окон уже MaxNamedWindows показателей
+func namedWindow(named *map[string]window, name string, c DetectorConfig) window {
+	if w, ok := (*named)[name]; ok {
+		return w
+	}
+	if len(*named) >= MaxNamedWindows {
+		return nil
+	}
+	if *named == nil {
+		*named = make(map[string]window)
+	}
+	w := newWindow(c)
+	(*named)[name] = w
+	return w
+}
+
+// namedStats статистика окон именованных показателей
+func namedStats(named map[string]window) map[string]WindowStats {
+	stats := make(map[string]WindowStats, len(named))
+	for name, w := range named {
+		stats[name] = WindowStats{Avg: w.Mean(), StdDev: w.StdDev(), Count: w.Count()}
+	}
+	return stats
+}
+
+// shard владеет окнами CPU и RPS: читает и меняет их только горутина run,
+// остальные обращаются к ним сообщениями. Поэтому окнам не нужна блокировка.
+// Общие окна получают метрики всех устройств; с окнами по устройствам z-score
+// считается по окнам устройства метрики
+type shard struct {
+	clock   clock.Clock
+	inbox   chan request
+	replies chan chan response
+	quit    chan struct{}
+	exited  chan struct{}
+	// final состояние на момент остановки; читается только после закрытия exited
+	final Snapshot
+	// aggregated срезы общих окон объединяются со срезами других шардов
+	aggregated bool
+
+	// Поля ниже принадлежат горутине run
+	cpuWindow   window
+	rpsWindow   window
+	correlation *Correlation
+	cpuSeasonal *Seasonal
+	rpsSeasonal *Seasonal
+	named       map[string]window
+	episode     hysteresis
+	detector    DetectorConfig
+	samples     *atomic.Int64
+	// devices окна по устройствам; nil, если они выключены
+	devices   map[string]*deviceWindows
+	deviceCfg DeviceWindowsConfig
+	classes   ClassResolver
+	lastSweep time.Time
+	// lru окна устройств от недавно присылавших метрики к давно молчащим;
+	// deviceBytes оценка их памяти
+	lru         *list.List
+	deviceBytes int64
+	counters    *deviceCounters
+	// counterRates поля-счетчики; nil — счетчиков нет. counterValues последние
+	// значения счетчиков по устройствам
+	counterRates  *counterRates
+	counterValues map[string]*counterValues
+	counterSweep  time.Time
+}
+
+// newShard создает владельца окон и запускает его горутину. devices == nil
+// выключает окна по устройствам, rates == nil — поля-счетчики; aggregated — шард не единственный
+func newShard(detector DetectorConfig, devices *DeviceWindowsConfig, classes ClassResolver, clk clock.Clock, samples *atomic.Int64, counters *deviceCounters, rates *counterRates, aggregated bool) *shard {
+	s := &shard{
+		clock:       clk,
+		aggregated:  aggregated,
+		inbox:       make(chan request, inboxSize),
+		replies:     make(chan chan response, replyPoolSize),
+		quit:        make(chan struct{}),
+		exited:      make(chan struct{}),
+		cpuWindow:   newWindow(detector),
+		rpsWindow:   newWindow(detector),
+		correlation: NewCorrelation(detector.WindowSize),
+		cpuSeasonal: newSeasonal(detector),
+		rpsSeasonal: newSeasonal(detector),
+		detector:    detector,
+		samples:     samples,
+		counters:    counters,
+	}
+	if rates != nil {
+		s.counterRates = rates
+		s.counterValues = make(map[string]*counterValues)
+		s.counterSweep = clk.Now()
+	}
+	if devices != nil {
+		s.devices = make(map[string]*deviceWindows)
+		s.lru = list.New()
+		s.deviceCfg = *devices
+		s.lastSweep = clk.Now()
+		if len(devices.Classes) > 0 {
+			s.classes = classes
+		}
+	}
+	go s.run()
+	return s
+}
+
+// run обрабатывает сообщения до остановки. Накопившиеся сообщения
+// обрабатываются пачкой без возврата в планировщик
+func (s *shard) run() {
+	defer close(s.exited)
+	for {
+		select {
+		case req := <-s.inbox:
+			s.handle(req)
+			s.drain(maxBatch - 1)
+		case <-s.quit:
+			// Сообщения, принятые до остановки, получают ответ
+			s.drain(inboxSize)
+			s.final = s.snapshot()
+			return
+		}
+	}
+}
+
+// drain обрабатывает до n уже поступивших сообщений, не дожидаясь новых
+func (s *shard) drain(n int) int {
+	for i := 0; i < n; i++ {
+		select {
+		case req := <-s.inbox:
+			s.handle(req)
+		default:
+			return i
+		}
+	}
+	return n
+}
+
+func (s *shard) handle(req request) {
+	var resp response
+	switch req.kind {
+	case analyzeRequest:
+		resp.result = s.analyze(req.metric)
+	case batchRequest:
+		for i, m := range req.batch {
+			req.results[i] = s.analyze(m)
+		}
+	case snapshotRequest:
+		resp.snapshot = s.snapshot()
+	case deviceSnapshotRequest:
+		if w, ok := s.devices[req.metric.DeviceID]; ok {
+			resp.snapshot = s.windowSnapshot(w.cpu, w.rps, w.correlation, w.detector, w.episode.Episode)
+			resp.found = true
+		}
+	case namedStatsRequest:
+		named := s.named
+		if id := req.metric.DeviceID; id != "" {
+			w, ok := s.devices[id]
+			if !ok {
+				break
+			}
+			named = w.named
+		}
+		resp.named = namedStats(named)
+		resp.found = true
+	case configureRequest:
+		// Статистика переносится в окна новой конфигурации, а не обнуляется
+		s.cpuWindow = reconfigure(s.cpuWindow, req.config)
+		s.rpsWindow = reconfigure(s.rpsWindow, req.config)
+		s.correlation = s.correlation.resize(req.config.WindowSize)
+		s.cpuSeasonal = reconfigureSeasonal(s.cpuSeasonal, req.config)
+		s.rpsSeasonal = reconfigureSeasonal(s.rpsSeasonal, req.config)
+		reconfigureNamed(s.named, req.config)
+		s.detector = req.config
+		// Классы наследуют незаданные параметры от новой конфигурации
+		for _, w := range s.devices {
+			w.configure(w.class, s.classDetector(w.class))
+			s.account(w)
+		}
+	case resetRequest:
+		resp.previous, resp.snapshot, resp.found = s.reset(req.metric.DeviceID, req.seed)
+	case exportRequest:
+		resp.seeds = s.export()
+	}
+	req.reply <- resp
+}
+
+// analyze выполняет анализ одной метрики
+func (s *shard) analyze(m models.Metric) models.AnalysisResult {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = s.clock.Now()
+	}
+	// Счетчики переводятся в скорость до окон, и окна видят только ее
+	ready := s.counterRates == nil || s.toRates(&m)
+
+	cpuWindow, rpsWindow, correlation := s.cpuWindow, s.rpsWindow, s.correlation
+	cpuSeasonal, rpsSeasonal := s.cpuSeasonal, s.rpsSeasonal
+	named, detector, episode := &s.named, s.detector, &s.episode
+	w := s.deviceWindows(m.DeviceID)
+	if w != nil {
+		cpuWindow, rpsWindow, correlation = w.cpu, w.rps, w.correlation
+		cpuSeasonal, rpsSeasonal = w.cpuSeasonal, w.rpsSeasonal
+		named, detector, episode = &w.named, w.detector, &w.episode
+	}
+	if !ready {
+		if w != nil {
+			s.account(w)
+		}
+		// Скорость cpu или rps появится со следующей метрикой устройства
+		return models.AnalysisResult{
+			Timestamp:       m.Timestamp,
+			RollingAvgCPU:   cpuWindow.Mean(),
+			RollingAvgRPS:   rpsWindow.Mean(),
+			AnomalyDetected: episode.Open,
+			WarmingUp:       true,
+			Threshold:       detector.ZScoreThreshold,
+		}
+	}
+	if w != nil {
+		for name, v := range m.Values {
+			if nw := namedWindow(&s.named, name, s.detector); nw != nil {
+				observe(nw, m.Timestamp, v)
+			}
+		}
+		observe(s.cpuWindow, m.Timestamp, m.CPU)
+		observe(s.rpsWindow, m.Timestamp, m.RPS)
+		s.correlation.Add(m.CPU, m.RPS)
+		if s.cpuSeasonal != nil {
+			s.cpuSeasonal.Add(m.Timestamp, m.CPU)
+			s.rpsSeasonal.Add(m.Timestamp, m.RPS)
+		}
+	}
+
+	// Вычисляем z-score до добавления в окно; окно по времени сначала
+	// освобождается от значений старше своей длительности
+	expire(cpuWindow, m.Timestamp)
+	expire(rpsWindow, m.Timestamp)
+	warmingUp := !detector.warm(cpuWindow.Count())
+	zScoreCPU := seasonalZScore(cpuSeasonal, cpuWindow, m.Timestamp, m.CPU)
+	zScoreRPS := seasonalZScore(rpsSeasonal, rpsWindow, m.Timestamp, m.RPS)
+	baselineCPU := seasonalBaseline(cpuSeasonal, cpuWindow, m.Timestamp)
+	baselineRPS := seasonalBaseline(rpsSeasonal, rpsWindow, m.Timestamp)
+
+	// Добавляем значения в окна
+	observe(cpuWindow, m.Timestamp, m.CPU)
+	observe(rpsWindow, m.Timestamp, m.RPS)
+	correlation.Add(m.CPU, m.RPS)
+	if cpuSeasonal != nil {
+		cpuSeasonal.Add(m.Timestamp, m.CPU)
+		rpsSeasonal.Add(m.Timestamp, m.RPS)
+	}
+	s.samples.Add(1)
+
+	// Определяем аномалии по z-score (по умолчанию threshold > 2σ)
+	isAnomalyCPU := math.Abs(zScoreCPU) > detector.ZScoreThreshold
+	isAnomalyRPS := math.Abs(zScoreRPS) > detector.ZScoreThreshold
+
+	// Именованные показатели анализируются так же, каждый в своем окне;
+	// без них результат не выделяет память
+	var values map[string]models.ValueResult
+	anomalyValue := false
+	if len(m.Values) > 0 {
+		values = make(map[string]models.ValueResult, len(m.Values))
+		for name, v := range m.Values {
+			nw := namedWindow(named, name, detector)
+			if nw == nil {
+				continue
+			}
+			expire(nw, m.Timestamp)
+			z := nw.ZScore(v)
+			baseline := windowBaseline(nw)
+			warm := detector.warm(nw.Count())
+			observe(nw, m.Timestamp, v)
+			isAnomaly := math.Abs(z) > detector.ZScoreThreshold
+			anomalyValue = anomalyValue || (isAnomaly && warm)
+			values[name] = models.ValueResult{RollingAvg: nw.Mean(), ZScore: z, IsAnomaly: isAnomaly, Baseline: baseline}
+		}
+	}
+
+	// Эпизод сглаживает колебания около порога: флаги IsAnomaly* относятся
+	// к событию, AnomalyDetected — к эпизоду. Превышения на непрогретом окне
+	// эпизод не открывают
+	breach := !warmingUp && (isAnomalyCPU || isAnomalyRPS)
+	transition := episode.update(m.Timestamp, breach || anomalyValue, detector)
+	r, _ := correlation.Coefficient()
+	if w != nil {
+		s.account(w)
+	}
+
+	return models.AnalysisResult{
+		Timestamp:       m.Timestamp,
+		RollingAvgCPU:   cpuWindow.Mean(),
+		RollingAvgRPS:   rpsWindow.Mean(),
+		ZScoreCPU:       zScoreCPU,
+		ZScoreRPS:       zScoreRPS,
+		IsAnomalyCPU:    isAnomalyCPU,
+		IsAnomalyRPS:    isAnomalyRPS,
+		AnomalyDetected: episode.Open,
+		Episode:         transition,
+		WarmingUp:       warmingUp,
+		Correlation:     r,
+		Values:          values,
+		BaselineCPU:     baselineCPU,
+		BaselineRPS:     baselineRPS,
+		Threshold:       detector.ZScoreThreshold,
+	}
+}
+
+// deviceWindows возвращает окна устройства, создавая их для нового устройства.
+// При заполненном лимите (MaxDevices, MaxMemory) новое устройство вытесняет
+// окна давнее всех молчащего. nil — окна по устройствам выключены или
+// вытеснить некого: тогда метрика анализируется по общим окнам
+func (s *shard) deviceWindows(id string) *deviceWindows {
+	if s.devices == nil {
+		return nil
+	}
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) >= s.deviceCfg.IdleTTL/sweepsPerTTL {
+		s.evictIdle(now)
+	}
+	class := ""
+	if s.classes != nil {
+		class = s.classes.ClassOf(id)
+	}
+	w, ok := s.devices[id]
+	if !ok {
+		detector := s.classDetector(class)
+		if !s.makeRoom(now, newDeviceBytes(id, detector)+s.counterBytes(id)) {
+			s.counters.overflow.Add(1)
+			// Значения счетчиков живут не дольше окон устройства, иначе поток
+			// новых идентификаторов копил бы их в обход лимитов
+			delete(s.counterValues, id)
+			return nil
+		}
+		w = &deviceWindows{
+			cpu:         newWindow(detector),
+			rps:         newWindow(detector),
+			correlation: NewCorrelation(detector.WindowSize),
+			cpuSeasonal: newSeasonal(detector),
+			rpsSeasonal: newSeasonal(detector),
+			class:       class,
+			detector:    detector,
+		}
+		w.lastSeen = now
+		s.addDevice(id, w)
+		return w
+	}
+	if w.class != class {
+		w.configure(class, s.classDetector(class))
+	}
+	w.lastSeen = now
+	s.lru.MoveToFront(w.elem)
+	return w
+}
+
+// classDetector конфигурация детектора для класса устройств
+func (s *shard) classDetector(class string) DetectorConfig {
+	if c, ok := s.deviceCfg.Classes[class]; ok {
+		return c.Inherit(s.detector)
+	}
+	return s.detector
+}
+
+func (s *shard) snapshot() Snapshot {
+	snap := s.windowSnapshot(s.cpuWindow, s.rpsWindow, s.correlation, s.detector, s.episode.Episode)
+	snap.Devices = len(s.devices)
+	if s.aggregated {
+		snap.shared = &sharedWindows{pairs: s.correlation.sums()}
+		if v, ok := s.cpuWindow.(valuer); ok {
+			snap.shared.cpuValues = v.Values()
+		}
+		if v, ok := s.rpsWindow.(valuer); ok {
+			snap.shared.rpsValues = v.Values()
+		}
+	}
+	return snap
+}
+
+// percentiler окно, хранящее значения (SlidingWindow, TimeWindow)
+type percentiler interface {
+	Percentiles() Percentiles
+}
+
+// valuer окно, отдающее копию своих значений
+type valuer interface {
+	Values() []float64
+}
+
+func (s *shard) windowSnapshot(cpu, rps window, correlation *Correlation, detector DetectorConfig, episode Episode) Snapshot {
+	snap := Snapshot{
+		AvgCPU:    cpu.Mean(),
+		AvgRPS:    rps.Mean(),
+		StdDevCPU: cpu.StdDev(),
+		StdDevRPS: rps.StdDev(),
+		Count:     cpu.Count(),
+		Detector:  detector,
+		Episode:   episode,
+	}
+	cpuWindow, cpuOK := cpu.(percentiler)
+	rpsWindow, rpsOK := rps.(percentiler)
+	if cpuOK && rpsOK {
+		snap.PercentilesCPU, snap.PercentilesRPS = cpuWindow.Percentiles(), rpsWindow.Percentiles()
+		snap.HasPercentiles = true
+	}
+	snap.Correlation, snap.HasCorrelation = correlation.Coefficient()
+	return snap
+}
+
+// call отправляет сообщение и ждет ответа. ok == false, если владелец остановлен
+// и сообщение не было обработано
+func (s *shard) call(req request) (response, bool) {
+	var reply chan response
+	select {
+	case reply = <-s.replies:
+	default:
+		reply = make(chan response, 1)
+	}
+	req.reply = reply
+
+	select {
+	case s.inbox <- req:
+	case <-s.exited:
+		s.release(reply)
+		return response{}, false
+	}
+	select {
+	case resp := <-reply:
+		s.release(reply)
+		return resp, true
+	case <-s.exited:
+		// Ответ мог быть отправлен перед остановкой
+		select {
+		case resp := <-reply:
+			s.release(reply)
+			return resp, true
+		default:
+			return response{}, false
+		}
+	}
+}
+
+// release возвращает пустой канал ответа в пул
+func (s *shard) release(reply chan response) {
+	select {
+	case s.replies <- reply:
+	default:
+	}
+}
+
+// stop останавливает владельца после ответа на уже принятые сообщения
+func (s *shard) stop() {
+	close(s.quit)
+	<-s.exited
+}
@@ -0,0 +1,223 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+
+	"highload-service/internal/models"
+)
+
+// MaxShards наибольшее количество шардов окон
+const MaxShards = 256
+
+// WithShards делит окна устройств между n шардами. Каждый шард — отдельная
+// горутина-владелец со своими окнами, и устройство всегда анализируется своим
+// шардом (по хешу DeviceID), поэтому воркеры анализируют метрики разных
+// устройств параллельно. Лимит MaxDevices делится между шардами поровну.
+// Общие окна каждого шарда получают только его метрики, поэтому вместе они
+// хранят до n окон значений; общая статистика (Snapshot, GetStats(""))
+// объединяет срезы всех шардов. Метрики без устройства
+// и сверх лимита устройств анализируются по общим окнам своего шарда, поэтому
+// n > 1 имеет смысл только с окнами по устройствам. n < 1 — один шард
+func WithShards(n int) Option {
+	return func(a *Analyzer) {
+		a.shardCount = n
+	}
+}
+
+// Shards возвращает количество шардов окон
+func (a *Analyzer) Shards() int {
+	return len(a.shards)
+}
+
+// shardIndex номер шарда устройства deviceID: FNV-1a хеш идентификатора по
+// модулю количества шардов. Метрики без устройства анализирует шард 0
+func (a *Analyzer) shardIndex(deviceID string) int {
+	if len(a.shards) == 1 || deviceID == "" {
+		return 0
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(deviceID); i++ {
+		h ^= uint32(deviceID[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(a.shards)))
+}
+
+// shardFor шард устройства deviceID
+func (a *Analyzer) shardFor(deviceID string) *shard {
+	return a.shards[a.shardIndex(deviceID)]
+}
+
+// router раскладывает пачку воркера по шардам; у каждого воркера свой
+type router struct {
+	groups  [][]int
+	batch   []models.Metric
+	results []models.AnalysisResult
+}
+
+func newRouter(shards int) *router {
+	r := &router{
+		groups:  make([][]int, shards),
+		batch:   make([]models.Metric, 0, maxBatch),
+		results: make([]models.AnalysisResult, maxBatch),
+	}
+	for i := range r.groups {
+		r.groups[i] = make([]int, 0, maxBatch)
+	}
+	return r
+}
+
+// analyzeBatch анализирует пачку, передавая каждому шарду его метрики одним
+// сообщением, и заполняет results в порядке batch. false — анализатор остановлен
+func (a *Analyzer) analyzeBatch(r *router, batch []models.Metric, results []models.AnalysisResult) bool {
+	if len(a.shards) == 1 {
+		_, ok := a.shards[0].call(request{kind: batchRequest, batch: batch, results: results})
+		return ok
+	}
+	for k := range r.groups {
+		r.groups[k] = r.groups[k][:0]
+	}
+	for i, m := range batch {
+		k := a.shardIndex(m.DeviceID)
+		r.groups[k] = append(r.groups[k], i)
+	}
+	for k, group := range r.groups {
+		if len(group) == 0 {
+			continue
+		}
+		r.batch = r.batch[:0]
+		for _, i := range group {
+			r.batch = append(r.batch, batch[i])
+		}
+		if _, ok := a.shards[k].call(request{kind: batchRequest, batch: r.batch, results: r.results}); !ok {
+			return false
+		}
+		for j, i := range group {
+			results[i] = r.results[j]
+		}
+	}
+	return true
+}
+
+// broadcast отправляет req всем шардам и возвращает их ответы. ok == false,
+// если хотя бы один шард остановлен
+func (a *Analyzer) broadcast(req request) ([]response, bool) {
+	resps := make([]response, len(a.shards))
+	for i, s := range a.shards {
+		resp, ok := s.call(req)
+		if !ok {
+			return nil, false
+		}
+		resps[i] = resp
+	}
+	return resps, true
+}
+
+// moments количество значений, среднее и выборочное стандартное отклонение окна
+type moments struct {
+	count  int
+	mean   float64
+	stdDev float64
+}
+
+// pool объединяет моменты непересекающихся окон: среднее взвешивается
+// количеством значений, дисперсия складывается из дисперсий окон и разброса
+// их средних. Для EWMA результат приближенный
+func pool(parts []moments) moments {
+	n, sum := 0.0, 0.0
+	for _, p := range parts {
+		n += float64(p.count)
+		sum += float64(p.count) * p.mean
+	}
+	if n == 0 {
+		return moments{}
+	}
+	merged := moments{count: int(n), mean: sum / n}
+	if n < 2 {
+		return merged
+	}
+	squares := 0.0
+	for _, p := range parts {
+		c, d := float64(p.count), p.mean-merged.mean
+		squares += math.Max(c-1, 0)*p.stdDev*p.stdDev + c*d*d
+	}
+	merged.stdDev = math.Sqrt(squares / (n - 1))
+	return merged
+}
+
+// mergeSnapshots объединяет срезы общих окон шардов. Перцентили и корреляция
+// считаются по объединению значений и пар окон; эпизод открыт, если открыт
+// эпизод хотя бы одного шарда
+func mergeSnapshots(parts []Snapshot) Snapshot {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	merged := Snapshot{Detector: parts[0].Detector, HasPercentiles: parts[0].HasPercentiles}
+	cpu, rps := make([]moments, len(parts)), make([]moments, len(parts))
+	var cpuValues, rpsValues []float64
+	var pairs correlationSums
+	for i, p := range parts {
+		cpu[i] = moments{count: p.Count, mean: p.AvgCPU, stdDev: p.StdDevCPU}
+		rps[i] = moments{count: p.Count, mean: p.AvgRPS, stdDev: p.StdDevRPS}
+		if p.shared != nil {
+			cpuValues = append(cpuValues, p.shared.cpuValues...)
+			rpsValues = append(rpsValues, p.shared.rpsValues...)
+			pairs.add(p.shared.pairs)
+		}
+		merged.Devices += p.Devices
+		merged.Episode = mergeEpisode(merged.Episode, p.Episode)
+	}
+	c, r := pool(cpu), pool(rps)
+	merged.Count, merged.AvgCPU, merged.StdDevCPU = c.count, c.mean, c.stdDev
+	merged.AvgRPS, merged.StdDevRPS = r.mean, r.stdDev
+	if merged.HasPercentiles {
+		merged.PercentilesCPU, merged.PercentilesRPS = percentiles(cpuValues), percentiles(rpsValues)
+	}
+	merged.Correlation, merged.HasCorrelation = pairs.coefficient()
+	return merged
+}
+
+// percentiles p50, p95 и p99 значений values; values сортируется
+func percentiles(values []float64) Percentiles {
+	sort.Float64s(values)
+	return Percentiles{P50: percentile(values, 0.5), P95: percentile(values, 0.95), P99: percentile(values, 0.99)}
+}
+
+// mergeEpisode объединяет эпизоды двух шардов: открытый эпизод важнее
+// закрытого, открытые начинаются с более раннего и складывают события,
+// из закрытых берется последний
+func mergeEpisode(a, b Episode) Episode {
+	switch {
+	case a.Open && b.Open:
+		if b.Start.Before(a.Start) {
+			a.Start = b.Start
+		}
+		a.Events += b.Events
+		return a
+	case a.Open:
+		return a
+	case b.Open || b.Start.After(a.Start):
+		return b
+	}
+	return a
+}
+
+// mergeNamed объединяет статистику общих окон именованных показателей шардов
+func mergeNamed(parts []map[string]WindowStats) map[string]WindowStats {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	byName := make(map[string][]moments)
+	for _, named := range parts {
+		for name, s := range named {
+			byName[name] = append(byName[name], moments{count: s.Count, mean: s.Avg, stdDev: s.StdDev})
+		}
+	}
+	merged := make(map[string]WindowStats, len(byName))
+	for name, m := range byName {
+		p := pool(m)
+		merged[name] = WindowStats{Avg: p.mean, StdDev: p.stdDev, Count: p.count}
+	}
+	return merged
+}
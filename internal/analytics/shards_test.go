@@ -0,0 +1,135 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestAnalyzer_ShardsMatchSingleOwner(t *testing.T) {
+	opts := []Option{
+		WithDetectorConfig(DetectorConfig{WindowSize: 100, ZScoreThreshold: 3}),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 100}),
+	}
+	single := NewAnalyzer(1, opts...)
+	defer single.Stop()
+	sharded := NewAnalyzer(1, append(opts, WithShards(4))...)
+	defer sharded.Stop()
+	if sharded.Shards() != 4 {
+		t.Fatalf("Expected 4 shards, got %d", sharded.Shards())
+	}
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// 96 values fit in one shared window, so the shards together hold the same values
+	for i := 0; i < 12; i++ {
+		for d := 0; d < 8; d++ {
+			m := models.Metric{
+				DeviceID:  fmt.Sprintf("sensor-%d", d),
+				Timestamp: base.Add(time.Duration(i) * time.Second),
+				CPU:       float64(10*d + i%5),
+				RPS:       float64(100 + d*i),
+				Values:    map[string]float64{"temp": float64(d + i)},
+			}
+			want, got := single.AnalyzeSync(m), sharded.AnalyzeSync(m)
+			if want.ZScoreCPU != got.ZScoreCPU || want.RollingAvgRPS != got.RollingAvgRPS {
+				t.Fatalf("Expected device results to match a single owner, got %+v, want %+v", got, want)
+			}
+		}
+	}
+
+	// Shared statistics of all shards add up to the single owner's windows
+	want, got := single.Snapshot(), sharded.Snapshot()
+	if got.Count != want.Count || got.Devices != want.Devices {
+		t.Errorf("Expected %d values and %d devices, got %d and %d", want.Count, want.Devices, got.Count, got.Devices)
+	}
+	for name, pair := range map[string][2]float64{
+		"avg cpu":     {want.AvgCPU, got.AvgCPU},
+		"std dev cpu": {want.StdDevCPU, got.StdDevCPU},
+		"avg rps":     {want.AvgRPS, got.AvgRPS},
+		"std dev rps": {want.StdDevRPS, got.StdDevRPS},
+		"p95 cpu":     {want.PercentilesCPU.P95, got.PercentilesCPU.P95},
+		"p50 rps":     {want.PercentilesRPS.P50, got.PercentilesRPS.P50},
+		"correlation": {want.Correlation, got.Correlation},
+	} {
+		if math.Abs(pair[0]-pair[1]) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", name, pair[0], pair[1])
+		}
+	}
+	wantNamed, _ := single.NamedStats("")
+	gotNamed, _ := sharded.NamedStats("")
+	if w, g := wantNamed["temp"], gotNamed["temp"]; g.Count != w.Count || math.Abs(g.Avg-w.Avg) > 1e-9 || math.Abs(g.StdDev-w.StdDev) > 1e-9 {
+		t.Errorf("Expected merged named stats %+v, got %+v", w, g)
+	}
+
+	// Resetting the shared windows clears every shard and seeds them once
+	_, after, err := sharded.SeedWindows("", WindowSeed{CPU: []float64{10, 20, 30}, RPS: []float64{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Count != 3 || after.AvgCPU != 20 {
+		t.Errorf("Expected shared windows seeded with 3 values averaging 20, got %+v", after)
+	}
+	if s, ok := sharded.DeviceSnapshot("sensor-3"); !ok || s.Count != 12 {
+		t.Errorf("Expected device windows to survive a shared reset, got %+v (ok %v)", s, ok)
+	}
+}
+
+func TestAnalyzer_ShardedWorkersKeepBatchOrder(t *testing.T) {
+	analyzer := NewAnalyzer(64, WithShards(3),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 100}))
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		analyzer.Submit(models.Metric{DeviceID: fmt.Sprintf("sensor-%d", i%7), Timestamp: base.Add(time.Duration(i)), CPU: 50, RPS: 100})
+	}
+	analyzer.Start(1)
+
+	for i := 0; i < 60; i++ {
+		select {
+		case r := <-analyzer.GetResults():
+			if want := base.Add(time.Duration(i)); !r.Result.Timestamp.Equal(want) {
+				t.Fatalf("Result %d: expected timestamp %v, got %v", i, want, r.Result.Timestamp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for result %d", i)
+		}
+	}
+	analyzer.Stop()
+	if s := analyzer.Snapshot(); s.Count != 60 || s.Devices != 7 {
+		t.Errorf("Expected the final snapshot to cover all shards, got %+v", s)
+	}
+}
+
+func TestAnalyzer_ExportedWindowsRestoreStatistics(t *testing.T) {
+	opts := []Option{
+		WithDetectorConfig(DetectorConfig{WindowSize: 20, ZScoreThreshold: 3}),
+		WithDeviceWindows(DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 100}),
+	}
+	previous := NewAnalyzer(1, append(opts, WithShards(2))...)
+	for i := 0; i < 30; i++ {
+		for _, id := range []string{"sensor-1", "sensor-2", "sensor-3"} {
+			previous.AnalyzeSync(models.Metric{DeviceID: id, CPU: float64(i), RPS: float64(100 + i)})
+		}
+	}
+	want, _ := previous.DeviceSnapshot("sensor-2")
+	previous.Stop()
+	// Windows are exported after Stop, when the queue has been processed
+	seeds := previous.ExportWindows()
+	if len(seeds) != 4 {
+		t.Fatalf("Expected shared and 3 device windows, got %d", len(seeds))
+	}
+
+	successor := NewAnalyzer(1, opts...)
+	defer successor.Stop()
+	if n, err := successor.ImportWindows(seeds); err != nil || n != 4 {
+		t.Fatalf("Expected 4 restored windows, got %d (err %v)", n, err)
+	}
+	if got, ok := successor.DeviceSnapshot("sensor-2"); !ok || got.Count != want.Count || got.AvgCPU != want.AvgCPU {
+		t.Errorf("Expected restored device windows %+v, got %+v", want, got)
+	}
+	if got := successor.Snapshot(); got.Count != 20 {
+		t.Errorf("Expected the shared windows of both shards to fill one window, got %+v", got)
+	}
+}
@@ -0,0 +1,149 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression сжатие t-digest по умолчанию: меньше сотни центроидов,
+// ошибка ранга около процента в середине и сотые доли процента в хвостах
+const DefaultCompression = 100
+
+// centroid группа близких значений: среднее и количество
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest скетч распределения (merging t-digest, Dunning): значения сжимаются
+// в центроиды, тем более мелкие, чем ближе они к хвостам, поэтому p99 и p999
+// точнее медианы при памяти O(compression). Скетчи можно сливать, и слияние
+// интервалов дает скетч всего периода. Не безопасен для конкурентного использования
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	min, max    float64
+}
+
+// NewTDigest создает пустой скетч; compression <= 0 — DefaultCompression
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return &TDigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// reset очищает скетч, сохраняя выделенную память
+func (d *TDigest) reset() {
+	d.centroids, d.buffer = d.centroids[:0], d.buffer[:0]
+	d.count, d.min, d.max = 0, math.Inf(1), math.Inf(-1)
+}
+
+// Add добавляет значение; некорректные значения (NaN, ±Inf) отбрасываются
+func (d *TDigest) Add(x float64) {
+	if !IsValidValue(x) {
+		return
+	}
+	d.add(centroid{mean: x, weight: 1})
+}
+
+func (d *TDigest) add(c centroid) {
+	d.buffer = append(d.buffer, c)
+	d.count += c.weight
+	d.min = math.Min(d.min, c.mean)
+	d.max = math.Max(d.max, c.mean)
+	// Буфер сжимается пачками: сортировка на каждое значение была бы дорогой
+	if len(d.buffer) >= int(5*d.compression) {
+		d.compress()
+	}
+}
+
+// Merge добавляет в скетч все значения other; other не меняется
+func (d *TDigest) Merge(other *TDigest) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	for _, c := range other.centroids {
+		d.add(c)
+	}
+	for _, c := range other.buffer {
+		d.add(c)
+	}
+	d.min = math.Min(d.min, other.min)
+	d.max = math.Max(d.max, other.max)
+}
+
+// Count возвращает количество значений
+func (d *TDigest) Count() int64 {
+	return int64(d.count)
+}
+
+// Quantile возвращает оценку квантиля q из [0, 1]; для пустого скетча — 0
+func (d *TDigest) Quantile(q float64) float64 {
+	d.compress()
+	cs := d.centroids
+	switch {
+	case len(cs) == 0:
+		return 0
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	case len(cs) == 1:
+		return cs[0].mean
+	}
+
+	// Значения центроида считаются равномерно распределенными вокруг его
+	// среднего: между серединами соседних центроидов оценка интерполируется
+	// линейно, а до крайних центроидов — от min и до max
+	target := q * d.count
+	if half := cs[0].weight / 2; target < half {
+		return d.min + (cs[0].mean-d.min)*target/half
+	}
+	cum := cs[0].weight / 2
+	for i := 0; i < len(cs)-1; i++ {
+		step := (cs[i].weight + cs[i+1].weight) / 2
+		if target < cum+step {
+			return cs[i].mean + (cs[i+1].mean-cs[i].mean)*(target-cum)/step
+		}
+		cum += step
+	}
+	last := cs[len(cs)-1]
+	if rest := d.count - cum; rest > 0 {
+		return last.mean + (d.max-last.mean)*math.Min(1, (target-cum)/rest)
+	}
+	return last.mean
+}
+
+// compress сливает буфер с центроидами. Соседние центроиды объединяются,
+// пока объединенный занимает не больше единицы шкалы k(q) = δ/2π·asin(2q-1),
+// которая сжимает середину распределения сильнее хвостов
+func (d *TDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.centroids, d.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	cur := all[0]
+	var before float64
+	for _, c := range all[1:] {
+		if d.scale((before+cur.weight+c.weight)/d.count)-d.scale(before/d.count) <= 1 {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+			continue
+		}
+		before += cur.weight
+		merged = append(merged, cur)
+		cur = c
+	}
+	d.centroids = append(merged, cur)
+	d.buffer = d.buffer[:0]
+}
+
+func (d *TDigest) scale(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*math.Min(1, q)-1)
+}
@@ -0,0 +1,112 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestTDigest_QuantileAccuracy(t *testing.T) {
+	d := NewTDigest(DefaultCompression)
+	if d.Quantile(0.5) != 0 || d.Count() != 0 {
+		t.Error("Expected an empty digest to report zero")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = rng.NormFloat64()*10 + 50
+		d.Add(values[i])
+	}
+	d.Add(math.NaN())
+	sort.Float64s(values)
+
+	if d.Count() != int64(len(values)) {
+		t.Errorf("Expected %d values, got %d", len(values), d.Count())
+	}
+	if d.Quantile(0) != values[0] || d.Quantile(1) != values[len(values)-1] {
+		t.Errorf("Expected exact min and max, got %v %v", d.Quantile(0), d.Quantile(1))
+	}
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+		// The error is measured in rank: the tails must be much tighter than the median
+		got := d.Quantile(q)
+		rank := float64(sort.SearchFloat64s(values, got)) / float64(len(values))
+		if tolerance := 0.01 * math.Min(1, 4*q*(1-q)+0.05); math.Abs(rank-q) > tolerance {
+			t.Errorf("Quantile %v: got %v at rank %v", q, got, rank)
+		}
+	}
+	if len(d.centroids) > 3*DefaultCompression {
+		t.Errorf("Expected the digest to stay compact, got %d centroids", len(d.centroids))
+	}
+}
+
+func TestTDigest_MergeMatchesCombinedStream(t *testing.T) {
+	a, b, all := NewTDigest(0), NewTDigest(0), NewTDigest(0)
+	for i := 0; i < 10000; i++ {
+		a.Add(float64(i))
+		all.Add(float64(i))
+		b.Add(float64(10000 + i))
+		all.Add(float64(10000 + i))
+	}
+	a.Merge(b)
+
+	if a.Count() != all.Count() || b.Count() != 10000 {
+		t.Fatalf("Expected merged count %d with the source untouched, got %d and %d", all.Count(), a.Count(), b.Count())
+	}
+	for _, q := range []float64{0.05, 0.5, 0.95, 0.999} {
+		// Both digests estimate the same uniform 0..19999 stream
+		tolerance := 20000 * 0.02 * math.Min(1, 4*q*(1-q)+0.05)
+		for name, d := range map[string]*TDigest{"merged": a, "combined": all} {
+			if got := d.Quantile(q); math.Abs(got-q*20000) > tolerance {
+				t.Errorf("Quantile %v of the %s digest: got %v", q, name, got)
+			}
+		}
+	}
+}
+
+func TestQuantiles_DigestOverRange(t *testing.T) {
+	q := NewQuantiles(QuantileConfig{Resolution: time.Minute, Retention: time.Hour, Compression: DefaultCompression})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Minute i holds CPU values around 10*i
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			q.Observe(models.Metric{
+				Timestamp: start.Add(time.Duration(i)*time.Minute + time.Duration(j)*time.Second/2),
+				CPU:       float64(10*i + j%10),
+				RPS:       100,
+				Values:    map[string]float64{"temp": 20},
+			})
+		}
+	}
+
+	d, err := q.Digest("cpu", start.Add(time.Minute), start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Digest failed: %v", err)
+	}
+	if d.Count() != 200 || d.Quantile(0) != 10 || d.Quantile(1) != 29 {
+		t.Errorf("Expected minutes 1 and 2 only, got %d values in [%v, %v]", d.Count(), d.Quantile(0), d.Quantile(1))
+	}
+	if got := q.Metrics(); len(got) != 3 || got[0] != "cpu" || got[2] != "temp" {
+		t.Errorf("Expected cpu, rps and temp sketches, got %v", got)
+	}
+
+	// A value an hour later takes the slot of minute 0, which expires
+	q.Observe(models.Metric{Timestamp: start.Add(time.Hour), CPU: 99})
+	d, _ = q.Digest("cpu", start.Add(time.Minute/2), start.Add(time.Hour))
+	if d.Count() != 201 || d.Quantile(1) != 99 {
+		t.Errorf("Expected minute 0 to be replaced, got %d values", d.Count())
+	}
+
+	if _, err := q.Digest("disk", start, start.Add(time.Minute)); !errors.Is(err, ErrUnknownQuantileMetric) {
+		t.Errorf("Expected ErrUnknownQuantileMetric, got %v", err)
+	}
+	if _, err := q.Digest("cpu", start, start.Add(2*time.Hour)); !errors.Is(err, ErrQuantileRange) {
+		t.Errorf("Expected ErrQuantileRange for a range beyond retention, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// MaxTimeWindowValues наибольшее количество значений в окне по времени: при
+// потоке выше MaxTimeWindowValues за длительность окна вытесняются самые старые
+const MaxTimeWindowValues = 1 << 16
+
+// timedWindow статистика, которой нужно время значений
+type timedWindow interface {
+	window
+	AddAt(t time.Time, value float64)
+	Expire(now time.Time)
+}
+
+// observe добавляет значение со временем t; окнам по количеству время не нужно
+func observe(w window, t time.Time, value float64) {
+	if tw, ok := w.(timedWindow); ok {
+		tw.AddAt(t, value)
+		return
+	}
+	w.Add(value)
+}
+
+// expire вытесняет из окна по времени значения старше его длительности
+// относительно t; вызывается перед расчетом z-score
+func expire(w window, t time.Time) {
+	if tw, ok := w.(timedWindow); ok {
+		tw.Expire(t)
+	}
+}
+
+// TimeWindow окно значений за последние duration: в отличие от SlidingWindow
+// оно охватывает одинаковый отрезок времени и при высокой, и при низкой
+// нагрузке. Время отсчитывается от самого позднего из полученных значений,
+// поэтому опоздавшие значения не сдвигают окно назад, а значения старше
+// окна отбрасываются
+type TimeWindow struct {
+	duration time.Duration
+	// times и values кольцевой буфер с началом head; растет по мере надобности
+	times  []int64
+	values []float64
+	head   int
+	count  int
+	latest int64
+	sum    float64
+	sumSq  float64
+	// evicted количество вытеснений с последнего пересчета сумм
+	evicted int
+}
+
+// NewTimeWindow создает окно длительностью duration
+func NewTimeWindow(duration time.Duration) *TimeWindow {
+	return &TimeWindow{duration: duration, latest: math.MinInt64}
+}
+
+// Add добавляет значение со временем самого позднего из полученных значений
+func (tw *TimeWindow) Add(value float64) {
+	if tw.latest == math.MinInt64 {
+		tw.AddAt(time.Now(), value)
+		return
+	}
+	tw.AddAt(time.Unix(0, tw.latest), value)
+}
+
+// AddAt добавляет значение со временем t. NaN, ±Inf, значения больше
+// MaxAbsValue и значения старше окна отбрасываются
+func (tw *TimeWindow) AddAt(t time.Time, value float64) {
+	if !IsValidValue(value) {
+		return
+	}
+	ts := t.UnixNano()
+	tw.Expire(t)
+	if ts < tw.latest-int64(tw.duration) {
+		return
+	}
+
+	if tw.count == len(tw.values) {
+		if len(tw.values) < MaxTimeWindowValues {
+			tw.grow()
+		} else {
+			tw.evictOldest()
+		}
+	}
+	i := (tw.head + tw.count) % len(tw.values)
+	tw.times[i], tw.values[i] = ts, value
+	tw.count++
+	tw.sum += value
+	tw.sumSq += value * value
+}
+
+// Expire вытесняет значения старше duration относительно now (или более позднего
+// из уже полученных значений)
+func (tw *TimeWindow) Expire(now time.Time) {
+	if ts := now.UnixNano(); ts > tw.latest {
+		tw.latest = ts
+	}
+	cutoff := tw.latest - int64(tw.duration)
+	for tw.count > 0 && tw.times[tw.head] < cutoff {
+		tw.evictOldest()
+	}
+}
+
+// evictOldest удаляет самое старое значение. Раз за оборот буфера суммы
+// пересчитываются с нуля, чтобы ошибка округления не накапливалась
+func (tw *TimeWindow) evictOldest() {
+	v := tw.values[tw.head]
+	tw.sum -= v
+	tw.sumSq -= v * v
+	tw.head = (tw.head + 1) % len(tw.values)
+	tw.count--
+	tw.evicted++
+	if tw.evicted >= len(tw.values) {
+		tw.resync()
+	}
+}
+
+// grow удваивает буфер, сохраняя порядок значений
+func (tw *TimeWindow) grow() {
+	size := 2 * len(tw.values)
+	if size == 0 {
+		size = 16
+	}
+	size = min(size, MaxTimeWindowValues)
+	times, values := make([]int64, size), make([]float64, size)
+	for i := 0; i < tw.count; i++ {
+		j := (tw.head + i) % len(tw.values)
+		times[i], values[i] = tw.times[j], tw.values[j]
+	}
+	tw.times, tw.values, tw.head = times, values, 0
+}
+
+// resync пересчитывает сумму и сумму квадратов по значениям в окне
+func (tw *TimeWindow) resync() {
+	tw.sum, tw.sumSq, tw.evicted = 0, 0, 0
+	for i := 0; i < tw.count; i++ {
+		v := tw.values[(tw.head+i)%len(tw.values)]
+		tw.sum += v
+		tw.sumSq += v * v
+	}
+}
+
+// Mean возвращает среднее значений окна
+func (tw *TimeWindow) Mean() float64 {
+	if tw.count == 0 {
+		return 0
+	}
+	return tw.sum / float64(tw.count)
+}
+
+// StdDev возвращает стандартное отклонение значений окна
+func (tw *TimeWindow) StdDev() float64 {
+	if tw.count < 2 {
+		return 0
+	}
+	n := float64(tw.count)
+	variance := (tw.sumSq - (tw.sum*tw.sum)/n) / (n - 1)
+	if variance < 0 {
+		variance = 0
+	}
+	return math.Sqrt(variance)
+}
+
+// ZScore вычисляет z-score для заданного значения
+func (tw *TimeWindow) ZScore(value float64) float64 {
+	if !IsValidValue(value) {
+		return 0
+	}
+	return zScore(value, tw.Mean(), tw.StdDev())
+}
+
+// Count возвращает количество значений в окне
+func (tw *TimeWindow) Count() int {
+	return tw.count
+}
+
+// Duration возвращает длительность окна
+func (tw *TimeWindow) Duration() time.Duration {
+	return tw.duration
+}
+
+// Values возвращает значения окна в порядке поступления
+func (tw *TimeWindow) Values() []float64 {
+	out := make([]float64, tw.count)
+	for i := range out {
+		out[i] = tw.values[(tw.head+i)%len(tw.values)]
+	}
+	return out
+}
+
+// Percentiles возвращает p50, p95 и p99 значений окна
+func (tw *TimeWindow) Percentiles() Percentiles {
+	sorted := tw.Values()
+	sort.Float64s(sorted)
+	return Percentiles{P50: percentile(sorted, 0.5), P95: percentile(sorted, 0.95), P99: percentile(sorted, 0.99)}
+}
@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestTimeWindow_EvictsByAge(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tw := NewTimeWindow(time.Minute)
+
+	// 100 values within 10 seconds, then silence and a single value a minute later
+	for i := 0; i < 100; i++ {
+		tw.AddAt(start.Add(time.Duration(i)*100*time.Millisecond), float64(i%2)*10)
+	}
+	if tw.Count() != 100 || tw.Mean() != 5 {
+		t.Fatalf("Expected 100 values with mean 5, got %d/%v", tw.Count(), tw.Mean())
+	}
+	tw.AddAt(start.Add(65*time.Second), 40)
+	if tw.Count() != 51 {
+		t.Errorf("Expected values older than a minute to be evicted, %d left", tw.Count())
+	}
+
+	// A late value inside the window counts, one older than the window does not
+	tw.AddAt(start.Add(30*time.Second), 40)
+	tw.AddAt(start.Add(time.Second), 40)
+	if tw.Count() != 52 {
+		t.Errorf("Expected only the late value within the window to be kept, got %d", tw.Count())
+	}
+
+	tw.Expire(start.Add(10 * time.Minute))
+	if tw.Count() != 0 || tw.Mean() != 0 || tw.StdDev() != 0 {
+		t.Errorf("Expected an empty window after a long pause, got %d values", tw.Count())
+	}
+	tw.AddAt(start.Add(10*time.Minute), math.NaN())
+	if tw.Count() != 0 {
+		t.Error("Expected NaN to be ignored")
+	}
+}
+
+func TestTimeWindow_MatchesSlidingWindowStatistics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tw := NewTimeWindow(time.Hour)
+	sw := NewSlidingWindow(1000)
+	for i := 0; i < 1000; i++ {
+		v := float64((i * 37) % 101)
+		tw.AddAt(start.Add(time.Duration(i)*time.Second), v)
+		sw.Add(v)
+	}
+	if math.Abs(tw.Mean()-sw.Mean()) > 1e-9 || math.Abs(tw.StdDev()-sw.StdDev()) > 1e-9 || tw.Percentiles() != sw.Percentiles() {
+		t.Errorf("Expected the same statistics, time window %v/%v, sliding window %v/%v",
+			tw.Mean(), tw.StdDev(), sw.Mean(), sw.StdDev())
+	}
+}
+
+func TestAnalyzer_TimeWindows(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	analyzer := NewAnalyzer(1, WithDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 3, WindowSeconds: 60}))
+	defer analyzer.Stop()
+
+	// A burst of 500 metrics in 5 seconds stays in the window as a whole
+	for i := 0; i < 500; i++ {
+		analyzer.AnalyzeSync(models.Metric{Timestamp: start.Add(time.Duration(i) * 10 * time.Millisecond), CPU: float64(40 + i%3), RPS: 100})
+	}
+	if s := analyzer.Snapshot(); s.Count != 500 || !s.HasPercentiles {
+		t.Errorf("Expected 500 values with percentiles in a 60s window, got %+v", s)
+	}
+	if r := analyzer.AnalyzeSync(models.Metric{Timestamp: start.Add(6 * time.Second), CPU: 90, RPS: 100}); !r.IsAnomalyCPU {
+		t.Errorf("Expected a spike to be detected, got z=%.2f", r.ZScoreCPU)
+	}
+
+	// Switching to a count window keeps the most recent values
+	if err := analyzer.SetDetectorConfig(DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if s := analyzer.Snapshot(); s.Count != 10 || s.AvgCPU == 0 {
+		t.Errorf("Expected a full count window after the switch, got %+v", s)
+	}
+
+	if (DetectorConfig{WindowSize: 10, ZScoreThreshold: 3, WindowSeconds: 60, Smoothing: SmoothingEWMA}).Validate() == nil {
+		t.Error("Expected window seconds with EWMA to be rejected")
+	}
+}
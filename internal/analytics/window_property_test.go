@@ -0,0 +1,127 @@
+package analytics
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// addSequence is a random window size together with a sequence of values to add.
+type addSequence struct {
+	Size   int
+	Values []float64
+}
+
+// Generate implements quick.Generator. Values mix small, large and repeated
+// numbers in the range seen from real devices.
+func (addSequence) Generate(r *rand.Rand, size int) reflect.Value {
+	seq := addSequence{Size: 1 + r.Intn(100)}
+	n := r.Intn(5 * (size + 1))
+	for i := 0; i < n; i++ {
+		switch r.Intn(4) {
+		case 0:
+			seq.Values = append(seq.Values, r.Float64()*100)
+		case 1:
+			seq.Values = append(seq.Values, r.NormFloat64()*1e4)
+		case 2:
+			seq.Values = append(seq.Values, float64(r.Intn(3)))
+		default:
+			seq.Values = append(seq.Values, 1e6+r.Float64())
+		}
+	}
+	return reflect.ValueOf(seq)
+}
+
+// referenceStats computes mean and sample standard deviation from scratch.
+func referenceStats(values []float64) (mean, stdDev float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+// retained returns the values the window should hold after adding all of them.
+func retained(values []float64, size int) []float64 {
+	if len(values) > size {
+		return values[len(values)-size:]
+	}
+	return values
+}
+
+func TestSlidingWindow_PropertyMatchesReference(t *testing.T) {
+	property := func(seq addSequence) bool {
+		sw := NewSlidingWindow(seq.Size)
+		for i, v := range seq.Values {
+			sw.Add(v)
+
+			kept := retained(seq.Values[:i+1], seq.Size)
+			wantMean, wantStdDev := referenceStats(kept)
+
+			// Incremental sums lose precision proportionally to the magnitude
+			// of the values seen since the last resync (at most two window
+			// lengths back), so the tolerance scales with it.
+			var scale float64 = 1
+			for _, k := range retained(seq.Values[:i+1], 2*seq.Size) {
+				scale = math.Max(scale, math.Abs(k))
+			}
+
+			if sw.Count() != len(kept) {
+				t.Logf("count %d, want %d", sw.Count(), len(kept))
+				return false
+			}
+			if math.Abs(sw.Mean()-wantMean) > 1e-9*scale {
+				t.Logf("mean %v, want %v", sw.Mean(), wantMean)
+				return false
+			}
+			gotVar, wantVar := sw.StdDev()*sw.StdDev(), wantStdDev*wantStdDev
+			if math.Abs(gotVar-wantVar) > 1e-9*scale*scale {
+				t.Logf("variance %v, want %v", gotVar, wantVar)
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlidingWindow_PropertyZScoreSign(t *testing.T) {
+	property := func(seq addSequence, probe float64) bool {
+		sw := NewSlidingWindow(seq.Size)
+		for _, v := range seq.Values {
+			sw.Add(v)
+		}
+
+		z := sw.ZScore(probe)
+		if !IsValidValue(probe) || sw.StdDev() == 0 {
+			return z == 0
+		}
+		switch {
+		case probe > sw.Mean():
+			return z > 0
+		case probe < sw.Mean():
+			return z < 0
+		default:
+			return z == 0
+		}
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWorkerFailed воркер упал (паника) во время анализа метрики
+var ErrWorkerFailed = errors.New("analyzer worker failed")
+
+// worker состояние горутины-воркера для сторожа (watchdog)
+type worker struct {
+	id   int
+	quit chan struct{}
+	// beat время последнего прохода цикла (UnixNano)
+	beat atomic.Int64
+	// busy время начала обработки текущей пачки (UnixNano); 0 — воркер ждет метрики
+	busy atomic.Int64
+	// dead воркер завершился паникой
+	dead atomic.Bool
+	// stalled воркер заменен во время обработки пачки и учтен в Analyzer.stalled
+	stalled atomic.Bool
+	exited  atomic.Bool
+}
+
+// WorkerStatus состояние воркера анализатора
+type WorkerStatus struct {
+	ID int `json:"id"`
+	// LastBeat время последнего прохода цикла воркера
+	LastBeat time.Time `json:"last_beat"`
+	// BusySince начало обработки текущей пачки; нулевое — воркер ждет метрики
+	BusySince time.Time `json:"busy_since,omitempty"`
+	// Dead воркер завершился паникой и требует замены (ReplaceWorker)
+	Dead bool `json:"dead"`
+}
+
+// WorkerLoad накопленная с запуска загрузка воркеров: по разнице двух снимков
+// считаются доля занятого времени и среднее время обработки метрики
+type WorkerLoad struct {
+	// Busy суммарное время обработки пачек всеми воркерами
+	Busy time.Duration
+	// Processed количество метрик, разобранных воркерами
+	Processed int64
+}
+
+// WorkerLoad возвращает накопленную загрузку воркеров
+func (a *Analyzer) WorkerLoad() WorkerLoad {
+	return WorkerLoad{Busy: time.Duration(a.busyTime.Load()), Processed: a.processed.Load()}
+}
+
+// WorkerStatuses возвращает состояние запущенных воркеров
+func (a *Analyzer) WorkerStatuses() []WorkerStatus {
+	a.workersMu.Lock()
+	defer a.workersMu.Unlock()
+	statuses := make([]WorkerStatus, len(a.workers))
+	for i, w := range a.workers {
+		statuses[i] = WorkerStatus{ID: w.id, LastBeat: time.Unix(0, w.beat.Load()), Dead: w.dead.Load()}
+		if busy := w.busy.Load(); busy != 0 {
+			statuses[i].BusySince = time.Unix(0, busy)
+		}
+	}
+	return statuses
+}
+
+// ReplaceWorker останавливает воркер id и запускает вместо него новый.
+// Зависший воркер нельзя прервать: он завершится, когда досчитает пачку, а до
+// тех пор учитывается как зависший; заменять больше зависших воркеров, чем
+// работает, анализатор отказывается, чтобы не копить горутины
+func (a *Analyzer) ReplaceWorker(id int) error {
+	a.workersMu.Lock()
+	defer a.workersMu.Unlock()
+	if a.draining.Load() {
+		return ErrStopped
+	}
+	for i, w := range a.workers {
+		if w.id != id {
+			continue
+		}
+		if w.busy.Load() != 0 && !w.dead.Load() {
+			if int(a.stalled.Load()) >= len(a.workers) {
+				return fmt.Errorf("worker %d not replaced: %d replaced workers are still stuck", id, a.stalled.Load())
+			}
+			a.stalled.Add(1)
+			w.stalled.Store(true)
+			// Воркер мог завершиться до отметки
+			if w.exited.Load() && w.stalled.CompareAndSwap(true, false) {
+				a.stalled.Add(-1)
+			}
+		}
+		close(w.quit)
+		a.workers[i] = a.startWorkerLocked()
+		return nil
+	}
+	return fmt.Errorf("unknown worker %d", id)
+}
@@ -0,0 +1,87 @@
+package analytics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestAnalyzer_PanickingWorkerFailsItsBatchAndIsReplaced(t *testing.T) {
+	analyzer := NewAnalyzer(16)
+	analyzer.onBatch = func(batch []models.Metric) {
+		if batch[0].DeviceID == "poison" {
+			panic("bad metric")
+		}
+	}
+	analyzer.Start(1)
+	defer analyzer.Stop()
+
+	if _, err := analyzer.Analyze(models.Metric{DeviceID: "poison", CPU: 1}); !errors.Is(err, ErrWorkerFailed) {
+		t.Fatalf("Expected ErrWorkerFailed, got %v", err)
+	}
+	statuses := analyzer.WorkerStatuses()
+	if len(statuses) != 1 || !statuses[0].Dead {
+		t.Fatalf("Expected one dead worker, got %+v", statuses)
+	}
+
+	if err := analyzer.ReplaceWorker(statuses[0].ID); err != nil {
+		t.Fatalf("ReplaceWorker failed: %v", err)
+	}
+	statuses = analyzer.WorkerStatuses()
+	if len(statuses) != 1 || statuses[0].Dead || statuses[0].ID == 0 {
+		t.Fatalf("Expected a fresh worker, got %+v", statuses)
+	}
+	if r, err := analyzer.Analyze(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 10}); err != nil || r.RollingAvgCPU != 40 {
+		t.Errorf("Expected the new worker to analyze metrics, got %+v, %v", r, err)
+	}
+	if load := analyzer.WorkerLoad(); load.Processed != 1 {
+		t.Errorf("Expected one metric processed by workers, got %+v", load)
+	}
+	if err := analyzer.ReplaceWorker(42); err == nil {
+		t.Error("Expected an error for an unknown worker")
+	}
+}
+
+func TestAnalyzer_ReplacesStuckWorkerWithinLimit(t *testing.T) {
+	release := make(chan struct{})
+	analyzer := NewAnalyzer(16)
+	analyzer.onBatch = func(batch []models.Metric) {
+		if batch[0].DeviceID == "slow" {
+			<-release
+		}
+	}
+	analyzer.Start(1)
+	defer analyzer.Stop()
+
+	analyzer.Submit(models.Metric{DeviceID: "slow"})
+	deadline := time.Now().Add(time.Second)
+	for analyzer.WorkerStatuses()[0].BusySince.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("Worker never picked up the metric")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stuck := analyzer.WorkerStatuses()[0].ID
+	if err := analyzer.ReplaceWorker(stuck); err != nil {
+		t.Fatalf("ReplaceWorker failed: %v", err)
+	}
+
+	// The replacement keeps analyzing while the stuck worker is still busy
+	if r, err := analyzer.Analyze(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 10}); err != nil || r.RollingAvgCPU != 40 {
+		t.Fatalf("Expected the replacement to analyze metrics, got %+v, %v", r, err)
+	}
+	analyzer.Submit(models.Metric{DeviceID: "slow"})
+	for analyzer.WorkerStatuses()[0].BusySince.IsZero() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := analyzer.ReplaceWorker(analyzer.WorkerStatuses()[0].ID); err == nil {
+		t.Error("Expected a refusal while as many replaced workers as running ones are stuck")
+	}
+
+	close(release)
+	for analyzer.stalled.Load() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
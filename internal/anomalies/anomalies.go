@@ -0,0 +1,365 @@
+// Package anomalies ведет жизненный цикл обнаруженных аномалий: open → acked → resolved.
+//
+// Повторные срабатывания детектора на том же устройстве не создают новую аномалию,
+// а увеличивают счетчик открытой. Пока аномалия подтверждена (acked), повторные
+// оповещения подавляются; после resolved следующее срабатывание открывает новую
+package anomalies
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultCapacity количество аномалий, хранимых в памяти по умолчанию
+	DefaultCapacity = 1000
+	// MaxPinnedResults сколько результатов анализа закрепляет одна аномалия
+	MaxPinnedResults = 100
+)
+
+// State состояние аномалии
+type State string
+
+const (
+	// Open аномалия обнаружена и не обработана
+	Open State = "open"
+	// Acked аномалия подтверждена, повторные оповещения подавляются
+	Acked State = "acked"
+	// Resolved аномалия закрыта
+	Resolved State = "resolved"
+)
+
+var (
+	// ErrNotFound аномалия с таким идентификатором не найдена
+	ErrNotFound = errors.New("anomaly not found")
+	// ErrInvalidTransition переход недопустим из текущего состояния
+	ErrInvalidTransition = errors.New("invalid state transition")
+)
+
+// ParseState разбирает имя состояния
+func ParseState(s string) (State, error) {
+	switch State(s) {
+	case Open, Acked, Resolved:
+		return State(s), nil
+	}
+	return "", fmt.Errorf("unknown anomaly state %q (open, acked, resolved)", s)
+}
+
+// Anomaly аномалия устройства или группы устройств (Group).
+// Поля z-score относятся к последнему срабатыванию
+type Anomaly struct {
+	ID          string     `json:"id"`
+	DeviceID    string     `json:"device_id,omitempty"`
+	Group       string     `json:"group,omitempty"`
+	State       State      `json:"state"`
+	FirstSeen   time.Time  `json:"first_seen"`
+	LastSeen    time.Time  `json:"last_seen"`
+	Occurrences int64      `json:"occurrences"`
+	ZScoreCPU   float64    `json:"z_score_cpu"`
+	ZScoreRPS   float64    `json:"z_score_rps"`
+	AckedBy     string     `json:"acked_by,omitempty"`
+	AckedAt     *time.Time `json:"acked_at,omitempty"`
+	ResolvedBy  string     `json:"resolved_by,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	// Results ключи закрепленных результатов анализа срабатываний (первые MaxPinnedResults)
+	Results []string `json:"results,omitempty"`
+}
+
+// Option настраивает Tracker
+type Option func(*Tracker)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(t *Tracker) {
+		t.clock = c
+	}
+}
+
+// WithCapacity задает количество хранимых аномалий; при переполнении
+// первыми вытесняются самые старые закрытые
+func WithCapacity(n int) Option {
+	return func(t *Tracker) {
+		if n > 0 {
+			t.capacity = n
+		}
+	}
+}
+
+// WithNotifier задает получателя оповещений вместо записи в лог
+func WithNotifier(fn func(Anomaly)) Option {
+	return func(t *Tracker) {
+		t.notify = fn
+	}
+}
+
+// WithTransitions задает получателя изменений состояния (ack и resolve).
+// Вызывается после каждого успешного перехода вне блокировки трекера
+func WithTransitions(fn func(Anomaly)) Option {
+	return func(t *Tracker) {
+		t.transitions = fn
+	}
+}
+
+// WithUnpin задает снятие закрепления результатов: вызывается с ключами
+// результатов аномалии, когда она закрывается или вытесняется незакрытой
+func WithUnpin(fn func(keys []string)) Option {
+	return func(t *Tracker) {
+		t.unpin = fn
+	}
+}
+
+// Tracker хранит аномалии в памяти экземпляра. Безопасен для конкурентного использования
+type Tracker struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	capacity int
+	notify   func(Anomaly)
+	// transitions получатель изменений состояния; nil — не уведомлять
+	transitions func(Anomaly)
+	// unpin снятие закрепления результатов; nil — результаты не закрепляются
+	unpin func(keys []string)
+	seq   int64
+	// order идентификаторы от старых к новым
+	order []string
+	byID  map[string]*Anomaly
+	// active незакрытая аномалия каждого устройства и группы
+	active map[string]*Anomaly
+}
+
+// NewTracker создает пустой трекер
+func NewTracker(opts ...Option) *Tracker {
+	t := &Tracker{
+		clock:    clock.Real(),
+		capacity: DefaultCapacity,
+		notify:   LogAlert,
+		byID:     make(map[string]*Anomaly),
+		active:   make(map[string]*Anomaly),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// LogAlert записывает оповещение в лог; получатель оповещений по умолчанию
+func LogAlert(a Anomaly) {
+	subject := fmt.Sprintf("device %q", a.DeviceID)
+	if a.Group != "" {
+		subject = fmt.Sprintf("group %q", a.Group)
+	}
+	log.Printf("Anomaly %s on %s (occurrence %d): CPU z-score %.2f, RPS z-score %.2f",
+		a.ID, subject, a.Occurrences, a.ZScoreCPU, a.ZScoreRPS)
+}
+
+// Record учитывает результат анализа метрики m. Если аномалия не обнаружена,
+// ничего не происходит. Оповещение отправляется для открытых аномалий
+// и подавляется для подтвержденных
+func (t *Tracker) Record(m models.Metric, result models.AnalysisResult) {
+	t.record(Anomaly{DeviceID: m.DeviceID}, result)
+}
+
+// RecordGroup учитывает результат анализа в окнах группы устройств
+func (t *Tracker) RecordGroup(group string, result models.AnalysisResult) {
+	t.record(Anomaly{Group: group}, result)
+}
+
+// key ключ, по которому повторные срабатывания относятся к одной аномалии
+func (a *Anomaly) key() string {
+	if a.Group != "" {
+		return "group:" + a.Group
+	}
+	return a.DeviceID
+}
+
+func (t *Tracker) record(subject Anomaly, result models.AnalysisResult) {
+	if !result.AnomalyDetected {
+		return
+	}
+
+	var evicted []string
+	t.mu.Lock()
+	a, ok := t.active[subject.key()]
+	if !ok {
+		t.seq++
+		now := t.clock.Now().UTC()
+		a = &subject
+		a.ID = fmt.Sprintf("%d-%d", now.Unix(), t.seq)
+		a.State = Open
+		a.FirstSeen = now
+		t.byID[a.ID] = a
+		t.active[a.key()] = a
+		t.order = append(t.order, a.ID)
+		evicted = t.evictLocked()
+	}
+	a.LastSeen = t.clock.Now().UTC()
+	a.Occurrences++
+	a.ZScoreCPU = result.ZScoreCPU
+	a.ZScoreRPS = result.ZScoreRPS
+	snapshot, alert := *a, a.State == Open
+	t.mu.Unlock()
+	if len(evicted) > 0 {
+		t.unpin(evicted)
+	}
+
+	if !alert {
+		metrics.AnomalyAlerts.WithLabelValues("suppressed").Inc()
+		return
+	}
+	metrics.AnomalyAlerts.WithLabelValues("sent").Inc()
+	t.notify(snapshot)
+}
+
+// Get возвращает аномалию по идентификатору
+func (t *Tracker) Get(id string) (Anomaly, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.byID[id]
+	if !ok {
+		return Anomaly{}, ErrNotFound
+	}
+	return *a, nil
+}
+
+// List возвращает аномалии от новых к старым; пустой state означает все состояния
+func (t *Tracker) List(state State) []Anomaly {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := []Anomaly{}
+	for i := len(t.order) - 1; i >= 0; i-- {
+		a := t.byID[t.order[i]]
+		if state == "" || a.State == state {
+			list = append(list, *a)
+		}
+	}
+	return list
+}
+
+// Ack подтверждает открытую аномалию
+func (t *Tracker) Ack(id, actor string) (Anomaly, error) {
+	return t.transition(t.ack, id, actor)
+}
+
+// Resolve закрывает открытую или подтвержденную аномалию и снимает закрепление ее результатов
+func (t *Tracker) Resolve(id, actor string) (Anomaly, error) {
+	a, err := t.transition(t.resolve, id, actor)
+	if err == nil && t.unpin != nil && len(a.Results) > 0 {
+		t.unpin(a.Results)
+	}
+	return a, err
+}
+
+// Pin закрепляет результат анализа key за незакрытой аномалией устройства.
+// false — закрепление выключено, у устройства нет незакрытой аномалии или
+// она уже закрепила MaxPinnedResults результатов
+func (t *Tracker) Pin(deviceID, key string) bool {
+	if t.unpin == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.active[deviceID]
+	if !ok || a.Group != "" || len(a.Results) >= MaxPinnedResults {
+		return false
+	}
+	a.Results = append(a.Results, key)
+	return true
+}
+
+// transition выполняет переход под блокировкой и уведомляет получателя после нее
+func (t *Tracker) transition(fn func(id, actor string) (Anomaly, error), id, actor string) (Anomaly, error) {
+	t.mu.Lock()
+	a, err := fn(id, actor)
+	t.mu.Unlock()
+	if err == nil && t.transitions != nil {
+		t.transitions(a)
+	}
+	return a, err
+}
+
+// ack и resolve вызываются под блокировкой
+func (t *Tracker) ack(id, actor string) (Anomaly, error) {
+	a, ok := t.byID[id]
+	if !ok {
+		return Anomaly{}, ErrNotFound
+	}
+	if a.State != Open {
+		return Anomaly{}, fmt.Errorf("%w: cannot ack %s anomaly", ErrInvalidTransition, a.State)
+	}
+	now := t.clock.Now().UTC()
+	a.State = Acked
+	a.AckedBy = actor
+	a.AckedAt = &now
+	return *a, nil
+}
+
+func (t *Tracker) resolve(id, actor string) (Anomaly, error) {
+	a, ok := t.byID[id]
+	if !ok {
+		return Anomaly{}, ErrNotFound
+	}
+	if a.State == Resolved {
+		return Anomaly{}, fmt.Errorf("%w: anomaly is already resolved", ErrInvalidTransition)
+	}
+	now := t.clock.Now().UTC()
+	a.State = Resolved
+	a.ResolvedBy = actor
+	a.ResolvedAt = &now
+	delete(t.active, a.key())
+	return *a, nil
+}
+
+// Prune удаляет аномалии, закрытые раньше, чем maxAge назад, и возвращает их количество
+func (t *Tracker) Prune(maxAge time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := t.clock.Now().Add(-maxAge)
+	kept := t.order[:0]
+	pruned := 0
+	for _, id := range t.order {
+		a := t.byID[id]
+		if a.State == Resolved && a.ResolvedAt.Before(cutoff) {
+			delete(t.byID, id)
+			pruned++
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+	return pruned
+}
+
+// evictLocked удаляет лишние аномалии: сначала самую старую закрытую,
+// а если закрытых нет — самую старую; вызывается под mu. Возвращает ключи
+// результатов, закрепленных вытесненными незакрытыми аномалиями
+func (t *Tracker) evictLocked() (pinned []string) {
+	for len(t.order) > t.capacity {
+		victim := 0
+		for i, id := range t.order {
+			if t.byID[id].State == Resolved {
+				victim = i
+				break
+			}
+		}
+		a := t.byID[t.order[victim]]
+		delete(t.byID, a.ID)
+		if t.active[a.key()] == a {
+			delete(t.active, a.key())
+		}
+		if a.State != Resolved {
+			pinned = append(pinned, a.Results...)
+		}
+		t.order = append(t.order[:victim], t.order[victim+1:]...)
+	}
+	return pinned
+}
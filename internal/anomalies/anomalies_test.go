@@ -0,0 +1,164 @@
+package anomalies
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+var anomalous = models.AnalysisResult{AnomalyDetected: true, ZScoreCPU: 4}
+
+func TestTracker_AckSuppressesRepeatAlerts(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	var alerts []Anomaly
+	tr := NewTracker(WithClock(clk), WithNotifier(func(a Anomaly) { alerts = append(alerts, a) }))
+	sensor := models.Metric{DeviceID: "sensor-1"}
+
+	tr.Record(sensor, anomalous)
+	tr.Record(sensor, models.AnalysisResult{})
+	tr.Record(sensor, anomalous)
+	if len(alerts) != 2 || alerts[1].Occurrences != 2 || alerts[0].ID != alerts[1].ID {
+		t.Fatalf("Expected two alerts for one open anomaly, got %+v", alerts)
+	}
+	id := alerts[0].ID
+
+	clk.Advance(time.Minute)
+	acked, err := tr.Ack(id, "oncall")
+	if err != nil || acked.State != Acked || acked.AckedBy != "oncall" || !acked.AckedAt.Equal(clk.Now()) {
+		t.Fatalf("Unexpected ack result %+v (%v)", acked, err)
+	}
+	tr.Record(sensor, anomalous)
+	if len(alerts) != 2 {
+		t.Errorf("Repeat alerts must be suppressed for acked anomalies, got %d alerts", len(alerts))
+	}
+	if a, _ := tr.Get(id); a.Occurrences != 3 {
+		t.Errorf("Suppressed occurrences should still be counted, got %d", a.Occurrences)
+	}
+
+	if _, err := tr.Ack(id, "oncall"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Expected ErrInvalidTransition for second ack, got %v", err)
+	}
+	if _, err := tr.Resolve(id, "oncall"); err != nil {
+		t.Fatal(err)
+	}
+
+	// After resolution the next detection opens a new anomaly and alerts again
+	tr.Record(sensor, anomalous)
+	if len(alerts) != 3 || alerts[2].ID == id {
+		t.Errorf("Expected a new anomaly after resolve, got %+v", alerts)
+	}
+	if open := tr.List(Open); len(open) != 1 || len(tr.List("")) != 2 {
+		t.Errorf("Unexpected list: open=%d all=%d", len(open), len(tr.List("")))
+	}
+	if _, err := tr.Resolve("missing", "oncall"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if n := tr.Prune(time.Hour); n != 1 || len(tr.List("")) != 1 {
+		t.Errorf("Expected the resolved anomaly to be pruned, pruned=%d left=%d", n, len(tr.List("")))
+	}
+}
+
+func TestTracker_EvictsResolvedFirst(t *testing.T) {
+	tr := NewTracker(WithCapacity(2), WithNotifier(func(Anomaly) {}))
+
+	tr.Record(models.Metric{DeviceID: "a"}, anomalous)
+	tr.Record(models.Metric{DeviceID: "b"}, anomalous)
+	resolved := tr.List("")[0]
+	tr.Resolve(resolved.ID, "oncall")
+	tr.Record(models.Metric{DeviceID: "c"}, anomalous)
+
+	list := tr.List("")
+	if len(list) != 2 {
+		t.Fatalf("Expected capacity of 2, got %d", len(list))
+	}
+	for _, a := range list {
+		if a.ID == resolved.ID {
+			t.Errorf("Resolved anomaly should be evicted before open ones")
+		}
+	}
+}
+
+func TestEvents_RecordExplainsAndFiltersByPeriod(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	e := NewEvents(cache.NewMemoryCache(clk))
+
+	e.Record(models.Metric{DeviceID: "sensor-1", CPU: 50}, models.AnalysisResult{})
+	e.Record(models.Metric{DeviceID: "sensor-1", CPU: 95, RPS: 100, Values: map[string]float64{"temp": 80, "disk": 1}},
+		models.AnalysisResult{
+			AnomalyDetected: true, Episode: models.EpisodeOpened, Threshold: 2,
+			ZScoreCPU: 9, IsAnomalyCPU: true, BaselineCPU: models.Baseline{Mean: 50, StdDev: 5, Count: 40},
+			Values: map[string]models.ValueResult{"temp": {ZScore: -3, IsAnomaly: true}, "disk": {ZScore: 1}},
+		})
+	// Enough events of another device to span several pages of the stream
+	for i := 0; i < 2*eventPage; i++ {
+		clk.Advance(time.Millisecond)
+		e.Record(models.Metric{DeviceID: fmt.Sprintf("sensor-%d", 2+i%2)}, anomalous)
+	}
+	clk.Advance(time.Minute)
+	e.Record(models.Metric{DeviceID: "sensor-1"}, anomalous)
+
+	events, truncated, err := e.Query(EventQuery{From: start, To: start.Add(time.Second), DeviceID: "sensor-1"})
+	if err != nil || truncated || len(events) != 1 {
+		t.Fatalf("Expected the one event of sensor-1 within a second, got %d (truncated %v, %v)", len(events), truncated, err)
+	}
+	ev := events[0]
+	if !ev.DetectedAt.Equal(start) || ev.Episode != models.EpisodeOpened || len(ev.Fields) != 3 {
+		t.Fatalf("Expected an opened event with cpu, rps and temp, got %+v", ev)
+	}
+	cpu := ev.Fields[0]
+	if cpu.Metric != "cpu" || !cpu.Anomalous || cpu.Value != 95 || cpu.ExpectedMin != 40 || cpu.ExpectedMax != 60 || cpu.Window.Count != 40 {
+		t.Errorf("Expected cpu 95 outside [40, 60], got %+v", cpu)
+	}
+	if ev.Fields[1].Metric != "rps" || ev.Fields[1].Anomalous || ev.Fields[2].Metric != "temp" || ev.Fields[2].Value != 80 {
+		t.Errorf("Expected a normal rps and the anomalous temp only, got %+v", ev.Fields[1:])
+	}
+
+	events, _, _ = e.Query(EventQuery{From: start, To: start.Add(time.Hour), DeviceID: "sensor-1"})
+	if len(events) != 2 {
+		t.Errorf("Expected both events of sensor-1 across pages, got %d", len(events))
+	}
+	events, truncated, _ = e.Query(EventQuery{From: start.Add(time.Millisecond), To: start.Add(time.Hour), DeviceID: "sensor-2", Limit: 100})
+	if len(events) != 100 || !truncated || events[0].DeviceID != "sensor-2" {
+		t.Errorf("Expected 100 events of sensor-2 and a truncated result, got %d (truncated %v)", len(events), truncated)
+	}
+	if all, _, _ := e.Query(EventQuery{From: start, To: start.Add(time.Hour)}); len(all) != MaxEvents {
+		t.Errorf("Expected the result to be capped at %d events, got %d", MaxEvents, len(all))
+	}
+}
+
+func TestTracker_PinsResultsOfUnresolvedAnomalies(t *testing.T) {
+	var unpinned []string
+	tr := NewTracker(WithCapacity(1), WithUnpin(func(keys []string) { unpinned = append(unpinned, keys...) }))
+
+	if tr.Pin("sensor-1", "analysis:sensor-1:1") {
+		t.Error("Expected nothing to be pinned without an open anomaly")
+	}
+	tr.Record(models.Metric{DeviceID: "sensor-1"}, anomalous)
+	for i := 0; i < MaxPinnedResults+1; i++ {
+		if pinned := tr.Pin("sensor-1", fmt.Sprintf("analysis:sensor-1:%d", i)); pinned != (i < MaxPinnedResults) {
+			t.Fatalf("Result %d: expected pinned=%v", i, i < MaxPinnedResults)
+		}
+	}
+
+	// An unresolved anomaly evicted by a newer one releases its results
+	tr.Record(models.Metric{DeviceID: "sensor-2"}, anomalous)
+	if len(unpinned) != MaxPinnedResults || unpinned[0] != "analysis:sensor-1:0" {
+		t.Fatalf("Expected the evicted anomaly to unpin its results, got %d", len(unpinned))
+	}
+	tr.Pin("sensor-2", "analysis:sensor-2:1")
+	a := tr.List("")[0]
+	if _, err := tr.Resolve(a.ID, "oncall"); err != nil || len(unpinned) != MaxPinnedResults+1 {
+		t.Errorf("Expected resolve to unpin the result, got %d (%v)", len(unpinned), err)
+	}
+	if tr.Pin("sensor-2", "analysis:sensor-2:2") {
+		t.Error("Expected a resolved anomaly not to pin results")
+	}
+}
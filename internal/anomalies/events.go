@@ -0,0 +1,196 @@
+package anomalies
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/models"
+)
+
+const (
+	// EventStream поток событий аномалий
+	EventStream = "anomalies:events"
+	// DefaultEventMaxLen примерное количество хранимых событий
+	DefaultEventMaxLen = 100000
+	// MaxEvents ограничение числа событий в одном ответе
+	MaxEvents = 1000
+	// eventPage количество записей, читаемых из потока за раз
+	eventPage = 500
+)
+
+// EventLog хранилище событий (реализуется cache.RedisCache и cache.MemoryCache)
+type EventLog interface {
+	AppendStream(stream string, data []byte, maxLen int64) (string, error)
+	RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error)
+}
+
+// Event событие аномалии: метрика, пришедшая при открытом эпизоде, с объяснением.
+// В отличие от Anomaly, которая копит повторные срабатывания, событие
+// записывается на каждую такую метрику и не меняется
+type Event struct {
+	// ID идентификатор записи потока "<unix ms>-<seq>"
+	ID string `json:"id"`
+	// DetectedAt время записи события; по нему выбирается период
+	DetectedAt time.Time `json:"detected_at"`
+	// Timestamp время метрики
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Episode   string    `json:"episode,omitempty"`
+	// Fields объяснения CPU, RPS и именованных показателей, превысивших порог
+	Fields []Explanation `json:"fields"`
+}
+
+// Explanation почему значение показателя сочтено аномальным: ожидаемый
+// диапазон — среднее окна ± порог стандартных отклонений
+type Explanation struct {
+	Metric      string          `json:"metric"`
+	Value       float64         `json:"value"`
+	ZScore      float64         `json:"z_score"`
+	Anomalous   bool            `json:"anomalous"`
+	ExpectedMin float64         `json:"expected_min"`
+	ExpectedMax float64         `json:"expected_max"`
+	Window      models.Baseline `json:"window"`
+}
+
+// EventQuery отбор событий: период обнаружения [From, To] и устройство
+type EventQuery struct {
+	From     time.Time
+	To       time.Time
+	DeviceID string
+	// Limit наибольшее количество событий; 0 и больше MaxEvents — MaxEvents
+	Limit int
+}
+
+// EventOption настраивает Events
+type EventOption func(*Events)
+
+// WithEventMaxLen задает примерное количество хранимых событий
+func WithEventMaxLen(n int64) EventOption {
+	return func(e *Events) {
+		if n > 0 {
+			e.maxLen = n
+		}
+	}
+}
+
+// Events журнал событий аномалий. Безопасен для конкурентного использования
+type Events struct {
+	log    EventLog
+	maxLen int64
+}
+
+// NewEvents создает журнал событий поверх log
+func NewEvents(log EventLog, opts ...EventOption) *Events {
+	e := &Events{log: log, maxLen: DefaultEventMaxLen}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Record записывает событие, если в результате обнаружена аномалия. Ошибка
+// записи только логируется: журнал не должен останавливать прием метрик
+func (e *Events) Record(m models.Metric, result models.AnalysisResult) {
+	if !result.AnomalyDetected {
+		return
+	}
+	event := Event{
+		Timestamp: result.Timestamp,
+		DeviceID:  m.DeviceID,
+		Region:    m.Region,
+		Episode:   result.Episode,
+		Fields: []Explanation{
+			explain("cpu", m.CPU, result.ZScoreCPU, result.IsAnomalyCPU, result.BaselineCPU, result.Threshold),
+			explain("rps", m.RPS, result.ZScoreRPS, result.IsAnomalyRPS, result.BaselineRPS, result.Threshold),
+		},
+	}
+	for name, v := range result.Values {
+		if v.IsAnomaly {
+			event.Fields = append(event.Fields, explain(name, m.Values[name], v.ZScore, true, v.Baseline, result.Threshold))
+		}
+	}
+	// Именованные показатели в порядке имен, чтобы события одной метрики совпадали
+	named := event.Fields[2:]
+	sort.Slice(named, func(i, j int) bool { return named[i].Metric < named[j].Metric })
+
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = e.log.AppendStream(EventStream, data, e.maxLen)
+	}
+	if err != nil {
+		log.Printf("Failed to record anomaly event for device %q: %v", m.DeviceID, err)
+	}
+}
+
+// explain объясняет значение показателя относительно ожидания окна
+func explain(metric string, value, z float64, anomalous bool, b models.Baseline, threshold float64) Explanation {
+	return Explanation{
+		Metric:      metric,
+		Value:       value,
+		ZScore:      z,
+		Anomalous:   anomalous,
+		ExpectedMin: b.Mean - threshold*b.StdDev,
+		ExpectedMax: b.Mean + threshold*b.StdDev,
+		Window:      b,
+	}
+}
+
+// Query возвращает события периода от старых к новым и признак того, что
+// событий больше Limit и ответ усечен
+func (e *Events) Query(q EventQuery) ([]Event, bool, error) {
+	if q.Limit <= 0 || q.Limit > MaxEvents {
+		q.Limit = MaxEvents
+	}
+	start := strconv.FormatInt(q.From.UnixMilli(), 10) + "-0"
+	last := q.To.UnixMilli()
+
+	events := []Event{}
+	after := ""
+	for {
+		raw, err := e.log.RangeStream(EventStream, start, eventPage+1)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, r := range raw {
+			// Начало диапазона включено, а последняя запись прошлой страницы уже прочитана
+			if r.ID == after {
+				continue
+			}
+			ms := eventMillis(r.ID)
+			if ms > last {
+				return events, false, nil
+			}
+			var ev Event
+			if err := json.Unmarshal(r.Data, &ev); err != nil {
+				log.Printf("Skipping malformed anomaly event %s: %v", r.ID, err)
+				continue
+			}
+			if q.DeviceID != "" && ev.DeviceID != q.DeviceID {
+				continue
+			}
+			if len(events) == q.Limit {
+				return events, true, nil
+			}
+			ev.ID, ev.DetectedAt = r.ID, time.UnixMilli(ms).UTC()
+			events = append(events, ev)
+		}
+		if len(raw) <= eventPage {
+			return events, false, nil
+		}
+		start = raw[len(raw)-1].ID
+		after = start
+	}
+}
+
+// eventMillis время записи из идентификатора "<unix ms>-<seq>"
+func eventMillis(id string) int64 {
+	ms, _, _ := strings.Cut(id, "-")
+	v, _ := strconv.ParseInt(ms, 10, 64)
+	return v
+}
@@ -0,0 +1,114 @@
+// Package audit реализует журнал аудита административных операций:
+// кто, когда и что изменил. Записи пишутся JSON-строками в отдельный
+// вывод, а последние из них доступны в памяти для просмотра через API
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+)
+
+// DefaultCapacity количество записей, хранимых в памяти по умолчанию
+const DefaultCapacity = 256
+
+// Event запись журнала аудита
+type Event struct {
+	Time time.Time `json:"time"`
+	// Actor инициатор операции (например, адрес клиента)
+	Actor string `json:"actor"`
+	// Action имя операции, например "runtime.update"
+	Action string `json:"action"`
+	// Before и After состояние до и после изменения
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Log журнал аудита. Безопасен для конкурентного использования
+type Log struct {
+	mu       sync.Mutex
+	out      io.Writer
+	clock    clock.Clock
+	events   []Event
+	next     int
+	capacity int
+	// subscriber получатель записей, nil — записи никуда не передаются
+	subscriber func(Event)
+}
+
+// Option настраивает журнал
+type Option func(*Log)
+
+// WithSubscriber передает fn каждую запись после ее сохранения, вне
+// блокировки журнала
+func WithSubscriber(fn func(Event)) Option {
+	return func(l *Log) {
+		l.subscriber = fn
+	}
+}
+
+// New создает журнал; out может быть nil, тогда записи хранятся только в памяти
+func New(out io.Writer, c clock.Clock, capacity int, opts ...Option) *Log {
+	if c == nil {
+		c = clock.Real()
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	l := &Log{out: out, clock: c, capacity: capacity}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Record добавляет запись; время заполняется автоматически
+func (l *Log) Record(e Event) {
+	e = l.record(e)
+	if l.subscriber != nil {
+		l.subscriber(e)
+	}
+}
+
+// record сохраняет запись и возвращает ее с заполненным временем
+func (l *Log) record(e Event) Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = l.clock.Now().UTC()
+	}
+	if len(l.events) < l.capacity {
+		l.events = append(l.events, e)
+	} else {
+		l.events[l.next] = e
+		l.next = (l.next + 1) % l.capacity
+	}
+
+	if l.out == nil {
+		return e
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode audit event %s: %v", e.Action, err)
+		return e
+	}
+	if _, err := l.out.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit event %s: %v", e.Action, err)
+	}
+	return e
+}
+
+// Recent возвращает записи из памяти в хронологическом порядке
+func (l *Log) Recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]Event, 0, len(l.events))
+	out = append(out, l.events[l.next:]...)
+	return append(out, l.events[:l.next]...)
+}
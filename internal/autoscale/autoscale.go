@@ -0,0 +1,221 @@
+// Package autoscale подбирает количество воркеров анализатора по нагрузке:
+// по заполненности очереди и по загрузке воркеров (доле времени, занятой
+// обработкой пачек) с учетом среднего времени обработки метрики. Количество
+// остается в пределах [Min, Max]; WORKER_COUNT задает только начальное
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+// Причины изменения количества воркеров
+const (
+	// ReasonBacklog очередь заполнена больше ScaleUpBacklog: воркеры удваиваются
+	ReasonBacklog = "backlog"
+	// ReasonUtilization воркеры заняты больше ScaleUpUtilization: добавляется один
+	ReasonUtilization = "utilization"
+	// ReasonLatency среднее время обработки метрики выше MaxLatency: добавляется один
+	ReasonLatency = "latency"
+	// ReasonIdle воркеры заняты меньше ScaleDownUtilization при пустой очереди: убирается один
+	ReasonIdle = "idle"
+	// ReasonBounds количество вне [Min, Max] (например, задано через /admin/runtime)
+	ReasonBounds = "bounds"
+)
+
+// Config настройки автомасштабирования
+type Config struct {
+	Enabled bool
+	// Min и Max пределы количества воркеров
+	Min int
+	Max int
+	// Interval период пересчета
+	Interval time.Duration
+	// ScaleUpBacklog доля емкости очереди, при которой воркеры удваиваются
+	ScaleUpBacklog float64
+	// ScaleUpUtilization и ScaleDownUtilization доли занятого времени воркеров,
+	// выше которой воркер добавляется и ниже которой убирается
+	ScaleUpUtilization   float64
+	ScaleDownUtilization float64
+	// MaxLatency среднее время обработки метрики воркером, выше которого
+	// добавляется воркер; 0 — не учитывается
+	MaxLatency time.Duration
+	// Cooldown минимальный интервал от последнего изменения до уменьшения
+	Cooldown time.Duration
+}
+
+// Validate проверяет настройки; maxWorkers верхняя граница Max
+func (c Config) Validate(maxWorkers int) error {
+	if c.Min < 1 || c.Max < c.Min || c.Max > maxWorkers {
+		return fmt.Errorf("WORKER_MIN and WORKER_MAX: expected 1 <= min <= max <= %d, got %d and %d", maxWorkers, c.Min, c.Max)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("AUTOSCALE_INTERVAL: must be positive, got %s", c.Interval)
+	}
+	if c.ScaleUpBacklog <= 0 || c.ScaleUpBacklog > 1 {
+		return fmt.Errorf("AUTOSCALE_BACKLOG: must be within (0, 1], got %v", c.ScaleUpBacklog)
+	}
+	if c.ScaleDownUtilization < 0 || c.ScaleDownUtilization >= c.ScaleUpUtilization || c.ScaleUpUtilization > 1 {
+		return fmt.Errorf("AUTOSCALE_DOWN_UTILIZATION and AUTOSCALE_UP_UTILIZATION: expected 0 <= down < up <= 1, got %v and %v",
+			c.ScaleDownUtilization, c.ScaleUpUtilization)
+	}
+	return nil
+}
+
+// Clamp ограничивает n пределами [Min, Max]
+func (c Config) Clamp(n int) int {
+	return min(max(n, c.Min), c.Max)
+}
+
+// Pool воркеры анализатора и их очередь (реализуется analytics.Analyzer)
+type Pool interface {
+	Workers() int
+	SetWorkers(n int) error
+	QueueLength() int
+	QueueCapacity() int
+	WorkerLoad() analytics.WorkerLoad
+}
+
+// Status состояние автомасштабирования по последнему пересчету
+type Status struct {
+	Workers int `json:"workers"`
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	// Backlog метрики в очереди; Capacity емкость очереди
+	Backlog  int `json:"backlog"`
+	Capacity int `json:"capacity"`
+	// Utilization доля времени, занятая обработкой, за последний интервал
+	Utilization float64 `json:"utilization"`
+	// MetricLatency среднее время обработки метрики воркером за последний интервал
+	MetricLatency string `json:"metric_latency"`
+	// LastChange время и причина последнего изменения количества
+	LastChange *time.Time `json:"last_change,omitempty"`
+	LastReason string     `json:"last_reason,omitempty"`
+}
+
+// Option настраивает Scaler
+type Option func(*Scaler)
+
+// WithClock задает источник времени; должен совпадать с часами анализатора
+func WithClock(c clock.Clock) Option {
+	return func(s *Scaler) {
+		s.clock = c
+	}
+}
+
+// Scaler изменяет количество воркеров пула по нагрузке
+type Scaler struct {
+	cfg   Config
+	pool  Pool
+	clock clock.Clock
+
+	mu         sync.Mutex
+	lastLoad   analytics.WorkerLoad
+	lastAt     time.Time
+	lastChange time.Time
+	status     Status
+}
+
+// New создает Scaler; первый интервал отсчитывается от создания
+func New(cfg Config, pool Pool, opts ...Option) *Scaler {
+	s := &Scaler{cfg: cfg, pool: pool, clock: clock.Real()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.lastLoad = pool.WorkerLoad()
+	s.lastAt = s.clock.Now()
+	s.status = Status{Workers: pool.Workers(), Min: cfg.Min, Max: cfg.Max, Capacity: pool.QueueCapacity(), MetricLatency: "0s"}
+	return s
+}
+
+// Run пересчитывает количество воркеров с периодом Interval до отмены контекста
+func (s *Scaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check()
+		}
+	}
+}
+
+// Status возвращает состояние по последнему пересчету
+func (s *Scaler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Check пересчитывает загрузку за время с прошлого вызова и при необходимости
+// изменяет количество воркеров. Возвращает причину изменения или пустую строку
+func (s *Scaler) Check() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	load := s.pool.WorkerLoad()
+	elapsed := now.Sub(s.lastAt)
+	busy := load.Busy - s.lastLoad.Busy
+	processed := load.Processed - s.lastLoad.Processed
+	s.lastLoad, s.lastAt = load, now
+
+	workers := s.pool.Workers()
+	var utilization float64
+	if elapsed > 0 && workers > 0 {
+		utilization = min(float64(busy)/(float64(elapsed)*float64(workers)), 1)
+	}
+	var latency time.Duration
+	if processed > 0 {
+		latency = busy / time.Duration(processed)
+	}
+	backlog, capacity := s.pool.QueueLength(), s.pool.QueueCapacity()
+
+	target, reason := workers, ""
+	switch {
+	case workers != s.cfg.Clamp(workers):
+		target, reason = s.cfg.Clamp(workers), ReasonBounds
+	case float64(backlog) >= s.cfg.ScaleUpBacklog*float64(capacity):
+		target, reason = workers*2, ReasonBacklog
+	case utilization >= s.cfg.ScaleUpUtilization:
+		target, reason = workers+1, ReasonUtilization
+	case s.cfg.MaxLatency > 0 && latency >= s.cfg.MaxLatency:
+		target, reason = workers+1, ReasonLatency
+	case utilization <= s.cfg.ScaleDownUtilization && backlog == 0 && now.Sub(s.lastChange) >= s.cfg.Cooldown:
+		target, reason = workers-1, ReasonIdle
+	}
+	target = s.cfg.Clamp(target)
+
+	s.status.Backlog, s.status.Capacity = backlog, capacity
+	s.status.Utilization = utilization
+	s.status.MetricLatency = latency.String()
+	s.status.Workers = workers
+	if target == workers {
+		return ""
+	}
+	if err := s.pool.SetWorkers(target); err != nil {
+		log.Printf("Failed to scale analyzer workers %d -> %d: %v", workers, target, err)
+		return ""
+	}
+	direction := "up"
+	if target < workers {
+		direction = "down"
+	}
+	metrics.WorkerScaling.WithLabelValues(direction, reason).Inc()
+	log.Printf("Scaled analyzer workers %d -> %d (%s: backlog %d/%d, utilization %.2f, metric latency %s)",
+		workers, target, reason, backlog, capacity, utilization, latency)
+	s.status.Workers = target
+	s.lastChange = now
+	s.status.LastChange, s.status.LastReason = &now, reason
+	return reason
+}
@@ -0,0 +1,132 @@
+package autoscale
+
+import (
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+)
+
+type fakePool struct {
+	workers  int
+	depth    int
+	capacity int
+	load     analytics.WorkerLoad
+}
+
+func (p *fakePool) Workers() int                     { return p.workers }
+func (p *fakePool) SetWorkers(n int) error           { p.workers = n; return nil }
+func (p *fakePool) QueueLength() int                 { return p.depth }
+func (p *fakePool) QueueCapacity() int               { return p.capacity }
+func (p *fakePool) WorkerLoad() analytics.WorkerLoad { return p.load }
+
+// busy records d of work per worker over the next interval
+func (p *fakePool) busy(d time.Duration, metrics int64) {
+	p.load.Busy += d * time.Duration(p.workers)
+	p.load.Processed += metrics
+}
+
+func testConfig() Config {
+	return Config{
+		Enabled:              true,
+		Min:                  2,
+		Max:                  8,
+		Interval:             time.Second,
+		ScaleUpBacklog:       0.5,
+		ScaleUpUtilization:   0.8,
+		ScaleDownUtilization: 0.3,
+		Cooldown:             10 * time.Second,
+	}
+}
+
+func TestScaler_ScalesUpOnBacklogAndUtilization(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	pool := &fakePool{workers: 2, capacity: 100}
+	s := New(testConfig(), pool, WithClock(clk))
+
+	pool.depth = 60
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != ReasonBacklog || pool.workers != 4 {
+		t.Fatalf("Expected workers doubled on backlog, got %q and %d", reason, pool.workers)
+	}
+	clk.Advance(time.Second)
+	if s.Check(); pool.workers != 8 {
+		t.Fatalf("Expected workers doubled again, got %d", pool.workers)
+	}
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != "" || pool.workers != 8 {
+		t.Fatalf("Expected WORKER_MAX to cap the pool, got %q and %d", reason, pool.workers)
+	}
+
+	pool.depth, pool.workers = 0, 4
+	pool.busy(900*time.Millisecond, 1000)
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != ReasonUtilization || pool.workers != 5 {
+		t.Fatalf("Expected one more worker at 90%% utilization, got %q and %d", reason, pool.workers)
+	}
+	status := s.Status()
+	if status.Utilization < 0.89 || status.MetricLatency != "3.6ms" || status.LastReason != ReasonUtilization {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestScaler_ScalesUpOnLatency(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	cfg := testConfig()
+	cfg.MaxLatency = time.Millisecond
+	pool := &fakePool{workers: 2, capacity: 100}
+	s := New(cfg, pool, WithClock(clk))
+
+	pool.busy(500*time.Millisecond, 100)
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != ReasonLatency || pool.workers != 3 {
+		t.Errorf("Expected one more worker at 10ms per metric, got %q and %d", reason, pool.workers)
+	}
+}
+
+func TestScaler_ScalesDownAfterCooldownAndKeepsBounds(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	pool := &fakePool{workers: 4, capacity: 100}
+	s := New(testConfig(), pool, WithClock(clk))
+
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != ReasonIdle || pool.workers != 3 {
+		t.Fatalf("Expected one worker removed when idle, got %q and %d", reason, pool.workers)
+	}
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != "" || pool.workers != 3 {
+		t.Fatalf("Expected cooldown to hold the pool, got %q and %d", reason, pool.workers)
+	}
+	clk.Advance(10 * time.Second)
+	s.Check()
+	clk.Advance(10 * time.Second)
+	if s.Check(); pool.workers != 2 {
+		t.Fatalf("Expected WORKER_MIN to stop scaling down, got %d", pool.workers)
+	}
+
+	// A count set through /admin/runtime is pulled back into bounds
+	pool.workers = 20
+	clk.Advance(time.Second)
+	if reason := s.Check(); reason != ReasonBounds || pool.workers != 8 {
+		t.Errorf("Expected the pool clamped to WORKER_MAX, got %q and %d", reason, pool.workers)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := testConfig().Validate(1024); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	for name, mutate := range map[string]func(*Config){
+		"min above max":        func(c *Config) { c.Min = 9 },
+		"max above limit":      func(c *Config) { c.Max = 2000 },
+		"down above up":        func(c *Config) { c.ScaleDownUtilization = 0.9 },
+		"backlog out of range": func(c *Config) { c.ScaleUpBacklog = 0 },
+	} {
+		cfg := testConfig()
+		mutate(&cfg)
+		if err := cfg.Validate(1024); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
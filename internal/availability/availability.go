@@ -0,0 +1,264 @@
+// Package availability ведет учет доступности сервиса в Redis. Каждая реплика,
+// пока она готова принимать трафик, отмечает текущую минуту в битовой карте
+// суток; минута считается доступной, если ее отметила хотя бы одна реплика.
+// Поэтому падение без штатной остановки тоже видно — как пропуск в карте.
+// Запуск, остановка и переходы готовности реплик пишутся в поток событий
+package availability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// KeyPrefix префикс битовых карт суток: availability:2006-01-02 (UTC)
+	KeyPrefix = "availability:"
+	// MetaKey хеш с началом учета (поле since)
+	MetaKey = "availability:meta"
+	// TransitionsStream поток событий запуска, остановки и готовности реплик
+	TransitionsStream = "availability:transitions"
+	// Retention срок хранения карты суток: месячный отчет читает до 31 карты
+	Retention = 35 * 24 * time.Hour
+	// DefaultInterval период проверки готовности
+	DefaultInterval = 15 * time.Second
+
+	maxTransitions = 10000
+	sinceField     = "since"
+)
+
+// События реплики (поле event записи потока)
+const (
+	EventStart   = "start"
+	EventReady   = "ready"
+	EventUnready = "unready"
+	EventStop    = "stop"
+)
+
+// Store хранит карты доступности, начало учета и события
+// (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	MarkSlot(key string, slot int64, ttl time.Duration) error
+	CountSlots(keys []string) ([]int64, error)
+	UpdateHashes(updates []cache.HashUpdate, ttl time.Duration) error
+	GetHash(key string) (map[string]string, error)
+	AppendStream(stream string, data []byte, maxLen int64) (string, error)
+}
+
+// Transition событие реплики в потоке TransitionsStream
+type Transition struct {
+	Node  string    `json:"node"`
+	Event string    `json:"event"`
+	At    time.Time `json:"at"`
+}
+
+// Option настраивает Tracker
+type Option func(*Tracker)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(t *Tracker) {
+		t.clock = c
+	}
+}
+
+// WithInterval задает период проверки готовности; он должен быть меньше минуты,
+// иначе готовая реплика пропускает минуты
+func WithInterval(d time.Duration) Option {
+	return func(t *Tracker) {
+		t.interval = d
+	}
+}
+
+// Tracker учет доступности одной реплики. Безопасен для конкурентного использования
+type Tracker struct {
+	store    Store
+	node     string
+	clock    clock.Clock
+	interval time.Duration
+
+	mu    sync.Mutex
+	since time.Time
+	ready bool
+	// marked последняя отмеченная минута, Unix-время в минутах
+	marked int64
+}
+
+// New создает учет доступности реплики node
+func New(store Store, node string, opts ...Option) *Tracker {
+	t := &Tracker{
+		store:    store,
+		node:     node,
+		clock:    clock.Real(),
+		interval: DefaultInterval,
+		marked:   -1,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.since = t.clock.Now().UTC()
+	return t
+}
+
+// Start записывает запуск реплики и читает начало учета; первая реплика,
+// запущенная с учетом, задает его. До начала учета простоем ничего не считается
+func (t *Tracker) Start() error {
+	now := t.clock.Now().UTC()
+	meta, err := t.store.GetHash(MetaKey)
+	if err != nil {
+		return fmt.Errorf("failed to read availability start: %w", err)
+	}
+	since, err := time.Parse(time.RFC3339Nano, meta[sinceField])
+	if err != nil {
+		since = now
+		update := cache.HashUpdate{Key: MetaKey, Set: map[string]string{sinceField: now.Format(time.RFC3339Nano)}}
+		if err := t.store.UpdateHashes([]cache.HashUpdate{update}, 0); err != nil {
+			return fmt.Errorf("failed to store availability start: %w", err)
+		}
+	}
+	t.mu.Lock()
+	t.since = since
+	t.mu.Unlock()
+	return t.record(EventStart, now)
+}
+
+// Run проверяет готовность ready с периодом Interval до отмены контекста
+func (t *Tracker) Run(ctx context.Context, ready func() bool) {
+	if err := t.Start(); err != nil {
+		log.Printf("Availability: %v", err)
+	}
+	check := func() {
+		if err := t.Check(ready()); err != nil {
+			log.Printf("Availability: %v", err)
+		}
+	}
+	check()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// Check записывает переход готовности и, если реплика готова, отмечает
+// текущую минуту доступной
+func (t *Tracker) Check(ready bool) error {
+	now := t.clock.Now().UTC()
+	t.mu.Lock()
+	changed := ready != t.ready
+	t.ready = ready
+	t.mu.Unlock()
+
+	if changed {
+		event := EventUnready
+		if ready {
+			event = EventReady
+		}
+		if err := t.record(event, now); err != nil {
+			return err
+		}
+	}
+	if !ready {
+		return nil
+	}
+	day := now.Truncate(24 * time.Hour)
+	if err := t.store.MarkSlot(dayKey(day), int64(now.Sub(day)/time.Minute), Retention); err != nil {
+		return fmt.Errorf("failed to mark minute available: %w", err)
+	}
+	t.mu.Lock()
+	t.marked = now.Unix() / 60
+	t.mu.Unlock()
+	return nil
+}
+
+// Stop записывает штатную остановку реплики
+func (t *Tracker) Stop() error {
+	return t.record(EventStop, t.clock.Now().UTC())
+}
+
+// Report возвращает доступность за текущие сутки и месяц (UTC). Текущая
+// минута учитывается, только когда реплика уже отметила ее
+func (t *Tracker) Report() (models.Availability, error) {
+	now := t.clock.Now().UTC()
+	t.mu.Lock()
+	since, marked := t.since, t.marked
+	t.mu.Unlock()
+
+	today := now.Truncate(24 * time.Hour)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var keys []string
+	for day := month; !day.After(today); day = day.AddDate(0, 0, 1) {
+		keys = append(keys, dayKey(day))
+	}
+	counts, err := t.store.CountSlots(keys)
+	if err != nil {
+		return models.Availability{}, err
+	}
+	var monthly int64
+	for _, n := range counts {
+		monthly += n
+	}
+
+	current := now.Unix()/60 == marked
+	return models.Availability{
+		Since:   since,
+		Daily:   period(maxTime(today, since), now, counts[len(counts)-1], current),
+		Monthly: period(maxTime(month, since), now, monthly, current),
+	}, nil
+}
+
+// period доступность с from до now по количеству доступных минут up
+func period(from, now time.Time, up int64, current bool) models.AvailabilityPeriod {
+	from = from.Truncate(time.Minute)
+	elapsed := int64(now.Sub(from) / time.Minute)
+	if current {
+		elapsed++
+	}
+	up = min(up, elapsed)
+	p := models.AvailabilityPeriod{From: from, Percent: 100}
+	if elapsed > 0 {
+		p.Percent = math.Round(float64(up)/float64(elapsed)*1e5) / 1e3
+	}
+	p.Downtime = (time.Duration(elapsed-up) * time.Minute).String()
+	return p
+}
+
+// record пишет событие реплики в поток
+func (t *Tracker) record(event string, at time.Time) error {
+	data, err := json.Marshal(Transition{Node: t.node, Event: event, At: at})
+	if err != nil {
+		return err
+	}
+	if _, err := t.store.AppendStream(TransitionsStream, data, maxTransitions); err != nil {
+		return fmt.Errorf("failed to record %s: %w", event, err)
+	}
+	log.Printf("Availability: node %s %s", t.node, event)
+	return nil
+}
+
+// dayKey ключ битовой карты суток day
+func dayKey(day time.Time) string {
+	return KeyPrefix + day.Format("2006-01-02")
+}
+
+// maxTime более позднее из двух времен
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package availability
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func TestTracker_CountsMinutesWithoutAnyReadyReplica(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	a := New(store, "a", WithClock(clk))
+	b := New(store, "b", WithClock(clk))
+	a.Start()
+	b.Start()
+
+	// Two ready replicas mark the same minutes once; replica a crashes after
+	// 30 minutes without a stop event, b is down from minute 20 to 40
+	for m := 0; m < 60; m++ {
+		if m < 30 {
+			a.Check(true)
+		}
+		b.Check(m < 20 || m >= 40)
+		clk.Advance(time.Minute)
+	}
+
+	report, err := b.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Since.Equal(time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected accounting to start at the first start, got %s", report.Since)
+	}
+	// Minutes 30-39 had no ready replica; the current minute is not marked yet
+	if report.Daily.Percent != 83.333 || report.Daily.Downtime != "10m0s" {
+		t.Errorf("Unexpected daily availability %+v", report.Daily)
+	}
+	if report.Monthly != report.Daily {
+		t.Errorf("Expected the month to start with accounting, got %+v", report.Monthly)
+	}
+
+	b.Check(true)
+	if report, _ := b.Report(); report.Daily.Downtime != "10m0s" || report.Daily.Percent != 83.607 {
+		t.Errorf("Expected the marked current minute counted, got %+v", report.Daily)
+	}
+}
+
+func TestTracker_KeepsStartAcrossRestarts(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	first := New(store, "a", WithClock(clk))
+	first.Start()
+	first.Check(true)
+	clk.Advance(time.Minute)
+	first.Stop()
+
+	// The process is down for the rest of the day and restarts the next morning
+	clk.Set(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))
+	restarted := New(store, "a", WithClock(clk))
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Check(true)
+
+	report, err := restarted.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Daily.Percent != 100 {
+		t.Errorf("Expected today fully available, got %+v", report.Daily)
+	}
+	if report.Monthly.Downtime != "23h59m0s" || !report.Monthly.From.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected yesterday's outage in the monthly report, got %+v", report.Monthly)
+	}
+
+	entries, _ := store.RangeStream(TransitionsStream, "-", 10)
+	var events []string
+	for _, e := range entries {
+		var tr Transition
+		json.Unmarshal(e.Data, &tr)
+		events = append(events, tr.Event)
+	}
+	want := []string{EventStart, EventReady, EventStop, EventStart, EventReady}
+	if len(events) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Expected transitions %v, got %v", want, events)
+			break
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package backpressure приостановка приема из потоковых источников при
+// переполнении внутренних буферов.
+//
+// Gate следит за заполнением буферов (очередь анализатора и т.п.). Когда любой
+// из них достигает верхней границы, прием приостанавливается и возобновляется,
+// только когда все буферы опустятся до нижней границы. Потребители потоков
+// вызывают Wait перед чтением очередного сообщения: пока прием приостановлен,
+// сообщения остаются у источника (в очереди брокера, в окне управления потоком
+// HTTP/2), а не копятся в памяти сервиса и не отбрасываются. HTTP-прием
+// вызывает Check и отвечает 429, пока прием приостановлен
+package backpressure
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"highload-service/internal/metrics"
+)
+
+const (
+	// DefaultHighWater заполнение, при котором прием приостанавливается
+	DefaultHighWater = 0.8
+	// DefaultLowWater заполнение, при котором прием возобновляется
+	DefaultLowWater = 0.5
+	// DefaultPollInterval как часто ожидающие потребители проверяют заполнение
+	DefaultPollInterval = 20 * time.Millisecond
+)
+
+// Source наблюдаемый буфер
+type Source struct {
+	Name string
+	// Level возвращает текущее количество элементов и емкость буфера
+	Level func() (used, capacity int)
+}
+
+// Config границы заполнения, доли емкости 0–1
+type Config struct {
+	HighWater float64
+	LowWater  float64
+}
+
+// Validate проверяет, что 0 < LowWater < HighWater <= 1
+func (c Config) Validate() error {
+	if c.LowWater <= 0 || c.LowWater >= c.HighWater || c.HighWater > 1 {
+		return fmt.Errorf("backpressure water marks must satisfy 0 < low < high <= 1, got low %v, high %v", c.LowWater, c.HighWater)
+	}
+	return nil
+}
+
+// Option настраивает Gate
+type Option func(*Gate)
+
+// WithPollInterval задает интервал проверки заполнения для ожидающих потребителей
+func WithPollInterval(d time.Duration) Option {
+	return func(g *Gate) {
+		if d > 0 {
+			g.poll = d
+		}
+	}
+}
+
+// Gate решает, можно ли читать следующее сообщение. Безопасен для конкурентного использования
+type Gate struct {
+	cfg     Config
+	sources []Source
+	poll    time.Duration
+
+	mu     sync.Mutex
+	paused bool
+	// resumed закрывается при возобновлении приема и пересоздается при приостановке
+	resumed chan struct{}
+}
+
+// New создает Gate над буферами sources
+func New(cfg Config, sources []Source, opts ...Option) *Gate {
+	g := &Gate{
+		cfg:     cfg,
+		sources: sources,
+		poll:    DefaultPollInterval,
+		resumed: make(chan struct{}),
+	}
+	close(g.resumed)
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Check измеряет заполнение буферов, обновляет состояние и возвращает true,
+// если прием приостановлен
+func (g *Gate) Check() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.paused {
+		for _, s := range g.sources {
+			if fill(s) >= g.cfg.HighWater {
+				g.paused = true
+				g.resumed = make(chan struct{})
+				metrics.IngestPaused.Set(1)
+				metrics.IngestPauses.WithLabelValues(s.Name).Inc()
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, s := range g.sources {
+		if fill(s) > g.cfg.LowWater {
+			return true
+		}
+	}
+	g.paused = false
+	close(g.resumed)
+	metrics.IngestPaused.Set(0)
+	return false
+}
+
+// Paused сообщает, приостановлен ли прием, без повторного измерения
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Wait возвращается сразу, если прием не приостановлен, иначе ждет
+// возобновления или отмены ctx
+func (g *Gate) Wait(ctx context.Context) error {
+	if !g.Check() {
+		return nil
+	}
+
+	ticker := time.NewTicker(g.poll)
+	defer ticker.Stop()
+	for {
+		g.mu.Lock()
+		resumed := g.resumed
+		g.mu.Unlock()
+
+		select {
+		case <-resumed:
+			return nil
+		case <-ticker.C:
+			if !g.Check() {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fill доля заполнения буфера; буфер без емкости считается пустым
+func fill(s Source) float64 {
+	used, capacity := s.Level()
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity)
+}
@@ -0,0 +1,87 @@
+package backpressure
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGate_PausesAtHighWaterAndResumesAtLowWater(t *testing.T) {
+	var queue, buffer atomic.Int64
+	g := New(Config{HighWater: 0.8, LowWater: 0.5}, []Source{
+		{Name: "queue", Level: func() (int, int) { return int(queue.Load()), 100 }},
+		{Name: "buffer", Level: func() (int, int) { return int(buffer.Load()), 10 }},
+	}, WithPollInterval(time.Millisecond))
+
+	if g.Check() {
+		t.Fatal("Expected an empty gate to be open")
+	}
+	queue.Store(80)
+	if !g.Check() || !g.Paused() {
+		t.Fatal("Expected a pause at the high-water mark")
+	}
+	// Between the marks the gate keeps its state
+	queue.Store(60)
+	if !g.Check() {
+		t.Fatal("Expected the pause to hold above the low-water mark")
+	}
+	// Every source must drain before resuming
+	queue.Store(50)
+	buffer.Store(6)
+	if !g.Check() {
+		t.Fatal("Expected the pause to hold while another buffer is above the low-water mark")
+	}
+	buffer.Store(5)
+	if g.Check() {
+		t.Fatal("Expected a resume at the low-water mark")
+	}
+	queue.Store(60)
+	if g.Check() {
+		t.Fatal("Expected the gate to stay open below the high-water mark")
+	}
+}
+
+func TestGate_WaitBlocksUntilResumed(t *testing.T) {
+	var queue atomic.Int64
+	queue.Store(100)
+	g := New(Config{HighWater: 0.8, LowWater: 0.5}, []Source{
+		{Name: "queue", Level: func() (int, int) { return int(queue.Load()), 100 }},
+	}, WithPollInterval(time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() { done <- g.Wait(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Wait to block while paused, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	queue.Store(10)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return after the queue drained")
+	}
+
+	queue.Store(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Wait(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, c := range []Config{{0.5, 0.5}, {0.8, 0}, {1.2, 0.5}} {
+		if c.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+	if err := (Config{HighWater: DefaultHighWater, LowWater: DefaultLowWater}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
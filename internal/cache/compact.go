@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"highload-service/internal/models"
+)
+
+const (
+	// MetricBlobKeyPrefix префикс почасовых блоков метрик: metrics:hour:<unix начала часа>
+	MetricBlobKeyPrefix = "metrics:hour:"
+	// CompactionGrace сколько ждать после окончания часа, прежде чем сжимать его метрики
+	CompactionGrace = 5 * time.Minute
+)
+
+// CompactionStats итог сжатия метрик
+type CompactionStats struct {
+	// Hours количество записанных почасовых блоков
+	Hours int
+	// Metrics количество метрик, перенесенных в блоки
+	Metrics int
+	// Keys количество удаленных ключей metric:<ts>
+	Keys int
+	// Unreadable ключи, которые не удалось расшифровать или разобрать (в связке
+	// нет ключа шифрования, значение повреждено). Они не удаляются и остаются
+	// до истечения по MetricsTTL
+	Unreadable int
+}
+
+// CompactMetrics переносит метрики часов, закончившихся до before, из отдельных
+// ключей metric:<ts> в сжатые почасовые блоки. Метрики, пришедшие с опозданием,
+// дописываются в существующий блок. Блок живет, пока не истекла бы последняя
+// из его метрик, и читается GetMetricsRange наравне с отдельными ключами.
+// Запускать следует на одном экземпляре (задача планировщика с выбором лидера)
+func (r *RedisCache) CompactMetrics(before time.Time) (CompactionStats, error) {
+	var stats CompactionStats
+	members, err := r.client.ZRangeByScoreWithScores(r.ctx, MetricsTimelineKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.Truncate(time.Hour).UnixNano(), 10),
+	}).Result()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get metrics timeline: %w", err)
+	}
+
+	byHour := make(map[int64][]string)
+	for _, z := range members {
+		key, _ := z.Member.(string)
+		hour := time.Unix(0, int64(z.Score)).Truncate(time.Hour).Unix()
+		byHour[hour] = append(byHour[hour], key)
+	}
+	hours := make([]int64, 0, len(byHour))
+	for hour := range byHour {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i] < hours[j] })
+
+	for _, hour := range hours {
+		keys := byHour[hour]
+		n, unreadable, err := r.compactHour(time.Unix(hour, 0), keys)
+		if err != nil {
+			return stats, err
+		}
+		if n > 0 {
+			stats.Hours++
+			stats.Metrics += n
+		}
+		stats.Keys += len(keys) - unreadable
+		stats.Unreadable += unreadable
+	}
+	return stats, nil
+}
+
+// compactHour записывает метрики keys в блок часа hour и удаляет прочитанные
+// ключи. Возвращает количество перенесенных метрик и непрочитанных ключей
+func (r *RedisCache) compactHour(hour time.Time, keys []string) (int, int, error) {
+	metrics, unreadable, err := r.getMetrics(keys)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Непрочитанные ключи остаются на месте: удалить их значило бы потерять метрики
+	if len(unreadable) > 0 {
+		skip := make(map[string]bool, len(unreadable))
+		for _, k := range unreadable {
+			skip[k] = true
+		}
+		readable := make([]string, 0, len(keys)-len(unreadable))
+		for _, k := range keys {
+			if !skip[k] {
+				readable = append(readable, k)
+			}
+		}
+		keys = readable
+	}
+	blobKey := metricBlobKey(hour)
+	// Блок истекает вместе с последней метрикой часа
+	ttl := time.Until(hour.Add(time.Hour + MetricsTTL))
+
+	members := make([]interface{}, len(keys))
+	for i, k := range keys {
+		members[i] = k
+	}
+	if len(keys) == 0 {
+		return 0, len(unreadable), nil
+	}
+	pipe := r.client.TxPipeline()
+	if ttl > 0 && len(metrics) > 0 {
+		existing, err := r.getBlobs([]string{blobKey})
+		if err != nil {
+			return 0, 0, err
+		}
+		metrics = append(existing, metrics...)
+		sortMetrics(metrics)
+		data, err := encodeMetricBlob(metrics)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Шифруется уже сжатый блок: шифротекст не сжимается
+		if data, err = r.seal(data); err != nil {
+			return 0, 0, err
+		}
+		pipe.Set(r.ctx, blobKey, data, ttl)
+	} else {
+		// Метрики уже истекли или истекают: блок не нужен, достаточно убрать ключи
+		metrics = nil
+	}
+	pipe.Del(r.ctx, keys...)
+	pipe.ZRem(r.ctx, MetricsTimelineKey, members...)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to compact metrics of %s: %w", hour.UTC().Format(time.RFC3339), err)
+	}
+	return len(metrics), len(unreadable), nil
+}
+
+// getMetrics читает метрики по ключам; истекшие ключи пропускаются. Ключи,
+// значения которых не удалось расшифровать или разобрать, возвращаются в unreadable
+func (r *RedisCache) getMetrics(keys []string) (metrics []models.Metric, unreadable []string, err error) {
+	metrics = make([]models.Metric, 0, len(keys))
+	const chunk = 500
+	for start := 0; start < len(keys); start += chunk {
+		end := start + chunk
+		if end > len(keys) {
+			end = len(keys)
+		}
+		values, err := r.client.MGet(r.ctx, keys[start:end]...).Result()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get metrics: %w", err)
+		}
+		for i, v := range values {
+			// Ключ истек раньше, чем был вычищен из индекса
+			data, ok := v.(string)
+			if !ok {
+				continue
+			}
+			var m models.Metric
+			plain, err := r.open([]byte(data))
+			if err == nil {
+				err = json.Unmarshal(plain, &m)
+			}
+			if err != nil {
+				unreadable = append(unreadable, keys[start+i])
+				continue
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, unreadable, nil
+}
+
+// getBlobs читает и распаковывает почасовые блоки; отсутствующие пропускаются
+func (r *RedisCache) getBlobs(keys []string) ([]models.Metric, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metric blobs: %w", err)
+	}
+	var metrics []models.Metric
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		plain, err := r.open([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("metric blob %s: %w", keys[i], err)
+		}
+		blob, err := decodeMetricBlob(plain)
+		if err != nil {
+			return nil, fmt.Errorf("metric blob %s: %w", keys[i], err)
+		}
+		metrics = append(metrics, blob...)
+	}
+	return metrics, nil
+}
+
+// blobsInRange возвращает метрики почасовых блоков с временем в [from, to]
+func (r *RedisCache) blobsInRange(from, to time.Time) ([]models.Metric, error) {
+	// Блоки старше MetricsTTL и часа уже истекли
+	if oldest := time.Now().Add(-MetricsTTL - time.Hour); from.Before(oldest) {
+		from = oldest
+	}
+	// Блоков позже текущего часа нет: без ограничения далекий to дал бы
+	// миллионы ключей в одном MGET
+	last := to
+	if current := time.Now().Truncate(time.Hour); last.After(current) {
+		last = current
+	}
+	if from.Truncate(time.Hour).After(last) {
+		return nil, nil
+	}
+	var keys []string
+	for hour := from.Truncate(time.Hour); !hour.After(last); hour = hour.Add(time.Hour) {
+		keys = append(keys, metricBlobKey(hour))
+	}
+	blobs, err := r.getBlobs(keys)
+	if err != nil {
+		return nil, err
+	}
+	metrics := blobs[:0]
+	for _, m := range blobs {
+		if !m.Timestamp.Before(from) && !m.Timestamp.After(to) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
+
+func metricBlobKey(hour time.Time) string {
+	return MetricBlobKeyPrefix + strconv.FormatInt(hour.Truncate(time.Hour).Unix(), 10)
+}
+
+// encodeMetricBlob сериализует метрики в JSON-массив, сжатый gzip
+func encodeMetricBlob(metrics []models.Metric) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(metrics); err != nil {
+		return nil, fmt.Errorf("failed to encode metric blob: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress metric blob: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMetricBlob(data []byte) ([]models.Metric, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []models.Metric
+	if err := json.Unmarshal(raw, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func sortMetrics(metrics []models.Metric) {
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Timestamp.Before(metrics[j].Timestamp) })
+}
+
+// IndexMetricKeys добавляет в индекс по времени ключи metric:<ts>, которых в нем
+// нет (сохраненные до появления индекса), и возвращает количество просмотренных
+// ключей. Повторный вызов безопасен
+func (r *RedisCache) IndexMetricKeys() (int, error) {
+	n := 0
+	iter := r.client.Scan(r.ctx, 0, MetricKeyPrefix+"*", 1000).Iterator()
+	pipe := r.client.Pipeline()
+	for iter.Next(r.ctx) {
+		key := iter.Val()
+		ts, err := strconv.ParseInt(strings.TrimPrefix(key, MetricKeyPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		pipe.ZAdd(r.ctx, MetricsTimelineKey, &redis.Z{Score: float64(ts), Member: key})
+		n++
+		if n%1000 == 0 {
+			if _, err := pipe.Exec(r.ctx); err != nil {
+				return n, fmt.Errorf("failed to index metric keys: %w", err)
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return n, fmt.Errorf("failed to scan metric keys: %w", err)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return n, fmt.Errorf("failed to index metric keys: %w", err)
+	}
+	return n, nil
+}
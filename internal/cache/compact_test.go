@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"highload-service/internal/encryption"
+	"highload-service/internal/models"
+)
+
+func TestMetricBlob_RoundTrip(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metrics := make([]models.Metric, 3600)
+	for i := range metrics {
+		metrics[i] = models.Metric{Timestamp: base.Add(time.Duration(i) * time.Second), CPU: 40, RPS: 500, DeviceID: "sensor-1"}
+	}
+
+	data, err := encodeMetricBlob(metrics)
+	if err != nil {
+		t.Fatalf("encodeMetricBlob failed: %v", err)
+	}
+	got, err := decodeMetricBlob(data)
+	if err != nil {
+		t.Fatalf("decodeMetricBlob failed: %v", err)
+	}
+	if len(got) != len(metrics) || !got[3599].Timestamp.Equal(metrics[3599].Timestamp) || got[0].DeviceID != "sensor-1" {
+		t.Errorf("Blob did not round-trip, got %d metrics", len(got))
+	}
+	// An hour of similar metrics compresses to a fraction of its ~90 bytes of JSON per metric
+	if len(data) > len(metrics)*10 {
+		t.Errorf("Expected compressed blob, got %d bytes for %d metrics", len(data), len(metrics))
+	}
+
+	if _, err := decodeMetricBlob([]byte("not gzip")); err == nil {
+		t.Error("Expected error for corrupted blob")
+	}
+}
+
+func TestMetricBlobKey_TruncatesToHour(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 34, 56, 0, time.UTC)
+	if got, want := metricBlobKey(at), "metrics:hour:1704110400"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func testKeyring(t *testing.T, keys ...string) *encryption.Keyring {
+	t.Helper()
+	var raw []string
+	for i, name := range keys {
+		raw = append(raw, name+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)))
+	}
+	k, err := encryption.ParseKeys(strings.Join(raw, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestCompactMetrics_KeepsUndecryptableKeys(t *testing.T) {
+	writer, redis := newTestRedisCache(t, WithEncryption(testKeyring(t, "old")))
+	// Compaction up to the next hour covers the metrics whatever the current minute
+	base := time.Now().Add(-10 * time.Minute)
+	before := time.Now().Add(time.Hour)
+	metrics := []models.Metric{{Timestamp: base, CPU: 1, RPS: 2}, {Timestamp: base.Add(time.Second), CPU: 3, RPS: 4}}
+	if err := writer.CacheMetricsBatch(metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replica whose keyring lost the writer's key after rotation
+	rotated := reconnect(t, writer, WithEncryption(testKeyring(t, "new")))
+	stats, err := rotated.CompactMetrics(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unreadable != 2 || stats.Keys != 0 || stats.Hours != 0 {
+		t.Errorf("Expected both keys reported unreadable, got %+v", stats)
+	}
+	if got, _ := writer.GetMetricsRange(base, base.Add(time.Minute)); len(got) != 2 || redis.zcard(MetricsTimelineKey) != 2 {
+		t.Fatalf("Expected the metrics to survive compaction, got %+v", got)
+	}
+
+	// A replica that can read them compacts them as usual
+	stats, err = writer.CompactMetrics(before)
+	if err != nil || stats.Keys != 2 || stats.Metrics != 2 || stats.Unreadable != 0 {
+		t.Errorf("Expected the keys compacted by a replica with the key, got %+v (err %v)", stats, err)
+	}
+	if got, _ := writer.GetMetricsRange(base, base.Add(time.Minute)); len(got) != 2 {
+		t.Errorf("Expected the metrics read from the blob, got %+v", got)
+	}
+}
+
+func TestGetMetricsRange_BoundsBlobKeys(t *testing.T) {
+	c, redis := newTestRedisCache(t)
+	now := time.Now()
+	if err := c.CacheMetric(models.Metric{Timestamp: now, CPU: 1, RPS: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A far-future end reads only the blobs that can exist: MetricsTTL back to the current hour
+	got, err := c.GetMetricsRange(now.Add(-time.Minute), time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected the stored metric, got %+v (err %v)", got, err)
+	}
+	if n := redis.mgets(); n > int(MetricsTTL/time.Hour)+2 {
+		t.Errorf("Expected a handful of blob keys, got an MGET of %d keys", n)
+	}
+	// A range entirely in the future reads no blobs at all
+	if got, err := c.GetMetricsRange(now.Add(24*time.Hour), now.Add(48*time.Hour)); err != nil || len(got) != 0 {
+		t.Errorf("Expected no metrics in the future, got %+v (err %v)", got, err)
+	}
+}
+
+func TestGetMetricsRange_DropsMetricsCompactedMidRead(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+	base := time.Now().Add(-10 * time.Minute)
+	metrics := []models.Metric{{Timestamp: base, CPU: 1, RPS: 2}, {Timestamp: base.Add(time.Second), CPU: 3, RPS: 4}}
+	if err := c.CacheMetricsBatch(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CompactMetrics(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// The keys read before the compaction removed them, the blob after it wrote them
+	if err := c.CacheMetricsBatch(metrics); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetMetricsRange(base, base.Add(time.Minute))
+	if err != nil || len(got) != 2 || !got[0].Timestamp.Equal(base) || got[1].CPU != 3 {
+		t.Errorf("Expected each metric once, got %+v (err %v)", got, err)
+	}
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// HashUpdate изменение хеша key: приращения целых полей, новые значения полей
+// и удаляемые поля
+type HashUpdate struct {
+	Key  string
+	Incr map[string]int64
+	Set  map[string]string
+	Del  []string
+}
+
+// memoryHash хеш с временем истечения
+type memoryHash struct {
+	fields    map[string]string
+	expiresAt time.Time
+}
+
+// UpdateHashes применяет изменения одним конвейером (HINCRBY, HSET, HDEL) и продлевает
+// срок жизни каждого хеша до ttl; ttl 0 — без срока жизни
+func (r *RedisCache) UpdateHashes(updates []HashUpdate, ttl time.Duration) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, u := range updates {
+		for field, delta := range u.Incr {
+			pipe.HIncrBy(r.ctx, u.Key, field, delta)
+		}
+		if len(u.Set) > 0 {
+			values := make([]interface{}, 0, 2*len(u.Set))
+			for field, v := range u.Set {
+				values = append(values, field, v)
+			}
+			pipe.HSet(r.ctx, u.Key, values...)
+		}
+		if len(u.Del) > 0 {
+			pipe.HDel(r.ctx, u.Key, u.Del...)
+		}
+		if ttl > 0 {
+			pipe.Expire(r.ctx, u.Key, ttl)
+		}
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to update %d hashes: %w", len(updates), err)
+	}
+	return nil
+}
+
+// GetHash возвращает поля хеша key; для отсутствующего хеша — пустой результат
+func (r *RedisCache) GetHash(key string) (map[string]string, error) {
+	fields, err := r.client.HGetAll(r.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hash %s: %w", key, err)
+	}
+	return fields, nil
+}
+
+// UpdateHashes применяет изменения и продлевает срок жизни хешей до ttl
+func (m *MemoryCache) UpdateHashes(updates []HashUpdate, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, u := range updates {
+		h, ok := m.hashes[u.Key]
+		if !ok || (!h.expiresAt.IsZero() && !now.Before(h.expiresAt)) {
+			h = memoryHash{fields: make(map[string]string)}
+		}
+		for field, delta := range u.Incr {
+			v, _ := strconv.ParseInt(h.fields[field], 10, 64)
+			h.fields[field] = strconv.FormatInt(v+delta, 10)
+		}
+		for field, v := range u.Set {
+			h.fields[field] = v
+		}
+		for _, field := range u.Del {
+			delete(h.fields, field)
+		}
+		if ttl > 0 {
+			h.expiresAt = now.Add(ttl)
+		}
+		m.hashes[u.Key] = h
+	}
+	return nil
+}
+
+// GetHash возвращает копию полей хеша key
+func (m *MemoryCache) GetHash(key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hashes[key]
+	if !ok || (!h.expiresAt.IsZero() && !m.clock.Now().Before(h.expiresAt)) {
+		delete(m.hashes, key)
+		return map[string]string{}, nil
+	}
+	fields := make(map[string]string, len(h.fields))
+	for field, v := range h.fields {
+		fields[field] = v
+	}
+	return fields, nil
+}
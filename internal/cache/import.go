@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"highload-service/internal/models"
+)
+
+// importChunk количество метрик в одном конвейере Redis при импорте
+const importChunk = 500
+
+// ImportMetrics сохраняет исторические метрики. В отличие от CacheMetric,
+// метрики не попадают в список последних, а срок жизни отсчитывается от времени
+// метрики: метрики старше MetricsTTL уже вышли за срок хранения и пропускаются.
+// Возвращает количество сохраненных метрик
+func (r *RedisCache) ImportMetrics(metrics []models.Metric) (int, error) {
+	stored := 0
+	for start := 0; start < len(metrics); start += importChunk {
+		end := start + importChunk
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		pipe := r.client.Pipeline()
+		n := 0
+		for _, m := range metrics[start:end] {
+			ttl := time.Until(m.Timestamp.Add(MetricsTTL))
+			if ttl <= 0 {
+				continue
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				return stored, fmt.Errorf("failed to marshal metric: %w", err)
+			}
+			if data, err = r.seal(data); err != nil {
+				return stored, err
+			}
+			key := fmt.Sprintf("%s%d", MetricKeyPrefix, m.Timestamp.UnixNano())
+			pipe.Set(r.ctx, key, data, ttl)
+			pipe.ZAdd(r.ctx, MetricsTimelineKey, &redis.Z{Score: float64(m.Timestamp.UnixNano()), Member: key})
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := pipe.Exec(r.ctx); err != nil {
+			return stored, fmt.Errorf("failed to import metrics: %w", err)
+		}
+		stored += n
+	}
+	return stored, nil
+}
+
+// ImportMetrics сохраняет исторические метрики со сроком жизни от времени метрики
+func (m *MemoryCache) ImportMetrics(metrics []models.Metric) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	stored := 0
+	for _, metric := range metrics {
+		ttl := metric.Timestamp.Add(MetricsTTL).Sub(now)
+		if ttl <= 0 {
+			continue
+		}
+		data, err := json.Marshal(metric)
+		if err != nil {
+			return stored, fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		m.set(fmt.Sprintf("%s%d", MetricKeyPrefix, metric.Timestamp.UnixNano()), data, ttl)
+		stored++
+	}
+	return stored, nil
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// QuotaWindow окно квоты: счетчик Key, который не должен превысить Limit и
+// живет TTL — до конца окна
+type QuotaWindow struct {
+	Key   string
+	Limit int64
+	TTL   time.Duration
+}
+
+// consumeQuotaScript учитывает запрос во всех окнах, только если ни одно из них
+// не исчерпано, и возвращает счетчики окон и 1, если запрос учтен. Отклоненный
+// запрос не расходует квоту других окон
+var consumeQuotaScript = redis.NewScript(`
+local used = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	used[i] = tonumber(redis.call("GET", key) or "0")
+	if used[i] >= tonumber(ARGV[2 * i - 1]) then
+		allowed = 0
+	end
+end
+if allowed == 1 then
+	for i, key in ipairs(KEYS) do
+		used[i] = redis.call("INCR", key)
+		redis.call("PEXPIRE", key, ARGV[2 * i])
+	end
+end
+used[#KEYS + 1] = allowed
+return used
+`)
+
+// ConsumeQuota атомарно учитывает запрос во всех окнах, если ни одно из них не
+// исчерпано. Возвращает счетчики окон после учета (или текущие, если запрос
+// отклонен) и признак учета
+func (r *RedisCache) ConsumeQuota(windows []QuotaWindow) ([]int64, bool, error) {
+	keys := make([]string, len(windows))
+	args := make([]interface{}, 0, 2*len(windows))
+	for i, w := range windows {
+		keys[i] = w.Key
+		args = append(args, w.Limit, w.TTL.Milliseconds())
+	}
+	res, err := consumeQuotaScript.Run(r.ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to consume quota: %w", err)
+	}
+	return res[:len(windows)], res[len(windows)] == 1, nil
+}
+
+// ConsumeQuota атомарно учитывает запрос во всех окнах, если ни одно из них не исчерпано
+func (m *MemoryCache) ConsumeQuota(windows []QuotaWindow) ([]int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used := make([]int64, len(windows))
+	allowed := true
+	for i, w := range windows {
+		m.expireCounter(w.Key)
+		used[i] = m.counters[w.Key]
+		if used[i] >= w.Limit {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return used, false, nil
+	}
+	now := m.clock.Now()
+	for i, w := range windows {
+		m.counters[w.Key]++
+		m.expiries[w.Key] = now.Add(w.TTL)
+		used[i] = m.counters[w.Key]
+	}
+	return used, true, nil
+}
+
+// Поля хеша маркерной корзины
+const (
+	bucketTokens = "tokens"
+	bucketTime   = "ts"
+)
+
+// takeTokensScript пополняет корзину за время с прошлого обращения по часам
+// Redis, общим для всех реплик, и резервирует n маркеров, если их придется
+// ждать не дольше наибольшего ожидания. Маркеров может стать меньше нуля: они
+// обещаны ожидающим запросам. Хеш живет, пока корзина не наполнится снова.
+// Возвращает 1 или 0 и ожидание в миллисекундах строкой: числа Lua с дробной
+// частью Redis отбросил бы
+var takeTokensScript = redis.NewScript(`
+local n, rate, burst, max_wait = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local wait = (n - tokens) / rate * 1000
+local taken = 0
+if wait <= max_wait then
+	tokens = tokens - n
+	taken = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {taken, tostring(wait)}
+`)
+
+// returnTokensScript возвращает маркеры отмененного запроса, если корзина еще хранится
+var returnTokensScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HINCRBYFLOAT", KEYS[1], "tokens", ARGV[1])
+end
+return 0
+`)
+
+// TakeTokens пополняет общую корзину key со скоростью rate маркеров в секунду
+// до burst и резервирует n маркеров, если их придется ждать не дольше maxWait.
+// Возвращает ожидание до появления маркеров и признак резервирования
+func (r *RedisCache) TakeTokens(key string, n int, rate float64, burst int, maxWait time.Duration) (time.Duration, bool, error) {
+	res, err := takeTokensScript.Run(r.ctx, r.client, []string{key},
+		n, formatFloat(rate), burst, maxWait.Milliseconds()).Slice()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to take tokens from %s: %w", key, err)
+	}
+	taken, _ := res[0].(int64)
+	raw, _ := res[1].(string)
+	waitMs, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid wait %q from %s: %w", raw, key, err)
+	}
+	return time.Duration(waitMs * float64(time.Millisecond)), taken == 1, nil
+}
+
+// ReturnTokens возвращает в корзину key маркеры запроса, который не дождался их
+func (r *RedisCache) ReturnTokens(key string, n int) error {
+	if err := returnTokensScript.Run(r.ctx, r.client, []string{key}, n).Err(); err != nil {
+		return fmt.Errorf("failed to return tokens to %s: %w", key, err)
+	}
+	return nil
+}
+
+// TakeTokens пополняет корзину key по часам кэша и резервирует n маркеров,
+// если их придется ждать не дольше maxWait
+func (m *MemoryCache) TakeTokens(key string, n int, rate float64, burst int, maxWait time.Duration) (time.Duration, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	h, ok := m.hashes[key]
+	if !ok || (!h.expiresAt.IsZero() && !now.Before(h.expiresAt)) {
+		h = memoryHash{fields: map[string]string{
+			bucketTokens: strconv.Itoa(burst),
+			bucketTime:   strconv.FormatInt(now.UnixNano(), 10),
+		}}
+	}
+	tokens, _ := strconv.ParseFloat(h.fields[bucketTokens], 64)
+	last, _ := strconv.ParseInt(h.fields[bucketTime], 10, 64)
+	elapsed := max(0, now.Sub(time.Unix(0, last)).Seconds())
+	tokens = math.Min(float64(burst), tokens+elapsed*rate)
+
+	wait := time.Duration((float64(n) - tokens) / rate * float64(time.Second))
+	taken := wait <= maxWait
+	if taken {
+		tokens -= float64(n)
+	}
+	h.fields[bucketTokens] = formatFloat(tokens)
+	h.fields[bucketTime] = strconv.FormatInt(now.UnixNano(), 10)
+	h.expiresAt = now.Add(time.Duration((float64(burst)-tokens)/rate*float64(time.Second)) + time.Second)
+	m.hashes[key] = h
+	return wait, taken, nil
+}
+
+// ReturnTokens возвращает в корзину key маркеры запроса, который не дождался их
+func (m *MemoryCache) ReturnTokens(key string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hashes[key]
+	if !ok || (!h.expiresAt.IsZero() && !m.clock.Now().Before(h.expiresAt)) {
+		return nil
+	}
+	tokens, _ := strconv.ParseFloat(h.fields[bucketTokens], 64)
+	h.fields[bucketTokens] = formatFloat(tokens + float64(n))
+	return nil
+}
@@ -0,0 +1,459 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+// maxLatestMetrics количество последних метрик, хранимых в списке (как LTRIM 0 999 в Redis)
+const maxLatestMetrics = 1000
+
+// memoryEntry значение с временем истечения
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryCache реализует Cache в памяти процесса.
+// Используется в тестах и при работе без Redis
+type MemoryCache struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	entries  map[string]memoryEntry
+	counters map[string]int64
+	nodes    map[string]map[string]int64
+	expiries map[string]time.Time
+	hashes   map[string]memoryHash
+	latest   [][]byte
+	streams  map[string]*memoryStream
+	closed   bool
+}
+
+var _ Cache = (*MemoryCache)(nil)
+
+// NewMemoryCache создает кэш в памяти. TTL отсчитывается по переданным часам
+func NewMemoryCache(c clock.Clock) *MemoryCache {
+	if c == nil {
+		c = clock.Real()
+	}
+	return &MemoryCache{
+		clock:    c,
+		entries:  make(map[string]memoryEntry),
+		counters: make(map[string]int64),
+		nodes:    make(map[string]map[string]int64),
+		expiries: make(map[string]time.Time),
+		hashes:   make(map[string]memoryHash),
+		streams:  make(map[string]*memoryStream),
+	}
+}
+
+// CacheMetric сохраняет метрику
+func (m *MemoryCache) CacheMetric(metric models.Metric) error {
+	return m.CacheMetricsBatch([]models.Metric{metric})
+}
+
+// CacheMetricsBatch сохраняет метрики; первой в списке последних оказывается
+// последняя метрика среза, как при сохранении по одной
+func (m *MemoryCache) CacheMetricsBatch(metrics []models.Metric) error {
+	encoded := make([][]byte, len(metrics))
+	for i, metric := range metrics {
+		data, err := json.Marshal(metric)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		encoded[i] = data
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := make([][]byte, 0, len(metrics)+len(m.latest))
+	for i := len(metrics) - 1; i >= 0; i-- {
+		m.set(fmt.Sprintf("%s%d", MetricKeyPrefix, metrics[i].Timestamp.UnixNano()), encoded[i], MetricsTTL)
+		latest = append(latest, encoded[i])
+	}
+	m.latest = append(latest, m.latest...)
+	if len(m.latest) > maxLatestMetrics {
+		m.latest = m.latest[:maxLatestMetrics]
+	}
+	return nil
+}
+
+// GetLatestMetrics возвращает последние N метрик
+func (m *MemoryCache) GetLatestMetrics(count int64) ([]models.Metric, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if count > int64(len(m.latest)) || count < 0 {
+		count = int64(len(m.latest))
+	}
+
+	metrics := make([]models.Metric, 0, count)
+	for _, d := range m.latest[:count] {
+		var metric models.Metric
+		if err := json.Unmarshal(d, &metric); err != nil {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// GetMetricsRange возвращает неистекшие метрики с временем в [from, to] в порядке времени
+func (m *MemoryCache) GetMetricsRange(from, to time.Time) ([]models.Metric, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	metrics := []models.Metric{}
+	for key, entry := range m.entries {
+		if !strings.HasPrefix(key, MetricKeyPrefix) {
+			continue
+		}
+		if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+			continue
+		}
+		var metric models.Metric
+		if err := json.Unmarshal(entry.data, &metric); err != nil {
+			continue
+		}
+		if metric.Timestamp.Before(from) || metric.Timestamp.After(to) {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Timestamp.Before(metrics[j].Timestamp) })
+	return metrics, nil
+}
+
+// CacheAnalysisResult сохраняет результат анализа под ключом key на срок ttl
+func (m *MemoryCache) CacheAnalysisResult(key string, result models.AnalysisResult, ttl time.Duration) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal analysis result: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, data, ttl)
+	return nil
+}
+
+// IncrementCounter увеличивает счетчик
+func (m *MemoryCache) IncrementCounter(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireCounter(key)
+	m.counters[key]++
+	return m.counters[key], nil
+}
+
+// GetCounter возвращает значение счетчика
+func (m *MemoryCache) GetCounter(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireCounter(key)
+	return m.counters[key], nil
+}
+
+// IncrementNodeCounter увеличивает слагаемое счетчика key, принадлежащее узлу node
+func (m *MemoryCache) IncrementNodeCounter(key, node string, delta int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nodes[key] == nil {
+		m.nodes[key] = make(map[string]int64)
+	}
+	m.nodes[key][node] += delta
+	return nil
+}
+
+// GetNodeCounters возвращает слагаемые счетчика key по узлам
+func (m *MemoryCache) GetNodeCounters(key string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make(map[string]int64, len(m.nodes[key]))
+	for node, v := range m.nodes[key] {
+		values[node] = v
+	}
+	return values, nil
+}
+
+// expireCounter удаляет истекший счетчик; вызывается под блокировкой
+func (m *MemoryCache) expireCounter(key string) {
+	if exp, ok := m.expiries[key]; ok && !m.clock.Now().Before(exp) {
+		delete(m.counters, key)
+		delete(m.expiries, key)
+	}
+}
+
+// AcquireLock захватывает блокировку key для owner на ttl или продлевает ее,
+// если owner уже владеет блокировкой
+func (m *MemoryCache) AcquireLock(key, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lockKey := "lock:" + key
+	entry, ok := m.entries[lockKey]
+	if ok && m.clock.Now().Before(entry.expiresAt) && string(entry.data) != owner {
+		return false, nil
+	}
+	m.entries[lockKey] = memoryEntry{data: []byte(owner), expiresAt: m.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+// ReleaseLock освобождает блокировку key, если ею владеет owner
+func (m *MemoryCache) ReleaseLock(key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lockKey := "lock:" + key
+	if entry, ok := m.entries[lockKey]; ok && string(entry.data) == owner {
+		delete(m.entries, lockKey)
+	}
+	return nil
+}
+
+// memoryStream поток с группами потребителей, повторяющий семантику Redis Streams
+type memoryStream struct {
+	entries []StreamEntry
+	lastMs  int64
+	lastSeq int64
+	groups  map[string]*memoryGroup
+}
+
+// memoryGroup позиция группы и записи в обработке
+type memoryGroup struct {
+	lastID  string
+	pending map[string]memoryPending
+}
+
+type memoryPending struct {
+	consumer    string
+	deliveredAt time.Time
+}
+
+// stream возвращает поток, создавая его при первом обращении; вызывается под блокировкой
+func (m *MemoryCache) stream(name string) *memoryStream {
+	s, ok := m.streams[name]
+	if !ok {
+		s = &memoryStream{groups: make(map[string]*memoryGroup)}
+		m.streams[name] = s
+	}
+	return s
+}
+
+// group возвращает группу, которая при создании начинает с конца потока
+func (s *memoryStream) group(name string) *memoryGroup {
+	g, ok := s.groups[name]
+	if !ok {
+		g = &memoryGroup{lastID: fmt.Sprintf("%d-%d", s.lastMs, s.lastSeq), pending: make(map[string]memoryPending)}
+		s.groups[name] = g
+	}
+	return g
+}
+
+// AppendStream добавляет запись в поток, сохраняя maxLen последних записей
+func (m *MemoryCache) AppendStream(stream string, data []byte, maxLen int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stream(stream)
+	ms := m.clock.Now().UnixMilli()
+	if ms > s.lastMs {
+		s.lastMs, s.lastSeq = ms, 0
+	} else {
+		s.lastSeq++
+	}
+	entry := StreamEntry{ID: fmt.Sprintf("%d-%d", s.lastMs, s.lastSeq), Data: append([]byte(nil), data...)}
+	s.entries = append(s.entries, entry)
+	if maxLen > 0 && int64(len(s.entries)) > maxLen {
+		s.entries = s.entries[int64(len(s.entries))-maxLen:]
+	}
+	return entry.ID, nil
+}
+
+// ReadStreamGroup выдает потребителю consumer до count новых записей группы
+func (m *MemoryCache) ReadStreamGroup(stream, group, consumer string, count int64) ([]StreamEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stream(stream)
+	g := s.group(group)
+	var entries []StreamEntry
+	for _, e := range s.entries {
+		if int64(len(entries)) >= count {
+			break
+		}
+		if !streamIDLess(g.lastID, e.ID) {
+			continue
+		}
+		entries = append(entries, e)
+		g.lastID = e.ID
+		g.pending[e.ID] = memoryPending{consumer: consumer, deliveredAt: m.clock.Now()}
+	}
+	return entries, nil
+}
+
+// ClaimStream передает потребителю consumer записи, находящиеся в обработке дольше minIdle
+func (m *MemoryCache) ClaimStream(stream, group, consumer string, minIdle time.Duration, count int64) ([]StreamEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stream(stream)
+	g := s.group(group)
+	now := m.clock.Now()
+	var entries []StreamEntry
+	for _, e := range s.entries {
+		if int64(len(entries)) >= count {
+			break
+		}
+		p, ok := g.pending[e.ID]
+		if !ok || now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+		entries = append(entries, e)
+		g.pending[e.ID] = memoryPending{consumer: consumer, deliveredAt: now}
+	}
+	return entries, nil
+}
+
+// AckStream подтверждает обработку записей группой
+func (m *MemoryCache) AckStream(stream, group string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g := m.stream(stream).group(group)
+	for _, id := range ids {
+		delete(g.pending, id)
+	}
+	return nil
+}
+
+// RangeStream возвращает до count записей потока, начиная с start включительно ("-" — с начала)
+func (m *MemoryCache) RangeStream(stream, start string, count int64) ([]StreamEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []StreamEntry{}
+	for _, e := range m.stream(stream).entries {
+		if int64(len(entries)) >= count {
+			break
+		}
+		if start != "-" && streamIDLess(e.ID, start) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// DeleteStream удаляет записи из потока
+func (m *MemoryCache) DeleteStream(stream string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.stream(stream)
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	kept := s.entries[:0]
+	for _, e := range s.entries {
+		if !remove[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	s.entries = kept
+	for _, g := range s.groups {
+		for _, id := range ids {
+			delete(g.pending, id)
+		}
+	}
+	return nil
+}
+
+// streamIDLess сравнивает идентификаторы записей "<ms>-<seq>"; "<ms>" означает "<ms>-0"
+func streamIDLess(a, b string) bool {
+	aMs, aSeq := parseStreamID(a)
+	bMs, bSeq := parseStreamID(b)
+	return aMs < bMs || aMs == bMs && aSeq < bSeq
+}
+
+func parseStreamID(id string) (ms, seq int64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseInt(msPart, 10, 64)
+	seq, _ = strconv.ParseInt(seqPart, 10, 64)
+	return ms, seq
+}
+
+// SetWithTTL устанавливает значение с TTL
+func (m *MemoryCache) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, data, ttl)
+	return nil
+}
+
+// Get получает значение по ключу
+func (m *MemoryCache) Get(key string, dest interface{}) error {
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	if ok && !entry.expiresAt.IsZero() && !m.clock.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		ok = false
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(entry.data, dest)
+}
+
+// Ping проверяет доступность кэша
+func (m *MemoryCache) Ping() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("memory cache is closed")
+	}
+	return nil
+}
+
+// Close помечает кэш закрытым
+func (m *MemoryCache) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// set сохраняет значение; вызывается под блокировкой
+func (m *MemoryCache) set(key string, data []byte, ttl time.Duration) {
+	entry := memoryEntry{data: data}
+	if ttl > 0 {
+		entry.expiresAt = m.clock.Now().Add(ttl)
+	}
+	m.entries[key] = entry
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+func TestMemoryCache_TTLExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := NewMemoryCache(clk)
+
+	if err := c.SetWithTTL("key", map[string]int{"v": 1}, time.Minute); err != nil {
+		t.Fatalf("SetWithTTL failed: %v", err)
+	}
+
+	var dest map[string]int
+	if err := c.Get("key", &dest); err != nil || dest["v"] != 1 {
+		t.Fatalf("Expected value before expiry, got %v (err %v)", dest, err)
+	}
+
+	clk.Advance(time.Minute)
+	if err := c.Get("key", &dest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after TTL, got %v", err)
+	}
+}
+
+func TestMemoryCache_LatestMetricsOrderAndLimit(t *testing.T) {
+	c := NewMemoryCache(nil)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxLatestMetrics+10; i++ {
+		if err := c.CacheMetric(models.Metric{Timestamp: base.Add(time.Duration(i) * time.Second), CPU: float64(i)}); err != nil {
+			t.Fatalf("CacheMetric failed: %v", err)
+		}
+	}
+
+	latest, err := c.GetLatestMetrics(3)
+	if err != nil {
+		t.Fatalf("GetLatestMetrics failed: %v", err)
+	}
+	if len(latest) != 3 || latest[0].CPU != float64(maxLatestMetrics+9) {
+		t.Errorf("Expected newest metrics first, got %+v", latest)
+	}
+
+	all, _ := c.GetLatestMetrics(5000)
+	if len(all) != maxLatestMetrics {
+		t.Errorf("Expected %d retained metrics, got %d", maxLatestMetrics, len(all))
+	}
+}
+
+func TestMemoryCache_BatchMatchesOneByOne(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var batch []models.Metric
+	for i := 0; i < 5; i++ {
+		batch = append(batch, models.Metric{Timestamp: base.Add(time.Duration(i) * time.Second), CPU: float64(i)})
+	}
+
+	single, batched := NewMemoryCache(nil), NewMemoryCache(nil)
+	for _, m := range batch {
+		if err := single.CacheMetric(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batched.CacheMetricsBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := single.GetLatestMetrics(10)
+	got, _ := batched.GetLatestMetrics(10)
+	if !reflect.DeepEqual(got, want) || got[0].CPU != 4 {
+		t.Errorf("Expected the same latest list, got %+v, want %+v", got, want)
+	}
+	if r, _ := batched.GetMetricsRange(base, base.Add(time.Minute)); len(r) != len(batch) {
+		t.Errorf("Expected every metric stored, got %d", len(r))
+	}
+}
+
+func TestMemoryCache_MetricsRange(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := NewMemoryCache(clk)
+	base := clk.Now()
+
+	// Cached in reverse order to check that the range is sorted by time
+	for i := 9; i >= 0; i-- {
+		_ = c.CacheMetric(models.Metric{Timestamp: base.Add(time.Duration(i) * time.Minute), CPU: float64(i)})
+	}
+
+	got, err := c.GetMetricsRange(base.Add(2*time.Minute), base.Add(5*time.Minute))
+	if err != nil {
+		t.Fatalf("GetMetricsRange failed: %v", err)
+	}
+	if len(got) != 4 || got[0].CPU != 2 || got[3].CPU != 5 {
+		t.Errorf("Expected metrics 2..5 in time order, got %+v", got)
+	}
+
+	clk.Advance(MetricsTTL)
+	if got, _ := c.GetMetricsRange(base, base.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("Expected expired metrics to be excluded, got %d", len(got))
+	}
+}
+
+func TestMemoryCache_StreamGroups(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := NewMemoryCache(clk)
+
+	_, _ = c.AppendStream("s", []byte("before"), 0)
+	// A new group starts at the end of the stream, like XGROUP CREATE ... $
+	if got, _ := c.ReadStreamGroup("s", "g", "c1", 10); len(got) != 0 {
+		t.Fatalf("Expected new group to skip existing entries, got %d", len(got))
+	}
+
+	id, _ := c.AppendStream("s", []byte("event"), 0)
+	got, _ := c.ReadStreamGroup("s", "g", "c1", 10)
+	if len(got) != 1 || got[0].ID != id || string(got[0].Data) != "event" {
+		t.Fatalf("Expected the new entry, got %+v", got)
+	}
+
+	if got, _ := c.ClaimStream("s", "g", "c2", time.Minute, 10); len(got) != 0 {
+		t.Errorf("Expected no claim before min idle, got %d", len(got))
+	}
+	clk.Advance(time.Minute)
+	if got, _ := c.ClaimStream("s", "g", "c2", time.Minute, 10); len(got) != 1 {
+		t.Errorf("Expected pending entry to be claimed, got %d", len(got))
+	}
+
+	_ = c.AckStream("s", "g", id)
+	clk.Advance(time.Minute)
+	if got, _ := c.ClaimStream("s", "g", "c2", time.Minute, 10); len(got) != 0 {
+		t.Errorf("Expected acknowledged entry not to be claimed, got %d", len(got))
+	}
+
+	if all, _ := c.RangeStream("s", "-", 10); len(all) != 2 {
+		t.Errorf("Expected range over the whole stream, got %d", len(all))
+	}
+}
@@ -4,11 +4,17 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 
+	"highload-service/internal/encryption"
 	"highload-service/internal/models"
 )
 
@@ -17,6 +23,8 @@ const (
 	MetricKeyPrefix = "metric:"
 	// LatestMetricsKey ключ для последних метрик
 	LatestMetricsKey = "metrics:latest"
+	// MetricsTimelineKey индекс ключей метрик по времени (sorted set, score — UnixNano)
+	MetricsTimelineKey = "metrics:timeline"
 	// AnalysisKeyPrefix префикс для результатов анализа
 	AnalysisKeyPrefix = "analysis:"
 	// StatsKey ключ для статистики
@@ -27,14 +35,61 @@ const (
 	MetricsTTL = 1 * time.Hour
 )
 
+// ErrNotFound возвращается, если ключ отсутствует в кэше
+var ErrNotFound = errors.New("cache: key not found")
+
+// StreamEntry запись потока (Redis Stream); ID вида "<unix ms>-<seq>"
+type StreamEntry struct {
+	ID   string
+	Data []byte
+}
+
+// streamField поле записи потока с данными
+const streamField = "data"
+
+// Cache описывает операции кэша, используемые обработчиками и фоновыми задачами
+type Cache interface {
+	CacheMetric(m models.Metric) error
+	CacheMetricsBatch(ms []models.Metric) error
+	GetLatestMetrics(count int64) ([]models.Metric, error)
+	GetMetricsRange(from, to time.Time) ([]models.Metric, error)
+	CacheAnalysisResult(key string, result models.AnalysisResult, ttl time.Duration) error
+	GetAnalysisResults(keys []string) ([]*models.AnalysisResult, error)
+	IncrementCounter(key string) (int64, error)
+	GetCounter(key string) (int64, error)
+	IncrementNodeCounter(key, node string, delta int64) error
+	GetNodeCounters(key string) (map[string]int64, error)
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+	Get(key string, dest interface{}) error
+	Ping() error
+	Close() error
+}
+
+var _ Cache = (*RedisCache)(nil)
+
 // RedisCache реализует кэширование в Redis
 type RedisCache struct {
 	client *redis.Client
 	ctx    context.Context
+	// groups группы потребителей потоков, созданные этим экземпляром
+	groups sync.Map
+	// keys шифруют метрики, результаты анализа и записи потоков; nil — без шифрования
+	keys *encryption.Keyring
+}
+
+// RedisOption настраивает RedisCache
+type RedisOption func(*RedisCache)
+
+// WithEncryption шифрует сохраняемые данные ключами keys. Значения, записанные
+// до включения шифрования, по-прежнему читаются
+func WithEncryption(keys *encryption.Keyring) RedisOption {
+	return func(r *RedisCache) {
+		r.keys = keys
+	}
 }
 
 // NewRedisCache создает новое подключение к Redis
-func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
+func NewRedisCache(addr, password string, db int, opts ...RedisOption) (*RedisCache, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:         addr,
 		Password:     password,
@@ -53,31 +108,73 @@ func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisCache{
+	r := &RedisCache{
 		client: client,
 		ctx:    ctx,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
 }
 
-// CacheMetric сохраняет метрику в Redis
-func (r *RedisCache) CacheMetric(m models.Metric) error {
-	data, err := json.Marshal(m)
+// seal шифрует значение перед записью, если шифрование включено
+func (r *RedisCache) seal(data []byte) ([]byte, error) {
+	sealed, err := r.keys.Seal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metric: %w", err)
+		return nil, fmt.Errorf("failed to encrypt value: %w", err)
 	}
+	return sealed, nil
+}
 
-	key := fmt.Sprintf("%s%d", MetricKeyPrefix, m.Timestamp.UnixNano())
+// open расшифровывает прочитанное значение; незашифрованные возвращаются как есть
+func (r *RedisCache) open(data []byte) ([]byte, error) {
+	plain, err := r.keys.Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plain, nil
+}
 
-	pipe := r.client.Pipeline()
-	pipe.Set(r.ctx, key, data, MetricsTTL)
-	pipe.LPush(r.ctx, LatestMetricsKey, data)
-	pipe.LTrim(r.ctx, LatestMetricsKey, 0, 999) // Храним последние 1000 метрик
+// CacheMetric сохраняет метрику в Redis
+func (r *RedisCache) CacheMetric(m models.Metric) error {
+	return r.CacheMetricsBatch([]models.Metric{m})
+}
 
-	_, err = pipe.Exec(r.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to cache metric: %w", err)
+// CacheMetricsBatch сохраняет метрики одним конвейером: ключ каждой метрики,
+// затем одни LPUSH списка последних и ZADD индекса по времени на весь пакет,
+// поэтому пакет стоит одного обращения к Redis. Метрики попадают в список
+// последних в порядке среза, как при сохранении по одной
+func (r *RedisCache) CacheMetricsBatch(ms []models.Metric) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	latest := make([]interface{}, 0, len(ms))
+	timeline := make([]*redis.Z, 0, len(ms))
+	for _, m := range ms {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		if data, err = r.seal(data); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s%d", MetricKeyPrefix, m.Timestamp.UnixNano())
+		pipe.Set(r.ctx, key, data, MetricsTTL)
+		latest = append(latest, data)
+		timeline = append(timeline, &redis.Z{Score: float64(m.Timestamp.UnixNano()), Member: key})
 	}
+	pipe.LPush(r.ctx, LatestMetricsKey, latest...)
+	pipe.LTrim(r.ctx, LatestMetricsKey, 0, maxLatestMetrics-1)
+	// Индекс по времени живет столько же, сколько сами метрики
+	pipe.ZAdd(r.ctx, MetricsTimelineKey, timeline...)
+	pipe.ZRemRangeByScore(r.ctx, MetricsTimelineKey, "-inf",
+		strconv.FormatInt(time.Now().Add(-MetricsTTL).UnixNano(), 10))
 
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to cache metrics: %w", err)
+	}
 	return nil
 }
 
@@ -91,7 +188,11 @@ func (r *RedisCache) GetLatestMetrics(count int64) ([]models.Metric, error) {
 	metrics := make([]models.Metric, 0, len(data))
 	for _, d := range data {
 		var m models.Metric
-		if err := json.Unmarshal([]byte(d), &m); err != nil {
+		plain, err := r.open([]byte(d))
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(plain, &m); err != nil {
 			continue
 		}
 		metrics = append(metrics, m)
@@ -100,15 +201,66 @@ func (r *RedisCache) GetLatestMetrics(count int64) ([]models.Metric, error) {
 	return metrics, nil
 }
 
-// CacheAnalysisResult сохраняет результат анализа
-func (r *RedisCache) CacheAnalysisResult(result models.AnalysisResult) error {
+// GetMetricsRange возвращает сохраненные метрики с временем в [from, to] в порядке времени,
+// включая сжатые почасовые блоки (см. CompactMetrics).
+// Доступны только метрики, не истекшие по MetricsTTL
+func (r *RedisCache) GetMetricsRange(from, to time.Time) ([]models.Metric, error) {
+	keys, err := r.client.ZRangeByScore(r.ctx, MetricsTimelineKey, &redis.ZRangeBy{
+		Min: timelineScore(from),
+		Max: timelineScore(to),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics timeline: %w", err)
+	}
+
+	metrics, _, err := r.getMetrics(keys)
+	if err != nil {
+		return nil, err
+	}
+	compacted, err := r.blobsInRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(compacted) > 0 {
+		// Ключи и блоки читаются разными запросами: сжатие между ними оставляет
+		// метрику и в ключе, и в блоке. Метрика определяется временем, как ключ metric:<ts>
+		seen := make(map[int64]bool, len(metrics)+len(compacted))
+		for _, m := range metrics {
+			seen[m.Timestamp.UnixNano()] = true
+		}
+		for _, m := range compacted {
+			if ts := m.Timestamp.UnixNano(); !seen[ts] {
+				seen[ts] = true
+				metrics = append(metrics, m)
+			}
+		}
+		sortMetrics(metrics)
+	}
+	return metrics, nil
+}
+
+// timelineScore граница ZRANGEBYSCORE индекса по времени для t. Время вне
+// диапазона UnixNano (годы до 1678 и после 2262) становится бесконечностью
+func timelineScore(t time.Time) string {
+	switch {
+	case t.After(time.Unix(0, math.MaxInt64)):
+		return "+inf"
+	case t.Before(time.Unix(0, math.MinInt64)):
+		return "-inf"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// CacheAnalysisResult сохраняет результат анализа под ключом key (см. ResultKey) на срок ttl
+func (r *RedisCache) CacheAnalysisResult(key string, result models.AnalysisResult, ttl time.Duration) error {
 	data, err := json.Marshal(result)
 	if err != nil {
 		return fmt.Errorf("failed to marshal analysis result: %w", err)
 	}
-
-	key := fmt.Sprintf("%s%d", AnalysisKeyPrefix, result.Timestamp.UnixNano())
-	return r.client.Set(r.ctx, key, data, DefaultTTL).Err()
+	if data, err = r.seal(data); err != nil {
+		return err
+	}
+	return r.client.Set(r.ctx, key, data, ttl).Err()
 }
 
 // IncrementCounter увеличивает счетчик
@@ -125,21 +277,205 @@ func (r *RedisCache) GetCounter(key string) (int64, error) {
 	return val, err
 }
 
+// IncrementNodeCounter увеличивает слагаемое счетчика key, принадлежащее узлу node (HINCRBY)
+func (r *RedisCache) IncrementNodeCounter(key, node string, delta int64) error {
+	return r.client.HIncrBy(r.ctx, key, node, delta).Err()
+}
+
+// GetNodeCounters возвращает слагаемые счетчика key по узлам
+func (r *RedisCache) GetNodeCounters(key string) (map[string]int64, error) {
+	fields, err := r.client.HGetAll(r.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node counters %s: %w", key, err)
+	}
+	values := make(map[string]int64, len(fields))
+	for node, raw := range fields {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter %s[%s]: %w", key, node, err)
+		}
+		values[node] = v
+	}
+	return values, nil
+}
+
+// acquireLockScript захватывает свободную блокировку или продлевает собственную
+var acquireLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// AcquireLock захватывает блокировку key для owner на ttl или продлевает ее,
+// если owner уже владеет блокировкой
+func (r *RedisCache) AcquireLock(key, owner string, ttl time.Duration) (bool, error) {
+	n, err := acquireLockScript.Run(r.ctx, r.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+	return n == 1, nil
+}
+
+// releaseLockScript удаляет блокировку, только если ею владеет owner
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ReleaseLock освобождает блокировку key, если ею владеет owner
+func (r *RedisCache) ReleaseLock(key, owner string) error {
+	if err := releaseLockScript.Run(r.ctx, r.client, []string{key}, owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", key, err)
+	}
+	return nil
+}
+
+// AppendStream добавляет запись в поток, сохраняя примерно maxLen последних записей
+func (r *RedisCache) AppendStream(stream string, data []byte, maxLen int64) (string, error) {
+	data, err := r.seal(data)
+	if err != nil {
+		return "", err
+	}
+	id, err := r.client.XAdd(r.ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: map[string]interface{}{streamField: data},
+	}).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to append to stream %s: %w", stream, err)
+	}
+	return id, nil
+}
+
+// ensureGroup создает группу потребителей, которая начинает с конца потока
+func (r *RedisCache) ensureGroup(stream, group string) error {
+	key := stream + "\x00" + group
+	if _, ok := r.groups.Load(key); ok {
+		return nil
+	}
+	err := r.client.XGroupCreateMkStream(r.ctx, stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create group %s on %s: %w", group, stream, err)
+	}
+	r.groups.Store(key, true)
+	return nil
+}
+
+// ReadStreamGroup выдает потребителю consumer до count новых записей группы (без ожидания).
+// Записи остаются в обработке, пока не подтверждены AckStream
+func (r *RedisCache) ReadStreamGroup(stream, group, consumer string, count int64) ([]StreamEntry, error) {
+	if err := r.ensureGroup(stream, group); err != nil {
+		return nil, err
+	}
+	res, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    -1,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream %s: %w", stream, err)
+	}
+	var entries []StreamEntry
+	for _, s := range res {
+		entries = append(entries, r.streamEntries(s.Messages)...)
+	}
+	return entries, nil
+}
+
+// ClaimStream передает потребителю consumer записи группы, которые находятся
+// в обработке дольше minIdle (потребитель упал или не смог их обработать)
+func (r *RedisCache) ClaimStream(stream, group, consumer string, minIdle time.Duration, count int64) ([]StreamEntry, error) {
+	if err := r.ensureGroup(stream, group); err != nil {
+		return nil, err
+	}
+	messages, _, err := r.client.XAutoClaim(r.ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim stream %s: %w", stream, err)
+	}
+	return r.streamEntries(messages), nil
+}
+
+// AckStream подтверждает обработку записей группой
+func (r *RedisCache) AckStream(stream, group string, ids ...string) error {
+	return r.client.XAck(r.ctx, stream, group, ids...).Err()
+}
+
+// RangeStream возвращает до count записей потока, начиная с start включительно ("-" — с начала)
+func (r *RedisCache) RangeStream(stream, start string, count int64) ([]StreamEntry, error) {
+	messages, err := r.client.XRangeN(r.ctx, stream, start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to range stream %s: %w", stream, err)
+	}
+	return r.streamEntries(messages), nil
+}
+
+// DeleteStream удаляет записи из потока
+func (r *RedisCache) DeleteStream(stream string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.client.XDel(r.ctx, stream, ids...).Err()
+}
+
+// streamEntries извлекает данные записей потока. Запись, которую не удалось
+// расшифровать, отдается как есть: потребитель не разберет ее и обработает
+// как испорченную, а не потеряет молча
+func (r *RedisCache) streamEntries(messages []redis.XMessage) []StreamEntry {
+	entries := make([]StreamEntry, 0, len(messages))
+	for _, m := range messages {
+		raw, _ := m.Values[streamField].(string)
+		data, err := r.open([]byte(raw))
+		if err != nil {
+			data = []byte(raw)
+		}
+		entries = append(entries, StreamEntry{ID: m.ID, Data: data})
+	}
+	return entries
+}
+
 // SetWithTTL устанавливает значение с TTL
 func (r *RedisCache) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
 	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
+	if data, err = r.seal(data); err != nil {
+		return err
+	}
 	return r.client.Set(r.ctx, key, data, ttl).Err()
 }
 
 // Get получает значение по ключу
 func (r *RedisCache) Get(key string, dest interface{}) error {
 	data, err := r.client.Get(r.ctx, key).Bytes()
+	if err == redis.Nil {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
+	if data, err = r.open(data); err != nil {
+		return err
+	}
 	return json.Unmarshal(data, dest)
 }
 
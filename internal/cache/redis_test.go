@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis in-memory Redis speaking RESP2, enough for the metric paths of
+// RedisCache: strings, the latest list, sorted sets and MULTI/EXEC. TTLs are ignored
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	lists   map[string][]string
+	zsets   map[string]map[string]float64
+	// maxMGet the largest number of keys requested by one MGET
+	maxMGet int
+}
+
+// newTestRedisCache serves a fakeRedis and connects a RedisCache to it
+func newTestRedisCache(t *testing.T, opts ...RedisOption) (*RedisCache, *fakeRedis) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		lists:   make(map[string][]string),
+		zsets:   make(map[string]map[string]float64),
+	}
+	go f.serve(lis)
+	t.Cleanup(func() { lis.Close() })
+
+	r, err := NewRedisCache(lis.Addr().String(), "", 0, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, f
+}
+
+// reconnect returns another RedisCache over the server of r, with opts
+func reconnect(t *testing.T, r *RedisCache, opts ...RedisOption) *RedisCache {
+	t.Helper()
+	other, err := NewRedisCache(r.client.Options().Addr, "", 0, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	return other
+}
+
+func (f *fakeRedis) serve(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	out := bufio.NewWriter(conn)
+	var queued [][]string
+	multi := false
+	for {
+		args, err := readCommand(in)
+		if err != nil {
+			return
+		}
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			multi, queued = true, nil
+			out.WriteString("+OK\r\n")
+		case name == "EXEC":
+			fmt.Fprintf(out, "*%d\r\n", len(queued))
+			for _, cmd := range queued {
+				f.exec(out, cmd)
+			}
+			multi = false
+		case multi:
+			queued = append(queued, args)
+			out.WriteString("+QUEUED\r\n")
+		default:
+			f.exec(out, args)
+		}
+		if in.Buffered() == 0 {
+			if err := out.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readCommand(in *bufio.Reader) ([]string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = in.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) exec(out *bufio.Writer, args []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bulk := func(s string) { fmt.Fprintf(out, "$%d\r\n%s\r\n", len(s), s) }
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		out.WriteString("+PONG\r\n")
+	case "SET":
+		f.strings[args[1]] = args[2]
+		out.WriteString("+OK\r\n")
+	case "MGET":
+		if len(args)-1 > f.maxMGet {
+			f.maxMGet = len(args) - 1
+		}
+		fmt.Fprintf(out, "*%d\r\n", len(args)-1)
+		for _, k := range args[1:] {
+			if v, ok := f.strings[k]; ok {
+				bulk(v)
+			} else {
+				out.WriteString("$-1\r\n")
+			}
+		}
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := f.strings[k]; ok {
+				delete(f.strings, k)
+				n++
+			}
+		}
+		fmt.Fprintf(out, ":%d\r\n", n)
+	case "LPUSH":
+		for _, v := range args[2:] {
+			f.lists[args[1]] = append([]string{v}, f.lists[args[1]]...)
+		}
+		fmt.Fprintf(out, ":%d\r\n", len(f.lists[args[1]]))
+	case "LTRIM":
+		out.WriteString("+OK\r\n")
+	case "ZADD":
+		z := f.zsets[args[1]]
+		if z == nil {
+			z = make(map[string]float64)
+			f.zsets[args[1]] = z
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			z[args[i+1]] = score
+		}
+		fmt.Fprintf(out, ":%d\r\n", len(z))
+	case "ZREM":
+		for _, m := range args[2:] {
+			delete(f.zsets[args[1]], m)
+		}
+		fmt.Fprintf(out, ":%d\r\n", len(args)-2)
+	case "ZREMRANGEBYSCORE":
+		for _, m := range f.zrange(args[1], args[2], args[3]) {
+			delete(f.zsets[args[1]], m)
+		}
+		out.WriteString(":0\r\n")
+	case "ZRANGEBYSCORE":
+		members := f.zrange(args[1], args[2], args[3])
+		withScores := len(args) > 4 && strings.EqualFold(args[4], "WITHSCORES")
+		if withScores {
+			fmt.Fprintf(out, "*%d\r\n", 2*len(members))
+		} else {
+			fmt.Fprintf(out, "*%d\r\n", len(members))
+		}
+		for _, m := range members {
+			bulk(m)
+			if withScores {
+				bulk(strconv.FormatFloat(f.zsets[args[1]][m], 'f', -1, 64))
+			}
+		}
+	default:
+		fmt.Fprintf(out, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// mgets the largest number of keys requested by one MGET so far
+func (f *fakeRedis) mgets() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxMGet
+}
+
+// zcard the size of the sorted set key
+func (f *fakeRedis) zcard(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.zsets[key])
+}
+
+// zrange members of key with scores in [min, max] by score
+func (f *fakeRedis) zrange(key, min, max string) []string {
+	lo, loOpen := parseScore(min)
+	hi, hiOpen := parseScore(max)
+	var members []string
+	for m, s := range f.zsets[key] {
+		if s < lo || s > hi || (loOpen && s == lo) || (hiOpen && s == hi) {
+			continue
+		}
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		z := f.zsets[key]
+		if z[members[i]] != z[members[j]] {
+			return z[members[i]] < z[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+func parseScore(s string) (float64, bool) {
+	open := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch s {
+	case "-inf":
+		return math.Inf(-1), open
+	case "+inf", "inf":
+		return math.Inf(1), open
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v, open
+}
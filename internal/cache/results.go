@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultAnomalyResultTTL срок хранения результата с аномалией
+	DefaultAnomalyResultTTL = 7 * 24 * time.Hour
+	// DefaultPinnedResultTTL срок хранения закрепленного результата
+	DefaultPinnedResultTTL = 30 * 24 * time.Hour
+)
+
+// ResultTTL сроки хранения результатов анализа по уровням
+type ResultTTL struct {
+	// Normal результат без аномалии
+	Normal time.Duration
+	// Anomaly результат с аномалией; с этим же сроком хранится закрепленный
+	// результат после закрытия аномалии
+	Anomaly time.Duration
+	// Pinned результат, на который ссылается незакрытая аномалия. Срок ограничен,
+	// чтобы закрепление не пережило навсегда экземпляр, который должен его снять
+	Pinned time.Duration
+}
+
+// DefaultResultTTL 5m для обычных результатов, неделя для аномальных, 30 дней для закрепленных
+func DefaultResultTTL() ResultTTL {
+	return ResultTTL{Normal: DefaultTTL, Anomaly: DefaultAnomalyResultTTL, Pinned: DefaultPinnedResultTTL}
+}
+
+// Validate проверяет, что сроки положительны и не убывают от уровня к уровню
+func (t ResultTTL) Validate() error {
+	if t.Normal <= 0 {
+		return fmt.Errorf("result ttl must be positive, got %s", t.Normal)
+	}
+	if t.Anomaly < t.Normal {
+		return fmt.Errorf("anomaly result ttl %s is shorter than result ttl %s", t.Anomaly, t.Normal)
+	}
+	if t.Pinned < t.Anomaly {
+		return fmt.Errorf("pinned result ttl %s is shorter than anomaly result ttl %s", t.Pinned, t.Anomaly)
+	}
+	return nil
+}
+
+// ResultKey ключ результата анализа метрики устройства: analysis:<устройство>:<UnixNano>,
+// без устройства — analysis:<UnixNano>
+func ResultKey(deviceID string, ts time.Time) string {
+	nanos := strconv.FormatInt(ts.UnixNano(), 10)
+	if deviceID == "" {
+		return AnalysisKeyPrefix + nanos
+	}
+	return AnalysisKeyPrefix + deviceID + ":" + nanos
+}
+
+// GetAnalysisResults возвращает результаты анализа keys одним запросом (MGET)
+// в порядке ключей; на месте истекших и нечитаемых результатов nil
+func (r *RedisCache) GetAnalysisResults(keys []string) ([]*models.AnalysisResult, error) {
+	results := make([]*models.AnalysisResult, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+	values, err := r.client.MGet(r.ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get analysis results: %w", err)
+	}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		plain, err := r.open([]byte(data))
+		if err != nil {
+			continue
+		}
+		results[i] = decodeResult(plain)
+	}
+	return results, nil
+}
+
+// GetAnalysisResults возвращает результаты анализа keys в порядке ключей;
+// на месте истекших результатов nil
+func (m *MemoryCache) GetAnalysisResults(keys []string) ([]*models.AnalysisResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	results := make([]*models.AnalysisResult, len(keys))
+	for i, key := range keys {
+		entry, ok := m.entries[key]
+		if !ok || (!entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)) {
+			continue
+		}
+		results[i] = decodeResult(entry.data)
+	}
+	return results, nil
+}
+
+// decodeResult разбирает сохраненный результат; nil — данные испорчены
+func decodeResult(data []byte) *models.AnalysisResult {
+	var result models.AnalysisResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil
+	}
+	return &result
+}
+
+// ExpireKeys задает ключам срок жизни ttl одним конвейером; отсутствующие ключи пропускаются
+func (r *RedisCache) ExpireKeys(keys []string, ttl time.Duration) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.PExpire(r.ctx, key, ttl)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to expire %d keys: %w", len(keys), err)
+	}
+	return nil
+}
+
+// ExpireKeys задает ключам срок жизни ttl; отсутствующие ключи пропускаются
+func (m *MemoryCache) ExpireKeys(keys []string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, key := range keys {
+		entry, ok := m.entries[key]
+		if !ok || (!entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)) {
+			continue
+		}
+		entry.expiresAt = now.Add(ttl)
+		m.entries[key] = entry
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RollupBucket агрегат одного интервала, хранимый в хеше Key
+type RollupBucket struct {
+	Key       string
+	Count     int64
+	Anomalies int64
+	Sum       float64
+	Min       float64
+	Max       float64
+	// TTL срок жизни хеша интервала, продлевается при каждом слиянии
+	TTL time.Duration
+}
+
+// Поля хеша интервала
+const (
+	rollupCount     = "count"
+	rollupAnomalies = "anomalies"
+	rollupSum       = "sum"
+	rollupMin       = "min"
+	rollupMax       = "max"
+)
+
+// mergeRollupScript сливает агрегат с хешем интервала: счетчики и сумма
+// складываются, минимум и максимум сравниваются. Скрипт выполняется атомарно,
+// поэтому реплики не теряют изменений друг друга
+var mergeRollupScript = redis.NewScript(`
+redis.call("HINCRBY", KEYS[1], "count", ARGV[1])
+redis.call("HINCRBY", KEYS[1], "anomalies", ARGV[2])
+redis.call("HINCRBYFLOAT", KEYS[1], "sum", ARGV[3])
+local min = redis.call("HGET", KEYS[1], "min")
+if not min or tonumber(ARGV[4]) < tonumber(min) then
+	redis.call("HSET", KEYS[1], "min", ARGV[4])
+end
+local max = redis.call("HGET", KEYS[1], "max")
+if not max or tonumber(ARGV[5]) > tonumber(max) then
+	redis.call("HSET", KEYS[1], "max", ARGV[5])
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[6])
+`)
+
+// MergeRollups сливает агрегаты с хранимыми одним конвейером
+func (r *RedisCache) MergeRollups(buckets []RollupBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, b := range buckets {
+		// EVAL, а не EVALSHA: в конвейере нельзя повторить команду после NOSCRIPT
+		mergeRollupScript.Eval(r.ctx, pipe, []string{b.Key}, b.Count, b.Anomalies,
+			formatFloat(b.Sum), formatFloat(b.Min), formatFloat(b.Max), b.TTL.Milliseconds())
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to merge %d rollups: %w", len(buckets), err)
+	}
+	return nil
+}
+
+// GetRollups возвращает хранимые агрегаты keys одним конвейером;
+// отсутствующие интервалы пропускаются
+func (r *RedisCache) GetRollups(keys []string) ([]RollupBucket, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(r.ctx, key)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to get %d rollups: %w", len(keys), err)
+	}
+	buckets := make([]RollupBucket, 0, len(keys))
+	for i, cmd := range cmds {
+		if b, ok := parseRollup(keys[i], cmd.Val()); ok {
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets, nil
+}
+
+// MergeRollups сливает агрегаты с хранимыми
+func (m *MemoryCache) MergeRollups(buckets []RollupBucket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for _, b := range buckets {
+		h, ok := m.hashes[b.Key]
+		if !ok || (!h.expiresAt.IsZero() && !now.Before(h.expiresAt)) {
+			h = memoryHash{fields: make(map[string]string)}
+		}
+		cur, ok := parseRollup(b.Key, h.fields)
+		if !ok {
+			cur = RollupBucket{Min: b.Min, Max: b.Max}
+		}
+		h.fields[rollupCount] = strconv.FormatInt(cur.Count+b.Count, 10)
+		h.fields[rollupAnomalies] = strconv.FormatInt(cur.Anomalies+b.Anomalies, 10)
+		h.fields[rollupSum] = formatFloat(cur.Sum + b.Sum)
+		h.fields[rollupMin] = formatFloat(min(cur.Min, b.Min))
+		h.fields[rollupMax] = formatFloat(max(cur.Max, b.Max))
+		if b.TTL > 0 {
+			h.expiresAt = now.Add(b.TTL)
+		}
+		m.hashes[b.Key] = h
+	}
+	return nil
+}
+
+// GetRollups возвращает хранимые агрегаты keys; отсутствующие интервалы пропускаются
+func (m *MemoryCache) GetRollups(keys []string) ([]RollupBucket, error) {
+	buckets := make([]RollupBucket, 0, len(keys))
+	for _, key := range keys {
+		fields, err := m.GetHash(key)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := parseRollup(key, fields); ok {
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets, nil
+}
+
+// parseRollup разбирает хеш интервала; false — интервала нет
+func parseRollup(key string, fields map[string]string) (RollupBucket, bool) {
+	b := RollupBucket{Key: key}
+	b.Count, _ = strconv.ParseInt(fields[rollupCount], 10, 64)
+	if b.Count == 0 {
+		return b, false
+	}
+	b.Anomalies, _ = strconv.ParseInt(fields[rollupAnomalies], 10, 64)
+	b.Sum, _ = strconv.ParseFloat(fields[rollupSum], 64)
+	b.Min, _ = strconv.ParseFloat(fields[rollupMin], 64)
+	b.Max, _ = strconv.ParseFloat(fields[rollupMax], 64)
+	return b, true
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MarkSlot отмечает слот slot битовой карты key (SETBIT) и продлевает срок ее
+// жизни до ttl. Повторная отметка слота ничего не меняет, поэтому слот могут
+// отмечать несколько реплик
+func (r *RedisCache) MarkSlot(key string, slot int64, ttl time.Duration) error {
+	pipe := r.client.Pipeline()
+	pipe.SetBit(r.ctx, key, slot, 1)
+	pipe.Expire(r.ctx, key, ttl)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return fmt.Errorf("failed to mark slot %d of %s: %w", slot, key, err)
+	}
+	return nil
+}
+
+// CountSlots возвращает количество отмеченных слотов каждой битовой карты
+// одним конвейером BITCOUNT; отсутствующая карта дает 0
+func (r *RedisCache) CountSlots(keys []string) ([]int64, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.BitCount(r.ctx, key, nil)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("failed to count slots of %d keys: %w", len(keys), err)
+	}
+	counts := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+	return counts, nil
+}
+
+// MarkSlot отмечает слот slot карты key; карта хранится хешем с полем на слот
+func (m *MemoryCache) MarkSlot(key string, slot int64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	h, ok := m.hashes[key]
+	if !ok || (!h.expiresAt.IsZero() && !now.Before(h.expiresAt)) {
+		h = memoryHash{fields: make(map[string]string)}
+	}
+	h.fields[strconv.FormatInt(slot, 10)] = "1"
+	h.expiresAt = now.Add(ttl)
+	m.hashes[key] = h
+	return nil
+}
+
+// CountSlots возвращает количество отмеченных слотов каждой карты
+func (m *MemoryCache) CountSlots(keys []string) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	counts := make([]int64, len(keys))
+	for i, key := range keys {
+		if h, ok := m.hashes[key]; ok && (h.expiresAt.IsZero() || now.Before(h.expiresAt)) {
+			counts[i] = int64(len(h.fields))
+		}
+	}
+	return counts, nil
+}
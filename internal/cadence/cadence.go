@@ -0,0 +1,316 @@
+// Package cadence SLA интервалов отчетности устройств: время между
+// последовательными метриками каждого устройства, перцентили p50/p95/p99 по
+// устройствам и по парку и устройства, нарушающие ожидаемую частоту.
+//
+// Интервалы считаются по времени прихода метрик, а не по их timestamp, поэтому
+// растущие интервалы и молчание устройств — ранний признак проблем сети на
+// площадке, даже если часы устройств сбиты. Устройство нарушает SLA, если его
+// p95 интервала или время молчания больше ожидаемого интервала, умноженного на
+// Tolerance. Ожидаемый интервал берется из реестра устройств (поле interval),
+// иначе общий ExpectedInterval
+package cadence
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultExpectedInterval ожидаемый интервал между метриками устройства
+	DefaultExpectedInterval = 10 * time.Second
+	// DefaultTolerance во сколько раз интервал может превышать ожидаемый
+	DefaultTolerance = 1.5
+	// DefaultMaxDevices лимит отслеживаемых устройств
+	DefaultMaxDevices = 10000
+	// DefaultForgetAfter через сколько молчащее устройство перестает учитываться
+	DefaultForgetAfter = 24 * time.Hour
+	// Unknown устройство метрик без device_id
+	Unknown = "unknown"
+
+	// samples количество последних интервалов устройства, по которым считаются перцентили
+	samples = 64
+	// minSamples меньше интервалов недостаточно, чтобы судить о p95 устройства
+	minSamples = 5
+)
+
+// Нарушения SLA (поле violation)
+const (
+	// ViolationSilent устройство молчит дольше допустимого интервала
+	ViolationSilent = "silent"
+	// ViolationInterval p95 интервала устройства больше допустимого
+	ViolationInterval = "interval"
+)
+
+// ErrUnknownDevice устройство не присылало метрик или перестало учитываться
+var ErrUnknownDevice = errors.New("device has no recent metrics")
+
+// Config настройки SLA интервалов
+type Config struct {
+	Enabled bool
+	// ExpectedInterval ожидаемый интервал для устройств без interval в реестре
+	ExpectedInterval time.Duration
+	// Tolerance допустимое превышение ожидаемого интервала, не меньше 1
+	Tolerance float64
+	// MaxDevices лимит отслеживаемых устройств
+	MaxDevices int
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	if c.ExpectedInterval <= 0 {
+		return fmt.Errorf("CADENCE_EXPECTED_INTERVAL: must be positive, got %s", c.ExpectedInterval)
+	}
+	if !(c.Tolerance >= 1) {
+		return fmt.Errorf("CADENCE_TOLERANCE: must be at least 1, got %g", c.Tolerance)
+	}
+	if c.MaxDevices <= 0 {
+		return fmt.Errorf("CADENCE_MAX_DEVICES: must be positive, got %d", c.MaxDevices)
+	}
+	return nil
+}
+
+// Device интервалы отчетности устройства; длительности в миллисекундах
+type Device struct {
+	DeviceID string `json:"device_id"`
+	// Intervals количество интервалов, по которым посчитаны перцентили
+	Intervals  int     `json:"intervals"`
+	ExpectedMs float64 `json:"expected_ms"`
+	P50Ms      float64 `json:"p50_ms"`
+	P95Ms      float64 `json:"p95_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+	// SilenceMs время с последней метрики
+	SilenceMs float64   `json:"silence_ms"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Violation ViolationSilent или ViolationInterval; пусто — устройство укладывается в SLA
+	Violation string `json:"violation,omitempty"`
+
+	// severity отношение худшего из p95 и молчания к ожидаемому интервалу
+	severity float64
+}
+
+// Fleet перцентили интервалов всех устройств
+type Fleet struct {
+	Devices   int     `json:"devices"`
+	Violators int     `json:"violators"`
+	Intervals int     `json:"intervals"`
+	P50Ms     float64 `json:"p50_ms"`
+	P95Ms     float64 `json:"p95_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	// Untracked метрики устройств сверх лимита, не вошедшие в интервалы
+	Untracked int64 `json:"untracked"`
+}
+
+// Directory ожидаемые интервалы устройств (реализуется devices.Registry); 0 —
+// интервал не задан
+type Directory interface {
+	IntervalOf(id string) time.Duration
+}
+
+// Option настраивает Tracker
+type Option func(*Tracker)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(t *Tracker) {
+		t.clock = c
+	}
+}
+
+// WithDirectory задает ожидаемые интервалы отдельных устройств
+func WithDirectory(d Directory) Option {
+	return func(t *Tracker) {
+		t.directory = d
+	}
+}
+
+type device struct {
+	// intervals кольцо последних интервалов в наносекундах
+	intervals [samples]int64
+	count     int
+	next      int
+	lastSeen  time.Time
+}
+
+// Tracker накапливает интервалы между метриками устройств. Безопасен для
+// конкурентного использования
+type Tracker struct {
+	cfg       Config
+	clock     clock.Clock
+	directory Directory
+
+	mu        sync.Mutex
+	devices   map[string]*device
+	untracked int64
+}
+
+// New создает Tracker
+func New(cfg Config, opts ...Option) *Tracker {
+	t := &Tracker{
+		cfg:     cfg,
+		clock:   clock.Real(),
+		devices: make(map[string]*device),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Observe учитывает приход метрики m
+func (t *Tracker) Observe(m models.Metric) {
+	id := m.DeviceID
+	if id == "" {
+		id = Unknown
+	}
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	d, ok := t.devices[id]
+	if !ok {
+		if len(t.devices) >= t.cfg.MaxDevices {
+			t.untracked++
+			t.mu.Unlock()
+			return
+		}
+		t.devices[id] = &device{lastSeen: now}
+		t.mu.Unlock()
+		return
+	}
+	interval := now.Sub(d.lastSeen)
+	d.lastSeen = now
+	if interval < 0 {
+		interval = 0
+	}
+	d.intervals[d.next] = int64(interval)
+	d.next = (d.next + 1) % samples
+	if d.count < samples {
+		d.count++
+	}
+	t.mu.Unlock()
+
+	metrics.ReportInterval.Observe(interval.Seconds())
+}
+
+// Device возвращает интервалы устройства
+func (t *Tracker) Device(id string) (Device, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	d, ok := t.devices[id]
+	if !ok || now.Sub(d.lastSeen) > DefaultForgetAfter {
+		return Device{}, ErrUnknownDevice
+	}
+	return t.deviceLocked(id, d, now, nil), nil
+}
+
+// Report возвращает перцентили парка и до limit нарушителей SLA от худшего
+// к лучшему (limit <= 0 — все). Устройства, молчащие дольше DefaultForgetAfter,
+// перестают учитываться
+func (t *Tracker) Report(limit int) (Fleet, []Device) {
+	t.mu.Lock()
+	now := t.clock.Now()
+	var all []int64
+	violators := make([]Device, 0)
+	fleet := Fleet{Untracked: t.untracked}
+	for id, d := range t.devices {
+		if now.Sub(d.lastSeen) > DefaultForgetAfter {
+			delete(t.devices, id)
+			continue
+		}
+		fleet.Devices++
+		if dev := t.deviceLocked(id, d, now, &all); dev.Violation != "" {
+			violators = append(violators, dev)
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	fleet.Intervals = len(all)
+	fleet.P50Ms = millis(percentile(all, 0.5))
+	fleet.P95Ms = millis(percentile(all, 0.95))
+	fleet.P99Ms = millis(percentile(all, 0.99))
+	fleet.Violators = len(violators)
+
+	sort.Slice(violators, func(i, j int) bool {
+		if violators[i].severity != violators[j].severity {
+			return violators[i].severity > violators[j].severity
+		}
+		return violators[i].DeviceID < violators[j].DeviceID
+	})
+	if limit > 0 && len(violators) > limit {
+		violators = violators[:limit]
+	}
+	return fleet, violators
+}
+
+// deviceLocked считает перцентили устройства и проверяет SLA; интервалы
+// устройства дописываются в all, если он задан
+func (t *Tracker) deviceLocked(id string, d *device, now time.Time, all *[]int64) Device {
+	sorted := append([]int64(nil), d.intervals[:d.count]...)
+	if all != nil {
+		*all = append(*all, sorted...)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	expected := t.expectedInterval(id)
+	silence := now.Sub(d.lastSeen)
+	dev := Device{
+		DeviceID:   id,
+		Intervals:  d.count,
+		ExpectedMs: millis(int64(expected)),
+		P50Ms:      millis(percentile(sorted, 0.5)),
+		P95Ms:      millis(percentile(sorted, 0.95)),
+		P99Ms:      millis(percentile(sorted, 0.99)),
+		MaxMs:      millis(percentile(sorted, 1)),
+		SilenceMs:  millis(int64(silence)),
+		LastSeen:   d.lastSeen.UTC(),
+	}
+
+	allowed := float64(expected) * t.cfg.Tolerance
+	p95 := float64(percentile(sorted, 0.95))
+	dev.severity = math.Max(p95, float64(silence)) / float64(expected)
+	switch {
+	case float64(silence) > allowed:
+		dev.Violation = ViolationSilent
+	case d.count >= minSamples && p95 > allowed:
+		dev.Violation = ViolationInterval
+	}
+	return dev
+}
+
+// expectedInterval ожидаемый интервал устройства
+func (t *Tracker) expectedInterval(id string) time.Duration {
+	if t.directory != nil {
+		if v := t.directory.IntervalOf(id); v > 0 {
+			return v
+		}
+	}
+	return t.cfg.ExpectedInterval
+}
+
+// percentile перцентиль q отсортированных интервалов по ближайшему рангу
+func percentile(sorted []int64, q float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// millis переводит наносекунды в миллисекунды с точностью до сотых
+func millis(ns int64) float64 {
+	return math.Round(float64(ns)/1e4) / 100
+}
@@ -0,0 +1,129 @@
+package cadence
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+// intervals is a Directory backed by a map
+type intervals map[string]time.Duration
+
+func (m intervals) IntervalOf(id string) time.Duration { return m[id] }
+
+func testConfig() Config {
+	return Config{Enabled: true, ExpectedInterval: 10 * time.Second, Tolerance: 1.5, MaxDevices: 100}
+}
+
+func TestTracker_ReportsPercentilesAndViolators(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tr := New(testConfig(), WithClock(clk), WithDirectory(intervals{"slow": time.Minute}))
+
+	// Every second: steady reports every 10s, jittery every 10s but every
+	// fourth interval stretches to 30s, slow every 60s as registered, and
+	// gone reports every 10s until it falls silent
+	for sec := 0; sec <= 600; sec++ {
+		if sec%10 == 0 {
+			tr.Observe(models.Metric{DeviceID: "steady"})
+		}
+		if sec%60 == 0 || sec%60 == 30 || sec%60 == 40 || sec%60 == 50 {
+			tr.Observe(models.Metric{DeviceID: "jittery"})
+		}
+		if sec%60 == 0 {
+			tr.Observe(models.Metric{DeviceID: "slow"})
+		}
+		if sec%10 == 0 && sec <= 500 {
+			tr.Observe(models.Metric{DeviceID: "gone"})
+		}
+		clk.Advance(time.Second)
+	}
+
+	steady, err := tr.Device("steady")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steady.P50Ms != 10000 || steady.P99Ms != 10000 || steady.Violation != "" || steady.Intervals != 60 {
+		t.Errorf("Expected steady 10s intervals within SLA, got %+v", steady)
+	}
+	if slow, _ := tr.Device("slow"); slow.ExpectedMs != 60000 || slow.Violation != "" {
+		t.Errorf("Expected slow to be held to its registered interval, got %+v", slow)
+	}
+
+	fleet, violators := tr.Report(0)
+	if fleet.Devices != 4 || fleet.Violators != 2 || len(violators) != 2 {
+		t.Fatalf("Expected 2 of 4 devices to violate, got %+v %+v", fleet, violators)
+	}
+	// gone has been silent for over 100s, ten times its interval; jittery's p95 is 30s
+	if violators[0].DeviceID != "gone" || violators[0].Violation != ViolationSilent {
+		t.Errorf("Expected gone to be the worst violator, got %+v", violators[0])
+	}
+	if violators[1].DeviceID != "jittery" || violators[1].Violation != ViolationInterval || violators[1].P95Ms != 30000 {
+		t.Errorf("Expected jittery to violate by interval, got %+v", violators[1])
+	}
+	if fleet.P50Ms != 10000 || fleet.P99Ms != 60000 {
+		t.Errorf("Unexpected fleet percentiles %+v", fleet)
+	}
+
+	if _, violators := tr.Report(1); len(violators) != 1 || violators[0].DeviceID != "gone" {
+		t.Errorf("Expected limit to keep the worst violator, got %+v", violators)
+	}
+}
+
+func TestTracker_NeedsSamplesBeforeJudgingIntervals(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tr := New(testConfig(), WithClock(clk))
+
+	// Two 14s intervals are within tolerance, one 20s is not, but three
+	// intervals are too few to speak for the device's p95
+	for _, gap := range []time.Duration{0, 14 * time.Second, 20 * time.Second, 14 * time.Second} {
+		clk.Advance(gap)
+		tr.Observe(models.Metric{DeviceID: "new"})
+	}
+	if d, _ := tr.Device("new"); d.Violation != "" || d.Intervals != 3 {
+		t.Errorf("Expected no verdict yet, got %+v", d)
+	}
+}
+
+func TestTracker_LimitsAndForgetsDevices(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := testConfig()
+	cfg.MaxDevices = 1
+	tr := New(cfg, WithClock(clk))
+
+	tr.Observe(models.Metric{})
+	tr.Observe(models.Metric{DeviceID: "extra"})
+	if _, err := tr.Device(Unknown); err != nil {
+		t.Errorf("Expected metrics without device_id under %q, got %v", Unknown, err)
+	}
+	if fleet, _ := tr.Report(0); fleet.Devices != 1 || fleet.Untracked != 1 {
+		t.Errorf("Expected the device over the limit to be untracked, got %+v", fleet)
+	}
+
+	clk.Advance(DefaultForgetAfter + time.Second)
+	if _, err := tr.Device(Unknown); !errors.Is(err, ErrUnknownDevice) {
+		t.Errorf("Expected a long-silent device to be forgotten, got %v", err)
+	}
+	if fleet, _ := tr.Report(0); fleet.Devices != 0 {
+		t.Errorf("Expected no devices, got %+v", fleet)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.ExpectedInterval = 0 },
+		func(c *Config) { c.Tolerance = 0.9 },
+		func(c *Config) { c.MaxDevices = 0 },
+	} {
+		c := testConfig()
+		mutate(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", c)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package clock предоставляет абстракцию времени, позволяющую
+// детерминированно управлять временем в тестах
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock источник текущего времени
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+// realClock использует системное время
+type realClock struct{}
+
+// Real возвращает часы на основе системного времени
+func Real() Clock {
+	return realClock{}
+}
+
+// Now возвращает текущее системное время
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Since возвращает время, прошедшее с t
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// Fake часы с ручным управлением временем для тестов
+type Fake struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+// NewFake создает часы, остановленные на моменте now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now возвращает текущее время часов
+func (f *Fake) Now() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.now
+}
+
+// Since возвращает время, прошедшее с t по часам
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Advance сдвигает часы вперед на d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Set устанавливает текущее время часов
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
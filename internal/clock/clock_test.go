@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_AdvanceAndSet(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	if !c.Now().Equal(start) {
+		t.Fatalf("Expected %v, got %v", start, c.Now())
+	}
+
+	c.Advance(90 * time.Second)
+	if got := c.Since(start); got != 90*time.Second {
+		t.Errorf("Expected 90s since start, got %v", got)
+	}
+
+	later := start.Add(24 * time.Hour)
+	c.Set(later)
+	if !c.Now().Equal(later) {
+		t.Errorf("Expected %v after Set, got %v", later, c.Now())
+	}
+}
@@ -0,0 +1,227 @@
+// Package codec реестр форматов сериализации. Прием HTTP, потоки устройств и
+// получатели событий выбирают кодек по Content-Type или имени из реестра,
+// поэтому новый формат подключается одной регистрацией, без правок в пакетах,
+// которые его используют.
+//
+// Зарегистрированы JSON (по умолчанию), line protocol для метрик, protobuf
+// (metrics.proto) и MessagePack. Данные в
+// Redis по-прежнему хранятся в JSON: формат хранения общий для всех версий
+// сервиса и не зависит от формата приема
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"highload-service/internal/models"
+)
+
+// ErrUnsupportedType кодек не умеет кодировать значение такого типа
+var ErrUnsupportedType = errors.New("codec does not support this type")
+
+// Codec формат сериализации
+type Codec interface {
+	// Name короткое имя (json, line); используется и как content-subtype gRPC
+	Name() string
+	// ContentType MIME-тип без параметров
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal не должен удерживать data после возврата: Decode
+	// переиспользует буфер тела для следующих запросов
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StreamDecoder кодек, который умеет декодировать из потока без чтения тела целиком
+type StreamDecoder interface {
+	Decode(r io.Reader, v interface{}) error
+}
+
+// Registry набор кодеков по имени и MIME-типу. Безопасен для конкурентного использования
+type Registry struct {
+	mu     sync.RWMutex
+	byName map[string]Codec
+	byType map[string]Codec
+}
+
+// NewRegistry создает реестр с кодеками codecs
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{byName: make(map[string]Codec), byType: make(map[string]Codec)}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register добавляет кодек; кодек с тем же именем или MIME-типом заменяется
+func (r *Registry) Register(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byName[c.Name()] = c
+	r.byType[c.ContentType()] = c
+}
+
+// Get возвращает кодек по имени
+func (r *Registry) Get(name string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// ForContentType возвращает кодек по заголовку Content-Type; параметры
+// (charset и т.п.) не учитываются. Пустой заголовок означает JSON
+func (r *Registry) ForContentType(header string) (Codec, bool) {
+	if header == "" {
+		return JSON, true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// Заголовок без параметров находится без разбора и аллокаций
+	if c, ok := r.byType[header]; ok {
+		return c, true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := r.byType[mediaType]
+	return c, ok
+}
+
+// Names возвращает имена кодеков по алфавиту
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.byName))
+	for name := range r.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContentTypes возвращает MIME-типы кодеков по алфавиту
+func (r *Registry) ContentTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.byType))
+	for t := range r.byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Negotiate выбирает по заголовку Accept кодек ответа из offers: с наибольшим
+// q среди подходящих диапазонов, при равенстве — раньше в offers. Если Accept
+// пуст или не подходит ни один кодек, возвращается offers[0]: ответ в формате
+// по умолчанию полезнее, чем 406
+func Negotiate(accept string, offers ...Codec) Codec {
+	if accept == "" || len(offers) == 1 {
+		return offers[0]
+	}
+	best, bestQ := offers[0], 0.0
+	for _, c := range offers {
+		if q := acceptQuality(accept, c.ContentType()); q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// acceptQuality q диапазона Accept, которому соответствует contentType; из
+// нескольких подходящих берется самый точный (type/subtype, type/*, */*)
+func acceptQuality(accept, contentType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case mediaType == contentType:
+			s = 2
+		case mediaType == "*/*":
+			s = 0
+		case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(mediaType, "*")):
+			s = 1
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// Default реестр, которым пользуются пакеты сервиса
+var Default = NewRegistry(JSON, LineProtocol, Protobuf, MessagePack)
+
+// Register добавляет кодек в реестр по умолчанию
+func Register(c Codec) {
+	Default.Register(c)
+}
+
+// maxPooledBuffer буферы тел больше этого размера не возвращаются в пул,
+// чтобы один крупный пакет не удерживал память
+const maxPooledBuffer = 1 << 20
+
+// buffers буферы тел для Decode
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// Decode декодирует r кодеком c, не читая тело целиком, если кодек это умеет.
+// Иначе тело читается в буфер из пула, поэтому прием не выделяет память под
+// каждое тело
+func Decode(c Codec, r io.Reader, v interface{}) error {
+	if sd, ok := c.(StreamDecoder); ok {
+		return sd.Decode(r, v)
+	}
+	buf := buffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			buf.Reset()
+			buffers.Put(buf)
+		}
+	}()
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	return c.Unmarshal(buf.Bytes(), v)
+}
+
+// JSON кодек JSON. Модели со сгенерированным кодом (models.AppendJSON,
+// models.DecodeJSON) кодируются и разбираются без reflect, остальные
+// значения — encoding/json
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, ok, err := models.AppendJSON(nil, v); ok {
+		return data, err
+	}
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	if ok, err := models.DecodeJSON(data, v); ok {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
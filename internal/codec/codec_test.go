@@ -0,0 +1,377 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"highload-service/internal/models"
+)
+
+func TestRegistry_ForContentType(t *testing.T) {
+	r := NewRegistry(JSON, LineProtocol)
+	for header, want := range map[string]string{
+		"":                                "json",
+		"application/json":                "json",
+		"application/json; charset=utf-8": "json",
+		"text/plain":                      "line",
+	} {
+		c, ok := r.ForContentType(header)
+		if !ok || c.Name() != want {
+			t.Errorf("%q: expected %s, got %v", header, want, c)
+		}
+	}
+	if _, ok := r.ForContentType("application/xml"); ok {
+		t.Error("Expected no codec for an unregistered type")
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "json" || names[1] != "line" {
+		t.Errorf("Unexpected names %v", names)
+	}
+}
+
+func TestLineProtocol_RoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	in := []models.Metric{
+		{Timestamp: ts, CPU: 45.5, RPS: 500, DeviceID: "sensor 1,a=b", Region: "eu-west", Values: map[string]float64{"temperature": -4.5, "disk io": 12}},
+		{CPU: 1, RPS: 2, Seq: 7},
+	}
+	data, err := LineProtocol.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out models.MetricsBatch
+	if err := Decode(LineProtocol, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Metrics, in) {
+		t.Errorf("Round trip mismatch:\n%s\n%+v", data, out.Metrics)
+	}
+
+	var m models.Metric
+	err = LineProtocol.Unmarshal([]byte("# comment\ncpu_load,host=a rps=3i,cpu=4,extra=1,state=\"ok\" 1704110400000000000\n"), &m)
+	if err != nil || m.CPU != 4 || m.RPS != 3 || !m.Timestamp.Equal(ts) || len(m.Values) != 1 || m.Values["extra"] != 1 {
+		t.Errorf("Unexpected metric %+v (%v)", m, err)
+	}
+
+	for _, bad := range []string{"metrics cpu=1", "metrics cpu=x,rps=1", "metrics cpu=1,rps=1 soon", "metrics"} {
+		if err := LineProtocol.Unmarshal([]byte(bad), &m); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+	if _, err := LineProtocol.Marshal(map[string]int{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestLineProtocol_QuotedAndEscaped(t *testing.T) {
+	ts := time.Unix(0, 1704110400000000000).UTC()
+	for _, tc := range []struct {
+		line string
+		want models.Metric
+	}{
+		{`cpu,device_id=d1 cpu=1,status="ok go",rps=2`, models.Metric{DeviceID: "d1", CPU: 1, RPS: 2}},
+		{`cpu,device_id=d1 cpu=1,status="a, b=c",rps=2 1704110400000000000`, models.Metric{DeviceID: "d1", CPU: 1, RPS: 2, Timestamp: ts}},
+		{`cpu cpu=1,msg="say \"hi\", then go",rps=2`, models.Metric{CPU: 1, RPS: 2}},
+		{`cpu,device_id=a\=b cpu=1,rps=2,disk\=io=3,x"=4`, models.Metric{DeviceID: "a=b", CPU: 1, RPS: 2, Values: map[string]float64{"disk=io": 3, `x"`: 4}}},
+		// Quotes are literal in tags
+		{`cpu,device\ id=x,region="eu\ west" cpu=1,rps=2`, models.Metric{Region: `"eu west"`, CPU: 1, RPS: 2}},
+	} {
+		var m models.Metric
+		if err := LineProtocol.Unmarshal([]byte(tc.line), &m); err != nil {
+			t.Errorf("%s: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, tc.want) {
+			t.Errorf("%s: expected %+v, got %+v", tc.line, tc.want, m)
+		}
+	}
+	if err := LineProtocol.Unmarshal([]byte(`cpu cpu=1,rps=2,status="unterminated 1704110400000000000`), new(models.Metric)); err != nil {
+		t.Errorf("Expected an unterminated string to swallow the rest of the field set, got %v", err)
+	}
+}
+
+func TestProtobuf_RoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 500, time.UTC)
+	in := []models.Metric{
+		{Timestamp: ts, CPU: 45.5, RPS: 500, DeviceID: "sensor-1", Region: "eu-west", Values: map[string]float64{"temperature": -4.5, "disk_io": 12}, Seq: 3},
+		{CPU: 1, RPS: 2},
+	}
+	data, err := Protobuf.Marshal(models.MetricsBatch{Metrics: in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unknown fields from a newer schema are skipped
+	data = protowire.AppendTag(data, 99, protowire.BytesType)
+	data = protowire.AppendString(data, "future")
+	out := models.MetricsBatch{Metrics: make([]models.Metric, 5)}
+	if err := Decode(Protobuf, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Metrics, in) {
+		t.Errorf("Round trip mismatch: %+v", out.Metrics)
+	}
+
+	resp := models.BatchResponse{
+		Results: []models.AnalysisResult{
+			{Timestamp: ts, RollingAvgCPU: 40, ZScoreCPU: 3.5, IsAnomalyCPU: true, AnomalyDetected: true, Episode: models.EpisodeOpened, Correlation: -0.5,
+				Values: map[string]models.ValueResult{"temperature": {RollingAvg: -4, ZScore: 0.1}}},
+			{Seq: 9, Sequencing: "held"},
+		},
+		Processed:      1,
+		Rejected:       1,
+		AnomaliesFound: 1,
+		Stats: models.BatchStats{
+			CPU:            models.FieldStats{Count: 1, Mean: 45.5, Min: 45.5, Max: 45.5},
+			Values:         map[string]models.FieldStats{"temperature": {Count: 1, Mean: -4.5, Min: -4.5, Max: -4.5}},
+			AnomalyIndices: []int{0, 300},
+		},
+	}
+	if data, err = Protobuf.Marshal(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.BatchResponse
+	if err := Protobuf.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, resp) {
+		t.Errorf("Batch response mismatch:\n%+v\n%+v", decoded, resp)
+	}
+	duplicate := models.BatchResponse{Duplicate: true}
+	if data, err = Protobuf.Marshal(&duplicate); err != nil {
+		t.Fatal(err)
+	}
+	if err := Protobuf.Unmarshal(data, &decoded); err != nil || !decoded.Duplicate {
+		t.Errorf("Expected a duplicate response, got %+v (err %v)", decoded, err)
+	}
+	accepted := models.AsyncAccepted{IngestID: "0af3", Queued: 2, Rejected: 1}
+	if data, err = Protobuf.Marshal(accepted); err != nil {
+		t.Fatal(err)
+	}
+	var acceptedOut models.AsyncAccepted
+	if err := Protobuf.Unmarshal(data, &acceptedOut); err != nil || acceptedOut != accepted {
+		t.Errorf("Accepted response mismatch: %+v (err %v)", acceptedOut, err)
+	}
+
+	var m models.Metric
+	for name, bad := range map[string][]byte{
+		"truncated":    data[:len(data)-1],
+		"invalid utf8": protowire.AppendString(protowire.AppendTag(nil, 4, protowire.BytesType), "\xff"),
+	} {
+		if err := Protobuf.Unmarshal(bad, &m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := Protobuf.Marshal(map[string]int{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestMessagePack_RoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 500, time.UTC)
+	in := []models.Metric{
+		{Timestamp: ts, CPU: 45.5, RPS: 500, DeviceID: "sensor-1", Region: "eu-west", Values: map[string]float64{"temperature": -4.5, "disk_io": 12}, Seq: 3},
+		{Timestamp: time.Unix(1<<35, 0).UTC(), CPU: 1, RPS: 2},
+	}
+	data, err := MessagePack.Marshal(models.MetricsBatch{Metrics: in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := models.MetricsBatch{Metrics: make([]models.Metric, 5)}
+	if err := Decode(MessagePack, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Metrics, in) {
+		t.Errorf("Round trip mismatch: %+v", out.Metrics)
+	}
+
+	// A minimal encoder's payload: a bare array, integer and float32 numbers,
+	// an RFC 3339 timestamp, nil and an unknown nested key
+	compact := []byte{0x91, 0x85,
+		0xa3, 'c', 'p', 'u', 0x2d,
+		0xa3, 'r', 'p', 's', 0xca, 0x43, 0xfa, 0x00, 0x00,
+		0xa9, 't', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p', 0xb4}
+	compact = append(compact, "2024-01-01T12:00:00Z"...)
+	compact = append(compact, 0xa6, 'r', 'e', 'g', 'i', 'o', 'n', 0xc0,
+		0xa5, 'e', 'x', 't', 'r', 'a', 0x81, 0xa1, 'a', 0x92, 0xc3, 0xd6, 0xff, 0, 0, 0, 1)
+	var batch []models.Metric
+	if err := MessagePack.Unmarshal(compact, &batch); err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Metric{{Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), CPU: 45, RPS: 500}}
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("Compact payload decoded as %+v", batch)
+	}
+	// A repeated metrics key replaces the earlier metrics instead of indexing past them
+	repeated := []byte{0x82, 0xa7, 'm', 'e', 't', 'r', 'i', 'c', 's', 0x91, 0x81, 0xa3, 'c', 'p', 'u', 0x01,
+		0xa7, 'm', 'e', 't', 'r', 'i', 'c', 's', 0x92, 0x81, 0xa3, 'c', 'p', 'u', 0x02, 0x80}
+	if err := MessagePack.Unmarshal(repeated, &batch); err != nil || len(batch) != 2 || batch[0].CPU != 2 {
+		t.Errorf("Expected the last metrics key to win, got %+v (err %v)", batch, err)
+	}
+
+	resp := models.BatchResponse{
+		Results: []models.AnalysisResult{
+			{Timestamp: ts, RollingAvgCPU: 40, ZScoreCPU: 3.5, IsAnomalyCPU: true, AnomalyDetected: true, Episode: models.EpisodeOpened, Correlation: -0.5,
+				Values: map[string]models.ValueResult{"temperature": {RollingAvg: -4, ZScore: 0.1}}},
+			{Seq: 9, Sequencing: "held", WarmingUp: true, Snoozed: true},
+		},
+		Processed:      1,
+		Rejected:       1,
+		AnomaliesFound: 1,
+		Stats: models.BatchStats{
+			CPU:            models.FieldStats{Count: 1, Mean: 45.5, Min: 45.5, Max: 45.5},
+			Values:         map[string]models.FieldStats{"temperature": {Count: 1, Mean: -4.5, Min: -4.5, Max: -4.5}},
+			AnomalyIndices: []int{0, 300, 70000},
+		},
+	}
+	if data, err = MessagePack.Marshal(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.BatchResponse
+	if err := MessagePack.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, resp) {
+		t.Errorf("Batch response mismatch:\n%+v\n%+v", decoded, resp)
+	}
+	duplicate := models.BatchResponse{Duplicate: true}
+	if data, err = MessagePack.Marshal(&duplicate); err != nil {
+		t.Fatal(err)
+	}
+	if err := MessagePack.Unmarshal(data, &decoded); err != nil || !decoded.Duplicate {
+		t.Errorf("Expected a duplicate response, got %+v (err %v)", decoded, err)
+	}
+	accepted := models.AsyncAccepted{IngestID: "0af3", Queued: 2, Rejected: 1}
+	if data, err = MessagePack.Marshal(accepted); err != nil {
+		t.Fatal(err)
+	}
+	var acceptedOut models.AsyncAccepted
+	if err := MessagePack.Unmarshal(data, &acceptedOut); err != nil || acceptedOut != accepted {
+		t.Errorf("Accepted response mismatch: %+v (err %v)", acceptedOut, err)
+	}
+
+	var m models.Metric
+	for name, bad := range map[string][]byte{
+		"truncated":    data[:len(data)-1],
+		"trailing":     append([]byte{0x80}, 0x01),
+		"invalid utf8": {0x81, 0xa6, 'r', 'e', 'g', 'i', 'o', 'n', 0xa1, 0xff},
+		"huge map":     {0xdf, 0xff, 0xff, 0xff, 0xff},
+		"not a number": {0x81, 0xa3, 'c', 'p', 'u', 0xa1, 'x'},
+		"bad nanos":    {0x81, 0xa9, 't', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p', 0xd7, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0},
+	} {
+		if err := MessagePack.Unmarshal(bad, &m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := MessagePack.Marshal(map[string]int{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                      "json",
+		"*/*":                                   "json",
+		"application/x-protobuf":                "protobuf",
+		"application/json;q=0.5, application/*": "protobuf",
+		"application/x-protobuf;q=0.1, */*":     "json",
+		"application/x-protobuf, application/json": "json",
+		"text/html": "json",
+	} {
+		if c := Negotiate(accept, JSON, Protobuf); c.Name() != want {
+			t.Errorf("%q: expected %s, got %s", accept, want, c.Name())
+		}
+	}
+}
+
+// fuzzTargets are the models a body or a response is decoded into
+var fuzzTargets = []func() interface{}{
+	func() interface{} { return new(models.Metric) },
+	func() interface{} { return new(models.MetricsBatch) },
+	func() interface{} { return new(models.AnalysisResult) },
+	func() interface{} { return new(models.BatchResponse) },
+	func() interface{} { return new(models.AsyncAccepted) },
+}
+
+// fuzzUnmarshal decodes data into every target. Decoding must not panic, and
+// whatever decodes must encode again to bytes that decode to the same encoding
+func fuzzUnmarshal(t *testing.T, c Codec, data []byte) {
+	for _, target := range fuzzTargets {
+		v := target()
+		if err := c.Unmarshal(data, v); err != nil {
+			continue
+		}
+		encoded, err := c.Marshal(v)
+		if errors.Is(err, ErrUnsupportedType) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q decoded into %T but does not encode: %v", data, v, err)
+		}
+		again := target()
+		if err := c.Unmarshal(encoded, again); err != nil {
+			t.Fatalf("%q into %T: re-encoded %q does not decode: %v", data, v, encoded, err)
+		}
+		if reencoded, _ := c.Marshal(again); !bytes.Equal(reencoded, encoded) {
+			t.Fatalf("%q into %T: encoding is not stable:\n%q\n%q", data, v, encoded, reencoded)
+		}
+	}
+}
+
+func FuzzLineProtocol(f *testing.F) {
+	f.Add([]byte("metrics,device_id=sensor-1,region=eu-west cpu=45.5,rps=500 1704110400000000000"))
+	f.Add([]byte("# comment\ncpu_load,host=a rps=3i,cpu=4,extra=1,state=\"ok\"\n\nmetrics cpu=1,rps=2,seq=7i"))
+	f.Add([]byte("metrics,device_id=sensor\\ 1\\,a\\=b cpu=NaN,rps=+Inf,disk\\ io=1e308 -1"))
+	f.Add([]byte("metrics cpu=1,rps=1 soon"))
+	f.Add([]byte("metrics"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzUnmarshal(t, LineProtocol, data)
+	})
+}
+
+// fuzzSeeds encodes sample models with c as fuzz seeds
+func fuzzSeeds(f *testing.F, c Codec) {
+	f.Helper()
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 500, time.UTC)
+	for _, v := range []interface{}{
+		models.MetricsBatch{Metrics: []models.Metric{
+			{Timestamp: ts, CPU: 45.5, RPS: 500, DeviceID: "sensor-1", Region: "eu-west", Values: map[string]float64{"temperature": -4.5}, Seq: 3},
+			{CPU: 1, RPS: 2},
+		}},
+		&models.AnalysisResult{Timestamp: ts, RollingAvgCPU: 40, ZScoreCPU: 3.5, AnomalyDetected: true, Episode: models.EpisodeOpened,
+			Values: map[string]models.ValueResult{"temperature": {RollingAvg: -4, ZScore: 0.1}}},
+		&models.BatchResponse{Results: []models.AnalysisResult{{Seq: 9, Sequencing: "held"}}, Processed: 1, Rejected: 1,
+			Stats: models.BatchStats{CPU: models.FieldStats{Count: 1, Mean: 45.5}, AnomalyIndices: []int{0, 300}}},
+		&models.BatchResponse{Duplicate: true},
+		&models.AsyncAccepted{IngestID: "ingest-1", Queued: 2, Rejected: 1},
+	} {
+		data, err := c.Marshal(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzProtobuf(f *testing.F) {
+	fuzzSeeds(f, Protobuf)
+	f.Add([]byte{0x0a, 0x05, 'a'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzUnmarshal(t, Protobuf, data)
+	})
+}
+
+func FuzzMessagePack(f *testing.F) {
+	fuzzSeeds(f, MessagePack)
+	f.Add([]byte{0x91, 0x81, 0xa3, 'c', 'p', 'u', 0xca, 0x43, 0xfa, 0x00, 0x00})
+	f.Add([]byte{0x82, 0xa7, 'm', 'e', 't', 'r', 'i', 'c', 's', 0x91, 0x80, 0xa7, 'm', 'e', 't', 'r', 'i', 'c', 's', 0x92, 0x80, 0x80})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzUnmarshal(t, MessagePack, data)
+	})
+}
@@ -0,0 +1,240 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// LineProtocol кодек метрик в line protocol InfluxDB, по строке на метрику:
+//
+//	metrics,device_id=sensor-1,region=eu-west cpu=45.5,rps=500 1704110400000000000
+//
+// Имя измерения при чтении не проверяется, теги device_id и region и поля cpu,
+// rps и seq сопоставляются по именам, остальные числовые поля становятся именованными
+// показателями (Metric.Values), прочие теги и поля (в том числе строки в кавычках,
+// которые могут содержать пробелы и запятые) игнорируются. Время — наносекунды Unix;
+// без времени метрика получает время приема. Поддерживаются models.Metric,
+// []models.Metric и models.MetricsBatch
+var LineProtocol Codec = lineCodec{}
+
+// lineMeasurement имя измерения при записи
+const lineMeasurement = "metrics"
+
+type lineCodec struct{}
+
+func (lineCodec) Name() string        { return "line" }
+func (lineCodec) ContentType() string { return "text/plain" }
+
+func (lineCodec) Marshal(v interface{}) ([]byte, error) {
+	var metrics []models.Metric
+	switch m := v.(type) {
+	case models.Metric:
+		metrics = []models.Metric{m}
+	case *models.Metric:
+		metrics = []models.Metric{*m}
+	case []models.Metric:
+		metrics = m
+	case models.MetricsBatch:
+		metrics = m.Metrics
+	case *models.MetricsBatch:
+		metrics = m.Metrics
+	default:
+		return nil, fmt.Errorf("%w: line protocol encodes metrics, got %T", ErrUnsupportedType, v)
+	}
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		buf.WriteString(lineMeasurement)
+		if m.DeviceID != "" {
+			buf.WriteString(",device_id=")
+			buf.WriteString(escapeTag(m.DeviceID))
+		}
+		if m.Region != "" {
+			buf.WriteString(",region=")
+			buf.WriteString(escapeTag(m.Region))
+		}
+		buf.WriteString(" cpu=")
+		buf.WriteString(strconv.FormatFloat(m.CPU, 'g', -1, 64))
+		buf.WriteString(",rps=")
+		buf.WriteString(strconv.FormatFloat(m.RPS, 'g', -1, 64))
+		names := make([]string, 0, len(m.Values))
+		for name := range m.Values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf.WriteByte(',')
+			buf.WriteString(escapeTag(name))
+			buf.WriteByte('=')
+			buf.WriteString(strconv.FormatFloat(m.Values[name], 'g', -1, 64))
+		}
+		if m.Seq > 0 {
+			buf.WriteString(",seq=")
+			buf.WriteString(strconv.FormatUint(m.Seq, 10))
+			buf.WriteByte('u')
+		}
+		if !m.Timestamp.IsZero() {
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(m.Timestamp.UnixNano(), 10))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (lineCodec) Unmarshal(data []byte, v interface{}) error {
+	var metrics []models.Metric
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parseLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n+1, err)
+		}
+		metrics = append(metrics, m)
+	}
+
+	switch dest := v.(type) {
+	case *models.Metric:
+		if len(metrics) != 1 {
+			return fmt.Errorf("expected exactly one metric, got %d", len(metrics))
+		}
+		*dest = metrics[0]
+	case *[]models.Metric:
+		*dest = metrics
+	case *models.MetricsBatch:
+		dest.Metrics = metrics
+	default:
+		return fmt.Errorf("%w: line protocol decodes metrics, got %T", ErrUnsupportedType, v)
+	}
+	return nil
+}
+
+// parseLine разбирает строку "измерение[,теги] поля [время]"
+func parseLine(line string) (models.Metric, error) {
+	var m models.Metric
+	// Кавычки значимы только в значениях полей, поэтому измерение с тегами
+	// отделяется без их учета
+	head, rest, _ := cutUnescaped(line, ' ')
+	parts := append([]string{head}, splitUnescaped(rest, ' ', true)...)
+	if rest == "" || len(parts) > 3 {
+		return m, fmt.Errorf("expected \"measurement[,tags] fields [timestamp]\", got %q", line)
+	}
+
+	for _, tag := range splitUnescaped(parts[0], ',', false)[1:] {
+		key, value, ok := cutUnescaped(tag, '=')
+		if !ok {
+			return m, fmt.Errorf("invalid tag %q", tag)
+		}
+		switch unescapeTag(key) {
+		case "device_id":
+			m.DeviceID = unescapeTag(value)
+		case "region":
+			m.Region = unescapeTag(value)
+		}
+	}
+
+	var hasCPU, hasRPS bool
+	for _, field := range splitUnescaped(parts[1], ',', true) {
+		key, value, ok := cutUnescaped(field, '=')
+		if !ok {
+			return m, fmt.Errorf("invalid field %q", field)
+		}
+		key = unescapeTag(key)
+		if key == "seq" {
+			seq, err := strconv.ParseUint(strings.TrimRight(value, "iu"), 10, 64)
+			if err != nil {
+				return m, fmt.Errorf("invalid seq value %q", value)
+			}
+			m.Seq = seq
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSuffix(value, "i"), 64)
+		switch {
+		case key != "cpu" && key != "rps":
+			// Строковые и логические поля не анализируются
+			if err == nil {
+				if m.Values == nil {
+					m.Values = make(map[string]float64)
+				}
+				m.Values[key] = f
+			}
+		case err != nil:
+			return m, fmt.Errorf("invalid %s value %q", key, value)
+		case key == "cpu":
+			m.CPU, hasCPU = f, true
+		default:
+			m.RPS, hasRPS = f, true
+		}
+	}
+	if !hasCPU || !hasRPS {
+		return m, fmt.Errorf("fields cpu and rps are required")
+	}
+
+	if len(parts) == 3 {
+		ns, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		m.Timestamp = time.Unix(0, ns).UTC()
+	}
+	return m, nil
+}
+
+// splitUnescaped делит s по sep, пропуская экранированные обратной косой
+// чертой, а с quotes — и стоящие в строковых значениях полей в кавычках
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quotes)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// cutUnescaped делит s по первому неэкранированному sep
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	if i := indexUnescaped(s, sep, false); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// indexUnescaped индекс первого неэкранированного sep в s или -1. С quotes
+// пропускаются строки в кавычках, которые начинаются сразу после = значения поля
+func indexUnescaped(s string, sep byte, quotes bool) int {
+	quoted := false
+	valueStart := -1
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quoted:
+			quoted = c != '"'
+		case quotes && c == '"' && i == valueStart:
+			quoted = true
+		case c == sep:
+			return i
+		case c == '=':
+			valueStart = i + 1
+		}
+	}
+	return -1
+}
+
+var tagEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+var tagUnescaper = strings.NewReplacer(`\,`, `,`, `\ `, ` `, `\=`, `=`)
+
+func escapeTag(s string) string   { return tagEscaper.Replace(s) }
+func unescapeTag(s string) string { return tagUnescaper.Replace(s) }
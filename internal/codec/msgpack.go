@@ -0,0 +1,981 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"highload-service/internal/models"
+)
+
+// MessagePack кодек application/msgpack для устройств, которым нужен
+// компактный двоичный формат без схемы и генерации кода. Значения — карты с
+// теми же ключами, что и в JSON (cpu, rps, device_id, values...), числа
+// принимаются любого целого или вещественного типа, время — расширением
+// timestamp (-1) или строкой RFC 3339. Неизвестные ключи пропускаются.
+// Пакет принимается картой {"metrics": [...]} или просто массивом метрик.
+// Поддерживаются models.Metric, []models.Metric, models.MetricsBatch,
+// models.AnalysisResult, models.BatchResponse и models.AsyncAccepted
+var MessagePack Codec = msgpackCodec{}
+
+// msgpackTimestamp тип расширения timestamp
+const msgpackTimestamp = -1
+
+// maxMsgpackDepth наибольшая вложенность пропускаемых значений
+const maxMsgpackDepth = 32
+
+// errMsgpackTruncated данные закончились посреди значения
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string        { return "msgpack" }
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case models.Metric:
+		return appendMsgpackMetric(nil, &m), nil
+	case *models.Metric:
+		return appendMsgpackMetric(nil, m), nil
+	case []models.Metric:
+		return appendMsgpackBatch(nil, m), nil
+	case models.MetricsBatch:
+		return appendMsgpackBatch(nil, m.Metrics), nil
+	case *models.MetricsBatch:
+		return appendMsgpackBatch(nil, m.Metrics), nil
+	case models.AnalysisResult:
+		return AppendMsgpackResult(nil, &m), nil
+	case *models.AnalysisResult:
+		return AppendMsgpackResult(nil, m), nil
+	case models.BatchResponse:
+		return appendMsgpackResponse(nil, &m), nil
+	case *models.BatchResponse:
+		return appendMsgpackResponse(nil, m), nil
+	case models.AsyncAccepted:
+		return appendMsgpackAccepted(nil, &m), nil
+	case *models.AsyncAccepted:
+		return appendMsgpackAccepted(nil, m), nil
+	default:
+		return nil, fmt.Errorf("%w: msgpack encodes metrics and analysis results, got %T", ErrUnsupportedType, v)
+	}
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	r := &msgpackReader{data: data}
+	var err error
+	switch dest := v.(type) {
+	case *models.Metric:
+		*dest = models.Metric{}
+		err = r.metric(dest)
+	case *[]models.Metric:
+		err = r.batch(dest)
+	case *models.MetricsBatch:
+		err = r.batch(&dest.Metrics)
+	case *models.AnalysisResult:
+		*dest = models.AnalysisResult{}
+		err = r.result(dest)
+	case *models.BatchResponse:
+		*dest = models.BatchResponse{}
+		err = r.response(dest)
+	case *models.AsyncAccepted:
+		*dest = models.AsyncAccepted{}
+		err = r.accepted(dest)
+	default:
+		return fmt.Errorf("%w: msgpack decodes metrics and analysis results, got %T", ErrUnsupportedType, v)
+	}
+	if err == nil && r.off != len(data) {
+		err = fmt.Errorf("msgpack: %d trailing bytes", len(data)-r.off)
+	}
+	return err
+}
+
+// AppendMsgpackResult дописывает к b результат анализа картой с ключами JSON
+func AppendMsgpackResult(b []byte, r *models.AnalysisResult) []byte {
+	n := 9
+	for _, set := range []bool{r.Episode != "", r.Snoozed, r.Seq != 0, r.Sequencing != "", r.WarmingUp, len(r.Values) > 0} {
+		if set {
+			n++
+		}
+	}
+	b = appendMsgpackMap(b, n)
+	b = appendMsgpackTime(appendMsgpackString(b, "timestamp"), r.Timestamp)
+	b = appendMsgpackFloat(appendMsgpackString(b, "rolling_avg_cpu"), r.RollingAvgCPU)
+	b = appendMsgpackFloat(appendMsgpackString(b, "rolling_avg_rps"), r.RollingAvgRPS)
+	b = appendMsgpackFloat(appendMsgpackString(b, "z_score_cpu"), r.ZScoreCPU)
+	b = appendMsgpackFloat(appendMsgpackString(b, "z_score_rps"), r.ZScoreRPS)
+	b = appendMsgpackBool(appendMsgpackString(b, "is_anomaly_cpu"), r.IsAnomalyCPU)
+	b = appendMsgpackBool(appendMsgpackString(b, "is_anomaly_rps"), r.IsAnomalyRPS)
+	b = appendMsgpackBool(appendMsgpackString(b, "anomaly_detected"), r.AnomalyDetected)
+	if r.Episode != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "episode"), r.Episode)
+	}
+	if r.Snoozed {
+		b = appendMsgpackBool(appendMsgpackString(b, "snoozed"), true)
+	}
+	if r.Seq != 0 {
+		b = appendMsgpackUint(appendMsgpackString(b, "seq"), r.Seq)
+	}
+	if r.Sequencing != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "sequencing"), r.Sequencing)
+	}
+	if r.WarmingUp {
+		b = appendMsgpackBool(appendMsgpackString(b, "warming_up"), true)
+	}
+	b = appendMsgpackFloat(appendMsgpackString(b, "correlation"), r.Correlation)
+	if len(r.Values) == 0 {
+		return b
+	}
+	b = appendMsgpackMap(appendMsgpackString(b, "values"), len(r.Values))
+	for _, name := range sortedKeys(r.Values) {
+		v := r.Values[name]
+		b = appendMsgpackMap(appendMsgpackString(b, name), 3)
+		b = appendMsgpackFloat(appendMsgpackString(b, "rolling_avg"), v.RollingAvg)
+		b = appendMsgpackFloat(appendMsgpackString(b, "z_score"), v.ZScore)
+		b = appendMsgpackBool(appendMsgpackString(b, "is_anomaly"), v.IsAnomaly)
+	}
+	return b
+}
+
+// AppendMsgpackBatch дописывает к b ответ пакетной загрузки. В MessagePack
+// длина массива пишется перед элементами, поэтому результаты, уже
+// закодированные AppendMsgpackResult, передаются готовыми байтами results
+// вместе с их количеством n
+func AppendMsgpackBatch(b, results []byte, n, processed, rejected, anomalies int, stats *models.BatchStats) []byte {
+	return appendMsgpackBatchFields(appendMsgpackMap(b, 5), results, n, processed, rejected, anomalies, stats)
+}
+
+// appendMsgpackBatchFields дописывает к b пары ключ-значение ответа пакетной
+// загрузки без заголовка карты
+func appendMsgpackBatchFields(b, results []byte, n, processed, rejected, anomalies int, stats *models.BatchStats) []byte {
+	b = append(appendMsgpackArray(appendMsgpackString(b, "results"), n), results...)
+	b = appendMsgpackInt(appendMsgpackString(b, "processed"), int64(processed))
+	b = appendMsgpackInt(appendMsgpackString(b, "rejected"), int64(rejected))
+	b = appendMsgpackInt(appendMsgpackString(b, "anomalies_found"), int64(anomalies))
+	return appendMsgpackStats(appendMsgpackString(b, "stats"), stats)
+}
+
+func appendMsgpackResponse(b []byte, resp *models.BatchResponse) []byte {
+	var results []byte
+	for i := range resp.Results {
+		results = AppendMsgpackResult(results, &resp.Results[i])
+	}
+	if !resp.Duplicate {
+		return AppendMsgpackBatch(b, results, len(resp.Results), resp.Processed, resp.Rejected, resp.AnomaliesFound, &resp.Stats)
+	}
+	b = appendMsgpackBatchFields(appendMsgpackMap(b, 6), results, len(resp.Results), resp.Processed, resp.Rejected, resp.AnomaliesFound, &resp.Stats)
+	return appendMsgpackBool(appendMsgpackString(b, "duplicate"), true)
+}
+
+func appendMsgpackAccepted(b []byte, a *models.AsyncAccepted) []byte {
+	b = appendMsgpackMap(b, 3)
+	b = appendMsgpackString(appendMsgpackString(b, "ingest_id"), a.IngestID)
+	b = appendMsgpackInt(appendMsgpackString(b, "queued"), int64(a.Queued))
+	return appendMsgpackInt(appendMsgpackString(b, "rejected"), int64(a.Rejected))
+}
+
+func appendMsgpackBatch(b []byte, metrics []models.Metric) []byte {
+	b = appendMsgpackArray(appendMsgpackString(appendMsgpackMap(b, 1), "metrics"), len(metrics))
+	for i := range metrics {
+		b = appendMsgpackMetric(b, &metrics[i])
+	}
+	return b
+}
+
+func appendMsgpackMetric(b []byte, m *models.Metric) []byte {
+	n := 2
+	for _, set := range []bool{!m.Timestamp.IsZero(), m.DeviceID != "", m.Region != "", len(m.Values) > 0, m.Seq != 0} {
+		if set {
+			n++
+		}
+	}
+	b = appendMsgpackMap(b, n)
+	if !m.Timestamp.IsZero() {
+		b = appendMsgpackTime(appendMsgpackString(b, "timestamp"), m.Timestamp)
+	}
+	b = appendMsgpackFloat(appendMsgpackString(b, "cpu"), m.CPU)
+	b = appendMsgpackFloat(appendMsgpackString(b, "rps"), m.RPS)
+	if m.DeviceID != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "device_id"), m.DeviceID)
+	}
+	if m.Region != "" {
+		b = appendMsgpackString(appendMsgpackString(b, "region"), m.Region)
+	}
+	if len(m.Values) > 0 {
+		b = appendMsgpackMap(appendMsgpackString(b, "values"), len(m.Values))
+		for _, name := range sortedKeys(m.Values) {
+			b = appendMsgpackFloat(appendMsgpackString(b, name), m.Values[name])
+		}
+	}
+	if m.Seq != 0 {
+		b = appendMsgpackUint(appendMsgpackString(b, "seq"), m.Seq)
+	}
+	return b
+}
+
+func appendMsgpackStats(b []byte, s *models.BatchStats) []byte {
+	n := 3
+	if len(s.Values) > 0 {
+		n++
+	}
+	b = appendMsgpackMap(b, n)
+	b = appendMsgpackFieldStats(appendMsgpackString(b, "cpu"), s.CPU)
+	b = appendMsgpackFieldStats(appendMsgpackString(b, "rps"), s.RPS)
+	if len(s.Values) > 0 {
+		b = appendMsgpackMap(appendMsgpackString(b, "values"), len(s.Values))
+		for _, name := range sortedKeys(s.Values) {
+			b = appendMsgpackFieldStats(appendMsgpackString(b, name), s.Values[name])
+		}
+	}
+	b = appendMsgpackArray(appendMsgpackString(b, "anomaly_indices"), len(s.AnomalyIndices))
+	for _, i := range s.AnomalyIndices {
+		b = appendMsgpackInt(b, int64(i))
+	}
+	return b
+}
+
+func appendMsgpackFieldStats(b []byte, s models.FieldStats) []byte {
+	b = appendMsgpackMap(b, 5)
+	b = appendMsgpackInt(appendMsgpackString(b, "count"), int64(s.Count))
+	b = appendMsgpackFloat(appendMsgpackString(b, "mean"), s.Mean)
+	b = appendMsgpackFloat(appendMsgpackString(b, "min"), s.Min)
+	b = appendMsgpackFloat(appendMsgpackString(b, "max"), s.Max)
+	return appendMsgpackFloat(appendMsgpackString(b, "std_dev"), s.StdDev)
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+	}
+}
+
+func appendMsgpackArray(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendMsgpackBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 128:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+	}
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(int8(v)))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(int8(v)))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(int16(v)))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(int32(v)))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+	}
+}
+
+// appendMsgpackTime дописывает время расширением timestamp в самой короткой
+// из трех форм: 32 бита секунд, 64 бита (30 бит наносекунд и 34 бита секунд)
+// или 96 бит
+func appendMsgpackTime(b []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd6, 0xff), uint32(sec))
+	case sec>>34 == 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xd7, 0xff), uint64(nsec)<<34|uint64(sec))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc7, 12, 0xff), uint32(nsec))
+		return binary.BigEndian.AppendUint64(b, uint64(sec))
+	}
+}
+
+// msgpackReader разбирает MessagePack из data начиная с off
+type msgpackReader struct {
+	data []byte
+	off  int
+}
+
+// take возвращает следующие n байт
+func (r *msgpackReader) take(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.off < n {
+		return nil, errMsgpackTruncated
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *msgpackReader) byte() (byte, error) {
+	b, err := r.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// length читает длину размером size байт
+func (r *msgpackReader) length(size int) (int, error) {
+	b, err := r.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+// nil пропускает nil и сообщает, был ли он: nil означает значение по умолчанию
+func (r *msgpackReader) nil() bool {
+	if r.off < len(r.data) && r.data[r.off] == 0xc0 {
+		r.off++
+		return true
+	}
+	return false
+}
+
+// mapLen читает заголовок карты; nil — пустая карта
+func (r *msgpackReader) mapLen() (int, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	switch {
+	case c&0xf0 == 0x80:
+		n = int(c & 0x0f)
+	case c == 0xde:
+		n, err = r.length(2)
+	case c == 0xdf:
+		n, err = r.length(4)
+	case c == 0xc0:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("msgpack: expected a map, got 0x%02x", c)
+	}
+	// Каждая пара занимает хотя бы два байта: длина не может превышать остаток
+	if err == nil && n > (len(r.data)-r.off)/2 {
+		err = errMsgpackTruncated
+	}
+	return n, err
+}
+
+// arrayLen читает заголовок массива; nil — пустой массив
+func (r *msgpackReader) arrayLen() (int, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	switch {
+	case c&0xf0 == 0x90:
+		n = int(c & 0x0f)
+	case c == 0xdc:
+		n, err = r.length(2)
+	case c == 0xdd:
+		n, err = r.length(4)
+	case c == 0xc0:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("msgpack: expected an array, got 0x%02x", c)
+	}
+	if err == nil && n > len(r.data)-r.off {
+		err = errMsgpackTruncated
+	}
+	return n, err
+}
+
+// stringBytes читает строку (или bin) без копирования
+func (r *msgpackReader) stringBytes() ([]byte, error) {
+	c, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9 || c == 0xc4:
+		n, err = r.length(1)
+	case c == 0xda || c == 0xc5:
+		n, err = r.length(2)
+	case c == 0xdb || c == 0xc6:
+		n, err = r.length(4)
+	default:
+		return nil, fmt.Errorf("msgpack: expected a string, got 0x%02x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.take(n)
+}
+
+// string читает строку и копирует ее: Unmarshal не удерживает data
+func (r *msgpackReader) string() (string, error) {
+	if r.nil() {
+		return "", nil
+	}
+	b, err := r.stringBytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("msgpack: string contains invalid UTF-8")
+	}
+	return string(b), nil
+}
+
+// number читает целое или вещественное число
+func (r *msgpackReader) number() (float64, error) {
+	if r.nil() {
+		return 0, nil
+	}
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case 0xca:
+		b, err := r.take(4)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := r.take(8)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	r.off--
+	i, u, signed, err := r.integer()
+	if signed {
+		return float64(i), err
+	}
+	return float64(u), err
+}
+
+// uint читает неотрицательное целое
+func (r *msgpackReader) uint() (uint64, error) {
+	if r.nil() {
+		return 0, nil
+	}
+	i, u, signed, err := r.integer()
+	if err != nil {
+		return 0, err
+	}
+	if signed {
+		if i < 0 {
+			return 0, fmt.Errorf("msgpack: expected a non-negative integer, got %d", i)
+		}
+		return uint64(i), nil
+	}
+	return u, nil
+}
+
+// int читает целое со знаком
+func (r *msgpackReader) int() (int64, error) {
+	if r.nil() {
+		return 0, nil
+	}
+	i, u, signed, err := r.integer()
+	if err != nil || signed {
+		return i, err
+	}
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("msgpack: integer %d overflows int64", u)
+	}
+	return int64(u), nil
+}
+
+// integer читает целое: знаковые форматы возвращаются в i, беззнаковые в u
+func (r *msgpackReader) integer() (i int64, u uint64, signed bool, err error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	switch {
+	case c < 0x80:
+		return 0, uint64(c), false, nil
+	case c >= 0xe0:
+		return int64(int8(c)), 0, true, nil
+	}
+	size := map[byte]int{0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8, 0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8}[c]
+	if size == 0 {
+		return 0, 0, false, fmt.Errorf("msgpack: expected a number, got 0x%02x", c)
+	}
+	b, err := r.take(size)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	switch c {
+	case 0xcc:
+		return 0, uint64(b[0]), false, nil
+	case 0xcd:
+		return 0, uint64(binary.BigEndian.Uint16(b)), false, nil
+	case 0xce:
+		return 0, uint64(binary.BigEndian.Uint32(b)), false, nil
+	case 0xcf:
+		return 0, binary.BigEndian.Uint64(b), false, nil
+	case 0xd0:
+		return int64(int8(b[0])), 0, true, nil
+	case 0xd1:
+		return int64(int16(binary.BigEndian.Uint16(b))), 0, true, nil
+	case 0xd2:
+		return int64(int32(binary.BigEndian.Uint32(b))), 0, true, nil
+	default:
+		return int64(binary.BigEndian.Uint64(b)), 0, true, nil
+	}
+}
+
+func (r *msgpackReader) bool() (bool, error) {
+	if r.nil() {
+		return false, nil
+	}
+	c, err := r.byte()
+	if err != nil {
+		return false, err
+	}
+	switch c {
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	default:
+		return false, fmt.Errorf("msgpack: expected a boolean, got 0x%02x", c)
+	}
+}
+
+// time читает расширение timestamp или строку RFC 3339
+func (r *msgpackReader) time() (time.Time, error) {
+	if r.nil() {
+		return time.Time{}, nil
+	}
+	if r.off < len(r.data) && (r.data[r.off]&0xe0 == 0xa0 || r.data[r.off] == 0xd9) {
+		s, err := r.string()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	c, err := r.byte()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var size int
+	switch c {
+	case 0xd6:
+		size = 4
+	case 0xd7:
+		size = 8
+	case 0xc7:
+		if size, err = r.length(1); err != nil {
+			return time.Time{}, err
+		}
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: expected a timestamp, got 0x%02x", c)
+	}
+	typ, err := r.byte()
+	if err != nil {
+		return time.Time{}, err
+	}
+	b, err := r.take(size)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if int8(typ) != msgpackTimestamp {
+		return time.Time{}, fmt.Errorf("msgpack: expected a timestamp extension, got type %d", int8(typ))
+	}
+	var sec, nsec int64
+	switch size {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(b))
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		sec, nsec = int64(v&(1<<34-1)), int64(v>>34)
+	case 12:
+		nsec, sec = int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint64(b[4:]))
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", size)
+	}
+	if nsec >= int64(time.Second) {
+		return time.Time{}, fmt.Errorf("msgpack: timestamp nanoseconds out of range: %d", nsec)
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// skip пропускает значение любого типа
+func (r *msgpackReader) skip(depth int) error {
+	if depth > maxMsgpackDepth {
+		return errors.New("msgpack: value nested too deeply")
+	}
+	c, err := r.byte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return nil
+	case c&0xf0 == 0x80, c == 0xde, c == 0xdf:
+		r.off--
+		n, err := r.mapLen()
+		for i := 0; err == nil && i < 2*n; i++ {
+			err = r.skip(depth + 1)
+		}
+		return err
+	case c&0xf0 == 0x90, c == 0xdc, c == 0xdd:
+		r.off--
+		n, err := r.arrayLen()
+		for i := 0; err == nil && i < n; i++ {
+			err = r.skip(depth + 1)
+		}
+		return err
+	case c&0xe0 == 0xa0, c == 0xd9, c == 0xda, c == 0xdb, c == 0xc4, c == 0xc5, c == 0xc6:
+		r.off--
+		_, err := r.stringBytes()
+		return err
+	}
+	var n int
+	switch c {
+	case 0xcc, 0xd0:
+		n = 1
+	case 0xcd, 0xd1:
+		n = 2
+	case 0xca, 0xce, 0xd2:
+		n = 4
+	case 0xcb, 0xcf, 0xd3:
+		n = 8
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		n = 1 + 1<<(c-0xd4)
+	case 0xc7, 0xc8, 0xc9:
+		size := map[byte]int{0xc7: 1, 0xc8: 2, 0xc9: 4}[c]
+		if n, err = r.length(size); err != nil {
+			return err
+		}
+		n++
+	default:
+		return fmt.Errorf("msgpack: invalid type 0x%02x", c)
+	}
+	_, err = r.take(n)
+	return err
+}
+
+// fields перебирает ключи карты; field читает значение ключа и возвращает
+// false, если ключ неизвестен и значение надо пропустить
+func (r *msgpackReader) fields(field func(key string) (bool, error)) error {
+	n, err := r.mapLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := r.stringBytes()
+		if err != nil {
+			return err
+		}
+		// Сравнение с string(key) в switch не выделяет память
+		known, err := field(string(key))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if !known {
+			if err := r.skip(0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *msgpackReader) batch(dest *[]models.Metric) error {
+	metrics := (*dest)[:0]
+	defer func() { *dest = metrics }()
+	// Повторный ключ metrics заменяет прежние метрики, как в encoding/json
+	readMetrics := func() error {
+		n, err := r.arrayLen()
+		if err != nil {
+			return err
+		}
+		metrics = metrics[:0]
+		for i := 0; i < n; i++ {
+			metrics = append(metrics, models.Metric{})
+			if err := r.metric(&metrics[i]); err != nil {
+				return fmt.Errorf("metric %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+	if r.off < len(r.data) && (r.data[r.off]&0xf0 == 0x90 || r.data[r.off] == 0xdc || r.data[r.off] == 0xdd) {
+		return readMetrics()
+	}
+	return r.fields(func(key string) (bool, error) {
+		if key != "metrics" {
+			return false, nil
+		}
+		return true, readMetrics()
+	})
+}
+
+func (r *msgpackReader) metric(m *models.Metric) error {
+	return r.fields(func(key string) (bool, error) {
+		var err error
+		switch key {
+		case "timestamp":
+			m.Timestamp, err = r.time()
+		case "cpu":
+			m.CPU, err = r.number()
+		case "rps":
+			m.RPS, err = r.number()
+		case "device_id":
+			m.DeviceID, err = r.string()
+		case "region":
+			m.Region, err = r.string()
+		case "seq":
+			m.Seq, err = r.uint()
+		case "values":
+			err = r.fields(func(name string) (bool, error) {
+				v, err := r.number()
+				if m.Values == nil {
+					m.Values = make(map[string]float64)
+				}
+				m.Values[name] = v
+				return true, err
+			})
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *msgpackReader) result(res *models.AnalysisResult) error {
+	return r.fields(func(key string) (bool, error) {
+		var err error
+		switch key {
+		case "timestamp":
+			res.Timestamp, err = r.time()
+		case "rolling_avg_cpu":
+			res.RollingAvgCPU, err = r.number()
+		case "rolling_avg_rps":
+			res.RollingAvgRPS, err = r.number()
+		case "z_score_cpu":
+			res.ZScoreCPU, err = r.number()
+		case "z_score_rps":
+			res.ZScoreRPS, err = r.number()
+		case "is_anomaly_cpu":
+			res.IsAnomalyCPU, err = r.bool()
+		case "is_anomaly_rps":
+			res.IsAnomalyRPS, err = r.bool()
+		case "anomaly_detected":
+			res.AnomalyDetected, err = r.bool()
+		case "episode":
+			res.Episode, err = r.string()
+		case "snoozed":
+			res.Snoozed, err = r.bool()
+		case "seq":
+			res.Seq, err = r.uint()
+		case "sequencing":
+			res.Sequencing, err = r.string()
+		case "warming_up":
+			res.WarmingUp, err = r.bool()
+		case "correlation":
+			res.Correlation, err = r.number()
+		case "values":
+			err = r.fields(func(name string) (bool, error) {
+				var v models.ValueResult
+				err := r.fields(func(key string) (bool, error) {
+					var err error
+					switch key {
+					case "rolling_avg":
+						v.RollingAvg, err = r.number()
+					case "z_score":
+						v.ZScore, err = r.number()
+					case "is_anomaly":
+						v.IsAnomaly, err = r.bool()
+					default:
+						return false, nil
+					}
+					return true, err
+				})
+				if res.Values == nil {
+					res.Values = make(map[string]models.ValueResult)
+				}
+				res.Values[name] = v
+				return true, err
+			})
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *msgpackReader) response(resp *models.BatchResponse) error {
+	return r.fields(func(key string) (bool, error) {
+		var err error
+		var n int64
+		switch key {
+		case "results":
+			var count int
+			if count, err = r.arrayLen(); err != nil {
+				return true, err
+			}
+			resp.Results = make([]models.AnalysisResult, count)
+			for i := 0; i < count && err == nil; i++ {
+				err = r.result(&resp.Results[i])
+			}
+		case "processed":
+			n, err = r.int()
+			resp.Processed = int(n)
+		case "rejected":
+			n, err = r.int()
+			resp.Rejected = int(n)
+		case "anomalies_found":
+			n, err = r.int()
+			resp.AnomaliesFound = int(n)
+		case "stats":
+			err = r.stats(&resp.Stats)
+		case "duplicate":
+			resp.Duplicate, err = r.bool()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *msgpackReader) accepted(a *models.AsyncAccepted) error {
+	return r.fields(func(key string) (bool, error) {
+		var err error
+		var n int64
+		switch key {
+		case "ingest_id":
+			a.IngestID, err = r.string()
+		case "queued":
+			n, err = r.int()
+			a.Queued = int(n)
+		case "rejected":
+			n, err = r.int()
+			a.Rejected = int(n)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *msgpackReader) stats(s *models.BatchStats) error {
+	return r.fields(func(key string) (bool, error) {
+		var err error
+		switch key {
+		case "cpu":
+			s.CPU, err = r.fieldStats()
+		case "rps":
+			s.RPS, err = r.fieldStats()
+		case "values":
+			err = r.fields(func(name string) (bool, error) {
+				fs, err := r.fieldStats()
+				if s.Values == nil {
+					s.Values = make(map[string]models.FieldStats)
+				}
+				s.Values[name] = fs
+				return true, err
+			})
+		case "anomaly_indices":
+			var count int
+			if count, err = r.arrayLen(); err != nil {
+				return true, err
+			}
+			s.AnomalyIndices = make([]int, count)
+			for i := 0; i < count && err == nil; i++ {
+				var v int64
+				v, err = r.int()
+				s.AnomalyIndices[i] = int(v)
+			}
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (r *msgpackReader) fieldStats() (models.FieldStats, error) {
+	var s models.FieldStats
+	err := r.fields(func(key string) (bool, error) {
+		var err error
+		var n int64
+		switch key {
+		case "count":
+			n, err = r.int()
+			s.Count = int(n)
+		case "mean":
+			s.Mean, err = r.number()
+		case "min":
+			s.Min, err = r.number()
+		case "max":
+			s.Max, err = r.number()
+		case "std_dev":
+			s.StdDev, err = r.number()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	return s, err
+}
@@ -0,0 +1,567 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"highload-service/internal/models"
+)
+
+// Protobuf кодек application/x-protobuf по схеме metrics.proto. Сообщения
+// кодируются и разбираются вручную (protowire), без reflect и промежуточных
+// структур: разбор идет сразу в models.Metric. Как и в сгенерированном коде,
+// неизвестные поля и поля с неожиданным типом провода пропускаются.
+// Поддерживаются models.Metric, []models.Metric, models.MetricsBatch,
+// models.AnalysisResult, models.BatchResponse и models.AsyncAccepted
+var Protobuf Codec = protobufCodec{}
+
+// Типы провода полей схемы
+const (
+	varintType  = protowire.VarintType
+	fixed64Type = protowire.Fixed64Type
+	bytesType   = protowire.BytesType
+)
+
+// errInvalidUTF8 строковое поле содержит некорректный UTF-8 (proto3 это запрещает)
+var errInvalidUTF8 = errors.New("string field contains invalid UTF-8")
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case models.Metric:
+		return appendMetric(nil, &m), nil
+	case *models.Metric:
+		return appendMetric(nil, m), nil
+	case []models.Metric:
+		return appendMetrics(nil, m), nil
+	case models.MetricsBatch:
+		return appendMetrics(nil, m.Metrics), nil
+	case *models.MetricsBatch:
+		return appendMetrics(nil, m.Metrics), nil
+	case models.AnalysisResult:
+		return appendResult(nil, &m), nil
+	case *models.AnalysisResult:
+		return appendResult(nil, m), nil
+	case models.BatchResponse:
+		return appendBatchResponse(nil, &m), nil
+	case *models.BatchResponse:
+		return appendBatchResponse(nil, m), nil
+	case models.AsyncAccepted:
+		return appendAccepted(nil, &m), nil
+	case *models.AsyncAccepted:
+		return appendAccepted(nil, m), nil
+	default:
+		return nil, fmt.Errorf("%w: protobuf encodes metrics and analysis results, got %T", ErrUnsupportedType, v)
+	}
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch dest := v.(type) {
+	case *models.Metric:
+		*dest = models.Metric{}
+		return decodeMetric(data, dest)
+	case *[]models.Metric:
+		return decodeMetrics(data, dest)
+	case *models.MetricsBatch:
+		return decodeMetrics(data, &dest.Metrics)
+	case *models.AnalysisResult:
+		*dest = models.AnalysisResult{}
+		return decodeResult(data, dest)
+	case *models.BatchResponse:
+		*dest = models.BatchResponse{}
+		return decodeBatchResponse(data, dest)
+	case *models.AsyncAccepted:
+		*dest = models.AsyncAccepted{}
+		return decodeAccepted(data, dest)
+	default:
+		return fmt.Errorf("%w: protobuf decodes metrics and analysis results, got %T", ErrUnsupportedType, v)
+	}
+}
+
+// AppendResult дописывает к b результат анализа сообщением AnalysisResult
+func AppendResult(b []byte, r *models.AnalysisResult) []byte {
+	return appendResult(b, r)
+}
+
+// AppendBatchResult дописывает к b результат пакета полем results сообщения
+// BatchResponse. Повторяющиеся поля protobuf склеиваются, поэтому ответ
+// пакетной загрузки пишется по результату, а итог — AppendBatchSummary
+func AppendBatchResult(b []byte, r *models.AnalysisResult) []byte {
+	return appendMessage(b, 1, func(b []byte) []byte { return appendResult(b, r) })
+}
+
+// AppendBatchSummary дописывает к b итоговые поля BatchResponse
+func AppendBatchSummary(b []byte, processed, rejected, anomalies int, stats *models.BatchStats) []byte {
+	b = appendVarint(b, 2, uint64(processed))
+	b = appendVarint(b, 3, uint64(rejected))
+	b = appendVarint(b, 4, uint64(anomalies))
+	return appendMessage(b, 5, func(b []byte) []byte { return appendBatchStats(b, stats) })
+}
+
+func appendMetrics(b []byte, metrics []models.Metric) []byte {
+	for i := range metrics {
+		b = appendMessage(b, 1, func(b []byte) []byte { return appendMetric(b, &metrics[i]) })
+	}
+	return b
+}
+
+func appendMetric(b []byte, m *models.Metric) []byte {
+	b = appendTimestamp(b, 1, m.Timestamp)
+	b = appendDouble(b, 2, m.CPU)
+	b = appendDouble(b, 3, m.RPS)
+	b = appendString(b, 4, m.DeviceID)
+	b = appendString(b, 5, m.Region)
+	for _, name := range sortedKeys(m.Values) {
+		v := m.Values[name]
+		b = appendMessage(b, 6, func(b []byte) []byte {
+			return appendDouble(appendString(b, 1, name), 2, v)
+		})
+	}
+	return appendVarint(b, 7, m.Seq)
+}
+
+func appendResult(b []byte, r *models.AnalysisResult) []byte {
+	b = appendTimestamp(b, 1, r.Timestamp)
+	b = appendDouble(b, 2, r.RollingAvgCPU)
+	b = appendDouble(b, 3, r.RollingAvgRPS)
+	b = appendDouble(b, 4, r.ZScoreCPU)
+	b = appendDouble(b, 5, r.ZScoreRPS)
+	b = appendBool(b, 6, r.IsAnomalyCPU)
+	b = appendBool(b, 7, r.IsAnomalyRPS)
+	b = appendBool(b, 8, r.AnomalyDetected)
+	b = appendString(b, 9, r.Episode)
+	b = appendBool(b, 10, r.Snoozed)
+	b = appendVarint(b, 11, r.Seq)
+	b = appendString(b, 12, r.Sequencing)
+	b = appendBool(b, 13, r.WarmingUp)
+	b = appendDouble(b, 14, r.Correlation)
+	for _, name := range sortedKeys(r.Values) {
+		v := r.Values[name]
+		b = appendMessage(b, 15, func(b []byte) []byte {
+			b = appendString(b, 1, name)
+			return appendMessage(b, 2, func(b []byte) []byte {
+				b = appendDouble(b, 1, v.RollingAvg)
+				b = appendDouble(b, 2, v.ZScore)
+				return appendBool(b, 3, v.IsAnomaly)
+			})
+		})
+	}
+	return b
+}
+
+func appendBatchResponse(b []byte, resp *models.BatchResponse) []byte {
+	for i := range resp.Results {
+		b = AppendBatchResult(b, &resp.Results[i])
+	}
+	b = AppendBatchSummary(b, resp.Processed, resp.Rejected, resp.AnomaliesFound, &resp.Stats)
+	return appendBool(b, 6, resp.Duplicate)
+}
+
+func appendAccepted(b []byte, a *models.AsyncAccepted) []byte {
+	b = appendString(b, 1, a.IngestID)
+	b = appendVarint(b, 2, uint64(a.Queued))
+	return appendVarint(b, 3, uint64(a.Rejected))
+}
+
+func appendBatchStats(b []byte, s *models.BatchStats) []byte {
+	b = appendMessage(b, 1, func(b []byte) []byte { return appendFieldStats(b, s.CPU) })
+	b = appendMessage(b, 2, func(b []byte) []byte { return appendFieldStats(b, s.RPS) })
+	for _, name := range sortedKeys(s.Values) {
+		fs := s.Values[name]
+		b = appendMessage(b, 3, func(b []byte) []byte {
+			return appendMessage(appendString(b, 1, name), 2, func(b []byte) []byte { return appendFieldStats(b, fs) })
+		})
+	}
+	if len(s.AnomalyIndices) > 0 {
+		b = appendMessage(b, 4, func(b []byte) []byte {
+			for _, i := range s.AnomalyIndices {
+				b = protowire.AppendVarint(b, uint64(int64(i)))
+			}
+			return b
+		})
+	}
+	return b
+}
+
+func appendFieldStats(b []byte, s models.FieldStats) []byte {
+	b = appendVarint(b, 1, uint64(s.Count))
+	b = appendDouble(b, 2, s.Mean)
+	b = appendDouble(b, 3, s.Min)
+	b = appendDouble(b, 4, s.Max)
+	return appendDouble(b, 5, s.StdDev)
+}
+
+// appendMessage дописывает вложенное сообщение, которое кодирует body. Длина
+// известна только после кодирования, поэтому тело сдвигается на размер ее varint
+func appendMessage(b []byte, num protowire.Number, body func([]byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, bytesType)
+	start := len(b)
+	b = body(b)
+	n := len(b) - start
+	size := protowire.SizeVarint(uint64(n))
+	for i := 0; i < size; i++ {
+		b = append(b, 0)
+	}
+	copy(b[start+size:], b[start:start+n])
+	protowire.AppendVarint(b[start:start], uint64(n))
+	return b
+}
+
+// appendTimestamp дописывает google.protobuf.Timestamp; нулевое время опускается
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendMessage(b, num, func(b []byte) []byte {
+		b = appendVarint(b, 1, uint64(t.Unix()))
+		return appendVarint(b, 2, uint64(t.Nanosecond()))
+	})
+}
+
+// Значения по умолчанию proto3 (ноль, false, пустая строка) не кодируются
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, varintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, bytesType)
+	return protowire.AppendString(b, s)
+}
+
+// sortedKeys ключи карты по алфавиту: кодирование детерминировано
+func sortedKeys[V any](m map[string]V) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// protoField поле сообщения: число для varint и fixed64, содержимое для bytes
+type protoField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+// is сообщает, что поле имеет номер num и тип провода typ
+func (f protoField) is(num protowire.Number, typ protowire.Type) bool {
+	return f.num == num && f.typ == typ
+}
+
+func (f protoField) double() float64 { return math.Float64frombits(f.value) }
+
+// string копирует содержимое поля: Unmarshal не удерживает data
+func (f protoField) string() (string, error) {
+	if !utf8.Valid(f.bytes) {
+		return "", fmt.Errorf("field %d: %w", f.num, errInvalidUTF8)
+	}
+	return string(f.bytes), nil
+}
+
+// decodeFields передает field поля сообщения b по порядку
+func decodeFields(b []byte, field func(f protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case varintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case bytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := field(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeMetrics разбирает MetricsBatch, переиспользуя емкость dest
+func decodeMetrics(data []byte, dest *[]models.Metric) error {
+	metrics := (*dest)[:0]
+	err := decodeFields(data, func(f protoField) error {
+		if !f.is(1, bytesType) {
+			return nil
+		}
+		metrics = append(metrics, models.Metric{})
+		if err := decodeMetric(f.bytes, &metrics[len(metrics)-1]); err != nil {
+			return fmt.Errorf("metric %d: %w", len(metrics)-1, err)
+		}
+		return nil
+	})
+	*dest = metrics
+	return err
+}
+
+func decodeMetric(data []byte, m *models.Metric) error {
+	return decodeFields(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, bytesType):
+			m.Timestamp, err = decodeTimestamp(f.bytes)
+		case f.is(2, fixed64Type):
+			m.CPU = f.double()
+		case f.is(3, fixed64Type):
+			m.RPS = f.double()
+		case f.is(4, bytesType):
+			m.DeviceID, err = f.string()
+		case f.is(5, bytesType):
+			m.Region, err = f.string()
+		case f.is(6, bytesType):
+			var name string
+			var v float64
+			err = decodeFields(f.bytes, func(e protoField) error {
+				var err error
+				switch {
+				case e.is(1, bytesType):
+					name, err = e.string()
+				case e.is(2, fixed64Type):
+					v = e.double()
+				}
+				return err
+			})
+			if m.Values == nil {
+				m.Values = make(map[string]float64)
+			}
+			m.Values[name] = v
+		case f.is(7, varintType):
+			m.Seq = f.value
+		}
+		return err
+	})
+}
+
+func decodeResult(data []byte, r *models.AnalysisResult) error {
+	return decodeFields(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, bytesType):
+			r.Timestamp, err = decodeTimestamp(f.bytes)
+		case f.is(2, fixed64Type):
+			r.RollingAvgCPU = f.double()
+		case f.is(3, fixed64Type):
+			r.RollingAvgRPS = f.double()
+		case f.is(4, fixed64Type):
+			r.ZScoreCPU = f.double()
+		case f.is(5, fixed64Type):
+			r.ZScoreRPS = f.double()
+		case f.is(6, varintType):
+			r.IsAnomalyCPU = f.value != 0
+		case f.is(7, varintType):
+			r.IsAnomalyRPS = f.value != 0
+		case f.is(8, varintType):
+			r.AnomalyDetected = f.value != 0
+		case f.is(9, bytesType):
+			r.Episode, err = f.string()
+		case f.is(10, varintType):
+			r.Snoozed = f.value != 0
+		case f.is(11, varintType):
+			r.Seq = f.value
+		case f.is(12, bytesType):
+			r.Sequencing, err = f.string()
+		case f.is(13, varintType):
+			r.WarmingUp = f.value != 0
+		case f.is(14, fixed64Type):
+			r.Correlation = f.double()
+		case f.is(15, bytesType):
+			var name string
+			var v models.ValueResult
+			err = decodeFields(f.bytes, func(e protoField) error {
+				var err error
+				switch {
+				case e.is(1, bytesType):
+					name, err = e.string()
+				case e.is(2, bytesType):
+					err = decodeFields(e.bytes, func(g protoField) error {
+						switch {
+						case g.is(1, fixed64Type):
+							v.RollingAvg = g.double()
+						case g.is(2, fixed64Type):
+							v.ZScore = g.double()
+						case g.is(3, varintType):
+							v.IsAnomaly = g.value != 0
+						}
+						return nil
+					})
+				}
+				return err
+			})
+			if r.Values == nil {
+				r.Values = make(map[string]models.ValueResult)
+			}
+			r.Values[name] = v
+		}
+		return err
+	})
+}
+
+func decodeBatchResponse(data []byte, resp *models.BatchResponse) error {
+	return decodeFields(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, bytesType):
+			resp.Results = append(resp.Results, models.AnalysisResult{})
+			err = decodeResult(f.bytes, &resp.Results[len(resp.Results)-1])
+		case f.is(2, varintType):
+			resp.Processed = int(f.value)
+		case f.is(3, varintType):
+			resp.Rejected = int(f.value)
+		case f.is(4, varintType):
+			resp.AnomaliesFound = int(f.value)
+		case f.is(5, bytesType):
+			err = decodeBatchStats(f.bytes, &resp.Stats)
+		case f.is(6, varintType):
+			resp.Duplicate = f.value != 0
+		}
+		return err
+	})
+}
+
+func decodeAccepted(data []byte, a *models.AsyncAccepted) error {
+	return decodeFields(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, bytesType):
+			a.IngestID, err = f.string()
+		case f.is(2, varintType):
+			a.Queued = int(f.value)
+		case f.is(3, varintType):
+			a.Rejected = int(f.value)
+		}
+		return err
+	})
+}
+
+func decodeBatchStats(data []byte, s *models.BatchStats) error {
+	return decodeFields(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, bytesType):
+			s.CPU, err = decodeFieldStats(f.bytes)
+		case f.is(2, bytesType):
+			s.RPS, err = decodeFieldStats(f.bytes)
+		case f.is(3, bytesType):
+			var name string
+			var fs models.FieldStats
+			err = decodeFields(f.bytes, func(e protoField) error {
+				var err error
+				switch {
+				case e.is(1, bytesType):
+					name, err = e.string()
+				case e.is(2, bytesType):
+					fs, err = decodeFieldStats(e.bytes)
+				}
+				return err
+			})
+			if s.Values == nil {
+				s.Values = make(map[string]models.FieldStats)
+			}
+			s.Values[name] = fs
+		case f.is(4, varintType):
+			s.AnomalyIndices = append(s.AnomalyIndices, int(int32(f.value)))
+		case f.is(4, bytesType):
+			// Упакованное повторяющееся поле: varint подряд
+			for b := f.bytes; len(b) > 0; {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return fmt.Errorf("field 4: %w", protowire.ParseError(n))
+				}
+				s.AnomalyIndices = append(s.AnomalyIndices, int(int32(v)))
+				b = b[n:]
+			}
+		}
+		return err
+	})
+}
+
+func decodeFieldStats(data []byte) (models.FieldStats, error) {
+	var s models.FieldStats
+	err := decodeFields(data, func(f protoField) error {
+		switch {
+		case f.is(1, varintType):
+			s.Count = int(int64(f.value))
+		case f.is(2, fixed64Type):
+			s.Mean = f.double()
+		case f.is(3, fixed64Type):
+			s.Min = f.double()
+		case f.is(4, fixed64Type):
+			s.Max = f.double()
+		case f.is(5, fixed64Type):
+			s.StdDev = f.double()
+		}
+		return nil
+	})
+	return s, err
+}
+
+// decodeTimestamp разбирает google.protobuf.Timestamp
+func decodeTimestamp(data []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := decodeFields(data, func(f protoField) error {
+		switch {
+		case f.is(1, varintType):
+			seconds = int64(f.value)
+		case f.is(2, varintType):
+			nanos = int64(int32(f.value))
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nanos < 0 || nanos >= int64(time.Second) {
+		return time.Time{}, fmt.Errorf("timestamp nanos out of range: %d", nanos)
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
@@ -0,0 +1,893 @@
+// Package config загружает конфигурацию сервиса.
+//
+// Источники в порядке приоритета (последний побеждает):
+//  1. значения по умолчанию;
+//  2. JSON-файл из CONFIG_FILE с плоским объектом {"КЛЮЧ": "значение"};
+//  3. переменные окружения с теми же именами.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/accesslog"
+	"highload-service/internal/admin"
+	"highload-service/internal/admission"
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/autoscale"
+	"highload-service/internal/availability"
+	"highload-service/internal/backpressure"
+	"highload-service/internal/cache"
+	"highload-service/internal/cadence"
+	"highload-service/internal/counters"
+	"highload-service/internal/devices"
+	"highload-service/internal/devicestate"
+	"highload-service/internal/encryption"
+	"highload-service/internal/flags"
+	"highload-service/internal/health"
+	"highload-service/internal/incidents"
+	"highload-service/internal/journal"
+	"highload-service/internal/listeners"
+	"highload-service/internal/loglevel"
+	"highload-service/internal/metrics"
+	"highload-service/internal/middleware"
+	"highload-service/internal/migrations"
+	"highload-service/internal/outbox"
+	"highload-service/internal/payload"
+	"highload-service/internal/profiler"
+	"highload-service/internal/quota"
+	"highload-service/internal/rollup"
+	"highload-service/internal/sampling"
+	"highload-service/internal/scheduler"
+	"highload-service/internal/score"
+	"highload-service/internal/selfmon"
+	"highload-service/internal/sequencer"
+	"highload-service/internal/watchdog"
+)
+
+// Config содержит конфигурацию сервиса
+type Config struct {
+	ServerAddr     string
+	RedisAddr      string
+	RedisPassword  string
+	RedisDB        int
+	WorkerCount    int
+	BufferSize     int
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	DrainTimeout   time.Duration
+	WarmupSamples  int
+	WarmupDuration time.Duration
+	// AvailabilityInterval период проверки готовности для учета доступности
+	AvailabilityInterval time.Duration
+	Quota                quota.Limits
+	Logging              middleware.LoggingConfig
+	// ConcurrencyLimits ограничения одновременных запросов по маршрутам
+	ConcurrencyLimits middleware.ConcurrencyLimits
+	// Middleware имена middleware в порядке вызова (MIDDLEWARE)
+	Middleware []string
+	// CORS настройки middleware cors
+	CORS middleware.CORSConfig
+	// APIKeys ключи, принимаемые middleware auth; AuthExemptPaths пути без проверки
+	APIKeys         []string
+	AuthExemptPaths []string
+	// ConcurrencyRetryAfter значение Retry-After при превышении ограничения
+	ConcurrencyRetryAfter time.Duration
+	AccessLog             accesslog.Config
+	Profiler              profiler.Config
+	LogLevel              loglevel.Level
+	// SelfMonitor анализ загрузки CPU, памяти и горутин самого сервиса
+	SelfMonitor selfmon.Config
+	// Watchdog сторож воркеров анализатора
+	Watchdog watchdog.Config
+	// Autoscale пределы и пороги автомасштабирования воркеров; WorkerCount —
+	// начальное количество
+	Autoscale autoscale.Config
+	// AdminToken токен административного API; пустое значение отключает /admin
+	AdminToken string
+	// AuditLogOutput stdout или путь к файлу журнала аудита
+	AuditLogOutput string
+	// Flags базовые значения feature-флагов (flags.Defaults с учетом FEATURE_FLAGS)
+	Flags map[string]flags.Flag
+	// FlagsRedisKey ключ Redis с переопределениями флагов
+	FlagsRedisKey string
+	// FlagsRefreshInterval период перечитывания переопределений
+	FlagsRefreshInterval time.Duration
+	// Detector параметры основного детектора
+	Detector analytics.DetectorConfig
+	// DeviceWindows окна детектора по устройствам; nil — одни общие окна
+	DeviceWindows *analytics.DeviceWindowsConfig
+	// CounterFields поля метрики со счетчиками (cpu, rps, имена values), которые
+	// анализатор переводит в скорость
+	CounterFields []string
+	// AnalyticsShards количество шардов окон анализатора; больше одного — только
+	// с окнами по устройствам
+	AnalyticsShards int
+	// Experiment A/B-сравнение двух конфигураций детектора
+	Experiment ExperimentConfig
+	// NodeID идентификатор экземпляра в глобальных счетчиках (по умолчанию имя хоста)
+	NodeID string
+	// Schedule расписания фоновых задач (scheduler.Defaults с учетом SCHEDULE)
+	Schedule map[string]string
+	// SchedulerJitter верхняя граница случайной задержки запуска задач
+	SchedulerJitter time.Duration
+	// SchedulerLeaderTTL время жизни блокировки лидера планировщика
+	SchedulerLeaderTTL time.Duration
+	// Migrations миграции хранилищ при запуске
+	Migrations MigrationsConfig
+	// Devices реестр устройств с их группами (DEVICE_REGISTRY)
+	Devices []devices.Device
+	// DeviceState накопительные показатели устройств в хешах Redis
+	DeviceState DeviceStateConfig
+	// RollupFlushInterval период слияния агрегатов 1m/5m/1h с Redis;
+	// 0 — агрегаты хранятся только в памяти реплики
+	RollupFlushInterval time.Duration
+	// Histograms интервалы гистограмм входящих значений
+	Histograms metrics.HistogramConfig
+	// DeviceMetrics метрики Prometheus с меткой device
+	DeviceMetrics metrics.DeviceMetricsConfig
+	// ScoreWindow окно сводной оценки здоровья (/score)
+	ScoreWindow time.Duration
+	// ScoreExpectedInterval ожидаемый интервал между метриками устройства для штрафа за пропуски
+	ScoreExpectedInterval time.Duration
+	// Cadence SLA интервалов отчетности устройств (/cadence)
+	Cadence cadence.Config
+	// IncidentGap наибольший перерыв между оповещениями одного инцидента (/incidents)
+	IncidentGap time.Duration
+	// ResultTTL сроки хранения результатов анализа: обычных, аномальных и
+	// закрепленных незакрытыми аномалиями
+	ResultTTL cache.ResultTTL
+	// AnomalyEventsMaxLen примерное количество хранимых событий аномалий
+	// (/anomalies/events); 0 — события не записываются
+	AnomalyEventsMaxLen int
+	// OutboxWebhooks получатели событий об аномалиях: имя → URL (OUTBOX_WEBHOOKS)
+	OutboxWebhooks map[string]string
+	// OutboxTemplates шаблоны тела запроса по получателям (OUTBOX_TEMPLATES)
+	OutboxTemplates map[string]*outbox.Template
+	// OutboxFormats форматы тела запроса по получателям: json или alertmanager (OUTBOX_FORMATS)
+	OutboxFormats map[string]string
+	// OutboxRetryAfter через сколько недоставленное событие отправляется повторно
+	OutboxRetryAfter time.Duration
+	// OutboxRoutes правила, какие получатели получают какие события (OUTBOX_ROUTES)
+	OutboxRoutes outbox.Routes
+	// OutboxRoutesRedisKey ключ Redis с маршрутами, заменяющими OutboxRoutes без перезапуска
+	OutboxRoutesRedisKey string
+	// OutboxRoutesRefreshInterval период перечитывания маршрутов из Redis
+	OutboxRoutesRefreshInterval time.Duration
+	// OutboxCriticalZScore |z|, начиная с которого аномалия считается критической в маршрутах
+	OutboxCriticalZScore float64
+	// DLQFile файл очереди недоставленных метрик; пустое значение — поток Redis
+	DLQFile string
+	// Encryption ключи шифрования данных в Redis и файлах очередей; nil — без шифрования
+	Encryption *encryption.Keyring
+	// PayloadMappings правила перевода JSON устройств сторонних моделей; nil — выключено
+	PayloadMappings *payload.Mappings
+	// Listeners точки приема запросов (LISTENERS); по умолчанию одна TCP на SERVER_ADDR
+	Listeners []listeners.Config
+	// HandoverEnabled по SIGUSR2 процесс передает сокеты и окна анализатора новому экземпляру
+	HandoverEnabled bool
+	// HandoverTimeout сколько старый процесс ждет готовности нового, а новый — окон старого
+	HandoverTimeout time.Duration
+	// StreamAddr адрес gRPC-сервера потоков устройств; пустое значение отключает его
+	StreamAddr string
+	// StreamReportingInterval интервал отправки метрик, который получают устройства без своих настроек
+	StreamReportingInterval time.Duration
+	// GRPCAddr адрес gRPC-сервиса приема и запросов highload.v1.Metrics; пустое значение отключает его
+	GRPCAddr string
+	// GRPCTLSCert и GRPCTLSKey включают TLS на порту GRPCAddr
+	GRPCTLSCert string
+	GRPCTLSKey  string
+	// Backpressure границы заполнения буферов, при которых прием из потоков
+	// приостанавливается, а HTTP-прием отвечает 429
+	Backpressure backpressure.Config
+	// BackpressureRetryAfter значение Retry-After ответа 429 при переполненной очереди
+	BackpressureRetryAfter time.Duration
+	// Health пороги заполнения очереди анализатора для статуса /health
+	Health health.Config
+	// ImportSQL источник импорта исторических метрик; пустой DSN отключает импорт
+	ImportSQL ImportSQLConfig
+	// Journal журнал результатов анализа; пустой Backend отключает его
+	Journal JournalConfig
+	// Admission маркерная корзина приема метрик в анализ; нулевая скорость отключает ее
+	Admission admission.Config
+	// BatchDedupWindow сколько помнятся хеши пакетов для отбрасывания повторов; 0 отключает
+	BatchDedupWindow time.Duration
+	// Quantiles скетчи t-digest для /quantiles; нулевое Resolution отключает их
+	Quantiles analytics.QuantileConfig
+	// Sampling прореживание сохраняемых сырых метрик по тенантам (SAMPLING_POLICY)
+	Sampling sampling.Config
+	// Sequencing упорядочивание метрик устройств по номеру seq
+	Sequencing sequencer.Config
+}
+
+// JournalConfig настройки журнала результатов анализа
+type JournalConfig struct {
+	// Backend redis (поток Redis) или file (сегменты в каталоге Dir)
+	Backend string
+	Dir     string
+	// MaxLen примерное количество хранимых записей
+	MaxLen int
+	// SegmentSize количество записей в файле сегмента
+	SegmentSize int
+}
+
+// MigrationsConfig настройки миграций хранилищ при запуске
+type MigrationsConfig struct {
+	Enabled bool
+	// Timeout сколько ждать миграций, включая ожидание другой реплики
+	Timeout time.Duration
+	// LockTTL время жизни блокировки миграций
+	LockTTL time.Duration
+}
+
+// DeviceStateConfig настройки накопительных показателей устройств
+type DeviceStateConfig struct {
+	Enabled bool
+	// FlushInterval период записи накопленных изменений в Redis
+	FlushInterval time.Duration
+	// TTL срок жизни показателей устройства, переставшего присылать метрики
+	TTL time.Duration
+}
+
+// ImportSQLConfig настройки импорта исторических метрик из внешней SQL-базы
+type ImportSQLConfig struct {
+	// Driver имя драйвера database/sql, например postgres
+	Driver string
+	DSN    string
+	// Query запрос с параметрами начала и конца диапазона
+	Query string
+}
+
+// ExperimentConfig настройки A/B-сравнения конфигураций детектора
+type ExperimentConfig struct {
+	Enabled bool
+	A, B    analytics.DetectorConfig
+}
+
+// Load загружает конфигурацию из CONFIG_FILE и переменных окружения.
+// Некорректные значения приводят к ошибке, а не к молчаливой подстановке значения по умолчанию
+func Load() (Config, error) {
+	src, err := newSource(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{
+		ServerAddr:     src.String("SERVER_ADDR", ":8080"),
+		RedisAddr:      src.String("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:  src.String("REDIS_PASSWORD", ""),
+		RedisDB:        src.Int("REDIS_DB", 0),
+		WorkerCount:    src.Int("WORKER_COUNT", runtime.NumCPU()),
+		BufferSize:     src.Int("BUFFER_SIZE", 10000),
+		ReadTimeout:    src.Duration("READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:   src.Duration("WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:    src.Duration("IDLE_TIMEOUT", 60*time.Second),
+		DrainTimeout:   src.Duration("DRAIN_TIMEOUT", 30*time.Second),
+		WarmupDuration: src.Duration("WARMUP_DURATION", 30*time.Second),
+		Quota: quota.Limits{
+			Daily:         int64(src.Int("QUOTA_DAILY", 0)),
+			Rolling:       int64(src.Int("QUOTA_ROLLING", 0)),
+			RollingWindow: src.Duration("QUOTA_ROLLING_WINDOW", time.Minute),
+		},
+		Logging: middleware.LoggingConfig{
+			SampleRate:    src.Float("LOG_SAMPLE_RATE", 0.01),
+			SlowThreshold: src.Duration("SLOW_REQUEST_THRESHOLD", 50*time.Millisecond),
+		},
+		AccessLog: accesslog.Config{
+			Enabled:    src.Bool("ACCESS_LOG_ENABLED", false),
+			Format:     accesslog.Format(src.String("ACCESS_LOG_FORMAT", string(accesslog.FormatJSON))),
+			Output:     src.String("ACCESS_LOG_OUTPUT", "/var/log/highload/access.log"),
+			MaxSizeMB:  src.Int("ACCESS_LOG_MAX_SIZE_MB", 100),
+			MaxBackups: src.Int("ACCESS_LOG_MAX_BACKUPS", 5),
+			BufferSize: src.Int("ACCESS_LOG_BUFFER", 4096),
+		},
+		Profiler: profiler.Config{
+			Enabled:        src.Bool("PROFILE_CAPTURE_ENABLED", false),
+			Output:         src.String("PROFILE_OUTPUT", "/var/lib/highload/profiles"),
+			P99Threshold:   src.Duration("PROFILE_P99_THRESHOLD", 500*time.Millisecond),
+			QueueThreshold: src.Int("PROFILE_QUEUE_THRESHOLD", 0),
+			CheckInterval:  src.Duration("PROFILE_CHECK_INTERVAL", 10*time.Second),
+			CPUDuration:    src.Duration("PROFILE_CPU_DURATION", 10*time.Second),
+			Cooldown:       src.Duration("PROFILE_COOLDOWN", 5*time.Minute),
+			MaxFiles:       src.Int("PROFILE_MAX_FILES", 20),
+			S3: profiler.S3Config{
+				Region:          src.String("AWS_REGION", ""),
+				AccessKeyID:     src.String("AWS_ACCESS_KEY_ID", ""),
+				SecretAccessKey: src.String("AWS_SECRET_ACCESS_KEY", ""),
+				SessionToken:    src.String("AWS_SESSION_TOKEN", ""),
+				Endpoint:        src.String("S3_ENDPOINT", ""),
+			},
+		},
+	}
+
+	cfg.NodeID = src.String("NODE_ID", counters.DefaultNodeID())
+	cfg.AdminToken = src.String("ADMIN_TOKEN", "")
+	cfg.AuditLogOutput = src.String("AUDIT_LOG_OUTPUT", accesslog.Stdout)
+	level, err := loglevel.Parse(src.String("LOG_LEVEL", loglevel.Info.String()))
+	if err != nil {
+		src.errs = append(src.errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	cfg.LogLevel = level
+
+	cfg.Detector = analytics.DetectorConfig{
+		WindowSize:      src.Int("DETECTOR_WINDOW_SIZE", analytics.WindowSize),
+		ZScoreThreshold: src.Float("DETECTOR_Z_THRESHOLD", analytics.ZScoreThreshold),
+		Smoothing:       src.String("ANALYTICS_SMOOTHING", analytics.SmoothingSMA),
+		Alpha:           src.Float("ANALYTICS_EWMA_ALPHA", 0),
+		Seasonality:     src.String("ANALYTICS_SEASONALITY", ""),
+		WindowSeconds:   src.Duration("ANALYTICS_WINDOW_DURATION", 0).Seconds(),
+		OpenAfter:       src.Int("ANOMALY_OPEN_AFTER", 1),
+		CloseAfter:      src.Int("ANOMALY_CLOSE_AFTER", 1),
+		WarmupFill:      src.Float("ANOMALY_WARMUP_FILL", 0.5),
+	}
+	if err := cfg.Detector.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("DETECTOR_*, ANALYTICS_*: %w", err))
+	}
+	deviceClasses, err := analytics.ParseDeviceClasses(src.String("DEVICE_CLASSES", ""))
+	if err != nil {
+		src.errs = append(src.errs, fmt.Errorf("DEVICE_CLASSES: %w", err))
+	}
+	if src.Bool("DEVICE_WINDOWS_ENABLED", true) {
+		cfg.DeviceWindows = &analytics.DeviceWindowsConfig{
+			IdleTTL:    src.Duration("DEVICE_WINDOWS_IDLE_TTL", analytics.DefaultDeviceIdleTTL),
+			MaxDevices: src.Int("DEVICE_WINDOWS_MAX_DEVICES", analytics.DefaultMaxDeviceWindows),
+			MaxMemory:  int64(src.Int("DEVICE_WINDOWS_MAX_MEMORY_MB", analytics.DefaultMaxDeviceWindowMemory>>20)) << 20,
+			EvictAfter: src.Duration("DEVICE_WINDOWS_EVICT_AFTER", analytics.DefaultDeviceEvictAfter),
+			Classes:    deviceClasses,
+		}
+		if err := cfg.DeviceWindows.Validate(); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("DEVICE_WINDOWS_*, DEVICE_CLASSES: %w", err))
+		}
+	} else if len(deviceClasses) > 0 {
+		// Параметры класса действуют на окна устройства
+		src.errs = append(src.errs, fmt.Errorf("DEVICE_CLASSES requires DEVICE_WINDOWS_ENABLED=true"))
+	}
+	cfg.CounterFields = src.Strings("COUNTER_FIELDS", nil)
+	if err := analytics.ValidateCounterFields(cfg.CounterFields); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("COUNTER_FIELDS: %w", err))
+	}
+	cfg.AnalyticsShards = src.Int("ANALYTICS_SHARDS", 1)
+	if cfg.AnalyticsShards < 1 || cfg.AnalyticsShards > analytics.MaxShards {
+		src.errs = append(src.errs, fmt.Errorf("ANALYTICS_SHARDS: must be within [1, %d], got %d", analytics.MaxShards, cfg.AnalyticsShards))
+	} else if cfg.AnalyticsShards > 1 && cfg.DeviceWindows == nil {
+		// Без окон устройств шарды делили бы общие окна, и z-score считался бы по части потока
+		src.errs = append(src.errs, fmt.Errorf("ANALYTICS_SHARDS > 1 requires DEVICE_WINDOWS_ENABLED=true"))
+	}
+	// По умолчанию прогрев длится одно окно основного детектора
+	cfg.WarmupSamples = src.Int("WARMUP_SAMPLES", cfg.Detector.WindowSize)
+	// Доступность учитывается по минутам: при проверке реже раза в минуту готовая реплика пропускала бы минуты
+	cfg.AvailabilityInterval = src.Duration("AVAILABILITY_INTERVAL", availability.DefaultInterval)
+	if cfg.AvailabilityInterval <= 0 || cfg.AvailabilityInterval >= time.Minute {
+		src.errs = append(src.errs, fmt.Errorf("AVAILABILITY_INTERVAL: must be within (0, 1m), got %s", cfg.AvailabilityInterval))
+	}
+
+	// Конфигурация A по умолчанию совпадает с основной, B отличается окном
+	cfg.Experiment = ExperimentConfig{
+		Enabled: src.Bool("EXPERIMENT_ENABLED", false),
+		A: analytics.DetectorConfig{
+			WindowSize:      src.Int("EXPERIMENT_A_WINDOW_SIZE", cfg.Detector.WindowSize),
+			ZScoreThreshold: src.Float("EXPERIMENT_A_Z_THRESHOLD", cfg.Detector.ZScoreThreshold),
+			Smoothing:       cfg.Detector.Smoothing,
+			Alpha:           cfg.Detector.Alpha,
+			Seasonality:     cfg.Detector.Seasonality,
+			WindowSeconds:   cfg.Detector.WindowSeconds,
+			OpenAfter:       cfg.Detector.OpenAfter,
+			CloseAfter:      cfg.Detector.CloseAfter,
+			WarmupFill:      cfg.Detector.WarmupFill,
+		},
+		B: analytics.DetectorConfig{
+			WindowSize:      src.Int("EXPERIMENT_B_WINDOW_SIZE", 200),
+			ZScoreThreshold: src.Float("EXPERIMENT_B_Z_THRESHOLD", cfg.Detector.ZScoreThreshold),
+			Smoothing:       cfg.Detector.Smoothing,
+			Alpha:           cfg.Detector.Alpha,
+			Seasonality:     cfg.Detector.Seasonality,
+			WindowSeconds:   cfg.Detector.WindowSeconds,
+			OpenAfter:       cfg.Detector.OpenAfter,
+			CloseAfter:      cfg.Detector.CloseAfter,
+			WarmupFill:      cfg.Detector.WarmupFill,
+		},
+	}
+	if cfg.Experiment.Enabled {
+		if err := cfg.Experiment.A.Validate(); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("EXPERIMENT_A_*: %w", err))
+		}
+		if err := cfg.Experiment.B.Validate(); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("EXPERIMENT_B_*: %w", err))
+		}
+	}
+
+	cfg.Flags = flags.Defaults()
+	configured, err := flags.Parse(src.String("FEATURE_FLAGS", ""))
+	if err != nil {
+		src.errs = append(src.errs, fmt.Errorf("FEATURE_FLAGS: %w", err))
+	}
+	for name, f := range configured {
+		cfg.Flags[name] = f
+	}
+	cfg.FlagsRedisKey = src.String("FLAGS_REDIS_KEY", flags.DefaultRedisKey)
+	cfg.FlagsRefreshInterval = src.Duration("FLAGS_REFRESH_INTERVAL", 15*time.Second)
+
+	if cfg.ConcurrencyLimits, err = middleware.ParseConcurrencyLimits(src.String("CONCURRENCY_LIMITS", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("CONCURRENCY_LIMITS: %w", err))
+	}
+	cfg.ConcurrencyRetryAfter = src.Duration("CONCURRENCY_RETRY_AFTER", time.Second)
+
+	cfg.Middleware = middleware.DefaultChain
+	if raw, ok := src.lookup("MIDDLEWARE"); ok {
+		if cfg.Middleware, err = middleware.ParseChain(raw); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("MIDDLEWARE: %w", err))
+		}
+	}
+	cfg.CORS = middleware.CORSConfig{
+		AllowedOrigins: src.Strings("CORS_ALLOWED_ORIGINS", []string{"*"}),
+		AllowedMethods: src.Strings("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+		AllowedHeaders: src.Strings("CORS_ALLOWED_HEADERS",
+			[]string{"Content-Type", "Content-Encoding", "Authorization", "Last-Event-ID", quota.APIKeyHeader, payload.ModelHeader}),
+		MaxAge: src.Duration("CORS_MAX_AGE", 10*time.Minute),
+	}
+	cfg.APIKeys = src.Strings("API_KEYS", nil)
+	cfg.AuthExemptPaths = src.Strings("AUTH_EXEMPT_PATHS", []string{"/health", "/readyz", "/prometheus", "/openapi.json", "/admin/"})
+	if slices.Contains(cfg.Middleware, middleware.NameAuth) && len(cfg.APIKeys) == 0 {
+		src.errs = append(src.errs, fmt.Errorf("API_KEYS: must be set when MIDDLEWARE includes %s", middleware.NameAuth))
+	}
+
+	cfg.Schedule = scheduler.Defaults()
+	schedule, err := scheduler.ParseSchedule(src.String("SCHEDULE", ""))
+	if err != nil {
+		src.errs = append(src.errs, fmt.Errorf("SCHEDULE: %w", err))
+	}
+	for name, spec := range schedule {
+		cfg.Schedule[name] = spec
+	}
+	if cfg.Devices, err = devices.Parse(src.String("DEVICE_REGISTRY", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("DEVICE_REGISTRY: %w", err))
+	}
+
+	defaultHistograms := metrics.DefaultHistogramConfig()
+	cfg.Histograms = metrics.HistogramConfig{
+		CPUBuckets: src.Floats("HISTOGRAM_CPU_BUCKETS", defaultHistograms.CPUBuckets),
+		RPSBuckets: src.Floats("HISTOGRAM_RPS_BUCKETS", defaultHistograms.RPSBuckets),
+		PerRegion:  src.Bool("HISTOGRAM_PER_REGION", false),
+	}
+	if err := cfg.Histograms.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("HISTOGRAM_*: %w", err))
+	}
+	cfg.DeviceMetrics = metrics.DeviceMetricsConfig{
+		Enabled:    src.Bool("DEVICE_METRICS_ENABLED", false),
+		MaxDevices: src.Int("DEVICE_METRICS_MAX_DEVICES", metrics.DefaultMaxDevices),
+	}
+	if err := cfg.DeviceMetrics.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("DEVICE_METRICS_MAX_DEVICES: %w", err))
+	}
+
+	if cfg.OutboxWebhooks, err = outbox.ParseWebhooks(src.String("OUTBOX_WEBHOOKS", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("OUTBOX_WEBHOOKS: %w", err))
+	}
+	if cfg.OutboxTemplates, err = outbox.ParseTemplates(src.String("OUTBOX_TEMPLATES", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("OUTBOX_TEMPLATES: %w", err))
+	}
+	for name := range cfg.OutboxTemplates {
+		if _, ok := cfg.OutboxWebhooks[name]; !ok {
+			src.errs = append(src.errs, fmt.Errorf("OUTBOX_TEMPLATES: template for unknown webhook %q", name))
+		}
+	}
+	if cfg.OutboxFormats, err = outbox.ParseFormats(src.String("OUTBOX_FORMATS", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("OUTBOX_FORMATS: %w", err))
+	}
+	for name, format := range cfg.OutboxFormats {
+		if _, ok := cfg.OutboxWebhooks[name]; !ok {
+			src.errs = append(src.errs, fmt.Errorf("OUTBOX_FORMATS: format for unknown webhook %q", name))
+		} else if _, ok := cfg.OutboxTemplates[name]; ok && format == outbox.FormatAlertmanager {
+			src.errs = append(src.errs, fmt.Errorf("OUTBOX_FORMATS: webhook %q has a template and cannot use the alertmanager format", name))
+		}
+	}
+	cfg.OutboxRetryAfter = src.Duration("OUTBOX_RETRY_AFTER", outbox.DefaultRetryAfter)
+	if cfg.OutboxRoutes, err = outbox.ParseRoutes(src.String("OUTBOX_ROUTES", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("OUTBOX_ROUTES: %w", err))
+	}
+	cfg.OutboxRoutesRedisKey = src.String("OUTBOX_ROUTES_REDIS_KEY", outbox.DefaultRoutesRedisKey)
+	cfg.OutboxRoutesRefreshInterval = src.Duration("OUTBOX_ROUTES_REFRESH_INTERVAL", 15*time.Second)
+	cfg.OutboxCriticalZScore = src.Float("OUTBOX_CRITICAL_Z_SCORE", outbox.DefaultCriticalZScore)
+	if cfg.OutboxRoutesRefreshInterval <= 0 || !(cfg.OutboxCriticalZScore > 0) {
+		src.errs = append(src.errs, fmt.Errorf("OUTBOX_ROUTES_REFRESH_INTERVAL and OUTBOX_CRITICAL_Z_SCORE must be positive"))
+	}
+	cfg.DLQFile = src.String("DLQ_FILE", "")
+	if cfg.Encryption, err = encryption.LoadKeys(src.String("ENCRYPTION_KEYS", ""), src.String("ENCRYPTION_KEYS_FILE", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("ENCRYPTION_KEYS: %w", err))
+	}
+
+	if cfg.PayloadMappings, err = payload.Load(src.String("PAYLOAD_MAPPINGS", ""), src.String("PAYLOAD_MAPPINGS_FILE", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("PAYLOAD_MAPPINGS: %w", err))
+	}
+
+	if cfg.Listeners, err = listeners.Parse(src.String("LISTENERS", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("LISTENERS: %w", err))
+	}
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []listeners.Config{{Name: "default", Network: listeners.TCP, Address: cfg.ServerAddr}}
+	}
+	for i := range cfg.Listeners {
+		cfg.Listeners[i] = cfg.Listeners[i].WithTimeouts(cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+	cfg.HandoverEnabled = src.Bool("HANDOVER_ENABLED", false)
+	// Новый процесс ждет окна, пока старый дорабатывает очередь, поэтому по умолчанию бюджет с запасом
+	cfg.HandoverTimeout = src.Duration("HANDOVER_TIMEOUT", cfg.DrainTimeout+30*time.Second)
+	if cfg.HandoverTimeout <= 0 {
+		src.errs = append(src.errs, fmt.Errorf("HANDOVER_TIMEOUT must be positive"))
+	}
+
+	cfg.ScoreWindow = src.Duration("SCORE_WINDOW", score.DefaultWindow)
+	cfg.ScoreExpectedInterval = src.Duration("SCORE_EXPECTED_INTERVAL", score.DefaultExpectedInterval)
+	if cfg.ScoreWindow <= 0 || cfg.ScoreExpectedInterval <= 0 {
+		src.errs = append(src.errs, fmt.Errorf("SCORE_WINDOW and SCORE_EXPECTED_INTERVAL must be positive"))
+	}
+
+	cfg.Cadence = cadence.Config{
+		Enabled:          src.Bool("CADENCE_ENABLED", true),
+		ExpectedInterval: src.Duration("CADENCE_EXPECTED_INTERVAL", cadence.DefaultExpectedInterval),
+		Tolerance:        src.Float("CADENCE_TOLERANCE", cadence.DefaultTolerance),
+		MaxDevices:       src.Int("CADENCE_MAX_DEVICES", cadence.DefaultMaxDevices),
+	}
+	if cfg.Cadence.Enabled {
+		if err := cfg.Cadence.Validate(); err != nil {
+			src.errs = append(src.errs, err)
+		}
+	}
+
+	cfg.IncidentGap = src.Duration("INCIDENT_GAP", incidents.DefaultGap)
+	if cfg.IncidentGap <= 0 {
+		src.errs = append(src.errs, fmt.Errorf("INCIDENT_GAP must be positive"))
+	}
+
+	resultTTL := cache.DefaultResultTTL()
+	cfg.ResultTTL = cache.ResultTTL{
+		Normal:  src.Duration("RESULT_TTL", resultTTL.Normal),
+		Anomaly: src.Duration("ANOMALY_RESULT_TTL", resultTTL.Anomaly),
+		Pinned:  src.Duration("RESULT_PIN_TTL", resultTTL.Pinned),
+	}
+	if err := cfg.ResultTTL.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("RESULT_TTL, ANOMALY_RESULT_TTL, RESULT_PIN_TTL: %w", err))
+	}
+
+	cfg.AnomalyEventsMaxLen = src.Int("ANOMALY_EVENTS_MAX_LEN", anomalies.DefaultEventMaxLen)
+	if cfg.AnomalyEventsMaxLen < 0 {
+		src.errs = append(src.errs, fmt.Errorf("ANOMALY_EVENTS_MAX_LEN must not be negative"))
+	}
+
+	cfg.StreamAddr = src.String("STREAM_ADDR", "")
+	cfg.StreamReportingInterval = src.Duration("STREAM_REPORTING_INTERVAL", 10*time.Second)
+	if cfg.StreamReportingInterval < time.Millisecond {
+		src.errs = append(src.errs, fmt.Errorf("STREAM_REPORTING_INTERVAL must be at least 1ms"))
+	}
+	cfg.GRPCAddr = src.String("GRPC_ADDR", "")
+	if cfg.GRPCAddr != "" && cfg.GRPCAddr == cfg.StreamAddr {
+		// Серверы используют разные кодеки и не делят порт
+		src.errs = append(src.errs, fmt.Errorf("GRPC_ADDR must differ from STREAM_ADDR, both are %q", cfg.GRPCAddr))
+	}
+	cfg.GRPCTLSCert = src.String("GRPC_TLS_CERT", "")
+	cfg.GRPCTLSKey = src.String("GRPC_TLS_KEY", "")
+	if (cfg.GRPCTLSCert == "") != (cfg.GRPCTLSKey == "") {
+		src.errs = append(src.errs, fmt.Errorf("GRPC_TLS_CERT and GRPC_TLS_KEY must be set together"))
+	}
+
+	cfg.ImportSQL = ImportSQLConfig{
+		Driver: src.String("IMPORT_SQL_DRIVER", "postgres"),
+		DSN:    src.String("IMPORT_SQL_DSN", ""),
+		Query: src.String("IMPORT_SQL_QUERY",
+			"SELECT ts AS timestamp, device_id, cpu, rps FROM metrics WHERE ts BETWEEN $1 AND $2 ORDER BY ts"),
+	}
+	if cfg.ImportSQL.DSN != "" && (cfg.ImportSQL.Driver == "" || cfg.ImportSQL.Query == "") {
+		src.errs = append(src.errs, fmt.Errorf("IMPORT_SQL_DRIVER and IMPORT_SQL_QUERY are required with IMPORT_SQL_DSN"))
+	}
+
+	cfg.Journal = JournalConfig{
+		Backend:     src.String("JOURNAL_BACKEND", ""),
+		Dir:         src.String("JOURNAL_DIR", ""),
+		MaxLen:      src.Int("JOURNAL_MAX_LEN", journal.DefaultMaxLen),
+		SegmentSize: src.Int("JOURNAL_SEGMENT_SIZE", journal.DefaultSegmentSize),
+	}
+	switch {
+	case cfg.Journal.Backend != "" && cfg.Journal.Backend != "redis" && cfg.Journal.Backend != "file":
+		src.errs = append(src.errs, fmt.Errorf("JOURNAL_BACKEND must be redis or file, got %q", cfg.Journal.Backend))
+	case cfg.Journal.Backend == "file" && cfg.Journal.Dir == "":
+		src.errs = append(src.errs, fmt.Errorf("JOURNAL_DIR is required with JOURNAL_BACKEND=file"))
+	case cfg.Journal.MaxLen < 1 || cfg.Journal.SegmentSize < 1:
+		src.errs = append(src.errs, fmt.Errorf("JOURNAL_MAX_LEN and JOURNAL_SEGMENT_SIZE must be positive"))
+	}
+
+	cfg.BatchDedupWindow = src.Duration("BATCH_DEDUP_WINDOW", 0)
+	if cfg.BatchDedupWindow < 0 {
+		src.errs = append(src.errs, fmt.Errorf("BATCH_DEDUP_WINDOW must not be negative"))
+	}
+
+	if src.Bool("QUANTILES_ENABLED", true) {
+		def := analytics.DefaultQuantileConfig()
+		cfg.Quantiles = analytics.QuantileConfig{
+			Resolution:  src.Duration("QUANTILES_RESOLUTION", def.Resolution),
+			Retention:   src.Duration("QUANTILES_RETENTION", def.Retention),
+			Compression: src.Float("QUANTILES_COMPRESSION", def.Compression),
+		}
+		if err := cfg.Quantiles.Validate(); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("QUANTILES_*: %w", err))
+		}
+	}
+
+	if cfg.Sampling, err = sampling.ParseConfig(src.String("SAMPLING_POLICY", "")); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("SAMPLING_POLICY: %w", err))
+	}
+
+	seqDefaults := sequencer.DefaultConfig()
+	cfg.Sequencing = sequencer.Config{
+		Mode:    src.String("SEQUENCING_MODE", seqDefaults.Mode),
+		Buffer:  src.Int("SEQUENCING_BUFFER", seqDefaults.Buffer),
+		MaxWait: src.Duration("SEQUENCING_MAX_WAIT", seqDefaults.MaxWait),
+	}
+	if err := cfg.Sequencing.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("SEQUENCING_*: %w", err))
+	}
+
+	// По умолчанию корзина вмещает секунду приема
+	cfg.Admission = admission.Config{Rate: src.Float("ADMISSION_RATE", 0)}
+	cfg.Admission.Burst = src.Int("ADMISSION_BURST", int(math.Ceil(cfg.Admission.Rate)))
+	cfg.Admission.MaxWait = src.Duration("ADMISSION_MAX_WAIT", admission.DefaultMaxWait)
+	cfg.Admission.Shared = src.Bool("ADMISSION_SHARED", false)
+	if err := cfg.Admission.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("ADMISSION_*: %w", err))
+	}
+
+	cfg.Backpressure = backpressure.Config{
+		HighWater: src.Float("BACKPRESSURE_HIGH_WATER", backpressure.DefaultHighWater),
+		LowWater:  src.Float("BACKPRESSURE_LOW_WATER", backpressure.DefaultLowWater),
+	}
+	if err := cfg.Backpressure.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("BACKPRESSURE_*: %w", err))
+	}
+	cfg.BackpressureRetryAfter = src.Duration("BACKPRESSURE_RETRY_AFTER", time.Second)
+
+	cfg.Health = health.Config{
+		QueueDegraded:  src.Float("HEALTH_QUEUE_DEGRADED", health.DefaultQueueDegraded),
+		QueueUnhealthy: src.Float("HEALTH_QUEUE_UNHEALTHY", health.DefaultQueueUnhealthy),
+	}
+	if err := cfg.Health.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("HEALTH_*: %w", err))
+	}
+
+	cfg.SchedulerJitter = src.Duration("SCHEDULER_JITTER", 30*time.Second)
+	cfg.SchedulerLeaderTTL = src.Duration("SCHEDULER_LEADER_TTL", 30*time.Second)
+	if cfg.SchedulerLeaderTTL < 3*time.Second {
+		src.errs = append(src.errs, fmt.Errorf("SCHEDULER_LEADER_TTL must be at least 3s"))
+	}
+
+	cfg.DeviceState = DeviceStateConfig{
+		Enabled:       src.Bool("DEVICE_STATE_ENABLED", true),
+		FlushInterval: src.Duration("DEVICE_STATE_FLUSH_INTERVAL", devicestate.DefaultFlushInterval),
+		TTL:           src.Duration("DEVICE_STATE_TTL", devicestate.DefaultTTL),
+	}
+	if cfg.DeviceState.FlushInterval <= 0 || cfg.DeviceState.TTL < 0 {
+		src.errs = append(src.errs, fmt.Errorf("DEVICE_STATE_FLUSH_INTERVAL must be positive and DEVICE_STATE_TTL not negative"))
+	}
+
+	cfg.RollupFlushInterval = src.Duration("ROLLUP_FLUSH_INTERVAL", rollup.DefaultFlushInterval)
+	if cfg.RollupFlushInterval < 0 {
+		src.errs = append(src.errs, fmt.Errorf("ROLLUP_FLUSH_INTERVAL must not be negative"))
+	}
+
+	cfg.Migrations = MigrationsConfig{
+		Enabled: src.Bool("MIGRATIONS_ENABLED", true),
+		Timeout: src.Duration("MIGRATIONS_TIMEOUT", 5*time.Minute),
+		LockTTL: src.Duration("MIGRATIONS_LOCK_TTL", migrations.DefaultLockTTL),
+	}
+	if cfg.Migrations.Timeout <= 0 || cfg.Migrations.LockTTL < 3*time.Second {
+		src.errs = append(src.errs, fmt.Errorf("MIGRATIONS_TIMEOUT must be positive and MIGRATIONS_LOCK_TTL at least 3s"))
+	}
+
+	// По умолчанию профили снимаются при заполнении очереди на 80%
+	if cfg.Profiler.QueueThreshold == 0 {
+		cfg.Profiler.QueueThreshold = cfg.BufferSize * 8 / 10
+	}
+
+	if cfg.Logging.SampleRate > 1 {
+		src.errs = append(src.errs, fmt.Errorf("LOG_SAMPLE_RATE must be within [0, 1]"))
+	}
+	if cfg.AccessLog.Enabled {
+		if err := cfg.AccessLog.Validate(); err != nil {
+			src.errs = append(src.errs, err)
+		}
+	}
+
+	if cfg.Profiler.Enabled {
+		if err := cfg.Profiler.Validate(); err != nil {
+			src.errs = append(src.errs, err)
+		}
+	}
+
+	cfg.Autoscale = autoscale.Config{
+		Enabled:              src.Bool("AUTOSCALE_ENABLED", true),
+		Min:                  src.Int("WORKER_MIN", 1),
+		Max:                  src.Int("WORKER_MAX", min(4*runtime.NumCPU(), admin.MaxWorkers)),
+		Interval:             src.Duration("AUTOSCALE_INTERVAL", 5*time.Second),
+		ScaleUpBacklog:       src.Float("AUTOSCALE_BACKLOG", 0.25),
+		ScaleUpUtilization:   src.Float("AUTOSCALE_UP_UTILIZATION", 0.8),
+		ScaleDownUtilization: src.Float("AUTOSCALE_DOWN_UTILIZATION", 0.3),
+		MaxLatency:           src.Duration("AUTOSCALE_MAX_LATENCY", 0),
+		Cooldown:             src.Duration("AUTOSCALE_COOLDOWN", 30*time.Second),
+	}
+	if cfg.Autoscale.Enabled {
+		if err := cfg.Autoscale.Validate(admin.MaxWorkers); err != nil {
+			src.errs = append(src.errs, err)
+		} else {
+			cfg.WorkerCount = cfg.Autoscale.Clamp(cfg.WorkerCount)
+		}
+	}
+
+	cfg.Watchdog = watchdog.Config{
+		Enabled:        src.Bool("WATCHDOG_ENABLED", true),
+		Interval:       src.Duration("WATCHDOG_INTERVAL", 5*time.Second),
+		StuckAfter:     src.Duration("WATCHDOG_STUCK_AFTER", 30*time.Second),
+		GoroutineSlack: src.Int("WATCHDOG_GOROUTINE_SLACK", 1000),
+	}
+	if cfg.Watchdog.Enabled {
+		if err := cfg.Watchdog.Validate(); err != nil {
+			src.errs = append(src.errs, err)
+		}
+	}
+
+	cfg.SelfMonitor = selfmon.Config{
+		Enabled:  src.Bool("SELF_MONITOR_ENABLED", false),
+		Interval: src.Duration("SELF_MONITOR_INTERVAL", selfmon.DefaultInterval),
+		Device:   src.String("SELF_MONITOR_DEVICE", selfmon.DefaultDevice),
+	}
+	if err := cfg.SelfMonitor.Validate(); err != nil {
+		src.errs = append(src.errs, fmt.Errorf("SELF_MONITOR_*: %w", err))
+	}
+
+	return cfg, errors.Join(src.errs...)
+}
+
+// source объединяет файл конфигурации и окружение, накапливая ошибки разбора
+type source struct {
+	file map[string]string
+	errs []error
+}
+
+func newSource(path string) (*source, error) {
+	s := &source{file: map[string]string{}}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			s.file[k] = v
+		case map[string]interface{}, []interface{}:
+			// Вложенные объекты (например, FEATURE_FLAGS) передаются как JSON
+			data, _ := json.Marshal(v)
+			s.file[k] = string(data)
+		default:
+			// Числа и логические значения допускаются без кавычек
+			s.file[k] = strings.Trim(fmt.Sprint(v), " ")
+		}
+	}
+	return s, nil
+}
+
+// lookup возвращает значение ключа: окружение имеет приоритет над файлом
+func (s *source) lookup(key string) (string, bool) {
+	if v := os.Getenv(key); v != "" {
+		return v, true
+	}
+	v, ok := s.file[key]
+	return v, ok && v != ""
+}
+
+// String возвращает строковое значение
+func (s *source) String(key, def string) string {
+	if v, ok := s.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// Int возвращает неотрицательное целое значение
+func (s *source) Int(key string, def int) int {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		s.errs = append(s.errs, fmt.Errorf("%s: invalid non-negative integer %q", key, v))
+		return def
+	}
+	return n
+}
+
+// Float возвращает неотрицательное дробное значение
+func (s *source) Float(key string, def float64) float64 {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		s.errs = append(s.errs, fmt.Errorf("%s: invalid non-negative number %q", key, v))
+		return def
+	}
+	return f
+}
+
+// Floats возвращает список чисел через запятую ("10,50,90"); в файле допускается JSON-массив
+func (s *source) Floats(key string, def []float64) []float64 {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	parts := strings.Split(strings.Trim(v, "[] "), ",")
+	values := make([]float64, 0, len(parts))
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("%s: invalid number list %q", key, v))
+			return def
+		}
+		values = append(values, f)
+	}
+	return values
+}
+
+// Strings возвращает список строк через запятую или пробел; в файле допускается JSON-массив
+func (s *source) Strings(key string, def []string) []string {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	return strings.FieldsFunc(strings.Trim(v, "[]"), func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Duration возвращает положительную длительность (например, "30s")
+func (s *source) Duration(key string, def time.Duration) time.Duration {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		s.errs = append(s.errs, fmt.Errorf("%s: invalid duration %q", key, v))
+		return def
+	}
+	return d
+}
+
+// Bool возвращает логическое значение
+func (s *source) Bool(key string, def bool) bool {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s: invalid boolean %q", key, v))
+		return def
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"highload-service/internal/flags"
+	"highload-service/internal/middleware"
+)
+
+func TestLoad_FileOverriddenByEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"SERVER_ADDR": ":9090", "WORKER_COUNT": 8, "DRAIN_TIMEOUT": "10s", "ACCESS_LOG_ENABLED": true, "ACCESS_LOG_FORMAT": "clf", "FEATURE_FLAGS": {"batch_ingest": {"enabled": false, "tenants": ["beta"]}}, "HISTOGRAM_CPU_BUCKETS": [25, 50, 75]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("WORKER_COUNT", "2")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ServerAddr != ":9090" || cfg.DrainTimeout != 10*time.Second {
+		t.Errorf("Expected values from file, got addr=%s drain=%s", cfg.ServerAddr, cfg.DrainTimeout)
+	}
+	if cfg.WorkerCount != 2 {
+		t.Errorf("Expected env to override file, got WorkerCount=%d", cfg.WorkerCount)
+	}
+	if !cfg.AccessLog.Enabled || cfg.AccessLog.Format != "clf" {
+		t.Errorf("Expected CLF access log enabled, got %+v", cfg.AccessLog)
+	}
+	if f := cfg.Flags[flags.BatchIngest]; f.Enabled || len(f.Tenants) != 1 {
+		t.Errorf("Expected nested FEATURE_FLAGS object from file, got %+v", f)
+	}
+	if b := cfg.Histograms.CPUBuckets; len(b) != 3 || b[2] != 75 {
+		t.Errorf("Expected CPU histogram buckets from JSON array, got %v", b)
+	}
+}
+
+func TestLoad_ReportsAllInvalidValues(t *testing.T) {
+	t.Setenv("WORKER_COUNT", "many")
+	t.Setenv("DRAIN_TIMEOUT", "-5s")
+	t.Setenv("LOG_SAMPLE_RATE", "2")
+	t.Setenv("SCHEDULE", `{"stats.report": "61 * * * *"}`)
+	t.Setenv("HISTOGRAM_RPS_BUCKETS", "100,10")
+	t.Setenv("CADENCE_TOLERANCE", "0.5")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Expected configuration error")
+	}
+	for _, key := range []string{"WORKER_COUNT", "DRAIN_TIMEOUT", "LOG_SAMPLE_RATE", "SCHEDULE", "HISTOGRAM", "CADENCE_TOLERANCE"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected error to mention %s, got %v", key, err)
+		}
+	}
+}
+
+func TestLoad_MiddlewareChain(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Middleware, ",") != strings.Join(middleware.DefaultChain, ",") {
+		t.Errorf("Expected the default chain, got %v", cfg.Middleware)
+	}
+
+	t.Setenv("MIDDLEWARE", "tracing,cors,auth,logging")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "API_KEYS") {
+		t.Errorf("Expected auth without API_KEYS to be rejected, got %v", err)
+	}
+	t.Setenv("API_KEYS", "k1,k2")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Middleware) != 4 || cfg.Middleware[2] != middleware.NameAuth || len(cfg.APIKeys) != 2 || len(cfg.CORS.AllowedOrigins) != 2 {
+		t.Errorf("Unexpected middleware settings: %v %v %v", cfg.Middleware, cfg.APIKeys, cfg.CORS.AllowedOrigins)
+	}
+}
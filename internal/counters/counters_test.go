@@ -0,0 +1,45 @@
+package counters
+
+import (
+	"sync"
+	"testing"
+
+	"highload-service/internal/cache"
+)
+
+func TestCounters_MergedAcrossNodes(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	// Value written by a release that still used a single shared key
+	store.IncrementCounter(AnomaliesTotal)
+
+	eu := New(store, "eu-1")
+	us := New(store, "us-1")
+
+	var wg sync.WaitGroup
+	for _, c := range []*Counters{eu, us} {
+		wg.Add(1)
+		go func(c *Counters) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Inc(AnomaliesTotal)
+			}
+		}(c)
+	}
+	wg.Wait()
+	us.Add(AnomaliesTotal, 5)
+
+	for _, c := range []*Counters{eu, us} {
+		total, err := c.Total(AnomaliesTotal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 206 {
+			t.Errorf("node %s: expected global total 206, got %d", c.Node(), total)
+		}
+	}
+
+	nodes, _ := eu.Nodes(AnomaliesTotal)
+	if nodes["eu-1"] != 100 || nodes["us-1"] != 105 {
+		t.Errorf("Unexpected per-node breakdown %v", nodes)
+	}
+}
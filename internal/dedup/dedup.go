@@ -0,0 +1,88 @@
+// Package dedup распознает повторно присланные пакеты метрик.
+//
+// Шлюзы, агрессивно повторяющие отправку, присылают один и тот же пакет по
+// нескольку раз. Если каждый повтор проанализировать, скользящее среднее RPS
+// и окна детектора учтут одни и те же метрики многократно. Пакет узнается по
+// SHA-256 тела: первое появление хеша в окне Window занимает ключ Redis с
+// временем жизни Window (SET NX), а повторы, пришедшие на любую реплику,
+// находят ключ занятым. Пакет, который не удалось принять (429, 503),
+// освобождает ключ, иначе повтор шлюза был бы отброшен и пакет потерян
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultWindow сколько помнится хеш пакета
+	DefaultWindow = 5 * time.Minute
+	// KeyPrefix префикс ключей с хешами пакетов
+	KeyPrefix = "dedup:batch:"
+)
+
+// Locker занимает ключ key для owner на ttl и освобождает его (реализуется
+// cache.RedisCache и cache.MemoryCache); false — ключ занят другим владельцем
+type Locker interface {
+	AcquireLock(key, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(key, owner string) error
+}
+
+// Detector распознает повторы пакетов
+type Detector struct {
+	store  Locker
+	window time.Duration
+	node   string
+	seq    atomic.Uint64
+}
+
+// New создает Detector, помнящий пакеты в течение window. node отличает
+// владельцев ключей разных реплик
+func New(store Locker, window time.Duration, node string) *Detector {
+	return &Detector{store: store, window: window, node: node}
+}
+
+// NewHash возвращает хеш, в который пишется тело пакета
+func NewHash() hash.Hash {
+	return sha256.New()
+}
+
+// Claim хеш пакета, занятый первым появлением пакета
+type Claim struct {
+	d     *Detector
+	key   string
+	owner string
+}
+
+// Claim отмечает пакет с хешем sum и сообщает, встречался ли он в окне.
+// Новый пакет получает Claim, чтобы освободить хеш, если пакет не будет
+// принят. При ошибке хранилища пакет считается новым (fail-open): лишний
+// анализ лучше потерянных метрик
+func (d *Detector) Claim(sum []byte) (*Claim, bool) {
+	c := &Claim{d: d, key: KeyPrefix + hex.EncodeToString(sum), owner: d.node + ":" + strconv.FormatUint(d.seq.Add(1), 10)}
+	first, err := d.store.AcquireLock(c.key, c.owner, d.window)
+	if err != nil {
+		log.Printf("Batch deduplication check failed, accepting batch: %v", err)
+		return nil, false
+	}
+	if !first {
+		return nil, true
+	}
+	return c, false
+}
+
+// Release освобождает хеш непринятого пакета, чтобы его повтор был обработан.
+// Допускает nil
+func (c *Claim) Release() {
+	if c == nil {
+		return
+	}
+	if err := c.d.store.ReleaseLock(c.key, c.owner); err != nil {
+		log.Printf("Failed to release duplicate batch key: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package devices реестр устройств: принадлежность устройств группам (стойка, площадка, парк)
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device запись реестра
+type Device struct {
+	ID string `json:"id"`
+	// Groups группы устройства, например ["rack-12", "site-msk"]
+	Groups []string `json:"groups,omitempty"`
+	// Tenant владелец устройства; по нему маршрутизируются оповещения
+	Tenant string `json:"tenant,omitempty"`
+	// Class класс устройства ("gateway", "battery-sensor") со своими параметрами детектора
+	Class string `json:"class,omitempty"`
+	// Interval ожидаемый интервал между метриками устройства ("30s"); пустое
+	// значение — общий CADENCE_EXPECTED_INTERVAL
+	Interval string `json:"interval,omitempty"`
+}
+
+// Parse разбирает JSON-массив устройств (значение DEVICE_REGISTRY)
+func Parse(raw string) ([]Device, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var list []Device
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(list))
+	for i, d := range list {
+		if d.ID == "" {
+			return nil, fmt.Errorf("device #%d: id is required", i)
+		}
+		if seen[d.ID] {
+			return nil, fmt.Errorf("device %s is listed twice", d.ID)
+		}
+		seen[d.ID] = true
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Validate проверяет запись устройства
+func (d Device) Validate() error {
+	if d.ID == "" {
+		return fmt.Errorf("device id is required")
+	}
+	if d.Interval != "" {
+		if v, err := time.ParseDuration(d.Interval); err != nil || v <= 0 {
+			return fmt.Errorf("device %s: interval must be a positive duration, got %q", d.ID, d.Interval)
+		}
+	}
+	return nil
+}
+
+// Registry реестр устройств. Безопасен для конкурентного использования
+type Registry struct {
+	mu      sync.RWMutex
+	devices map[string]Device
+	members map[string]map[string]bool
+}
+
+// NewRegistry создает реестр с заданными устройствами
+func NewRegistry(list ...Device) *Registry {
+	r := &Registry{
+		devices: make(map[string]Device),
+		members: make(map[string]map[string]bool),
+	}
+	for _, d := range list {
+		r.Register(d)
+	}
+	return r
+}
+
+// Register добавляет устройство или заменяет его запись
+func (r *Registry) Register(d Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if old, ok := r.devices[d.ID]; ok {
+		r.leaveGroupsLocked(old)
+	}
+	d.Groups = append([]string(nil), d.Groups...)
+	r.devices[d.ID] = d
+	for _, g := range d.Groups {
+		if r.members[g] == nil {
+			r.members[g] = make(map[string]bool)
+		}
+		r.members[g][d.ID] = true
+	}
+}
+
+// leaveGroupsLocked убирает устройство из его групп; опустевшие группы удаляются
+func (r *Registry) leaveGroupsLocked(d Device) {
+	for _, g := range d.Groups {
+		delete(r.members[g], d.ID)
+		if len(r.members[g]) == 0 {
+			delete(r.members, g)
+		}
+	}
+}
+
+// Retire удаляет устройство из реестра и его групп; в ответе удаленная запись
+func (r *Registry) Retire(id string) (Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[id]
+	if !ok {
+		return Device{}, false
+	}
+	r.leaveGroupsLocked(d)
+	delete(r.devices, id)
+	return d, true
+}
+
+// Get возвращает запись устройства
+func (r *Registry) Get(id string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[id]
+	return d, ok
+}
+
+// ClassOf возвращает класс устройства; для незарегистрированного устройства — ""
+func (r *Registry) ClassOf(id string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.devices[id].Class
+}
+
+// IntervalOf возвращает ожидаемый интервал между метриками устройства; 0 —
+// устройство не зарегистрировано или интервал не задан
+func (r *Registry) IntervalOf(id string) time.Duration {
+	r.mu.RLock()
+	raw := r.devices[id].Interval
+	r.mu.RUnlock()
+	if raw == "" {
+		return 0
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return v
+}
+
+// GroupsOf возвращает группы устройства; для незарегистрированного устройства — nil
+func (r *Registry) GroupsOf(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.devices[id].Groups
+}
+
+// Members возвращает отсортированные идентификаторы устройств группы
+func (r *Registry) Members(group string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.members[group]))
+	for id := range r.members[group] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Groups возвращает отсортированные имена всех групп
+func (r *Registry) Groups() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.members))
+	for g := range r.members {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	return names
+}
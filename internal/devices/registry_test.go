@@ -0,0 +1,66 @@
+package devices
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry_ReRegisterMovesGroups(t *testing.T) {
+	list, err := Parse(`[{"id": "s1", "groups": ["rack-1"]}, {"id": "s2", "groups": ["rack-1", "site-msk"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(list...)
+
+	r.Register(Device{ID: "s1", Groups: []string{"rack-2"}})
+
+	if got := r.Members("rack-1"); !reflect.DeepEqual(got, []string{"s2"}) {
+		t.Errorf("Expected s1 to leave rack-1, got %v", got)
+	}
+	if got := r.Groups(); !reflect.DeepEqual(got, []string{"rack-1", "rack-2", "site-msk"}) {
+		t.Errorf("Unexpected groups %v", got)
+	}
+
+	for _, raw := range []string{`[{"groups": ["x"]}]`, `[{"id": "a"}, {"id": "a"}]`, `{}`,
+		`[{"id": "a", "interval": "soon"}]`, `[{"id": "a", "interval": "-5s"}]`} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestRegistry_IntervalOf(t *testing.T) {
+	list, err := Parse(`[{"id": "gw-1", "interval": "1m"}, {"id": "s1"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(list...)
+
+	if got := r.IntervalOf("gw-1"); got != time.Minute {
+		t.Errorf("Expected 1m for gw-1, got %s", got)
+	}
+	if got := r.IntervalOf("s1"); got != 0 {
+		t.Errorf("Expected no interval for s1, got %s", got)
+	}
+	if got := r.IntervalOf("missing"); got != 0 {
+		t.Errorf("Expected no interval for an unknown device, got %s", got)
+	}
+}
+
+func TestRegistry_RetireLeavesGroups(t *testing.T) {
+	r := NewRegistry(Device{ID: "s1", Groups: []string{"rack-1"}}, Device{ID: "s2", Groups: []string{"rack-1", "rack-2"}})
+
+	if d, ok := r.Retire("s2"); !ok || d.ID != "s2" {
+		t.Fatalf("Expected s2 to be retired, got %+v %v", d, ok)
+	}
+	if _, ok := r.Get("s2"); ok {
+		t.Error("Expected s2 to be gone")
+	}
+	if got := r.Groups(); !reflect.DeepEqual(got, []string{"rack-1"}) {
+		t.Errorf("Expected the emptied group to disappear, got %v", got)
+	}
+	if _, ok := r.Retire("s2"); ok {
+		t.Error("Expected retiring twice to report a missing device")
+	}
+}
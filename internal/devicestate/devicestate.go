@@ -0,0 +1,212 @@
+// Package devicestate накопительные показатели устройств в хешах Redis.
+//
+// Для каждого устройства хранится хеш device:state:<id>: количество метрик и
+// аномалий (HINCRBY, поэтому реплики не теряют обновлений друг друга), последние
+// значения и время последней аномалии. Результаты анализа накапливаются в
+// памяти и сбрасываются одним конвейером раз в FlushInterval, так что прием не
+// ждет Redis, а эндпоинт устройства читает один хеш вместо перебора метрик.
+// Последние значения пишет реплика, сбросившая их последней
+package devicestate
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/models"
+)
+
+const (
+	// KeyPrefix префикс хешей устройств
+	KeyPrefix = "device:state:"
+	// DefaultFlushInterval период сброса накопленных изменений
+	DefaultFlushInterval = time.Second
+	// DefaultTTL срок жизни хеша устройства, которое перестало присылать метрики
+	DefaultTTL = 30 * 24 * time.Hour
+)
+
+// Поля хеша устройства
+const (
+	fieldMetrics     = "metrics"
+	fieldAnomalies   = "anomalies"
+	fieldLastCPU     = "last_cpu"
+	fieldLastRPS     = "last_rps"
+	fieldLastSeen    = "last_seen"
+	fieldLastAnomaly = "last_anomaly_at"
+)
+
+// Store хранилище хешей (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	UpdateHashes(updates []cache.HashUpdate, ttl time.Duration) error
+	GetHash(key string) (map[string]string, error)
+}
+
+// pending изменения устройства, еще не записанные в хранилище
+type pending struct {
+	metrics     int64
+	anomalies   int64
+	last        models.Metric
+	lastAnomaly time.Time
+}
+
+// Tracker накапливает показатели устройств. Безопасен для конкурентного использования
+type Tracker struct {
+	store Store
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pending
+}
+
+// New создает Tracker; хеши устройств живут ttl после последнего сброса
+func New(store Store, ttl time.Duration) *Tracker {
+	return &Tracker{store: store, ttl: ttl, pending: make(map[string]*pending)}
+}
+
+// Observe учитывает результат анализа метрики устройства. Метрики без
+// устройства не учитываются
+func (t *Tracker) Observe(m models.Metric, r models.AnalysisResult) {
+	if m.DeviceID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[m.DeviceID]
+	if !ok {
+		p = &pending{}
+		t.pending[m.DeviceID] = p
+	}
+	p.merge(m, r)
+}
+
+func (p *pending) merge(m models.Metric, r models.AnalysisResult) {
+	p.metrics++
+	// Опоздавшая метрика учитывается в счетчике, но не заменяет последние значения
+	if !m.Timestamp.Before(p.last.Timestamp) {
+		p.last = m
+	}
+	if r.AnomalyDetected {
+		p.anomalies++
+		if m.Timestamp.After(p.lastAnomaly) {
+			p.lastAnomaly = m.Timestamp
+		}
+	}
+}
+
+// add возвращает изменения q в p (после неудачного сброса)
+func (p *pending) add(q *pending) {
+	p.metrics += q.metrics
+	p.anomalies += q.anomalies
+	if q.last.Timestamp.After(p.last.Timestamp) {
+		p.last = q.last
+	}
+	if q.lastAnomaly.After(p.lastAnomaly) {
+		p.lastAnomaly = q.lastAnomaly
+	}
+}
+
+// Flush записывает накопленные изменения. При ошибке изменения возвращаются
+// в накопитель и записываются следующим сбросом
+func (t *Tracker) Flush() error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[string]*pending, len(batch))
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	updates := make([]cache.HashUpdate, 0, len(batch))
+	for id, p := range batch {
+		u := cache.HashUpdate{
+			Key:  KeyPrefix + id,
+			Incr: map[string]int64{fieldMetrics: p.metrics},
+			Set: map[string]string{
+				fieldLastCPU:  strconv.FormatFloat(p.last.CPU, 'g', -1, 64),
+				fieldLastRPS:  strconv.FormatFloat(p.last.RPS, 'g', -1, 64),
+				fieldLastSeen: p.last.Timestamp.UTC().Format(time.RFC3339Nano),
+			},
+		}
+		if p.anomalies > 0 {
+			u.Incr[fieldAnomalies] = p.anomalies
+			u.Set[fieldLastAnomaly] = p.lastAnomaly.UTC().Format(time.RFC3339Nano)
+		}
+		updates = append(updates, u)
+	}
+
+	err := t.store.UpdateHashes(updates, t.ttl)
+	if err != nil {
+		t.mu.Lock()
+		for id, p := range batch {
+			if cur, ok := t.pending[id]; ok {
+				p.add(cur)
+			}
+			t.pending[id] = p
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// Run сбрасывает изменения каждые interval до отмены ctx, после чего
+// выполняет последний сброс
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush device state on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush device state: %v", err)
+			}
+		}
+	}
+}
+
+// Get возвращает показатели устройства id вместе с еще не сброшенными
+// изменениями этой реплики; false — устройство не присылало метрик
+func (t *Tracker) Get(id string) (models.DeviceState, bool, error) {
+	fields, err := t.store.GetHash(KeyPrefix + id)
+	if err != nil {
+		return models.DeviceState{}, false, err
+	}
+
+	var stored pending
+	stored.metrics, _ = strconv.ParseInt(fields[fieldMetrics], 10, 64)
+	stored.anomalies, _ = strconv.ParseInt(fields[fieldAnomalies], 10, 64)
+	stored.last.CPU, _ = strconv.ParseFloat(fields[fieldLastCPU], 64)
+	stored.last.RPS, _ = strconv.ParseFloat(fields[fieldLastRPS], 64)
+	stored.last.Timestamp, _ = time.Parse(time.RFC3339Nano, fields[fieldLastSeen])
+	stored.lastAnomaly, _ = time.Parse(time.RFC3339Nano, fields[fieldLastAnomaly])
+
+	t.mu.Lock()
+	if p, ok := t.pending[id]; ok {
+		stored.add(p)
+	}
+	t.mu.Unlock()
+	if stored.metrics == 0 {
+		return models.DeviceState{}, false, nil
+	}
+
+	state := models.DeviceState{
+		DeviceID:  id,
+		Metrics:   stored.metrics,
+		Anomalies: stored.anomalies,
+		LastCPU:   stored.last.CPU,
+		LastRPS:   stored.last.RPS,
+		LastSeen:  stored.last.Timestamp,
+	}
+	if !stored.lastAnomaly.IsZero() {
+		at := stored.lastAnomaly
+		state.LastAnomalyAt = &at
+	}
+	return state, true, nil
+}
@@ -0,0 +1,74 @@
+package devicestate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+// failingStore fails writes while broken is set
+type failingStore struct {
+	*cache.MemoryCache
+	broken bool
+}
+
+func (s *failingStore) UpdateHashes(updates []cache.HashUpdate, ttl time.Duration) error {
+	if s.broken {
+		return errors.New("redis unavailable")
+	}
+	return s.MemoryCache.UpdateHashes(updates, ttl)
+}
+
+func TestTracker_AggregatesAcrossReplicas(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store := &failingStore{MemoryCache: cache.NewMemoryCache(clk)}
+	replicas := []*Tracker{New(store, time.Hour), New(store, time.Hour)}
+
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	replicas[0].Observe(models.Metric{DeviceID: "sensor-1", Timestamp: at(1), CPU: 40, RPS: 100}, models.AnalysisResult{})
+	replicas[0].Observe(models.Metric{DeviceID: "sensor-1", Timestamp: at(3), CPU: 95, RPS: 100}, models.AnalysisResult{AnomalyDetected: true})
+	// A late metric is counted but does not replace the last values
+	replicas[0].Observe(models.Metric{DeviceID: "sensor-1", Timestamp: at(2), CPU: 41, RPS: 100}, models.AnalysisResult{})
+	replicas[1].Observe(models.Metric{DeviceID: "sensor-1", Timestamp: at(4), CPU: 42, RPS: 110}, models.AnalysisResult{})
+	replicas[1].Observe(models.Metric{CPU: 1}, models.AnalysisResult{})
+
+	// Unflushed changes of the replica are visible to its own readers
+	if s, ok, _ := replicas[0].Get("sensor-1"); !ok || s.Metrics != 3 || s.LastCPU != 95 {
+		t.Fatalf("Expected pending changes in the state, got %+v", s)
+	}
+
+	// A failed flush keeps the changes for the next one
+	store.broken = true
+	if err := replicas[0].Flush(); err == nil {
+		t.Fatal("Expected the flush to fail")
+	}
+	store.broken = false
+	for _, r := range replicas {
+		if err := r.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, ok, err := replicas[0].Get("sensor-1")
+	if err != nil || !ok {
+		t.Fatalf("Expected the device state, got %v", err)
+	}
+	if s.Metrics != 4 || s.Anomalies != 1 || s.LastCPU != 42 || s.LastRPS != 110 || !s.LastSeen.Equal(at(4)) ||
+		s.LastAnomalyAt == nil || !s.LastAnomalyAt.Equal(at(3)) {
+		t.Errorf("Unexpected merged state %+v", s)
+	}
+	if _, ok, _ := replicas[0].Get(""); ok {
+		t.Error("Expected metrics without a device not to be tracked")
+	}
+
+	// The state of a silent device expires with its hash
+	clk.Advance(2 * time.Hour)
+	if _, ok, _ := replicas[1].Get("sensor-1"); ok {
+		t.Error("Expected the state to expire after the TTL")
+	}
+}
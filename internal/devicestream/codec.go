@@ -0,0 +1,23 @@
+package devicestream
+
+import "highload-service/internal/codec"
+
+// Codec кодек gRPC, передающий сообщения потока в JSON. Сообщения — обычные
+// структуры Go, поэтому генерация кода из .proto не нужна. Клиенты на других
+// языках указывают content-subtype "json" (application/grpc+json)
+type Codec struct{}
+
+// Marshal кодирует сообщение
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return codec.JSON.Marshal(v)
+}
+
+// Unmarshal декодирует сообщение
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return codec.JSON.Unmarshal(data, v)
+}
+
+// Name имя кодека в content-subtype
+func (Codec) Name() string {
+	return codec.JSON.Name()
+}
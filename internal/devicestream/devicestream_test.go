@@ -0,0 +1,156 @@
+package devicestream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/models"
+)
+
+// fakeIngester flags CPU above 90 as an anomaly and records it in the tracker
+type fakeIngester struct {
+	tracker *anomalies.Tracker
+}
+
+func (f fakeIngester) Ingest(m models.Metric) (models.AnalysisResult, error) {
+	if err := m.Validate(); err != nil {
+		return models.AnalysisResult{}, err
+	}
+	result := models.AnalysisResult{Timestamp: m.Timestamp, AnomalyDetected: m.CPU > 90}
+	f.tracker.Record(m, result)
+	return result, nil
+}
+
+func startServer(t *testing.T) (*Hub, *anomalies.Tracker, *grpc.ClientConn) {
+	t.Helper()
+	hub := NewHub(NewDeviceConfig(10 * time.Second))
+	tracker := anomalies.NewTracker(anomalies.WithNotifier(hub.NotifyAnomaly), anomalies.WithTransitions(hub.NotifyAnomaly))
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(Codec{}))
+	NewServer(hub, fakeIngester{tracker}, tracker).Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return hub, tracker, cc
+}
+
+func recv(t *testing.T, s *Stream) ServerMessage {
+	t.Helper()
+	msg, err := s.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	return msg
+}
+
+func TestStream_ResultsConfigAndAcks(t *testing.T) {
+	hub, tracker, cc := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := Connect(ctx, cc, "sensor-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(t, stream); msg.Config == nil || msg.Config.ReportingIntervalMs != 10000 {
+		t.Fatalf("Expected the default config first, got %+v", msg)
+	}
+
+	// Normal metric: analysis result only
+	if err := stream.Send(DeviceMessage{Metric: &models.Metric{CPU: 40, RPS: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(t, stream); msg.Result == nil || msg.Result.AnomalyDetected {
+		t.Fatalf("Expected a normal result, got %+v", msg)
+	}
+	if n := len(tracker.List("")); n != 0 {
+		t.Fatalf("Expected no anomalies, got %d", n)
+	}
+
+	// Anomalous metric: result plus the opened anomaly of this device
+	if err := stream.Send(DeviceMessage{Metric: &models.Metric{CPU: 99, RPS: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	var opened *anomalies.Anomaly
+	for i := 0; i < 2; i++ {
+		if msg := recv(t, stream); msg.Anomaly != nil {
+			opened = msg.Anomaly
+		}
+	}
+	if opened == nil || opened.DeviceID != "sensor-1" || opened.State != anomalies.Open {
+		t.Fatalf("Expected an open anomaly of sensor-1, got %+v", opened)
+	}
+
+	// Config pushed by an operator arrives on the open stream
+	if n, err := hub.SetConfig("sensor-1", DeviceConfig{ReportingIntervalMs: 2500}); err != nil || n != 1 {
+		t.Fatalf("Expected config pushed to 1 stream, got %d (%v)", n, err)
+	}
+	if msg := recv(t, stream); msg.Config == nil || msg.Config.ReportingIntervalMs != 2500 {
+		t.Fatalf("Expected the new config, got %+v", msg)
+	}
+
+	// The device acknowledges its anomaly over the same stream
+	if err := stream.Send(DeviceMessage{AckAnomaly: opened.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(t, stream); msg.Anomaly == nil || msg.Anomaly.State != anomalies.Acked || msg.Anomaly.AckedBy != "device:sensor-1" {
+		t.Fatalf("Expected the acked anomaly, got %+v", msg)
+	}
+
+	// Foreign metrics and anomalies are rejected without closing the stream
+	tracker.Record(models.Metric{DeviceID: "sensor-2"}, models.AnalysisResult{AnomalyDetected: true})
+	foreign := tracker.List(anomalies.Open)[0]
+	if err := stream.Send(DeviceMessage{AckAnomaly: foreign.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(t, stream); msg.Error == "" {
+		t.Fatalf("Expected an error for another device's anomaly, got %+v", msg)
+	}
+	if err := stream.Send(DeviceMessage{Metric: &models.Metric{DeviceID: "sensor-2", CPU: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(t, stream); msg.Error == "" {
+		t.Fatalf("Expected an error for another device's metric, got %+v", msg)
+	}
+
+	if got := hub.Connected(); len(got) != 1 || got[0].DeviceID != "sensor-1" || got[0].Config.ReportingIntervalMs != 2500 {
+		t.Fatalf("Expected sensor-1 connected with its config, got %+v", got)
+	}
+
+	// Shutdown ends the stream so the device reconnects elsewhere
+	hub.Close()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable after Close, got %v", err)
+	}
+}
+
+func TestStream_RequiresDeviceID(t *testing.T) {
+	_, _, cc := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := Connect(ctx, cc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument without device id, got %v", err)
+	}
+}
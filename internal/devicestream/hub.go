@@ -0,0 +1,183 @@
+package devicestream
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/metrics"
+)
+
+// sendBuffer размер очереди исходящих сообщений одного подключения
+const sendBuffer = 64
+
+// ErrClosed возвращается при подключении к остановленному хабу
+var ErrClosed = errors.New("device stream hub is closed")
+
+// DeviceConfig настройки, которые сервер передает устройству
+type DeviceConfig struct {
+	// ReportingIntervalMs как часто устройство отправляет метрики, в миллисекундах
+	ReportingIntervalMs int64 `json:"reporting_interval_ms"`
+}
+
+// NewDeviceConfig создает настройки с интервалом отправки interval
+func NewDeviceConfig(interval time.Duration) DeviceConfig {
+	return DeviceConfig{ReportingIntervalMs: interval.Milliseconds()}
+}
+
+// Validate проверяет настройки
+func (c DeviceConfig) Validate() error {
+	if c.ReportingIntervalMs <= 0 {
+		return fmt.Errorf("reporting_interval_ms must be positive, got %d", c.ReportingIntervalMs)
+	}
+	return nil
+}
+
+// Connection подключенное устройство
+type Connection struct {
+	DeviceID string `json:"device_id"`
+	// Streams количество открытых потоков устройства (например, после переподключения)
+	Streams int          `json:"streams"`
+	Config  DeviceConfig `json:"config"`
+}
+
+// conn исходящая очередь одного потока
+type conn struct {
+	out chan ServerMessage
+}
+
+// Hub реестр подключенных устройств: хранит их настройки и рассылает
+// изменения настроек и аномалий во все потоки устройства. Безопасен для
+// конкурентного использования
+type Hub struct {
+	mu       sync.Mutex
+	defaults DeviceConfig
+	configs  map[string]DeviceConfig
+	conns    map[string]map[*conn]struct{}
+	closed   chan struct{}
+}
+
+// NewHub создает хаб; defaults — настройки устройств, для которых не заданы свои
+func NewHub(defaults DeviceConfig) *Hub {
+	return &Hub{
+		defaults: defaults,
+		configs:  make(map[string]DeviceConfig),
+		conns:    make(map[string]map[*conn]struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Config возвращает действующие настройки устройства
+func (h *Hub) Config(deviceID string) DeviceConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.configLocked(deviceID)
+}
+
+func (h *Hub) configLocked(deviceID string) DeviceConfig {
+	if c, ok := h.configs[deviceID]; ok {
+		return c
+	}
+	return h.defaults
+}
+
+// SetConfig задает настройки устройства и отправляет их в его открытые потоки.
+// Возвращает количество потоков, получивших настройки
+func (h *Hub) SetConfig(deviceID string, c DeviceConfig) (int, error) {
+	if err := c.Validate(); err != nil {
+		return 0, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.configs[deviceID] = c
+	return h.pushLocked(deviceID, ServerMessage{Config: &c}), nil
+}
+
+// NotifyAnomaly отправляет состояние аномалии в потоки ее устройства.
+// Подходит для anomalies.WithNotifier (новые аномалии) и
+// anomalies.WithTransitions (подтверждения и закрытия)
+func (h *Hub) NotifyAnomaly(a anomalies.Anomaly) {
+	if a.DeviceID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pushLocked(a.DeviceID, ServerMessage{Anomaly: &a})
+}
+
+// Connected возвращает подключенные устройства, упорядоченные по идентификатору
+func (h *Hub) Connected() []Connection {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := make([]Connection, 0, len(h.conns))
+	for id, conns := range h.conns {
+		list = append(list, Connection{DeviceID: id, Streams: len(conns), Config: h.configLocked(id)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceID < list[j].DeviceID })
+	return list
+}
+
+// Close завершает все потоки: устройства переподключаются к другим экземплярам
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+// register добавляет поток устройства; первым сообщением в него ставятся настройки
+func (h *Hub) register(deviceID string) (*conn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	c := &conn{out: make(chan ServerMessage, sendBuffer)}
+	config := h.configLocked(deviceID)
+	c.out <- ServerMessage{Config: &config}
+	if h.conns[deviceID] == nil {
+		h.conns[deviceID] = make(map[*conn]struct{})
+	}
+	h.conns[deviceID][c] = struct{}{}
+	metrics.DeviceStreams.Inc()
+	return c, nil
+}
+
+// unregister удаляет поток устройства
+func (h *Hub) unregister(deviceID string, c *conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns[deviceID], c)
+	if len(h.conns[deviceID]) == 0 {
+		delete(h.conns, deviceID)
+	}
+	metrics.DeviceStreams.Dec()
+}
+
+// pushLocked ставит сообщение в очереди потоков устройства, не дожидаясь
+// медленных получателей. Настройки повторно отправляются при переподключении,
+// поэтому переполненная очередь лишь пропускает сообщение
+func (h *Hub) pushLocked(deviceID string, msg ServerMessage) int {
+	sent := 0
+	for c := range h.conns[deviceID] {
+		select {
+		case c.out <- msg:
+			sent++
+		default:
+			metrics.DeviceStreamDropped.Inc()
+			log.Printf("Device stream of %q is full, dropping server message", deviceID)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,266 @@
+// Package devicestream двунаправленный gRPC-поток для устройств: устройство
+// непрерывно отправляет метрики, а сервер в том же соединении возвращает
+// результаты анализа, новые настройки (интервал отправки) и изменения
+// состояния аномалий устройства, включая подтверждения
+package devicestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/models"
+)
+
+const (
+	// ServiceName имя gRPC-сервиса
+	ServiceName = "highload.DeviceStream"
+	// DeviceIDHeader ключ метаданных с идентификатором устройства
+	DeviceIDHeader = "device-id"
+)
+
+// DeviceMessage сообщение устройства. Поля независимы: одно сообщение может
+// содержать и метрику, и подтверждение аномалии
+type DeviceMessage struct {
+	// Metric метрика; пустой device_id заменяется устройством потока
+	Metric *models.Metric `json:"metric,omitempty"`
+	// AckAnomaly идентификатор аномалии устройства, которую нужно подтвердить.
+	// Подтвержденная аномалия возвращается сообщением с полем anomaly
+	AckAnomaly string `json:"ack_anomaly,omitempty"`
+}
+
+// ServerMessage сообщение сервера; заполнено ровно одно поле
+type ServerMessage struct {
+	Result  *models.AnalysisResult `json:"result,omitempty"`
+	Config  *DeviceConfig          `json:"config,omitempty"`
+	Anomaly *anomalies.Anomaly     `json:"anomaly,omitempty"`
+	// Error ошибка обработки сообщения устройства; поток при этом не закрывается
+	Error string `json:"error,omitempty"`
+}
+
+// Ingester прием метрик (реализуется handlers.Handler)
+type Ingester interface {
+	Ingest(m models.Metric) (models.AnalysisResult, error)
+}
+
+// Acker подтверждение аномалий (реализуется anomalies.Tracker)
+type Acker interface {
+	Get(id string) (anomalies.Anomaly, error)
+	Ack(id, actor string) (anomalies.Anomaly, error)
+}
+
+// Throttle приостановка приема при переполнении буферов (реализуется backpressure.Gate)
+type Throttle interface {
+	Wait(ctx context.Context) error
+}
+
+// ServerOption настраивает Server
+type ServerOption func(*Server)
+
+// WithThrottle приостанавливает чтение сообщений устройств, пока throttle не
+// разрешит прием. Непрочитанные сообщения остаются в окне управления потоком
+// HTTP/2, и устройство перестает отправлять, а не теряет метрики
+func WithThrottle(t Throttle) ServerOption {
+	return func(s *Server) {
+		s.throttle = t
+	}
+}
+
+// Server реализация сервиса DeviceStream
+type Server struct {
+	hub      *Hub
+	ingest   Ingester
+	acks     Acker
+	throttle Throttle
+}
+
+// NewServer создает сервис. acks может быть nil, если учет аномалий выключен
+func NewServer(hub *Hub, ingest Ingester, acks Acker, opts ...ServerOption) *Server {
+	s := &Server{hub: hub, ingest: ingest, acks: acks}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register регистрирует сервис на gRPC-сервере. Сервер должен использовать
+// Codec: grpc.NewServer(grpc.ForceServerCodec(devicestream.Codec{}))
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+// streamServer тип обработчика для проверки при регистрации сервиса
+type streamServer interface {
+	connect(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*streamServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Connect",
+		Handler:       connectHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "devicestream",
+}
+
+func connectHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(streamServer).connect(stream)
+}
+
+// connect обслуживает поток устройства. Сообщения устройства читает отдельная
+// горутина, а отправляет только эта: grpc.ServerStream не допускает
+// конкурентный SendMsg
+func (s *Server) connect(stream grpc.ServerStream) error {
+	deviceID := deviceIDFrom(stream.Context())
+	if deviceID == "" {
+		return status.Errorf(codes.InvalidArgument, "%s metadata is required", DeviceIDHeader)
+	}
+	c, err := s.hub.register(deviceID)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer s.hub.unregister(deviceID, c)
+
+	received := make(chan error, 1)
+	go func() {
+		received <- s.receive(stream, deviceID, c)
+	}()
+
+	for {
+		select {
+		case msg := <-c.out:
+			if err := stream.SendMsg(&msg); err != nil {
+				return err
+			}
+		case err := <-received:
+			// Устройство закончило отправку: досылаем накопленные ответы
+			for {
+				select {
+				case msg := <-c.out:
+					if err := stream.SendMsg(&msg); err != nil {
+						return err
+					}
+				default:
+					return err
+				}
+			}
+		case <-s.hub.closed:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+// receive читает сообщения устройства до конца потока. Ответы ставятся в очередь
+// с ожиданием, поэтому медленное чтение ответов замедляет и прием метрик
+func (s *Server) receive(stream grpc.ServerStream, deviceID string, c *conn) error {
+	ctx := stream.Context()
+	for {
+		if s.throttle != nil {
+			if err := s.throttle.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		var msg DeviceMessage
+		if err := stream.RecvMsg(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for _, reply := range s.handle(deviceID, msg) {
+			select {
+			case c.out <- reply:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// handle обрабатывает сообщение устройства и возвращает ответы на него
+func (s *Server) handle(deviceID string, msg DeviceMessage) []ServerMessage {
+	var replies []ServerMessage
+	if msg.Metric != nil {
+		m := *msg.Metric
+		if m.DeviceID == "" {
+			m.DeviceID = deviceID
+		}
+		if m.DeviceID != deviceID {
+			replies = append(replies, ServerMessage{Error: fmt.Sprintf("metric device_id %q does not match stream device %q", m.DeviceID, deviceID)})
+		} else if result, err := s.ingest.Ingest(m); err != nil {
+			replies = append(replies, ServerMessage{Error: err.Error()})
+		} else {
+			replies = append(replies, ServerMessage{Result: &result})
+		}
+	}
+	if msg.AckAnomaly != "" {
+		// Об успешном подтверждении поток узнает от хаба вместе с остальными потоками устройства
+		if err := s.ack(deviceID, msg.AckAnomaly); err != nil {
+			replies = append(replies, ServerMessage{Error: err.Error()})
+		}
+	}
+	return replies
+}
+
+// ack подтверждает аномалию, если она принадлежит устройству потока
+func (s *Server) ack(deviceID, id string) error {
+	if s.acks == nil {
+		return errors.New("anomaly tracking is not enabled")
+	}
+	a, err := s.acks.Get(id)
+	if err != nil {
+		return err
+	}
+	if a.DeviceID != deviceID {
+		// Чужие аномалии неотличимы от несуществующих
+		return anomalies.ErrNotFound
+	}
+	_, err = s.acks.Ack(id, "device:"+deviceID)
+	return err
+}
+
+func deviceIDFrom(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(DeviceIDHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Stream клиентская сторона потока устройства
+type Stream struct {
+	grpc.ClientStream
+}
+
+// Connect открывает поток устройства deviceID. Первым сообщением сервер
+// присылает действующие настройки устройства
+func Connect(ctx context.Context, cc grpc.ClientConnInterface, deviceID string) (*Stream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, DeviceIDHeader, deviceID)
+	cs, err := cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/Connect", grpc.ForceCodec(Codec{}))
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{cs}, nil
+}
+
+// Send отправляет сообщение устройства
+func (s *Stream) Send(msg DeviceMessage) error {
+	return s.SendMsg(&msg)
+}
+
+// Recv ждет следующее сообщение сервера
+func (s *Stream) Recv() (ServerMessage, error) {
+	var msg ServerMessage
+	err := s.RecvMsg(&msg)
+	return msg, err
+}
@@ -0,0 +1,227 @@
+// Package dlq очередь недоставленных метрик (dead-letter queue).
+//
+// Метрики, не прошедшие проверку, упавшие при анализе или не сохраненные в
+// хранилище, не отбрасываются, а записываются в журнал вместе с причиной.
+// Записи можно просмотреть и повторно отправить в обработку; запись, снова
+// завершившаяся ошибкой, возвращается в очередь с увеличенным счетчиком попыток
+package dlq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// Stream поток недоставленных метрик
+	Stream = "dlq:metrics"
+	// MaxLen примерное количество хранимых записей
+	MaxLen = 100000
+	// MaxList ограничение числа записей в одном ответе и одном повторе
+	MaxList = 1000
+	// pageSize количество записей, читаемых из журнала за раз при фильтрации
+	pageSize = 500
+)
+
+// Reason этап, на котором метрика не была обработана
+type Reason string
+
+const (
+	// ReasonValidation метрика не прошла проверку
+	ReasonValidation Reason = "validation"
+	// ReasonAnalysis анализ метрики завершился ошибкой
+	ReasonAnalysis Reason = "analysis"
+	// ReasonPersistence метрику не удалось сохранить в хранилище
+	ReasonPersistence Reason = "persistence"
+)
+
+// ErrUnknownReason неизвестная причина в фильтре
+var ErrUnknownReason = errors.New("unknown dead letter reason")
+
+// ParseReason проверяет причину; пустая строка означает любую причину
+func ParseReason(s string) (Reason, error) {
+	switch r := Reason(s); r {
+	case "", ReasonValidation, ReasonAnalysis, ReasonPersistence:
+		return r, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownReason, s)
+	}
+}
+
+// Log журнал записей (реализуется cache.RedisCache, cache.MemoryCache и FileLog)
+type Log interface {
+	AppendStream(stream string, data []byte, maxLen int64) (string, error)
+	RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error)
+	DeleteStream(stream string, ids ...string) error
+}
+
+// Entry недоставленная метрика
+type Entry struct {
+	ID       string        `json:"id"`
+	Reason   Reason        `json:"reason"`
+	Error    string        `json:"error"`
+	Metric   models.Metric `json:"metric"`
+	FailedAt time.Time     `json:"failed_at"`
+	// Attempts количество неудачных попыток обработки
+	Attempts int `json:"attempts"`
+}
+
+// Processor повторно обрабатывает метрику записи
+type Processor func(Entry) error
+
+// Failure запись, снова не прошедшая обработку
+type Failure struct {
+	ID string `json:"id"`
+	// NewID идентификатор, под которым запись возвращена в очередь
+	NewID string `json:"new_id,omitempty"`
+	Error string `json:"error"`
+}
+
+// RequeueResult итог повторной обработки
+type RequeueResult struct {
+	Requeued int       `json:"requeued"`
+	Failed   []Failure `json:"failed"`
+	// Missing идентификаторы, которых нет в очереди
+	Missing []string `json:"missing"`
+}
+
+// Option настраивает Queue
+type Option func(*Queue)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(q *Queue) {
+		q.clock = c
+	}
+}
+
+// Queue очередь недоставленных метрик
+type Queue struct {
+	log   Log
+	clock clock.Clock
+}
+
+// New создает очередь поверх журнала log
+func New(log Log, opts ...Option) *Queue {
+	q := &Queue{log: log, clock: clock.Real()}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Add записывает метрику в очередь. Ошибка записи только логируется: очередь
+// вызывается на пути приема метрик, который не должен от нее зависеть
+func (q *Queue) Add(m models.Metric, reason Reason, cause error) {
+	q.add(Entry{Reason: reason, Error: cause.Error(), Metric: m, Attempts: 1})
+}
+
+func (q *Queue) add(e Entry) string {
+	e.ID = ""
+	e.FailedAt = q.clock.Now().UTC()
+	data, err := json.Marshal(e)
+	var id string
+	if err == nil {
+		id, err = q.log.AppendStream(Stream, data, MaxLen)
+	}
+	if err != nil {
+		log.Printf("Failed to dead-letter metric from %q (%s: %s): %v", e.Metric.DeviceID, e.Reason, e.Error, err)
+		return ""
+	}
+	metrics.DeadLetters.WithLabelValues(string(e.Reason)).Inc()
+	return id
+}
+
+// List возвращает до limit записей с причиной reason (пустая — любая), старые первыми
+func (q *Queue) List(reason Reason, limit int) ([]Entry, error) {
+	if limit <= 0 || limit > MaxList {
+		limit = MaxList
+	}
+	result := []Entry{}
+	start := "-"
+	for len(result) < limit {
+		page, err := q.log.RangeStream(Stream, start, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range page {
+			e, err := decode(raw)
+			if err != nil {
+				log.Printf("Skipping malformed dead letter %s: %v", raw.ID, err)
+				continue
+			}
+			if reason == "" || e.Reason == reason {
+				result = append(result, e)
+				if len(result) == limit {
+					break
+				}
+			}
+		}
+		if len(page) < pageSize {
+			break
+		}
+		start = nextID(page[len(page)-1].ID)
+	}
+	return result, nil
+}
+
+// Requeue повторно обрабатывает записи ids. Обработанные записи удаляются из
+// очереди, неудачные возвращаются в ее конец с увеличенным счетчиком попыток
+func (q *Queue) Requeue(ids []string, process Processor) (RequeueResult, error) {
+	result := RequeueResult{Failed: []Failure{}, Missing: []string{}}
+	for _, id := range ids {
+		page, err := q.log.RangeStream(Stream, id, 1)
+		if err != nil {
+			return result, err
+		}
+		if len(page) == 0 || page[0].ID != id {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+		e, err := decode(page[0])
+		if err != nil {
+			return result, fmt.Errorf("dead letter %s: %w", id, err)
+		}
+
+		if err := process(e); err != nil {
+			e.Attempts++
+			e.Error = err.Error()
+			failure := Failure{ID: id, NewID: q.add(e), Error: e.Error}
+			result.Failed = append(result.Failed, failure)
+			metrics.DeadLetterRequeues.WithLabelValues("failed").Inc()
+			if failure.NewID == "" {
+				// Исходная запись остается в очереди, если новую записать не удалось
+				continue
+			}
+		} else {
+			result.Requeued++
+			metrics.DeadLetterRequeues.WithLabelValues("requeued").Inc()
+		}
+		if err := q.log.DeleteStream(Stream, id); err != nil {
+			return result, fmt.Errorf("failed to remove dead letter %s: %w", id, err)
+		}
+	}
+	return result, nil
+}
+
+func decode(raw cache.StreamEntry) (Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(raw.Data, &e); err != nil {
+		return Entry{}, err
+	}
+	e.ID = raw.ID
+	return e, nil
+}
+
+// nextID возвращает идентификатор, следующий за id "<ms>-<seq>"
+func nextID(id string) string {
+	ms, seq := parseID(id)
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
@@ -0,0 +1,114 @@
+package dlq
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/encryption"
+	"highload-service/internal/models"
+)
+
+func TestQueue_ListAndRequeue(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	q := New(cache.NewMemoryCache(clk), WithClock(clk))
+
+	q.Add(models.Metric{DeviceID: "a", CPU: -1}, ReasonValidation, errors.New("negative cpu"))
+	q.Add(models.Metric{DeviceID: "b", CPU: 10}, ReasonPersistence, errors.New("redis down"))
+	q.Add(models.Metric{DeviceID: "c", CPU: 20}, ReasonPersistence, errors.New("redis down"))
+
+	persistence, err := q.List(ReasonPersistence, 10)
+	if err != nil || len(persistence) != 2 || persistence[0].Metric.DeviceID != "b" {
+		t.Fatalf("Expected two persistence entries oldest first, got %+v (err %v)", persistence, err)
+	}
+	if all, _ := q.List("", 1); len(all) != 1 || all[0].Reason != ReasonValidation {
+		t.Errorf("Expected limit to apply across reasons, got %+v", all)
+	}
+
+	// "c" fails again and returns to the queue with a second attempt
+	process := func(e Entry) error {
+		if e.Metric.DeviceID == "c" {
+			return errors.New("still down")
+		}
+		return nil
+	}
+	result, err := q.Requeue([]string{persistence[0].ID, persistence[1].ID, "1-1"}, process)
+	if err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+	if result.Requeued != 1 || len(result.Failed) != 1 || len(result.Missing) != 1 {
+		t.Fatalf("Unexpected requeue result %+v", result)
+	}
+
+	left, _ := q.List(ReasonPersistence, 10)
+	if len(left) != 1 || left[0].ID != result.Failed[0].NewID || left[0].Attempts != 2 || left[0].Error != "still down" {
+		t.Errorf("Expected failed entry to be re-queued with attempts=2, got %+v", left)
+	}
+}
+
+func TestFileLog_SurvivesReopen(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+
+	l, err := OpenFileLog(path, clk)
+	if err != nil {
+		t.Fatalf("OpenFileLog failed: %v", err)
+	}
+	q := New(l, WithClock(clk))
+	for _, id := range []string{"a", "b", "c"} {
+		q.Add(models.Metric{DeviceID: id}, ReasonAnalysis, errors.New("panic"))
+	}
+	entries, _ := q.List("", 10)
+	if _, err := q.Requeue([]string{entries[1].ID}, func(Entry) error { return nil }); err != nil {
+		t.Fatalf("Requeue failed: %v", err)
+	}
+
+	reopened, err := OpenFileLog(path, clk)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	q = New(reopened, WithClock(clk))
+	got, _ := q.List("", 10)
+	if len(got) != 2 || got[0].Metric.DeviceID != "a" || got[1].Metric.DeviceID != "c" {
+		t.Fatalf("Expected entries a and c after reopen, got %+v", got)
+	}
+
+	// New IDs continue after the ones already in the file
+	q.Add(models.Metric{DeviceID: "d"}, ReasonAnalysis, errors.New("panic"))
+	if got, _ := q.List("", 10); len(got) != 3 || got[2].Metric.DeviceID != "d" {
+		t.Errorf("Expected appended entry last, got %+v", got)
+	}
+}
+
+func TestFileLog_EncryptsEntries(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	keys, err := encryption.ParseKeys("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenFileLog(path, clk, WithFileEncryption(keys))
+	if err != nil {
+		t.Fatalf("OpenFileLog failed: %v", err)
+	}
+	New(l, WithClock(clk)).Add(models.Metric{DeviceID: "secret-device"}, ReasonAnalysis, errors.New("panic"))
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "secret-device") {
+		t.Errorf("Expected the metric to be encrypted on disk, got %s", raw)
+	}
+	reopened, err := OpenFileLog(path, clk, WithFileEncryption(keys))
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if got, _ := New(reopened, WithClock(clk)).List("", 10); len(got) != 1 || got[0].Metric.DeviceID != "secret-device" {
+		t.Errorf("Expected the decrypted entry, got %+v", got)
+	}
+}
@@ -0,0 +1,204 @@
+package dlq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/encryption"
+)
+
+// fileRecord строка файла журнала
+type fileRecord struct {
+	Stream string          `json:"stream"`
+	ID     string          `json:"id"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// FileLog журнал в файле JSON Lines для установок без Redis.
+// Записи держатся в памяти и дописываются в конец файла; при удалении и
+// обрезке по maxLen файл перезаписывается целиком
+type FileLog struct {
+	mu    sync.Mutex
+	path  string
+	clock clock.Clock
+	// keys шифруют данные записей; в памяти записи тоже хранятся зашифрованными
+	keys    *encryption.Keyring
+	entries map[string][]cache.StreamEntry
+	lastMs  int64
+	lastSeq int64
+}
+
+// FileOption настраивает FileLog
+type FileOption func(*FileLog)
+
+// WithFileEncryption шифрует данные записей ключами keys
+func WithFileEncryption(keys *encryption.Keyring) FileOption {
+	return func(l *FileLog) {
+		l.keys = keys
+	}
+}
+
+// OpenFileLog открывает журнал path, загружая сохраненные записи
+func OpenFileLog(path string, clk clock.Clock, opts ...FileOption) (*FileLog, error) {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	l := &FileLog{path: path, clock: clk, entries: make(map[string][]cache.StreamEntry)}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("dead letter file %s line %d: %w", path, line, err)
+		}
+		l.entries[rec.Stream] = append(l.entries[rec.Stream], cache.StreamEntry{ID: rec.ID, Data: rec.Data})
+		if ms, seq := parseID(rec.ID); ms > l.lastMs || ms == l.lastMs && seq > l.lastSeq {
+			l.lastMs, l.lastSeq = ms, seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	return l, nil
+}
+
+// AppendStream дописывает запись в файл
+func (l *FileLog) AppendStream(stream string, data []byte, maxLen int64) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ms := l.clock.Now().UnixMilli()
+	if ms > l.lastMs {
+		l.lastMs, l.lastSeq = ms, 0
+	} else {
+		l.lastSeq++
+	}
+	sealed, err := l.keys.Seal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt dead letter: %w", err)
+	}
+	if l.keys == nil {
+		sealed = append([]byte(nil), data...)
+	}
+	entry := cache.StreamEntry{ID: fmt.Sprintf("%d-%d", l.lastMs, l.lastSeq), Data: sealed}
+	l.entries[stream] = append(l.entries[stream], entry)
+
+	if maxLen > 0 && int64(len(l.entries[stream])) > maxLen {
+		l.entries[stream] = l.entries[stream][int64(len(l.entries[stream]))-maxLen:]
+		return entry.ID, l.rewrite()
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+	line, err := json.Marshal(fileRecord{Stream: stream, ID: entry.ID, Data: entry.Data})
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	return entry.ID, nil
+}
+
+// RangeStream возвращает до count записей потока, начиная с start включительно ("-" — с начала)
+func (l *FileLog) RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	startMs, startSeq := parseID(start)
+	entries := []cache.StreamEntry{}
+	for _, e := range l.entries[stream] {
+		if int64(len(entries)) >= count {
+			break
+		}
+		if ms, seq := parseID(e.ID); start != "-" && (ms < startMs || ms == startMs && seq < startSeq) {
+			continue
+		}
+		// Запись, которую не удалось расшифровать, отдается как есть и будет разобрана как испорченная
+		if data, err := l.keys.Open(e.Data); err == nil {
+			e.Data = data
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// DeleteStream удаляет записи и перезаписывает файл
+func (l *FileLog) DeleteStream(stream string, ids ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	kept := l.entries[stream][:0]
+	for _, e := range l.entries[stream] {
+		if !remove[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	l.entries[stream] = kept
+	return l.rewrite()
+}
+
+// rewrite атомарно заменяет файл текущим содержимым; вызывается под блокировкой
+func (l *FileLog) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for stream, entries := range l.entries {
+		for _, e := range entries {
+			line, err := json.Marshal(fileRecord{Stream: stream, ID: e.ID, Data: e.Data})
+			if err != nil {
+				tmp.Close()
+				return err
+			}
+			w.Write(append(line, '\n'))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite dead letter file: %w", err)
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// parseID разбирает идентификатор "<ms>-<seq>"
+func parseID(id string) (ms, seq int64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseInt(msPart, 10, 64)
+	seq, _ = strconv.ParseInt(seqPart, 10, 64)
+	return ms, seq
+}
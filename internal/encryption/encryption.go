@@ -0,0 +1,171 @@
+// Package encryption шифрует данные перед записью в Redis и файлы на диске
+// (AES-GCM). Зашифрованное значение — JSON-строка
+//
+//	"enc:v1:<ключ>:<base64(nonce || шифротекст)>"
+//
+// поэтому оно остается корректным JSON там, где хранилище ждет JSON (файлы
+// очередей), и двоично безопасно в Redis. Имя ключа в значении позволяет
+// менять ключи: новые записи шифруются основным ключом, старые читаются
+// прежними, пока те остаются в связке. Значения без префикса считаются
+// незашифрованными и возвращаются как есть, чтобы включить шифрование на
+// работающем кластере без миграции данных
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix начало зашифрованного значения (вместе с открывающей кавычкой JSON-строки)
+const prefix = `"enc:v1:`
+
+var (
+	// ErrUnknownKey значение зашифровано ключом, которого нет в связке
+	ErrUnknownKey = errors.New("encryption key not found")
+	// ErrMalformed значение с префиксом шифрования повреждено
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+// Keyring связка ключей AES-GCM. Нулевой указатель — шифрование выключено:
+// Seal и Open возвращают данные без изменений
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// ParseKeys разбирает связку вида "имя:base64-ключ,имя:base64-ключ". Первый
+// ключ основной; ключи длиной 16, 24 или 32 байта (AES-128/192/256)
+func ParseKeys(raw string) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, encoded, ok := strings.Cut(item, ":")
+		if !ok || !validName(name) {
+			return nil, fmt.Errorf("key %q: expected name:base64-key with a name of letters, digits, - or _", name)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", name, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", name, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", name, err)
+		}
+		if _, dup := k.aeads[name]; dup {
+			return nil, fmt.Errorf("key %q is listed twice", name)
+		}
+		k.aeads[name] = aead
+		if k.primary == "" {
+			k.primary = name
+		}
+	}
+	if k.primary == "" {
+		return nil, errors.New("no encryption keys")
+	}
+	return k, nil
+}
+
+// LoadKeys читает связку из переменной (raw) или, если она пуста, из файла
+// path — например, секрета, который KMS или Vault монтирует в под. Пустые
+// raw и path означают, что шифрование выключено (nil без ошибки)
+func LoadKeys(raw, path string) (*Keyring, error) {
+	if raw == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption keys: %w", err)
+		}
+		raw = string(data)
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return ParseKeys(raw)
+}
+
+// Primary возвращает имя основного ключа
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Seal шифрует data основным ключом
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	if k == nil {
+		return data, nil
+	}
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, data, nil)
+
+	out := make([]byte, 0, len(prefix)+len(k.primary)+2+base64.StdEncoding.EncodedLen(len(sealed)))
+	out = append(out, prefix...)
+	out = append(out, k.primary...)
+	out = append(out, ':')
+	out = base64.StdEncoding.AppendEncode(out, sealed)
+	return append(out, '"'), nil
+}
+
+// Open расшифровывает значение, записанное Seal. Значение без префикса
+// шифрования возвращается как есть
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return data, nil
+	}
+	if k == nil {
+		return nil, fmt.Errorf("%w: value is encrypted but no keys are configured", ErrUnknownKey)
+	}
+	body := data[len(prefix):]
+	if len(body) == 0 || body[len(body)-1] != '"' {
+		return nil, ErrMalformed
+	}
+	name, encoded, ok := bytes.Cut(body[:len(body)-1], []byte(":"))
+	if !ok {
+		return nil, ErrMalformed
+	}
+	aead, ok := k.aeads[string(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, name)
+	}
+	sealed, err := base64.StdEncoding.AppendDecode(nil, encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return plain, nil
+}
+
+// validName проверяет, что имя ключа не содержит разделителей формата
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestKeyring_SealOpenAndRotate(t *testing.T) {
+	old, err := ParseKeys("k1:" + key(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"device_id":"sensor-1","cpu":42}`)
+	sealed, err := old.Seal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("sensor-1")) || !json.Valid(sealed) {
+		t.Fatalf("Expected an opaque JSON string, got %s", sealed)
+	}
+
+	// After rotation new values use k2 while values sealed with k1 stay readable
+	rotated, err := ParseKeys("k2:" + key(2) + ", k1:" + key(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := rotated.Open(sealed); err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("Expected the old value to open, got %s (%v)", plain, err)
+	}
+	resealed, _ := rotated.Seal(payload)
+	if !bytes.HasPrefix(resealed, []byte(`"enc:v1:k2:`)) {
+		t.Errorf("Expected the primary key k2, got %s", resealed)
+	}
+	if _, err := old.Open(resealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	// Values written before encryption was enabled pass through
+	if plain, err := rotated.Open(payload); err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("Expected plaintext to pass through, got %s (%v)", plain, err)
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := old.Open(tampered); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed for a tampered value, got %v", err)
+	}
+
+	var disabled *Keyring
+	if out, _ := disabled.Seal(payload); !bytes.Equal(out, payload) {
+		t.Errorf("Expected a nil keyring to leave data as is, got %s", out)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte("from-file:"+key(3)+"\n"), 0o600)
+
+	if k, err := LoadKeys("", path); err != nil || k.Primary() != "from-file" {
+		t.Errorf("Expected keys from the file, got %v (%v)", k, err)
+	}
+	if k, err := LoadKeys("env:"+key(4), path); err != nil || k.Primary() != "env" {
+		t.Errorf("Expected the variable to win over the file, got %v (%v)", k, err)
+	}
+	if k, err := LoadKeys("", ""); err != nil || k != nil {
+		t.Errorf("Expected encryption to be disabled, got %v (%v)", k, err)
+	}
+	for _, raw := range []string{"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "bad name:" + key(1), "k1", "k1:" + key(1) + ",k1:" + key(2)} {
+		if _, err := ParseKeys(raw); err == nil {
+			t.Errorf("Expected an error for %q", raw)
+		}
+	}
+}
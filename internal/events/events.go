@@ -0,0 +1,152 @@
+// Package events реализует внутреннюю шину событий. Издатели публикуют
+// события типизированных тем, не зная получателей, а получатели
+// подписываются на темы при сборке сервиса: новый получатель подключается
+// подпиской в main без изменения издателей
+package events
+
+import (
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/audit"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+// Topic тема событий с типом T
+type Topic[T any] struct {
+	name string
+}
+
+// Name имя темы в логах и метриках
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Ingested метрика, принятая и проанализированная обработчиком приема
+type Ingested struct {
+	Metric models.Metric
+	Result models.AnalysisResult
+}
+
+var (
+	// MetricIngested метрики после синхронного анализа при приеме, записи в
+	// журнал и учета аномалий. Метрики асинхронного приема не публикуются
+	MetricIngested = Topic[Ingested]{name: "metric-ingested"}
+	// AnomalyDetected оповещения о новых и вновь открытых аномалиях
+	// (подтвержденные аномалии подавляются учетом аномалий)
+	AnomalyDetected = Topic[anomalies.Anomaly]{name: "anomaly-detected"}
+	// ConfigChanged изменения, примененные через административный API;
+	// событие совпадает с записью журнала аудита
+	ConfigChanged = Topic[audit.Event]{name: "config-changed"}
+)
+
+// subscription подписчик темы
+type subscription[T any] struct {
+	fn func(T)
+}
+
+// topicState подписчики темы и ее счетчики. Срез подписчиков заменяется
+// целиком при подписке и отписке, поэтому публикация читает его без копирования
+type topicState[T any] struct {
+	subs      []*subscription[T]
+	published prometheus.Counter
+	panics    prometheus.Counter
+}
+
+// Bus шина событий. События доставляются синхронно в горутине издателя в
+// порядке подписки: медленный получатель должен сам передавать их в свою
+// горутину. Паника подписчика записывается в лог и не мешает доставке
+// остальным. Нулевой *Bus допустим: публикация в него ничего не делает.
+// Безопасна для конкурентного использования
+type Bus struct {
+	mu     sync.RWMutex
+	topics map[string]interface{}
+}
+
+// New создает шину без подписчиков
+func New() *Bus {
+	return &Bus{topics: make(map[string]interface{})}
+}
+
+// Subscribe подписывает fn на тему и возвращает функцию отписки
+func Subscribe[T any](b *Bus, t Topic[T], fn func(T)) (unsubscribe func()) {
+	sub := &subscription[T]{fn: fn}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := stateLocked(b, t)
+	state.subs = append(state.subs, sub)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		state := stateLocked(b, t)
+		subs := make([]*subscription[T], 0, len(state.subs))
+		for _, s := range state.subs {
+			if s != sub {
+				subs = append(subs, s)
+			}
+		}
+		state.subs = subs
+	}
+}
+
+// Publish доставляет событие подписчикам темы
+func Publish[T any](b *Bus, t Topic[T], event T) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	state, _ := b.topics[t.name].(*topicState[T])
+	var subs []*subscription[T]
+	if state != nil {
+		subs = state.subs
+	}
+	b.mu.RUnlock()
+	if state == nil {
+		return
+	}
+
+	state.published.Inc()
+	for _, sub := range subs {
+		deliver(t, state, sub, event)
+	}
+}
+
+// Subscribers количество подписчиков темы
+func Subscribers[T any](b *Bus, t Topic[T]) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if state, ok := b.topics[t.name].(*topicState[T]); ok {
+		return len(state.subs)
+	}
+	return 0
+}
+
+// deliver вызывает подписчика, перехватывая его панику
+func deliver[T any](t Topic[T], state *topicState[T], sub *subscription[T], event T) {
+	defer func() {
+		if p := recover(); p != nil {
+			state.panics.Inc()
+			log.Printf("Event subscriber of %s panicked: %v", t.name, p)
+		}
+	}()
+	sub.fn(event)
+}
+
+// stateLocked возвращает состояние темы, создавая его при первой подписке
+func stateLocked[T any](b *Bus, t Topic[T]) *topicState[T] {
+	if state, ok := b.topics[t.name].(*topicState[T]); ok {
+		return state
+	}
+	state := &topicState[T]{
+		published: metrics.EventsPublished.WithLabelValues(t.name),
+		panics:    metrics.EventSubscriberPanics.WithLabelValues(t.name),
+	}
+	b.topics[t.name] = state
+	return state
+}
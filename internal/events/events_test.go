@@ -0,0 +1,59 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+
+	"highload-service/internal/audit"
+	"highload-service/internal/models"
+)
+
+func TestBus_DeliversInSubscriptionOrder(t *testing.T) {
+	bus := New()
+	var got []string
+	Subscribe(bus, MetricIngested, func(e Ingested) { got = append(got, "first:"+e.Metric.DeviceID) })
+	unsubscribe := Subscribe(bus, MetricIngested, func(e Ingested) { got = append(got, "second:"+e.Metric.DeviceID) })
+	Subscribe(bus, ConfigChanged, func(e audit.Event) { got = append(got, "config:"+e.Action) })
+
+	Publish(bus, MetricIngested, Ingested{Metric: models.Metric{DeviceID: "d1"}})
+	unsubscribe()
+	Publish(bus, MetricIngested, Ingested{Metric: models.Metric{DeviceID: "d2"}})
+	Publish(bus, ConfigChanged, audit.Event{Action: "runtime.update"})
+
+	want := []string{"first:d1", "second:d1", "first:d2", "config:runtime.update"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if n := Subscribers(bus, MetricIngested); n != 1 {
+		t.Errorf("Expected 1 subscriber after unsubscribing, got %d", n)
+	}
+}
+
+func TestBus_RecoversSubscriberPanics(t *testing.T) {
+	bus := New()
+	delivered := 0
+	Subscribe(bus, ConfigChanged, func(audit.Event) { panic("broken subscriber") })
+	Subscribe(bus, ConfigChanged, func(audit.Event) { delivered++ })
+
+	Publish(bus, ConfigChanged, audit.Event{Action: "flags.update"})
+	if delivered != 1 {
+		t.Errorf("Expected the event delivered past a panicking subscriber, got %d", delivered)
+	}
+}
+
+func TestBus_NilBusIgnoresEvents(t *testing.T) {
+	var bus *Bus
+	Publish(bus, MetricIngested, Ingested{})
+	// Topics without subscribers are a no-op as well
+	Publish(New(), MetricIngested, Ingested{})
+}
+
+func BenchmarkPublish(b *testing.B) {
+	bus := New()
+	Subscribe(bus, MetricIngested, func(Ingested) {})
+	e := Ingested{Metric: models.Metric{DeviceID: "d1", CPU: 50, RPS: 100}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Publish(bus, MetricIngested, e)
+	}
+}
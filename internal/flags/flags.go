@@ -0,0 +1,237 @@
+// Package flags реализует легковесные feature-флаги. Флаги задаются в конфигурации
+// и могут переопределяться документом в Redis, который периодически перечитывается,
+// поэтому рискованные возможности включаются и выключаются без передеплоя.
+// Флаг вычисляется для конкретного тенанта и устройства
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+)
+
+// TenantHeader заголовок с идентификатором тенанта
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultRedisKey ключ Redis с документом переопределений
+const DefaultRedisKey = "flags:overrides"
+
+// Имена флагов, которыми управляются возможности сервиса
+const (
+	// BatchIngest прием пакетов метрик через POST /metrics/batch
+	BatchIngest = "batch_ingest"
+	// StreamIngest прием потока метрик через POST /metrics/stream
+	StreamIngest = "stream_ingest"
+)
+
+// Defaults значения флагов, действующие без конфигурации
+func Defaults() map[string]Flag {
+	return map[string]Flag{
+		BatchIngest:  {Enabled: true},
+		StreamIngest: {Enabled: true},
+	}
+}
+
+// Flag правила вычисления флага. Порядок проверки:
+// DisabledTenants -> Enabled -> Tenants/Devices -> Rollout
+type Flag struct {
+	// Enabled включает флаг для всех
+	Enabled bool `json:"enabled"`
+	// Rollout процент устройств (0..100), для которых флаг включен
+	Rollout int `json:"rollout,omitempty"`
+	// Tenants и Devices списки, для которых флаг включен всегда
+	Tenants []string `json:"tenants,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+	// DisabledTenants тенанты, для которых флаг выключен всегда
+	DisabledTenants []string `json:"disabled_tenants,omitempty"`
+}
+
+// Subject субъект, для которого вычисляется флаг. Пустые поля допустимы
+type Subject struct {
+	Tenant string
+	Device string
+}
+
+// Parse разбирает JSON-документ вида {"имя": {"enabled": true, ...}}
+func Parse(data string) (map[string]Flag, error) {
+	flags := make(map[string]Flag)
+	if data == "" {
+		return flags, nil
+	}
+	if err := json.Unmarshal([]byte(data), &flags); err != nil {
+		return nil, fmt.Errorf("invalid feature flags: %w", err)
+	}
+	return flags, validate(flags)
+}
+
+// validate проверяет значения флагов
+func validate(flags map[string]Flag) error {
+	for name, f := range flags {
+		if f.Rollout < 0 || f.Rollout > 100 {
+			return fmt.Errorf("flag %s: rollout must be within [0, 100], got %d", name, f.Rollout)
+		}
+	}
+	return nil
+}
+
+// Source источник JSON-документа переопределений (реализуется cache.Cache)
+type Source interface {
+	Get(key string, dest interface{}) error
+}
+
+// Set набор флагов: базовые значения из конфигурации и переопределения из Redis
+type Set struct {
+	mu        sync.RWMutex
+	base      map[string]Flag
+	overrides map[string]Flag
+}
+
+// New создает набор с базовыми значениями
+func New(base map[string]Flag) *Set {
+	return &Set{base: base, overrides: map[string]Flag{}}
+}
+
+// lookup возвращает флаг с учетом переопределений
+func (s *Set) lookup(name string) (Flag, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if f, ok := s.overrides[name]; ok {
+		return f, true
+	}
+	f, ok := s.base[name]
+	return f, ok
+}
+
+// Enabled вычисляет флаг для субъекта. Неизвестный флаг выключен.
+// Для nil Set действуют значения Defaults
+func (s *Set) Enabled(name string, subj Subject) bool {
+	var f Flag
+	var ok bool
+	if s == nil {
+		f, ok = Defaults()[name]
+	} else {
+		f, ok = s.lookup(name)
+	}
+	if !ok {
+		return false
+	}
+
+	if subj.Tenant != "" && contains(f.DisabledTenants, subj.Tenant) {
+		return false
+	}
+	if f.Enabled {
+		return true
+	}
+	if (subj.Tenant != "" && contains(f.Tenants, subj.Tenant)) ||
+		(subj.Device != "" && contains(f.Devices, subj.Device)) {
+		return true
+	}
+	if f.Rollout > 0 {
+		key := subj.Device
+		if key == "" {
+			key = subj.Tenant
+		}
+		return bucket(name, key) < f.Rollout
+	}
+	return false
+}
+
+// Snapshot возвращает действующие флаги
+func (s *Set) Snapshot() map[string]Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Flag, len(s.base)+len(s.overrides))
+	for name, f := range s.base {
+		out[name] = f
+	}
+	for name, f := range s.overrides {
+		out[name] = f
+	}
+	return out
+}
+
+// Refresh перечитывает переопределения из источника. Отсутствие ключа
+// сбрасывает переопределения; при ошибке действуют прежние значения
+func (s *Set) Refresh(src Source, key string) error {
+	overrides := make(map[string]Flag)
+	if err := src.Get(key, &overrides); err != nil && !errors.Is(err, cache.ErrNotFound) {
+		return err
+	}
+	if err := validate(overrides); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch периодически вызывает Refresh до отмены контекста
+func (s *Set) Watch(ctx context.Context, src Source, key string, interval time.Duration) {
+	if err := s.Refresh(src, key); err != nil {
+		log.Printf("Failed to load feature flag overrides: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Refresh(src, key)
+			// Логируем только смену состояния, чтобы недоступный Redis не засыпал лог
+			if err != nil && !failing {
+				log.Printf("Failed to refresh feature flag overrides, keeping previous values: %v", err)
+			} else if err == nil && failing {
+				log.Printf("Feature flag overrides refreshed")
+			}
+			failing = err != nil
+		}
+	}
+}
+
+// Require пропускает запрос, только если флаг включен для тенанта запроса;
+// иначе отвечает 404, как если бы эндпоинта не существовало
+func (s *Set) Require(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.Enabled(name, Subject{Tenant: r.Header.Get(TenantHeader)}) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Feature disabled: " + name})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bucket детерминированно относит субъект к одному из 100 сегментов;
+// имя флага входит в хеш, чтобы разные флаги раскатывались на разные устройства
+func bucket(name, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
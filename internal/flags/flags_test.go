@@ -0,0 +1,106 @@
+package flags
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func TestSet_EvaluationOrder(t *testing.T) {
+	s := New(map[string]Flag{
+		"on":      {Enabled: true, DisabledTenants: []string{"acme"}},
+		"listed":  {Tenants: []string{"beta"}, Devices: []string{"sensor-7"}},
+		"off":     {},
+		"rollout": {Rollout: 100},
+	})
+
+	cases := []struct {
+		flag string
+		subj Subject
+		want bool
+	}{
+		{"on", Subject{Tenant: "other"}, true},
+		{"on", Subject{Tenant: "acme"}, false},
+		{"listed", Subject{Tenant: "beta"}, true},
+		{"listed", Subject{Device: "sensor-7"}, true},
+		{"listed", Subject{Tenant: "other", Device: "sensor-1"}, false},
+		{"off", Subject{Tenant: "beta"}, false},
+		{"rollout", Subject{Device: "any"}, true},
+		{"unknown", Subject{}, false},
+	}
+	for _, c := range cases {
+		if got := s.Enabled(c.flag, c.subj); got != c.want {
+			t.Errorf("%s for %+v: expected %v, got %v", c.flag, c.subj, c.want, got)
+		}
+	}
+}
+
+func TestSet_RolloutIsStableAndProportional(t *testing.T) {
+	s := New(map[string]Flag{"new_detector": {Rollout: 30}})
+
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		subj := Subject{Device: "sensor-" + strconv.Itoa(i)}
+		first := s.Enabled("new_detector", subj)
+		if first != s.Enabled("new_detector", subj) {
+			t.Fatalf("Rollout must be deterministic for %+v", subj)
+		}
+		if first {
+			enabled++
+		}
+	}
+	if enabled < 2700 || enabled > 3300 {
+		t.Errorf("Expected ~30%% of devices enabled, got %d/10000", enabled)
+	}
+}
+
+func TestSet_RefreshOverridesAndResets(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	s := New(Defaults())
+
+	store.SetWithTTL(DefaultRedisKey, map[string]Flag{BatchIngest: {Enabled: false}}, time.Minute)
+	if err := s.Refresh(store, DefaultRedisKey); err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled(BatchIngest, Subject{}) {
+		t.Error("Expected Redis override to disable the flag")
+	}
+
+	// Once the override expires the configured value applies again
+	clk.Advance(2 * time.Minute)
+	if err := s.Refresh(store, DefaultRedisKey); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled(BatchIngest, Subject{}) {
+		t.Error("Expected configured value after override removal")
+	}
+
+	store.SetWithTTL(DefaultRedisKey, map[string]Flag{BatchIngest: {Rollout: 150}}, time.Minute)
+	if err := s.Refresh(store, DefaultRedisKey); err == nil {
+		t.Error("Expected invalid override to be rejected")
+	}
+	if !s.Enabled(BatchIngest, Subject{}) {
+		t.Error("Invalid override must keep previous values")
+	}
+}
+
+func TestRequire_HidesDisabledEndpoint(t *testing.T) {
+	s := New(map[string]Flag{"beta_api": {Tenants: []string{"beta"}}})
+	handler := s.Require("beta_api")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for tenant, want := range map[string]int{"beta": http.StatusOK, "other": http.StatusNotFound, "": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodGet, "/beta", nil)
+		req.Header.Set(TenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("tenant %q: expected %d, got %d", tenant, want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package groups аналитика на уровне групп устройств из реестра.
+//
+// У каждой группы собственные скользящие окна, в которые попадают метрики всех
+// ее устройств, поэтому стойку или площадку можно наблюдать как единое целое:
+// аномалия группы означает, что устройство выбилось из поведения своей группы
+package groups
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/clock"
+	"highload-service/internal/devices"
+	"highload-service/internal/models"
+)
+
+// ErrUnknownGroup группа не описана в реестре
+var ErrUnknownGroup = errors.New("unknown device group")
+
+// Stats статистика группы
+type Stats struct {
+	Group string `json:"group"`
+	// Devices количество устройств группы в реестре
+	Devices int `json:"devices"`
+	// ActiveDevices устройства, присылавшие метрики с момента запуска
+	ActiveDevices int                `json:"active_devices"`
+	Metrics       int64              `json:"metrics"`
+	Anomalies     int64              `json:"anomalies"`
+	RollingAvg    map[string]float64 `json:"rolling_avg"`
+	StdDev        map[string]float64 `json:"std_dev"`
+	LastSeen      *time.Time         `json:"last_seen,omitempty"`
+}
+
+// group окна и счетчики одной группы
+type group struct {
+	analyzer  *analytics.Analyzer
+	active    map[string]bool
+	metrics   int64
+	anomalies int64
+	lastSeen  time.Time
+}
+
+// Option настраивает Analytics
+type Option func(*Analytics)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(a *Analytics) {
+		a.clock = c
+	}
+}
+
+// WithAlerts передает аномалии групп в трекер, где их можно подтвердить и закрыть
+func WithAlerts(t *anomalies.Tracker) Option {
+	return func(a *Analytics) {
+		a.alerts = t
+	}
+}
+
+// Analytics окна и счетчики всех групп. Безопасен для конкурентного использования
+type Analytics struct {
+	registry *devices.Registry
+	detector analytics.DetectorConfig
+	clock    clock.Clock
+	alerts   *anomalies.Tracker
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+// New создает аналитику групп реестра с параметрами детектора detector
+func New(registry *devices.Registry, detector analytics.DetectorConfig, opts ...Option) *Analytics {
+	a := &Analytics{
+		registry: registry,
+		detector: detector,
+		clock:    clock.Real(),
+		groups:   make(map[string]*group),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Observe добавляет метрику в окна всех групп ее устройства
+func (a *Analytics) Observe(m models.Metric) {
+	for _, name := range a.registry.GroupsOf(m.DeviceID) {
+		g := a.group(name)
+		result := g.analyzer.AnalyzeSync(m)
+
+		a.mu.Lock()
+		g.active[m.DeviceID] = true
+		g.metrics++
+		if result.AnomalyDetected {
+			g.anomalies++
+		}
+		g.lastSeen = a.clock.Now().UTC()
+		a.mu.Unlock()
+
+		if a.alerts != nil {
+			a.alerts.RecordGroup(name, result)
+		}
+	}
+}
+
+// group возвращает состояние группы, создавая его при первой метрике
+func (a *Analytics) group(name string) *group {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	g, ok := a.groups[name]
+	if !ok {
+		g = &group{
+			analyzer: analytics.NewAnalyzer(1, analytics.WithDetectorConfig(a.detector), analytics.WithClock(a.clock)),
+			active:   make(map[string]bool),
+		}
+		a.groups[name] = g
+	}
+	return g
+}
+
+// Stats возвращает статистику группы
+func (a *Analytics) Stats(name string) (Stats, error) {
+	members := a.registry.Members(name)
+	if len(members) == 0 {
+		return Stats{}, ErrUnknownGroup
+	}
+
+	stats := Stats{
+		Group:      name,
+		Devices:    len(members),
+		RollingAvg: map[string]float64{"cpu": 0, "rps": 0},
+		StdDev:     map[string]float64{"cpu": 0, "rps": 0},
+	}
+
+	a.mu.Lock()
+	g, ok := a.groups[name]
+	if ok {
+		stats.ActiveDevices = len(g.active)
+		stats.Metrics = g.metrics
+		stats.Anomalies = g.anomalies
+		lastSeen := g.lastSeen
+		stats.LastSeen = &lastSeen
+	}
+	a.mu.Unlock()
+
+	if ok {
+		avgCPU, avgRPS, stdCPU, stdRPS := g.analyzer.GetStats("")
+		stats.RollingAvg = map[string]float64{"cpu": avgCPU, "rps": avgRPS}
+		stats.StdDev = map[string]float64{"cpu": stdCPU, "rps": stdRPS}
+	}
+	return stats, nil
+}
+
+// List возвращает статистику всех групп реестра в порядке имен
+func (a *Analytics) List() []Stats {
+	list := []Stats{}
+	for _, name := range a.registry.Groups() {
+		if stats, err := a.Stats(name); err == nil {
+			list = append(list, stats)
+		}
+	}
+	return list
+}
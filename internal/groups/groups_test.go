@@ -0,0 +1,53 @@
+package groups
+
+import (
+	"errors"
+	"testing"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/devices"
+	"highload-service/internal/models"
+)
+
+func TestAnalytics_GroupWindowsAndAlerts(t *testing.T) {
+	registry := devices.NewRegistry(
+		devices.Device{ID: "s1", Groups: []string{"rack-1", "site-msk"}},
+		devices.Device{ID: "s2", Groups: []string{"rack-1"}},
+		devices.Device{ID: "s3", Groups: []string{"rack-2"}},
+	)
+	var alerts []anomalies.Anomaly
+	tracker := anomalies.NewTracker(anomalies.WithNotifier(func(a anomalies.Anomaly) { alerts = append(alerts, a) }))
+	g := New(registry, analytics.DetectorConfig{WindowSize: 20, ZScoreThreshold: 3}, WithAlerts(tracker))
+
+	// Both rack-1 devices contribute to one baseline
+	for i := 0; i < 20; i++ {
+		g.Observe(models.Metric{DeviceID: []string{"s1", "s2"}[i%2], CPU: 40 + float64(i%3), RPS: 100})
+	}
+	g.Observe(models.Metric{DeviceID: "s2", CPU: 99, RPS: 100})
+	g.Observe(models.Metric{DeviceID: "unregistered", CPU: 99})
+
+	stats, err := g.Stats("rack-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Devices != 2 || stats.ActiveDevices != 2 || stats.Metrics != 21 || stats.Anomalies != 1 {
+		t.Errorf("Unexpected rack-1 stats %+v", stats)
+	}
+	if site, _ := g.Stats("site-msk"); site.Metrics != 10 || site.Devices != 1 {
+		t.Errorf("Unexpected site-msk stats %+v", site)
+	}
+	if idle, _ := g.Stats("rack-2"); idle.Metrics != 0 || idle.LastSeen != nil {
+		t.Errorf("Group without metrics should have empty stats, got %+v", idle)
+	}
+	if _, err := g.Stats("rack-9"); !errors.Is(err, ErrUnknownGroup) {
+		t.Errorf("Expected ErrUnknownGroup, got %v", err)
+	}
+
+	if len(alerts) != 1 || alerts[0].Group != "rack-1" || alerts[0].DeviceID != "" {
+		t.Errorf("Expected one group-level alert for rack-1, got %+v", alerts)
+	}
+	if len(g.List()) != 3 {
+		t.Errorf("Expected 3 groups, got %d", len(g.List()))
+	}
+}
@@ -0,0 +1,161 @@
+package grpcapi_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+	"highload-service/internal/grpcapi"
+	"highload-service/internal/handlers"
+	"highload-service/internal/models"
+	"highload-service/pkg/grpcclient"
+)
+
+// startServer serves the API over an in-memory connection; withLatest enables
+// GetLatest over the handler's cache
+func startServer(t *testing.T, withLatest bool, clientOpts ...grpcclient.Option) *grpcclient.Client {
+	t.Helper()
+	var opts []grpcapi.ServerOption
+	store := cache.NewMemoryCache(nil)
+	if withLatest {
+		opts = append(opts, grpcapi.WithLatest(store))
+	}
+	return serve(t, store, opts, []grpc.ServerOption{grpc.ForceServerCodec(grpcapi.Codec{})}, clientOpts...)
+}
+
+func serve(t *testing.T, store *cache.MemoryCache, opts []grpcapi.ServerOption, serverOpts []grpc.ServerOption, clientOpts ...grpcclient.Option) *grpcclient.Client {
+	t.Helper()
+	analyzer := analytics.NewAnalyzer(10, analytics.WithDeviceWindows(analytics.DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 10}))
+	t.Cleanup(analyzer.Stop)
+	handler := handlers.NewHandler(analyzer, store)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(serverOpts...)
+	grpcapi.NewServer(handler, analyzer, opts...).Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return grpcclient.NewClient(cc, clientOpts...)
+}
+
+func TestMetrics_SubmitAndQuery(t *testing.T) {
+	client := startServer(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.SubmitMetric(ctx, models.Metric{DeviceID: "d1", CPU: 40, RPS: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RollingAvgCPU != 40 || result.Timestamp.IsZero() {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	resp, err := client.SubmitBatch(ctx, []models.Metric{{DeviceID: "d1", CPU: 50, RPS: 100}, {CPU: -1}, {DeviceID: "d2", CPU: 10, RPS: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Processed != 2 || resp.Rejected != 1 || resp.Stats.CPU.Count != 2 || resp.Results[0].RollingAvgCPU != 45 {
+		t.Errorf("Unexpected batch response %+v", resp)
+	}
+
+	// Invalid metrics map to InvalidArgument
+	if _, err := client.SubmitMetric(ctx, models.Metric{CPU: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+	if _, err := client.SubmitBatch(ctx, nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an empty batch, got %v", err)
+	}
+
+	stats, err := client.GetStats(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Devices != 2 || stats.Count != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	device, err := client.GetStats(ctx, "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.AvgCPU != 45 || device.Count != 2 || device.Devices != 0 {
+		t.Errorf("Unexpected device stats %+v", device)
+	}
+	if _, err := client.GetStats(ctx, "unknown"); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+
+	// GetLatest is Unavailable without the cache option, like /metrics/latest without Redis
+	if _, err := client.GetLatest(ctx, 0); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable, got %v", err)
+	}
+	client = startServer(t, true)
+	if _, err := client.SubmitMetric(ctx, models.Metric{DeviceID: "d3", CPU: 1, RPS: 2}); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := client.GetLatest(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || latest[0].DeviceID != "d3" {
+		t.Errorf("Unexpected latest metrics %+v", latest)
+	}
+	if _, err := client.GetLatest(ctx, grpcapi.MaxLatestCount+1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a large count, got %v", err)
+	}
+}
+
+func TestAPIKeyInterceptor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverOpts := []grpc.ServerOption{grpc.ForceServerCodec(grpcapi.Codec{}), grpc.UnaryInterceptor(grpcapi.APIKeyInterceptor([]string{"secret"}))}
+
+	anonymous := serve(t, cache.NewMemoryCache(nil), nil, serverOpts)
+	if _, err := anonymous.SubmitMetric(ctx, models.Metric{CPU: 1, RPS: 2}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a key, got %v", err)
+	}
+	wrong := serve(t, cache.NewMemoryCache(nil), nil, serverOpts, grpcclient.WithAPIKey("guess"))
+	if _, err := wrong.GetStats(ctx, ""); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated with a wrong key, got %v", err)
+	}
+	client := serve(t, cache.NewMemoryCache(nil), nil, serverOpts, grpcclient.WithAPIKey("secret"))
+	if _, err := client.SubmitMetric(ctx, models.Metric{CPU: 1, RPS: 2}); err != nil {
+		t.Errorf("Expected the call with a valid key to pass, got %v", err)
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	r := 0.0
+	in := grpcapi.Stats{TotalMetrics: 7, AnomaliesCount: 2, AvgCPU: 45.5, StdDevRPS: 3, Count: 10, Devices: 4, Correlation: &r}
+	data, err := grpcapi.Codec{}.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out grpcapi.Stats
+	if err := (grpcapi.Codec{}).Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	// A zero correlation is present, unlike an undefined one
+	if out.Correlation == nil || *out.Correlation != 0 || out.TotalMetrics != 7 || out.AvgCPU != 45.5 || out.Devices != 4 {
+		t.Errorf("Round trip mismatch: %+v", out)
+	}
+	if err := (grpcapi.Codec{}).Unmarshal([]byte{0x0a, 0x05, 'a'}, &grpcapi.StatsRequest{}); err == nil {
+		t.Error("Expected an error for a truncated message")
+	}
+}
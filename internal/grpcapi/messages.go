@@ -0,0 +1,228 @@
+package grpcapi
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"highload-service/internal/codec"
+	"highload-service/internal/models"
+)
+
+// StatsRequest запрос GetStats; пустой DeviceID — статистика общих окон
+type StatsRequest struct {
+	DeviceID string
+}
+
+// Stats ответ GetStats: счетчики сервиса и статистика окон анализатора
+type Stats struct {
+	TotalMetrics     int64
+	AnomaliesCount   int64
+	DuplicateBatches int64
+	AvgCPU           float64
+	AvgRPS           float64
+	StdDevCPU        float64
+	StdDevRPS        float64
+	// Count количество значений в окне CPU
+	Count int64
+	// Devices количество устройств с собственными окнами; 0 для статистики устройства
+	Devices int64
+	// Correlation корреляция Пирсона CPU и RPS; nil, пока она не определена
+	Correlation *float64
+}
+
+// LatestRequest запрос GetLatest; Count 0 — DefaultLatestCount метрик
+type LatestRequest struct {
+	Count int32
+}
+
+// LatestResponse ответ GetLatest: последние сохраненные метрики
+type LatestResponse struct {
+	Metrics []models.Metric
+}
+
+// Codec кодек gRPC: сообщения в protobuf по схеме internal/codec/metrics.proto,
+// поэтому клиенты на любом языке генерируются из нее. Модели кодирует
+// codec.Protobuf, сообщения GetStats и GetLatest — этот пакет. Сервер
+// использует его для всех запросов: grpc.ForceServerCodec(grpcapi.Codec{})
+type Codec struct{}
+
+// Name имя кодека в content-subtype
+func (Codec) Name() string {
+	return "proto"
+}
+
+// Marshal кодирует сообщение
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *StatsRequest:
+		return appendString(nil, 1, m.DeviceID), nil
+	case *Stats:
+		return m.marshal(), nil
+	case *LatestRequest:
+		return appendVarint(nil, 1, uint64(m.Count)), nil
+	case *LatestResponse:
+		var b []byte
+		for i := range m.Metrics {
+			metric, err := codec.Protobuf.Marshal(&m.Metrics[i])
+			if err != nil {
+				return nil, err
+			}
+			b = protowire.AppendBytes(protowire.AppendTag(b, 1, protowire.BytesType), metric)
+		}
+		return b, nil
+	default:
+		return codec.Protobuf.Marshal(v)
+	}
+}
+
+// Unmarshal декодирует сообщение
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *StatsRequest:
+		*m = StatsRequest{}
+		return decodeFields(data, func(f field) (err error) {
+			if f.is(1, protowire.BytesType) {
+				m.DeviceID, err = f.string()
+			}
+			return err
+		})
+	case *Stats:
+		*m = Stats{}
+		return m.unmarshal(data)
+	case *LatestRequest:
+		*m = LatestRequest{}
+		return decodeFields(data, func(f field) error {
+			if f.is(1, protowire.VarintType) {
+				m.Count = int32(f.value)
+			}
+			return nil
+		})
+	case *LatestResponse:
+		*m = LatestResponse{}
+		return decodeFields(data, func(f field) error {
+			if !f.is(1, protowire.BytesType) {
+				return nil
+			}
+			var metric models.Metric
+			if err := codec.Protobuf.Unmarshal(f.bytes, &metric); err != nil {
+				return err
+			}
+			m.Metrics = append(m.Metrics, metric)
+			return nil
+		})
+	default:
+		return codec.Protobuf.Unmarshal(data, v)
+	}
+}
+
+func (s *Stats) marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(s.TotalMetrics))
+	b = appendVarint(b, 2, uint64(s.AnomaliesCount))
+	b = appendVarint(b, 3, uint64(s.DuplicateBatches))
+	b = appendDouble(b, 4, s.AvgCPU)
+	b = appendDouble(b, 5, s.AvgRPS)
+	b = appendDouble(b, 6, s.StdDevCPU)
+	b = appendDouble(b, 7, s.StdDevRPS)
+	b = appendVarint(b, 8, uint64(s.Count))
+	b = appendVarint(b, 9, uint64(s.Devices))
+	if s.Correlation != nil {
+		// optional: нулевая корреляция передается, отсутствие — нет
+		b = protowire.AppendFixed64(protowire.AppendTag(b, 10, protowire.Fixed64Type), math.Float64bits(*s.Correlation))
+	}
+	return b
+}
+
+func (s *Stats) unmarshal(data []byte) error {
+	ints := map[protowire.Number]*int64{1: &s.TotalMetrics, 2: &s.AnomaliesCount, 3: &s.DuplicateBatches, 8: &s.Count, 9: &s.Devices}
+	doubles := map[protowire.Number]*float64{4: &s.AvgCPU, 5: &s.AvgRPS, 6: &s.StdDevCPU, 7: &s.StdDevRPS}
+	return decodeFields(data, func(f field) error {
+		switch {
+		case f.typ == protowire.VarintType && ints[f.num] != nil:
+			*ints[f.num] = int64(f.value)
+		case f.typ == protowire.Fixed64Type && doubles[f.num] != nil:
+			*doubles[f.num] = math.Float64frombits(f.value)
+		case f.is(10, protowire.Fixed64Type):
+			r := math.Float64frombits(f.value)
+			s.Correlation = &r
+		}
+		return nil
+	})
+}
+
+// appendVarint дописывает целое поле; нулевое значение в proto3 не передается
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendFixed64(protowire.AppendTag(b, num, protowire.Fixed64Type), math.Float64bits(v))
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+}
+
+// field поле сообщения: value для varint и fixed64, bytes для length-delimited
+type field struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+// is сообщает, что поле имеет номер num и тип провода typ
+func (f field) is(num protowire.Number, typ protowire.Type) bool {
+	return f.num == num && f.typ == typ
+}
+
+// string копирует содержимое поля: Unmarshal не удерживает data
+func (f field) string() (string, error) {
+	if !utf8.Valid(f.bytes) {
+		return "", fmt.Errorf("field %d: string contains invalid UTF-8", f.num)
+	}
+	return string(f.bytes), nil
+}
+
+// decodeFields передает each поля сообщения data по порядку; поля других
+// типов провода пропускаются, как в сгенерированном коде
+func decodeFields(data []byte, each func(f field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+		if err := each(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// Package grpcapi gRPC-сервис приема и запросов для серверных производителей
+// метрик: те же операции, что POST /metrics, POST /metrics/batch, /analyze и
+// /metrics/latest, с типизированными клиентами и мультиплексированием HTTP/2.
+// Сервис highload.v1.Metrics и его сообщения описаны в
+// internal/codec/metrics.proto; обработчики регистрируются вручную, без
+// сгенерированного кода, как и в devicestream
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/counters"
+	"highload-service/internal/middleware"
+	"highload-service/internal/models"
+)
+
+const (
+	// ServiceName полное имя gRPC-сервиса
+	ServiceName = "highload.v1.Metrics"
+	// DefaultLatestCount сколько метрик возвращает GetLatest без count
+	DefaultLatestCount = 50
+	// MaxLatestCount наибольший count GetLatest
+	MaxLatestCount = 1000
+	// APIKeyMetadata ключ метаданных с ключом API, как заголовок X-API-Key HTTP
+	APIKeyMetadata = "x-api-key"
+)
+
+// Ingester прием метрик (реализуется handlers.Handler)
+type Ingester interface {
+	Ingest(m models.Metric) (models.AnalysisResult, error)
+	IngestBatch(metrics []models.Metric) models.BatchResponse
+}
+
+// Snapshots статистика окон (реализуется analytics.Analyzer)
+type Snapshots interface {
+	Snapshot() analytics.Snapshot
+	DeviceSnapshot(deviceID string) (analytics.Snapshot, bool)
+}
+
+// Totals глобальные счетчики сервиса (реализуется counters.Counters)
+type Totals interface {
+	Total(name string) (int64, error)
+}
+
+// Latest последние сохраненные метрики (реализуется cache.Cache)
+type Latest interface {
+	GetLatestMetrics(count int64) ([]models.Metric, error)
+}
+
+// Throttle приостановка приема при переполнении буферов (реализуется backpressure.Gate)
+type Throttle interface {
+	Wait(ctx context.Context) error
+}
+
+// ServerOption настраивает Server
+type ServerOption func(*Server)
+
+// WithTotals добавляет в GetStats глобальные счетчики метрик, аномалий и повторов пакетов
+func WithTotals(t Totals) ServerOption {
+	return func(s *Server) {
+		s.totals = t
+	}
+}
+
+// WithLatest включает GetLatest; без него метод отвечает Unavailable, как
+// /metrics/latest без Redis
+func WithLatest(l Latest) ServerOption {
+	return func(s *Server) {
+		s.latest = l
+	}
+}
+
+// WithThrottle задерживает SubmitMetric и SubmitBatch, пока throttle не
+// разрешит прием. Вызов ждет в пределах своего дедлайна, а не получает отказ
+func WithThrottle(t Throttle) ServerOption {
+	return func(s *Server) {
+		s.throttle = t
+	}
+}
+
+// Server реализация сервиса Metrics
+type Server struct {
+	ingest   Ingester
+	windows  Snapshots
+	totals   Totals
+	latest   Latest
+	throttle Throttle
+}
+
+// NewServer создает сервис
+func NewServer(ingest Ingester, windows Snapshots, opts ...ServerOption) *Server {
+	s := &Server{ingest: ingest, windows: windows}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register регистрирует сервис на gRPC-сервере. Сервер должен использовать
+// Codec: grpc.NewServer(grpc.ForceServerCodec(grpcapi.Codec{}))
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+// metricsServer тип обработчика для проверки при регистрации сервиса
+type metricsServer interface {
+	submitMetric(ctx context.Context, m *models.Metric) (interface{}, error)
+	submitBatch(ctx context.Context, b *models.MetricsBatch) (interface{}, error)
+	getStats(ctx context.Context, req *StatsRequest) (interface{}, error)
+	getLatest(ctx context.Context, req *LatestRequest) (interface{}, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*metricsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("SubmitMetric", metricsServer.submitMetric),
+		unary("SubmitBatch", metricsServer.submitBatch),
+		unary("GetStats", metricsServer.getStats),
+		unary("GetLatest", metricsServer.getLatest),
+	},
+	Metadata: "internal/codec/metrics.proto",
+}
+
+// unary описание унарного метода name: разбор запроса типа Req и вызов call
+// через перехватчики сервера
+func unary[Req any](name string, call func(metricsServer, context.Context, *Req) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(metricsServer), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}, handler)
+		},
+	}
+}
+
+func (s *Server) submitMetric(ctx context.Context, m *models.Metric) (interface{}, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	result, err := s.ingest.Ingest(*m)
+	if err != nil {
+		return nil, ingestError(err)
+	}
+	return &result, nil
+}
+
+// submitBatch принимает пакет целиком: некорректные метрики не прерывают
+// пакет и учитываются в rejected, как в POST /metrics/batch
+func (s *Server) submitBatch(ctx context.Context, b *models.MetricsBatch) (interface{}, error) {
+	if len(b.Metrics) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "batch contains no metrics")
+	}
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	resp := s.ingest.IngestBatch(b.Metrics)
+	return &resp, nil
+}
+
+func (s *Server) getStats(_ context.Context, req *StatsRequest) (interface{}, error) {
+	var stats Stats
+	snap := s.windows.Snapshot()
+	if req.DeviceID != "" {
+		var ok bool
+		if snap, ok = s.windows.DeviceSnapshot(req.DeviceID); !ok {
+			return nil, status.Errorf(codes.NotFound, "no windows for device %q", req.DeviceID)
+		}
+	} else {
+		stats.Devices = int64(snap.Devices)
+	}
+	stats.AvgCPU, stats.AvgRPS = snap.AvgCPU, snap.AvgRPS
+	stats.StdDevCPU, stats.StdDevRPS = snap.StdDevCPU, snap.StdDevRPS
+	stats.Count = int64(snap.Count)
+	if snap.HasCorrelation {
+		r := snap.Correlation
+		stats.Correlation = &r
+	}
+	if s.totals != nil {
+		// Недоступный Redis оставляет счетчики нулевыми, как в /stats
+		stats.TotalMetrics, _ = s.totals.Total(counters.MetricsTotal)
+		stats.AnomaliesCount, _ = s.totals.Total(counters.AnomaliesTotal)
+		stats.DuplicateBatches, _ = s.totals.Total(counters.DuplicateBatchesTotal)
+	}
+	return &stats, nil
+}
+
+func (s *Server) getLatest(_ context.Context, req *LatestRequest) (interface{}, error) {
+	count := int64(req.Count)
+	switch {
+	case count == 0:
+		count = DefaultLatestCount
+	case count < 0 || count > MaxLatestCount:
+		return nil, status.Errorf(codes.InvalidArgument, "count must be within [1, %d], got %d", MaxLatestCount, count)
+	}
+	if s.latest == nil {
+		return nil, status.Error(codes.Unavailable, "cache not available")
+	}
+	metrics, err := s.latest.GetLatestMetrics(count)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to get metrics: %v", err)
+	}
+	return &LatestResponse{Metrics: metrics}, nil
+}
+
+// APIKeyInterceptor пропускает только вызовы с одним из ключей keys в
+// метаданных x-api-key, как middleware auth HTTP; остальные получают Unauthenticated
+func APIKeyInterceptor(keys []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var key string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(APIKeyMetadata); len(values) > 0 {
+				key = values[0]
+			}
+		}
+		if !middleware.ValidAPIKey(key, keys) {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid API key")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// wait ждет разрешения приема; отмена или дедлайн вызова возвращаются статусом gRPC
+func (s *Server) wait(ctx context.Context) error {
+	if s.throttle == nil {
+		return nil
+	}
+	if err := s.throttle.Wait(ctx); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// ingestError статус gRPC ошибки приема: некорректная метрика — InvalidArgument,
+// остановка анализатора — Unavailable (клиент повторит на другом экземпляре)
+func ingestError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrInvalidMetric):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, analytics.ErrStopped):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"highload-service/internal/analytics"
+)
+
+// metricsHandlerAllocBudget is the allocation budget for one POST /metrics
+// call without cache, including JSON decoding and encoding of the response.
+// Before request buffers and encoders were pooled it was 9.
+const metricsHandlerAllocBudget = 2
+
+// batchHandlerAllocBudget is the allocation budget for one POST /metrics/batch
+// of 100 metrics without cache: decoded device IDs cost one allocation each.
+// Before pooling a batch of 100 took about 330.
+const batchHandlerAllocBudget = 110
+
+func TestAllocBudget_MetricsHandler(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Allocation budgets do not apply under the race detector")
+	}
+	h := NewHandler(analytics.NewAnalyzer(1), nil)
+	body := []byte(`{"timestamp":"2024-01-01T12:00:00Z","cpu":45.5,"rps":500,"device_id":"sensor-1"}`)
+	reader := bytes.NewReader(body)
+	req := httptest.NewRequest(http.MethodPost, "/metrics", reader)
+	rec := httptest.NewRecorder()
+
+	allocs := testing.AllocsPerRun(200, func() {
+		reader.Reset(body)
+		rec.Body.Reset()
+		h.MetricsHandler(rec, req)
+	})
+	if allocs > metricsHandlerAllocBudget {
+		t.Errorf("MetricsHandler allocates %.1f times per call, budget is %d", allocs, metricsHandlerAllocBudget)
+	}
+}
+
+// BenchmarkMetricsHandler measures one POST /metrics without cache:
+//
+//	go test ./internal/handlers -run '^$' -bench 'MetricsHandler' -benchmem
+func BenchmarkMetricsHandler(b *testing.B) {
+	h := NewHandler(analytics.NewAnalyzer(1), nil)
+	body := []byte(`{"timestamp":"2024-01-01T12:00:00Z","cpu":45.5,"rps":500,"device_id":"sensor-1"}`)
+	reader := bytes.NewReader(body)
+	req := httptest.NewRequest(http.MethodPost, "/metrics", reader)
+	rec := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(body)
+		rec.Body.Reset()
+		h.MetricsHandler(rec, req)
+	}
+}
+
+func TestAllocBudget_BatchMetricsHandler(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Allocation budgets do not apply under the race detector")
+	}
+	h := NewHandler(analytics.NewAnalyzer(1), nil)
+	body := batchBody(100)
+	reader := bytes.NewReader(body)
+	req := httptest.NewRequest(http.MethodPost, "/metrics/batch", reader)
+	rec := httptest.NewRecorder()
+
+	allocs := testing.AllocsPerRun(50, func() {
+		reader.Reset(body)
+		rec.Body.Reset()
+		h.BatchMetricsHandler(rec, req)
+	})
+	if allocs > batchHandlerAllocBudget {
+		t.Errorf("BatchMetricsHandler allocates %.1f times per batch, budget is %d", allocs, batchHandlerAllocBudget)
+	}
+}
+
+// batchBody builds a /metrics/batch body of n metrics from ten devices
+func batchBody(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"metrics":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"timestamp":"2024-01-01T12:00:00Z","cpu":%d,"rps":500,"device_id":"sensor-%d"}`, 40+i%20, i%10)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+// BenchmarkBatchMetricsHandler measures one POST /metrics/batch of 100 metrics
+// without cache; allocs/op are per batch
+func BenchmarkBatchMetricsHandler(b *testing.B) {
+	h := NewHandler(analytics.NewAnalyzer(1), nil)
+	body := batchBody(100)
+	reader := bytes.NewReader(body)
+	req := httptest.NewRequest(http.MethodPost, "/metrics/batch", reader)
+	rec := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(body)
+		rec.Body.Reset()
+		h.BatchMetricsHandler(rec, req)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/anomalies"
+)
+
+const (
+	anomalyAck     = "ack"
+	anomalyResolve = "resolve"
+)
+
+// AnomalyActionRequest тело POST /anomalies/{id}/ack и /resolve
+type AnomalyActionRequest struct {
+	// By кто выполнил действие; по умолчанию адрес клиента
+	By string `json:"by"`
+}
+
+// ListAnomaliesHandler обрабатывает GET /anomalies - аномалии от новых к старым,
+// с необязательным фильтром ?state=open|acked|resolved
+func (h *Handler) ListAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.anomalies == nil {
+		h.respondError(w, "Anomaly tracking is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var state anomalies.State
+	if v := r.URL.Query().Get("state"); v != "" {
+		s, err := anomalies.ParseState(v)
+		if err != nil {
+			h.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state = s
+	}
+	h.respondJSON(w, h.anomalies.List(state), http.StatusOK)
+}
+
+// AnomalyEventsResponse ответ GET /anomalies/events
+type AnomalyEventsResponse struct {
+	From   time.Time         `json:"from"`
+	To     time.Time         `json:"to"`
+	Count  int               `json:"count"`
+	Events []anomalies.Event `json:"events"`
+	// Truncated событий в периоде больше limit
+	Truncated bool `json:"truncated"`
+}
+
+// AnomalyEventsHandler обрабатывает GET /anomalies/events - события аномалий с
+// объяснениями от старых к новым. Период обнаружения задается from и to (RFC 3339)
+// или range до текущего момента (по умолчанию час), ?device оставляет одно устройство
+func (h *Handler) AnomalyEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.anomalyEvents == nil {
+		h.respondError(w, "Anomaly events are not enabled", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	from, to, err := parsePeriod(params, h.clock.Now(), time.Hour)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		h.respondError(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > anomalies.MaxEvents {
+			h.respondError(w, fmt.Sprintf("limit must be between 1 and %d", anomalies.MaxEvents), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, truncated, err := h.anomalyEvents.Query(anomalies.EventQuery{
+		From:     from,
+		To:       to,
+		DeviceID: params.Get("device"),
+		Limit:    limit,
+	})
+	if err != nil {
+		h.respondError(w, "Failed to read anomaly events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.respondJSON(w, AnomalyEventsResponse{
+		From:      from.UTC(),
+		To:        to.UTC(),
+		Count:     len(events),
+		Events:    events,
+		Truncated: truncated,
+	}, http.StatusOK)
+}
+
+// AnomalyTransitionHandler обрабатывает POST /anomalies/{id}/ack и /anomalies/{id}/resolve
+func (h *Handler) AnomalyTransitionHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.anomalies == nil {
+			h.respondError(w, "Anomaly tracking is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var req AnomalyActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			h.respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.By == "" {
+			req.By = r.RemoteAddr
+		}
+
+		transition := h.anomalies.Ack
+		if action == anomalyResolve {
+			transition = h.anomalies.Resolve
+		}
+		a, err := transition(mux.Vars(r)["id"], req.By)
+		switch {
+		case errors.Is(err, anomalies.ErrNotFound):
+			h.respondError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, anomalies.ErrInvalidTransition):
+			h.respondError(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.respondError(w, err.Error(), http.StatusInternalServerError)
+		default:
+			h.respondJSON(w, a, http.StatusOK)
+		}
+	}
+}
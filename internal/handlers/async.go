@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/dlq"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+// asyncPreference значение заголовка Prefer, запрашивающее асинхронную обработку (RFC 7240)
+const asyncPreference = "respond-async"
+
+// errQueueFull очередь анализатора заполнена, метрика не поставлена в очередь
+var errQueueFull = errors.New("analysis queue is full")
+
+// wantsAsync сообщает, запросил ли клиент асинхронный прием (?async=true или
+// Prefer: respond-async) и возможен ли он. Упорядочиванию и прореживанию
+// нужен результат анализа до сохранения, поэтому с ними запрос
+// обрабатывается синхронно, как разрешает RFC 7240
+func (h *Handler) wantsAsync(r *http.Request) bool {
+	if h.sequencer != nil || h.sampler != nil {
+		return false
+	}
+	// Синхронный прием не платит за разбор строки запроса
+	if r.URL.RawQuery != "" {
+		if v := r.URL.Query().Get("async"); v != "" {
+			async, _ := strconv.ParseBool(v)
+			return async
+		}
+	}
+	for _, v := range r.Header["Prefer"] {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), asyncPreference) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue проверяет метрику и ставит ее в очередь анализатора, не дожидаясь
+// анализа. Поставленная метрика сохраняется сразу или, если задан writes,
+// откладывается для сохранения пакетом. Результат анализа приходит из
+// analytics.Analyzer.GetResults в RecordAsync
+func (h *Handler) enqueue(metric models.Metric, writes *models.MetricsBatch, timings *stageTimings) error {
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = h.clock.Now()
+	}
+	start := time.Now()
+	err := metric.Validate()
+	timings.since(stageValidate, start)
+	if err != nil {
+		h.deadLetter(metric, dlq.ReasonValidation, err)
+		return err
+	}
+	if !h.analyzer.Submit(metric) {
+		return errQueueFull
+	}
+	metrics.MetricsReceived.Inc()
+	if writes != nil {
+		writes.Metrics = append(writes.Metrics, metric)
+	} else {
+		h.persist(metric, timings)
+	}
+	return nil
+}
+
+// RecordAsync обрабатывает результат анализа метрики, принятой асинхронно
+// (analytics.Analyzer.GetResults), как синхронный прием после анализа:
+// отложение, журнал, агрегаты, учет аномалий с оповещениями и сохранение
+// результата. Метрика не анализируется повторно
+func (h *Handler) RecordAsync(metric models.Metric, result models.AnalysisResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			h.deadLetter(metric, dlq.ReasonAnalysis, fmt.Errorf("recording analysis panicked: %v", p))
+		}
+	}()
+	h.record(metric, &result)
+	metrics.UpdateAnalysisMetrics(result.RollingAvgCPU, result.RollingAvgRPS, result.ZScoreCPU, result.ZScoreRPS, result.AnomalyDetected)
+	h.cacheResult(metric, result)
+	// Сами метрики учтены при постановке в очередь
+	if result.AnomalyDetected {
+		h.countIngested(0, 1)
+	}
+}
+
+// acceptAsync принимает одну метрику POST /metrics асинхронно
+func (h *Handler) acceptAsync(w http.ResponseWriter, r *http.Request, metric models.Metric, timings *stageTimings) {
+	err := h.enqueue(metric, nil, timings)
+	if debugTimings(r) {
+		w.Header().Set("Server-Timing", timings.serverTiming(1))
+	}
+	switch {
+	case errors.Is(err, models.ErrInvalidMetric):
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "400").Inc()
+		return
+	case err != nil:
+		// Метрика не принята, клиент повторит ее позже
+		w.Header().Set("Retry-After", h.asyncRetryAfter())
+		h.respondError(w, "Analysis queue is full", http.StatusTooManyRequests)
+		metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "429").Inc()
+		return
+	}
+	h.countIngested(1, 0)
+	h.respondAccepted(w, r, "/metrics", models.AsyncAccepted{IngestID: newIngestID(), Queued: 1})
+}
+
+// acceptBatchAsync принимает пакет асинхронно. Метрики, не поместившиеся в
+// очередь, уходят в очередь недоставленных: ответ 202 клиент не повторяет
+func (h *Handler) acceptBatchAsync(w http.ResponseWriter, r *http.Request, batch *models.MetricsBatch, timings *stageTimings) {
+	writes := getBatch()
+	defer putBatch(writes)
+
+	accepted := models.AsyncAccepted{IngestID: newIngestID()}
+	for _, metric := range batch.Metrics {
+		err := h.enqueue(metric, writes, timings)
+		if errors.Is(err, errQueueFull) {
+			h.deadLetter(metric, dlq.ReasonAnalysis, err)
+		}
+		if err != nil {
+			accepted.Rejected++
+			continue
+		}
+		accepted.Queued++
+	}
+	h.persistBatch(writes.Metrics, timings)
+	h.countIngested(accepted.Queued, 0)
+	if debugTimings(r) {
+		w.Header().Set("Server-Timing", timings.serverTiming(len(batch.Metrics)))
+	}
+	h.respondAccepted(w, r, "/metrics/batch", accepted)
+}
+
+// respondAccepted отвечает 202 с идентификатором приема в формате из Accept
+func (h *Handler) respondAccepted(w http.ResponseWriter, r *http.Request, endpoint string, accepted models.AsyncAccepted) {
+	w.Header().Set("Preference-Applied", asyncPreference)
+	w.Header().Set("X-Ingest-ID", accepted.IngestID)
+	metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "202").Inc()
+	h.respondEncoded(w, negotiate(w, r), &accepted, http.StatusAccepted)
+}
+
+// asyncRetryAfter значение Retry-After при заполненной очереди
+func (h *Handler) asyncRetryAfter() string {
+	if h.retryAfter != "" {
+		return h.retryAfter
+	}
+	return "1"
+}
+
+// newIngestID случайный идентификатор приема
+func newIngestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
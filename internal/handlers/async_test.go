@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/codec"
+	"highload-service/internal/dlq"
+	"highload-service/internal/journal"
+	"highload-service/internal/models"
+	"highload-service/internal/sequencer"
+	"highload-service/internal/snooze"
+)
+
+func TestMetricsHandler_AsyncQueuesWithoutAnalysis(t *testing.T) {
+	// Workers are not started, so queued metrics stay in the queue
+	analyzer := analytics.NewAnalyzer(10)
+	store := cache.NewMemoryCache(nil)
+	h := NewHandler(analyzer, store)
+
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics?async=true",
+		strings.NewReader(`{"cpu":45.5,"rps":500,"device_id":"sensor-1"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var accepted models.AsyncAccepted
+	json.Unmarshal(rec.Body.Bytes(), &accepted)
+	if accepted.Queued != 1 || len(accepted.IngestID) != 32 || rec.Header().Get("X-Ingest-ID") != accepted.IngestID {
+		t.Errorf("Unexpected response %+v, headers %v", accepted, rec.Header())
+	}
+	if analyzer.QueueLength() != 1 || analyzer.Samples() != 0 {
+		t.Errorf("Expected the metric queued but not analyzed, queue %d", analyzer.QueueLength())
+	}
+	if latest, _ := store.GetLatestMetrics(10); len(latest) != 1 {
+		t.Errorf("Expected the queued metric to be stored, got %+v", latest)
+	}
+
+	rec = httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics?async=true", strings.NewReader(`{"cpu":-1,"rps":5}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid metric, got %d", rec.Code)
+	}
+
+	// async=false keeps the synchronous response
+	rec = httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics?async=false", strings.NewReader(`{"cpu":1,"rps":5}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 without async, got %d", rec.Code)
+	}
+
+	// The 202 body follows Accept like the synchronous response
+	for _, c := range []codec.Codec{codec.Protobuf, codec.MessagePack} {
+		req := httptest.NewRequest(http.MethodPost, "/metrics?async=true", strings.NewReader(`{"cpu":1,"rps":5}`))
+		req.Header.Set("Accept", c.ContentType())
+		rec = httptest.NewRecorder()
+		h.MetricsHandler(rec, req)
+		var decoded models.AsyncAccepted
+		if err := c.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || decoded.Queued != 1 || decoded.IngestID != rec.Header().Get("X-Ingest-ID") {
+			t.Errorf("Expected a %s 202 body, got %+v (err %v)", c.Name(), decoded, err)
+		}
+	}
+}
+
+func TestBatchMetricsHandler_AsyncReportsQueuedAndRejected(t *testing.T) {
+	analyzer := analytics.NewAnalyzer(2)
+	deadLetters := dlq.New(cache.NewMemoryCache(nil))
+	h := NewHandler(analyzer, nil, WithDeadLetters(deadLetters))
+
+	body := `{"metrics":[{"cpu":1,"rps":1},{"cpu":-1,"rps":1},{"cpu":2,"rps":2},{"cpu":3,"rps":3}]}`
+	req := httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body))
+	req.Header.Set("Prefer", "wait=5, respond-async")
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, req)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Preference-Applied") != "respond-async" {
+		t.Fatalf("Expected 202 with the preference applied, got %d %v", rec.Code, rec.Header())
+	}
+	var accepted models.AsyncAccepted
+	json.Unmarshal(rec.Body.Bytes(), &accepted)
+	// The queue holds two metrics: the invalid one and the one over capacity are rejected
+	if accepted.Queued != 2 || accepted.Rejected != 2 {
+		t.Errorf("Expected 2 queued and 2 rejected, got %+v", accepted)
+	}
+	if entries, _ := deadLetters.List(dlq.ReasonAnalysis, 10); len(entries) != 1 || entries[0].Metric.CPU != 3 {
+		t.Errorf("Expected the metric over capacity dead-lettered, got %+v", entries)
+	}
+
+	// Single metrics are refused while the queue is full, so the client retries them
+	rec = httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics?async=1", strings.NewReader(`{"cpu":1,"rps":5}`)))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After on a full queue, got %d", rec.Code)
+	}
+}
+
+func TestMetricsHandler_AsyncFallsBackWhenSequencing(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(10), nil,
+		WithSequencer(sequencer.New(sequencer.Config{Mode: sequencer.ModeFlag, Buffer: 4, MaxWait: time.Minute})))
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(`{"cpu":1,"rps":5,"device_id":"d1","seq":1}`))
+	req.Header.Set("Prefer", "respond-async")
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Preference-Applied") != "" {
+		t.Errorf("Expected a synchronous response while sequencing, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRecordAsync_ReachesSinks(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	analyzer := analytics.NewAnalyzer(100, analytics.WithDetectorConfig(analytics.DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}))
+	tracker := anomalies.NewTracker(anomalies.WithClock(clk))
+	results := journal.New(cache.NewMemoryCache(nil))
+	snoozes := snooze.New(clk)
+	snoozes.Snooze("quiet", time.Hour)
+	h := NewHandler(analyzer, nil, WithClock(clk), WithAnomalies(tracker), WithJournal(results), WithSnoozes(snoozes))
+
+	var body strings.Builder
+	body.WriteString(`{"metrics":[`)
+	for _, device := range []string{"quiet", "loud"} {
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&body, `{"device_id":%q,"cpu":%d,"rps":100},`, device, 40+i%2)
+		}
+		fmt.Fprintf(&body, `{"device_id":%q,"cpu":95,"rps":100},`, device)
+	}
+	batch := strings.TrimSuffix(body.String(), ",") + "]}"
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch?async=true", strings.NewReader(batch)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The server hands every queued result to RecordAsync
+	analyzer.Start(1)
+	defer analyzer.Stop()
+	for i := 0; i < 22; i++ {
+		select {
+		case analyzed := <-analyzer.GetResults():
+			h.RecordAsync(analyzed.Metric, analyzed.Result)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for result %d", i)
+		}
+	}
+
+	if entries, _, _ := results.Read("", 100, false); len(entries) != 22 {
+		t.Errorf("Expected 22 journal entries, got %d", len(entries))
+	}
+	// The spike of the snoozed device is not tracked
+	if list := tracker.List(""); len(list) != 1 || list[0].DeviceID != "loud" {
+		t.Errorf("Expected one anomaly of the loud device, got %+v", list)
+	}
+}
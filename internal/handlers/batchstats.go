@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/models"
+)
+
+// fieldAccumulator среднее и дисперсия поля по Уэлфорду: один проход без хранения значений
+type fieldAccumulator struct {
+	n        int
+	mean, m2 float64
+	min, max float64
+}
+
+// add учитывает значение; как и окна анализатора, пропускает значения больше
+// analytics.MaxAbsValue, на которых дисперсия переполнилась бы
+func (a *fieldAccumulator) add(v float64) {
+	if !analytics.IsValidValue(v) {
+		return
+	}
+	if a.n == 0 {
+		a.min, a.max = v, v
+	}
+	a.n++
+	delta := v - a.mean
+	a.mean += delta / float64(a.n)
+	a.m2 += delta * (v - a.mean)
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+}
+
+// stats возвращает статистику; стандартное отклонение выборочное, как у окон анализатора
+func (a *fieldAccumulator) stats() models.FieldStats {
+	s := models.FieldStats{Count: a.n, Mean: a.mean, Min: a.min, Max: a.max}
+	if a.n > 1 {
+		s.StdDev = math.Sqrt(a.m2 / float64(a.n-1))
+	}
+	return s
+}
+
+// batchStats собирает статистику принятых метрик пакета по мере обработки
+type batchStats struct {
+	cpu, rps  fieldAccumulator
+	values    map[string]*fieldAccumulator
+	anomalies []int
+}
+
+// add учитывает принятую метрику. Именованных показателей учитывается
+// не больше analytics.MaxNamedWindows, как и в окнах анализатора
+func (b *batchStats) add(metric models.Metric) {
+	b.cpu.add(metric.CPU)
+	b.rps.add(metric.RPS)
+	for name, v := range metric.Values {
+		acc, ok := b.values[name]
+		if !ok {
+			if len(b.values) >= analytics.MaxNamedWindows {
+				continue
+			}
+			if b.values == nil {
+				b.values = make(map[string]*fieldAccumulator)
+			}
+			acc = &fieldAccumulator{}
+			b.values[name] = acc
+		}
+		acc.add(v)
+	}
+}
+
+// anomaly отмечает аномалию в метрике с позицией index в пакете
+func (b *batchStats) anomaly(index int) {
+	b.anomalies = append(b.anomalies, index)
+}
+
+// sequenceKey метрика устройства с номером
+type sequenceKey struct {
+	device string
+	seq    uint64
+}
+
+// result возвращает статистику для ответа
+func (b *batchStats) result() models.BatchStats {
+	// Задержанные упорядочиванием метрики анализируются позже следующих за ними
+	sort.Ints(b.anomalies)
+	s := models.BatchStats{CPU: b.cpu.stats(), RPS: b.rps.stats(), AnomalyIndices: b.anomalies}
+	if s.AnomalyIndices == nil {
+		s.AnomalyIndices = []int{}
+	}
+	if len(b.values) > 0 {
+		s.Values = make(map[string]models.FieldStats, len(b.values))
+		for name, acc := range b.values {
+			s.Values[name] = acc.stats()
+		}
+	}
+	return s
+}
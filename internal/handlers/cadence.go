@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"highload-service/internal/cadence"
+)
+
+// CadenceHandler обрабатывает GET /cadence - перцентили интервалов отчетности
+// парка и устройства, нарушающие ожидаемую частоту (limit, по умолчанию 10;
+// 0 — все), или интервалы одного устройства (device)
+func (h *Handler) CadenceHandler(w http.ResponseWriter, r *http.Request) {
+	if h.cadence == nil {
+		h.respondError(w, "Reporting cadence tracking is not configured", http.StatusNotFound)
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.respondError(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	fleet, devices := h.cadence.Report(limit)
+	if id := r.URL.Query().Get("device"); id != "" {
+		d, err := h.cadence.Device(id)
+		if errors.Is(err, cadence.ErrUnknownDevice) {
+			h.respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		devices = []cadence.Device{d}
+	}
+	h.respondJSON(w, map[string]interface{}{"fleet": fleet, "devices": devices}, http.StatusOK)
+}
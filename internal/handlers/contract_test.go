@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/admin"
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/audit"
+	"highload-service/internal/availability"
+	"highload-service/internal/cache"
+	"highload-service/internal/cadence"
+	"highload-service/internal/clock"
+	"highload-service/internal/devices"
+	"highload-service/internal/devicestate"
+	"highload-service/internal/devicestream"
+	"highload-service/internal/dlq"
+	"highload-service/internal/groups"
+	"highload-service/internal/incidents"
+	"highload-service/internal/journal"
+	"highload-service/internal/loglevel"
+	"highload-service/internal/models"
+	"highload-service/internal/quota"
+	"highload-service/internal/regions"
+	"highload-service/internal/replay"
+	"highload-service/internal/rollup"
+	"highload-service/internal/score"
+	"highload-service/internal/snooze"
+)
+
+// contractAdminToken authenticates requests to /admin/* unless a case is anonymous.
+const contractAdminToken = "admin-token"
+
+// contractCase is an extra request exercised on top of the spec examples,
+// typically to cover documented error responses.
+type contractCase struct {
+	method, path, body string
+	contentType        string
+	wantStatus         int
+	anonymous          bool
+}
+
+var contractCases = []contractCase{
+	{method: http.MethodPost, path: "/metrics", body: `{"cpu":`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/metrics/batch", body: `[]`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/metrics", body: "metrics cpu=45.5,rps=500", contentType: "text/plain", wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/metrics/batch", body: "metrics cpu=1,rps=2\nmetrics cpu=3", contentType: "text/plain", wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/metrics", body: `<metric/>`, contentType: "application/xml", wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/metrics", body: `{"cpu":45.5,"rps":500}`, contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/metrics/batch", body: `{"metrics":[{"cpu":1,"rps":2}]}`, contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/metrics?async=true", body: `{"cpu":45.5,"rps":500}`, wantStatus: http.StatusAccepted},
+	{method: http.MethodPost, path: "/metrics/batch?async=true", body: `{"metrics":[{"cpu":1,"rps":2},{"cpu":-1,"rps":2}]}`, wantStatus: http.StatusAccepted},
+	{method: http.MethodPost, path: "/metrics/stream", body: "{\"cpu\":1,\"rps\":2}\n{\"cpu\":-1,\"rps\":2}\nnot json", contentType: NDJSONContentType, wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/metrics/stream?summary=true", body: "{\"cpu\":1,\"rps\":2}\n", contentType: NDJSONContentType, wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/metrics/stream", body: `{"cpu":1,"rps":2}`, contentType: "application/json", wantStatus: http.StatusUnsupportedMediaType},
+	{method: http.MethodGet, path: "/metrics/latest?count=5", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/metrics/latest?count=5&with_analysis=true", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/metrics/latest?with_analysis=maybe", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/analyze?device=sensor-1", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/analyze?device=missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodGet, path: "/series?metric=rps&range=6h&resolution=1m", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/series?resolution=1m&range=30d", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/query?q=avg_over_time(cpu%5B5m%5D)%20by%20(device)&range=1h", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/query?q=avg(cpu)", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/quantiles?metric=rps&q=0.5,0.999&range=30m", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/quantiles?q=1.5", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/quantiles?metric=missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodGet, path: "/anomalies?state=open", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/anomalies/events?device=sensor-1&range=1h", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/anomalies/events?limit=0", wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/anomalies/missing/ack", wantStatus: http.StatusNotFound},
+	{method: http.MethodGet, path: "/devices/sensor-1", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/devices/missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodPost, path: "/devices/sensor-1/snooze?duration=2h", wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/devices/sensor-1/snooze?duration=-1h", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/stats", wantStatus: http.StatusOK},
+	{method: http.MethodDelete, path: "/devices/sensor-1/snooze", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/groups/rack-1/stats", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/regions", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/score?device=sensor-1", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/score?device=missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodGet, path: "/score?limit=-1", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/cadence", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/cadence?device=sensor-3", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/cadence?device=missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodGet, path: "/cadence?limit=-1", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/incidents?active=true&limit=5", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/incidents?active=maybe", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/incidents/1704067200-1", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/journal?anomalies=true&limit=10", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/journal?cursor=latest", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/forecast?metric=rps&season=0", wantStatus: http.StatusUnprocessableEntity},
+	{method: http.MethodGet, path: "/forecast?horizon=0", wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/admin/runtime", anonymous: true, wantStatus: http.StatusUnauthorized},
+	{method: http.MethodPost, path: "/admin/devices/bulk", anonymous: true, wantStatus: http.StatusUnauthorized},
+	{method: http.MethodPatch, path: "/admin/runtime", body: `{"workers":0}`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/admin/dlq?reason=validation&limit=10", wantStatus: http.StatusOK},
+	{method: http.MethodGet, path: "/admin/dlq?reason=unknown", wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/admin/dlq/requeue", body: `{"ids":["missing"]}`, wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/replay", body: `{"from":"2024-01-02T00:00:00Z","to":"2024-01-01T00:00:00Z"}`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodGet, path: "/admin/replay/missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodPut, path: "/admin/streams/sensor-1/config", body: `{"reporting_interval_ms":0}`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/admin/windows/reset?device=sensor-1", wantStatus: http.StatusOK},
+	{method: http.MethodPost, path: "/admin/windows/reset?device=missing", wantStatus: http.StatusNotFound},
+	{method: http.MethodPost, path: "/admin/windows/seed", body: `{"cpu":[1],"rps":[]}`, wantStatus: http.StatusBadRequest},
+	{method: http.MethodPost, path: "/admin/devices/bulk", body: "{\"op\":\"register\",\"id\":\"sensor-9\",\"groups\":[\"rack-1\"]}\n{\"op\":\"snooze\",\"id\":\"sensor-9\",\"duration\":\"2h\"}\n{\"op\":\"tag\",\"id\":\"missing\",\"groups\":[\"rack-2\"]}\n", contentType: NDJSONContentType, wantStatus: http.StatusOK},
+}
+
+func newContractRouter(t *testing.T) (*mux.Router, map[string]interface{}) {
+	t.Helper()
+
+	experiment := analytics.NewExperiment(analytics.DefaultDetectorConfig(),
+		analytics.DetectorConfig{WindowSize: 200, ZScoreThreshold: 3}, 10)
+	t.Cleanup(experiment.Stop)
+	correlator := incidents.New(incidents.WithClock(clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))))
+	tracker := anomalies.NewTracker(anomalies.WithNotifier(correlator.Observe))
+	tracker.Record(models.Metric{DeviceID: "sensor-1"}, models.AnalysisResult{AnomalyDetected: true, ZScoreCPU: 4})
+	registry := devices.NewRegistry(devices.Device{ID: "sensor-2", Groups: []string{"rack-1"}})
+	analyzer := analytics.NewAnalyzer(1, analytics.WithDeviceWindows(analytics.DeviceWindowsConfig{IdleTTL: time.Hour, MaxDevices: 10}))
+	analyzer.AnalyzeSync(models.Metric{DeviceID: "sensor-1", CPU: 40, RPS: 100})
+	results := journal.New(cache.NewMemoryCache(nil))
+	results.Append(models.Metric{DeviceID: "sensor-1"}, models.AnalysisResult{AnomalyDetected: true, ZScoreCPU: 4})
+	events := anomalies.NewEvents(cache.NewMemoryCache(nil))
+	events.Record(models.Metric{DeviceID: "sensor-1", CPU: 95, Values: map[string]float64{"temp": 80}},
+		models.AnalysisResult{AnomalyDetected: true, Episode: models.EpisodeOpened, ZScoreCPU: 4, IsAnomalyCPU: true,
+			Values: map[string]models.ValueResult{"temp": {ZScore: 3, IsAnomaly: true}}})
+	deviceState := devicestate.New(cache.NewMemoryCache(nil), 0)
+	deviceState.Observe(models.Metric{DeviceID: "sensor-1", Timestamp: time.Now()}, models.AnalysisResult{AnomalyDetected: true})
+	uptime := availability.New(cache.NewMemoryCache(nil), "node-1")
+	uptime.Check(true)
+	h := NewHandler(analyzer, cache.NewMemoryCache(nil),
+		WithExperiment(experiment),
+		WithRollup(rollup.New()),
+		WithQuantiles(analytics.NewQuantiles(analytics.DefaultQuantileConfig())),
+		WithAnomalies(tracker),
+		WithAnomalyEvents(events),
+		WithGroups(groups.New(registry, analytics.DefaultDetectorConfig(), groups.WithAlerts(tracker))),
+		WithRegions(regions.New(100)),
+		WithScorer(score.New()),
+		WithCadence(cadence.New(cadence.Config{ExpectedInterval: time.Nanosecond, Tolerance: 1, MaxDevices: 10})),
+		WithIncidents(correlator),
+		WithJournal(results),
+		WithSnoozes(snooze.New(nil)),
+		WithDeviceState(deviceState),
+		WithAvailability(uptime),
+	)
+	if _, err := h.Ingest(models.Metric{DeviceID: "sensor-3", CPU: 40, RPS: 100}); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	router := mux.NewRouter()
+	h.RegisterRoutes(router)
+
+	// The admin API is served by the same router and documented in the same spec
+	deadLetters := dlq.New(cache.NewMemoryCache(nil))
+	deadLetters.Add(models.Metric{DeviceID: "sensor-1", CPU: -1, Timestamp: time.Now()}, dlq.ReasonValidation, errors.New("cpu must be non-negative"))
+	metricsArchive := cache.NewMemoryCache(nil)
+	admin.NewHandler(contractAdminToken, analyzer, audit.New(nil, nil, 10),
+		admin.WithRateLimiter(quota.NewLimiter(nil, nil, quota.Limits{RollingWindow: time.Minute})),
+		admin.WithDetectorRollout(analytics.NewCanary(analyzer, experiment)),
+		admin.WithReplay(replay.New(metricsArchive, metricsArchive)),
+		admin.WithDeadLetters(deadLetters, h.Reprocess),
+		admin.WithDeviceStreams(devicestream.NewHub(devicestream.NewDeviceConfig(time.Second))),
+		admin.WithWindows(analyzer),
+		admin.WithDevices(registry, snooze.New(nil)),
+	).RegisterRoutes(router)
+	t.Cleanup(func() { loglevel.Set(loglevel.Info) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json returned %d", rec.Code)
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Served spec is not valid JSON: %v", err)
+	}
+	return router, spec
+}
+
+func TestContract_RoutesMatchSpec(t *testing.T) {
+	router, spec := newContractRouter(t)
+
+	documented := make(map[string]bool)
+	for path, item := range spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	registered := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		// Subrouter prefixes such as /admin only group the routes below them
+		if route.GetHandler() == nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			registered[m+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk router: %v", err)
+	}
+
+	for op := range registered {
+		if !documented[op] {
+			t.Errorf("Route %s is not documented in openapi.json", op)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			t.Errorf("Operation %s is documented but not registered", op)
+		}
+	}
+}
+
+func TestContract_ResponsesMatchSpec(t *testing.T) {
+	router, spec := newContractRouter(t)
+
+	var cases []contractCase
+	for path, item := range spec["paths"].(map[string]interface{}) {
+		for method, op := range item.(map[string]interface{}) {
+			c := contractCase{method: strings.ToUpper(method), path: path}
+			if example, ok := lookup(op, "requestBody", "content", "application/json", "example"); ok {
+				body, _ := json.Marshal(example)
+				c.body = string(body)
+			}
+			cases = append(cases, c)
+		}
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].method+cases[i].path < cases[j].method+cases[j].path })
+	cases = append(cases, contractCases...)
+
+	for _, c := range cases {
+		t.Run(c.method+" "+c.path, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			if strings.HasPrefix(c.path, "/admin/") && !c.anonymous {
+				req.Header.Set("Authorization", "Bearer "+contractAdminToken)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if c.wantStatus != 0 && rec.Code != c.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", c.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			// Paths with parameters are looked up by the template of the matched route
+			template := strings.SplitN(c.path, "?", 2)[0]
+			var match mux.RouteMatch
+			if router.Match(req, &match) {
+				template, _ = match.Route.GetPathTemplate()
+			}
+			op, _ := lookup(spec, "paths", template, strings.ToLower(c.method))
+			response, ok := lookup(op, "responses", strconv.Itoa(rec.Code))
+			if !ok {
+				t.Fatalf("Status %d is not documented", rec.Code)
+			}
+			response = resolve(spec, response)
+			// NDJSON responses are checked line by line against the schema of one line
+			mediaType := "application/json"
+			if strings.HasPrefix(rec.Header().Get("Content-Type"), NDJSONContentType) {
+				mediaType = NDJSONContentType
+			}
+			schema, ok := lookup(response, "content", mediaType, "schema")
+			if !ok {
+				t.Fatalf("Status %d has no %s schema", rec.Code, mediaType)
+			}
+
+			dec := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+			dec.UseNumber()
+			for n := 0; ; n++ {
+				var body interface{}
+				err := dec.Decode(&body)
+				if errors.Is(err, io.EOF) && n > 0 {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Response is not JSON: %v", err)
+				}
+				if mediaType != NDJSONContentType {
+					for _, problem := range validateSchema(spec, schema, body, "$") {
+						t.Error(problem)
+					}
+					break
+				}
+				for _, problem := range validateSchema(spec, schema, body, fmt.Sprintf("line %d: $", n+1)) {
+					t.Error(problem)
+				}
+			}
+		})
+	}
+}
+
+// lookup walks nested JSON objects by keys.
+func lookup(node interface{}, keys ...string) (interface{}, bool) {
+	for _, k := range keys {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// resolve follows a local "$ref" if the node has one.
+func resolve(spec, node interface{}) interface{} {
+	ref, ok := lookup(node, "$ref")
+	if !ok {
+		return node
+	}
+	keys := strings.Split(strings.TrimPrefix(ref.(string), "#/"), "/")
+	target, _ := lookup(spec, keys...)
+	return target
+}
+
+// validateSchema checks value against the subset of JSON Schema used in
+// openapi.json. Objects with declared properties reject undocumented fields,
+// so adding a response field without updating the spec fails the test.
+func validateSchema(spec, schemaNode, value interface{}, path string) []string {
+	schema, _ := resolve(spec, schemaNode).(map[string]interface{})
+	if schema == nil {
+		return []string{fmt.Sprintf("%s: unresolvable schema", path)}
+	}
+
+	var problems []string
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v not in enum %v", path, value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected object, got %T", path, value))
+		}
+		props, hasProps := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := obj[r.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required field %q", path, r))
+				}
+			}
+		}
+		for k, v := range obj {
+			prop, ok := props[k]
+			if !ok {
+				if additional, ok := schema["additionalProperties"]; ok {
+					problems = append(problems, validateSchema(spec, additional, v, path+"."+k)...)
+				} else if hasProps {
+					problems = append(problems, fmt.Sprintf("%s: undocumented field %q", path, k))
+				}
+				continue
+			}
+			problems = append(problems, validateSchema(spec, prop, v, path+"."+k)...)
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected array, got %T", path, value))
+		}
+		for i, v := range arr {
+			problems = append(problems, validateSchema(spec, schema["items"], v, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected string, got %T", path, value))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid date-time %q", path, s))
+			}
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected number, got %T", path, value))
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected integer, got %T", path, value))
+		}
+		if _, err := n.Int64(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: expected integer, got %s", path, n))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected boolean, got %T", path, value))
+		}
+	}
+	return problems
+}
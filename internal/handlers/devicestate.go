@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// DeviceStateHandler обрабатывает GET /devices/{id} - накопительные показатели
+// устройства: количество метрик и аномалий, последние значения
+func (h *Handler) DeviceStateHandler(w http.ResponseWriter, r *http.Request) {
+	if h.deviceState == nil {
+		h.respondError(w, "Device state is not enabled", http.StatusNotFound)
+		return
+	}
+	state, ok, err := h.deviceState.Get(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondError(w, "Failed to read device state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.respondError(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, state, http.StatusOK)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"highload-service/internal/dlq"
+	"highload-service/internal/events"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+	"highload-service/internal/regions"
+)
+
+// observe анализирует метрику и передает ее включенным агрегатам. Паника
+// на любом шаге возвращается как ошибка, чтобы метрика попала в очередь
+// недоставленных, а не пропала вместе с запросом. Запись в журнал, учет
+// аномалий (откуда событие уходит в outbox), запись событий аномалий и
+// публикация в шину событий считаются этапом sink_publish, остальное —
+// этапом analyze
+func (h *Handler) observe(metric models.Metric, timings *stageTimings) (result models.AnalysisResult, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("analysis panicked: %v", p)
+		}
+	}()
+	start := time.Now()
+	if result, err = h.analyzer.Analyze(metric); err != nil {
+		return result, err
+	}
+	publish := h.record(metric, &result)
+	timings.add(stageAnalyze, time.Since(start)-publish)
+	timings.add(stagePublish, publish)
+	return result, nil
+}
+
+// record передает проанализированную метрику включенным агрегатам и
+// возвращает время этапа sink_publish. Аномалия отложенного устройства
+// сбрасывается в result до учета
+func (h *Handler) record(metric models.Metric, result *models.AnalysisResult) (publish time.Duration) {
+	// Отложенное устройство обучает окна, но его аномалии не доходят до учета и оповещений
+	if result.AnomalyDetected && h.snoozes.Snoozed(metric.DeviceID) {
+		result.AnomalyDetected, result.Snoozed = false, true
+	}
+	if h.journal != nil {
+		p := time.Now()
+		h.journal.Append(metric, *result)
+		publish += time.Since(p)
+	}
+	if h.experiment != nil {
+		h.experiment.Observe(metric)
+	}
+	if h.rollup != nil {
+		h.rollup.Observe(metric, result.AnomalyDetected)
+	}
+	if h.quantiles != nil {
+		h.quantiles.Observe(metric)
+	}
+	if h.anomalies != nil {
+		p := time.Now()
+		h.anomalies.Record(metric, *result)
+		publish += time.Since(p)
+	}
+	if h.anomalyEvents != nil {
+		p := time.Now()
+		h.anomalyEvents.Record(metric, *result)
+		publish += time.Since(p)
+	}
+	if h.groups != nil {
+		h.groups.Observe(metric)
+	}
+	region := regions.Unknown
+	if h.regions != nil {
+		region = h.regions.Observe(metric, *result)
+	}
+	if h.histograms != nil {
+		h.histograms.Observe(region, metric.CPU, metric.RPS)
+	}
+	if h.scorer != nil {
+		h.scorer.Observe(metric, *result)
+	}
+	if h.cadence != nil {
+		h.cadence.Observe(metric)
+	}
+	if h.deviceState != nil {
+		h.deviceState.Observe(metric, *result)
+	}
+	if h.events != nil {
+		p := time.Now()
+		events.Publish(h.events, events.MetricIngested, events.Ingested{Metric: metric, Result: *result})
+		publish += time.Since(p)
+	}
+	return publish
+}
+
+// deadLetter записывает метрику в очередь недоставленных, если она включена
+func (h *Handler) deadLetter(metric models.Metric, reason dlq.Reason, err error) {
+	if h.deadLetters != nil {
+		h.deadLetters.Add(metric, reason, err)
+	}
+}
+
+// Reprocess повторно обрабатывает запись очереди недоставленных. Для ошибок
+// сохранения повторяется только сохранение (метрика уже проанализирована),
+// остальные записи проходят прием заново
+func (h *Handler) Reprocess(e dlq.Entry) error {
+	if e.Reason == dlq.ReasonPersistence {
+		if h.cache == nil {
+			return errors.New("cache not available")
+		}
+		return h.cache.CacheMetric(e.Metric)
+	}
+
+	if err := e.Metric.Validate(); err != nil {
+		return err
+	}
+	if h.cache != nil {
+		if err := h.cache.CacheMetric(e.Metric); err != nil {
+			return err
+		}
+	}
+	metrics.MetricsReceived.Inc()
+	result, err := h.observe(e.Metric, nil)
+	if err != nil {
+		return err
+	}
+	anomalies := 0
+	if result.AnomalyDetected {
+		anomalies = 1
+	}
+	h.countIngested(1, anomalies)
+	return nil
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+	"highload-service/internal/rollup"
+)
+
+const (
+	// defaultForecastHorizon количество прогнозируемых точек по умолчанию
+	defaultForecastHorizon = 12
+	// defaultForecastSeason длина сезона по умолчанию: суточный цикл нагрузки
+	defaultForecastSeason = 24 * time.Hour
+	// defaultForecastHistory история без сезонности, по которой строится прогноз
+	defaultForecastHistory = 6 * time.Hour
+)
+
+// ForecastPoint прогноз значения метрики на интервал
+type ForecastPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Lower float64   `json:"lower"`
+	Upper float64   `json:"upper"`
+}
+
+// ForecastResponse прогноз метрики и модель, по которой он построен
+type ForecastResponse struct {
+	Metric     string  `json:"metric"`
+	Resolution string  `json:"resolution"`
+	Confidence float64 `json:"confidence"`
+	// Model коэффициенты модели; Season 0 — сезонность не учтена (истории меньше двух сезонов)
+	Model analytics.HoltWinters `json:"model"`
+	// RMSE среднеквадратичная ошибка прогноза на шаг вперед по истории
+	RMSE   float64         `json:"rmse"`
+	Points []ForecastPoint `json:"points"`
+}
+
+// ForecastHandler обрабатывает GET /forecast - прогноз CPU или RPS парка на
+// horizon интервалов вперед методом Холта–Винтерса по агрегатам GET /series.
+// Коэффициенты подбираются по истории; пока истории меньше двух сезонов,
+// прогноз строится без сезонности
+func (h *Handler) ForecastHandler(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/forecast", r.Method))
+	defer timer.ObserveDuration()
+
+	if h.rollup == nil {
+		h.respondError(w, "Series are not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	field := query.Get("metric")
+	if field == "" {
+		field = rollup.FieldCPU
+	}
+
+	horizon := defaultForecastHorizon
+	if v := query.Get("horizon"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSeriesPoints {
+			h.respondError(w, fmt.Sprintf("horizon must be an integer within [1, %d]", maxSeriesPoints), http.StatusBadRequest)
+			return
+		}
+		horizon = n
+	}
+
+	confidence := 0.95
+	if v := query.Get("confidence"); v != "" {
+		c, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(c > 0 && c < 1) {
+			h.respondError(w, "confidence must be within (0, 1)", http.StatusBadRequest)
+			return
+		}
+		confidence = c
+	}
+
+	season := defaultForecastSeason
+	if v := query.Get("season"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			h.respondError(w, "Invalid season: "+v, http.StatusBadRequest)
+			return
+		}
+		season = d
+	}
+
+	// По умолчанию история — три сезона: два нужны для начальных оценок, третий уточняет их
+	history := 3 * season
+	if season == 0 {
+		history = defaultForecastHistory
+	}
+	if v := query.Get("history"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			h.respondError(w, "Invalid history: "+v, http.StatusBadRequest)
+			return
+		}
+		history = d
+	}
+
+	var resolution time.Duration
+	if v := query.Get("resolution"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			h.respondError(w, "Invalid resolution: "+v, http.StatusBadRequest)
+			return
+		}
+		resolution = d
+	} else {
+		d, err := h.rollup.Resolve(history, maxSeriesPoints)
+		if err != nil {
+			h.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resolution = d
+	}
+	if season%resolution != 0 {
+		h.respondError(w, fmt.Sprintf("season must be a multiple of resolution %s", rollup.FormatResolution(resolution)), http.StatusBadRequest)
+		return
+	}
+
+	// Текущий интервал еще заполняется, поэтому история заканчивается предыдущим
+	next := h.clock.Now().Truncate(resolution)
+	to := next.Add(-time.Nanosecond)
+	points, err := h.rollup.Series(field, resolution, to.Add(-history), to)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series := regularSeries(points, resolution, next)
+
+	model, err := analytics.FitHoltWinters(series, int(season/resolution))
+	if errors.Is(err, analytics.ErrInsufficientData) && season > 0 {
+		model, err = analytics.FitHoltWinters(series, 0)
+	}
+	if errors.Is(err, analytics.ErrInsufficientData) {
+		h.respondError(w, fmt.Sprintf("Not enough data for forecast: %d intervals of %s collected, at least %d needed",
+			len(series), rollup.FormatResolution(resolution), analytics.HoltWinters{}.MinPoints()), http.StatusUnprocessableEntity)
+		return
+	}
+	predictions, rmse, err := model.Forecast(series, horizon, confidence)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp := ForecastResponse{
+		Metric:     field,
+		Resolution: rollup.FormatResolution(resolution),
+		Confidence: confidence,
+		Model:      model,
+		RMSE:       rmse,
+		Points:     make([]ForecastPoint, len(predictions)),
+	}
+	for i, p := range predictions {
+		// CPU и RPS не бывают отрицательными
+		resp.Points[i] = ForecastPoint{
+			Time:  next.Add(time.Duration(i) * resolution).UTC(),
+			Value: math.Max(p.Value, 0),
+			Lower: math.Max(p.Lower, 0),
+			Upper: math.Max(p.Upper, 0),
+		}
+	}
+
+	metrics.RequestsTotal.WithLabelValues("/forecast", r.Method, "200").Inc()
+	h.respondJSON(w, resp, http.StatusOK)
+}
+
+// regularSeries раскладывает непустые интервалы по сетке от первого из них до
+// end; пропущенные интервалы (устройства молчали) повторяют предыдущее значение
+func regularSeries(points []models.SeriesPoint, resolution time.Duration, end time.Time) []float64 {
+	if len(points) == 0 {
+		return nil
+	}
+	series := make([]float64, 0, int(end.Sub(points[0].Time)/resolution))
+	i := 0
+	for t := points[0].Time; t.Before(end); t = t.Add(resolution) {
+		if i < len(points) && !points[i].Time.After(t) {
+			series = append(series, points[i].Avg)
+			i++
+			continue
+		}
+		series = append(series, series[len(series)-1])
+	}
+	return series
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListGroupsHandler обрабатывает GET /groups - статистика всех групп устройств
+func (h *Handler) ListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.groups == nil {
+		h.respondError(w, "Device groups are not configured", http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, h.groups.List(), http.StatusOK)
+}
+
+// GroupStatsHandler обрабатывает GET /groups/{id}/stats - окна и счетчики группы
+func (h *Handler) GroupStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.groups == nil {
+		h.respondError(w, "Device groups are not configured", http.StatusNotFound)
+		return
+	}
+	stats, err := h.groups.Stats(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, stats, http.StatusOK)
+}
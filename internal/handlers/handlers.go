@@ -2,34 +2,382 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
 	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
+	"highload-service/internal/admission"
 	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/availability"
+	"highload-service/internal/backpressure"
 	"highload-service/internal/cache"
+	"highload-service/internal/cadence"
+	"highload-service/internal/clock"
+	"highload-service/internal/codec"
+	"highload-service/internal/counters"
+	"highload-service/internal/dedup"
+	"highload-service/internal/devicestate"
+	"highload-service/internal/dlq"
+	"highload-service/internal/events"
+	"highload-service/internal/flags"
+	"highload-service/internal/groups"
+	"highload-service/internal/health"
+	"highload-service/internal/incidents"
+	"highload-service/internal/journal"
 	"highload-service/internal/metrics"
+	"highload-service/internal/middleware"
 	"highload-service/internal/models"
+	"highload-service/internal/payload"
+	"highload-service/internal/regions"
+	"highload-service/internal/rollup"
+	"highload-service/internal/sampling"
+	"highload-service/internal/score"
+	"highload-service/internal/sequencer"
+	"highload-service/internal/snooze"
 )
 
+// maxSeriesPoints ограничение числа точек в ответе GET /series
+const maxSeriesPoints = 1000
+
 // Handler содержит зависимости для HTTP обработчиков
 type Handler struct {
-	analyzer  *analytics.Analyzer
-	cache     *cache.RedisCache
+	analyzer *analytics.Analyzer
+	cache    cache.Cache
+	clock    clock.Clock
+	// resultTTL сроки хранения результатов анализа по уровням
+	resultTTL cache.ResultTTL
 	startTime time.Time
+	draining  atomic.Bool
+	// persistFailedAt время последней неудачной записи метрики в хранилище, UnixNano
+	persistFailedAt atomic.Int64
+
+	warmupSamples  int64
+	warmupDuration time.Duration
+
+	ingestMiddleware []func(http.Handler) http.Handler
+	flags            *flags.Set
+	experiment       *analytics.Experiment
+	counters         *counters.Counters
+	rollup           *rollup.Rollup
+	anomalies        *anomalies.Tracker
+	anomalyEvents    *anomalies.Events
+	groups           *groups.Analytics
+	regions          *regions.Aggregator
+	histograms       *metrics.ValueHistograms
+	events           *events.Bus
+	scorer           *score.Scorer
+	cadence          *cadence.Tracker
+	incidents        *incidents.Correlator
+	deadLetters      *dlq.Queue
+	journal          *journal.Journal
+	admission        *admission.Controller
+	snoozes          *snooze.Registry
+	availability     *availability.Tracker
+	dedup            *dedup.Detector
+	deviceState      *devicestate.Tracker
+	sampler          *sampling.Sampler
+	sequencer        *sequencer.Sequencer
+	quantiles        *analytics.Quantiles
+	payloads         *payload.Mappings
+	backpressure     *backpressure.Gate
+	// retryAfter значение Retry-After ответа 429 при переполненной очереди анализатора
+	retryAfter   string
+	healthChecks []health.Check
+}
+
+// Option настраивает обработчик
+type Option func(*Handler)
+
+// WithClock задает источник времени обработчика
+func WithClock(c clock.Clock) Option {
+	return func(h *Handler) {
+		h.clock = c
+	}
+}
+
+// WithWarmup задает условия готовности: сервис готов после samples проанализированных
+// метрик или по истечении duration с момента запуска, в зависимости от того, что наступит раньше.
+// Нулевые значения отключают соответствующее условие
+func WithWarmup(samples int64, duration time.Duration) Option {
+	return func(h *Handler) {
+		h.warmupSamples = samples
+		h.warmupDuration = duration
+	}
+}
+
+// WithIngestMiddleware добавляет middleware, применяемые только к эндпоинтам приема метрик.
+// Middleware выполняются в порядке передачи
+func WithIngestMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(h *Handler) {
+		h.ingestMiddleware = append(h.ingestMiddleware, mw...)
+	}
+}
+
+// WithFlags задает feature-флаги, которыми включаются эндпоинты.
+// Без них действуют flags.Defaults
+func WithFlags(f *flags.Set) Option {
+	return func(h *Handler) {
+		h.flags = f
+	}
+}
+
+// WithExperiment подключает A/B-сравнение конфигураций детектора:
+// каждая принятая метрика дополнительно передается в эксперимент
+func WithExperiment(e *analytics.Experiment) Option {
+	return func(h *Handler) {
+		h.experiment = e
+	}
+}
+
+// WithCounters задает глобальные счетчики метрик и аномалий.
+// По умолчанию счетчики ведутся в cache под именем хоста
+func WithCounters(c *counters.Counters) Option {
+	return func(h *Handler) {
+		h.counters = c
+	}
+}
+
+// WithRollup подключает агрегаты для GET /series: каждая принятая метрика
+// добавляется в интервалы всех уровней
+func WithRollup(r *rollup.Rollup) Option {
+	return func(h *Handler) {
+		h.rollup = r
+	}
+}
+
+// WithAnomalies включает учет аномалий с подтверждением и закрытием (/anomalies)
+func WithAnomalies(t *anomalies.Tracker) Option {
+	return func(h *Handler) {
+		h.anomalies = t
+	}
+}
+
+// WithAnomalyEvents записывает событие с объяснением на каждую аномальную метрику
+// и включает их выборку по периоду и устройству (/anomalies/events)
+func WithAnomalyEvents(e *anomalies.Events) Option {
+	return func(h *Handler) {
+		h.anomalyEvents = e
+	}
+}
+
+// WithGroups включает аналитику групп устройств (/groups)
+func WithGroups(g *groups.Analytics) Option {
+	return func(h *Handler) {
+		h.groups = g
+	}
+}
+
+// WithValueHistograms включает гистограммы входящих значений CPU и RPS
+func WithValueHistograms(v *metrics.ValueHistograms) Option {
+	return func(h *Handler) {
+		h.histograms = v
+	}
+}
+
+// WithEvents публикует принятые и проанализированные метрики в шину событий
+func WithEvents(b *events.Bus) Option {
+	return func(h *Handler) {
+		h.events = b
+	}
+}
+
+// WithScorer включает сводные оценки здоровья устройств и парка (/score)
+func WithScorer(s *score.Scorer) Option {
+	return func(h *Handler) {
+		h.scorer = s
+	}
+}
+
+// WithCadence включает SLA интервалов отчетности устройств (/cadence)
+func WithCadence(t *cadence.Tracker) Option {
+	return func(h *Handler) {
+		h.cadence = t
+	}
+}
+
+// WithIncidents включает просмотр инцидентов (/incidents). Оповещения в
+// Correlator передает трекер аномалий, поэтому он должен быть его получателем
+func WithIncidents(c *incidents.Correlator) Option {
+	return func(h *Handler) {
+		h.incidents = c
+	}
+}
+
+// WithRegions включает агрегаты по регионам устройств (/regions)
+func WithRegions(a *regions.Aggregator) Option {
+	return func(h *Handler) {
+		h.regions = a
+	}
+}
+
+// WithDeadLetters направляет метрики, не прошедшие проверку, анализ или
+// сохранение, в очередь недоставленных
+func WithDeadLetters(q *dlq.Queue) Option {
+	return func(h *Handler) {
+		h.deadLetters = q
+	}
+}
+
+// WithJournal записывает результат анализа каждой принятой метрики в журнал
+// и включает его чтение с курсором (/journal)
+func WithJournal(j *journal.Journal) Option {
+	return func(h *Handler) {
+		h.journal = j
+	}
+}
+
+// WithAdmission пропускает метрики в анализ через маркерную корзину: всплеск
+// пакетов ждет маркеров, а избыток сверх ожидания отклоняется с 429
+func WithAdmission(c *admission.Controller) Option {
+	return func(h *Handler) {
+		h.admission = c
+	}
+}
+
+// WithSnoozes включает отложение аномалий устройств (/devices/{id}/snooze)
+func WithSnoozes(r *snooze.Registry) Option {
+	return func(h *Handler) {
+		h.snoozes = r
+	}
+}
+
+// WithAvailability добавляет в GET /stats доступность сервиса за сутки и месяц
+func WithAvailability(t *availability.Tracker) Option {
+	return func(h *Handler) {
+		h.availability = t
+	}
+}
+
+// WithBatchDedup отбрасывает повторно присланные пакеты (тот же SHA-256 тела
+// в окне детектора), не анализируя их. Проверяются только пакеты, у всех
+// метрик которых указано время
+func WithBatchDedup(d *dedup.Detector) Option {
+	return func(h *Handler) {
+		h.dedup = d
+	}
+}
+
+// WithDeviceState ведет накопительные показатели устройств для GET /devices/{id}
+func WithDeviceState(t *devicestate.Tracker) Option {
+	return func(h *Handler) {
+		h.deviceState = t
+	}
+}
+
+// WithSequencer анализирует метрики устройств с номером seq по порядку
+func WithSequencer(s *sequencer.Sequencer) Option {
+	return func(h *Handler) {
+		h.sequencer = s
+	}
+}
+
+// WithQuantiles ведет скетчи t-digest значений для квантилей за период (/quantiles)
+func WithQuantiles(q *analytics.Quantiles) Option {
+	return func(h *Handler) {
+		h.quantiles = q
+	}
+}
+
+// WithResultTTL задает сроки хранения результатов анализа: обычных, аномальных
+// и закрепленных незакрытыми аномалиями
+func WithResultTTL(t cache.ResultTTL) Option {
+	return func(h *Handler) {
+		h.resultTTL = t
+	}
+}
+
+// WithSampling прореживает сохранение сырых метрик: решение принимается после
+// анализа, чтобы аномалии и их соседи сохранялись всегда
+func WithSampling(s *sampling.Sampler) Option {
+	return func(h *Handler) {
+		h.sampler = s
+	}
+}
+
+// WithPayloadMappings переводит JSON устройств сторонних моделей в метрики:
+// модель указывается заголовком X-Device-Model
+func WithPayloadMappings(m *payload.Mappings) Option {
+	return func(h *Handler) {
+		h.payloads = m
+	}
+}
+
+// WithBackpressure отклоняет метрики с 429 и Retry-After, пока gate сообщает о
+// переполнении очереди анализатора: клиенты повторяют позже, а не копят
+// запросы, ожидающие анализа
+func WithBackpressure(gate *backpressure.Gate, retryAfter time.Duration) Option {
+	return func(h *Handler) {
+		h.backpressure = gate
+		h.retryAfter = strconv.FormatInt(int64(math.Max(math.Ceil(retryAfter.Seconds()), 1)), 10)
+	}
+}
+
+// WithHealthChecks добавляет проверки компонентов в сводный статус /health.
+// Доступность хранилища и сохранение метрик проверяются всегда
+func WithHealthChecks(checks ...health.Check) Option {
+	return func(h *Handler) {
+		h.healthChecks = append(h.healthChecks, checks...)
+	}
 }
 
 // NewHandler создает новый обработчик
-func NewHandler(analyzer *analytics.Analyzer, cache *cache.RedisCache) *Handler {
-	return &Handler{
+// cache может быть nil, если Redis недоступен
+func NewHandler(analyzer *analytics.Analyzer, cache cache.Cache, opts ...Option) *Handler {
+	h := &Handler{
 		analyzer:  analyzer,
 		cache:     cache,
-		startTime: time.Now(),
+		clock:     clock.Real(),
+		resultTTL: defaultResultTTL,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	if h.counters == nil && cache != nil {
+		h.counters = counters.New(cache, counters.DefaultNodeID())
+	}
+	h.startTime = h.clock.Now()
+	return h
+}
+
+// StartDraining переводит обработчик в режим остановки: новые метрики
+// отклоняются с 503, чтобы балансировщик перенаправил устройства на другие реплики
+func (h *Handler) StartDraining() {
+	h.draining.Store(true)
+}
+
+// rejectIfDraining отвечает 503 на прием метрик во время остановки
+func (h *Handler) rejectIfDraining(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if !h.draining.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "1")
+	h.respondError(w, "Service is shutting down", http.StatusServiceUnavailable)
+	metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "503").Inc()
+	return true
+}
+
+// rejectIfSaturated отвечает 429, пока очередь анализатора выше верхней границы
+// и еще не опустилась до нижней
+func (h *Handler) rejectIfSaturated(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if h.backpressure == nil || !h.backpressure.Check() {
+		return false
+	}
+	w.Header().Set("Retry-After", h.retryAfter)
+	h.respondError(w, "Analysis queue is saturated", http.StatusTooManyRequests)
+	metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "429").Inc()
+	return true
 }
 
 // MetricsHandler обрабатывает POST /metrics - прием метрик
@@ -43,55 +391,119 @@ func (h *Handler) MetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var metric models.Metric
-	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
-		h.respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-		metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "400").Inc()
+	if h.rejectIfDraining(w, r, "/metrics") || h.rejectIfSaturated(w, r, "/metrics") {
 		return
 	}
 
-	// Устанавливаем временную метку, если не указана
-	if metric.Timestamp.IsZero() {
-		metric.Timestamp = time.Now()
+	var timings stageTimings
+	decoded := getMetric()
+	defer putMetric(decoded)
+	start := time.Now()
+	if !h.decodeBody(w, r, "/metrics", decoded) {
+		return
 	}
-
-	// Кэшируем метрику в Redis
-	if h.cache != nil {
-		if err := h.cache.CacheMetric(metric); err != nil {
-			// Логируем ошибку, но продолжаем обработку
-			metrics.CacheMisses.Inc()
-		} else {
-			metrics.CacheHits.Inc()
-		}
+	timings.since(stageDecode, start)
+	metric := *decoded
+	if !h.admit(w, r, "/metrics", 1) {
+		return
+	}
+	middleware.SetDeviceID(r, metric.DeviceID)
+	if h.wantsAsync(r) {
+		h.acceptAsync(w, r, metric, &timings)
+		return
 	}
 
-	// Отправляем на анализ
-	metrics.MetricsReceived.Inc()
+	result, err := h.ingestTimed(metric, &timings)
+	if debugTimings(r) {
+		w.Header().Set("Server-Timing", timings.serverTiming(1))
+	}
+	switch {
+	case errors.Is(err, models.ErrInvalidMetric):
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "400").Inc()
+		return
+	case err != nil:
+		h.respondError(w, "Analysis failed: "+err.Error(), http.StatusInternalServerError)
+		metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "500").Inc()
+		return
+	}
 
-	// Синхронный анализ для ответа
-	startAnalysis := time.Now()
-	result := h.analyzer.AnalyzeSync(metric)
-	metrics.AnalysisLatency.Observe(time.Since(startAnalysis).Seconds())
+	metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "200").Inc()
+	// По указателю результат кодируется без копии
+	h.respondResult(w, negotiate(w, r), &result)
+}
 
-	// Обновляем метрики Prometheus
-	metrics.UpdateAnalysisMetrics(
-		result.RollingAvgCPU,
-		result.RollingAvgRPS,
-		result.ZScoreCPU,
-		result.ZScoreRPS,
-		result.AnomalyDetected,
-	)
+// decodeBody декодирует тело запроса кодеком, выбранным по Content-Type (JSON
+// для незарегистрированных типов), или, с заголовком X-Device-Model, правилами
+// модели устройства. При ошибке отвечает сам и возвращает false
+func (h *Handler) decodeBody(w http.ResponseWriter, r *http.Request, endpoint string, v interface{}) bool {
+	if model := r.Header.Get(payload.ModelHeader); model != "" && h.payloads != nil {
+		if err := h.payloads.Decode(model, r.Body, v); err != nil {
+			if h.rejectTooLarge(w, r, endpoint, err) {
+				return false
+			}
+			h.respondError(w, "Invalid payload for device model "+strconv.Quote(model)+": "+err.Error(), http.StatusBadRequest)
+			metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "400").Inc()
+			return false
+		}
+		return true
+	}
+	// Тело с незарегистрированным типом разбирается как JSON, как до реестра
+	// кодеков: на это полагаются клиенты вроде curl -d, который отправляет
+	// application/x-www-form-urlencoded
+	c, ok := codec.Default.ForContentType(r.Header.Get("Content-Type"))
+	if !ok {
+		c = codec.JSON
+	}
+	if err := codec.Decode(c, r.Body, v); err != nil {
+		if h.rejectTooLarge(w, r, endpoint, err) {
+			return false
+		}
+		h.respondError(w, "Invalid "+strings.ToUpper(c.Name())+": "+err.Error(), http.StatusBadRequest)
+		metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "400").Inc()
+		return false
+	}
+	return true
+}
 
-	// Кэшируем результат анализа
-	if h.cache != nil {
-		_ = h.cache.CacheAnalysisResult(result)
+// rejectTooLarge отвечает 413, если чтение тела прервано ограничением размера
+// (для сжатого тела — middleware.MaxExpansion)
+func (h *Handler) rejectTooLarge(w http.ResponseWriter, r *http.Request, endpoint string, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
 	}
+	h.respondError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "413").Inc()
+	return true
+}
 
-	metrics.RequestsTotal.WithLabelValues("/metrics", r.Method, "200").Inc()
-	h.respondJSON(w, result, http.StatusOK)
+// admit дожидается маркеров на n метрик. При отказе отвечает 429 с Retry-After
+// и возвращает false
+func (h *Handler) admit(w http.ResponseWriter, r *http.Request, endpoint string, n int) bool {
+	if h.admission == nil {
+		return true
+	}
+	err := h.admission.Admit(r.Context(), n)
+	if err == nil {
+		return true
+	}
+	var rejected *admission.RejectedError
+	if errors.As(err, &rejected) {
+		seconds := int64(math.Ceil(rejected.RetryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	// Отмененный клиентом запрос тоже считается отклоненным: ответ уже никто не ждет
+	h.respondError(w, "Ingest rate limit exceeded", http.StatusTooManyRequests)
+	metrics.RequestsTotal.WithLabelValues(endpoint, r.Method, "429").Inc()
+	return false
 }
 
-// AnalyzeHandler обрабатывает GET /analyze - получение статистики анализа
+// AnalyzeHandler обрабатывает GET /analyze - получение статистики анализа:
+// общих окон или, с ?device=, окон одного устройства
 func (h *Handler) AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/analyze", r.Method))
 	defer timer.ObserveDuration()
@@ -102,10 +514,22 @@ func (h *Handler) AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	avgCPU, avgRPS, stdDevCPU, stdDevRPS := h.analyzer.GetStats()
+	snap := h.analyzer.Snapshot()
+	id := r.URL.Query().Get("device")
+	if id != "" {
+		deviceSnap, ok := h.analyzer.DeviceSnapshot(id)
+		if !ok {
+			h.respondError(w, "Device has no analysis windows", http.StatusNotFound)
+			metrics.RequestsTotal.WithLabelValues("/analyze", r.Method, "404").Inc()
+			return
+		}
+		snap = deviceSnap
+	}
+	avgCPU, avgRPS, stdDevCPU, stdDevRPS := snap.AvgCPU, snap.AvgRPS, snap.StdDevCPU, snap.StdDevRPS
+	detector := snap.Detector
 
 	response := map[string]interface{}{
-		"timestamp":      time.Now(),
+		"timestamp": h.clock.Now(),
 		"rolling_avg": map[string]float64{
 			"cpu": avgCPU,
 			"rps": avgRPS,
@@ -115,15 +539,48 @@ func (h *Handler) AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 			"rps": stdDevRPS,
 		},
 		"thresholds": map[string]float64{
-			"anomaly_z_score": analytics.ZScoreThreshold,
-			"window_size":     float64(analytics.WindowSize),
+			"anomaly_z_score": detector.ZScoreThreshold,
+			"window_size":     float64(detector.WindowSize),
 		},
 	}
+	// Перцентили показывают хвост распределения, который скрывают среднее и σ;
+	// при сглаживании EWMA значения не хранятся, и перцентилей нет
+	if snap.HasPercentiles {
+		response["percentiles"] = map[string]analytics.Percentiles{
+			"cpu": snap.PercentilesCPU,
+			"rps": snap.PercentilesRPS,
+		}
+	}
+	// Рассогласование CPU и RPS (RPS падает, CPU остается высоким) видно по падению корреляции
+	if snap.HasCorrelation {
+		response["correlation"] = snap.Correlation
+	}
+	// Эпизод аномалии окон: открытый или последний закрытый
+	if ep := snap.Episode; !ep.Start.IsZero() {
+		response["episode"] = map[string]interface{}{
+			"open":   ep.Open,
+			"start":  ep.Start,
+			"events": ep.Events,
+		}
+	}
+	if named, ok := h.analyzer.NamedStats(id); ok && len(named) > 0 {
+		response["values"] = named
+	}
 
 	metrics.RequestsTotal.WithLabelValues("/analyze", r.Method, "200").Inc()
 	h.respondJSON(w, response, http.StatusOK)
 }
 
+// CompareHandler обрабатывает GET /analyze/compare - сравнение двух конфигураций
+// детектора на одном потоке метрик
+func (h *Handler) CompareHandler(w http.ResponseWriter, r *http.Request) {
+	if h.experiment == nil {
+		h.respondError(w, "No detector comparison is running", http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, h.experiment.Report(), http.StatusOK)
+}
+
 // BatchMetricsHandler обрабатывает POST /metrics/batch - массовая загрузка метрик
 func (h *Handler) BatchMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/metrics/batch", r.Method))
@@ -135,42 +592,164 @@ func (h *Handler) BatchMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var batch models.MetricsBatch
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		h.respondError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-		metrics.RequestsTotal.WithLabelValues("/metrics/batch", r.Method, "400").Inc()
+	if h.rejectIfDraining(w, r, "/metrics/batch") || h.rejectIfSaturated(w, r, "/metrics/batch") {
+		return
+	}
+
+	// Хеш тела считается попутно с разбором: повтор не должен ни тратить
+	// маркеры, ни попадать в окна анализатора
+	var sum hash.Hash
+	if h.dedup != nil {
+		sum = dedup.NewHash()
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, sum), r.Body}
+	}
+	var timings stageTimings
+	batch := getBatch()
+	defer putBatch(batch)
+	start := time.Now()
+	if !h.decodeBody(w, r, "/metrics/batch", batch) {
+		return
+	}
+	timings.since(stageDecode, start)
+	// Метрикам без времени его ставит сервер, поэтому одинаковые тела таких
+	// пакетов — разные показания, а не повтор
+	var claim *dedup.Claim
+	if sum != nil && timestamped(batch.Metrics) {
+		var duplicate bool
+		if claim, duplicate = h.dedup.Claim(sum.Sum(nil)); duplicate {
+			h.respondDuplicate(w, r)
+			return
+		}
+	}
+	if !h.admit(w, r, "/metrics/batch", len(batch.Metrics)) {
+		// Отклоненный пакет шлюз повторит, и повтор не должен считаться дубликатом
+		claim.Release()
+		return
+	}
+	if h.wantsAsync(r) {
+		h.acceptBatchAsync(w, r, batch, &timings)
 		return
 	}
 
-	results := make([]models.AnalysisResult, 0, len(batch.Metrics))
+	// Результаты отправляются по мере анализа, а не собираются в один ответ,
+	// поэтому разбивка по этапам передается трейлером
+	debug := debugTimings(r)
+	if debug {
+		w.Header().Set("Trailer", "Server-Timing")
+	}
+	stream := newResultStream(w, negotiate(w, r))
+	processed := 0
 	anomaliesCount := 0
+	rejected := 0
+	var stats batchStats
+	// Метрики к сохранению копятся и записываются одним конвейером после пакета
+	writes := getBatch()
+	defer putBatch(writes)
+	// Позиции задержанных метрик пакета: упорядочивание выпускает их позже
+	var positions map[sequenceKey]int
+	if h.sequencer != nil {
+		positions = make(map[sequenceKey]int)
+	}
 
-	for _, metric := range batch.Metrics {
+	for i, metric := range batch.Metrics {
+		middleware.SetDeviceID(r, metric.DeviceID)
 		if metric.Timestamp.IsZero() {
-			metric.Timestamp = time.Now()
+			metric.Timestamp = h.clock.Now()
 		}
 
-		if h.cache != nil {
-			_ = h.cache.CacheMetric(metric)
+		// Некорректные метрики не прерывают пакет, а уходят в очередь недоставленных
+		start := time.Now()
+		err := metric.Validate()
+		timings.since(stageValidate, start)
+		if err != nil {
+			h.deadLetter(metric, dlq.ReasonValidation, err)
+			rejected++
+			continue
+		}
+		stats.add(metric)
+
+		batch.Metrics[i] = metric
+		ready := batch.Metrics[i : i+1]
+		if h.sequencer != nil {
+			var verdict sequencer.Verdict
+			if ready, verdict = h.sequencer.Offer(metric); verdict != sequencer.Ready {
+				stream.Add(h.unsequenced(metric, verdict, &timings))
+				if verdict == sequencer.Held {
+					positions[sequenceKey{metric.DeviceID, metric.Seq}] = i
+				}
+				continue
+			}
 		}
+		// Результаты метрик, освобожденных этой, идут следом за ней
+		for _, m := range ready {
+			if h.sampler == nil {
+				writes.Metrics = append(writes.Metrics, m)
+			}
+
+			metrics.MetricsReceived.Inc()
+			result, err := h.observe(m, &timings)
+			result.Seq = m.Seq
+			writes.Metrics = append(writes.Metrics, h.keepAfter(m, result, err)...)
+			if err != nil {
+				h.deadLetter(m, dlq.ReasonAnalysis, err)
+				rejected++
+				continue
+			}
+			stream.Add(result)
+			processed++
+
+			if result.AnomalyDetected {
+				anomaliesCount++
+				if m.Seq == metric.Seq {
+					stats.anomaly(i)
+				} else if pos, ok := positions[sequenceKey{m.DeviceID, m.Seq}]; ok {
+					stats.anomaly(pos)
+				}
+			}
+		}
+	}
+	h.persistBatch(writes.Metrics, &timings)
+	h.countIngested(processed, anomaliesCount)
 
-		metrics.MetricsReceived.Inc()
-		result := h.analyzer.AnalyzeSync(metric)
-		results = append(results, result)
+	metrics.RequestsTotal.WithLabelValues("/metrics/batch", r.Method, "200").Inc()
+	stream.Close(processed, rejected, anomaliesCount, stats.result())
+	if debug {
+		w.Header().Set("Server-Timing", timings.serverTiming(len(batch.Metrics)))
+	}
+}
 
-		if result.AnomalyDetected {
-			anomaliesCount++
+// timestamped сообщает, что у всех метрик пакета есть время
+func timestamped(batch []models.Metric) bool {
+	for i := range batch {
+		if batch[i].Timestamp.IsZero() {
+			return false
 		}
 	}
+	return true
+}
 
-	response := map[string]interface{}{
-		"processed":       len(batch.Metrics),
-		"anomalies_found": anomaliesCount,
-		"results":         results,
+// respondDuplicate отвечает на повтор пакета успехом, чтобы шлюз прекратил
+// повторы, но ничего не обрабатывает. Формат ответа — из Accept, как у пакета
+func (h *Handler) respondDuplicate(w http.ResponseWriter, r *http.Request) {
+	metrics.DuplicateBatches.Inc()
+	if h.counters != nil {
+		_ = h.counters.Add(counters.DuplicateBatchesTotal, 1)
 	}
-
 	metrics.RequestsTotal.WithLabelValues("/metrics/batch", r.Method, "200").Inc()
-	h.respondJSON(w, response, http.StatusOK)
+	resp := models.BatchResponse{Results: []models.AnalysisResult{}, Duplicate: true}
+	h.respondEncoded(w, negotiate(w, r), &resp, http.StatusOK)
+}
+
+// countIngested добавляет принятые метрики и найденные аномалии в глобальные счетчики
+func (h *Handler) countIngested(metricsCount, anomalies int) {
+	if h.counters == nil {
+		return
+	}
+	_ = h.counters.Add(counters.MetricsTotal, int64(metricsCount))
+	_ = h.counters.Add(counters.AnomaliesTotal, int64(anomalies))
 }
 
 // HealthHandler обрабатывает GET /health - проверка здоровья
@@ -180,16 +759,71 @@ func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
 		redisStatus = "connected"
 	}
 
+	checks := append([]health.Check{h.redisHealth(redisStatus), h.persistenceHealth}, h.healthChecks...)
+	overall, reasons := health.Evaluate(checks...)
+	if h.draining.Load() {
+		overall = "draining"
+	}
+
 	status := models.HealthStatus{
-		Status:    "healthy",
-		Timestamp: time.Now(),
+		Status:    overall,
+		Timestamp: h.clock.Now(),
 		Redis:     redisStatus,
-		Uptime:    time.Since(h.startTime).String(),
+		Uptime:    h.clock.Since(h.startTime).String(),
+		Reasons:   reasons,
 	}
 
 	h.respondJSON(w, status, http.StatusOK)
 }
 
+// ReadyzHandler обрабатывает GET /readyz - готовность принимать трафик.
+// Возвращает 503, пока окна анализатора не прогреты или не восстановлены из снимка
+func (h *Handler) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := h.readiness()
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	h.respondJSON(w, status, code)
+}
+
+// Ready сообщает, готов ли сервис принимать трафик, как GET /readyz
+func (h *Handler) Ready() bool {
+	return h.readiness().Ready
+}
+
+// readiness определяет готовность: окна прогреты или восстановлены, и сервис не останавливается
+func (h *Handler) readiness() models.ReadinessStatus {
+	samples := h.analyzer.Samples()
+	elapsed := h.clock.Since(h.startTime)
+
+	status := models.ReadinessStatus{
+		Status:          "warming_up",
+		Samples:         samples,
+		RequiredSamples: h.warmupSamples,
+		Elapsed:         elapsed.String(),
+		RequiredElapsed: h.warmupDuration.String(),
+		Restored:        h.analyzer.Restored(),
+	}
+
+	warm := h.warmupSamples == 0 && h.warmupDuration == 0
+	if h.warmupSamples > 0 && samples >= h.warmupSamples {
+		warm = true
+	}
+	if h.warmupDuration > 0 && elapsed >= h.warmupDuration {
+		warm = true
+	}
+
+	switch {
+	case h.draining.Load():
+		status.Status = "draining"
+	case warm || status.Restored:
+		status.Ready = true
+		status.Status = "ready"
+	}
+	return status
+}
+
 // StatsHandler обрабатывает GET /stats - статистика сервиса
 func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/stats", r.Method))
@@ -200,18 +834,32 @@ func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var totalMetrics int64
 	var anomaliesCount int64
+	var duplicateBatches int64
 
-	if h.cache != nil {
-		totalMetrics, _ = h.cache.GetCounter("metrics:total")
-		anomaliesCount, _ = h.cache.GetCounter("anomalies:total")
+	if h.counters != nil {
+		totalMetrics, _ = h.counters.Total(counters.MetricsTotal)
+		anomaliesCount, _ = h.counters.Total(counters.AnomaliesTotal)
+		duplicateBatches, _ = h.counters.Total(counters.DuplicateBatchesTotal)
 	}
 
-	avgCPU, avgRPS, _, _ := h.analyzer.GetStats()
+	avgCPU, avgRPS, _, _ := h.analyzer.GetStats("")
 
 	response := models.StatsResponse{
-		TotalMetrics:   totalMetrics,
-		AnomaliesCount: anomaliesCount,
-		CurrentRPS:     avgRPS,
+		TotalMetrics:     totalMetrics,
+		AnomaliesCount:   anomaliesCount,
+		CurrentRPS:       avgRPS,
+		DuplicateBatches: duplicateBatches,
+	}
+	if r, ok := h.analyzer.GetCorrelation(""); ok {
+		response.Correlation = &r
+	}
+	if h.snoozes != nil {
+		response.Snoozes = h.snoozes.List()
+	}
+	if h.availability != nil {
+		if a, err := h.availability.Report(); err == nil {
+			response.Availability = &a
+		}
 	}
 
 	// Обновляем Prometheus метрики
@@ -222,7 +870,8 @@ func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	h.respondJSON(w, response, http.StatusOK)
 }
 
-// LatestMetricsHandler возвращает последние метрики из кэша
+// LatestMetricsHandler возвращает последние метрики из кэша; с ?with_analysis=true
+// каждая метрика дополняется сохраненным результатом ее анализа
 func (h *Handler) LatestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/metrics/latest", r.Method))
 	defer timer.ObserveDuration()
@@ -233,6 +882,15 @@ func (h *Handler) LatestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 			count = c
 		}
 	}
+	withAnalysis := false
+	if v := r.URL.Query().Get("with_analysis"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			h.respondError(w, "with_analysis must be a boolean", http.StatusBadRequest)
+			return
+		}
+		withAnalysis = b
+	}
 
 	if h.cache == nil {
 		h.respondError(w, "Cache not available", http.StatusServiceUnavailable)
@@ -245,20 +903,96 @@ func (h *Handler) LatestMetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var body interface{} = metricsData
+	if withAnalysis {
+		joined, err := h.joinAnalysis(metricsData)
+		if err != nil {
+			h.respondError(w, "Failed to get analysis results: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = joined
+	}
+
 	metrics.RequestsTotal.WithLabelValues("/metrics/latest", r.Method, "200").Inc()
-	h.respondJSON(w, metricsData, http.StatusOK)
+	h.respondJSON(w, body, http.StatusOK)
+}
+
+// SeriesHandler обрабатывает GET /series - прореженный ряд метрики для графика.
+// Без resolution выбирается самый подробный уровень, дающий не больше maxSeriesPoints точек
+func (h *Handler) SeriesHandler(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/series", r.Method))
+	defer timer.ObserveDuration()
+
+	if h.rollup == nil {
+		h.respondError(w, "Series are not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	field := query.Get("metric")
+	if field == "" {
+		field = rollup.FieldCPU
+	}
+
+	span := time.Hour
+	if v := query.Get("range"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			h.respondError(w, "Invalid range: "+v, http.StatusBadRequest)
+			return
+		}
+		span = d
+	}
+
+	var resolution time.Duration
+	if v := query.Get("resolution"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			h.respondError(w, "Invalid resolution: "+v, http.StatusBadRequest)
+			return
+		}
+		if span/d > maxSeriesPoints {
+			h.respondError(w, fmt.Sprintf("Too many points for resolution %s, at most %d are allowed", v, maxSeriesPoints), http.StatusBadRequest)
+			return
+		}
+		resolution = d
+	} else {
+		d, err := h.rollup.Resolve(span, maxSeriesPoints)
+		if err != nil {
+			h.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resolution = d
+	}
+
+	to := h.clock.Now()
+	from := to.Add(-span)
+	points, err := h.rollup.Series(field, resolution, from, to)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics.RequestsTotal.WithLabelValues("/series", r.Method, "200").Inc()
+	h.respondJSON(w, models.SeriesResponse{
+		Metric:     field,
+		Resolution: rollup.FormatResolution(resolution),
+		From:       from.UTC(),
+		To:         to.UTC(),
+		Points:     points,
+	}, http.StatusOK)
 }
 
 // respondJSON отправляет JSON ответ
 func (h *Handler) respondJSON(w http.ResponseWriter, data interface{}, status int) {
-	w.Header().Set("Content-Type", "application/json")
+	setJSONContentType(w)
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+	encodeJSON(w, data)
 }
 
 // respondError отправляет ошибку в JSON формате
 func (h *Handler) respondError(w http.ResponseWriter, message string, status int) {
-	w.Header().Set("Content-Type", "application/json")
+	setJSONContentType(w)
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	encodeJSON(w, map[string]string{"error": message})
 }
@@ -0,0 +1,1056 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/admission"
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/backpressure"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/codec"
+	"highload-service/internal/counters"
+	"highload-service/internal/dedup"
+	"highload-service/internal/dlq"
+	"highload-service/internal/events"
+	"highload-service/internal/flags"
+	"highload-service/internal/health"
+	"highload-service/internal/journal"
+	"highload-service/internal/models"
+	"highload-service/internal/payload"
+	"highload-service/internal/rollup"
+	"highload-service/internal/sequencer"
+	"highload-service/internal/snooze"
+)
+
+func TestHealthHandler_UptimeUsesClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	h := NewHandler(analytics.NewAnalyzer(1), nil, WithClock(clk))
+
+	clk.Advance(90 * time.Minute)
+
+	rec := httptest.NewRecorder()
+	h.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var status models.HealthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Uptime != "1h30m0s" {
+		t.Errorf("Expected uptime 1h30m0s, got %s", status.Uptime)
+	}
+	if !status.Timestamp.Equal(clk.Now()) {
+		t.Errorf("Expected timestamp %v, got %v", clk.Now(), status.Timestamp)
+	}
+}
+
+func TestMetricsHandler_DefaultsTimestampFromClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	h := NewHandler(analytics.NewAnalyzer(1), nil, WithClock(clk))
+
+	rec := httptest.NewRecorder()
+	body := bytes.NewReader([]byte(`{"cpu":50,"rps":500}`))
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", body))
+
+	var result models.AnalysisResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !result.Timestamp.Equal(clk.Now()) {
+		t.Errorf("Expected timestamp %v, got %v", clk.Now(), result.Timestamp)
+	}
+}
+
+func FuzzMetricsHandler(f *testing.F) {
+	f.Add([]byte(`{"timestamp":"2024-01-01T12:00:00Z","cpu":45.5,"rps":500}`))
+	f.Add([]byte(`{"cpu":1e308,"rps":-1e308,"device_id":"sensor-1"}`))
+	f.Add([]byte(`{"cpu":"NaN"}`))
+	f.Add([]byte(`{"timestamp":"not-a-time"}`))
+	f.Add([]byte(`[1,2,3]`))
+	f.Add([]byte(``))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		h := NewHandler(analytics.NewAnalyzer(1), nil)
+
+		rec := httptest.NewRecorder()
+		h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader(body)))
+		assertJSONResponse(t, rec)
+
+		avgCPU, avgRPS, stdDevCPU, stdDevRPS := h.analyzer.GetStats("")
+		for _, v := range []float64{avgCPU, avgRPS, stdDevCPU, stdDevRPS} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("analyzer state poisoned by %q: %v", body, v)
+			}
+		}
+	})
+}
+
+func FuzzBatchMetricsHandler(f *testing.F) {
+	f.Add([]byte(`{"metrics":[{"cpu":45.5,"rps":500},{"cpu":99,"rps":10,"device_id":"d"}]}`))
+	f.Add([]byte(`{"metrics":[{"cpu":1e308},{"cpu":-1e308},{"rps":1.7976931348623157e308}]}`))
+	f.Add([]byte(`{"metrics":null}`))
+	f.Add([]byte(`{"metrics":[null,{}]}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		h := NewHandler(analytics.NewAnalyzer(1), nil)
+
+		rec := httptest.NewRecorder()
+		h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", bytes.NewReader(body)))
+		assertJSONResponse(t, rec)
+	})
+}
+
+func assertJSONResponse(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("response is not valid JSON: %q", rec.Body.String())
+	}
+}
+
+func TestLatestMetricsHandler_ReturnsCachedMetrics(t *testing.T) {
+	c := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(1), c)
+
+	for _, cpu := range []float64{10, 20, 30} {
+		rec := httptest.NewRecorder()
+		body, _ := json.Marshal(models.Metric{CPU: cpu, RPS: 100})
+		h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.LatestMetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics/latest?count=2", nil))
+
+	var latest []models.Metric
+	if err := json.NewDecoder(rec.Body).Decode(&latest); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(latest) != 2 || latest[0].CPU != 30 || latest[1].CPU != 20 {
+		t.Errorf("Expected [30 20], got %+v", latest)
+	}
+}
+
+func TestLatestMetricsHandler_JoinsAnalysisResults(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := cache.NewMemoryCache(clk)
+	h := NewHandler(analytics.NewAnalyzer(1), c, WithClock(clk))
+
+	// The result of the first metric expires before the metric itself
+	for _, cpu := range []float64{10, 20, 30} {
+		if _, err := h.Ingest(models.Metric{DeviceID: "sensor-1", CPU: cpu, RPS: 100}); err != nil {
+			t.Fatalf("Ingest failed: %v", err)
+		}
+		clk.Advance(2 * time.Minute)
+	}
+
+	rec := httptest.NewRecorder()
+	h.LatestMetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics/latest?with_analysis=true", nil))
+	var latest []LatestMetric
+	if err := json.NewDecoder(rec.Body).Decode(&latest); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(latest) != 3 || latest[0].CPU != 30 || latest[2].CPU != 10 {
+		t.Fatalf("Expected [30 20 10], got %+v", latest)
+	}
+	if a := latest[0].Analysis; a == nil || a.RollingAvgCPU != 20 || !a.Timestamp.Equal(latest[0].Timestamp) {
+		t.Errorf("Expected the result of the newest metric, got %+v", a)
+	}
+	if latest[1].Analysis == nil || latest[2].Analysis != nil {
+		t.Errorf("Expected only the oldest result to have expired, got %+v and %+v", latest[1].Analysis, latest[2].Analysis)
+	}
+
+	rec = httptest.NewRecorder()
+	h.LatestMetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics/latest?with_analysis=yes", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-boolean with_analysis, got %d", rec.Code)
+	}
+}
+
+func TestStatsHandler_MergesCountersAcrossReplicas(t *testing.T) {
+	shared := cache.NewMemoryCache(nil)
+	replicas := []*Handler{
+		NewHandler(analytics.NewAnalyzer(1), shared, WithCounters(counters.New(shared, "eu-1"))),
+		NewHandler(analytics.NewAnalyzer(1), shared, WithCounters(counters.New(shared, "us-1"))),
+	}
+
+	replicas[0].MetricsHandler(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader([]byte(`{"cpu":10,"rps":100}`))))
+	replicas[1].BatchMetricsHandler(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/metrics/batch", bytes.NewReader([]byte(`{"metrics":[{"cpu":1},{"cpu":2}]}`))))
+
+	for i, h := range replicas {
+		rec := httptest.NewRecorder()
+		h.StatsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		var stats models.StatsResponse
+		json.NewDecoder(rec.Body).Decode(&stats)
+		if stats.TotalMetrics != 3 {
+			t.Errorf("replica %d: expected global total of 3 metrics, got %d", i, stats.TotalMetrics)
+		}
+	}
+}
+
+func TestHealthHandler_ReportsCacheStatus(t *testing.T) {
+	c := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(1), c)
+
+	rec := httptest.NewRecorder()
+	h.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var status models.HealthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Redis != "connected" {
+		t.Errorf("Expected connected cache, got %s", status.Redis)
+	}
+	if status.Status != health.Healthy || status.Reasons == nil || len(status.Reasons) != 0 {
+		t.Errorf("Expected a healthy status with no reasons, got %q %+v", status.Status, status.Reasons)
+	}
+}
+
+func TestHealthHandler_ReportsDegradation(t *testing.T) {
+	used := 0
+	queue := health.Saturation("analyzer_queue", health.Config{QueueDegraded: 0.8, QueueUnhealthy: 1}, func() (int, int) { return used, 10 })
+	failing := map[string]int{}
+	sinks := health.SinkFailures(func() map[string]int { return failing })
+	h := NewHandler(analytics.NewAnalyzer(1), cache.NewMemoryCache(nil), WithHealthChecks(queue, sinks))
+
+	check := func() models.HealthStatus {
+		rec := httptest.NewRecorder()
+		h.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected /health to answer 200, got %d", rec.Code)
+		}
+		var status models.HealthStatus
+		json.NewDecoder(rec.Body).Decode(&status)
+		return status
+	}
+
+	// A failing sink only degrades the service: events wait in the outbox
+	failing["pager"] = 3
+	status := check()
+	if status.Status != health.Degraded || len(status.Reasons) != 1 ||
+		status.Reasons[0].Code != health.CodeSinkFailing || status.Reasons[0].Component != "outbox/pager" {
+		t.Fatalf("Expected a degraded status for the failing sink, got %+v", status)
+	}
+
+	// A full analyzer queue is the worst reason and comes first
+	used = 10
+	status = check()
+	if status.Status != health.Unhealthy || len(status.Reasons) != 2 || status.Reasons[0].Code != health.CodeQueueSaturated {
+		t.Fatalf("Expected an unhealthy status led by the saturated queue, got %+v", status)
+	}
+
+	used, failing = 8, map[string]int{}
+	if status = check(); status.Status != health.Degraded || status.Reasons[0].Level != health.Degraded {
+		t.Errorf("Expected a queue above 80%% to degrade the service, got %+v", status)
+	}
+
+	// Without a cache metrics live in replica memory only
+	h = NewHandler(analytics.NewAnalyzer(1), nil)
+	if status = check(); status.Status != health.Degraded || status.Reasons[0].Code != health.CodeRedisUnavailable {
+		t.Errorf("Expected a missing cache to degrade the service, got %+v", status)
+	}
+}
+
+func TestMetricsHandler_RejectsWhileDraining(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(1), nil)
+	h.StartDraining()
+
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader([]byte(`{"cpu":1}`))))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 while draining, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+
+	rec = httptest.NewRecorder()
+	h.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var status models.HealthStatus
+	json.NewDecoder(rec.Body).Decode(&status)
+	if status.Status != "draining" {
+		t.Errorf("Expected draining health status, got %q", status.Status)
+	}
+}
+
+func TestReadyzHandler_WarmupGate(t *testing.T) {
+	readyz := func(h *Handler) int {
+		rec := httptest.NewRecorder()
+		h.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	h := NewHandler(analytics.NewAnalyzer(1), nil, WithClock(clk), WithWarmup(3, time.Minute))
+	if code := readyz(h); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 before warmup, got %d", code)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.analyzer.AnalyzeSync(models.Metric{CPU: 50, RPS: 500})
+	}
+	if code := readyz(h); code != http.StatusOK {
+		t.Errorf("Expected 200 after warmup samples, got %d", code)
+	}
+
+	h = NewHandler(analytics.NewAnalyzer(1), nil, WithClock(clk), WithWarmup(3, time.Minute))
+	clk.Advance(time.Minute)
+	if code := readyz(h); code != http.StatusOK {
+		t.Errorf("Expected 200 after warmup duration, got %d", code)
+	}
+
+	h.StartDraining()
+	if code := readyz(h); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", code)
+	}
+}
+
+func TestReadyzHandler_RestoredSkipsWarmup(t *testing.T) {
+	analyzer := analytics.NewAnalyzer(1)
+	h := NewHandler(analyzer, nil, WithWarmup(100, time.Hour))
+
+	analyzer.MarkRestored()
+
+	rec := httptest.NewRecorder()
+	h.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after snapshot restore, got %d", rec.Code)
+	}
+}
+
+func TestRoutes_GzipBatches(t *testing.T) {
+	router := mux.NewRouter()
+	NewHandler(analytics.NewAnalyzer(10), nil).RegisterRoutes(router)
+	send := func(body string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, body)
+		zw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/metrics/batch", &buf)
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(`{"metrics":[{"cpu":1,"rps":2},{"cpu":3,"rps":4}]}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected a gzipped batch accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Whitespace compresses about a thousand times, like a decompression bomb
+	if rec := send(`{"metrics":[` + strings.Repeat(" ", 16<<20)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a decompression bomb, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRoutes_Protobuf(t *testing.T) {
+	router := mux.NewRouter()
+	NewHandler(analytics.NewAnalyzer(10), nil).RegisterRoutes(router)
+	send := func(path string, v interface{}, accept string) *httptest.ResponseRecorder {
+		body, err := codec.Protobuf.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	batch := models.MetricsBatch{Metrics: []models.Metric{{CPU: 10, RPS: 100, DeviceID: "d1"}, {CPU: -1, RPS: 1}, {CPU: 12, RPS: 90}}}
+	rec := send("/metrics/batch", batch, "application/x-protobuf")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("Expected a protobuf response, got %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	var resp models.BatchResponse
+	if err := codec.Protobuf.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Processed != 2 || resp.Rejected != 1 || resp.Stats.CPU.Count != 2 || resp.Results[1].RollingAvgCPU != 11 {
+		t.Errorf("Unexpected batch response %+v", resp)
+	}
+
+	// Without Accept the protobuf request gets the usual JSON response
+	rec = send("/metrics", batch.Metrics[0], "")
+	var result models.AnalysisResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK || result.Timestamp.IsZero() {
+		t.Errorf("Expected a JSON result, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = send("/metrics", batch.Metrics[0], "application/json;q=0.5, application/x-protobuf")
+	if err := codec.Protobuf.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.RollingAvgCPU == 0 {
+		t.Errorf("Expected a protobuf result, got %+v (%v)", result, err)
+	}
+	if vary := rec.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "Accept") {
+		t.Errorf("Expected Vary: Accept, got %v", vary)
+	}
+}
+
+func TestRoutes_MessagePack(t *testing.T) {
+	router := mux.NewRouter()
+	NewHandler(analytics.NewAnalyzer(10), nil).RegisterRoutes(router)
+	send := func(path string, v interface{}) *httptest.ResponseRecorder {
+		body, err := codec.MessagePack.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set("Accept", "application/msgpack")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	batch := models.MetricsBatch{Metrics: []models.Metric{{CPU: 10, RPS: 100, DeviceID: "d1"}, {CPU: -1, RPS: 1}, {CPU: 12, RPS: 90}}}
+	rec := send("/metrics/batch", batch)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/msgpack" {
+		t.Fatalf("Expected a msgpack response, got %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	var resp models.BatchResponse
+	if err := codec.MessagePack.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Processed != 2 || resp.Rejected != 1 || resp.Stats.CPU.Count != 2 || resp.Results[1].RollingAvgCPU != 11 {
+		t.Errorf("Unexpected batch response %+v", resp)
+	}
+
+	rec = send("/metrics", batch.Metrics[0])
+	var result models.AnalysisResult
+	if err := codec.MessagePack.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK || result.Timestamp.IsZero() {
+		t.Errorf("Expected a msgpack result, got %d %+v (%v)", rec.Code, result, err)
+	}
+}
+
+func TestRoutes_BatchIngestFeatureFlag(t *testing.T) {
+	set := flags.New(map[string]flags.Flag{flags.BatchIngest: {Enabled: true, DisabledTenants: []string{"legacy"}}})
+	router := mux.NewRouter()
+	NewHandler(analytics.NewAnalyzer(10), nil, WithFlags(set)).RegisterRoutes(router)
+
+	for tenant, want := range map[string]int{"legacy": http.StatusNotFound, "modern": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/metrics/batch", bytes.NewReader([]byte(`{"metrics":[{"cpu":1,"rps":2}]}`)))
+		req.Header.Set(flags.TenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("tenant %q: expected %d, got %d", tenant, want, rec.Code)
+		}
+	}
+}
+
+func TestMetricsHandler_RejectsWhileAnalyzerQueueIsSaturated(t *testing.T) {
+	analyzer := analytics.NewAnalyzer(4)
+	defer analyzer.Stop()
+	gate := backpressure.New(backpressure.Config{HighWater: 0.75, LowWater: 0.25}, []backpressure.Source{{
+		Name:  "analyzer_queue",
+		Level: func() (int, int) { return analyzer.QueueLength(), analyzer.QueueCapacity() },
+	}})
+	h := NewHandler(analyzer, nil, WithBackpressure(gate, 1500*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		analyzer.Submit(models.Metric{CPU: 50, RPS: 100})
+	}
+
+	for _, endpoint := range []struct {
+		path   string
+		body   string
+		handle http.HandlerFunc
+	}{
+		{"/metrics", `{"cpu":50,"rps":500}`, h.MetricsHandler},
+		{"/metrics/batch", `{"metrics":[{"cpu":50,"rps":500}]}`, h.BatchMetricsHandler},
+	} {
+		rec := httptest.NewRecorder()
+		endpoint.handle(rec, httptest.NewRequest(http.MethodPost, endpoint.path, strings.NewReader(endpoint.body)))
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("%s: expected 429 with Retry-After 2, got %d %q", endpoint.path, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// Workers drain the queue below the low-water mark and ingestion resumes
+	analyzer.Start(1)
+	deadline := time.Now().Add(time.Second)
+	for analyzer.QueueLength() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(`{"cpu":50,"rps":500}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected ingestion to resume after the queue drained, got %d", rec.Code)
+	}
+}
+
+func TestBatchMetricsHandler_TranslatesVendorPayloads(t *testing.T) {
+	mappings, err := payload.Parse(`{"acme": {"device_id": "$.serial", "records": "$.readings", "cpu": "@.load", "rps": "@.req"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(analytics.NewAnalyzer(10), nil, WithPayloadMappings(mappings))
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(`{"serial": "a-1", "readings": [{"load": 40, "req": 100}, {"load": 41, "req": 90}]}`))
+	req.Header.Set(payload.ModelHeader, "acme")
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, req)
+	var resp struct {
+		Processed int `json:"processed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK || resp.Processed != 2 {
+		t.Fatalf("Expected 2 translated metrics, got %d: %+v (%v)", rec.Code, resp, err)
+	}
+	if n := h.analyzer.Samples(); n != 2 {
+		t.Errorf("Expected 2 analyzed metrics, got %d", n)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(`{"serial": "a-1"}`))
+	req.Header.Set(payload.ModelHeader, "acme")
+	rec = httptest.NewRecorder()
+	h.MetricsHandler(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "records: $.readings not found") {
+		t.Errorf("Expected 400 naming the missing records, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBatchMetricsHandler_StreamsLargeBatches(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(10), nil)
+
+	var body bytes.Buffer
+	body.WriteString(`{"metrics":[`)
+	for i := 0; i < 2500; i++ {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		fmt.Fprintf(&body, `{"cpu":%d,"rps":100}`, 40+i%5)
+	}
+	body.WriteString(`,{"cpu":-1,"rps":1}]}`)
+
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", &body))
+	var resp struct {
+		Processed int                     `json:"processed"`
+		Rejected  int                     `json:"rejected"`
+		Results   []models.AnalysisResult `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected a valid JSON response, got %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Processed != 2500 || resp.Rejected != 1 || len(resp.Results) != 2500 {
+		t.Fatalf("Expected 2500 results and 1 rejected, got %d: processed %d, rejected %d, %d results",
+			rec.Code, resp.Processed, resp.Rejected, len(resp.Results))
+	}
+	if !rec.Flushed {
+		t.Error("Expected results to be flushed while the batch was processed")
+	}
+}
+
+func TestBatchMetricsHandler_ReportsBatchStats(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(10), nil)
+
+	// The rejected first metric shifts results against positions in the batch
+	var body bytes.Buffer
+	body.WriteString(`{"metrics":[{"cpu":-1}`)
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&body, `,{"cpu":%d,"rps":100,"values":{"temp":%d}}`, 40+i%2*2, 20+i%2)
+	}
+	body.WriteString(`,{"cpu":99,"rps":100}]}`)
+
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", &body))
+	var resp struct {
+		Results []models.AnalysisResult `json:"results"`
+		Stats   models.BatchStats       `json:"stats"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected a valid JSON response, got %v", err)
+	}
+
+	cpu := resp.Stats.CPU
+	if cpu.Count != 31 || cpu.Min != 40 || cpu.Max != 99 || math.Abs(cpu.Mean-(15*40+15*42+99)/31.0) > 1e-9 || cpu.StdDev <= 0 {
+		t.Errorf("Unexpected cpu stats %+v", cpu)
+	}
+	if rps := resp.Stats.RPS; rps.Mean != 100 || rps.StdDev != 0 {
+		t.Errorf("Expected a constant rps, got %+v", rps)
+	}
+	if temp := resp.Stats.Values["temp"]; temp.Count != 30 || math.Abs(temp.StdDev-0.5085) > 1e-3 {
+		t.Errorf("Unexpected temp stats %+v", temp)
+	}
+
+	var want []int
+	for i, result := range resp.Results {
+		if result.AnomalyDetected {
+			want = append(want, i+1)
+		}
+	}
+	if len(want) == 0 || fmt.Sprint(resp.Stats.AnomalyIndices) != fmt.Sprint(want) {
+		t.Errorf("Expected anomaly indices %v, got %v", want, resp.Stats.AnomalyIndices)
+	}
+}
+
+func TestBatchMetricsHandler_ReordersSequencedMetrics(t *testing.T) {
+	analyzer := analytics.NewAnalyzer(10)
+	h := NewHandler(analyzer, nil,
+		WithSequencer(sequencer.New(sequencer.Config{Mode: sequencer.ModeReorder, Buffer: 4, MaxWait: time.Minute})))
+
+	// 3 waits for 2 and is analyzed right after it; the repeated 1 is late
+	body := `{"metrics":[{"device_id":"d1","seq":1,"cpu":10},{"device_id":"d1","seq":3,"cpu":30},` +
+		`{"device_id":"d1","seq":2,"cpu":20},{"device_id":"d1","seq":1,"cpu":10}]}`
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+	var resp struct {
+		Processed int                     `json:"processed"`
+		Results   []models.AnalysisResult `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected a valid JSON response, got %v", err)
+	}
+
+	var got []string
+	for _, r := range resp.Results {
+		got = append(got, fmt.Sprintf("%d%s", r.Seq, r.Sequencing))
+	}
+	if resp.Processed != 3 || strings.Join(got, " ") != "1 3held 2 3 1late" {
+		t.Errorf("Expected 3 metrics analyzed in order, got %d: %v", resp.Processed, got)
+	}
+	if n := analyzer.Samples(); n != 3 {
+		t.Errorf("Expected the late metric to stay out of the windows, got %d samples", n)
+	}
+}
+
+func TestMetricsHandlers_ReportStageTimings(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(10), store)
+	stages := []string{"decode;dur=", "validate;dur=", "analyze;dur=", "cache_write;dur=", "sink_publish;dur=", "total;dur="}
+
+	// Without the debug header no breakdown is sent
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(`{"cpu":10,"rps":100}`)))
+	if got := rec.Header().Get("Server-Timing"); got != "" {
+		t.Errorf("Expected no Server-Timing without %s, got %q", DebugTimingHeader, got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(`{"cpu":10,"rps":100}`))
+	req.Header.Set(DebugTimingHeader, "1")
+	rec = httptest.NewRecorder()
+	h.MetricsHandler(rec, req)
+	timing := rec.Header().Get("Server-Timing")
+	for _, stage := range stages {
+		if !strings.Contains(timing, stage) {
+			t.Errorf("Expected %q in Server-Timing %q", stage, timing)
+		}
+	}
+
+	// The batch response is streamed, so the breakdown arrives as a trailer
+	req = httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(`{"metrics":[{"cpu":1},{"cpu":2}]}`))
+	req.Header.Set(DebugTimingHeader, "true")
+	rec = httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, req)
+	resp := rec.Result()
+	io.Copy(io.Discard, resp.Body)
+	if timing := resp.Trailer.Get("Server-Timing"); !strings.HasPrefix(timing, stages[0]) {
+		t.Errorf("Expected the stage breakdown in the trailer, got %q", timing)
+	}
+}
+
+func TestMetricsHandlers_RouteInvalidMetricsToDeadLetters(t *testing.T) {
+	deadLetters := dlq.New(cache.NewMemoryCache(nil))
+	h := NewHandler(analytics.NewAnalyzer(10), nil, WithDeadLetters(deadLetters))
+
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", bytes.NewReader([]byte(`{"cpu":-5,"rps":10,"device_id":"d1"}`))))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for negative cpu, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	body := `{"metrics":[{"cpu":10,"rps":100},{"cpu":20,"rps":-1,"device_id":"d2"}]}`
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", bytes.NewReader([]byte(body))))
+	var resp struct {
+		Processed int `json:"processed"`
+		Rejected  int `json:"rejected"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Processed != 1 || resp.Rejected != 1 {
+		t.Fatalf("Expected 1 processed and 1 rejected, got %d %+v", rec.Code, resp)
+	}
+
+	entries, _ := deadLetters.List(dlq.ReasonValidation, 10)
+	if len(entries) != 2 || entries[0].Metric.DeviceID != "d1" || entries[1].Metric.DeviceID != "d2" {
+		t.Fatalf("Expected both invalid metrics in the queue, got %+v", entries)
+	}
+
+	// Requeue runs the metric through ingestion again, so it fails validation again
+	result, _ := deadLetters.Requeue([]string{entries[0].ID}, h.Reprocess)
+	if result.Requeued != 0 || len(result.Failed) != 1 {
+		t.Errorf("Expected invalid metric to stay dead-lettered, got %+v", result)
+	}
+	if samples := h.analyzer.Samples(); samples != 1 {
+		t.Errorf("Expected only the valid metric to be analyzed, got %d samples", samples)
+	}
+}
+
+func TestBatchMetricsHandler_DropsDuplicateBatches(t *testing.T) {
+	shared := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(10), shared,
+		WithCounters(counters.New(shared, "n1")), WithBatchDedup(dedup.New(shared, time.Minute, "n1")))
+
+	post := func(body string) (resp struct {
+		Processed int  `json:"processed"`
+		Duplicate bool `json:"duplicate"`
+	}) {
+		rec := httptest.NewRecorder()
+		h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+
+	// A gateway retry resends the same bytes; only the first copy is analyzed
+	batch := `{"metrics":[{"timestamp":"2024-01-01T12:00:00Z","cpu":10,"rps":100},{"timestamp":"2024-01-01T12:00:01Z","cpu":20,"rps":100}]}`
+	if resp := post(batch); resp.Processed != 2 || resp.Duplicate {
+		t.Fatalf("Expected the first batch to be processed, got %+v", resp)
+	}
+	if resp := post(batch); resp.Processed != 0 || !resp.Duplicate {
+		t.Fatalf("Expected the repeated batch to be dropped, got %+v", resp)
+	}
+	// The duplicate answer is negotiated like the batch response itself
+	var rec *httptest.ResponseRecorder
+	for _, c := range []codec.Codec{codec.Protobuf, codec.MessagePack} {
+		req := httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(batch))
+		req.Header.Set("Accept", c.ContentType())
+		rec = httptest.NewRecorder()
+		h.BatchMetricsHandler(rec, req)
+		var decoded models.BatchResponse
+		if err := c.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || !decoded.Duplicate || rec.Header().Get("Content-Type") != c.ContentType() {
+			t.Fatalf("Expected a %s duplicate response, got %+v (err %v, headers %v)", c.Name(), decoded, err, rec.Header())
+		}
+	}
+	if resp := post(`{"metrics":[{"timestamp":"2024-01-01T12:00:02Z","cpu":30,"rps":100}]}`); resp.Processed != 1 || resp.Duplicate {
+		t.Fatalf("Expected a different batch to be processed, got %+v", resp)
+	}
+	// Without timestamps the server stamps the metrics, so equal bodies are new readings
+	untimed := `{"metrics":[{"cpu":40,"rps":100}]}`
+	if resp := post(untimed); resp.Processed != 1 || resp.Duplicate {
+		t.Fatalf("Expected an untimed batch to be processed, got %+v", resp)
+	}
+	if resp := post(untimed); resp.Processed != 1 || resp.Duplicate {
+		t.Fatalf("Expected a repeated untimed batch to be processed, got %+v", resp)
+	}
+	if samples := h.analyzer.Samples(); samples != 5 {
+		t.Errorf("Expected 5 analyzed samples, got %d", samples)
+	}
+
+	rec = httptest.NewRecorder()
+	h.StatsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats models.StatsResponse
+	json.NewDecoder(rec.Body).Decode(&stats)
+	if stats.DuplicateBatches != 3 || stats.TotalMetrics != 5 {
+		t.Errorf("Expected 3 duplicate batches and 5 metrics in /stats, got %+v", stats)
+	}
+}
+
+func TestBatchMetricsHandler_RetriesRejectedBatch(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	shared := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(10), shared,
+		WithAdmission(admission.New(admission.Config{Rate: 1, Burst: 1}, admission.WithClock(clk))),
+		WithBatchDedup(dedup.New(shared, time.Minute, "n1")))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := post(`{"metrics":[{"timestamp":"2024-01-01T12:00:00Z","cpu":10,"rps":100}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// The bucket is empty, so the batch is rejected and must not be remembered
+	batch := `{"metrics":[{"timestamp":"2024-01-01T12:00:01Z","cpu":20,"rps":100}]}`
+	if rec := post(batch); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	clk.Advance(time.Second)
+	rec := post(batch)
+	var resp struct {
+		Processed int  `json:"processed"`
+		Duplicate bool `json:"duplicate"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Processed != 1 || resp.Duplicate {
+		t.Fatalf("Expected the retried batch to be processed, got %d %+v", rec.Code, resp)
+	}
+}
+
+func TestJournalHandler_StreamResumesFromLastEventID(t *testing.T) {
+	results := journal.New(cache.NewMemoryCache(nil))
+	h := NewHandler(analytics.NewAnalyzer(1), nil, WithJournal(results))
+	router := mux.NewRouter()
+	h.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, cpu := range []float64{10, 20, 30} {
+		if _, err := h.Ingest(models.Metric{DeviceID: "sensor-1", CPU: cpu, RPS: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen, _, _ := results.Read("", 1, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/journal", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", seen[0].Cursor)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	// The stream continues with the second result, not the one the client already saw
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e journal.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Cursor == seen[0].Cursor || e.DeviceID != "sensor-1" {
+			t.Errorf("Expected the entry after %s, got %+v", seen[0].Cursor, e)
+		}
+		return
+	}
+	t.Fatalf("Stream ended without events: %v", scanner.Err())
+}
+
+func TestForecastHandler_ContinuesTrend(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(30*time.Minute + 20*time.Second))
+	series := rollup.New()
+	for i := 0; i < 30; i++ {
+		// One silent minute is bridged by the previous value
+		if i == 15 {
+			continue
+		}
+		series.Observe(models.Metric{Timestamp: start.Add(time.Duration(i) * time.Minute), CPU: float64(10 + i), RPS: 100}, false)
+	}
+	// The current minute is still filling up and stays out of the history
+	series.Observe(models.Metric{Timestamp: clk.Now(), CPU: 500, RPS: 100}, false)
+	h := NewHandler(analytics.NewAnalyzer(1), nil, WithClock(clk), WithRollup(series))
+
+	rec := httptest.NewRecorder()
+	h.ForecastHandler(rec, httptest.NewRequest(http.MethodGet, "/forecast?season=0&history=30m&resolution=1m&horizon=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp ForecastResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Points) != 3 || !resp.Points[0].Time.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("Expected 3 points from 12:30, got %+v", resp.Points)
+	}
+	for i, p := range resp.Points {
+		if want := float64(40 + i); math.Abs(p.Value-want) > 1.5 || p.Lower > p.Value || p.Upper < p.Value {
+			t.Errorf("Point %d: expected about %v within its interval, got %+v", i, want, p)
+		}
+	}
+}
+
+func TestSnoozeHandler_ExcludesDeviceFromAnomalies(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tracker := anomalies.NewTracker(anomalies.WithClock(clk))
+	analyzer := analytics.NewAnalyzer(1, analytics.WithDetectorConfig(analytics.DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}))
+	h := NewHandler(analyzer, nil, WithClock(clk), WithAnomalies(tracker), WithSnoozes(snooze.New(clk)))
+	router := mux.NewRouter()
+	h.RegisterRoutes(router)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/devices/flaky-1/snooze?duration=30m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the device to be snoozed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for i := 0; i < 10; i++ {
+		h.Ingest(models.Metric{DeviceID: "flaky-1", CPU: float64(40 + i%2), RPS: 100})
+	}
+	result, _ := h.Ingest(models.Metric{DeviceID: "flaky-1", CPU: 95, RPS: 100})
+	if result.AnomalyDetected || !result.Snoozed || !result.IsAnomalyCPU {
+		t.Errorf("Expected the spike to be detected but snoozed, got %+v", result)
+	}
+	if list := tracker.List(""); len(list) != 0 {
+		t.Errorf("Expected no anomalies for a snoozed device, got %+v", list)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats models.StatsResponse
+	json.NewDecoder(rec.Body).Decode(&stats)
+	if len(stats.Snoozes) != 1 || stats.Snoozes[0].DeviceID != "flaky-1" || !stats.Snoozes[0].Until.Equal(clk.Now().Add(30*time.Minute)) {
+		t.Errorf("Expected the snooze in /stats, got %+v", stats.Snoozes)
+	}
+
+	// Once the snooze expires anomalies are tracked again
+	clk.Advance(31 * time.Minute)
+	if result, _ := h.Ingest(models.Metric{DeviceID: "flaky-1", CPU: 99, RPS: 100}); !result.AnomalyDetected {
+		t.Errorf("Expected the anomaly to count after the snooze, got %+v", result)
+	}
+	if list := tracker.List(""); len(list) != 1 {
+		t.Errorf("Expected one tracked anomaly, got %d", len(list))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/devices/flaky-1/snooze?duration=30d", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a snooze longer than a week to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestIngest_PinsAnomalousResultsUntilResolved(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	ttl := cache.ResultTTL{Normal: time.Minute, Anomaly: time.Hour, Pinned: 24 * time.Hour}
+	tracker := anomalies.NewTracker(anomalies.WithClock(clk), anomalies.WithUnpin(func(keys []string) {
+		store.ExpireKeys(keys, ttl.Anomaly)
+	}))
+	analyzer := analytics.NewAnalyzer(1, analytics.WithDetectorConfig(analytics.DetectorConfig{WindowSize: 10, ZScoreThreshold: 3}))
+	h := NewHandler(analyzer, store, WithClock(clk), WithAnomalies(tracker), WithResultTTL(ttl))
+
+	normal := cache.ResultKey("sensor-1", clk.Now())
+	for i := 0; i < 10; i++ {
+		h.Ingest(models.Metric{DeviceID: "sensor-1", CPU: float64(40 + i%2), RPS: 100})
+		clk.Advance(time.Second)
+	}
+	spike := cache.ResultKey("sensor-1", clk.Now())
+	if result, _ := h.Ingest(models.Metric{DeviceID: "sensor-1", CPU: 95, RPS: 100}); !result.AnomalyDetected {
+		t.Fatalf("Expected the spike to be an anomaly, got %+v", result)
+	}
+	list := tracker.List("")
+	if len(list) != 1 || len(list[0].Results) != 1 || list[0].Results[0] != spike {
+		t.Fatalf("Expected the anomaly to reference %s, got %+v", spike, list)
+	}
+
+	// The pinned result outlives both the normal and the anomaly tiers while the anomaly is open
+	clk.Advance(2 * time.Hour)
+	var result models.AnalysisResult
+	if err := store.Get(normal, &result); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Expected the normal result to expire, got %v", err)
+	}
+	if err := store.Get(spike, &result); err != nil || result.ZScoreCPU < 3 {
+		t.Fatalf("Expected the pinned result to be kept, got %+v (%v)", result, err)
+	}
+
+	// Resolving the anomaly leaves the result the anomaly tier from now on
+	if _, err := tracker.Resolve(list[0].ID, "oncall"); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	clk.Advance(time.Hour - time.Second)
+	if err := store.Get(spike, &result); err != nil {
+		t.Errorf("Expected the result to be kept for the anomaly tier after resolve, got %v", err)
+	}
+	clk.Advance(time.Second)
+	if err := store.Get(spike, &result); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Expected the unpinned result to expire, got %v", err)
+	}
+}
+
+// batchCountingCache counts single and batch metric writes and fails them while broken is set
+type batchCountingCache struct {
+	*cache.MemoryCache
+	single, batches int
+	broken          bool
+}
+
+func (c *batchCountingCache) CacheMetric(m models.Metric) error {
+	c.single++
+	return c.MemoryCache.CacheMetric(m)
+}
+
+func (c *batchCountingCache) CacheMetricsBatch(ms []models.Metric) error {
+	c.batches++
+	if c.broken {
+		return errors.New("redis down")
+	}
+	return c.MemoryCache.CacheMetricsBatch(ms)
+}
+
+func TestBatchMetricsHandler_PersistsInOneWrite(t *testing.T) {
+	store := &batchCountingCache{MemoryCache: cache.NewMemoryCache(nil)}
+	deadLetters := dlq.New(cache.NewMemoryCache(nil))
+	h := NewHandler(analytics.NewAnalyzer(10), store, WithDeadLetters(deadLetters))
+
+	body := `{"metrics":[{"cpu":10,"rps":100,"device_id":"d1"},{"cpu":20,"rps":-1},{"cpu":30,"rps":300,"device_id":"d3"}]}`
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if store.batches != 1 || store.single != 0 {
+		t.Errorf("Expected one batch write, got %d batch and %d single writes", store.batches, store.single)
+	}
+	latest, _ := store.GetLatestMetrics(10)
+	if len(latest) != 2 || latest[0].DeviceID != "d3" || latest[1].DeviceID != "d1" {
+		t.Errorf("Expected both valid metrics stored newest first, got %+v", latest)
+	}
+
+	// A failed write dead-letters every metric of the batch for a retry
+	store.broken = true
+	rec = httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the batch to be analyzed despite the failed write, got %d", rec.Code)
+	}
+	entries, _ := deadLetters.List(dlq.ReasonPersistence, 10)
+	if len(entries) != 2 {
+		t.Errorf("Expected both valid metrics dead-lettered for persistence, got %+v", entries)
+	}
+}
+
+func TestBatchMetricsHandler_PublishesIngestedMetrics(t *testing.T) {
+	bus := events.New()
+	var ingested []string
+	events.Subscribe(bus, events.MetricIngested, func(e events.Ingested) {
+		ingested = append(ingested, e.Metric.DeviceID)
+	})
+	h := NewHandler(analytics.NewAnalyzer(10), nil, WithEvents(bus))
+
+	body := `{"metrics":[{"cpu":1,"rps":1,"device_id":"a"},{"cpu":-1,"rps":1,"device_id":"bad"},{"cpu":2,"rps":2,"device_id":"b"}]}`
+	rec := httptest.NewRecorder()
+	h.BatchMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Invalid metrics are not analyzed and never reach subscribers
+	if strings.Join(ingested, ",") != "a,b" {
+		t.Errorf("Expected metrics a and b published, got %v", ingested)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"time"
+
+	"highload-service/internal/health"
+	"highload-service/internal/models"
+)
+
+// persistenceFailureWindow сколько после неудачной записи в хранилище сервис
+// считается degraded
+const persistenceFailureWindow = time.Minute
+
+// persistFailed отмечает неудачную запись метрики в хранилище
+func (h *Handler) persistFailed() {
+	h.persistFailedAt.Store(h.clock.Now().UnixNano())
+}
+
+// redisHealth проверка хранилища по результату Ping: без него метрики и
+// счетчики хранятся только в памяти реплики
+func (h *Handler) redisHealth(redisStatus string) health.Check {
+	return func() []models.HealthReason {
+		if redisStatus == "connected" {
+			return nil
+		}
+		return []models.HealthReason{{
+			Component: "redis",
+			Code:      health.CodeRedisUnavailable,
+			Level:     health.Degraded,
+			Message:   "metrics are kept in replica memory only",
+		}}
+	}
+}
+
+// persistenceHealth проверка записи метрик: недавние ошибки записи означают,
+// что метрики копятся в очереди недоставленных
+func (h *Handler) persistenceHealth() []models.HealthReason {
+	last := h.persistFailedAt.Load()
+	if last == 0 {
+		return nil
+	}
+	at := time.Unix(0, last)
+	if h.clock.Since(at) > persistenceFailureWindow {
+		return nil
+	}
+	return []models.HealthReason{{
+		Component: "redis",
+		Code:      health.CodePersistenceFailing,
+		Level:     health.Degraded,
+		Message:   "last metric write failed at " + at.UTC().Format(time.RFC3339),
+	}}
+}
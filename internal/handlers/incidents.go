@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/incidents"
+)
+
+// ListIncidentsHandler обрабатывает GET /incidents - инциденты (аномалии, близкие
+// по времени) от новых к старым; ?active=true оставляет только активные,
+// limit ограничивает количество (по умолчанию 50; 0 — все)
+func (h *Handler) ListIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.incidents == nil {
+		h.respondError(w, "Incident correlation is not enabled", http.StatusNotFound)
+		return
+	}
+
+	activeOnly := false
+	if v := r.URL.Query().Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			h.respondError(w, "active must be a boolean", http.StatusBadRequest)
+			return
+		}
+		activeOnly = b
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.respondError(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	h.respondJSON(w, h.incidents.List(activeOnly, limit), http.StatusOK)
+}
+
+// GetIncidentHandler обрабатывает GET /incidents/{id} - инцидент с участниками
+// от наиболее отклонившихся
+func (h *Handler) GetIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	if h.incidents == nil {
+		h.respondError(w, "Incident correlation is not enabled", http.StatusNotFound)
+		return
+	}
+	inc, err := h.incidents.Get(mux.Vars(r)["id"])
+	if errors.Is(err, incidents.ErrNotFound) {
+		h.respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, inc, http.StatusOK)
+}
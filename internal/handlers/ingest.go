@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"highload-service/internal/dlq"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+	"highload-service/internal/sequencer"
+)
+
+// Ingest принимает одну метрику так же, как POST /metrics: проверка, сохранение,
+// анализ и учет. Используется и вне HTTP (потоки устройств). Ошибка проверки
+// оборачивает models.ErrInvalidMetric, остальные ошибки — ошибки анализа
+func (h *Handler) Ingest(metric models.Metric) (models.AnalysisResult, error) {
+	return h.ingestTimed(metric, nil)
+}
+
+// ingestTimed принимает метрику, добавляя время этапов в timings
+func (h *Handler) ingestTimed(metric models.Metric, timings *stageTimings) (models.AnalysisResult, error) {
+	// Устанавливаем временную метку, если не указана
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = h.clock.Now()
+	}
+
+	start := time.Now()
+	err := metric.Validate()
+	timings.since(stageValidate, start)
+	if err != nil {
+		h.deadLetter(metric, dlq.ReasonValidation, err)
+		return models.AnalysisResult{}, err
+	}
+
+	if h.sequencer == nil {
+		return h.analyze(metric, timings)
+	}
+	ready, verdict := h.sequencer.Offer(metric)
+	if verdict != sequencer.Ready {
+		return h.unsequenced(metric, verdict, timings), nil
+	}
+	// Метрика могла освободить задержанные метрики устройства: они анализируются
+	// по порядку, а в ответ попадает результат самой метрики
+	var result models.AnalysisResult
+	for _, m := range ready {
+		r, err := h.analyze(m, timings)
+		if m.Seq == metric.Seq {
+			result = r
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// IngestBatch принимает пакет метрик так же, как POST /metrics/batch, но
+// собирает ответ целиком (gRPC). Некорректные метрики не прерывают пакет и
+// учитываются в Rejected, задержанные упорядочиванием получают результат без
+// анализа. Результаты метрик, которые освободила задержанная, в ответ не попадают
+func (h *Handler) IngestBatch(batch []models.Metric) models.BatchResponse {
+	resp := models.BatchResponse{Results: make([]models.AnalysisResult, 0, len(batch))}
+	var stats batchStats
+	for i, metric := range batch {
+		// Ingest проверяет метрику сам и отправляет некорректную в очередь недоставленных
+		result, err := h.Ingest(metric)
+		if errors.Is(err, models.ErrInvalidMetric) {
+			resp.Rejected++
+			continue
+		}
+		stats.add(metric)
+		if err != nil {
+			resp.Rejected++
+			continue
+		}
+		resp.Results = append(resp.Results, result)
+		if result.Sequencing != "" {
+			continue
+		}
+		resp.Processed++
+		if result.AnomalyDetected {
+			resp.AnomaliesFound++
+			stats.anomaly(i)
+		}
+	}
+	resp.Stats = stats.result()
+	return resp
+}
+
+// analyze сохраняет, анализирует и учитывает принятую метрику
+func (h *Handler) analyze(metric models.Metric, timings *stageTimings) (models.AnalysisResult, error) {
+	// Кэшируем метрику в Redis
+	h.persistBefore(metric, timings)
+
+	// Отправляем на анализ
+	metrics.MetricsReceived.Inc()
+
+	// Синхронный анализ для ответа
+	startAnalysis := time.Now()
+	result, err := h.observe(metric, timings)
+	result.Seq = metric.Seq
+	metrics.AnalysisLatency.Observe(time.Since(startAnalysis).Seconds())
+	h.persistAfter(metric, result, err, timings)
+	if err != nil {
+		h.deadLetter(metric, dlq.ReasonAnalysis, err)
+		return result, err
+	}
+
+	// Обновляем метрики Prometheus
+	metrics.UpdateAnalysisMetrics(
+		result.RollingAvgCPU,
+		result.RollingAvgRPS,
+		result.ZScoreCPU,
+		result.ZScoreRPS,
+		result.AnomalyDetected,
+	)
+
+	// Кэшируем результат анализа
+	h.cacheResult(metric, result)
+	anomalies := 0
+	if result.AnomalyDetected {
+		anomalies = 1
+	}
+	h.countIngested(1, anomalies)
+	return result, nil
+}
+
+// unsequenced результат метрики, которая не анализируется сейчас. Задержанная
+// метрика сохранится при анализе, опоздавшая сохраняется сразу, чтобы не потерять данные
+func (h *Handler) unsequenced(metric models.Metric, verdict sequencer.Verdict, timings *stageTimings) models.AnalysisResult {
+	result := models.AnalysisResult{Timestamp: metric.Timestamp, Seq: metric.Seq, Sequencing: "held"}
+	if verdict == sequencer.Late {
+		result.Sequencing = "late"
+		h.persist(metric, timings)
+	}
+	return result
+}
+
+// AnalyzeReleased анализирует метрику, выпущенную упорядочиванием после ожидания
+// пропущенных номеров (sequencer.Sequencer.Run)
+func (h *Handler) AnalyzeReleased(metric models.Metric) {
+	_, _ = h.analyze(metric, nil)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/journal"
+)
+
+const (
+	// journalPollInterval наибольшая пауза между проверками журнала в потоке SSE
+	journalPollInterval = time.Second
+	// journalKeepAlive интервал комментариев SSE, которые не дают прокси закрыть тихое соединение
+	journalKeepAlive = 15 * time.Second
+)
+
+// JournalHandler обрабатывает GET /journal - результаты анализа из журнала после
+// курсора ?cursor= (или заголовка Last-Event-ID). С Accept: text/event-stream
+// отвечает потоком SSE, в котором id события — курсор записи, поэтому
+// переподключившийся EventSource продолжает с последнего полученного события.
+// ?anomalies=true оставляет только результаты с аномалией
+func (h *Handler) JournalHandler(w http.ResponseWriter, r *http.Request) {
+	if h.journal == nil {
+		h.respondError(w, "Results journal is not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+	if cursor == "" {
+		cursor = r.Header.Get("Last-Event-ID")
+	}
+	if cursor != "" && !journal.ValidCursor(cursor) {
+		h.respondError(w, "cursor must look like <unix ms>-<seq>", http.StatusBadRequest)
+		return
+	}
+	anomaliesOnly := false
+	if v := query.Get("anomalies"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			h.respondError(w, "anomalies must be a boolean", http.StatusBadRequest)
+			return
+		}
+		anomaliesOnly = b
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > journal.MaxRead {
+			h.respondError(w, fmt.Sprintf("limit must be between 1 and %d", journal.MaxRead), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	if acceptsEventStream(r) {
+		h.streamJournal(w, r, cursor, anomaliesOnly)
+		return
+	}
+
+	entries, next, err := h.journal.Read(cursor, limit, anomaliesOnly)
+	if errors.Is(err, journal.ErrInvalidCursor) {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.respondError(w, "Failed to read journal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.respondJSON(w, map[string]interface{}{
+		"entries":     entries,
+		"next_cursor": next,
+	}, http.StatusOK)
+}
+
+// streamJournal отправляет записи журнала событиями SSE до отключения клиента
+func (h *Handler) streamJournal(w http.ResponseWriter, r *http.Request, cursor string, anomaliesOnly bool) {
+	rc := http.NewResponseController(w)
+	// Поток бесконечен, таймаут записи сервера к нему не относится
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	lastWrite := time.Now()
+	for {
+		entries, next, err := h.journal.Read(cursor, journal.MaxRead, anomaliesOnly)
+		if err != nil {
+			log.Printf("Journal stream: %v", err)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+			rc.Flush()
+			return
+		}
+		for _, e := range entries {
+			data, _ := json.Marshal(e)
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: result\ndata: %s\n\n", e.Cursor, data); err != nil {
+				return
+			}
+		}
+		if next != cursor {
+			// Курсор продвигается и по пропущенным фильтром записям
+			if len(entries) == 0 {
+				fmt.Fprintf(w, "id: %s\n\n", next)
+			}
+			cursor = next
+			lastWrite = time.Now()
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			continue
+		}
+
+		if time.Since(lastWrite) >= journalKeepAlive {
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+		if !h.journal.Wait(r.Context(), journalPollInterval) {
+			return
+		}
+	}
+}
+
+// acceptsEventStream сообщает, просит ли клиент поток SSE
+func acceptsEventStream(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
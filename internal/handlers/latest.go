@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"highload-service/internal/cache"
+	"highload-service/internal/models"
+)
+
+// LatestMetric метрика ответа GET /metrics/latest?with_analysis=true
+type LatestMetric struct {
+	models.Metric
+	// Analysis сохраненный результат анализа метрики; отсутствует, если он уже
+	// истек или метрика не анализировалась (например, опоздала при упорядочивании)
+	Analysis *models.AnalysisResult `json:"analysis,omitempty"`
+}
+
+// joinAnalysis дополняет метрики сохраненными результатами анализа, читая их одним запросом
+func (h *Handler) joinAnalysis(list []models.Metric) ([]LatestMetric, error) {
+	keys := make([]string, len(list))
+	for i, m := range list {
+		keys[i] = cache.ResultKey(m.DeviceID, m.Timestamp)
+	}
+	results, err := h.cache.GetAnalysisResults(keys)
+	if err != nil {
+		return nil, err
+	}
+	joined := make([]LatestMetric, len(list))
+	for i, m := range list {
+		joined[i] = LatestMetric{Metric: m, Analysis: results[i]}
+	}
+	return joined, nil
+}
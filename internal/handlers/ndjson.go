@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"highload-service/internal/codec"
+	"highload-service/internal/metrics"
+	"highload-service/internal/middleware"
+	"highload-service/internal/models"
+)
+
+const (
+	// NDJSONContentType тип тела и ответа POST /metrics/stream
+	NDJSONContentType = "application/x-ndjson"
+	// MaxStreamLine наибольшая длина строки потока; более длинная строка прерывает поток
+	MaxStreamLine = 64 << 10
+	// streamIdleTimeout сколько поток может молчать: таймауты чтения и записи
+	// сервера к потоку не относятся, вместо них каждая строка продлевает срок
+	streamIdleTimeout = time.Minute
+)
+
+// StreamLineResult строка ответа потока: результат анализа метрики из строки
+// Line тела или код и причина отказа, как у отдельного POST /metrics
+type StreamLineResult struct {
+	Line   int                    `json:"line"`
+	Result *models.AnalysisResult `json:"result,omitempty"`
+	Status int                    `json:"status,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// StreamSummary итог потока, последняя строка ответа ({"summary": ...})
+type StreamSummary struct {
+	// Lines непустые строки тела
+	Lines          int `json:"lines"`
+	Processed      int `json:"processed"`
+	Rejected       int `json:"rejected"`
+	AnomaliesFound int `json:"anomalies_found"`
+	// Error причина, по которой поток прерван до конца тела: строка длиннее
+	// MaxStreamLine, ошибка чтения или остановка сервиса
+	Error string `json:"error,omitempty"`
+}
+
+// StreamMetricsHandler обрабатывает POST /metrics/stream - прием метрик потоком
+// NDJSON по одной на строку. Устройство держит одно соединение и отправляет
+// метрики по мере появления: каждая строка принимается как отдельный
+// POST /metrics, как только она прочитана, а результат сразу отправляется
+// строкой ответа. С ?summary=true результаты строк не отправляются, и ответ
+// состоит из одного итога после конца тела
+func (h *Handler) StreamMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues("/metrics/stream", r.Method))
+	defer timer.ObserveDuration()
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err != nil || (mediaType != NDJSONContentType && mediaType != "application/jsonl") {
+			h.respondError(w, "Unsupported Content-Type, expected "+NDJSONContentType, http.StatusUnsupportedMediaType)
+			metrics.RequestsTotal.WithLabelValues("/metrics/stream", r.Method, "415").Inc()
+			return
+		}
+	}
+	summaryOnly := false
+	if v := r.URL.Query().Get("summary"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			h.respondError(w, "summary must be a boolean", http.StatusBadRequest)
+			metrics.RequestsTotal.WithLabelValues("/metrics/stream", r.Method, "400").Inc()
+			return
+		}
+		summaryOnly = b
+	}
+	if h.rejectIfDraining(w, r, "/metrics/stream") || h.rejectIfSaturated(w, r, "/metrics/stream") {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// Ответ пишется, пока тело еще читается; HTTP/2 это умеет и без флага
+	_ = rc.EnableFullDuplex()
+	// Заголовки уходят с первыми результатами: ответ до чтения тела помешал бы
+	// клиенту с Expect: 100-continue отправить тело
+	w.Header().Set("Content-Type", NDJSONContentType)
+	out := bufio.NewWriterSize(w, streamBufferSize)
+	flush := func() error {
+		_ = rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		_ = rc.Flush()
+		return nil
+	}
+
+	var timings stageTimings
+	var summary StreamSummary
+	var writeErr error
+	in := bufio.NewReaderSize(r.Body, MaxStreamLine)
+	for n := 1; ; n++ {
+		// Ответ отправляется, когда прочитанные строки закончились и чтение
+		// будет ждать клиента, а не после каждой строки
+		if n > 1 && in.Buffered() == 0 && writeErr == nil {
+			writeErr = flush()
+		}
+		if h.draining.Load() {
+			summary.Error = "Service is shutting down"
+			break
+		}
+		_ = rc.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		line, err := in.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			summary.Error = "Line " + strconv.Itoa(n) + " exceeds " + strconv.Itoa(MaxStreamLine) + " bytes"
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			summary.Error = "Failed to read body: " + err.Error()
+			break
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			summary.Lines++
+			res := h.ingestLine(r, n, trimmed, &timings)
+			if res.Result != nil {
+				summary.Processed++
+				if res.Result.AnomalyDetected {
+					summary.AnomaliesFound++
+				}
+			} else {
+				summary.Rejected++
+			}
+			if !summaryOnly && writeErr == nil {
+				writeErr = writeStreamLine(out, res)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	metrics.RequestsTotal.WithLabelValues("/metrics/stream", r.Method, "200").Inc()
+	if writeErr == nil {
+		line, _ := json.Marshal(map[string]StreamSummary{"summary": summary})
+		out.Write(append(line, '\n'))
+		flush()
+	}
+}
+
+// ingestLine принимает метрику из строки потока n
+func (h *Handler) ingestLine(r *http.Request, n int, line []byte, timings *stageTimings) StreamLineResult {
+	res := StreamLineResult{Line: n}
+	var metric models.Metric
+	start := time.Now()
+	if err := codec.JSON.Unmarshal(line, &metric); err != nil {
+		res.Status, res.Error = http.StatusBadRequest, "Invalid JSON: "+err.Error()
+		return res
+	}
+	timings.since(stageDecode, start)
+	middleware.SetDeviceID(r, metric.DeviceID)
+
+	if h.backpressure != nil && h.backpressure.Check() {
+		res.Status, res.Error = http.StatusTooManyRequests, "Analysis queue is saturated"
+		return res
+	}
+	if h.admission != nil {
+		if err := h.admission.Admit(r.Context(), 1); err != nil {
+			res.Status, res.Error = http.StatusTooManyRequests, "Ingest rate limit exceeded"
+			return res
+		}
+	}
+
+	result, err := h.ingestTimed(metric, timings)
+	switch {
+	case errors.Is(err, models.ErrInvalidMetric):
+		res.Status, res.Error = http.StatusBadRequest, err.Error()
+	case err != nil:
+		res.Status, res.Error = http.StatusInternalServerError, "Analysis failed: "+err.Error()
+	default:
+		res.Result = &result
+	}
+	return res
+}
+
+// writeStreamLine дописывает строку ответа потока. Результат кодируется
+// сгенерированным кодировщиком модели прямо в буфер записи, отказы — encoding/json
+func writeStreamLine(out *bufio.Writer, res StreamLineResult) error {
+	if res.Result == nil {
+		line, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(line, '\n'))
+		return err
+	}
+	out.WriteString(`{"line":`)
+	out.WriteString(strconv.Itoa(res.Line))
+	out.WriteString(`,"result":`)
+	b, ok, err := models.AppendJSON(out.AvailableBuffer(), res.Result)
+	if !ok {
+		b, err = json.Marshal(res.Result)
+	}
+	if err != nil {
+		return err
+	}
+	out.Write(b)
+	_, err = out.WriteString("}\n")
+	return err
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+)
+
+// readStreamLines decodes every line of an NDJSON response
+func readStreamLines(t *testing.T, body string) []map[string]json.RawMessage {
+	t.Helper()
+	var lines []map[string]json.RawMessage
+	for _, raw := range strings.Split(strings.TrimSpace(body), "\n") {
+		var line map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("Invalid response line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestStreamMetricsHandler_ReportsEachLine(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	h := NewHandler(analytics.NewAnalyzer(10), store)
+
+	body := strings.Join([]string{
+		`{"cpu":45.5,"rps":500,"device_id":"sensor-1"}`,
+		``,
+		`{"cpu":-1,"rps":5}`,
+		`{"cpu":`,
+		`{"cpu":50,"rps":510,"device_id":"sensor-1"}`,
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/metrics/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", NDJSONContentType)
+	rec := httptest.NewRecorder()
+	h.StreamMetricsHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != NDJSONContentType {
+		t.Fatalf("Expected an NDJSON 200, got %d %v", rec.Code, rec.Header())
+	}
+
+	lines := readStreamLines(t, rec.Body.String())
+	if len(lines) != 5 {
+		t.Fatalf("Expected 4 line results and a summary, got %s", rec.Body.String())
+	}
+	// Blank lines are skipped but still counted in line numbers; the last
+	// line has no trailing newline
+	for i, want := range []string{`1`, `3`, `4`, `5`} {
+		if string(lines[i]["line"]) != want {
+			t.Errorf("Expected line %s in result %d, got %s", want, i, lines[i]["line"])
+		}
+	}
+	if lines[0]["result"] == nil || lines[3]["result"] == nil {
+		t.Errorf("Expected results for valid lines, got %s", rec.Body.String())
+	}
+	if string(lines[1]["status"]) != "400" || string(lines[2]["status"]) != "400" || lines[2]["error"] == nil {
+		t.Errorf("Expected 400 for the invalid and malformed lines, got %s", rec.Body.String())
+	}
+	var summary StreamSummary
+	json.Unmarshal(lines[4]["summary"], &summary)
+	if summary != (StreamSummary{Lines: 4, Processed: 2, Rejected: 2}) {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if latest, _ := store.GetLatestMetrics(10); len(latest) != 2 {
+		t.Errorf("Expected the valid metrics stored, got %+v", latest)
+	}
+}
+
+func TestStreamMetricsHandler_SummaryOnlyAndOverlongLines(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(10), nil)
+
+	rec := httptest.NewRecorder()
+	h.StreamMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/stream?summary=true",
+		strings.NewReader("{\"cpu\":1,\"rps\":2}\n{\"cpu\":3,\"rps\":4}\n")))
+	lines := readStreamLines(t, rec.Body.String())
+	if len(lines) != 1 || lines[0]["summary"] == nil {
+		t.Errorf("Expected only the summary, got %s", rec.Body.String())
+	}
+
+	body := "{\"cpu\":1,\"rps\":2}\n{\"device_id\":\"" + strings.Repeat("x", MaxStreamLine) + "\"}\n{\"cpu\":3,\"rps\":4}\n"
+	rec = httptest.NewRecorder()
+	h.StreamMetricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics/stream", strings.NewReader(body)))
+	lines = readStreamLines(t, rec.Body.String())
+	var summary StreamSummary
+	json.Unmarshal(lines[len(lines)-1]["summary"], &summary)
+	// The stream stops at the overlong line: later lines cannot be told apart from its tail
+	if summary.Processed != 1 || summary.Lines != 1 || !strings.Contains(summary.Error, "Line 2") {
+		t.Errorf("Expected the stream cut at line 2, got %+v", summary)
+	}
+}
+
+func TestStreamMetricsHandler_AnswersBeforeBodyEnds(t *testing.T) {
+	h := NewHandler(analytics.NewAnalyzer(10), nil)
+	router := mux.NewRouter()
+	h.RegisterRoutes(router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	body, send := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/metrics/stream", body)
+	req.Header.Set("Content-Type", NDJSONContentType)
+	type response struct {
+		resp *http.Response
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		responses <- response{resp, err}
+	}()
+
+	// Each result must arrive while the request body is still open
+	if _, err := io.WriteString(send, "{\"cpu\":1,\"rps\":2,\"device_id\":\"d1\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := <-responses
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.resp.Body.Close()
+	lines := bufio.NewScanner(r.resp.Body)
+	if !lines.Scan() || !strings.Contains(lines.Text(), `"line":1`) {
+		t.Fatalf("Expected the first result before the body ended, got %q", lines.Text())
+	}
+	io.WriteString(send, "{\"cpu\":3,\"rps\":4,\"device_id\":\"d1\"}\n")
+	if !lines.Scan() || !strings.Contains(lines.Text(), `"line":2`) {
+		t.Fatalf("Expected the second result, got %q", lines.Text())
+	}
+	send.Close()
+	if !lines.Scan() || !strings.Contains(lines.Text(), `"summary"`) {
+		t.Fatalf("Expected the summary after the body ended, got %q", lines.Text())
+	}
+}
+
+func FuzzStreamMetricsHandler(f *testing.F) {
+	f.Add([]byte("{\"cpu\":45.5,\"rps\":500,\"device_id\":\"d\"}\n\n{\"cpu\":-1}\n{\"cpu\":"))
+	f.Add([]byte("{\"cpu\":1e308,\"rps\":1}\r\n{\"cpu\":-1e308}\r\n  \t\n[1,2]\nnull\n"))
+	f.Add([]byte("{\"cpu\":1,\"rps\":2}{\"cpu\":3,\"rps\":4}\n\"\\u0000\"\n"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		h := NewHandler(analytics.NewAnalyzer(1), nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/metrics/stream", bytes.NewReader(body))
+		req.Header.Set("Content-Type", NDJSONContentType)
+		rec := httptest.NewRecorder()
+		h.StreamMetricsHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+
+		// Every non-blank line gets exactly one result and the summary adds them up
+		lines := readStreamLines(t, rec.Body.String())
+		var summary StreamSummary
+		if err := json.Unmarshal(lines[len(lines)-1]["summary"], &summary); err != nil {
+			t.Fatalf("last line is not a summary: %q", rec.Body.String())
+		}
+		if summary.Lines != len(lines)-1 || summary.Processed+summary.Rejected != summary.Lines {
+			t.Fatalf("summary %+v does not match %d line results", summary, len(lines)-1)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/dlq"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+// defaultResultTTL сроки хранения результатов без WithResultTTL
+var defaultResultTTL = cache.DefaultResultTTL()
+
+// cacheResult сохраняет результат анализа на срок его уровня. Результат с
+// аномалией закрепляется за незакрытой аномалией устройства и хранится, пока
+// она не закроется; ошибка записи не прерывает обработку
+func (h *Handler) cacheResult(metric models.Metric, result models.AnalysisResult) {
+	if h.cache == nil {
+		return
+	}
+	ttl := h.resultTTL.Normal
+	key := cache.ResultKey(metric.DeviceID, result.Timestamp)
+	if result.AnomalyDetected {
+		ttl = h.resultTTL.Anomaly
+		if h.anomalies != nil && h.anomalies.Pin(metric.DeviceID, key) {
+			ttl = h.resultTTL.Pinned
+		}
+	}
+	_ = h.cache.CacheAnalysisResult(key, result, ttl)
+}
+
+// persist сохраняет сырую метрику. Ошибка не прерывает обработку: сохранение
+// можно повторить из очереди недоставленных
+func (h *Handler) persist(metric models.Metric, timings *stageTimings) {
+	if h.cache == nil {
+		return
+	}
+	start := time.Now()
+	err := h.cache.CacheMetric(metric)
+	timings.since(stageCacheWrite, start)
+	if err != nil {
+		metrics.CacheMisses.Inc()
+		h.persistFailed()
+		h.deadLetter(metric, dlq.ReasonPersistence, err)
+		return
+	}
+	metrics.CacheHits.Inc()
+}
+
+// persistBefore сохраняет метрику до анализа, если прореживание выключено
+func (h *Handler) persistBefore(metric models.Metric, timings *stageTimings) {
+	if h.sampler == nil {
+		h.persist(metric, timings)
+	}
+}
+
+// persistAfter сохраняет выбранные прореживанием метрики после анализа
+func (h *Handler) persistAfter(metric models.Metric, result models.AnalysisResult, err error, timings *stageTimings) {
+	for _, m := range h.keepAfter(metric, result, err) {
+		h.persist(m, timings)
+	}
+}
+
+// keepAfter возвращает метрики, которые прореживание выбрало для сохранения
+// после анализа. Аномальные метрики и метрики, анализ которых не удался,
+// сохраняются всегда
+func (h *Handler) keepAfter(metric models.Metric, result models.AnalysisResult, err error) []models.Metric {
+	if h.sampler == nil {
+		return nil
+	}
+	notable := err != nil || result.AnomalyDetected || result.IsAnomalyCPU || result.IsAnomalyRPS || result.Snoozed
+	return h.sampler.Keep(metric, notable)
+}
+
+// persistBatch сохраняет метрики пакета одним обращением к хранилищу. При
+// ошибке в очередь недоставленных уходит каждая метрика: конвейер не
+// сообщает, какие команды успели выполниться, а повторное сохранение безопасно
+func (h *Handler) persistBatch(ms []models.Metric, timings *stageTimings) {
+	if h.cache == nil || len(ms) == 0 {
+		return
+	}
+	start := time.Now()
+	err := h.cache.CacheMetricsBatch(ms)
+	timings.since(stageCacheWrite, start)
+	if err != nil {
+		metrics.CacheMisses.Add(float64(len(ms)))
+		h.persistFailed()
+		for _, m := range ms {
+			h.deadLetter(m, dlq.ReasonPersistence, err)
+		}
+		return
+	}
+	metrics.CacheHits.Add(float64(len(ms)))
+}
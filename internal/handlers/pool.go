@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"highload-service/internal/codec"
+	"highload-service/internal/models"
+)
+
+// maxPooledBatch пакеты с большей емкостью не возвращаются в пул, чтобы
+// один крупный пакет не удерживал память
+const maxPooledBatch = 10000
+
+// jsonContentType значение заголовка Content-Type ответов JSON. Заголовок
+// записывается готовым срезом: Header.Set выделял бы его на каждый ответ
+var jsonContentType = []string{"application/json"}
+
+// setJSONContentType выставляет Content-Type ответа JSON
+func setJSONContentType(w http.ResponseWriter) {
+	w.Header()["Content-Type"] = jsonContentType
+}
+
+// protobufContentType значение заголовка Content-Type ответов protobuf
+var protobufContentType = []string{"application/x-protobuf"}
+
+// msgpackContentType значение заголовка Content-Type ответов MessagePack
+var msgpackContentType = []string{"application/msgpack"}
+
+// negotiate выбирает по Accept формат ответа приема: JSON, protobuf или
+// MessagePack. Vary: Accept не дает кешам отдать ответ одного формата
+// клиенту другого
+func negotiate(w http.ResponseWriter, r *http.Request) codec.Codec {
+	w.Header().Add("Vary", "Accept")
+	return codec.Negotiate(r.Header.Get("Accept"), codec.JSON, codec.Protobuf, codec.MessagePack)
+}
+
+// respondResult отвечает 200 с результатом анализа в формате c. Двоичные
+// форматы кодируются в буфер из пула, как JSON
+func (h *Handler) respondResult(w http.ResponseWriter, c codec.Codec, result *models.AnalysisResult) {
+	if c != codec.Protobuf && c != codec.MessagePack {
+		h.respondJSON(w, result, http.StatusOK)
+		return
+	}
+	e := encoders.Get().(*jsonEncoder)
+	defer func() {
+		e.buf.Reset()
+		encoders.Put(e)
+	}()
+	if c == codec.Protobuf {
+		w.Header()["Content-Type"] = protobufContentType
+		w.WriteHeader(http.StatusOK)
+		w.Write(codec.AppendResult(e.buf.AvailableBuffer(), result))
+		return
+	}
+	w.Header()["Content-Type"] = msgpackContentType
+	w.WriteHeader(http.StatusOK)
+	w.Write(codec.AppendMsgpackResult(e.buf.AvailableBuffer(), result))
+}
+
+// respondEncoded отвечает v со статусом status в формате c. Если формат не
+// кодирует тип v, ответ отправляется в JSON
+func (h *Handler) respondEncoded(w http.ResponseWriter, c codec.Codec, v interface{}, status int) {
+	if c == codec.Protobuf || c == codec.MessagePack {
+		if data, err := c.Marshal(v); err == nil {
+			w.Header().Set("Content-Type", c.ContentType())
+			w.WriteHeader(status)
+			w.Write(data)
+			return
+		}
+	}
+	h.respondJSON(w, v, status)
+}
+
+// jsonEncoder буфер ответа с кодировщиком, привязанным к нему
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var encoders = sync.Pool{New: func() interface{} {
+	e := &jsonEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+// encodeJSON кодирует data буфером из пула и пишет результат в w. Модели
+// кодируются сгенерированным кодом, остальные значения — encoding/json
+func encodeJSON(w http.ResponseWriter, data interface{}) error {
+	e := encoders.Get().(*jsonEncoder)
+	defer func() {
+		if e.buf.Cap() <= streamBufferSize {
+			e.buf.Reset()
+			encoders.Put(e)
+		}
+	}()
+	if err := e.encode(data); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+// encode дописывает data в буфер с переводом строки в конце, как json.Encoder
+func (e *jsonEncoder) encode(data interface{}) error {
+	b, ok, err := models.AppendJSON(e.buf.AvailableBuffer(), data)
+	if !ok {
+		return e.enc.Encode(data)
+	}
+	if err != nil {
+		return err
+	}
+	e.buf.Write(append(b, '\n'))
+	return nil
+}
+
+var metricsPool = sync.Pool{New: func() interface{} { return new(models.Metric) }}
+
+// getMetric возвращает обнуленную метрику для разбора тела запроса
+func getMetric() *models.Metric {
+	return metricsPool.Get().(*models.Metric)
+}
+
+// putMetric возвращает метрику в пул. Значение уже скопировано обработчиком;
+// обнуление отпускает строки и карту показателей
+func putMetric(m *models.Metric) {
+	*m = models.Metric{}
+	metricsPool.Put(m)
+}
+
+var batches = sync.Pool{New: func() interface{} { return new(models.MetricsBatch) }}
+
+// getBatch возвращает пустой пакет; разбор тела переиспользует емкость
+// среза метрик предыдущих пакетов
+func getBatch() *models.MetricsBatch {
+	return batches.Get().(*models.MetricsBatch)
+}
+
+// putBatch возвращает пакет в пул. Метрики обнуляются по всей емкости:
+// encoding/json разбирает элементы поверх старых значений, и поля, которых
+// нет в новом теле, иначе достались бы следующему пакету
+func putBatch(b *models.MetricsBatch) {
+	if cap(b.Metrics) > maxPooledBatch {
+		return
+	}
+	clear(b.Metrics[:cap(b.Metrics)])
+	*b = models.MetricsBatch{Metrics: b.Metrics[:0]}
+	batches.Put(b)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestPutBatch_ClearsMetricsForReuse(t *testing.T) {
+	batch := getBatch()
+	body := `{"metrics":[{"device_id":"sensor-1","region":"eu","cpu":50,"values":{"temperature":40}},{"device_id":"sensor-2","cpu":60}]}`
+	if err := json.Unmarshal([]byte(body), batch); err != nil {
+		t.Fatal(err)
+	}
+	putBatch(batch)
+	if len(batch.Metrics) != 0 || cap(batch.Metrics) < 2 {
+		t.Fatalf("Expected an empty batch keeping its capacity, got len %d cap %d", len(batch.Metrics), cap(batch.Metrics))
+	}
+
+	// Fields missing from the next body must not survive from the previous one
+	if err := json.Unmarshal([]byte(`{"metrics":[{"device_id":"sensor-3","cpu":70}]}`), batch); err != nil {
+		t.Fatal(err)
+	}
+	if m := batch.Metrics[0]; m.Region != "" || m.Values != nil || m.DeviceID != "sensor-3" {
+		t.Errorf("Expected a clean metric, got %+v", m)
+	}
+	if stale := batch.Metrics[:2][1]; stale.DeviceID != "" || stale.CPU != 0 {
+		t.Errorf("Expected cleared spare capacity, got %+v", stale)
+	}
+}
+
+func TestPutBatch_DropsOversizedBatches(t *testing.T) {
+	batch := &models.MetricsBatch{Metrics: make([]models.Metric, 1, maxPooledBatch+1)}
+	batch.Metrics[0].DeviceID = "sensor-1"
+	putBatch(batch)
+	// An oversized batch is left as is for the garbage collector
+	if len(batch.Metrics) != 1 {
+		t.Errorf("Expected an oversized batch not to be recycled")
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/analytics"
+)
+
+// defaultQuantiles квантили GET /quantiles без параметра q
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// maxQuantiles наибольшее количество квантилей в одном запросе
+const maxQuantiles = 20
+
+// QuantilesResponse ответ GET /quantiles
+type QuantilesResponse struct {
+	Metric string    `json:"metric"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Count  int64     `json:"count"`
+	// Quantiles оценки по ключам из параметра q ("0.99" → значение)
+	Quantiles map[string]float64 `json:"quantiles"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+}
+
+// QuantilesHandler обрабатывает GET /quantiles - квантили показателя за период по
+// скетчам t-digest. Период задается from и to (RFC 3339) или range до текущего момента
+// (по умолчанию час); границы округляются до интервалов скетчей
+func (h *Handler) QuantilesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.quantiles == nil {
+		h.respondError(w, "Quantiles are not enabled", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	metric := params.Get("metric")
+	if metric == "" {
+		metric = "cpu"
+	}
+
+	qs := defaultQuantiles
+	if v := params.Get("q"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) > maxQuantiles {
+			h.respondError(w, "Too many quantiles, at most "+strconv.Itoa(maxQuantiles)+" are allowed", http.StatusBadRequest)
+			return
+		}
+		qs = make([]float64, 0, len(parts))
+		for _, part := range parts {
+			q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || !(q >= 0 && q <= 1) {
+				h.respondError(w, "Invalid quantile: "+part, http.StatusBadRequest)
+				return
+			}
+			qs = append(qs, q)
+		}
+	}
+
+	from, to, err := parsePeriod(params, h.clock.Now(), time.Hour)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	digest, err := h.quantiles.Digest(metric, from, to)
+	switch {
+	case errors.Is(err, analytics.ErrUnknownQuantileMetric):
+		h.respondError(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := QuantilesResponse{
+		Metric:    metric,
+		From:      from.UTC(),
+		To:        to.UTC(),
+		Count:     digest.Count(),
+		Quantiles: make(map[string]float64, len(qs)),
+	}
+	if resp.Count > 0 {
+		resp.Min, resp.Max = digest.Quantile(0), digest.Quantile(1)
+		for _, q := range qs {
+			resp.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = digest.Quantile(q)
+		}
+	}
+	h.respondJSON(w, resp, http.StatusOK)
+}
+
+// parsePeriod разбирает период запроса: from и to в RFC 3339 или range до to;
+// to по умолчанию now, from по умолчанию за span до to
+func parsePeriod(params url.Values, now time.Time, span time.Duration) (from, to time.Time, err error) {
+	to = now
+	if v := params.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("Invalid to: %s", v)
+		}
+	}
+	from = to.Add(-span)
+	switch {
+	case params.Get("from") != "":
+		if from, err = time.Parse(time.RFC3339, params.Get("from")); err != nil {
+			return from, to, fmt.Errorf("Invalid from: %s", params.Get("from"))
+		}
+	case params.Get("range") != "":
+		d, err := time.ParseDuration(params.Get("range"))
+		if err != nil || d <= 0 {
+			return from, to, fmt.Errorf("Invalid range: %s", params.Get("range"))
+		}
+		from = to.Add(-d)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"highload-service/internal/query"
+)
+
+// QueryResponse ответ GET /query
+type QueryResponse struct {
+	Query  string         `json:"query"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Step   string         `json:"step,omitempty"`
+	Result []query.Series `json:"result"`
+}
+
+// QueryHandler обрабатывает GET /query - выражение над агрегатами (см. пакет query).
+// Без range выражение вычисляется один раз на текущий момент, с range — с шагом step
+// (по умолчанию range/60, но не меньше минуты)
+func (h *Handler) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	if h.rollup == nil {
+		h.respondError(w, "Series are not enabled", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	expr, err := query.Parse(params.Get("q"))
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := h.clock.Now()
+	from := to
+	var step time.Duration
+	if v := params.Get("range"); v != "" {
+		span, err := time.ParseDuration(v)
+		if err != nil || span <= 0 {
+			h.respondError(w, "Invalid range: "+v, http.StatusBadRequest)
+			return
+		}
+		step = span / 60
+		if step < time.Minute {
+			step = time.Minute
+		}
+		if v := params.Get("step"); v != "" {
+			if step, err = time.ParseDuration(v); err != nil || step <= 0 {
+				h.respondError(w, "Invalid step: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+		if span/step > maxSeriesPoints {
+			h.respondError(w, fmt.Sprintf("Too many points for step %s, at most %d are allowed", step, maxSeriesPoints), http.StatusBadRequest)
+			return
+		}
+		from = to.Add(-span)
+	}
+
+	result, err := query.EvalRange(expr, h.rollup, from, to, step)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := QueryResponse{Query: expr.String(), From: from.UTC(), To: to.UTC(), Result: result}
+	if step > 0 {
+		resp.Step = step.String()
+	}
+	h.respondJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package handlers
+
+// raceEnabled reports a -race build: the race detector adds allocations and
+// makes sync.Pool drop objects at random, so allocation budgets do not apply
+const raceEnabled = true
@@ -0,0 +1,12 @@
+package handlers
+
+import "net/http"
+
+// RegionsHandler обрабатывает GET /regions - агрегаты и счетчики аномалий по регионам
+func (h *Handler) RegionsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.regions == nil {
+		h.respondError(w, "Region aggregation is not configured", http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, h.regions.List(), http.StatusOK)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/flags"
+	"highload-service/internal/middleware"
+)
+
+// openAPISpec спецификация API, отдаваемая по GET /openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// RegisterRoutes регистрирует маршруты API в роутере.
+// Каждый маршрут должен быть описан в openapi.json
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.Handle("/metrics", h.ingest(h.MetricsHandler)).Methods("POST")
+	router.Handle("/metrics/batch", h.feature(flags.BatchIngest, h.ingest(h.BatchMetricsHandler))).Methods("POST")
+	router.Handle("/metrics/stream", h.feature(flags.StreamIngest, h.ingest(h.StreamMetricsHandler))).Methods("POST")
+	router.HandleFunc("/metrics/latest", h.LatestMetricsHandler).Methods("GET")
+	router.HandleFunc("/analyze", h.AnalyzeHandler).Methods("GET")
+	router.HandleFunc("/analyze/compare", h.CompareHandler).Methods("GET")
+	router.HandleFunc("/health", h.HealthHandler).Methods("GET")
+	router.HandleFunc("/readyz", h.ReadyzHandler).Methods("GET")
+	router.HandleFunc("/stats", h.StatsHandler).Methods("GET")
+	router.HandleFunc("/series", h.SeriesHandler).Methods("GET")
+	router.HandleFunc("/quantiles", h.QuantilesHandler).Methods("GET")
+	router.HandleFunc("/forecast", h.ForecastHandler).Methods("GET")
+	router.HandleFunc("/query", h.QueryHandler).Methods("GET")
+	router.HandleFunc("/anomalies", h.ListAnomaliesHandler).Methods("GET")
+	router.HandleFunc("/anomalies/events", h.AnomalyEventsHandler).Methods("GET")
+	router.HandleFunc("/anomalies/{id}/ack", h.AnomalyTransitionHandler(anomalyAck)).Methods("POST")
+	router.HandleFunc("/anomalies/{id}/resolve", h.AnomalyTransitionHandler(anomalyResolve)).Methods("POST")
+	router.HandleFunc("/incidents", h.ListIncidentsHandler).Methods("GET")
+	router.HandleFunc("/incidents/{id}", h.GetIncidentHandler).Methods("GET")
+	router.HandleFunc("/devices/{id}", h.DeviceStateHandler).Methods("GET")
+	router.HandleFunc("/devices/{id}/snooze", h.SnoozeHandler).Methods("POST")
+	router.HandleFunc("/devices/{id}/snooze", h.CancelSnoozeHandler).Methods("DELETE")
+	router.HandleFunc("/groups", h.ListGroupsHandler).Methods("GET")
+	router.HandleFunc("/groups/{id}/stats", h.GroupStatsHandler).Methods("GET")
+	router.HandleFunc("/regions", h.RegionsHandler).Methods("GET")
+	router.HandleFunc("/score", h.ScoreHandler).Methods("GET")
+	router.HandleFunc("/cadence", h.CadenceHandler).Methods("GET")
+	router.HandleFunc("/journal", h.JournalHandler).Methods("GET")
+	router.HandleFunc("/openapi.json", h.OpenAPIHandler).Methods("GET")
+}
+
+// OpenAPIHandler обрабатывает GET /openapi.json - спецификация API
+func (h *Handler) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// ingest оборачивает эндпоинт приема метрик в middleware, заданные WithIngestMiddleware.
+// Тела, сжатые gzip (например, пакеты из SDK), распаковываются до обработчика
+func (h *Handler) ingest(fn http.HandlerFunc) http.Handler {
+	handler := middleware.DecompressRequest(fn)
+	for i := len(h.ingestMiddleware) - 1; i >= 0; i-- {
+		handler = h.ingestMiddleware[i](handler)
+	}
+	return handler
+}
+
+// feature делает эндпоинт доступным, только если флаг включен для тенанта запроса
+func (h *Handler) feature(name string, next http.Handler) http.Handler {
+	return h.flags.Require(name)(next)
+}
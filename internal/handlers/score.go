@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"highload-service/internal/score"
+)
+
+// ScoreHandler обрабатывает GET /score - сводная оценка здоровья парка и худших
+// устройств (limit, по умолчанию 10; 0 — все) или одного устройства (device)
+func (h *Handler) ScoreHandler(w http.ResponseWriter, r *http.Request) {
+	if h.scorer == nil {
+		h.respondError(w, "Health scoring is not configured", http.StatusNotFound)
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.respondError(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	fleet, devices := h.scorer.Fleet(limit)
+	if id := r.URL.Query().Get("device"); id != "" {
+		sc, err := h.scorer.Device(id)
+		if errors.Is(err, score.ErrUnknownDevice) {
+			h.respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		devices = []score.Score{sc}
+	}
+	h.respondJSON(w, map[string]interface{}{"fleet": fleet, "devices": devices}, http.StatusOK)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/snooze"
+)
+
+// SnoozeHandler обрабатывает POST /devices/{id}/snooze?duration=1h - исключает
+// устройство из учета аномалий и оповещений на duration (по умолчанию 1h)
+func (h *Handler) SnoozeHandler(w http.ResponseWriter, r *http.Request) {
+	if h.snoozes == nil {
+		h.respondError(w, "Snoozing is not enabled", http.StatusNotFound)
+		return
+	}
+
+	d := snooze.DefaultDuration
+	if v := r.URL.Query().Get("duration"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			h.respondError(w, "Invalid duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		d = parsed
+	}
+	s, err := h.snoozes.Snooze(mux.Vars(r)["id"], d)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.respondJSON(w, s, http.StatusOK)
+}
+
+// CancelSnoozeHandler обрабатывает DELETE /devices/{id}/snooze - досрочно
+// возвращает устройство в учет аномалий; в ответе снятое отложение
+func (h *Handler) CancelSnoozeHandler(w http.ResponseWriter, r *http.Request) {
+	if h.snoozes == nil {
+		h.respondError(w, "Snoozing is not enabled", http.StatusNotFound)
+		return
+	}
+	s, ok := h.snoozes.Cancel(mux.Vars(r)["id"])
+	if !ok {
+		h.respondError(w, "Device is not snoozed", http.StatusNotFound)
+		return
+	}
+	h.respondJSON(w, s, http.StatusOK)
+}
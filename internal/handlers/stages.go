@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"highload-service/internal/metrics"
+)
+
+// DebugTimingHeader заголовок запроса, включающий разбивку времени по этапам
+// в заголовке ответа Server-Timing (для пакетов — в трейлере)
+const DebugTimingHeader = "X-Debug-Timing"
+
+// IngestBudget бюджет времени приема одной метрики
+const IngestBudget = 5 * time.Millisecond
+
+// stage этап конвейера приема
+type stage int
+
+const (
+	stageDecode stage = iota
+	stageValidate
+	stageAnalyze
+	stageCacheWrite
+	stagePublish
+	numStages
+)
+
+var stageNames = [numStages]string{"decode", "validate", "analyze", "cache_write", "sink_publish"}
+
+// stageObservers гистограммы этапов; метки разрешаются один раз, а не на каждую метрику
+var stageObservers = func() (o [numStages]prometheus.Observer) {
+	for s, name := range stageNames {
+		o[s] = metrics.StageLatency.WithLabelValues(name)
+	}
+	return o
+}()
+
+// stageTimings суммарная длительность этапов обработки одного запроса.
+// Методы допускают nil: тогда время попадает только в гистограммы
+type stageTimings [numStages]time.Duration
+
+// since учитывает время этапа s от start и возвращает текущее время —
+// начало следующего этапа
+func (t *stageTimings) since(s stage, start time.Time) time.Time {
+	now := time.Now()
+	t.add(s, now.Sub(start))
+	return now
+}
+
+func (t *stageTimings) add(s stage, d time.Duration) {
+	stageObservers[s].Observe(d.Seconds())
+	if t != nil {
+		t[s] += d
+	}
+}
+
+// serverTiming значение заголовка Server-Timing: длительности этапов в миллисекундах
+// и признак превышения бюджета IngestBudget на метрику
+func (t *stageTimings) serverTiming(metricsCount int) string {
+	var b strings.Builder
+	var total time.Duration
+	for s, d := range t {
+		total += d
+		b.WriteString(stageNames[s])
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64))
+		b.WriteString(", ")
+	}
+	b.WriteString("total;dur=")
+	b.WriteString(strconv.FormatFloat(float64(total)/float64(time.Millisecond), 'f', 3, 64))
+	if metricsCount > 0 && total > time.Duration(metricsCount)*IngestBudget {
+		b.WriteString(`;desc="over budget"`)
+	}
+	return b.String()
+}
+
+// debugTimings сообщает, запрошена ли разбивка времени по этапам
+func debugTimings(r *http.Request) bool {
+	v := r.Header.Get(DebugTimingHeader)
+	return v != "" && v != "0" && v != "false"
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"highload-service/internal/codec"
+	"highload-service/internal/models"
+)
+
+const (
+	// streamFlushEvery через сколько результатов ответ отправляется клиенту
+	streamFlushEvery = 1000
+	// streamBufferSize буфер записи потокового ответа
+	streamBufferSize = 32 << 10
+)
+
+// resultStream пишет ответ пакетной загрузки по мере анализа: каждый результат
+// кодируется сразу и раз в streamFlushEvery результатов отправляется клиенту,
+// поэтому пакет из десятков тысяч метрик не собирается в памяти целиком.
+// Итоговые счетчики известны только в конце, поэтому идут после массива results.
+// Потоки с буфером и кодировщиком берутся из пула.
+//
+// В protobuf ответ — сообщение BatchResponse: повторяющееся поле results
+// пишется по элементу, а итоговые поля дописываются после него. В MessagePack
+// длина массива предшествует элементам, поэтому закодированные результаты
+// копятся в msgpack и отправляются в Close
+type resultStream struct {
+	w   *bufio.Writer
+	rc  http.ResponseController
+	enc *json.Encoder
+	n   int
+	// format формат ответа: codec.JSON, codec.Protobuf или codec.MessagePack
+	format codec.Codec
+	// msgpack закодированные результаты ответа MessagePack
+	msgpack []byte
+	// result копия текущего результата: кодировщик получает указатель на поле
+	// потока, и результат не копируется в кучу
+	result models.AnalysisResult
+	// err первая ошибка записи (клиент отключился); после нее запись пропускается,
+	// а пакет дообрабатывается
+	err error
+}
+
+var streams = sync.Pool{New: func() interface{} {
+	s := &resultStream{w: bufio.NewWriterSize(nil, streamBufferSize)}
+	s.enc = json.NewEncoder(s.w)
+	return s
+}}
+
+// newResultStream отправляет заголовки 200 и начало ответа в формате c
+func newResultStream(w http.ResponseWriter, c codec.Codec) *resultStream {
+	s := streams.Get().(*resultStream)
+	s.format = c
+	switch c {
+	case codec.Protobuf:
+		w.Header()["Content-Type"] = protobufContentType
+	case codec.MessagePack:
+		w.Header()["Content-Type"] = msgpackContentType
+	default:
+		s.format = codec.JSON
+		setJSONContentType(w)
+	}
+	w.WriteHeader(http.StatusOK)
+	s.w.Reset(w)
+	s.rc = *http.NewResponseController(w)
+	if s.format == codec.JSON {
+		s.write(`{"results":[`)
+	}
+	return s
+}
+
+// Add дописывает результат в массив results
+func (s *resultStream) Add(result models.AnalysisResult) {
+	if s.n > 0 && s.format == codec.JSON {
+		s.write(",")
+	}
+	if s.err == nil {
+		s.result = result
+		switch s.format {
+		case codec.Protobuf:
+			_, s.err = s.w.Write(codec.AppendBatchResult(s.w.AvailableBuffer(), &s.result))
+		case codec.MessagePack:
+			s.msgpack = codec.AppendMsgpackResult(s.msgpack, &s.result)
+		default:
+			s.err = s.encode(&s.result)
+		}
+	}
+	s.n++
+	if s.n%streamFlushEvery == 0 && s.format != codec.MessagePack {
+		s.flush()
+	}
+}
+
+// Close закрывает массив, дописывает итоговые счетчики и статистику пакета
+// и отправляет остаток ответа. Результатов может быть больше processed:
+// задержанные и опоздавшие метрики получают результат без анализа.
+// Поток возвращается в пул, и после Close им пользоваться нельзя
+func (s *resultStream) Close(processed, rejected, anomalies int, stats models.BatchStats) {
+	switch s.format {
+	case codec.Protobuf:
+		if s.err == nil {
+			_, s.err = s.w.Write(codec.AppendBatchSummary(s.w.AvailableBuffer(), processed, rejected, anomalies, &stats))
+		}
+		s.flush()
+		s.release()
+		return
+	case codec.MessagePack:
+		if s.err == nil {
+			_, s.err = s.w.Write(codec.AppendMsgpackBatch(s.w.AvailableBuffer(), s.msgpack, s.n, processed, rejected, anomalies, &stats))
+		}
+		s.flush()
+		s.release()
+		return
+	}
+	s.write(`],"processed":` + strconv.Itoa(processed) +
+		`,"rejected":` + strconv.Itoa(rejected) +
+		`,"anomalies_found":` + strconv.Itoa(anomalies) + `,"stats":`)
+	if s.err == nil {
+		// Значение завершается переводом строки, допустимым внутри JSON
+		s.err = s.encode(&stats)
+	}
+	s.write("}\n")
+	s.flush()
+	s.release()
+}
+
+// release отпускает ResponseWriter и возвращает поток в пул
+func (s *resultStream) release() {
+	s.w.Reset(nil)
+	// Буфер MessagePack крупного пакета не удерживается пулом
+	msgpack := s.msgpack[:0]
+	if cap(msgpack) > streamBufferSize {
+		msgpack = nil
+	}
+	*s = resultStream{w: s.w, enc: s.enc, msgpack: msgpack}
+	streams.Put(s)
+}
+
+// encode дописывает значение сгенерированным кодировщиком модели прямо в
+// буфер записи или, для других типов, через encoding/json
+func (s *resultStream) encode(v interface{}) error {
+	b, ok, err := models.AppendJSON(s.w.AvailableBuffer(), v)
+	if !ok {
+		return s.enc.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *resultStream) write(data string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(data)
+	}
+}
+
+// flush отправляет буфер клиенту. ResponseWriter без поддержки Flush
+// получает данные при заполнении буфера и по завершении обработчика
+func (s *resultStream) flush() {
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	if s.err == nil {
+		_ = s.rc.Flush()
+	}
+}
@@ -0,0 +1,246 @@
+// Package handover передает сокеты точек приема и окна анализатора новому
+// процессу без остановки приема.
+//
+// Старый процесс по сигналу запускает новый бинарник, передавая ему копии
+// сокетов по соглашению systemd socket activation (LISTEN_FDS, LISTEN_FDNAMES,
+// дескрипторы с 3) и канал передачи состояния (дескриптор с именем StateFD).
+// Новый процесс инициализируется и сообщает о готовности; старый перестает
+// принимать соединения, дорабатывает принятые запросы и очередь анализатора и
+// отправляет окна. Пока ни один процесс не принимает соединения, они ждут в
+// очереди сокета, поэтому метрики устройств не теряются. Новый процесс
+// заполняет окна и начинает прием на унаследованных сокетах.
+//
+// Те же переменные выставляет systemd для сервиса с .socket-юнитом: имена
+// FileDescriptorName= сопоставляются с именами точек приема. Под systemd
+// старый процесс перед завершением сообщает MAINPID нового (sd_notify,
+// NotifyAccess=all), иначе завершение главного процесса остановило бы
+// сервис вместе с новым процессом
+package handover
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"highload-service/internal/analytics"
+)
+
+const (
+	// EnvFDs количество переданных дескрипторов
+	EnvFDs = "LISTEN_FDS"
+	// EnvFDNames имена дескрипторов через двоеточие
+	EnvFDNames = "LISTEN_FDNAMES"
+	// EnvPID процесс, которому адресованы дескрипторы; пустой — любой
+	EnvPID = "LISTEN_PID"
+	// EnvNotifySocket сокет уведомлений systemd (sd_notify)
+	EnvNotifySocket = "NOTIFY_SOCKET"
+	// StateFD имя дескриптора канала передачи состояния
+	StateFD = "handover"
+	// firstFD первый переданный дескриптор (после stdin, stdout и stderr)
+	firstFD = 3
+	// ready сообщение нового процесса о готовности принять состояние
+	ready = "ready\n"
+)
+
+// State состояние, передаваемое новому процессу
+type State struct {
+	// Windows значения окон анализатора (analytics.Analyzer.ExportWindows)
+	Windows map[string]analytics.WindowSeed `json:"windows"`
+}
+
+// Inherited возвращает дескрипторы, переданные процессу, по именам и убирает
+// переменные окружения, чтобы их не унаследовали дочерние процессы. Без
+// LISTEN_FDNAMES дескрипторы получают имена defaults по порядку. Пустой
+// результат — дескрипторы не передавались или адресованы другому процессу
+func Inherited(defaults ...string) (map[string]*os.File, error) {
+	defer unsetEnv()
+	raw := os.Getenv(EnvFDs)
+	if raw == "" {
+		return nil, nil
+	}
+	if pid := os.Getenv(EnvPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s %q", EnvFDs, raw)
+	}
+	names := defaults
+	if v, ok := os.LookupEnv(EnvFDNames); ok {
+		names = strings.Split(v, ":")
+	}
+
+	files := make(map[string]*os.File, n)
+	for i := 0; i < n; i++ {
+		fd := firstFD + i
+		name := "fd" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		files[name] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
+
+func unsetEnv() {
+	os.Unsetenv(EnvFDs)
+	os.Unsetenv(EnvFDNames)
+	os.Unsetenv(EnvPID)
+}
+
+// Successor новый процесс, которому передаются сокеты
+type Successor struct {
+	proc *os.Process
+	pid  int
+	conn net.Conn
+}
+
+// Start запускает новый экземпляр исполняемого файла с теми же аргументами,
+// передает ему files под именами names и ждет его готовности до срока ctx.
+// Если новый процесс завершился или не успел, он останавливается, а старый
+// продолжает работу
+func Start(ctx context.Context, files []*os.File, names []string) (*Successor, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	// Дескрипторы берутся через SyscallConn, а не Fd (его вызывает и os/exec):
+	// Fd переводит сокет в блокирующий режим, общий для всех его копий, и
+	// Accept этого процесса перестал бы прерываться закрытием сокета
+	fds := []uintptr{0, 1, 2}
+	for _, f := range files {
+		raw, err := f.SyscallConn()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pass %s: %w", f.Name(), err)
+		}
+		raw.Control(func(fd uintptr) { fds = append(fds, fd) })
+	}
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state channel: %w", err)
+	}
+	local := os.NewFile(uintptr(pair[0]), StateFD)
+	conn, err := net.FileConn(local)
+	local.Close()
+	if err != nil {
+		syscall.Close(pair[1])
+		return nil, fmt.Errorf("failed to create state channel: %w", err)
+	}
+
+	env := append(environ(),
+		EnvFDs+"="+strconv.Itoa(len(files)+1),
+		EnvFDNames+"="+strings.Join(append(append([]string(nil), names...), StateFD), ":"))
+	pid, err := syscall.ForkExec(path, os.Args, &syscall.ProcAttr{Env: env, Files: append(fds, uintptr(pair[1]))})
+	// Копия канала остается только у нового процесса: его завершение закроет канал
+	syscall.Close(pair[1])
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start successor: %w", err)
+	}
+	proc, _ := os.FindProcess(pid)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err == nil && line != ready {
+		err = fmt.Errorf("unexpected message %q", line)
+	}
+	if err != nil {
+		proc.Kill()
+		proc.Wait()
+		conn.Close()
+		return nil, fmt.Errorf("successor %d is not ready: %w", pid, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return &Successor{proc: proc, pid: pid, conn: conn}, nil
+}
+
+// environ окружение без переменных передачи дескрипторов
+func environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if name != EnvFDs && name != EnvFDNames && name != EnvPID {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Pid идентификатор нового процесса
+func (s *Successor) Pid() int {
+	return s.pid
+}
+
+// Send передает состояние новому процессу, закрывает канал и сообщает systemd,
+// что главный процесс сервиса теперь новый. Новый процесс не ждется: он
+// продолжает работу после завершения старого, даже если состояние не передано
+func (s *Successor) Send(state State) error {
+	defer s.conn.Close()
+	var errs []error
+	if err := notifyMainPID(s.pid); err != nil {
+		errs = append(errs, fmt.Errorf("failed to report successor %d to systemd: %w", s.pid, err))
+	}
+	if err := json.NewEncoder(s.conn).Encode(state); err != nil {
+		errs = append(errs, fmt.Errorf("failed to send state to successor %d: %w", s.pid, err))
+	} else {
+		s.proc.Release()
+	}
+	return errors.Join(errs...)
+}
+
+// notifyMainPID отправляет systemd MAINPID=pid через NOTIFY_SOCKET; без
+// переменной (не под systemd или без NotifyAccess) ничего не делает
+func notifyMainPID(pid int) error {
+	addr := os.Getenv(EnvNotifySocket)
+	if addr == "" {
+		return nil
+	}
+	// Имя, начинающееся с @, — абстрактный сокет
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("MAINPID=" + strconv.Itoa(pid) + "\n"))
+	return err
+}
+
+// Receive сообщает предыдущему процессу о готовности через канал f и ждет
+// состояние не дольше timeout: предыдущий процесс отправляет его после
+// остановки приема и обработки очереди
+func Receive(f *os.File, timeout time.Duration) (State, error) {
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return State{}, fmt.Errorf("invalid state channel: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var state State
+	if _, err := io.WriteString(conn, ready); err != nil {
+		return State{}, fmt.Errorf("failed to report readiness: %w", err)
+	}
+	if err := json.NewDecoder(conn).Decode(&state); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("previous process closed the channel without state")
+		}
+		return State{}, err
+	}
+	return state, nil
+}
@@ -0,0 +1,83 @@
+package handover
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+)
+
+func TestReceive_ReportsReadinessAndDecodesState(t *testing.T) {
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Skipf("socket pairs are not available: %v", err)
+	}
+	previous, channel := os.NewFile(uintptr(pair[0]), "previous"), os.NewFile(uintptr(pair[1]), StateFD)
+	defer previous.Close()
+
+	sent := State{Windows: map[string]analytics.WindowSeed{"sensor-1": {CPU: []float64{1, 2}, RPS: []float64{10, 20}}}}
+	go func() {
+		// The previous process sends state only after the successor is ready
+		if line, err := bufio.NewReader(previous).ReadString('\n'); err != nil || line != ready {
+			t.Errorf("Expected a readiness message, got %q (err %v)", line, err)
+			return
+		}
+		json.NewEncoder(previous).Encode(sent)
+		previous.Close()
+	}()
+
+	state, err := Receive(channel, time.Second)
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if seed := state.Windows["sensor-1"]; len(seed.CPU) != 2 || seed.RPS[1] != 20 {
+		t.Errorf("Expected the sent windows, got %+v", state.Windows)
+	}
+}
+
+func TestReceive_TimesOutWithoutState(t *testing.T) {
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Skipf("socket pairs are not available: %v", err)
+	}
+	previous := os.NewFile(uintptr(pair[0]), "previous")
+	defer previous.Close()
+	if _, err := Receive(os.NewFile(uintptr(pair[1]), StateFD), 50*time.Millisecond); err == nil {
+		t.Error("Expected an error when the previous process sends nothing")
+	}
+}
+
+func TestNotifyMainPID_SendsDatagram(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets are not available: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv(EnvNotifySocket, addr)
+	if err := notifyMainPID(4242); err != nil {
+		t.Fatalf("notifyMainPID failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "MAINPID=4242\n" {
+		t.Errorf("Expected MAINPID=4242, got %q", got)
+	}
+
+	// Outside systemd there is nothing to notify
+	t.Setenv(EnvNotifySocket, "")
+	if err := notifyMainPID(4242); err != nil {
+		t.Errorf("Expected no error without NOTIFY_SOCKET, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package health сводная оценка здоровья сервиса.
+//
+// Каждая проверка смотрит на свой компонент (хранилище, очередь анализатора,
+// получатели outbox) и возвращает причины ухудшения с уровнем degraded или
+// unhealthy. Общий статус — худший из уровней причин: degraded означает, что
+// сервис работает, но часть данных копится или теряется, unhealthy — что
+// сервис не справляется с приемом
+package health
+
+import (
+	"fmt"
+	"sort"
+
+	"highload-service/internal/models"
+)
+
+// Уровни здоровья в порядке ухудшения
+const (
+	Healthy   = "healthy"
+	Degraded  = "degraded"
+	Unhealthy = "unhealthy"
+)
+
+// Коды причин
+const (
+	// CodeRedisUnavailable хранилище недоступно, данные хранятся только в памяти реплики
+	CodeRedisUnavailable = "redis_unavailable"
+	// CodePersistenceFailing метрики не сохраняются и копятся в очереди недоставленных
+	CodePersistenceFailing = "persistence_failing"
+	// CodeQueueSaturated очередь заполнена выше порога
+	CodeQueueSaturated = "queue_saturated"
+	// CodeSinkFailing получатель outbox не принимает события, они ждут в журнале
+	CodeSinkFailing = "sink_failing"
+)
+
+const (
+	// DefaultQueueDegraded заполнение очереди, с которого сервис считается degraded
+	DefaultQueueDegraded = 0.8
+	// DefaultQueueUnhealthy заполнение очереди, с которого сервис считается unhealthy
+	DefaultQueueUnhealthy = 1.0
+)
+
+// Config пороги заполнения очередей, доли емкости 0–1
+type Config struct {
+	QueueDegraded  float64
+	QueueUnhealthy float64
+}
+
+// Validate проверяет, что 0 < QueueDegraded < QueueUnhealthy <= 1
+func (c Config) Validate() error {
+	if c.QueueDegraded <= 0 || c.QueueDegraded >= c.QueueUnhealthy || c.QueueUnhealthy > 1 {
+		return fmt.Errorf("queue thresholds must satisfy 0 < degraded < unhealthy <= 1, got degraded %v, unhealthy %v",
+			c.QueueDegraded, c.QueueUnhealthy)
+	}
+	return nil
+}
+
+// Check проверка компонента; пустой результат — компонент здоров
+type Check func() []models.HealthReason
+
+// Evaluate выполняет проверки и возвращает общий статус и причины, худшие первыми
+func Evaluate(checks ...Check) (string, []models.HealthReason) {
+	reasons := []models.HealthReason{}
+	for _, check := range checks {
+		reasons = append(reasons, check()...)
+	}
+	sort.SliceStable(reasons, func(i, j int) bool { return rank(reasons[i].Level) > rank(reasons[j].Level) })
+
+	status := Healthy
+	if len(reasons) > 0 {
+		status = reasons[0].Level
+	}
+	return status, reasons
+}
+
+func rank(level string) int {
+	switch level {
+	case Unhealthy:
+		return 2
+	case Degraded:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Saturation проверяет заполнение очереди component: level возвращает
+// количество элементов и емкость
+func Saturation(component string, cfg Config, level func() (used, capacity int)) Check {
+	return func() []models.HealthReason {
+		used, capacity := level()
+		if capacity <= 0 {
+			return nil
+		}
+		fill := float64(used) / float64(capacity)
+		var lvl string
+		switch {
+		case fill >= cfg.QueueUnhealthy:
+			lvl = Unhealthy
+		case fill >= cfg.QueueDegraded:
+			lvl = Degraded
+		default:
+			return nil
+		}
+		return []models.HealthReason{{
+			Component: component,
+			Code:      CodeQueueSaturated,
+			Level:     lvl,
+			Message:   fmt.Sprintf("%d of %d slots used", used, capacity),
+		}}
+	}
+}
+
+// SinkFailures проверяет получателей outbox: failures возвращает количество
+// подряд неудачных доставок по именам получателей
+func SinkFailures(failures func() map[string]int) Check {
+	return func() []models.HealthReason {
+		failing := failures()
+		names := make([]string, 0, len(failing))
+		for name, n := range failing {
+			if n > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		reasons := make([]models.HealthReason, 0, len(names))
+		for _, name := range names {
+			reasons = append(reasons, models.HealthReason{
+				Component: "outbox/" + name,
+				Code:      CodeSinkFailing,
+				Level:     Degraded,
+				Message:   fmt.Sprintf("%d consecutive delivery failures", failing[name]),
+			})
+		}
+		return reasons
+	}
+}
@@ -0,0 +1,30 @@
+package health
+
+import (
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestEvaluate_WorstLevelWins(t *testing.T) {
+	reason := func(code, level string) Check {
+		return func() []models.HealthReason {
+			return []models.HealthReason{{Component: "c", Code: code, Level: level}}
+		}
+	}
+
+	if status, reasons := Evaluate(); status != Healthy || reasons == nil || len(reasons) != 0 {
+		t.Errorf("Expected healthy with an empty reason list, got %q %v", status, reasons)
+	}
+
+	status, reasons := Evaluate(reason("a", Degraded), reason("b", Unhealthy), reason("c", Degraded))
+	if status != Unhealthy || len(reasons) != 3 || reasons[0].Code != "b" || reasons[1].Code != "a" {
+		t.Errorf("Expected the unhealthy reason first, got %q %+v", status, reasons)
+	}
+
+	for _, cfg := range []Config{{0, 1}, {0.9, 0.8}, {0.5, 1.5}} {
+		if cfg.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
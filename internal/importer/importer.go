@@ -0,0 +1,343 @@
+// Package importer холодный импорт исторических метрик из внешней SQL-базы,
+// например из базы прежнего сборщика при миграции.
+//
+// Запрос задается конфигурацией и получает два позиционных параметра — начало и
+// конец диапазона (синтаксис параметров зависит от драйвера: $1 и $2 для
+// PostgreSQL, ? для MySQL). Столбцы сопоставляются по именам: timestamp, cpu и rps
+// обязательны, device_id и region — нет. Метрики сохраняются в хранилище без
+// анализа и оповещений; по запросу диапазон дополнительно прогоняется через
+// ретроспективный анализ (пакет replay), отчет которого сохраняется как обычно
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+	"highload-service/internal/replay"
+)
+
+const (
+	// DefaultBatchSize количество метрик, сохраняемых за одно обращение к хранилищу
+	DefaultBatchSize = 1000
+	// DefaultMaxRows наибольшее количество строк за один импорт
+	DefaultMaxRows = 1000000
+)
+
+var (
+	// ErrInvalidRequest некорректный диапазон или ретроанализ без настроенного хранилища отчетов
+	ErrInvalidRequest = errors.New("invalid import request")
+	// ErrInvalidQuery запрос не возвращает обязательные столбцы
+	ErrInvalidQuery = errors.New("invalid import query")
+)
+
+// Store хранилище импортируемых метрик (реализуется cache.RedisCache)
+type Store interface {
+	ImportMetrics(metrics []models.Metric) (int, error)
+}
+
+// Request параметры импорта
+type Request struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Analyze прогоняет прочитанные метрики через ретроспективный анализ
+	Analyze bool `json:"analyze,omitempty"`
+	// Detector конфигурация детектора для ретроанализа; если не задана, используется
+	// заданная WithRetroAnalysis
+	Detector *analytics.DetectorConfig `json:"detector,omitempty"`
+}
+
+// Report итог импорта
+type Report struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Rows количество прочитанных строк
+	Rows int `json:"rows"`
+	// Imported количество сохраненных метрик
+	Imported int `json:"imported"`
+	// Expired метрики старше срока хранения: не сохраняются, но участвуют в ретроанализе
+	Expired int `json:"expired"`
+	// Invalid строки с пустыми или недопустимыми значениями
+	Invalid int `json:"invalid"`
+	// Truncated прочитаны только первые MaxRows строк
+	Truncated bool           `json:"truncated,omitempty"`
+	Replay    *replay.Report `json:"replay,omitempty"`
+}
+
+// Option настраивает Importer
+type Option func(*Importer)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(i *Importer) {
+		i.clock = c
+	}
+}
+
+// WithBatchSize задает размер пачки сохранения
+func WithBatchSize(n int) Option {
+	return func(i *Importer) {
+		if n > 0 {
+			i.batchSize = n
+		}
+	}
+}
+
+// WithMaxRows ограничивает количество строк за один импорт. Для ретроанализа все
+// прочитанные метрики держатся в памяти
+func WithMaxRows(n int) Option {
+	return func(i *Importer) {
+		if n > 0 {
+			i.maxRows = n
+		}
+	}
+}
+
+// WithRetroAnalysis включает ретроанализ: отчеты сохраняются в reports,
+// detector используется для запросов без явной конфигурации
+func WithRetroAnalysis(reports replay.Store, detector analytics.DetectorConfig) Option {
+	return func(i *Importer) {
+		i.reports = reports
+		i.detector = detector
+	}
+}
+
+// Importer выполняет импорт
+type Importer struct {
+	db        *sql.DB
+	query     string
+	store     Store
+	clock     clock.Clock
+	batchSize int
+	maxRows   int
+	reports   replay.Store
+	detector  analytics.DetectorConfig
+}
+
+// New создает Importer, читающий метрики запросом query из db и сохраняющий их в store
+func New(db *sql.DB, query string, store Store, opts ...Option) *Importer {
+	i := &Importer{
+		db:        db,
+		query:     query,
+		store:     store,
+		clock:     clock.Real(),
+		batchSize: DefaultBatchSize,
+		maxRows:   DefaultMaxRows,
+		detector:  analytics.DefaultDetectorConfig(),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Run импортирует метрики диапазона req. При ошибке возвращает и отчет о том, что
+// успело сохраниться: повторный импорт того же диапазона перезаписывает те же ключи
+func (i *Importer) Run(ctx context.Context, req Request) (Report, error) {
+	if req.From.IsZero() || req.To.IsZero() || !req.To.After(req.From) {
+		return Report{}, fmt.Errorf("%w: from must be before to", ErrInvalidRequest)
+	}
+	if req.Analyze && i.reports == nil {
+		return Report{}, fmt.Errorf("%w: retro-analysis is not configured", ErrInvalidRequest)
+	}
+
+	report := Report{From: req.From.UTC(), To: req.To.UTC()}
+	rows, err := i.db.QueryContext(ctx, i.query, req.From, req.To)
+	if err != nil {
+		return report, fmt.Errorf("failed to query source: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return report, fmt.Errorf("failed to read source columns: %w", err)
+	}
+	idx, err := columnIndexes(columns)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := i.clock.Now().Add(-cache.MetricsTTL)
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for n := range values {
+		dest[n] = &values[n]
+	}
+	batch := make([]models.Metric, 0, i.batchSize)
+	var analyzed []models.Metric
+	for rows.Next() {
+		if report.Rows == i.maxRows {
+			report.Truncated = true
+			break
+		}
+		report.Rows++
+		if err := rows.Scan(dest...); err != nil {
+			return report, fmt.Errorf("failed to scan source row: %w", err)
+		}
+		m, err := toMetric(values, idx)
+		if err != nil {
+			report.Invalid++
+			continue
+		}
+		if req.Analyze {
+			analyzed = append(analyzed, m)
+		}
+		if m.Timestamp.Before(cutoff) {
+			report.Expired++
+			continue
+		}
+		batch = append(batch, m)
+		if len(batch) == i.batchSize {
+			if err := i.flush(&report, batch); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to read source rows: %w", err)
+	}
+	if err := i.flush(&report, batch); err != nil {
+		return report, err
+	}
+
+	if req.Analyze {
+		sort.Slice(analyzed, func(a, b int) bool { return analyzed[a].Timestamp.Before(analyzed[b].Timestamp) })
+		r := replay.New(archive(analyzed), i.reports, replay.WithClock(i.clock), replay.WithDetector(i.detector))
+		rep, err := r.Run(replay.Request{From: req.From, To: req.To, Detector: req.Detector})
+		if err != nil {
+			return report, fmt.Errorf("retro-analysis failed: %w", err)
+		}
+		report.Replay = &rep
+	}
+	return report, nil
+}
+
+func (i *Importer) flush(report *Report, batch []models.Metric) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	n, err := i.store.ImportMetrics(batch)
+	report.Imported += n
+	if err != nil {
+		return fmt.Errorf("failed to store imported metrics: %w", err)
+	}
+	return nil
+}
+
+// archive прочитанные метрики в порядке времени как источник для replay
+type archive []models.Metric
+
+func (a archive) GetMetricsRange(from, to time.Time) ([]models.Metric, error) {
+	metrics := []models.Metric{}
+	for _, m := range a {
+		if !m.Timestamp.Before(from) && !m.Timestamp.After(to) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
+
+// indexes позиции столбцов в результате запроса; -1 — столбца нет
+type indexes struct {
+	timestamp, cpu, rps, deviceID, region int
+}
+
+func columnIndexes(columns []string) (indexes, error) {
+	idx := indexes{timestamp: -1, cpu: -1, rps: -1, deviceID: -1, region: -1}
+	for n, name := range columns {
+		switch strings.ToLower(name) {
+		case "timestamp", "ts":
+			idx.timestamp = n
+		case "cpu":
+			idx.cpu = n
+		case "rps":
+			idx.rps = n
+		case "device_id":
+			idx.deviceID = n
+		case "region":
+			idx.region = n
+		}
+	}
+	if idx.timestamp < 0 || idx.cpu < 0 || idx.rps < 0 {
+		return idx, fmt.Errorf("%w: columns timestamp, cpu and rps are required, got %v", ErrInvalidQuery, columns)
+	}
+	return idx, nil
+}
+
+// toMetric собирает метрику из значений строки
+func toMetric(values []interface{}, idx indexes) (models.Metric, error) {
+	var m models.Metric
+	var err error
+	if m.Timestamp, err = toTime(values[idx.timestamp]); err != nil {
+		return m, err
+	}
+	if m.CPU, err = toFloat(values[idx.cpu]); err != nil {
+		return m, err
+	}
+	if m.RPS, err = toFloat(values[idx.rps]); err != nil {
+		return m, err
+	}
+	if idx.deviceID >= 0 {
+		m.DeviceID = toString(values[idx.deviceID])
+	}
+	if idx.region >= 0 {
+		m.Region = toString(values[idx.region])
+	}
+	return m, m.Validate()
+}
+
+// toTime принимает время драйвера, строку RFC 3339 или секунды Unix
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case int64:
+		return time.Unix(t, 0).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(t*float64(time.Second))).UTC(), nil
+	case []byte:
+		return toTime(string(t))
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ts.UTC(), nil
+		}
+		return time.Parse("2006-01-02 15:04:05", t)
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp %v (%T)", v, v)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case int64:
+		return float64(f), nil
+	case []byte:
+		return strconv.ParseFloat(string(f), 64)
+	case string:
+		return strconv.ParseFloat(f, 64)
+	}
+	return 0, fmt.Errorf("unsupported number %v (%T)", v, v)
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,166 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/replay"
+)
+
+// fakeDriver serves a fixed table for any query; the rows are filtered by the
+// from/to arguments the way a real "WHERE ts BETWEEN $1 AND $2" would
+type fakeDriver struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	from, to := args[0].(time.Time), args[1].(time.Time)
+	var rows [][]driver.Value
+	for _, row := range s.d.rows {
+		if ts, ok := row[0].(time.Time); ok && (ts.Before(from) || ts.After(to)) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return &fakeRows{columns: s.d.columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var driverSeq int
+
+func openFake(t *testing.T, d *fakeDriver) *sql.DB {
+	t.Helper()
+	driverSeq++
+	name := fmt.Sprintf("importer-fake-%d", driverSeq)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestImporter_ImportsAndAnalyzes(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	base := clk.Now().Add(-30 * time.Minute)
+	d := &fakeDriver{columns: []string{"ts", "device_id", "cpu", "rps", "region"}}
+	for i := 0; i < 30; i++ {
+		cpu := 40 + float64(i%3)
+		if i == 25 {
+			cpu = 90
+		}
+		d.rows = append(d.rows, []driver.Value{base.Add(time.Duration(i) * time.Second), "legacy-1", cpu, int64(100), nil})
+	}
+	d.rows = append(d.rows,
+		// Invalid values, an unparsable timestamp and a metric past retention
+		[]driver.Value{base, "legacy-1", -1.0, 1.0, nil},
+		[]driver.Value{"yesterday", "legacy-1", 1.0, 1.0, nil},
+		[]driver.Value{base.Add(-2 * cache.MetricsTTL), []byte("legacy-2"), "5.5", 1.0, "eu-west"},
+		// Outside the requested range
+		[]driver.Value{clk.Now().Add(time.Minute), "legacy-1", 1.0, 1.0, nil},
+	)
+
+	store := cache.NewMemoryCache(clk)
+	imp := New(openFake(t, d), "SELECT ...", store, WithClock(clk), WithBatchSize(7),
+		WithRetroAnalysis(store, analytics.DefaultDetectorConfig()))
+	report, err := imp.Run(context.Background(), Request{
+		From:     base.Add(-3 * cache.MetricsTTL),
+		To:       clk.Now(),
+		Analyze:  true,
+		Detector: &analytics.DetectorConfig{WindowSize: 20, ZScoreThreshold: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 33 || report.Imported != 30 || report.Expired != 1 || report.Invalid != 2 || report.Truncated {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	stored, err := store.GetMetricsRange(base, clk.Now())
+	if err != nil || len(stored) != 30 || stored[25].CPU != 90 || stored[0].DeviceID != "legacy-1" {
+		t.Errorf("Expected 30 imported metrics, got %d (%v)", len(stored), err)
+	}
+	if latest, _ := store.GetLatestMetrics(10); len(latest) != 0 {
+		t.Errorf("Expected imported metrics to stay out of the latest list, got %d", len(latest))
+	}
+
+	// The expired metric is not stored but still analyzed; the report is kept by replay
+	if report.Replay == nil || report.Replay.Metrics != 31 || report.Replay.Anomalies != 1 {
+		t.Fatalf("Unexpected retro-analysis %+v", report.Replay)
+	}
+	if _, err := replay.New(store, store).Get(report.Replay.ID); err != nil {
+		t.Errorf("Expected a stored replay report: %v", err)
+	}
+}
+
+func TestImporter_LimitsAndErrors(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	d := &fakeDriver{columns: []string{"timestamp", "cpu", "rps"}}
+	for i := 0; i < 5; i++ {
+		d.rows = append(d.rows, []driver.Value{clk.Now().Add(-time.Duration(i) * time.Minute), 1.0, 1.0})
+	}
+	db := openFake(t, d)
+	store := cache.NewMemoryCache(clk)
+	req := Request{From: clk.Now().Add(-time.Hour), To: clk.Now()}
+
+	report, err := New(db, "q", store, WithClock(clk), WithMaxRows(3)).Run(context.Background(), req)
+	if err != nil || report.Rows != 3 || report.Imported != 3 || !report.Truncated {
+		t.Errorf("Expected 3 rows and truncation, got %+v (%v)", report, err)
+	}
+
+	if _, err := New(db, "q", store).Run(context.Background(), Request{From: req.To, To: req.From}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest for an inverted range, got %v", err)
+	}
+	req.Analyze = true
+	if _, err := New(db, "q", store).Run(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest without retro-analysis, got %v", err)
+	}
+
+	d.columns = []string{"timestamp", "cpu"}
+	if _, err := New(db, "q", store).Run(context.Background(), Request{From: req.From, To: req.To}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery without rps, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package incidents объединяет аномалии, близкие по времени, в инциденты:
+// 500 одновременных оповещений с разных устройств — это один инцидент, а не 500.
+//
+// Оповещение присоединяется к последнему инциденту, если пришло не позже Gap
+// после предыдущего оповещения этого инцидента, иначе открывает новый.
+// Инцидент активен, пока оповещения продолжают приходить с интервалом меньше Gap
+package incidents
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/anomalies"
+	"highload-service/internal/clock"
+)
+
+const (
+	// DefaultGap наибольший перерыв между оповещениями одного инцидента
+	DefaultGap = 2 * time.Minute
+	// DefaultCapacity количество хранимых инцидентов
+	DefaultCapacity = 500
+	// MaxDevicesListed количество устройств в инциденте, перечисляемых поименно;
+	// остальные только считаются
+	MaxDevicesListed = 1000
+)
+
+// ErrNotFound инцидент не найден или вытеснен
+var ErrNotFound = errors.New("incident not found")
+
+// Device участие устройства или группы в инциденте
+type Device struct {
+	DeviceID string `json:"device_id,omitempty"`
+	Group    string `json:"group,omitempty"`
+	// Alerts количество оповещений
+	Alerts int64 `json:"alerts"`
+	// MaxZScore наибольшее |z| по CPU и RPS
+	MaxZScore float64   `json:"max_z_score"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// Incident группа аномалий, близких по времени
+type Incident struct {
+	ID        string    `json:"id"`
+	Active    bool      `json:"active"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Anomalies количество различных аномалий
+	Anomalies int `json:"anomalies"`
+	// Alerts количество оповещений, включая повторные срабатывания
+	Alerts int64 `json:"alerts"`
+	// DeviceCount количество различных устройств и групп
+	DeviceCount int `json:"device_count"`
+	// Metrics количество оповещений, в которых отклонилась метрика (cpu, rps)
+	Metrics map[string]int64 `json:"metrics"`
+	// Devices участники от наиболее отклонившихся; не более MaxDevicesListed
+	Devices []Device `json:"devices,omitempty"`
+}
+
+// Option настраивает Correlator
+type Option func(*Correlator)
+
+// WithClock задает источник времени
+func WithClock(clk clock.Clock) Option {
+	return func(c *Correlator) {
+		c.clock = clk
+	}
+}
+
+// WithGap задает наибольший перерыв между оповещениями одного инцидента
+func WithGap(d time.Duration) Option {
+	return func(c *Correlator) {
+		if d > 0 {
+			c.gap = d
+		}
+	}
+}
+
+// WithCapacity задает количество хранимых инцидентов; старые вытесняются
+func WithCapacity(n int) Option {
+	return func(c *Correlator) {
+		if n > 0 {
+			c.capacity = n
+		}
+	}
+}
+
+// WithThreshold задает |z|, выше которого метрика считается отклонившейся
+func WithThreshold(z float64) Option {
+	return func(c *Correlator) {
+		if z > 0 {
+			c.threshold = z
+		}
+	}
+}
+
+type incident struct {
+	Incident
+	anomalies map[string]struct{}
+	devices   map[string]*Device
+}
+
+// Correlator группирует оповещения об аномалиях. Безопасен для конкурентного использования
+type Correlator struct {
+	clock     clock.Clock
+	gap       time.Duration
+	capacity  int
+	threshold float64
+
+	mu  sync.Mutex
+	seq int64
+	// incidents от старых к новым; присоединять можно только к последнему
+	incidents []*incident
+}
+
+// New создает Correlator
+func New(opts ...Option) *Correlator {
+	c := &Correlator{
+		clock:     clock.Real(),
+		gap:       DefaultGap,
+		capacity:  DefaultCapacity,
+		threshold: analytics.ZScoreThreshold,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Observe учитывает оповещение об аномалии; подходит как получатель оповещений трекера
+func (c *Correlator) Observe(a anomalies.Anomaly) {
+	now := c.clock.Now().UTC()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var inc *incident
+	if n := len(c.incidents); n > 0 && now.Sub(c.incidents[n-1].LastSeen) <= c.gap {
+		inc = c.incidents[n-1]
+	} else {
+		c.seq++
+		inc = &incident{
+			Incident: Incident{
+				ID:        fmt.Sprintf("%d-%d", now.Unix(), c.seq),
+				StartedAt: now,
+				Metrics:   map[string]int64{},
+			},
+			anomalies: make(map[string]struct{}),
+			devices:   make(map[string]*Device),
+		}
+		c.incidents = append(c.incidents, inc)
+		if len(c.incidents) > c.capacity {
+			c.incidents[0] = nil
+			c.incidents = c.incidents[1:]
+		}
+	}
+
+	inc.LastSeen = now
+	inc.Alerts++
+	inc.anomalies[a.ID] = struct{}{}
+	if math.Abs(a.ZScoreCPU) > c.threshold {
+		inc.Metrics["cpu"]++
+	}
+	if math.Abs(a.ZScoreRPS) > c.threshold {
+		inc.Metrics["rps"]++
+	}
+
+	key := a.DeviceID
+	if a.Group != "" {
+		key = "group:" + a.Group
+	}
+	d, ok := inc.devices[key]
+	if !ok {
+		inc.DeviceCount++
+		if len(inc.devices) >= MaxDevicesListed {
+			return
+		}
+		d = &Device{DeviceID: a.DeviceID, Group: a.Group, FirstSeen: now}
+		inc.devices[key] = d
+	}
+	d.Alerts++
+	d.MaxZScore = math.Max(d.MaxZScore, math.Max(math.Abs(a.ZScoreCPU), math.Abs(a.ZScoreRPS)))
+}
+
+// List возвращает до limit инцидентов от новых к старым (limit <= 0 — все);
+// activeOnly оставляет только активные
+func (c *Correlator) List(activeOnly bool, limit int) []Incident {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	list := []Incident{}
+	for i := len(c.incidents) - 1; i >= 0; i-- {
+		if limit > 0 && len(list) == limit {
+			break
+		}
+		// Список не перечисляет участников: их может быть много
+		inc := c.snapshotLocked(c.incidents[i], now, false)
+		if activeOnly && !inc.Active {
+			continue
+		}
+		list = append(list, inc)
+	}
+	return list
+}
+
+// Get возвращает инцидент с участниками
+func (c *Correlator) Get(id string) (Incident, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, inc := range c.incidents {
+		if inc.ID == id {
+			return c.snapshotLocked(inc, c.clock.Now(), true), nil
+		}
+	}
+	return Incident{}, ErrNotFound
+}
+
+// snapshotLocked копия инцидента; участники копируются, только если withDevices
+func (c *Correlator) snapshotLocked(inc *incident, now time.Time, withDevices bool) Incident {
+	out := inc.Incident
+	out.Active = now.Sub(inc.LastSeen) <= c.gap
+	out.Anomalies = len(inc.anomalies)
+	out.Metrics = make(map[string]int64, len(inc.Metrics))
+	for k, v := range inc.Metrics {
+		out.Metrics[k] = v
+	}
+	if !withDevices {
+		return out
+	}
+	out.Devices = make([]Device, 0, len(inc.devices))
+	for _, d := range inc.devices {
+		out.Devices = append(out.Devices, *d)
+	}
+	sort.Slice(out.Devices, func(i, j int) bool {
+		if out.Devices[i].MaxZScore != out.Devices[j].MaxZScore {
+			return out.Devices[i].MaxZScore > out.Devices[j].MaxZScore
+		}
+		return out.Devices[i].FirstSeen.Before(out.Devices[j].FirstSeen)
+	})
+	return out
+}
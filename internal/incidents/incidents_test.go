@@ -0,0 +1,77 @@
+package incidents
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/clock"
+)
+
+func TestCorrelator_GroupsSimultaneousAlerts(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := New(WithClock(clk), WithGap(time.Minute), WithThreshold(2))
+
+	// 500 devices alert within a few seconds, some of them twice
+	for i := 0; i < 500; i++ {
+		a := anomalies.Anomaly{ID: fmt.Sprintf("a-%d", i), DeviceID: fmt.Sprintf("sensor-%d", i), ZScoreCPU: 3}
+		if i == 42 {
+			a.ZScoreRPS = -9
+		}
+		c.Observe(a)
+		if i%100 == 0 {
+			c.Observe(a)
+			clk.Advance(time.Second)
+		}
+	}
+	c.Observe(anomalies.Anomaly{ID: "g-1", Group: "rack-1", ZScoreRPS: 4})
+
+	list := c.List(false, 0)
+	if len(list) != 1 {
+		t.Fatalf("Expected one incident, got %d", len(list))
+	}
+	inc := list[0]
+	if !inc.Active || inc.Anomalies != 501 || inc.Alerts != 506 || inc.DeviceCount != 501 {
+		t.Errorf("Unexpected incident %+v", inc)
+	}
+	if inc.Metrics["cpu"] != 505 || inc.Metrics["rps"] != 2 || inc.Devices != nil {
+		t.Errorf("Expected metric counts without devices in the list, got %+v", inc)
+	}
+
+	full, err := c.Get(inc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Devices) != 501 || full.Devices[0].DeviceID != "sensor-42" || full.Devices[1].Group != "rack-1" {
+		t.Errorf("Expected devices ordered by deviation, got %+v", full.Devices[:2])
+	}
+
+	// A gap longer than Gap ends the incident; the next alert opens a new one
+	clk.Advance(2 * time.Minute)
+	if active := c.List(true, 0); len(active) != 0 {
+		t.Errorf("Expected no active incidents after the gap, got %+v", active)
+	}
+	c.Observe(anomalies.Anomaly{ID: "a-x", DeviceID: "sensor-1", ZScoreCPU: 5})
+	list = c.List(false, 1)
+	if len(list) != 1 || list[0].ID == inc.ID || !list[0].Active || list[0].DeviceCount != 1 {
+		t.Errorf("Expected a new active incident first, got %+v", list)
+	}
+
+	if _, err := c.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCorrelator_EvictsOldIncidents(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := New(WithClock(clk), WithGap(time.Second), WithCapacity(2))
+	for i := 0; i < 3; i++ {
+		c.Observe(anomalies.Anomaly{ID: fmt.Sprint(i), DeviceID: "sensor-1"})
+		clk.Advance(time.Minute)
+	}
+	if list := c.List(false, 0); len(list) != 2 {
+		t.Errorf("Expected 2 incidents kept, got %d", len(list))
+	}
+}
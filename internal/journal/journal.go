@@ -0,0 +1,188 @@
+// Package journal журнал результатов анализа с возобновляемыми курсорами.
+//
+// Каждый результат дописывается в конец журнала (поток Redis или сегменты
+// файлов) под идентификатором "<unix ms>-<seq>", который и служит курсором.
+// Потребитель (SSE, экспорт) запоминает курсор последней прочитанной записи и
+// после переподключения продолжает с него, поэтому перезапуск клиента не
+// теряет аномалий, пока записи не вытеснены по MaxLen
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// Stream поток результатов анализа
+	Stream = "journal:results"
+	// DefaultMaxLen примерное количество хранимых записей
+	DefaultMaxLen = 1000000
+	// MaxRead ограничение числа записей в одном чтении
+	MaxRead = 1000
+	// pageSize количество записей, читаемых из журнала за раз при фильтрации
+	pageSize = 500
+)
+
+// ErrInvalidCursor курсор не имеет вида "<unix ms>-<seq>"
+var ErrInvalidCursor = errors.New("invalid journal cursor")
+
+// Log журнал записей (реализуется cache.RedisCache, cache.MemoryCache и SegmentLog)
+type Log interface {
+	AppendStream(stream string, data []byte, maxLen int64) (string, error)
+	RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error)
+}
+
+// Entry запись журнала
+type Entry struct {
+	// Cursor идентификатор записи; чтение с курсором продолжается после нее
+	Cursor   string                `json:"cursor"`
+	DeviceID string                `json:"device_id,omitempty"`
+	Region   string                `json:"region,omitempty"`
+	Result   models.AnalysisResult `json:"result"`
+}
+
+// Option настраивает Journal
+type Option func(*Journal)
+
+// WithMaxLen задает примерное количество хранимых записей
+func WithMaxLen(n int64) Option {
+	return func(j *Journal) {
+		if n > 0 {
+			j.maxLen = n
+		}
+	}
+}
+
+// Journal журнал результатов анализа. Безопасен для конкурентного использования
+type Journal struct {
+	log    Log
+	maxLen int64
+
+	mu sync.Mutex
+	// appended закрывается при следующей записи; создается только для ожидающих
+	appended chan struct{}
+}
+
+// New создает журнал поверх log
+func New(log Log, opts ...Option) *Journal {
+	j := &Journal{log: log, maxLen: DefaultMaxLen}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Append дописывает результат анализа метрики. Ошибка записи только
+// логируется: журнал не должен останавливать прием метрик
+func (j *Journal) Append(metric models.Metric, result models.AnalysisResult) {
+	data, err := json.Marshal(Entry{DeviceID: metric.DeviceID, Region: metric.Region, Result: result})
+	if err == nil {
+		_, err = j.log.AppendStream(Stream, data, j.maxLen)
+	}
+	if err != nil {
+		metrics.JournalWrites.WithLabelValues("failed").Inc()
+		log.Printf("Failed to append analysis result to journal: %v", err)
+		return
+	}
+	metrics.JournalWrites.WithLabelValues("appended").Inc()
+
+	j.mu.Lock()
+	if j.appended != nil {
+		close(j.appended)
+		j.appended = nil
+	}
+	j.mu.Unlock()
+}
+
+// Read возвращает до limit записей после курсора after (пустой — с начала
+// журнала) и курсор, с которого продолжать чтение. anomaliesOnly оставляет
+// только результаты с аномалией; курсор при этом продвигается и по пропущенным
+func (j *Journal) Read(after string, limit int, anomaliesOnly bool) ([]Entry, string, error) {
+	start := "-"
+	if after != "" {
+		if !ValidCursor(after) {
+			return nil, "", fmt.Errorf("%w %q", ErrInvalidCursor, after)
+		}
+		start = after
+	}
+	if limit <= 0 || limit > MaxRead {
+		limit = MaxRead
+	}
+
+	entries := []Entry{}
+	next := after
+	for len(entries) < limit {
+		raw, err := j.log.RangeStream(Stream, start, pageSize+1)
+		if err != nil {
+			return nil, "", err
+		}
+		read := 0
+		for _, r := range raw {
+			// Начало диапазона включено в ответ, а запись курсора уже прочитана
+			if r.ID == after || len(entries) == limit {
+				continue
+			}
+			read++
+			next = r.ID
+			var e Entry
+			if err := json.Unmarshal(r.Data, &e); err != nil {
+				log.Printf("Skipping malformed journal entry %s: %v", r.ID, err)
+				continue
+			}
+			if anomaliesOnly && !e.Result.AnomalyDetected {
+				continue
+			}
+			e.Cursor = r.ID
+			entries = append(entries, e)
+		}
+		if read == 0 || len(raw) <= pageSize {
+			break
+		}
+		start, after = next, next
+	}
+	return entries, next, nil
+}
+
+// Wait ждет следующей записи этого экземпляра не дольше timeout. Возвращает false при
+// отмене ctx. Записи, сделанные другими экземплярами в общий поток, обнаруживаются
+// по истечении timeout
+func (j *Journal) Wait(ctx context.Context, timeout time.Duration) bool {
+	j.mu.Lock()
+	if j.appended == nil {
+		j.appended = make(chan struct{})
+	}
+	appended := j.appended
+	j.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-appended:
+	case <-timer.C:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// ValidCursor проверяет, что курсор имеет вид "<unix ms>-<seq>"
+func ValidCursor(cursor string) bool {
+	ms, seq, ok := strings.Cut(cursor, "-")
+	if !ok {
+		return false
+	}
+	_, errMs := strconv.ParseUint(ms, 10, 64)
+	_, errSeq := strconv.ParseUint(seq, 10, 64)
+	return errMs == nil && errSeq == nil
+}
@@ -0,0 +1,97 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+func TestJournal_ResumesFromCursor(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	j := New(cache.NewMemoryCache(clk))
+
+	for i := 0; i < 1200; i++ {
+		j.Append(models.Metric{DeviceID: "sensor-1"}, models.AnalysisResult{AnomalyDetected: i%400 == 399, ZScoreCPU: float64(i)})
+	}
+
+	first, cursor, err := j.Read("", 10, false)
+	if err != nil || len(first) != 10 || cursor != first[9].Cursor {
+		t.Fatalf("Unexpected first page %d entries, cursor %q (%v)", len(first), cursor, err)
+	}
+	// A reconnecting client continues right after the last entry it saw
+	second, _, err := j.Read(cursor, 1, false)
+	if err != nil || len(second) != 1 || second[0].Result.ZScoreCPU != 10 || second[0].DeviceID != "sensor-1" {
+		t.Errorf("Expected the 11th result, got %+v (%v)", second, err)
+	}
+
+	// Filtering pages through the whole journal and advances past skipped entries
+	anomalies, next, err := j.Read("", 0, true)
+	if err != nil || len(anomalies) != 3 || anomalies[2].Result.ZScoreCPU != 1199 {
+		t.Fatalf("Expected 3 anomalies, got %+v (%v)", anomalies, err)
+	}
+	if more, same, _ := j.Read(next, 0, true); len(more) != 0 || same != next {
+		t.Errorf("Expected nothing after the end, got %d entries, cursor %q", len(more), same)
+	}
+
+	if _, _, err := j.Read("latest", 0, false); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestSegmentLog_RotatesAndReopens(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	l, err := OpenSegmentLog(dir, 3, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		id, err := l.AppendStream(Stream, []byte(`{"n":1}`), 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	l.Close()
+
+	// 10 entries in segments of 3 with maxLen 6 keep the newest 3 segments
+	segments, _ := filepath.Glob(filepath.Join(dir, "journal_results", "*.jsonl"))
+	if len(segments) != 3 {
+		t.Errorf("Expected 3 segments, got %v", segments)
+	}
+
+	// A crash left a torn last line
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"id":"broken`)
+	f.Close()
+
+	l, err = OpenSegmentLog(dir, 3, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	all, err := l.RangeStream(Stream, "-", 100)
+	if err != nil || len(all) != 7 || all[0].ID != ids[3] {
+		t.Fatalf("Expected entries from %s, got %+v (%v)", ids[3], all, err)
+	}
+	from, _ := l.RangeStream(Stream, ids[7], 2)
+	if len(from) != 2 || from[0].ID != ids[7] || from[1].ID != ids[8] {
+		t.Errorf("Expected range from %s, got %+v", ids[7], from)
+	}
+
+	// Numbering continues after the reopened log and the torn line does not eat the next entry
+	id, err := l.AppendStream(Stream, []byte(`{"n":2}`), 6)
+	if err != nil || id != "1704110400000-10" {
+		t.Errorf("Expected id 1704110400000-10, got %q (%v)", id, err)
+	}
+	if tail, _ := l.RangeStream(Stream, id, 1); len(tail) != 1 || string(tail[0].Data) != `{"n":2}` {
+		t.Errorf("Expected the new entry to be readable, got %+v", tail)
+	}
+}
@@ -0,0 +1,307 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/encryption"
+)
+
+// DefaultSegmentSize количество записей в одном файле сегмента
+const DefaultSegmentSize = 100000
+
+// segmentRecord строка файла сегмента
+type segmentRecord struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// segment файл сегмента; имя файла — идентификатор первой записи
+type segment struct {
+	path    string
+	ms, seq int64
+}
+
+// segmentStream сегменты одного потока, от старых к новым
+type segmentStream struct {
+	dir      string
+	segments []segment
+	current  *os.File
+	// currentCount количество записей в последнем сегменте
+	currentCount int
+	lastMs       int64
+	lastSeq      int64
+}
+
+// SegmentLog журнал в файлах JSON Lines для установок без Redis. Поток
+// хранится в каталоге из сегментов по segmentSize записей; запись только
+// дописывается в конец последнего сегмента, а вытеснение по maxLen удаляет
+// старые сегменты целиком, поэтому файлы никогда не перезаписываются
+type SegmentLog struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int
+	clock       clock.Clock
+	// keys шифруют данные записей; nil — без шифрования
+	keys    *encryption.Keyring
+	streams map[string]*segmentStream
+}
+
+// SegmentOption настраивает SegmentLog
+type SegmentOption func(*SegmentLog)
+
+// WithSegmentEncryption шифрует данные записей ключами keys
+func WithSegmentEncryption(keys *encryption.Keyring) SegmentOption {
+	return func(l *SegmentLog) {
+		l.keys = keys
+	}
+}
+
+// OpenSegmentLog открывает журнал в каталоге dir, создавая его при необходимости
+func OpenSegmentLog(dir string, segmentSize int, clk clock.Clock, opts ...SegmentOption) (*SegmentLog, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if clk == nil {
+		clk = clock.Real()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	l := &SegmentLog{dir: dir, segmentSize: segmentSize, clock: clk, streams: make(map[string]*segmentStream)}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
+}
+
+// AppendStream дописывает запись в последний сегмент потока
+func (l *SegmentLog) AppendStream(stream string, data []byte, maxLen int64) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, err := l.stream(stream)
+	if err != nil {
+		return "", err
+	}
+	ms := l.clock.Now().UnixMilli()
+	if ms > s.lastMs {
+		s.lastMs, s.lastSeq = ms, 0
+	} else {
+		s.lastSeq++
+	}
+	id := fmt.Sprintf("%d-%d", s.lastMs, s.lastSeq)
+
+	if s.current == nil || s.currentCount >= l.segmentSize {
+		if err := l.rotate(s, maxLen); err != nil {
+			return "", err
+		}
+	}
+	sealed, err := l.keys.Seal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt journal entry: %w", err)
+	}
+	line, err := json.Marshal(segmentRecord{ID: id, Data: sealed})
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.current.Write(append(line, '\n')); err != nil {
+		return "", fmt.Errorf("failed to write journal segment: %w", err)
+	}
+	s.currentCount++
+	return id, nil
+}
+
+// RangeStream возвращает до count записей потока, начиная с start включительно ("-" — с начала)
+func (l *SegmentLog) RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, err := l.stream(stream)
+	if err != nil {
+		return nil, err
+	}
+	startMs, startSeq := int64(-1), int64(0)
+	if start != "-" {
+		startMs, startSeq = parseID(start)
+	}
+	// Первый сегмент, который может содержать start
+	first := sort.Search(len(s.segments), func(i int) bool {
+		return idLess(startMs, startSeq, s.segments[i].ms, s.segments[i].seq)
+	}) - 1
+	if first < 0 {
+		first = 0
+	}
+
+	entries := []cache.StreamEntry{}
+	for _, seg := range s.segments[first:] {
+		if int64(len(entries)) >= count {
+			break
+		}
+		err := scanSegment(seg.path, func(rec segmentRecord) bool {
+			if ms, seq := parseID(rec.ID); idLess(ms, seq, startMs, startSeq) {
+				return true
+			}
+			// Запись, которую не удалось расшифровать, отдается как есть и будет пропущена как испорченная
+			data, err := l.keys.Open(rec.Data)
+			if err != nil {
+				data = rec.Data
+			}
+			entries = append(entries, cache.StreamEntry{ID: rec.ID, Data: data})
+			return int64(len(entries)) < count
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Close закрывает открытые сегменты
+func (l *SegmentLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, s := range l.streams {
+		if s.current != nil {
+			if err := s.current.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			s.current = nil
+		}
+	}
+	return firstErr
+}
+
+// stream возвращает поток, при первом обращении загружая список его сегментов
+func (l *SegmentLog) stream(name string) (*segmentStream, error) {
+	if s, ok := l.streams[name]; ok {
+		return s, nil
+	}
+	s := &segmentStream{dir: filepath.Join(l.dir, strings.ReplaceAll(name, ":", "_"))}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		ms, seq := parseID(strings.TrimSuffix(filepath.Base(path), ".jsonl"))
+		s.segments = append(s.segments, segment{path: path, ms: ms, seq: seq})
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return idLess(s.segments[i].ms, s.segments[i].seq, s.segments[j].ms, s.segments[j].seq)
+	})
+
+	// Последний сегмент продолжает заполняться, а его последняя запись задает
+	// начало нумерации новых
+	if n := len(s.segments); n > 0 {
+		last := s.segments[n-1]
+		s.lastMs, s.lastSeq = last.ms, last.seq
+		err := scanSegment(last.path, func(rec segmentRecord) bool {
+			s.currentCount++
+			s.lastMs, s.lastSeq = parseID(rec.ID)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if s.current, err = os.OpenFile(last.path, os.O_RDWR|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to open journal segment: %w", err)
+		}
+		// Недописанная при падении строка завершается, чтобы не испортить следующую запись
+		if info, err := s.current.Stat(); err == nil && info.Size() > 0 {
+			tail := make([]byte, 1)
+			if _, err := s.current.ReadAt(tail, info.Size()-1); err == nil && tail[0] != '\n' {
+				s.current.Write([]byte{'\n'})
+			}
+		}
+	}
+	l.streams[name] = s
+	return s, nil
+}
+
+// rotate начинает новый сегмент и удаляет старые сверх maxLen записей
+func (l *SegmentLog) rotate(s *segmentStream, maxLen int64) error {
+	if s.current != nil {
+		if err := s.current.Close(); err != nil {
+			return fmt.Errorf("failed to close journal segment: %w", err)
+		}
+		s.current = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%d-%d.jsonl", s.lastMs, s.lastSeq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create journal segment: %w", err)
+	}
+	s.current, s.currentCount = f, 0
+	s.segments = append(s.segments, segment{path: path, ms: s.lastMs, seq: s.lastSeq})
+
+	if maxLen <= 0 {
+		return nil
+	}
+	// Полные сегменты сверх maxLen удаляются вместе с записями
+	keep := int((maxLen+int64(l.segmentSize)-1)/int64(l.segmentSize)) + 1
+	for len(s.segments) > keep {
+		if err := os.Remove(s.segments[0].path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove journal segment %s: %v", s.segments[0].path, err)
+		}
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// scanSegment вызывает fn для записей сегмента по порядку, пока fn возвращает true.
+// Испорченные строки (например, недописанная при падении) пропускаются
+func scanSegment(path string, fn func(segmentRecord) bool) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open journal segment: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec segmentRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Printf("Skipping malformed line in journal segment %s: %v", path, err)
+			continue
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journal segment: %w", err)
+	}
+	return nil
+}
+
+// parseID разбирает идентификатор "<ms>-<seq>"
+func parseID(id string) (ms, seq int64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseInt(msPart, 10, 64)
+	seq, _ = strconv.ParseInt(seqPart, 10, 64)
+	return ms, seq
+}
+
+// idLess сравнивает идентификаторы записей
+func idLess(ms1, seq1, ms2, seq2 int64) bool {
+	return ms1 < ms2 || ms1 == ms2 && seq1 < seq2
+}
@@ -0,0 +1,621 @@
+package jsonfast
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDepth наибольшая вложенность пропускаемых значений, как в encoding/json
+const maxDepth = 10000
+
+// SyntaxError документ не является корректным JSON
+type SyntaxError struct {
+	msg string
+	// Offset смещение в байтах, на котором обнаружена ошибка
+	Offset int64
+}
+
+func (e *SyntaxError) Error() string { return e.msg }
+
+// TypeError значение JSON не подходит полю; сообщение совпадает с
+// json.UnmarshalTypeError
+type TypeError struct {
+	// Value описание значения: string, number 1.5, object...
+	Value string
+	// Type тип Go, в который шел разбор
+	Type   string
+	Offset int64
+	// Struct и Field структура и имя поля JSON; пустые вне полей структуры
+	Struct string
+	Field  string
+}
+
+func (e *TypeError) Error() string {
+	if e.Struct != "" || e.Field != "" {
+		return "json: cannot unmarshal " + e.Value + " into Go struct field " + e.Struct + "." + e.Field + " of type " + e.Type
+	}
+	return "json: cannot unmarshal " + e.Value + " into Go value of type " + e.Type
+}
+
+// Decoder разбирает один документ JSON. Разбор прерывается на первой ошибке:
+// после нее методы ничего не читают и возвращают нулевые значения, а ошибку
+// возвращают Err и End. Нулевое значение готово к Reset
+type Decoder struct {
+	data []byte
+	pos  int
+	err  error
+	// first следующий элемент контейнера первый и идет без запятой
+	first bool
+	// structName и field поле структуры, которое сейчас разбирается
+	structName, field string
+	// scratch буфер строк с escape-последовательностями
+	scratch []byte
+}
+
+// Reset начинает разбор документа data
+func (d *Decoder) Reset(data []byte) {
+	*d = Decoder{data: data, scratch: d.scratch[:0]}
+}
+
+// Err первая ошибка разбора
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// End проверяет, что после значения остались только пробельные символы,
+// и возвращает первую ошибку разбора
+func (d *Decoder) End() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.skipSpace() {
+		d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + " after top-level value")
+	}
+	return d.err
+}
+
+// SetField задает поле структуры для сообщений об ошибках типа
+func (d *Decoder) SetField(structName, field string) {
+	d.structName, d.field = structName, field
+}
+
+// Null пропускает null и возвращает true, если следующее значение — null
+func (d *Decoder) Null() bool {
+	if d.err != nil || !d.skipSpace() || d.data[d.pos] != 'n' {
+		return false
+	}
+	return d.literal("null")
+}
+
+// BeginObject читает начало объекта. Другое значение — ошибка типа typ
+func (d *Decoder) BeginObject(typ string) bool {
+	return d.begin('{', typ)
+}
+
+// BeginArray читает начало массива. Другое значение — ошибка типа typ
+func (d *Decoder) BeginArray(typ string) bool {
+	return d.begin('[', typ)
+}
+
+func (d *Decoder) begin(c byte, typ string) bool {
+	if !d.peek() {
+		return false
+	}
+	if d.data[d.pos] != c {
+		d.typeError(typ)
+		return false
+	}
+	d.pos++
+	d.first = true
+	return true
+}
+
+// More сообщает, есть ли в контейнере следующий элемент, и пропускает запятую
+// перед ним; end — закрывающая скобка контейнера, которая читается в конце
+func (d *Decoder) More(end byte) bool {
+	if !d.peek() {
+		return false
+	}
+	if d.data[d.pos] == end {
+		d.pos++
+		d.first = false
+		return false
+	}
+	if !d.first {
+		if d.data[d.pos] != ',' {
+			d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + afterElement(end))
+			return false
+		}
+		d.pos++
+		if !d.peek() {
+			return false
+		}
+	}
+	d.first = false
+	if end == '}' && d.data[d.pos] != '"' {
+		d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + " looking for beginning of object key string")
+		return false
+	}
+	return true
+}
+
+// Key читает ключ объекта и двоеточие после него. Срез действителен до
+// следующего вызова Decoder
+func (d *Decoder) Key() []byte {
+	key := d.stringBytes()
+	if d.err != nil {
+		return nil
+	}
+	if !d.peek() {
+		return nil
+	}
+	if d.data[d.pos] != ':' {
+		d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + " after object key")
+		return nil
+	}
+	d.pos++
+	return key
+}
+
+// KeyString читает ключ объекта строкой (ключи карт)
+func (d *Decoder) KeyString() string {
+	return string(d.Key())
+}
+
+// String читает строку
+func (d *Decoder) String() string {
+	if !d.expect('"', "string") {
+		return ""
+	}
+	return string(d.stringBytes())
+}
+
+// Bool читает true или false
+func (d *Decoder) Bool() bool {
+	if !d.peek() {
+		return false
+	}
+	switch d.data[d.pos] {
+	case 't':
+		return d.literal("true")
+	case 'f':
+		d.literal("false")
+		return false
+	}
+	d.typeError("bool")
+	return false
+}
+
+// Float64 читает число
+func (d *Decoder) Float64() float64 {
+	num := d.number("float64")
+	if num == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		d.numberError(num, "float64")
+	}
+	return f
+}
+
+// Int читает целое число
+func (d *Decoder) Int() int {
+	num := d.number("int")
+	if num == nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(string(num), 10, strconv.IntSize)
+	if err != nil {
+		d.numberError(num, "int")
+	}
+	return int(n)
+}
+
+// Uint64 читает неотрицательное целое число
+func (d *Decoder) Uint64() uint64 {
+	num := d.number("uint64")
+	if num == nil {
+		return 0
+	}
+	n, err := strconv.ParseUint(string(num), 10, 64)
+	if err != nil {
+		d.numberError(num, "uint64")
+	}
+	return n
+}
+
+// Time читает время в формате RFC 3339, как time.Time.UnmarshalJSON
+func (d *Decoder) Time() time.Time {
+	if !d.expect('"', "time.Time") {
+		return time.Time{}
+	}
+	start := d.pos
+	d.stringBytes()
+	if d.err != nil {
+		return time.Time{}
+	}
+	var t time.Time
+	if err := t.UnmarshalJSON(d.data[start:d.pos]); err != nil {
+		d.err = err
+	}
+	return t
+}
+
+// Skip пропускает значение любого типа, проверяя его синтаксис
+func (d *Decoder) Skip() {
+	d.skip(0)
+}
+
+func (d *Decoder) skip(depth int) {
+	if !d.peek() {
+		return
+	}
+	if depth > maxDepth {
+		d.syntaxError("exceeded max depth")
+		return
+	}
+	switch c := d.data[d.pos]; {
+	case c == '{':
+		d.pos++
+		d.first = true
+		for d.More('}') {
+			d.Key()
+			d.skip(depth + 1)
+		}
+	case c == '[':
+		d.pos++
+		d.first = true
+		for d.More(']') {
+			d.skip(depth + 1)
+		}
+	case c == '"':
+		d.stringBytes()
+	case c == 't':
+		d.literal("true")
+	case c == 'f':
+		d.literal("false")
+	case c == 'n':
+		d.literal("null")
+	default:
+		d.scanNumber()
+	}
+}
+
+// skipSpace пропускает пробельные символы; false — документ закончился
+func (d *Decoder) skipSpace() bool {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// peek пропускает пробельные символы перед значением; false — ошибка или
+// неожиданный конец документа
+func (d *Decoder) peek() bool {
+	if d.err != nil {
+		return false
+	}
+	if !d.skipSpace() {
+		d.syntaxError("unexpected end of JSON input")
+		return false
+	}
+	return true
+}
+
+// expect проверяет, что значение начинается с c; иначе ошибка типа typ
+func (d *Decoder) expect(c byte, typ string) bool {
+	if !d.peek() {
+		return false
+	}
+	if d.data[d.pos] != c {
+		d.typeError(typ)
+		return false
+	}
+	return true
+}
+
+// literal читает true, false или null
+func (d *Decoder) literal(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if d.pos+i >= len(d.data) {
+			d.syntaxError("unexpected end of JSON input")
+			return false
+		}
+		if d.data[d.pos+i] != word[i] {
+			d.pos += i
+			d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + " in literal " + word + " (expecting " + quoteChar(word[i]) + ")")
+			return false
+		}
+	}
+	d.pos += len(word)
+	return true
+}
+
+// number читает число; значение другого типа — ошибка типа typ
+func (d *Decoder) number(typ string) []byte {
+	if !d.peek() {
+		return nil
+	}
+	if c := d.data[d.pos]; c != '-' && (c < '0' || c > '9') {
+		d.typeError(typ)
+		return nil
+	}
+	return d.scanNumber()
+}
+
+// scanNumber читает число по грамматике JSON
+func (d *Decoder) scanNumber() []byte {
+	start := d.pos
+	data := d.data
+	i := d.pos
+	if i < len(data) && data[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(data) && data[i] == '0':
+		i++
+	case i < len(data) && data[i] >= '1' && data[i] <= '9':
+		for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+	default:
+		d.pos = i
+		d.invalid("looking for beginning of value", "in numeric literal")
+		return nil
+	}
+	if i < len(data) && data[i] == '.' {
+		i++
+		if !isDigit(data, i) {
+			d.pos = i
+			d.invalid("after decimal point in numeric literal", "")
+			return nil
+		}
+		for isDigit(data, i) {
+			i++
+		}
+	}
+	if i < len(data) && (data[i] == 'e' || data[i] == 'E') {
+		i++
+		if i < len(data) && (data[i] == '+' || data[i] == '-') {
+			i++
+		}
+		if !isDigit(data, i) {
+			d.pos = i
+			d.invalid("in exponent of numeric literal", "")
+			return nil
+		}
+		for isDigit(data, i) {
+			i++
+		}
+	}
+	d.pos = i
+	return data[start:i]
+}
+
+// invalid ошибка неожиданного символа; в середине числа — с пояснением inNumber
+func (d *Decoder) invalid(context, inNumber string) {
+	if d.pos >= len(d.data) {
+		d.syntaxError("unexpected end of JSON input")
+		return
+	}
+	if inNumber != "" && d.pos > 0 && d.data[d.pos-1] == '-' {
+		context = inNumber
+	}
+	d.syntaxError("invalid character " + quoteChar(d.data[d.pos]) + " " + context)
+}
+
+func isDigit(data []byte, i int) bool {
+	return i < len(data) && data[i] >= '0' && data[i] <= '9'
+}
+
+// stringBytes читает строку с кавычкой в текущей позиции. Строка без
+// escape-последовательностей возвращается срезом документа, иначе — срезом
+// буфера scratch
+func (d *Decoder) stringBytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	d.pos++
+	start := d.pos
+	for i := start; i < len(d.data); i++ {
+		switch c := d.data[i]; {
+		case c == '"':
+			d.pos = i + 1
+			return d.data[start:i]
+		case c == '\\' || c >= utf8.RuneSelf:
+			return d.unquote(start, i)
+		case c < 0x20:
+			d.pos = i
+			d.syntaxError("invalid character " + quoteChar(c) + " in string")
+			return nil
+		}
+	}
+	d.pos = len(d.data)
+	d.syntaxError("unexpected end of JSON input")
+	return nil
+}
+
+// unquote дочитывает строку с escape-последовательностями или символами
+// не из ASCII начиная с i; некорректный UTF-8 заменяется на U+FFFD
+func (d *Decoder) unquote(start, i int) []byte {
+	buf := append(d.scratch[:0], d.data[start:i]...)
+	data := d.data
+	for i < len(data) {
+		c := data[i]
+		switch {
+		case c == '"':
+			d.pos = i + 1
+			// Буфер переиспользуется: ключ или строка копируются вызывающим
+			d.scratch = buf
+			return buf
+		case c == '\\':
+			if i+1 >= len(data) {
+				d.pos = len(data)
+				d.syntaxError("unexpected end of JSON input")
+				return nil
+			}
+			switch e := data[i+1]; e {
+			case '"', '\\', '/':
+				buf = append(buf, e)
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'u':
+				r, n := d.unicodeEscape(i)
+				if n == 0 {
+					return nil
+				}
+				buf = utf8.AppendRune(buf, r)
+				i += n
+				continue
+			default:
+				d.pos = i + 1
+				d.syntaxError("invalid character " + quoteChar(e) + " in string escape code")
+				return nil
+			}
+			i += 2
+		case c < 0x20:
+			d.pos = i
+			d.syntaxError("invalid character " + quoteChar(c) + " in string")
+			return nil
+		case c < utf8.RuneSelf:
+			buf = append(buf, c)
+			i++
+		default:
+			r, size := utf8.DecodeRune(data[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, "\uFFFD"...)
+			} else {
+				buf = append(buf, data[i:i+size]...)
+			}
+			i += size
+		}
+	}
+	d.pos = len(data)
+	d.syntaxError("unexpected end of JSON input")
+	return nil
+}
+
+// unicodeEscape разбирает \uXXXX в позиции i, включая суррогатную пару.
+// n — длина прочитанного; 0 при ошибке
+func (d *Decoder) unicodeEscape(i int) (rune, int) {
+	r, ok := d.hex4(i + 2)
+	if !ok {
+		return 0, 0
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, 6
+	}
+	if i+7 < len(d.data) && d.data[i+6] == '\\' && d.data[i+7] == 'u' {
+		if r2, ok := d.hex4(i + 8); ok {
+			if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+				return dec, 12
+			}
+		} else {
+			return 0, 0
+		}
+	}
+	return utf8.RuneError, 6
+}
+
+func (d *Decoder) hex4(i int) (rune, bool) {
+	var r rune
+	for j := i; j < i+4; j++ {
+		if j >= len(d.data) {
+			d.pos = len(d.data)
+			d.syntaxError("unexpected end of JSON input")
+			return 0, false
+		}
+		c := d.data[j]
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			d.pos = j
+			d.syntaxError("invalid character " + quoteChar(d.data[j]) + " in \\u hexadecimal character escape")
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+// typeError ошибка типа для значения в текущей позиции
+func (d *Decoder) typeError(typ string) {
+	var value string
+	switch c := d.data[d.pos]; {
+	case c == '{':
+		value = "object"
+	case c == '[':
+		value = "array"
+	case c == '"':
+		value = "string"
+	case c == 't' || c == 'f':
+		value = "bool"
+	case c == 'n':
+		value = "null"
+	case c == '-' || (c >= '0' && c <= '9'):
+		value = "number"
+	default:
+		d.syntaxError("invalid character " + quoteChar(c) + " looking for beginning of value")
+		return
+	}
+	d.err = &TypeError{Value: value, Type: typ, Offset: int64(d.pos), Struct: d.structName, Field: d.field}
+}
+
+// numberError число не помещается в тип typ
+func (d *Decoder) numberError(num []byte, typ string) {
+	d.err = &TypeError{Value: "number " + string(num), Type: typ, Offset: int64(d.pos), Struct: d.structName, Field: d.field}
+}
+
+func (d *Decoder) syntaxError(msg string) {
+	if d.err == nil {
+		d.err = &SyntaxError{msg: msg, Offset: int64(d.pos)}
+	}
+}
+
+// afterElement продолжение сообщения о символе после элемента контейнера
+func afterElement(end byte) string {
+	if end == '}' {
+		return " after object key:value pair"
+	}
+	return " after array element"
+}
+
+// quoteChar символ для сообщения об ошибке, как в encoding/json
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(rune(c)))
+	return "'" + s[1:len(s)-1] + "'"
+}
+
+// EqualFold сравнивает ключ с именем поля без учета регистра: encoding/json
+// принимает ключи в любом регистре, если точного совпадения нет
+func EqualFold(key []byte, name string) bool {
+	return strings.EqualFold(string(key), name)
+}
@@ -0,0 +1,77 @@
+package jsonfast
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecoder_ReadsValues(t *testing.T) {
+	var d Decoder
+	d.Reset([]byte(` {"s":"a\"bé","f":-1.5e2,"u":42,"i":-7,"b":true,"n":null,"skip":[{"x":[1,"y",false]}],"t":"2024-01-01T00:00:00Z"} `))
+	if !d.BeginObject("object") {
+		t.Fatal(d.Err())
+	}
+	got := map[string]interface{}{}
+	for d.More('}') {
+		switch key := string(d.Key()); key {
+		case "s":
+			got[key] = d.String()
+		case "f":
+			got[key] = d.Float64()
+		case "u":
+			got[key] = d.Uint64()
+		case "i":
+			got[key] = d.Int()
+		case "b":
+			got[key] = d.Bool()
+		case "n":
+			got[key] = d.Null()
+		case "t":
+			got[key] = d.Time().Year()
+		default:
+			d.Skip()
+		}
+	}
+	if err := d.End(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"s": "a\"bé", "f": -150.0, "u": uint64(42), "i": -7, "b": true, "n": true, "t": 2024}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestDecoder_SyntaxErrorsMatchEncodingJSON(t *testing.T) {
+	for _, doc := range []string{``, `{`, `{"a" 1}`, `{"a":1,}`, `{a:1}`, `{"a":1} x`, `{"a":01}`, `{"a":tru}`, "{\"a\":\"\x01\"}"} {
+		var d Decoder
+		d.Reset([]byte(doc))
+		if d.BeginObject("object") {
+			for d.More('}') {
+				d.Key()
+				d.Skip()
+			}
+		}
+		err := d.End()
+		var v interface{}
+		want := json.Unmarshal([]byte(doc), &v)
+		if err == nil || want == nil || err.Error() != want.Error() {
+			t.Errorf("%q: got %v, want %v", doc, err, want)
+		}
+	}
+}
+
+func TestDecoder_TypeErrorNamesField(t *testing.T) {
+	var d Decoder
+	d.Reset([]byte(`{"cpu":"high"}`))
+	d.BeginObject("models.Metric")
+	d.More('}')
+	d.Key()
+	d.SetField("Metric", "cpu")
+	d.Float64()
+	want := "json: cannot unmarshal string into Go struct field Metric.cpu of type float64"
+	if err := d.End(); err == nil || err.Error() != want {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,55 @@
+package jsonfast
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAppendString_MatchesEncodingJSON(t *testing.T) {
+	for _, s := range []string{
+		"", "plain", `"quoted" \ back`, "<script>&amp;</script>", "\x00\x1f\b\f\n\r\t\x7f",
+		"é ü 😀", "  ", "bad \xff\xfe utf8", "\xed\xa0\x80",
+	} {
+		want, _ := json.Marshal(s)
+		if got := AppendString(nil, s); string(got) != string(want) {
+			t.Errorf("%q: got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestAppendFloat_MatchesEncodingJSON(t *testing.T) {
+	for _, f := range []float64{
+		0, math.Copysign(0, -1), 1, -1.5, 0.1, 1e-6, 9.99e-7, 1e-7, 1e20, 1e21, 123456789.125,
+		math.MaxFloat64, math.SmallestNonzeroFloat64, -1e-300,
+	} {
+		want, _ := json.Marshal(f)
+		got, err := AppendFloat(nil, f)
+		if err != nil || string(got) != string(want) {
+			t.Errorf("%v: got %s (%v), want %s", f, got, err, want)
+		}
+	}
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := AppendFloat(nil, f); err == nil {
+			t.Errorf("%v: expected an error", f)
+		}
+	}
+}
+
+func TestAppendTime_MatchesEncodingJSON(t *testing.T) {
+	for _, ts := range []time.Time{
+		{},
+		time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC),
+		time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", -90*60)),
+	} {
+		want, _ := json.Marshal(ts)
+		got, err := AppendTime(nil, ts)
+		if err != nil || string(got) != string(want) {
+			t.Errorf("%v: got %s (%v), want %s", ts, got, err, want)
+		}
+	}
+	if _, err := AppendTime(nil, time.Date(-1, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("Expected an error for a year before 0")
+	}
+}
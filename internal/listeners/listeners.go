@@ -0,0 +1,444 @@
+// Package listeners несколько точек приема HTTP-запросов с независимыми
+// настройками: TCP (IPv4/IPv6) с TLS или без, а также unix-сокеты для
+// локальных sidecar-процессов
+package listeners
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Поддерживаемые сети
+const (
+	TCP  = "tcp"
+	TCP4 = "tcp4"
+	TCP6 = "tcp6"
+	Unix = "unix"
+)
+
+// Config настройки одной точки приема
+type Config struct {
+	// Name имя для логов, уникальное среди точек приема
+	Name string
+	// Network tcp, tcp4, tcp6 или unix
+	Network string
+	// Address адрес ":8080", "[::1]:8080" или путь к сокету
+	Address string
+	// TLSCert и TLSKey включают TLS
+	TLSCert string
+	TLSKey  string
+	// SocketMode права на файл unix-сокета; 0 — по umask
+	SocketMode os.FileMode
+	// ReadTimeout, WriteTimeout и IdleTimeout; нулевые значения заменяются общими (WithTimeouts)
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// TLS сообщает, включен ли TLS
+func (c Config) TLS() bool {
+	return c.TLSCert != ""
+}
+
+// String описание точки приема для логов
+func (c Config) String() string {
+	scheme := "http"
+	if c.TLS() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s (%s %s, %s)", c.Name, c.Network, c.Address, scheme)
+}
+
+// WithTimeouts подставляет общие таймауты вместо незаданных
+func (c Config) WithTimeouts(read, write, idle time.Duration) Config {
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = read
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = write
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = idle
+	}
+	return c
+}
+
+// Validate проверяет настройки точки приема
+func (c Config) Validate() error {
+	switch c.Network {
+	case TCP, TCP4, TCP6, Unix:
+	default:
+		return fmt.Errorf("listener %s: unknown network %q", c.Name, c.Network)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("listener %s: address is required", c.Name)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("listener %s: tls_cert and tls_key must be set together", c.Name)
+	}
+	if c.SocketMode != 0 && c.Network != Unix {
+		return fmt.Errorf("listener %s: socket_mode applies only to unix sockets", c.Name)
+	}
+	return nil
+}
+
+// jsonConfig запись LISTENERS: длительности строками, права восьмеричной строкой
+type jsonConfig struct {
+	Name         string `json:"name"`
+	Network      string `json:"network"`
+	Address      string `json:"address"`
+	TLSCert      string `json:"tls_cert"`
+	TLSKey       string `json:"tls_key"`
+	SocketMode   string `json:"socket_mode"`
+	ReadTimeout  string `json:"read_timeout"`
+	WriteTimeout string `json:"write_timeout"`
+	IdleTimeout  string `json:"idle_timeout"`
+}
+
+// Parse разбирает JSON-массив точек приема (значение LISTENERS), например
+// [{"name":"public","network":"tcp","address":":8443","tls_cert":"...","tls_key":"..."},
+// {"name":"sidecar","network":"unix","address":"/run/highload.sock","socket_mode":"0660"}]
+func Parse(raw string) ([]Config, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var list []jsonConfig
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, err
+	}
+
+	configs := make([]Config, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for i, j := range list {
+		c := Config{Name: j.Name, Network: j.Network, Address: j.Address, TLSCert: j.TLSCert, TLSKey: j.TLSKey}
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("listener-%d", i)
+		}
+		if c.Network == "" {
+			c.Network = TCP
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("listener %s is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+
+		if j.SocketMode != "" {
+			mode, err := strconv.ParseUint(j.SocketMode, 8, 32)
+			if err != nil || mode > 0o777 {
+				return nil, fmt.Errorf("listener %s: invalid socket_mode %q", c.Name, j.SocketMode)
+			}
+			c.SocketMode = os.FileMode(mode)
+		}
+		for _, d := range []struct {
+			name  string
+			value string
+			dest  *time.Duration
+		}{
+			{"read_timeout", j.ReadTimeout, &c.ReadTimeout},
+			{"write_timeout", j.WriteTimeout, &c.WriteTimeout},
+			{"idle_timeout", j.IdleTimeout, &c.IdleTimeout},
+		} {
+			if d.value == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.value)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("listener %s: invalid %s %q", c.Name, d.name, d.value)
+			}
+			*d.dest = v
+		}
+
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// Group HTTP-серверы с общим обработчиком на нескольких точках приема
+type Group struct {
+	servers []*server
+	// serving горутины Serve, еще не вернувшиеся из http.Server.Serve
+	serving sync.WaitGroup
+	// closing сокеты закрыты методом Shutdown
+	closing atomic.Bool
+	// inherited унаследованные сокеты по именам точек приема
+	inherited map[string]*os.File
+}
+
+// Option настраивает Group
+type Option func(*Group)
+
+// WithInherited задает сокеты, унаследованные от предыдущего процесса или
+// systemd (socket activation), по именам точек приема. Точка приема с
+// унаследованным сокетом не открывается заново, поэтому соединения, ожидающие
+// в очереди сокета, не теряются
+func WithInherited(files map[string]*os.File) Option {
+	return func(g *Group) {
+		g.inherited = files
+	}
+}
+
+type server struct {
+	cfg Config
+	srv *http.Server
+	ln  net.Listener
+	// open количество открытых соединений
+	open atomic.Int64
+}
+
+// Listen открывает все точки приема. Если какую-то открыть не удалось,
+// уже открытые закрываются
+func Listen(configs []Config, handler http.Handler, opts ...Option) (*Group, error) {
+	g := &Group{}
+	for _, opt := range opts {
+		opt(g)
+	}
+	for _, c := range configs {
+		s, err := listen(c, handler, g.inherited[c.Name])
+		if err != nil {
+			g.close()
+			return nil, fmt.Errorf("listener %s: %w", c.Name, err)
+		}
+		g.servers = append(g.servers, s)
+	}
+	return g, nil
+}
+
+// listen открывает точку приема c или, если inherited не nil, принимает
+// соединения на унаследованном сокете
+func listen(c Config, handler http.Handler, inherited *os.File) (*server, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		IdleTimeout:  c.IdleTimeout,
+	}
+	s := &server{cfg: c, srv: srv}
+	srv.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			s.open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			s.open.Add(-1)
+		}
+	}
+	if c.TLS() {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	if inherited != nil {
+		ln, err := net.FileListener(inherited)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited socket: %w", err)
+		}
+		// net.FileListener дублирует дескриптор
+		inherited.Close()
+		s.ln = ln
+		return s, nil
+	}
+	if c.Network == Unix {
+		if err := removeStaleSocket(c.Address); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(c.Network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	if c.Network == Unix && c.SocketMode != 0 {
+		if err := os.Chmod(c.Address, c.SocketMode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+	s.ln = ln
+	return s, nil
+}
+
+// removeStaleSocket удаляет сокет, оставшийся от аварийно завершенного процесса.
+// Файлы, не являющиеся сокетами, не трогаются
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// Addrs возвращает фактические адреса точек приема (с выбранным портом для ":0")
+func (g *Group) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(g.servers))
+	for i, s := range g.servers {
+		addrs[i] = s.ln.Addr()
+	}
+	return addrs
+}
+
+// Serve обслуживает запросы на всех точках приема до Shutdown и возвращает
+// первую ошибку, отличную от http.ErrServerClosed
+func (g *Group) Serve() error {
+	errs := make(chan error, len(g.servers))
+	g.serving.Add(len(g.servers))
+	for _, s := range g.servers {
+		go func(s *server) {
+			defer g.serving.Done()
+			log.Printf("Server listening on %s", s.cfg)
+			var err error
+			if s.cfg.TLS() {
+				err = s.srv.ServeTLS(s.ln, "", "")
+			} else {
+				err = s.srv.Serve(s.ln)
+			}
+			if errors.Is(err, http.ErrServerClosed) || (g.closing.Load() && errors.Is(err, net.ErrClosed)) {
+				err = nil
+			} else if err != nil {
+				err = fmt.Errorf("listener %s: %w", s.cfg.Name, err)
+			}
+			errs <- err
+		}(s)
+	}
+
+	var first error
+	for range g.servers {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Shutdown закрывает все точки приема и дожидается завершения текущих запросов.
+// http.Server.Shutdown не отвечает на запрос, прочитанный после начала
+// остановки, поэтому сначала закрываются сокеты и отключается keep-alive:
+// принятые соединения получают ответ и закрываются, и только затем серверы
+// останавливаются
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.closing.Store(true)
+	for _, s := range g.servers {
+		s.ln.Close()
+		s.srv.SetKeepAlivesEnabled(false)
+	}
+	// Serve возвращается, когда все принятые соединения учтены в open
+	served := make(chan struct{})
+	go func() {
+		g.serving.Wait()
+		close(served)
+	}()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-served:
+	wait:
+		for g.open() > 0 {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-ticker.C:
+			}
+		}
+	case <-ctx.Done():
+	}
+	return g.shutdown(ctx)
+}
+
+// open количество открытых соединений всех точек приема
+func (g *Group) open() int64 {
+	var n int64
+	for _, s := range g.servers {
+		n += s.open.Load()
+	}
+	return n
+}
+
+// shutdown закрывает серверы параллельно
+func (g *Group) shutdown(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, s := range g.servers {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			if err := s.srv.Shutdown(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("listener %s: %w", s.cfg.Name, err))
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// filer сокет, отдающий копию своего дескриптора (*net.TCPListener, *net.UnixListener)
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Files возвращает копии дескрипторов сокетов и имена точек приема для
+// передачи новому процессу. После этого закрытие точек приема не удаляет
+// файлы unix-сокетов: ими продолжает пользоваться новый процесс
+func (g *Group) Files() ([]*os.File, []string, error) {
+	files := make([]*os.File, 0, len(g.servers))
+	names := make([]string, 0, len(g.servers))
+	for _, s := range g.servers {
+		ln, ok := s.ln.(filer)
+		if !ok {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("listener %s: socket cannot be passed to another process", s.cfg.Name)
+		}
+		f, err := ln.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("listener %s: %w", s.cfg.Name, err)
+		}
+		files = append(files, f)
+		names = append(names, s.cfg.Name)
+	}
+	for _, s := range g.servers {
+		if ln, ok := s.ln.(*net.UnixListener); ok {
+			ln.SetUnlinkOnClose(false)
+		}
+	}
+	return files, names, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func (g *Group) close() {
+	for _, s := range g.servers {
+		s.ln.Close()
+	}
+}
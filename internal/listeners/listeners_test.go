@@ -0,0 +1,168 @@
+package listeners
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	configs, err := Parse(`[
+		{"name": "public", "address": ":8443", "tls_cert": "c.pem", "tls_key": "k.pem", "read_timeout": "5s"},
+		{"name": "sidecar", "network": "unix", "address": "/run/hl.sock", "socket_mode": "0660"}
+	]`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(configs) != 2 || configs[0].Network != TCP || !configs[0].TLS() || configs[0].ReadTimeout != 5*time.Second {
+		t.Fatalf("Unexpected TCP listener %+v", configs)
+	}
+	if configs[1].SocketMode != 0o660 {
+		t.Errorf("Expected socket mode 0660, got %o", configs[1].SocketMode)
+	}
+
+	withDefaults := configs[0].WithTimeouts(time.Second, 2*time.Second, 3*time.Second)
+	if withDefaults.ReadTimeout != 5*time.Second || withDefaults.WriteTimeout != 2*time.Second {
+		t.Errorf("Expected only unset timeouts to be replaced, got %+v", withDefaults)
+	}
+
+	for _, raw := range []string{
+		`[{"network": "udp", "address": ":1"}]`,
+		`[{"network": "tcp"}]`,
+		`[{"address": ":1", "tls_cert": "c.pem"}]`,
+		`[{"address": ":1", "socket_mode": "0600"}]`,
+		`[{"name": "a", "address": ":1"}, {"name": "a", "address": ":2"}]`,
+		`[{"address": ":1", "idle_timeout": "soon"}]`,
+	} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("Expected error for %s", raw)
+		}
+	}
+}
+
+func TestGroup_ServesTCPAndUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "hl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "hl.sock")
+	// A socket left behind by a crashed process must not block startup
+	stale, err := net.Listen(Unix, sock)
+	if err != nil {
+		t.Skipf("unix sockets are not available: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	g, err := Listen([]Config{
+		{Name: "tcp", Network: TCP4, Address: "127.0.0.1:0"},
+		{Name: "sidecar", Network: Unix, Address: sock, SocketMode: 0o600},
+	}, handler)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- g.Serve() }()
+
+	if info, err := os.Stat(sock); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket with mode 0600, got %v (err %v)", info, err)
+	}
+
+	tcpClient := &http.Client{Timeout: time.Second}
+	unixClient := &http.Client{Timeout: time.Second, Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, Unix, sock)
+		},
+	}}
+	for name, get := range map[string]func() (*http.Response, error){
+		"tcp":  func() (*http.Response, error) { return tcpClient.Get("http://" + g.Addrs()[0].String()) },
+		"unix": func() (*http.Response, error) { return unixClient.Get("http://sidecar/") },
+	} {
+		resp, err := get()
+		if err != nil {
+			t.Fatalf("%s request failed: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Errorf("%s: unexpected body %q", name, body)
+		}
+	}
+
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean Serve return, got %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestListen_RefusesToReplaceRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen([]Config{{Name: "sidecar", Network: Unix, Address: path}}, http.NotFoundHandler()); err == nil {
+		t.Fatal("Expected error for existing regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "data" {
+		t.Error("Regular file must not be removed")
+	}
+}
+
+func TestGroup_HandsSocketsOverToAnotherGroup(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "hl.sock")
+	configs := []Config{
+		{Name: "tcp", Network: TCP4, Address: "127.0.0.1:0"},
+		{Name: "sidecar", Network: Unix, Address: sock},
+	}
+	old, err := Listen(configs, http.NotFoundHandler())
+	if err != nil {
+		t.Skipf("unix sockets are not available: %v", err)
+	}
+	addr := old.Addrs()[0].String()
+	files, names, err := old.Files()
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	inherited := make(map[string]*os.File, len(files))
+	for i, f := range files {
+		inherited[names[i]] = f
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "new") })
+	g, err := Listen(configs, handler, WithInherited(inherited))
+	if err != nil {
+		t.Fatalf("Listen with inherited sockets failed: %v", err)
+	}
+	// The old group stops without taking the sockets away from the new one
+	old.close()
+	go g.Serve()
+	defer g.Shutdown(context.Background())
+
+	if got := g.Addrs()[0].String(); got != addr {
+		t.Errorf("Expected the inherited address %s, got %s", addr, got)
+	}
+	resp, err := (&http.Client{Timeout: time.Second}).Get("http://" + addr)
+	if err != nil {
+		t.Fatalf("Request to the inherited socket failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "new" {
+		t.Errorf("Expected the new group to answer, got %q", body)
+	}
+	if _, err := os.Stat(sock); err != nil {
+		t.Errorf("Expected the handed over unix socket to stay, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Package loglevel хранит текущий уровень логирования сервиса,
+// который можно менять на лету через административный API
+package loglevel
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Level уровень логирования
+type Level int32
+
+const (
+	// Debug логируются все запросы
+	Debug Level = iota
+	// Info выборка запросов, а также медленные и ошибочные
+	Info
+	// Warn только медленные и ошибочные запросы
+	Warn
+	// Error только запросы, завершившиеся ошибкой сервера
+	Error
+)
+
+var names = [...]string{"debug", "info", "warn", "error"}
+
+// String возвращает имя уровня
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return names[l]
+}
+
+// Parse разбирает имя уровня без учета регистра
+func Parse(s string) (Level, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(Info))
+}
+
+// Get возвращает текущий уровень
+func Get() Level {
+	return Level(current.Load())
+}
+
+// Set задает текущий уровень
+func Set(l Level) {
+	current.Store(int32(l))
+}
+
+// Enabled сообщает, пишутся ли сообщения уровня l
+func Enabled(l Level) bool {
+	return l >= Get()
+}
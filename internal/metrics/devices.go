@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// OtherDevice значение метки device для устройств сверх лимита
+	OtherDevice = "other"
+	// UnknownDevice значение метки device для метрик без device_id
+	UnknownDevice = "unknown"
+	// DefaultMaxDevices лимит различных значений метки device по умолчанию
+	DefaultMaxDevices = 100
+)
+
+// DeviceMetricsConfig настройки метрик с меткой device
+type DeviceMetricsConfig struct {
+	// Enabled включает метрики с меткой device
+	Enabled bool
+	// MaxDevices лимит различных значений метки device; устройства, впервые
+	// появившиеся после его достижения, учитываются в OtherDevice
+	MaxDevices int
+}
+
+// Validate проверяет настройки
+func (c DeviceMetricsConfig) Validate() error {
+	if c.Enabled && c.MaxDevices < 1 {
+		return fmt.Errorf("device metrics limit must be positive, got %d", c.MaxDevices)
+	}
+	return nil
+}
+
+// DeviceMetrics счетчики и последние z-score по устройствам для дашбордов
+// небольших парков. Число временных рядов ограничено MaxDevices+1 на метрику
+type DeviceMetrics struct {
+	metrics   *prometheus.CounterVec
+	anomalies *prometheus.CounterVec
+	zScore    *prometheus.GaugeVec
+	overflow  prometheus.Counter
+	max       int
+
+	mu      sync.Mutex
+	devices map[string]struct{}
+}
+
+// NewDeviceMetrics создает метрики устройств и регистрирует их в reg
+func NewDeviceMetrics(reg prometheus.Registerer, c DeviceMetricsConfig) (*DeviceMetrics, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	d := &DeviceMetrics{
+		metrics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "highload_device_metrics_total",
+			Help: "Total number of metrics received per device (devices over the limit are counted as \"other\")",
+		}, []string{"device"}),
+		anomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "highload_device_anomalies_total",
+			Help: "Total number of anomalies detected per device (devices over the limit are counted as \"other\")",
+		}, []string{"device"}),
+		zScore: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "highload_device_z_score",
+			Help: "Last z-score per device and metric (cpu, rps); for \"other\" it is the last of any such device",
+		}, []string{"device", "metric"}),
+		overflow: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "highload_device_label_overflow_total",
+			Help: "Metrics of devices over the device label limit, counted as \"other\"",
+		}),
+		max:     c.MaxDevices,
+		devices: make(map[string]struct{}, c.MaxDevices),
+	}
+	for _, collector := range []prometheus.Collector{d.metrics, d.anomalies, d.zScore, d.overflow} {
+		if err := reg.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register device metrics: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// Observe учитывает результат анализа метрики устройства deviceID
+func (d *DeviceMetrics) Observe(deviceID string, zScoreCPU, zScoreRPS float64, anomaly bool) {
+	label := d.label(deviceID)
+	d.metrics.WithLabelValues(label).Inc()
+	if anomaly {
+		d.anomalies.WithLabelValues(label).Inc()
+	}
+	d.zScore.WithLabelValues(label, "cpu").Set(zScoreCPU)
+	d.zScore.WithLabelValues(label, "rps").Set(zScoreRPS)
+}
+
+// label возвращает значение метки device: устройство сохраняет свою метку,
+// если получило ее до достижения лимита
+func (d *DeviceMetrics) label(deviceID string) string {
+	if deviceID == "" {
+		deviceID = UnknownDevice
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.devices[deviceID]; ok {
+		return deviceID
+	}
+	if len(d.devices) >= d.max {
+		d.overflow.Inc()
+		return OtherDevice
+	}
+	d.devices[deviceID] = struct{}{}
+	return deviceID
+}
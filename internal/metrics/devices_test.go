@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDeviceMetrics_CardinalityGuard(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	d, err := NewDeviceMetrics(reg, DeviceMetricsConfig{Enabled: true, MaxDevices: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Observe("sensor-1", 0.5, 0.1, false)
+	d.Observe("sensor-2", 3.2, 0.1, true)
+	// Over the limit: both new devices share the "other" series
+	d.Observe("sensor-3", 1, 1, true)
+	d.Observe("sensor-4", 1, 1, false)
+	// Devices that got a label keep it
+	d.Observe("sensor-1", 0.7, 0.2, false)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]map[string]float64{}
+	for _, f := range families {
+		counts[f.GetName()] = map[string]float64{}
+		for _, m := range f.Metric {
+			device := ""
+			for _, l := range m.Label {
+				if l.GetName() == "device" {
+					device = l.GetValue()
+				}
+			}
+			counts[f.GetName()][device] = m.GetCounter().GetValue()
+		}
+	}
+	want := map[string]float64{"sensor-1": 2, "sensor-2": 1, OtherDevice: 2}
+	for device, n := range want {
+		if got := counts["highload_device_metrics_total"][device]; got != n {
+			t.Errorf("Expected %v metrics for %s, got %v", n, device, got)
+		}
+	}
+	if n := len(counts["highload_device_metrics_total"]); n != 3 {
+		t.Errorf("Expected 3 device series, got %d", n)
+	}
+	if got := counts["highload_device_anomalies_total"][OtherDevice]; got != 1 {
+		t.Errorf("Expected 1 anomaly for other, got %v", got)
+	}
+	if got := counts["highload_device_label_overflow_total"][""]; got != 2 {
+		t.Errorf("Expected 2 overflowed metrics, got %v", got)
+	}
+}
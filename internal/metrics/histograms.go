@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HistogramConfig настройки гистограмм входящих значений CPU и RPS
+type HistogramConfig struct {
+	// CPUBuckets и RPSBuckets верхние границы интервалов, по возрастанию
+	CPUBuckets []float64
+	RPSBuckets []float64
+	// PerRegion добавляет метку region (регионы сверх лимита попадают в "other")
+	PerRegion bool
+}
+
+// DefaultHistogramConfig интервалы CPU по 10% и RPS от 10 до ~20000 с удвоением
+func DefaultHistogramConfig() HistogramConfig {
+	return HistogramConfig{
+		CPUBuckets: prometheus.LinearBuckets(10, 10, 10),
+		RPSBuckets: prometheus.ExponentialBuckets(10, 2, 12),
+	}
+}
+
+// Validate проверяет, что интервалы заданы и границы строго возрастают
+func (c HistogramConfig) Validate() error {
+	for name, buckets := range map[string][]float64{"cpu": c.CPUBuckets, "rps": c.RPSBuckets} {
+		if len(buckets) == 0 {
+			return fmt.Errorf("%s histogram needs at least one bucket", name)
+		}
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				return fmt.Errorf("%s histogram buckets must be strictly increasing, got %v", name, buckets)
+			}
+		}
+	}
+	return nil
+}
+
+// ValueHistograms распределение входящих значений для тепловых карт Grafana
+type ValueHistograms struct {
+	cpu       *prometheus.HistogramVec
+	rps       *prometheus.HistogramVec
+	perRegion bool
+}
+
+// NewValueHistograms создает гистограммы и регистрирует их в reg
+func NewValueHistograms(reg prometheus.Registerer, c HistogramConfig) (*ValueHistograms, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var labels []string
+	if c.PerRegion {
+		labels = []string{"region"}
+	}
+	h := &ValueHistograms{
+		cpu: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "highload_cpu_value",
+			Help:    "Distribution of incoming CPU values",
+			Buckets: c.CPUBuckets,
+		}, labels),
+		rps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "highload_rps_value",
+			Help:    "Distribution of incoming RPS values",
+			Buckets: c.RPSBuckets,
+		}, labels),
+		perRegion: c.PerRegion,
+	}
+	for _, collector := range []prometheus.Collector{h.cpu, h.rps} {
+		if err := reg.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register value histograms: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// Observe учитывает значения метрики; region используется только при PerRegion
+func (h *ValueHistograms) Observe(region string, cpu, rps float64) {
+	var values []string
+	if h.perRegion {
+		values = []string{region}
+	}
+	h.cpu.WithLabelValues(values...).Observe(cpu)
+	h.rps.WithLabelValues(values...).Observe(rps)
+}
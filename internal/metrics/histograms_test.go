@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestValueHistograms_PerRegion(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h, err := NewValueHistograms(reg, HistogramConfig{CPUBuckets: []float64{50, 100}, RPSBuckets: []float64{1000}, PerRegion: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Observe("eu-west", 30, 500)
+	h.Observe("eu-west", 70, 500)
+	h.Observe("us-east", 90, 5000)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "highload_cpu_value" {
+			continue
+		}
+		if len(f.Metric) != 2 {
+			t.Fatalf("Expected one series per region, got %d", len(f.Metric))
+		}
+		eu := f.Metric[0].GetHistogram()
+		if eu.GetSampleCount() != 2 || eu.Bucket[0].GetCumulativeCount() != 1 {
+			t.Errorf("Unexpected eu-west histogram %v", eu)
+		}
+		return
+	}
+	t.Fatal("highload_cpu_value is not registered")
+}
+
+func TestHistogramConfig_Validate(t *testing.T) {
+	if err := DefaultHistogramConfig().Validate(); err != nil {
+		t.Errorf("Default config must be valid: %v", err)
+	}
+	for _, c := range []HistogramConfig{
+		{CPUBuckets: []float64{10}},
+		{CPUBuckets: []float64{10, 10}, RPSBuckets: []float64{1}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", c)
+		}
+	}
+}
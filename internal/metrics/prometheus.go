@@ -27,6 +27,34 @@ var (
 		[]string{"endpoint", "method"},
 	)
 
+	// DuplicateBatches пакеты, отброшенные как повторы уже принятых
+	DuplicateBatches = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "highload_duplicate_batches_total",
+			Help: "Total number of metric batches dropped as duplicates of recently accepted ones",
+		},
+	)
+
+	// Sequencing метрики с номером, пришедшие не по порядку: held — задержаны до
+	// прихода пропущенных, late — опоздали и не анализируются, skipped — пропущенные
+	// номера, которых не дождались, restarted — перезапуски счета устройств
+	Sequencing = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_sequencing_metrics_total",
+			Help: "Total number of sequenced metrics that arrived out of order, by action",
+		},
+		[]string{"action"},
+	)
+
+	// SampledOut метрики, проанализированные, но не сохраненные из-за прореживания
+	SampledOut = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_sampled_out_metrics_total",
+			Help: "Total number of analyzed metrics not persisted due to adaptive sampling",
+		},
+		[]string{"tenant"},
+	)
+
 	// MetricsReceived количество полученных метрик
 	MetricsReceived = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -43,6 +71,15 @@ var (
 		},
 	)
 
+	// AnomalyAlerts оповещения об аномалиях: отправленные и подавленные подтверждением
+	AnomalyAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_anomaly_alerts_total",
+			Help: "Anomaly alerts by outcome (sent, suppressed for acknowledged anomalies)",
+		},
+		[]string{"outcome"},
+	)
+
 	// AnomalyRate скорость обнаружения аномалий
 	AnomalyRate = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -115,6 +152,239 @@ var (
 		},
 	)
 
+	// ConcurrencyRejected запросы, отклоненные из-за ограничения одновременных запросов к маршруту
+	ConcurrencyRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_concurrency_rejected_total",
+			Help: "Total number of requests rejected by per-route concurrency limits",
+		},
+		[]string{"route"},
+	)
+
+	// QuotaRejected запросы, отклоненные из-за исчерпанной квоты
+	QuotaRejected = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "highload_quota_rejected_total",
+			Help: "Total number of requests rejected by API key quotas",
+		},
+	)
+
+	// ProfilesCaptured автоматически снятые наборы профилей по причине срабатывания
+	ProfilesCaptured = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_profiles_captured_total",
+			Help: "Total number of automatic profile captures",
+		},
+		[]string{"reason"},
+	)
+
+	// WorkerScaling изменения количества воркеров анализатора по направлению (up, down) и причине
+	WorkerScaling = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_analyzer_worker_scaling_total",
+			Help: "Analyzer worker pool resizes by direction and reason",
+		},
+		[]string{"direction", "reason"},
+	)
+
+	// WatchdogEvents события сторожа воркеров: worker_died, worker_stuck,
+	// worker_restarted, goroutine_leak
+	WatchdogEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_watchdog_events_total",
+			Help: "Analyzer worker watchdog events by type",
+		},
+		[]string{"event"},
+	)
+
+	// WatchdogStuckWorkers воркеры, зависшие на пачке к последней проверке
+	WatchdogStuckWorkers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "highload_watchdog_stuck_workers",
+			Help: "Analyzer workers found stuck on a batch at the last watchdog check",
+		},
+	)
+
+	// WatchdogGoroutineBaseline базовое количество горутин для поиска утечек
+	WatchdogGoroutineBaseline = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "highload_watchdog_goroutine_baseline",
+			Help: "Goroutine count the watchdog compares against to detect leaks",
+		},
+	)
+
+	// SchedulerJobRuns запуски фоновых задач по результату (success, error, skipped)
+	SchedulerJobRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_scheduler_job_runs_total",
+			Help: "Scheduled job runs by result (skipped on non-leader replicas)",
+		},
+		[]string{"job", "status"},
+	)
+
+	// SchedulerJobDuration длительность выполнения фоновых задач
+	SchedulerJobDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "highload_scheduler_job_duration_seconds",
+			Help:    "Scheduled job duration in seconds",
+			Buckets: []float64{.001, .01, .1, 1, 10, 60, 300},
+		},
+		[]string{"job"},
+	)
+
+	// SchedulerJobLastSuccess время последнего успешного выполнения задачи (Unix)
+	SchedulerJobLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "highload_scheduler_job_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run of a scheduled job",
+		},
+		[]string{"job"},
+	)
+
+	// RegionMetrics количество метрик по регионам устройств
+	RegionMetrics = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_region_metrics_total",
+			Help: "Total number of metrics received per device region",
+		},
+		[]string{"region"},
+	)
+
+	// RegionAnomalies количество аномалий по регионам устройств
+	RegionAnomalies = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_region_anomalies_total",
+			Help: "Total number of anomalies detected per device region",
+		},
+		[]string{"region"},
+	)
+
+	// OutboxEvents события outbox: записанные, доставленные, неудачные, повторенные
+	// и не направленные получателю маршрутами
+	OutboxEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_outbox_events_total",
+			Help: "Outbox anomaly events by sink and outcome (recorded, record_failed, delivered, failed, replayed, not_routed)",
+		},
+		[]string{"sink", "outcome"},
+	)
+
+	// AdmissionDecisions метрики, прошедшие маркерную корзину сразу, после ожидания или отклоненные
+	AdmissionDecisions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_admission_metrics_total",
+			Help: "Metrics passed through ingest admission by outcome (admitted, queued, rejected)",
+		},
+		[]string{"outcome"},
+	)
+
+	// AdmissionWait ожидание маркеров запросами, которые были поставлены в очередь
+	AdmissionWait = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "highload_admission_wait_seconds",
+			Help:    "Time ingest requests waited for admission tokens",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+	)
+
+	// ReportInterval интервалы между метриками одного устройства по времени прихода
+	ReportInterval = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "highload_device_report_interval_seconds",
+			Help:    "Time between consecutive metrics of the same device",
+			Buckets: []float64{1, 5, 10, 15, 30, 60, 120, 300, 900, 3600},
+		},
+	)
+
+	// EventsPublished события внутренней шины по темам
+	EventsPublished = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_events_published_total",
+			Help: "Events published on the in-process event bus by topic",
+		},
+		[]string{"topic"},
+	)
+
+	// EventSubscriberPanics паники подписчиков шины событий; событие остальным
+	// подписчикам доставляется
+	EventSubscriberPanics = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_event_subscriber_panics_total",
+			Help: "Panics recovered from event bus subscribers by topic",
+		},
+		[]string{"topic"},
+	)
+
+	// AdminChanges изменения через административный API по операциям журнала аудита
+	AdminChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_admin_changes_total",
+			Help: "Changes applied through the admin API by audit action",
+		},
+		[]string{"action"},
+	)
+
+	// JournalWrites записи результатов анализа в журнал
+	JournalWrites = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_journal_writes_total",
+			Help: "Analysis results written to the results journal by outcome (appended, failed)",
+		},
+		[]string{"outcome"},
+	)
+
+	// DeadLetters метрики, записанные в очередь недоставленных
+	DeadLetters = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_dead_letters_total",
+			Help: "Metrics routed to the dead-letter queue by reason (validation, analysis, persistence)",
+		},
+		[]string{"reason"},
+	)
+
+	// DeadLetterRequeues повторные обработки записей очереди недоставленных
+	DeadLetterRequeues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_dead_letter_requeues_total",
+			Help: "Dead-letter requeue attempts by outcome (requeued, failed)",
+		},
+		[]string{"outcome"},
+	)
+
+	// DeviceStreams открытые gRPC-потоки устройств
+	DeviceStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "highload_device_streams",
+			Help: "Number of open device gRPC streams",
+		},
+	)
+
+	// DeviceStreamDropped сообщения сервера, пропущенные из-за переполненной очереди потока
+	DeviceStreamDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "highload_device_stream_dropped_total",
+			Help: "Server messages dropped because a device stream queue was full",
+		},
+	)
+
+	// IngestPaused 1, пока прием приостановлен из-за переполнения буферов: потоки
+	// не читаются, HTTP-прием отвечает 429
+	IngestPaused = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "highload_ingest_paused",
+			Help: "1 while ingestion is paused because a buffer crossed its high-water mark (streams stop reading, HTTP returns 429)",
+		},
+	)
+
+	// IngestPauses количество приостановок приема по источнику, превысившему верхнюю границу
+	IngestPauses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "highload_ingest_pauses_total",
+			Help: "Number of stream ingestion pauses by the buffer that crossed its high-water mark",
+		},
+		[]string{"source"},
+	)
+
 	// AnalysisLatency время выполнения анализа
 	AnalysisLatency = promauto.NewHistogram(
 		prometheus.HistogramOpts{
@@ -123,8 +393,75 @@ var (
 			Buckets: []float64{.0001, .0005, .001, .005, .01, .025, .05},
 		},
 	)
+
+	// StageLatency время этапов конвейера приема: decode — на запрос, остальные — на метрику.
+	// Граница 5ms совпадает с бюджетом приема
+	StageLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "highload_ingest_stage_duration_seconds",
+			Help:    "Ingest pipeline stage latency in seconds",
+			Buckets: []float64{.00001, .00005, .0001, .0005, .001, .0025, .005, .01, .05},
+		},
+		[]string{"stage"},
+	)
 )
 
+// RegisterAnalyzerQueue экспортирует глубину и емкость очереди анализатора;
+// значения читаются при каждом сборе метрик
+func RegisterAnalyzerQueue(depth, capacity func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "highload_analyzer_queue_depth",
+		Help: "Metrics waiting in the analyzer queue",
+	}, func() float64 { return float64(depth()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "highload_analyzer_queue_capacity",
+		Help: "Capacity of the analyzer queue",
+	}, func() float64 { return float64(capacity()) })
+}
+
+// RegisterAnalyzerWorkers экспортирует текущее количество воркеров анализатора
+func RegisterAnalyzerWorkers(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "highload_analyzer_workers",
+		Help: "Running analyzer workers",
+	}, func() float64 { return float64(count()) })
+}
+
+// RegisterCounterResets экспортирует количество сбросов полей-счетчиков метрик
+// (перезапусков устройств), замеченных анализатором
+func RegisterCounterResets(resets func() int64) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "highload_counter_resets_total",
+		Help: "Counter field resets detected when converting cumulative values to rates",
+	}, func() float64 { return float64(resets()) })
+}
+
+// RegisterDeviceWindows экспортирует окна детектора по устройствам: количество
+// устройств с окнами, оценку их памяти, вытеснения по причинам reasons и метрики
+// новых устройств, проанализированные по общим окнам из-за лимитов. Значения
+// читаются при каждом сборе метрик
+func RegisterDeviceWindows(devices, bytes, overflow func() int64, evicted func(reason string) int64, reasons ...string) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "highload_device_windows",
+		Help: "Devices with their own detector windows",
+	}, func() float64 { return float64(devices()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "highload_device_windows_bytes",
+		Help: "Estimated memory held by per-device detector windows",
+	}, func() float64 { return float64(bytes()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "highload_device_windows_overflow_total",
+		Help: "Metrics of new devices analyzed against the shared windows because the device window limits were reached",
+	}, func() float64 { return float64(overflow()) })
+	for _, reason := range reasons {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "highload_device_window_evictions_total",
+			Help:        "Per-device detector windows evicted, by reason",
+			ConstLabels: prometheus.Labels{"reason": reason},
+		}, func() float64 { return float64(evicted(reason)) })
+	}
+}
+
 // UpdateAnalysisMetrics обновляет метрики анализа
 func UpdateAnalysisMetrics(avgCPU, avgRPS, zCPU, zRPS float64, isAnomaly bool) {
 	RollingAvgCPU.Set(avgCPU)
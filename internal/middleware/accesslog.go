@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"highload-service/internal/accesslog"
+)
+
+// AccessLog записывает каждый запрос в журнал доступа
+func AccessLog(logger *accesslog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, info := withRequestInfo(r)
+
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rec, r)
+
+			logger.Log(accesslog.Entry{
+				Time:         start,
+				RemoteAddr:   r.RemoteAddr,
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Query:        r.URL.RawQuery,
+				Proto:        r.Proto,
+				Status:       rec.status,
+				RequestBytes: body.n,
+				Bytes:        rec.bytes,
+				Duration:     time.Since(start),
+				UserAgent:    r.UserAgent(),
+				DeviceID:     info.DeviceID(),
+			})
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"highload-service/internal/quota"
+)
+
+// APIKeyAuth пропускает только запросы с одним из ключей keys в заголовке
+// X-API-Key; остальные получают 401. Пути из exempt (точные или, если
+// заканчиваются на /, префиксы) проверяются без ключа: пробы Kubernetes,
+// сбор метрик и административный API со своим токеном
+func APIKeyAuth(keys, exempt []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exempted(r.URL.Path, exempt) || ValidAPIKey(r.Header.Get(quota.APIKeyHeader), keys) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+quota.APIKeyHeader+`"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Missing or invalid API key"})
+		})
+	}
+}
+
+func exempted(path string, exempt []string) bool {
+	for _, p := range exempt {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidAPIKey сравнивает ключ со всеми разрешенными за постоянное время
+func ValidAPIKey(key string, keys []string) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(key), []byte(k))
+	}
+	return key != "" && valid == 1
+}
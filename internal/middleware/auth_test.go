@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	handler := APIKeyAuth([]string{"k1", "k2"}, []string{"/health", "/admin/"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		path, key string
+		want      int
+	}{
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "wrong", http.StatusUnauthorized},
+		{"/metrics", "k2", http.StatusOK},
+		{"/health", "", http.StatusOK},
+		{"/healthz", "", http.StatusUnauthorized},
+		{"/admin/runtime", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s with key %q: expected %d, got %d", tc.path, tc.key, tc.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Имена middleware в цепочке (MIDDLEWARE)
+const (
+	// NameTracing trace-контекст W3C (Tracing)
+	NameTracing = "tracing"
+	// NameAccessLog журнал доступа; действует при ACCESS_LOG_ENABLED
+	NameAccessLog = "access_log"
+	// NameLogging выборочное логирование запросов (RequestLogging)
+	NameLogging = "logging"
+	// NameProfiler снятие профилей; действует при PROFILE_CAPTURE_ENABLED
+	NameProfiler = "profiler"
+	// NameMetrics счетчики запросов Prometheus
+	NameMetrics = "metrics"
+	// NameCORS заголовки CORS и ответы на preflight (CORS)
+	NameCORS = "cors"
+	// NameAuth проверка API-ключа (APIKeyAuth)
+	NameAuth = "auth"
+	// NameQuota квоты по API-ключу на все маршруты, а не только на прием
+	NameQuota = "quota"
+	// NameConcurrency ограничения одновременных запросов по маршрутам
+	NameConcurrency = "concurrency"
+	// NameCompression сжатие ответов gzip (Compress)
+	NameCompression = "compression"
+)
+
+// Names известные имена middleware
+var Names = []string{NameTracing, NameAccessLog, NameLogging, NameProfiler, NameMetrics,
+	NameCORS, NameAuth, NameQuota, NameConcurrency, NameCompression}
+
+// DefaultChain цепочка по умолчанию; совпадает с порядком до появления MIDDLEWARE
+var DefaultChain = []string{NameAccessLog, NameLogging, NameProfiler, NameMetrics, NameConcurrency}
+
+// ParseChain разбирает список имен через запятую (значение MIDDLEWARE).
+// Порядок списка — порядок вызова: первое имя получает запрос первым
+func ParseChain(raw string) ([]string, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known(name) {
+			return nil, fmt.Errorf("unknown middleware %q, expected one of %s", name, strings.Join(Names, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %q is listed twice", name)
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	return chain, nil
+}
+
+func known(name string) bool {
+	for _, n := range Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Factory создает middleware. nil без ошибки — middleware выключено своими
+// настройками и в цепочку не входит
+type Factory func() (func(http.Handler) http.Handler, error)
+
+// Build создает middleware цепочки chain в ее порядке. Фабрики вызываются
+// только для имен из chain, поэтому невключенные middleware не открывают
+// файлы и не запускают горутины. Возвращает также имена вошедших middleware
+func Build(chain []string, factories map[string]Factory) ([]func(http.Handler) http.Handler, []string, error) {
+	var built []func(http.Handler) http.Handler
+	var names []string
+	for _, name := range chain {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("middleware %q is not available", name)
+		}
+		mw, err := factory()
+		if err != nil {
+			return nil, nil, fmt.Errorf("middleware %s: %w", name, err)
+		}
+		if mw != nil {
+			built = append(built, mw)
+			names = append(names, name)
+		}
+	}
+	return built, names, nil
+}
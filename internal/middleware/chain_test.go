@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseChain(t *testing.T) {
+	chain, err := ParseChain(" tracing, cors,auth ,, compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chain, ",") != "tracing,cors,auth,compression" {
+		t.Errorf("Unexpected chain %v", chain)
+	}
+	for _, raw := range []string{"logging,gzip", "logging,metrics,logging"} {
+		if _, err := ParseChain(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+}
+
+func TestBuild_KeepsOrderAndSkipsDisabled(t *testing.T) {
+	var order []string
+	tag := func(name string) Factory {
+		return func() (func(http.Handler) http.Handler, error) {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					order = append(order, name)
+					next.ServeHTTP(w, r)
+				})
+			}, nil
+		}
+	}
+	opened := false
+	factories := map[string]Factory{
+		NameCORS:    tag(NameCORS),
+		NameTracing: tag(NameTracing),
+		NameMetrics: func() (func(http.Handler) http.Handler, error) { return nil, nil },
+		NameAccessLog: func() (func(http.Handler) http.Handler, error) {
+			opened = true
+			return nil, nil
+		},
+	}
+
+	chain, names, err := Build([]string{NameTracing, NameMetrics, NameCORS}, factories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "tracing,cors" {
+		t.Errorf("Expected disabled middleware to be skipped, got %v", names)
+	}
+	if opened {
+		t.Error("Expected factories outside the chain not to be called")
+	}
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Join(order, ",") != "tracing,cors" {
+		t.Errorf("Expected chain order, got %v", order)
+	}
+
+	if _, _, err := Build([]string{NameAuth}, factories); err == nil {
+		t.Error("Expected an error for a middleware without a factory")
+	}
+	factories[NameAuth] = func() (func(http.Handler) http.Handler, error) { return nil, errors.New("no keys") }
+	if _, _, err := Build([]string{NameAuth}, factories); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("Expected the factory error with the middleware name, got %v", err)
+	}
+}
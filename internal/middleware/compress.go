@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipWriters сжимающие writer, переиспользуемые между ответами: новый
+// gzip.Writer выделяет около мегабайта под словарь и таблицы
+var gzipWriters = sync.Pool{New: func() interface{} {
+	return gzip.NewWriter(io.Discard)
+}}
+
+// Compress сжимает ответы gzip для клиентов с Accept-Encoding: gzip.
+// Потоковые ответы (text/event-stream), уже сжатые ответы и ответы без тела
+// передаются как есть; Flush сбрасывает сжатые данные клиенту
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip сообщает, что клиент принимает gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// compressWriter решает, сжимать ли ответ, при записи заголовков
+type compressWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if !c.decided {
+		c.decided = true
+		h := c.Header()
+		if code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK &&
+			h.Get("Content-Encoding") == "" && !strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			c.gz = gzipWriters.Get().(*gzip.Writer)
+			c.gz.Reset(c.ResponseWriter)
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.decided {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.gz != nil {
+		return c.gz.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Flush сбрасывает сжатые данные и буфер соединения
+func (c *compressWriter) Flush() {
+	if c.gz != nil {
+		c.gz.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close дописывает сжатый поток и возвращает writer в пул
+func (c *compressWriter) close() {
+	if c.gz != nil {
+		c.gz.Close()
+		gzipWriters.Put(c.gz)
+		c.gz = nil
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress_GzipsResponsesForAcceptingClients(t *testing.T) {
+	body := strings.Repeat(`{"device_id":"sensor-1","cpu":55}`, 100)
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics/latest", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.Len() >= len(body) {
+		t.Fatalf("Expected a gzipped body, got %v (%d bytes)", rec.Header(), rec.Body.Len())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Errorf("Decompressed body mismatch")
+	}
+
+	for _, accept := range []string{"", "gzip;q=0"} {
+		req.Header.Set("Accept-Encoding", accept)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+			t.Errorf("Accept-Encoding %q: expected an uncompressed body", accept)
+		}
+	}
+}
+
+func TestCompress_LeavesEventStreamsUncompressed(t *testing.T) {
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {}\n\n")
+		w.(http.Flusher).Flush()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/journal", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "data: {}\n\n" || !rec.Flushed {
+		t.Errorf("Expected a flushed plain event stream, got %v %q", rec.Header(), rec.Body.String())
+	}
+}
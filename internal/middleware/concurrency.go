@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/metrics"
+)
+
+// ConcurrencyLimits наибольшее количество одновременно обрабатываемых запросов
+// по шаблону маршрута, например {"/query": 4, "/groups/{id}/stats": 2}
+type ConcurrencyLimits map[string]int
+
+// ParseConcurrencyLimits разбирает JSON-объект ограничений (значение CONCURRENCY_LIMITS)
+func ParseConcurrencyLimits(raw string) (ConcurrencyLimits, error) {
+	limits := ConcurrencyLimits{}
+	if raw == "" {
+		return limits, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &limits); err != nil {
+		return nil, err
+	}
+	for route, n := range limits {
+		if n < 1 {
+			return nil, fmt.Errorf("route %s: limit must be positive, got %d", route, n)
+		}
+	}
+	return limits, nil
+}
+
+// ConcurrencyLimit ограничивает количество одновременно обрабатываемых запросов
+// к маршрутам из limits: запрос сверх ограничения сразу получает 503 с
+// Retry-After, а не ждет в очереди, поэтому тяжелые запросы чтения не отнимают
+// ресурсы у приема метрик. Ограничение действует в пределах одной реплики и
+// не распространяется на маршруты, которых нет в limits
+func ConcurrencyLimit(limits ConcurrencyLimits, retryAfter time.Duration) func(http.Handler) http.Handler {
+	slots := make(map[string]chan struct{}, len(limits))
+	for route, n := range limits {
+		slots[route] = make(chan struct{}, n)
+	}
+	seconds := int64(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, _ := route.GetPathTemplate()
+			slot, ok := slots[template]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			select {
+			case slot <- struct{}{}:
+				defer func() { <-slot }()
+				next.ServeHTTP(w, r)
+			default:
+				metrics.ConcurrencyRejected.WithLabelValues(template).Inc()
+				w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Too many concurrent requests"})
+			}
+		})
+	}
+}
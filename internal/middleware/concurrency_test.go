@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	router := mux.NewRouter()
+	router.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	router.HandleFunc("/groups/{id}/stats", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+	router.Use(ConcurrencyLimit(ConcurrencyLimits{"/query": 2, "/groups/{id}/stats": 1}, 2*time.Second))
+
+	// Two slow queries occupy the route
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query", nil))
+			done <- rec.Code
+		}()
+		<-started
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 503 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Other routes are not affected, and templates match any path parameter
+	for _, path := range []string{"/metrics", "/groups/a/stats"} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected %s to be served, got %d", path, rec.Code)
+		}
+	}
+
+	// Finished requests free their slots
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Expected the running queries to complete, got %d", code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a free slot after the queries completed, got %d", rec.Code)
+	}
+
+	if _, err := ParseConcurrencyLimits(`{"/query": 0}`); err == nil {
+		t.Error("Expected a zero limit to be rejected")
+	}
+}
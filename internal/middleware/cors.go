@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig настройки CORS
+type CORSConfig struct {
+	// AllowedOrigins разрешенные источники; "*" — любой
+	AllowedOrigins []string
+	// AllowedMethods методы, разрешенные в ответе на preflight
+	AllowedMethods []string
+	// AllowedHeaders заголовки, разрешенные в ответе на preflight
+	AllowedHeaders []string
+	// MaxAge сколько браузер может хранить ответ на preflight
+	MaxAge time.Duration
+}
+
+// CORS выставляет заголовки Access-Control-* для разрешенных источников и
+// отвечает 204 на preflight-запросы (OPTIONS с Access-Control-Request-Method),
+// не передавая их дальше. Запросы без Origin и с чужим Origin проходят без
+// заголовков — решение о запрете принимает браузер.
+//
+// Middleware маршрутизатора вызываются только для найденных маршрутов, поэтому
+// для preflight нужен маршрут OPTIONS (например, router.Methods("OPTIONS"))
+func CORS(cfg CORSConfig) func(http.Handler) http.Handler {
+	anyOrigin := false
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[o] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge / time.Second))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			if !anyOrigin && !origins[origin] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset, Traceparent")
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS_AnswersPreflightForAllowedOrigins(t *testing.T) {
+	called := false
+	handler := CORS(CORSConfig{
+		AllowedOrigins: []string{"https://noc.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest(http.MethodOptions, "/metrics", nil)
+	req.Header.Set("Origin", "https://noc.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || called {
+		t.Fatalf("Expected 204 without calling the handler, got %d (called %v)", rec.Code, called)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || rec.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("Unexpected preflight headers %v", rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected a foreign origin to pass without CORS headers, got %v", rec.Header())
+	}
+
+	req.Header.Set("Origin", "https://noc.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://noc.example.com" {
+		t.Errorf("Expected the allowed origin echoed, got %v", rec.Header())
+	}
+}
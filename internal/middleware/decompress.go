@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxExpansion во сколько раз распакованное тело может превышать сжатое:
+	// JSON метрик сжимается в 5–20 раз, а gzip-бомба — в сотни
+	MaxExpansion = 100
+	// expansionAllowance сколько байт распакованного тела читается без проверки
+	// MaxExpansion: небольшие однообразные пакеты сжимаются сильнее
+	expansionAllowance = 1 << 20
+)
+
+// gzipReaders распаковщики, переиспользуемые между запросами
+var gzipReaders sync.Pool
+
+// DecompressRequest прозрачно распаковывает тела запросов с Content-Encoding:
+// gzip (или x-gzip). Некорректный gzip отклоняется с 400, другие кодировки — с
+// 415 и Accept-Encoding: gzip в ответе (RFC 7694). Чтение тела, которое
+// распаковалось больше чем в MaxExpansion раз, завершается *http.MaxBytesError,
+// поэтому gzip-бомба не раздувает память обработчика
+func DecompressRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		switch coding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+		default:
+			w.Header().Set("Accept-Encoding", "gzip")
+			respondDecompressError(w, "Unsupported Content-Encoding "+coding+", expected gzip", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body := &gzipBody{src: &countingReader{ReadCloser: r.Body}}
+		zr, _ := gzipReaders.Get().(*gzip.Reader)
+		var err error
+		if zr == nil {
+			zr, err = gzip.NewReader(body.src)
+		} else {
+			err = zr.Reset(body.src)
+		}
+		if err != nil {
+			respondDecompressError(w, "Invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.zr = zr
+		defer body.release()
+
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondDecompressError отвечает JSON-ошибкой, как обработчики
+func respondDecompressError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// gzipBody распакованное тело запроса. Close закрывает исходное тело;
+// распаковщик возвращается в пул после ответа
+type gzipBody struct {
+	zr *gzip.Reader
+	// src сжатое тело; счетчик его байтов ограничивает распакованное
+	src  *countingReader
+	read int64
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.zr.Read(p)
+	b.read += int64(n)
+	if limit := MaxExpansion * b.src.n; b.read > expansionAllowance && b.read > limit {
+		b.err = &http.MaxBytesError{Limit: limit}
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	return b.src.Close()
+}
+
+// release возвращает распаковщик в пул; дальнейшее чтение тела — ошибка
+func (b *gzipBody) release() {
+	if b.zr == nil {
+		return
+	}
+	b.zr.Close()
+	gzipReaders.Put(b.zr)
+	b.zr = nil
+	if b.err == nil {
+		b.err = http.ErrBodyReadAfterClose
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecompressRequest(t *testing.T) {
+	var got string
+	handler := DecompressRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got = string(data)
+	}))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"cpu":1}`))
+	zw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got != `{"cpu":1}` {
+		t.Errorf("Expected decompressed body, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid gzip, got %d", rec.Code)
+	}
+}
+
+func TestDecompressRequest_RejectsOtherEncodings(t *testing.T) {
+	handler := DecompressRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler must not see an undecodable body")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader("..."))
+	req.Header.Set("Content-Encoding", "br")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType || rec.Header().Get("Accept-Encoding") != "gzip" {
+		t.Errorf("Expected 415 advertising gzip, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestDecompressRequest_StopsGzipBombs(t *testing.T) {
+	var readErr error
+	var got []int
+	handler := DecompressRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		got = append(got, int(n))
+		readErr = err
+	}))
+	send := func(encoding string, body []byte) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/metrics/batch", &buf)
+		req.Header.Set("Content-Encoding", encoding)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Zeros compress about a thousand times: far beyond any metrics batch
+	send("gzip", make([]byte, 16<<20))
+	var tooLarge *http.MaxBytesError
+	if !errors.As(readErr, &tooLarge) || got[0] >= 16<<20 {
+		t.Fatalf("Expected the read cut with MaxBytesError, got %d bytes and %v", got[0], readErr)
+	}
+
+	// A pooled reader serves the next request from the start
+	body := bytes.Repeat([]byte(`{"cpu":1,"rps":2}`), 1000)
+	send("x-gzip", body)
+	if readErr != nil || got[1] != len(body) {
+		t.Errorf("Expected the whole second body, got %d bytes and %v", got[1], readErr)
+	}
+}
@@ -0,0 +1,164 @@
+// Package middleware содержит HTTP middleware общего назначения
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"highload-service/internal/loglevel"
+)
+
+// RequestInfo сведения о запросе, которые обработчики дополняют по ходу обработки
+type RequestInfo struct {
+	mu       sync.Mutex
+	deviceID string
+	traceID  string
+}
+
+type requestInfoKey struct{}
+
+// SetDeviceID запоминает идентификатор устройства для логов запроса.
+// Ничего не делает, если запрос прошел мимо middleware
+func SetDeviceID(r *http.Request, deviceID string) {
+	info, ok := r.Context().Value(requestInfoKey{}).(*RequestInfo)
+	if !ok || deviceID == "" {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.deviceID == "" {
+		info.deviceID = deviceID
+	}
+}
+
+// DeviceID возвращает идентификатор устройства, заданный обработчиком
+func (i *RequestInfo) DeviceID() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.deviceID
+}
+
+// TraceID возвращает идентификатор trace, выданный Tracing
+func (i *RequestInfo) TraceID() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.traceID
+}
+
+// withRequestInfo добавляет RequestInfo в контекст запроса, если его там еще нет
+func withRequestInfo(r *http.Request) (*http.Request, *RequestInfo) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*RequestInfo); ok {
+		return r, info
+	}
+	info := &RequestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// LoggingConfig настройки логирования запросов
+type LoggingConfig struct {
+	// SampleRate доля обычных запросов, попадающих в лог (0..1)
+	SampleRate float64
+	// SlowThreshold запросы дольше порога логируются всегда
+	SlowThreshold time.Duration
+}
+
+// RequestLogging логирует выборку запросов, а также все медленные и завершившиеся ошибкой
+// (статус >= 400) запросы с указанием устройства и размера тела.
+// Объем логов зависит от текущего уровня loglevel: debug пишет все запросы, error только 5xx
+func RequestLogging(cfg LoggingConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, info := withRequestInfo(r)
+
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rec, r)
+
+			elapsed := time.Since(start)
+			reason := ""
+			switch {
+			case rec.status >= 500:
+				reason = "error"
+			case rec.status >= 400 && loglevel.Enabled(loglevel.Warn):
+				reason = "error"
+			case cfg.SlowThreshold > 0 && elapsed > cfg.SlowThreshold && loglevel.Enabled(loglevel.Warn):
+				reason = "slow"
+			case loglevel.Enabled(loglevel.Debug):
+				reason = "debug"
+			case loglevel.Enabled(loglevel.Info) &&
+				(cfg.SampleRate >= 1 || (cfg.SampleRate > 0 && rand.Float64() < cfg.SampleRate)):
+				reason = "sampled"
+			default:
+				return
+			}
+
+			deviceID := info.DeviceID()
+			if deviceID == "" {
+				deviceID = "-"
+			}
+			trace := ""
+			if id := info.TraceID(); id != "" {
+				trace = " trace=" + id
+			}
+			log.Printf("%s %s %d %s device=%s req_bytes=%d resp_bytes=%d%s (%s)",
+				r.Method, r.URL.Path, rec.status, elapsed, deviceID, body.n, rec.bytes, trace, reason)
+		})
+	}
+}
+
+// countingReader считает прочитанные байты тела запроса
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// statusRecorder запоминает код ответа и размер тела
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush пробрасывает сброс буфера для потоковых ответов
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
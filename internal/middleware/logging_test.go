@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestRequestLogging_SkipsUnsampledFastRequests(t *testing.T) {
+	buf := captureLog(t)
+	handler := RequestLogging(LoggingConfig{SampleRate: 0, SlowThreshold: time.Second})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log line, got %q", buf.String())
+	}
+}
+
+func TestRequestLogging_AlwaysLogsErrorsWithDevice(t *testing.T) {
+	buf := captureLog(t)
+	handler := RequestLogging(LoggingConfig{SampleRate: 0})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			SetDeviceID(r, "sensor-7")
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+
+	body := strings.NewReader(`{"cpu":"bad"}`)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/metrics", body))
+
+	line := buf.String()
+	for _, want := range []string{"POST /metrics 400", "device=sensor-7", "req_bytes=13", "(error)"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in log line %q", want, line)
+		}
+	}
+}
+
+func TestRequestLogging_AlwaysLogsSlowRequests(t *testing.T) {
+	buf := captureLog(t)
+	handler := RequestLogging(LoggingConfig{SampleRate: 0, SlowThreshold: time.Millisecond})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+		}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/analyze", nil))
+	if !strings.Contains(buf.String(), "(slow)") {
+		t.Errorf("Expected slow request to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader заголовок trace-контекста W3C Trace Context
+const TraceparentHeader = "Traceparent"
+
+// Tracing продолжает trace из входящего заголовка traceparent или начинает
+// новый и выдает запросу свой span. Идентификатор trace попадает в логи
+// запросов (trace=) и возвращается в заголовке traceparent ответа, чтобы
+// запрос можно было найти по цепочке сервисов
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		traceID, flags, ok := parseTraceparent(r.Header.Get(TraceparentHeader))
+		if !ok {
+			traceID, flags = randomHex(16), "01"
+		}
+		info.mu.Lock()
+		info.traceID = traceID
+		info.mu.Unlock()
+		w.Header().Set(TraceparentHeader, "00-"+traceID+"-"+randomHex(8)+"-"+flags)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TraceID возвращает идентификатор trace запроса; пустой — запрос прошел мимо Tracing
+func TraceID(r *http.Request) string {
+	info, ok := r.Context().Value(requestInfoKey{}).(*RequestInfo)
+	if !ok {
+		return ""
+	}
+	return info.TraceID()
+}
+
+// parseTraceparent разбирает заголовок версии 00: 00-<trace-id>-<parent-id>-<flags>
+func parseTraceparent(v string) (traceID, flags string, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	for _, p := range parts[1:] {
+		if _, err := hex.DecodeString(p); err != nil || strings.ToLower(p) != p {
+			return "", "", false
+		}
+	}
+	// Нулевые идентификаторы недействительны
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
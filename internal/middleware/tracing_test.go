@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracing_ContinuesIncomingTrace(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var seen string
+	handler := Tracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = TraceID(r) }))
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set(TraceparentHeader, incoming)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the incoming trace ID, got %q", seen)
+	}
+	out := rec.Header().Get(TraceparentHeader)
+	if !strings.HasPrefix(out, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || out == incoming {
+		t.Errorf("Expected a new span in the same trace, got %q", out)
+	}
+
+	for _, invalid := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		req.Header.Set(TraceparentHeader, invalid)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if len(seen) != 32 || seen == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%q: expected a fresh trace ID, got %q", invalid, seen)
+		}
+	}
+}
+
+func TestRequestLogging_IncludesTraceID(t *testing.T) {
+	buf := captureLog(t)
+	handler := Tracing(RequestLogging(LoggingConfig{SampleRate: 1})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "trace=4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("Expected the trace ID in the log line, got %q", buf.String())
+	}
+}
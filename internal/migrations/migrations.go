@@ -0,0 +1,199 @@
+// Package migrations выполняет версионированные миграции хранилищ при запуске.
+//
+// Миграции хранилища (раскладка ключей Redis, схема SQL-базы) нумеруются по
+// возрастанию, а номер последней примененной хранится в самом хранилище. При
+// запуске реплика применяет недостающие миграции по порядку под блокировкой,
+// поэтому при обновлении нескольких реплик миграцию выполняет одна из них,
+// а остальные ждут, пока версия хранилища не дойдет до известной им.
+//
+// Миграция должна быть идемпотентной: если реплика остановилась после
+// миграции, но до записи версии, миграция выполнится повторно
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultLockTTL время жизни блокировки миграций; продлевается каждые LockTTL/3
+	DefaultLockTTL = 30 * time.Second
+	// DefaultPollInterval как часто ожидающая реплика проверяет версию хранилища
+	DefaultPollInterval = time.Second
+)
+
+// ErrInvalidMigrations миграции без имени, с неположительной или повторяющейся версией
+var ErrInvalidMigrations = errors.New("invalid migrations")
+
+// Migration миграция хранилища
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+}
+
+// Store версия хранилища: номер последней примененной миграции, 0 — ни одной
+type Store interface {
+	Version(ctx context.Context) (int, error)
+	SetVersion(ctx context.Context, version int) error
+}
+
+// Locker захватывает или продлевает блокировку key для owner (реализуется cache.RedisCache)
+type Locker interface {
+	AcquireLock(key, owner string, ttl time.Duration) (bool, error)
+}
+
+// Option настраивает Runner
+type Option func(*Runner)
+
+// WithLock выполняет миграции под блокировкой key, которую реплика owner
+// удерживает с временем жизни ttl. Без блокировки миграции выполняются сразу,
+// что безопасно только для единственной реплики
+func WithLock(l Locker, key, owner string, ttl time.Duration) Option {
+	return func(r *Runner) {
+		r.locker = l
+		r.lockKey = key
+		r.owner = owner
+		r.lockTTL = ttl
+	}
+}
+
+// WithPollInterval задает период проверки версии при ожидании чужих миграций
+func WithPollInterval(d time.Duration) Option {
+	return func(r *Runner) {
+		r.poll = d
+	}
+}
+
+// Runner применяет миграции одного хранилища
+type Runner struct {
+	name       string
+	store      Store
+	migrations []Migration
+	locker     Locker
+	lockKey    string
+	owner      string
+	lockTTL    time.Duration
+	poll       time.Duration
+}
+
+// New создает Runner для хранилища name (используется в логах) с миграциями list
+func New(name string, store Store, list []Migration, opts ...Option) (*Runner, error) {
+	sorted := append([]Migration(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Name == "" || m.Up == nil {
+			return nil, fmt.Errorf("%w: %s migration %d %q", ErrInvalidMigrations, name, m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("%w: %s migration version %d is used twice", ErrInvalidMigrations, name, m.Version)
+		}
+	}
+
+	r := &Runner{
+		name:       name,
+		store:      store,
+		migrations: sorted,
+		lockTTL:    DefaultLockTTL,
+		poll:       DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// Latest возвращает версию последней известной миграции
+func (r *Runner) Latest() int {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+// Run доводит хранилище до последней известной версии и возвращает итоговую
+// версию. Пока блокировку держит другая реплика, Run ждет, пока та не применит
+// миграции, или отмены контекста. Хранилище новее известных миграций (его уже
+// обновила реплика новой версии) не считается ошибкой
+func (r *Runner) Run(ctx context.Context) (int, error) {
+	for {
+		version, err := r.store.Version(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s schema version: %w", r.name, err)
+		}
+		if version >= r.Latest() {
+			if version > r.Latest() {
+				log.Printf("Migrations: %s is at version %d, newer than known %d", r.name, version, r.Latest())
+			}
+			return version, nil
+		}
+
+		locked := true
+		if r.locker != nil {
+			if locked, err = r.locker.AcquireLock(r.lockKey, r.owner, r.lockTTL); err != nil {
+				return version, err
+			}
+		}
+		if locked {
+			return r.migrate(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return version, fmt.Errorf("waiting for %s migrations by another replica: %w", r.name, ctx.Err())
+		case <-time.After(r.poll):
+		}
+	}
+}
+
+// migrate применяет недостающие миграции, удерживая блокировку
+func (r *Runner) migrate(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if r.locker != nil {
+		go r.holdLock(ctx, cancel)
+	}
+
+	// Версию перечитываем под блокировкой: предыдущий владелец мог успеть ее поднять
+	version, err := r.store.Version(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s schema version: %w", r.name, err)
+	}
+	for _, m := range r.migrations {
+		if m.Version <= version {
+			continue
+		}
+		start := time.Now()
+		if err := m.Up(ctx); err != nil {
+			return version, fmt.Errorf("%s migration %d (%s): %w", r.name, m.Version, m.Name, err)
+		}
+		if err := r.store.SetVersion(ctx, m.Version); err != nil {
+			return version, fmt.Errorf("failed to record %s schema version %d: %w", r.name, m.Version, err)
+		}
+		version = m.Version
+		log.Printf("Migrations: applied %s migration %d (%s) in %s", r.name, m.Version, m.Name, time.Since(start).Round(time.Millisecond))
+	}
+	return version, nil
+}
+
+// holdLock продлевает блокировку, пока выполняются миграции. Потеря блокировки
+// отменяет контекст миграций, чтобы две реплики не мигрировали одновременно
+func (r *Runner) holdLock(ctx context.Context, cancel context.CancelFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.lockTTL / 3):
+		}
+		ok, err := r.locker.AcquireLock(r.lockKey, r.owner, r.lockTTL)
+		if err != nil || !ok {
+			log.Printf("Migrations: lost %s migration lock (err=%v)", r.name, err)
+			cancel()
+			return
+		}
+	}
+}
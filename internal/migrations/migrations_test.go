@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func TestRunner_AppliesPendingMigrationsInOrder(t *testing.T) {
+	mc := cache.NewMemoryCache(clock.Real())
+	store := NewCacheStore(mc, RedisVersionKey)
+
+	var applied []int
+	step := func(v int) Migration {
+		return Migration{Version: v, Name: "step", Up: func(context.Context) error {
+			applied = append(applied, v)
+			return nil
+		}}
+	}
+	failing := errors.New("boom")
+	broken := Migration{Version: 4, Name: "broken", Up: func(context.Context) error { return failing }}
+
+	// Migrations are applied by version, not by declaration order; a failure keeps the last good version
+	runner, err := New("redis", store, []Migration{step(3), step(1), broken, step(2)}, WithLock(mc, RedisLockKey, "node-1", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Run(context.Background()); !errors.Is(err, failing) {
+		t.Fatalf("Expected the migration error, got %v", err)
+	}
+	if v, _ := store.Version(context.Background()); v != 3 || !reflect.DeepEqual(applied, []int{1, 2, 3}) {
+		t.Fatalf("Expected version 3 after migrations 1-3, got %d (%v)", v, applied)
+	}
+
+	// A fixed build continues from the recorded version
+	applied = nil
+	runner, _ = New("redis", store, []Migration{step(1), step(2), step(3), step(4)}, WithLock(mc, RedisLockKey, "node-1", time.Minute))
+	if v, err := runner.Run(context.Background()); err != nil || v != 4 || !reflect.DeepEqual(applied, []int{4}) {
+		t.Errorf("Expected only migration 4 to run, got version %d, %v (%v)", v, applied, err)
+	}
+
+	// An older build does not fail on a newer store
+	runner, _ = New("redis", store, []Migration{step(1)})
+	if v, err := runner.Run(context.Background()); err != nil || v != 4 {
+		t.Errorf("Expected the newer version to be accepted, got %d (%v)", v, err)
+	}
+
+	if _, err := New("redis", store, []Migration{step(1), step(1)}); !errors.Is(err, ErrInvalidMigrations) {
+		t.Errorf("Expected duplicate versions to be rejected, got %v", err)
+	}
+}
+
+func TestRunner_WaitsForAnotherReplica(t *testing.T) {
+	mc := cache.NewMemoryCache(clock.Real())
+	store := NewCacheStore(mc, RedisVersionKey)
+	migration := []Migration{{Version: 1, Name: "noop", Up: func(context.Context) error {
+		t.Error("Expected the waiting replica not to migrate")
+		return nil
+	}}}
+
+	// Another replica holds the lock and finishes its migrations a bit later
+	if ok, _ := mc.AcquireLock(RedisLockKey, "node-1", time.Minute); !ok {
+		t.Fatal("Expected to take the lock")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = store.SetVersion(context.Background(), 1)
+	}()
+
+	runner, _ := New("redis", store, migration, WithLock(mc, RedisLockKey, "node-2", time.Minute), WithPollInterval(10*time.Millisecond))
+	if v, err := runner.Run(context.Background()); err != nil || v != 1 {
+		t.Errorf("Expected to wait for version 1, got %d (%v)", v, err)
+	}
+
+	// A replica that gives up waiting reports the timeout
+	_ = store.SetVersion(context.Background(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := runner.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
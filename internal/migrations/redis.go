@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"log"
+)
+
+const (
+	// RedisVersionKey ключ Redis с версией раскладки ключей
+	RedisVersionKey = "migrations:redis:version"
+	// RedisLockKey ключ блокировки миграций Redis
+	RedisLockKey = "migrations:redis:lock"
+)
+
+// RedisLayout операции над раскладкой ключей Redis, нужные миграциям
+// (реализуется cache.RedisCache)
+type RedisLayout interface {
+	IndexMetricKeys() (int, error)
+}
+
+// Redis миграции раскладки ключей Redis. Номера не переиспользуются:
+// новая миграция всегда добавляется в конец со следующим номером
+func Redis(layout RedisLayout) []Migration {
+	return []Migration{
+		{Version: 1, Name: "index metric keys in metrics:timeline", Up: func(ctx context.Context) error {
+			// Метрики, сохраненные до появления индекса по времени, не видны
+			// GetMetricsRange и не сжимаются в почасовые блоки
+			n, err := layout.IndexMetricKeys()
+			if n > 0 {
+				log.Printf("Migrations: indexed %d metric keys", n)
+			}
+			return err
+		}},
+	}
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"highload-service/internal/cache"
+)
+
+// KVStore хранилище ключ-значение (реализуется cache.Cache)
+type KVStore interface {
+	Get(key string, dest interface{}) error
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+}
+
+// CacheStore хранит версию в ключе key хранилища ключ-значение
+type CacheStore struct {
+	kv  KVStore
+	key string
+}
+
+// NewCacheStore создает CacheStore
+func NewCacheStore(kv KVStore, key string) *CacheStore {
+	return &CacheStore{kv: kv, key: key}
+}
+
+// Version возвращает версию; отсутствие ключа — версия 0
+func (s *CacheStore) Version(ctx context.Context) (int, error) {
+	var version int
+	err := s.kv.Get(s.key, &version)
+	if errors.Is(err, cache.ErrNotFound) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// SetVersion записывает версию без срока жизни
+func (s *CacheStore) SetVersion(ctx context.Context, version int) error {
+	return s.kv.SetWithTTL(s.key, version, 0)
+}
+
+// SQLStore хранит версию в таблице table SQL-базы, создавая ее при первом обращении
+type SQLStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLStore создает SQLStore. table подставляется в запросы как есть и
+// должен быть доверенным именем из конфигурации
+func NewSQLStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{db: db, table: table}
+}
+
+// Version возвращает наибольшую записанную версию
+func (s *SQLStore) Version(ctx context.Context) (int, error) {
+	if _, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+s.table+
+		" (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", s.table, err)
+	}
+	var version sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM "+s.table).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// SetVersion добавляет запись о примененной версии. Версия подставляется
+// литералом: синтаксис параметров запроса у драйверов разный
+func (s *SQLStore) SetVersion(ctx context.Context, version int) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO "+s.table+" (version) VALUES ("+fmt.Sprint(version)+")")
+	return err
+}
+
+// SQL миграция, выполняющая statements в одной транзакции
+func SQL(version int, name string, db *sql.DB, statements ...string) Migration {
+	return Migration{Version: version, Name: name, Up: func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}}
+}
@@ -1,7 +1,18 @@
-// Package models содержит структуры данных для метрик и аналитики
+// Package models содержит структуры данных для метрик и аналитики.
+//
+// Метрики, результаты анализа и ответ пакетной загрузки кодируются и
+// разбираются сгенерированным кодом (AppendJSON, DecodeJSON) без reflect.
+// Сборка с тегом stdjson отключает его, и все значения обрабатывает encoding/json
 package models
 
-import "time"
+//go:generate go run ../../cmd/jsongen -types Metric,MetricsBatch,AnalysisResult,ValueResult,BatchStats,FieldStats -out metrics_json.go
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
 
 // Metric представляет входящую метрику от IoT-устройства или API
 type Metric struct {
@@ -9,6 +20,47 @@ type Metric struct {
 	CPU       float64   `json:"cpu"`
 	RPS       float64   `json:"rps"`
 	DeviceID  string    `json:"device_id,omitempty"`
+	// Region географический регион устройства, например "eu-west"
+	Region string `json:"region,omitempty"`
+	// Values дополнительные именованные показатели (memory, temperature, disk_io...):
+	// каждый анализируется в собственном окне, создаваемом при первом появлении имени
+	Values map[string]float64 `json:"values,omitempty"`
+	// Seq порядковый номер метрики устройства; при включенном упорядочивании
+	// (SEQUENCING_MODE) метрики устройства анализируются по возрастанию номера
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+const (
+	// MaxNamedValues наибольшее количество именованных показателей в метрике
+	MaxNamedValues = 32
+	// MaxValueNameLength наибольшая длина имени показателя
+	MaxValueNameLength = 64
+)
+
+// ErrInvalidMetric метрика содержит недопустимые значения
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// Validate проверяет, что CPU и RPS конечны и неотрицательны
+func (m Metric) Validate() error {
+	if math.IsNaN(m.CPU) || math.IsInf(m.CPU, 0) || m.CPU < 0 {
+		return fmt.Errorf("%w: cpu must be a non-negative number, got %v", ErrInvalidMetric, m.CPU)
+	}
+	if math.IsNaN(m.RPS) || math.IsInf(m.RPS, 0) || m.RPS < 0 {
+		return fmt.Errorf("%w: rps must be a non-negative number, got %v", ErrInvalidMetric, m.RPS)
+	}
+	if len(m.Values) > MaxNamedValues {
+		return fmt.Errorf("%w: at most %d named values are allowed, got %d", ErrInvalidMetric, MaxNamedValues, len(m.Values))
+	}
+	// Именованные показатели могут быть отрицательными (температура), но не NaN и ±Inf
+	for name, v := range m.Values {
+		if name == "" || len(name) > MaxValueNameLength || name == "cpu" || name == "rps" {
+			return fmt.Errorf("%w: invalid value name %q", ErrInvalidMetric, name)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: value %s must be a finite number, got %v", ErrInvalidMetric, name, v)
+		}
+	}
+	return nil
 }
 
 // AnalysisResult содержит результаты аналитики
@@ -21,6 +73,58 @@ type AnalysisResult struct {
 	IsAnomalyCPU    bool      `json:"is_anomaly_cpu"`
 	IsAnomalyRPS    bool      `json:"is_anomaly_rps"`
 	AnomalyDetected bool      `json:"anomaly_detected"`
+	// Episode переход эпизода аномалии на этом событии: opened, ongoing, closed;
+	// пусто вне эпизода. AnomalyDetected означает, что эпизод открыт: при
+	// гистерезисе он открывается после нескольких превышений порога подряд и
+	// остается открытым, пока нормальных событий подряд недостаточно для закрытия
+	Episode string `json:"episode,omitempty"`
+	// Snoozed аномалия найдена, но устройство отложено: AnomalyDetected сброшен,
+	// и аномалия не учитывается и не оповещается
+	Snoozed bool `json:"snoozed,omitempty"`
+	// Seq номер метрики из запроса, если он был задан
+	Seq uint64 `json:"seq,omitempty"`
+	// Sequencing метрика с номером пришла не по порядку: held — задержана до прихода
+	// пропущенных номеров и будет проанализирована позже, late — опоздала и не
+	// анализировалась. Пусто, если метрика проанализирована
+	Sequencing string `json:"sequencing,omitempty"`
+	// WarmingUp окно еще не заполнено до доли прогрева детектора: превышения
+	// порога не открывают эпизод, и AnomalyDetected не выставляется
+	WarmingUp bool `json:"warming_up,omitempty"`
+	// Correlation скользящая корреляция Пирсона CPU и RPS окон метрики;
+	// 0, пока она не определена
+	Correlation float64 `json:"correlation"`
+	// Values результаты анализа именованных показателей метрики
+	Values map[string]ValueResult `json:"values,omitempty"`
+	// BaselineCPU и BaselineRPS статистика, относительно которой посчитаны z-score;
+	// в ответ не входят и служат объяснением в журнале аномалий
+	BaselineCPU Baseline `json:"-"`
+	BaselineRPS Baseline `json:"-"`
+	// Threshold порог |z|, с которым детектор оценивал метрику
+	Threshold float64 `json:"-"`
+}
+
+// Baseline ожидание значения до его добавления в окно: среднее и стандартное
+// отклонение окна (или сезонной базовой линии) и количество значений окна
+type Baseline struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	Count  int     `json:"count"`
+}
+
+// Переходы эпизода аномалии
+const (
+	EpisodeOpened  = "opened"
+	EpisodeOngoing = "ongoing"
+	EpisodeClosed  = "closed"
+)
+
+// ValueResult результат анализа именованного показателя
+type ValueResult struct {
+	RollingAvg float64 `json:"rolling_avg"`
+	ZScore     float64 `json:"z_score"`
+	IsAnomaly  bool    `json:"is_anomaly"`
+	// Baseline статистика окна показателя до добавления значения
+	Baseline Baseline `json:"-"`
 }
 
 // MetricsBatch представляет пакет метрик для массовой загрузки
@@ -28,12 +132,77 @@ type MetricsBatch struct {
 	Metrics []Metric `json:"metrics"`
 }
 
+// BatchStats статистика принятых метрик пакета для ответа пакетной загрузки
+type BatchStats struct {
+	CPU FieldStats `json:"cpu"`
+	RPS FieldStats `json:"rps"`
+	// Values статистика именованных показателей
+	Values map[string]FieldStats `json:"values,omitempty"`
+	// AnomalyIndices позиции аномальных метрик в пакете, с нуля
+	AnomalyIndices []int `json:"anomaly_indices"`
+}
+
+// FieldStats статистика одного поля метрик пакета
+type FieldStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"std_dev"`
+}
+
+// BatchResponse ответ пакетной загрузки. Обработчик пишет его по частям, по
+// мере анализа; тип нужен кодекам, которые кодируют и разбирают ответ целиком
+type BatchResponse struct {
+	Results        []AnalysisResult `json:"results"`
+	Processed      int              `json:"processed"`
+	Rejected       int              `json:"rejected"`
+	AnomaliesFound int              `json:"anomalies_found"`
+	Stats          BatchStats       `json:"stats"`
+	// Duplicate пакет уже принят (дедупликация) и не анализировался
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// AsyncAccepted ответ 202 на асинхронный прием
+type AsyncAccepted struct {
+	// IngestID идентификатор приема для поиска в журналах клиента и сервиса
+	IngestID string `json:"ingest_id"`
+	// Queued метрики, поставленные в очередь анализатора
+	Queued int `json:"queued"`
+	// Rejected метрики, не прошедшие проверку или не поместившиеся в очередь;
+	// они записаны в очередь недоставленных
+	Rejected int `json:"rejected"`
+}
+
 // HealthStatus представляет статус здоровья сервиса
 type HealthStatus struct {
+	// Status healthy, degraded, unhealthy или draining
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 	Redis     string    `json:"redis"`
 	Uptime    string    `json:"uptime"`
+	// Reasons причины ухудшения, худшие первыми
+	Reasons []HealthReason `json:"reasons"`
+}
+
+// HealthReason причина ухудшения здоровья компонента
+type HealthReason struct {
+	Component string `json:"component"`
+	// Code машиночитаемый код причины
+	Code    string `json:"code"`
+	Level   string `json:"level"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessStatus представляет готовность сервиса принимать трафик
+type ReadinessStatus struct {
+	Ready           bool   `json:"ready"`
+	Status          string `json:"status"`
+	Samples         int64  `json:"samples"`
+	RequiredSamples int64  `json:"required_samples"`
+	Elapsed         string `json:"elapsed"`
+	RequiredElapsed string `json:"required_elapsed"`
+	Restored        bool   `json:"restored"`
 }
 
 // StatsResponse содержит статистику сервиса
@@ -42,4 +211,69 @@ type StatsResponse struct {
 	AnomaliesCount   int64   `json:"anomalies_count"`
 	CurrentRPS       float64 `json:"current_rps"`
 	AverageLatencyMs float64 `json:"average_latency_ms"`
+	// DuplicateBatches пакеты, отброшенные как повторы
+	DuplicateBatches int64 `json:"duplicate_batches"`
+	// Correlation скользящая корреляция Пирсона CPU и RPS общих окон; nil, пока она не определена
+	Correlation *float64 `json:"correlation,omitempty"`
+	// Snoozes устройства, аномалии которых сейчас не учитываются
+	Snoozes []Snooze `json:"snoozes,omitempty"`
+	// Availability доступность сервиса; nil, если учет недоступен
+	Availability *Availability `json:"availability,omitempty"`
+}
+
+// Availability доступность сервиса за текущие сутки и месяц (UTC)
+type Availability struct {
+	// Since начало учета: первый запуск сервиса с учетом доступности
+	Since   time.Time          `json:"since"`
+	Daily   AvailabilityPeriod `json:"daily"`
+	Monthly AvailabilityPeriod `json:"monthly"`
+}
+
+// AvailabilityPeriod доступность с From до текущего момента
+type AvailabilityPeriod struct {
+	From time.Time `json:"from"`
+	// Percent доля минут, в которые хотя бы одна реплика была готова принимать трафик
+	Percent float64 `json:"percent"`
+	// Downtime суммарное время недоступности
+	Downtime string `json:"downtime"`
+}
+
+// DeviceState накопительные показатели устройства
+type DeviceState struct {
+	DeviceID string `json:"device_id"`
+	// Metrics количество проанализированных метрик устройства
+	Metrics int64 `json:"metrics"`
+	// Anomalies количество метрик с обнаруженной аномалией
+	Anomalies int64     `json:"anomalies"`
+	LastCPU   float64   `json:"last_cpu"`
+	LastRPS   float64   `json:"last_rps"`
+	LastSeen  time.Time `json:"last_seen"`
+	// LastAnomalyAt время последней метрики с аномалией
+	LastAnomalyAt *time.Time `json:"last_anomaly_at,omitempty"`
+}
+
+// Snooze отложение аномалий устройства до Until
+type Snooze struct {
+	DeviceID string    `json:"device_id"`
+	Until    time.Time `json:"until"`
+}
+
+// SeriesPoint агрегат значений метрики за один интервал графика
+type SeriesPoint struct {
+	Time  time.Time `json:"time"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count int64     `json:"count"`
+	// Anomalies количество метрик интервала с найденной аномалией
+	Anomalies int64 `json:"anomalies"`
+}
+
+// SeriesResponse прореженный ряд для построения графика
+type SeriesResponse struct {
+	Metric     string        `json:"metric"`
+	Resolution string        `json:"resolution"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Points     []SeriesPoint `json:"points"`
 }
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// generated reports whether the package is built with generated JSON code;
+// under the stdjson tag the comparisons below have nothing to compare
+func generated(t testing.TB) {
+	if _, ok, _ := AppendJSON(nil, &FieldStats{}); !ok {
+		t.Skip("Built with the stdjson tag")
+	}
+}
+
+func TestAppendJSON_MatchesEncodingJSON(t *testing.T) {
+	generated(t)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 600700, time.FixedZone("", 3*3600))
+	values := []interface{}{
+		Metric{},
+		&Metric{Timestamp: ts, CPU: 45.5, RPS: 1e21, DeviceID: "<sensor&1>\u2028\"\\\x01\t é \xff", Region: "eu",
+			Values: map[string]float64{"temp": -1e-7, "mem": 0.000001, "disk": 123456789.125}, Seq: math.MaxUint64},
+		AnalysisResult{Timestamp: ts, RollingAvgCPU: 1.5, ZScoreCPU: -3.25, AnomalyDetected: true, Episode: EpisodeOpened,
+			Snoozed: true, Seq: 7, Sequencing: "held", WarmingUp: true, Correlation: 0.5,
+			Values:      map[string]ValueResult{"temp": {RollingAvg: 20, ZScore: 1, IsAnomaly: true}, "b": {}},
+			BaselineCPU: Baseline{Mean: 1}},
+		MetricsBatch{},
+		MetricsBatch{Metrics: []Metric{}},
+		MetricsBatch{Metrics: []Metric{{CPU: 1}, {DeviceID: "d", Values: map[string]float64{}}}},
+		BatchStats{},
+		BatchStats{CPU: FieldStats{Count: -2, Mean: 1e-300, Max: math.MaxFloat64}, AnomalyIndices: []int{},
+			Values: map[string]FieldStats{"temp": {Count: 1}}},
+		BatchStats{AnomalyIndices: []int{0, 5}},
+		(*Metric)(nil),
+	}
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := AppendJSON([]byte("prefix"), v)
+		if !ok || err != nil {
+			t.Fatalf("%T: ok %v, err %v", v, ok, err)
+		}
+		if string(got) != "prefix"+string(want) {
+			t.Errorf("%T:\n got %s\nwant %s", v, got[len("prefix"):], want)
+		}
+	}
+}
+
+func TestAppendJSON_RejectsWhatEncodingJSONRejects(t *testing.T) {
+	generated(t)
+	for _, v := range []interface{}{
+		Metric{CPU: math.NaN()},
+		AnalysisResult{Values: map[string]ValueResult{"temp": {ZScore: math.Inf(1)}}},
+		Metric{Timestamp: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if _, err := json.Marshal(v); err == nil {
+			t.Fatalf("%+v: expected encoding/json to fail", v)
+		}
+		if _, _, err := AppendJSON(nil, v); err == nil {
+			t.Errorf("%+v: expected an error", v)
+		}
+	}
+	if _, ok, _ := AppendJSON(nil, HealthStatus{}); ok {
+		t.Error("Expected types without generated code to be left to encoding/json")
+	}
+}
+
+// decodeSamples are documents both decoders must read the same way
+var decodeSamples = []string{
+	`{"timestamp":"2024-01-01T12:00:00.5+03:00","cpu":45.5,"rps":500,"device_id":"sensor-1","region":"eu","values":{"temp":-1.5e3},"seq":18446744073709551615}`,
+	` { "CPU" : 1 , "Device_ID" : "x" , "RPS" : 2E-2 } `,
+	`{"cpu":1,"cpu":2,"unknown":{"a":[1,{"b":null},"\u00e9"],"c":true},"extra":[]}`,
+	`{"device_id":"\"\\\/\b\f\n\r\t\u00e9\ud83d\ude00\ud800x\u2028","region":"é\xff"}`,
+	`{"timestamp":null,"cpu":null,"values":null,"device_id":null,"seq":null}`,
+	`{"values":{},"seq":0,"cpu":-0}`,
+	`null`,
+	`{}`,
+	`{"metrics":[{"cpu":1},null,{},{"values":{"a":1,"a":2}}]}`,
+	`{"metrics":[]}`,
+	`{"metrics":null}`,
+	`{"Metrics":[{"cpu":3}]}`,
+}
+
+// rejectSamples are documents both decoders must reject
+var rejectSamples = []string{
+	``,
+	`{`,
+	`{"cpu":}`,
+	`{"cpu":1,}`,
+	`{"cpu":"1"}`,
+	`{"seq":-1}`,
+	`{"seq":1.5}`,
+	`{"cpu":1e400}`,
+	`{} x`,
+	`[1]`,
+	`{"cpu":01}`,
+	`{"cpu":-}`,
+	`{"cpu":1.}`,
+	`{"timestamp":"yesterday"}`,
+	`{"timestamp":1}`,
+	"{\"device_id\":\"a\x01\"}",
+	`{"device_id":"\x"}`,
+	`{"device_id":"\u12"}`,
+	`{"values":[1]}`,
+	`{"values":{"a":"1"}}`,
+	`{"metrics":{}}`,
+	`{"metrics":[1]}`,
+	`{"cpu":tru}`,
+	`{cpu:1}`,
+	`{"cpu" 1}`,
+}
+
+func TestDecodeJSON_MatchesEncodingJSON(t *testing.T) {
+	generated(t)
+	for _, doc := range decodeSamples {
+		for _, target := range []func() interface{}{
+			func() interface{} { return new(Metric) },
+			func() interface{} { return new(MetricsBatch) },
+		} {
+			want, got := target(), target()
+			wantErr := json.Unmarshal([]byte(doc), want)
+			ok, err := DecodeJSON([]byte(doc), got)
+			if !ok {
+				t.Fatalf("%T: expected generated decoding", got)
+			}
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("%s into %T: error %v, encoding/json error %v", doc, got, err, wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s into %T:\n got %+v\nwant %+v", doc, got, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeJSON_RejectsWhatEncodingJSONRejects(t *testing.T) {
+	generated(t)
+	for _, doc := range rejectSamples {
+		if err := json.Unmarshal([]byte(doc), new(Metric)); err == nil {
+			if err := json.Unmarshal([]byte(doc), new(MetricsBatch)); err == nil {
+				t.Fatalf("%q: expected encoding/json to fail", doc)
+			}
+			if _, err := DecodeJSON([]byte(doc), new(MetricsBatch)); err == nil {
+				t.Errorf("%q: expected an error for a batch", doc)
+			}
+			continue
+		}
+		if _, err := DecodeJSON([]byte(doc), new(Metric)); err == nil {
+			t.Errorf("%q: expected an error", doc)
+		}
+	}
+
+	// Type errors read like those of encoding/json
+	_, err := DecodeJSON([]byte(`{"cpu":"1"}`), new(Metric))
+	if want := json.Unmarshal([]byte(`{"cpu":"1"}`), new(Metric)); err == nil || err.Error() != want.Error() {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+}
+
+func TestDecodeJSON_ReusesBatchCapacity(t *testing.T) {
+	generated(t)
+	batch := MetricsBatch{Metrics: make([]Metric, 0, 4)}
+	backing := &batch.Metrics[:1][0]
+	if _, err := DecodeJSON([]byte(`{"metrics":[{"cpu":1},{"cpu":2}]}`), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Metrics) != 2 || &batch.Metrics[0] != backing {
+		t.Errorf("Expected metrics decoded into the existing backing array, got %+v", batch.Metrics)
+	}
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	for _, doc := range append(decodeSamples, rejectSamples...) {
+		f.Add([]byte(doc))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		generated(t)
+		for _, target := range []func() interface{}{
+			func() interface{} { return new(Metric) },
+			func() interface{} { return new(MetricsBatch) },
+		} {
+			want, got := target(), target()
+			wantErr := json.Unmarshal(data, want)
+			_, err := DecodeJSON(data, got)
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("%q into %T: error %v, encoding/json error %v", data, got, err, wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q into %T:\n got %+v\nwant %+v", data, got, got, want)
+			}
+		}
+	})
+}
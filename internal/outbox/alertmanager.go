@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"highload-service/internal/anomalies"
+)
+
+// Форматы тела webhook-запроса (OUTBOX_FORMATS)
+const (
+	// FormatJSON событие Event в JSON
+	FormatJSON = "json"
+	// FormatAlertmanager оповещение API Alertmanager v2 (POST /api/v2/alerts)
+	FormatAlertmanager = "alertmanager"
+)
+
+// AlertName значение метки alertname оповещений Alertmanager
+const AlertName = "HighloadAnomaly"
+
+// Alert оповещение API Alertmanager v2. Alertmanager узнает оповещение по
+// меткам, поэтому повторная доставка события at-least-once его не дублирует
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	// EndsAt время закрытия аномалии; у открытой не задается, и Alertmanager
+	// закрывает оповещение сам по resolve_timeout
+	EndsAt *time.Time `json:"endsAt,omitempty"`
+}
+
+// NewAlert описывает событие оповещением Alertmanager: метки alertname,
+// severity, device_id, group и anomaly_id (пустые опускаются), в аннотациях —
+// сводка, z-score и идентификатор события
+func NewAlert(e Event) Alert {
+	a := e.Anomaly
+	labels := map[string]string{
+		"alertname":  AlertName,
+		"anomaly_id": a.ID,
+	}
+	if e.Severity != "" {
+		labels["severity"] = e.Severity
+	}
+	if a.DeviceID != "" {
+		labels["device_id"] = a.DeviceID
+	}
+	if a.Group != "" {
+		labels["group"] = a.Group
+	}
+
+	subject := "device " + a.DeviceID
+	if a.DeviceID == "" && a.Group != "" {
+		subject = "group " + a.Group
+	}
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary": "Anomaly on " + subject,
+			"description": fmt.Sprintf("CPU z-score %.2f, RPS z-score %.2f, %d occurrences since %s",
+				a.ZScoreCPU, a.ZScoreRPS, a.Occurrences, a.FirstSeen.UTC().Format(time.RFC3339)),
+			"z_score_cpu": strconv.FormatFloat(a.ZScoreCPU, 'f', 2, 64),
+			"z_score_rps": strconv.FormatFloat(a.ZScoreRPS, 'f', 2, 64),
+			"occurrences": strconv.FormatInt(a.Occurrences, 10),
+			"event_id":    e.ID,
+		},
+		StartsAt: a.FirstSeen.UTC(),
+	}
+	if a.State == anomalies.Resolved && a.ResolvedAt != nil {
+		ends := a.ResolvedAt.UTC()
+		alert.EndsAt = &ends
+	}
+	return alert
+}
+
+// ParseFormats разбирает JSON-объект "получатель": формат (значение OUTBOX_FORMATS)
+func ParseFormats(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var formats map[string]string
+	if err := json.Unmarshal([]byte(raw), &formats); err != nil {
+		return nil, err
+	}
+	for name, format := range formats {
+		if format != FormatJSON && format != FormatAlertmanager {
+			return nil, fmt.Errorf("webhook %q: format must be %q or %q, got %q", name, FormatJSON, FormatAlertmanager, format)
+		}
+	}
+	return formats, nil
+}
@@ -0,0 +1,295 @@
+// Package outbox доставка событий об аномалиях через журнал (transactional outbox).
+//
+// Событие сначала записывается в поток Redis и только потом доставляется.
+// У каждого получателя своя группа потребителей: событие подтверждается после
+// успешной доставки, а неподтвержденные события (ошибка получателя, падение
+// процесса) через RetryAfter забирает любая реплика. Доставка at-least-once,
+// поэтому получатели должны устранять дубликаты по идентификатору события
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+const (
+	// Stream поток событий об аномалиях
+	Stream = "outbox:anomalies"
+	// MaxLen примерное количество хранимых событий
+	MaxLen = 100000
+	// DefaultRetryAfter через сколько неподтвержденное событие доставляется повторно
+	DefaultRetryAfter = 30 * time.Second
+	// DefaultPollInterval пауза между опросами пустого потока
+	DefaultPollInterval = 500 * time.Millisecond
+	// batchSize количество событий, читаемых за раз
+	batchSize = 100
+	// MaxReplay ограничение числа событий в одном повторе
+	MaxReplay = 1000
+)
+
+var (
+	// ErrUnknownSink получатель с таким именем не настроен
+	ErrUnknownSink = errors.New("unknown outbox sink")
+	errMalformed   = errors.New("malformed outbox event")
+	// errNotRouted событие не направлено получателю: оно подтверждается без доставки
+	errNotRouted = errors.New("event is not routed to sink")
+)
+
+// Log журнал событий с группами потребителей (реализуется cache.RedisCache и cache.MemoryCache)
+type Log interface {
+	AppendStream(stream string, data []byte, maxLen int64) (string, error)
+	ReadStreamGroup(stream, group, consumer string, count int64) ([]cache.StreamEntry, error)
+	ClaimStream(stream, group, consumer string, minIdle time.Duration, count int64) ([]cache.StreamEntry, error)
+	AckStream(stream, group string, ids ...string) error
+	RangeStream(stream, start string, count int64) ([]cache.StreamEntry, error)
+}
+
+// Event событие об аномалии
+type Event struct {
+	// ID идентификатор записи в журнале; по нему получатели устраняют дубликаты
+	ID         string            `json:"id"`
+	RecordedAt time.Time         `json:"recorded_at"`
+	Anomaly    anomalies.Anomaly `json:"anomaly"`
+	// Severity тяжесть по маршрутам outbox; вычисляется при доставке и доступна шаблонам
+	Severity string `json:"-"`
+}
+
+// Sink получатель событий
+type Sink interface {
+	// Name имя получателя; используется как имя группы потребителей
+	Name() string
+	Deliver(ctx context.Context, e Event) error
+}
+
+// Option настраивает Outbox
+type Option func(*Outbox)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(o *Outbox) {
+		o.clock = c
+	}
+}
+
+// WithRetryAfter задает, через сколько неподтвержденное событие доставляется повторно
+func WithRetryAfter(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.retryAfter = d
+	}
+}
+
+// WithPollInterval задает паузу между опросами пустого потока
+func WithPollInterval(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.pollInterval = d
+	}
+}
+
+// WithRouter задает маршруты: получатель получает только направленные ему события
+func WithRouter(r *Router) Option {
+	return func(o *Outbox) {
+		o.router = r
+	}
+}
+
+// Outbox записывает события и доставляет их получателям
+type Outbox struct {
+	log          Log
+	consumer     string
+	sinks        map[string]Sink
+	router       *Router
+	clock        clock.Clock
+	retryAfter   time.Duration
+	pollInterval time.Duration
+
+	mu sync.Mutex
+	// failures количество подряд неудачных доставок по получателям
+	failures map[string]int
+}
+
+// New создает outbox поверх журнала log; consumer — имя экземпляра в группах потребителей
+func New(log Log, consumer string, sinks []Sink, opts ...Option) *Outbox {
+	o := &Outbox{
+		log:          log,
+		consumer:     consumer,
+		sinks:        make(map[string]Sink, len(sinks)),
+		failures:     make(map[string]int, len(sinks)),
+		clock:        clock.Real(),
+		retryAfter:   DefaultRetryAfter,
+		pollInterval: DefaultPollInterval,
+	}
+	for _, s := range sinks {
+		o.sinks[s.Name()] = s
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Record записывает аномалию в журнал. Подходит как anomalies.WithNotifier
+func (o *Outbox) Record(a anomalies.Anomaly) {
+	data, err := json.Marshal(Event{RecordedAt: o.clock.Now().UTC(), Anomaly: a})
+	if err == nil {
+		_, err = o.log.AppendStream(Stream, data, MaxLen)
+	}
+	if err != nil {
+		metrics.OutboxEvents.WithLabelValues("", "record_failed").Inc()
+		log.Printf("Failed to record anomaly %s in outbox: %v", a.ID, err)
+		return
+	}
+	metrics.OutboxEvents.WithLabelValues("", "recorded").Inc()
+}
+
+// Run доставляет события всем получателям до отмены ctx
+func (o *Outbox) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for _, s := range o.sinks {
+		go func(s Sink) {
+			o.deliverLoop(ctx, s)
+			done <- struct{}{}
+		}(s)
+	}
+	for range o.sinks {
+		<-done
+	}
+}
+
+// deliverLoop доставляет события получателю s, делая паузу, когда журнал пуст
+func (o *Outbox) deliverLoop(ctx context.Context, s Sink) {
+	for ctx.Err() == nil {
+		if o.poll(ctx, s) > 0 {
+			continue
+		}
+		timer := time.NewTimer(o.pollInterval)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// poll сначала забирает зависшие события группы, затем читает новые, и возвращает
+// количество обработанных записей
+func (o *Outbox) poll(ctx context.Context, s Sink) int {
+	entries, err := o.log.ClaimStream(Stream, s.Name(), o.consumer, o.retryAfter, batchSize)
+	if err == nil && len(entries) == 0 {
+		entries, err = o.log.ReadStreamGroup(Stream, s.Name(), o.consumer, batchSize)
+	}
+	if err != nil {
+		log.Printf("Outbox %s: %v", s.Name(), err)
+	}
+
+	for _, entry := range entries {
+		err := o.deliver(ctx, s, entry)
+		switch {
+		case errors.Is(err, errNotRouted):
+			metrics.OutboxEvents.WithLabelValues(s.Name(), "not_routed").Inc()
+		case errors.Is(err, errMalformed):
+			// Испорченная запись не станет корректной при повторе
+			log.Printf("Outbox %s: dropping event %s: %v", s.Name(), entry.ID, err)
+		case err != nil:
+			log.Printf("Outbox %s: delivery of event %s failed, retrying in %s: %v", s.Name(), entry.ID, o.retryAfter, err)
+			continue
+		default:
+			metrics.OutboxEvents.WithLabelValues(s.Name(), "delivered").Inc()
+		}
+		if err := o.log.AckStream(Stream, s.Name(), entry.ID); err != nil {
+			log.Printf("Outbox %s: failed to ack event %s: %v", s.Name(), entry.ID, err)
+		}
+	}
+	return len(entries)
+}
+
+// deliver разбирает запись журнала и доставляет ее получателю, если маршруты
+// направляют событие ему. Маршруты проверяются при доставке, поэтому их
+// изменение действует и на еще не доставленные события
+func (o *Outbox) deliver(ctx context.Context, s Sink, entry cache.StreamEntry) error {
+	var e Event
+	if err := json.Unmarshal(entry.Data, &e); err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+	e.ID = entry.ID
+	e.Severity = o.router.Severity(e.Anomaly)
+	if !o.router.Routed(s.Name(), e.Anomaly) {
+		return errNotRouted
+	}
+	err := s.Deliver(ctx, e)
+	o.mu.Lock()
+	if err != nil {
+		o.failures[s.Name()]++
+	} else {
+		delete(o.failures, s.Name())
+	}
+	o.mu.Unlock()
+	if err != nil {
+		metrics.OutboxEvents.WithLabelValues(s.Name(), "failed").Inc()
+		return err
+	}
+	return nil
+}
+
+// Failures возвращает количество подряд неудачных доставок получателям, которые
+// сейчас не принимают события. Подходит для health.SinkFailures
+func (o *Outbox) Failures() map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	failing := make(map[string]int, len(o.failures))
+	for name, n := range o.failures {
+		failing[name] = n
+	}
+	return failing
+}
+
+// Replay повторно доставляет получателю sink события, начиная с идентификатора from
+// ("-" — с начала журнала), и возвращает количество доставленных. События, которые
+// маршруты не направляют получателю, пропускаются. Повтор не меняет позицию группы
+// получателя
+func (o *Outbox) Replay(ctx context.Context, sink, from string) (int, error) {
+	s, ok := o.sinks[sink]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownSink, sink)
+	}
+	entries, err := o.log.RangeStream(Stream, from, MaxReplay)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		err := o.deliver(ctx, s, entry)
+		if errors.Is(err, errNotRouted) {
+			continue
+		}
+		if err != nil {
+			return delivered, fmt.Errorf("replay stopped at event %s: %w", entry.ID, err)
+		}
+		metrics.OutboxEvents.WithLabelValues(s.Name(), "replayed").Inc()
+		delivered++
+	}
+	return delivered, nil
+}
+
+// Sinks возвращает отсортированные имена получателей
+func (o *Outbox) Sinks() []string {
+	names := make([]string, 0, len(o.sinks))
+	for name := range o.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,274 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/devices"
+)
+
+// fakeSink records delivered events and fails the first failures deliveries
+type fakeSink struct {
+	failures  int
+	delivered []Event
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Deliver(_ context.Context, e Event) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.delivered = append(s.delivered, e)
+	return nil
+}
+
+func TestOutbox_RedeliversAfterFailure(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	sink := &fakeSink{failures: 1}
+	o := New(cache.NewMemoryCache(clk), "node-1", []Sink{sink}, WithClock(clk), WithRetryAfter(time.Minute))
+	ctx := context.Background()
+
+	// The first poll creates the consumer group at the end of the stream
+	o.poll(ctx, sink)
+	o.Record(anomalies.Anomaly{ID: "a-1", DeviceID: "dev-1"})
+
+	if n := o.poll(ctx, sink); n != 1 || len(sink.delivered) != 0 {
+		t.Fatalf("Expected one failed delivery, got %d entries and %d delivered", n, len(sink.delivered))
+	}
+	if failing := o.Failures(); failing["fake"] != 1 {
+		t.Errorf("Expected the sink to be reported as failing, got %v", failing)
+	}
+	if n := o.poll(ctx, sink); n != 0 {
+		t.Fatalf("Expected failed event to wait for retry, got %d entries", n)
+	}
+
+	clk.Advance(time.Minute)
+	if n := o.poll(ctx, sink); n != 1 || len(sink.delivered) != 1 {
+		t.Fatalf("Expected redelivery after retry interval, got %d entries and %d delivered", n, len(sink.delivered))
+	}
+	if failing := o.Failures(); len(failing) != 0 {
+		t.Errorf("Expected a successful delivery to clear failures, got %v", failing)
+	}
+	if e := sink.delivered[0]; e.ID == "" || e.Anomaly.ID != "a-1" {
+		t.Errorf("Unexpected delivered event %+v", e)
+	}
+
+	clk.Advance(time.Minute)
+	if n := o.poll(ctx, sink); n != 0 {
+		t.Errorf("Expected acknowledged event not to be redelivered, got %d entries", n)
+	}
+}
+
+func TestOutbox_Replay(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	sink := &fakeSink{}
+	o := New(cache.NewMemoryCache(clk), "node-1", []Sink{sink}, WithClock(clk))
+
+	for _, id := range []string{"a-1", "a-2", "a-3"} {
+		o.Record(anomalies.Anomaly{ID: id})
+		clk.Advance(time.Second)
+	}
+
+	n, err := o.Replay(context.Background(), "fake", "-")
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 replayed events, got %d (err %v)", n, err)
+	}
+
+	from := sink.delivered[1].ID
+	sink.delivered = nil
+	if n, _ := o.Replay(context.Background(), "fake", from); n != 2 || sink.delivered[0].Anomaly.ID != "a-2" {
+		t.Errorf("Expected replay to start at %s, got %d events %+v", from, n, sink.delivered)
+	}
+
+	if _, err := o.Replay(context.Background(), "missing", "-"); !errors.Is(err, ErrUnknownSink) {
+		t.Errorf("Expected ErrUnknownSink, got %v", err)
+	}
+}
+
+func TestRouter_Routed(t *testing.T) {
+	registry := devices.NewRegistry(
+		devices.Device{ID: "sensor-1", Tenant: "acme", Groups: []string{"rack-1"}},
+		devices.Device{ID: "gw-1", Tenant: "globex"},
+	)
+	routes, err := ParseRoutes(`{"rules": [
+		{"name": "acme-pager", "tenants": ["acme"], "severity": "critical", "sinks": ["pager"]},
+		{"devices": ["sensor-*"], "sinks": ["slack"]},
+		{"groups": ["rack-1"], "sinks": ["s3"]}
+	], "default": ["ops"]}`)
+	if err != nil {
+		t.Fatalf("ParseRoutes failed: %v", err)
+	}
+	r, err := NewRouter(routes, []string{"pager", "slack", "s3", "ops"}, WithDirectory(registry))
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		anomaly anomalies.Anomaly
+		want    []string
+	}{
+		{"critical tenant device", anomalies.Anomaly{DeviceID: "sensor-1", ZScoreCPU: 7}, []string{"pager", "slack", "s3"}},
+		{"warning tenant device", anomalies.Anomaly{DeviceID: "sensor-1", ZScoreRPS: -3.5}, []string{"slack", "s3"}},
+		{"group anomaly", anomalies.Anomaly{Group: "rack-1"}, []string{"s3"}},
+		{"unmatched device", anomalies.Anomaly{DeviceID: "gw-1", ZScoreCPU: 9}, []string{"ops"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, sink := range []string{"pager", "slack", "s3", "ops"} {
+			if r.Routed(sink, tt.anomaly) {
+				got = append(got, sink)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: expected sinks %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := NewRouter(Routes{Rules: []Rule{{Sinks: []string{"kafka"}}}}, []string{"ops"}); !errors.Is(err, ErrUnknownSink) {
+		t.Errorf("Expected ErrUnknownSink for a rule with an unconfigured sink, got %v", err)
+	}
+}
+
+func TestOutbox_RoutesReloadedFromSource(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	sink := &fakeSink{}
+	router, err := NewRouter(Routes{}, []string{"fake", "other"})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+	o := New(store, "node-1", []Sink{sink}, WithClock(clk), WithRouter(router))
+	ctx := context.Background()
+
+	o.poll(ctx, sink)
+	o.Record(anomalies.Anomaly{ID: "a-1", DeviceID: "dev-1"})
+	if o.poll(ctx, sink); len(sink.delivered) != 1 {
+		t.Fatalf("Expected every event to be delivered without rules, got %d", len(sink.delivered))
+	}
+
+	if err := store.SetWithTTL(DefaultRoutesRedisKey, Routes{Rules: []Rule{{Devices: []string{"dev-*"}, Sinks: []string{"other"}}}}, 0); err != nil {
+		t.Fatalf("SetWithTTL failed: %v", err)
+	}
+	if err := router.Refresh(store, DefaultRoutesRedisKey); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	o.Record(anomalies.Anomaly{ID: "a-2", DeviceID: "dev-2"})
+	if n := o.poll(ctx, sink); n != 1 || len(sink.delivered) != 1 {
+		t.Fatalf("Expected event routed elsewhere to be acknowledged without delivery, got %d entries and %d delivered", n, len(sink.delivered))
+	}
+	clk.Advance(time.Hour)
+	if n := o.poll(ctx, sink); n != 0 {
+		t.Errorf("Expected skipped event not to be redelivered, got %d entries", n)
+	}
+
+	store.SetWithTTL(DefaultRoutesRedisKey, Routes{Default: []string{"kafka"}}, 0)
+	if err := router.Refresh(store, DefaultRoutesRedisKey); !errors.Is(err, ErrUnknownSink) {
+		t.Errorf("Expected reload with an unknown sink to fail, got %v", err)
+	}
+	if got := router.Routes(); len(got.Rules) != 1 {
+		t.Errorf("Expected previous routes to stay in effect, got %+v", got)
+	}
+}
+
+func TestWebhook_Template(t *testing.T) {
+	var gotType, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotType, gotBody = r.Header.Get("Content-Type"), string(body)
+	}))
+	defer server.Close()
+
+	templates, err := ParseTemplates(`{
+		"pager": "{\"summary\": {{json (printf \"%s on %s\" (upper .Severity) (default \"fleet\" .Anomaly.DeviceID))}}, \"z\": {{round .Anomaly.ZScoreCPU 1}}, \"at\": {{unix .RecordedAt}}}",
+		"chat": {"body": "{{.Anomaly.ID}} at {{rfc3339 .RecordedAt}}", "content_type": "text/plain"}
+	}`)
+	if err != nil {
+		t.Fatalf("ParseTemplates failed: %v", err)
+	}
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	hook := NewWebhook("pager", server.URL, time.Second, WithTemplate(templates["pager"]))
+	o := New(cache.NewMemoryCache(clk), "node-1", []Sink{hook}, WithClock(clk))
+	o.poll(context.Background(), hook)
+	o.Record(anomalies.Anomaly{ID: "a-1", ZScoreCPU: 7.25})
+	if n := o.poll(context.Background(), hook); n != 1 {
+		t.Fatalf("Expected one delivery, got %d", n)
+	}
+	if want := `{"summary": "CRITICAL on fleet", "z": 7.3, "at": 1704110400}`; gotType != "application/json" || gotBody != want {
+		t.Errorf("Expected %s, got %q %s", want, gotType, gotBody)
+	}
+
+	chat := NewWebhook("chat", server.URL, time.Second, WithTemplate(templates["chat"]))
+	if err := chat.Deliver(context.Background(), Event{RecordedAt: clk.Now(), Anomaly: anomalies.Anomaly{ID: "a-2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if gotType != "text/plain" || gotBody != "a-2 at 2024-01-01T12:00:00Z" {
+		t.Errorf("Expected the plain text template, got %q %s", gotType, gotBody)
+	}
+
+	// Broken templates are rejected at startup rather than on the first anomaly
+	for _, raw := range []string{`{"x": "{{.Missing}}"}`, `{"x": "{{.ID"}`, `{"x": "not json {{.ID}}"}`} {
+		if _, err := ParseTemplates(raw); err == nil {
+			t.Errorf("Expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestWebhook_Alertmanager(t *testing.T) {
+	var got []Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected Content-Type %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	hook := NewWebhook("am", server.URL, time.Second, WithAlertmanager())
+	o := New(cache.NewMemoryCache(clk), "node-1", []Sink{hook}, WithClock(clk))
+	o.poll(context.Background(), hook)
+	firstSeen := time.Date(2024, 1, 1, 11, 58, 0, 0, time.UTC)
+	o.Record(anomalies.Anomaly{ID: "a-1", DeviceID: "sensor-1", State: anomalies.Open,
+		FirstSeen: firstSeen, Occurrences: 3, ZScoreCPU: 5.5, ZScoreRPS: -1.25})
+	if n := o.poll(context.Background(), hook); n != 1 || len(got) != 1 {
+		t.Fatalf("Expected one alert, got %d deliveries and %+v", n, got)
+	}
+
+	alert := got[0]
+	wantLabels := map[string]string{"alertname": AlertName, "anomaly_id": "a-1", "device_id": "sensor-1", "severity": SeverityCritical}
+	if !reflect.DeepEqual(alert.Labels, wantLabels) {
+		t.Errorf("Expected labels %v, got %v", wantLabels, alert.Labels)
+	}
+	if !alert.StartsAt.Equal(firstSeen) || alert.EndsAt != nil {
+		t.Errorf("Expected an open alert starting at the first occurrence, got %s - %v", alert.StartsAt, alert.EndsAt)
+	}
+	if alert.Annotations["summary"] != "Anomaly on device sensor-1" || alert.Annotations["z_score_rps"] != "-1.25" || alert.Annotations["event_id"] == "" {
+		t.Errorf("Unexpected annotations %v", alert.Annotations)
+	}
+
+	// A resolved anomaly ends the alert
+	resolvedAt := firstSeen.Add(10 * time.Minute)
+	resolved := NewAlert(Event{Anomaly: anomalies.Anomaly{ID: "a-2", Group: "rack-1", State: anomalies.Resolved, ResolvedAt: &resolvedAt}})
+	if resolved.EndsAt == nil || !resolved.EndsAt.Equal(resolvedAt) || resolved.Labels["group"] != "rack-1" {
+		t.Errorf("Expected a resolved group alert, got %+v", resolved)
+	}
+
+	if _, err := ParseFormats(`{"am": "prometheus"}`); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,292 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"highload-service/internal/anomalies"
+	"highload-service/internal/cache"
+	"highload-service/internal/devices"
+)
+
+const (
+	// DefaultRoutesRedisKey ключ Redis с документом маршрутов, заменяющим OUTBOX_ROUTES
+	DefaultRoutesRedisKey = "outbox:routes"
+	// DefaultCriticalZScore |z|, начиная с которого аномалия критическая
+	DefaultCriticalZScore = 5.0
+)
+
+// Уровни тяжести аномалии
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule правило маршрутизации: событие, подходящее под все заданные условия,
+// получают получатели Sinks. Пустое условие подходит под любое событие
+type Rule struct {
+	Name string `json:"name,omitempty"`
+	// Devices шаблоны идентификаторов устройств в синтаксисе path.Match, например "sensor-*"
+	Devices []string `json:"devices,omitempty"`
+	// Groups группы: группа аномалии или группы устройства в реестре
+	Groups []string `json:"groups,omitempty"`
+	// Tenants тенанты устройств по реестру
+	Tenants []string `json:"tenants,omitempty"`
+	// Severity наименьшая тяжесть: warning (любая аномалия) или critical
+	Severity string   `json:"severity,omitempty"`
+	Sinks    []string `json:"sinks"`
+}
+
+// Routes маршруты событий. Событие получают получатели всех подходящих правил,
+// а событие, не подошедшее ни под одно правило, — получатели Default. Без правил
+// каждое событие получают все получатели
+type Routes struct {
+	Rules   []Rule   `json:"rules,omitempty"`
+	Default []string `json:"default,omitempty"`
+}
+
+// ParseRoutes разбирает JSON-документ маршрутов (значение OUTBOX_ROUTES)
+func ParseRoutes(raw string) (Routes, error) {
+	var routes Routes
+	if raw == "" {
+		return routes, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+		return Routes{}, err
+	}
+	return routes, routes.validate(nil)
+}
+
+// validate проверяет шаблоны и уровни тяжести, а если задан sinks — что
+// правила ссылаются только на настроенных получателей
+func (r Routes) validate(sinks []string) error {
+	checkSinks := func(names []string) error {
+		for _, name := range names {
+			if sinks != nil && !contains(sinks, name) {
+				return fmt.Errorf("%w %q", ErrUnknownSink, name)
+			}
+		}
+		return nil
+	}
+	for i, rule := range r.Rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i)
+		}
+		if len(rule.Sinks) == 0 {
+			return fmt.Errorf("rule %s: sinks are required", label)
+		}
+		for _, pattern := range rule.Devices {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %s: device pattern %q: %w", label, pattern, err)
+			}
+		}
+		if rule.Severity != "" && rule.Severity != SeverityWarning && rule.Severity != SeverityCritical {
+			return fmt.Errorf("rule %s: severity must be %q or %q, got %q", label, SeverityWarning, SeverityCritical, rule.Severity)
+		}
+		if err := checkSinks(rule.Sinks); err != nil {
+			return fmt.Errorf("rule %s: %w", label, err)
+		}
+	}
+	if err := checkSinks(r.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	return nil
+}
+
+// Directory реестр устройств, из которого берутся тенант и группы устройства
+// (реализуется devices.Registry)
+type Directory interface {
+	Get(id string) (devices.Device, bool)
+}
+
+// RouterOption настраивает Router
+type RouterOption func(*Router)
+
+// WithDirectory задает реестр устройств для условий Tenants и Groups
+func WithDirectory(d Directory) RouterOption {
+	return func(r *Router) {
+		r.directory = d
+	}
+}
+
+// WithCriticalZScore задает |z|, начиная с которого аномалия критическая
+func WithCriticalZScore(z float64) RouterOption {
+	return func(r *Router) {
+		r.criticalZ = z
+	}
+}
+
+// Router выбирает получателей события. Маршруты из конфигурации можно
+// переопределить документом в Redis, который периодически перечитывается
+type Router struct {
+	directory Directory
+	criticalZ float64
+
+	// sinks имена настроенных получателей: маршруты не могут ссылаться на другие
+	sinks []string
+	base  Routes
+
+	mu     sync.RWMutex
+	routes Routes
+}
+
+// NewRouter создает маршрутизатор с маршрутами base для получателей sinks
+func NewRouter(base Routes, sinks []string, opts ...RouterOption) (*Router, error) {
+	if err := base.validate(sinks); err != nil {
+		return nil, err
+	}
+	r := &Router{sinks: sinks, base: base, routes: base, criticalZ: DefaultCriticalZScore}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// Routes возвращает действующие маршруты
+func (r *Router) Routes() Routes {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routes
+}
+
+// Severity возвращает тяжесть аномалии по наибольшему |z|. Для nil Router
+// действует DefaultCriticalZScore
+func (r *Router) Severity(a anomalies.Anomaly) string {
+	criticalZ := DefaultCriticalZScore
+	if r != nil {
+		criticalZ = r.criticalZ
+	}
+	if math.Max(math.Abs(a.ZScoreCPU), math.Abs(a.ZScoreRPS)) >= criticalZ {
+		return SeverityCritical
+	}
+	return SeverityWarning
+}
+
+// Routed сообщает, должен ли получатель sink получить аномалию. Для nil Router
+// событие получают все
+func (r *Router) Routed(sink string, a anomalies.Anomaly) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.RLock()
+	routes := r.routes
+	r.mu.RUnlock()
+	if len(routes.Rules) == 0 {
+		return true
+	}
+
+	var device devices.Device
+	if r.directory != nil && a.DeviceID != "" {
+		device, _ = r.directory.Get(a.DeviceID)
+	}
+	matched := false
+	for _, rule := range routes.Rules {
+		if r.matches(rule, a, device) {
+			if contains(rule.Sinks, sink) {
+				return true
+			}
+			matched = true
+		}
+	}
+	return !matched && contains(routes.Default, sink)
+}
+
+// matches проверяет условия правила
+func (r *Router) matches(rule Rule, a anomalies.Anomaly, device devices.Device) bool {
+	if rule.Severity == SeverityCritical && r.Severity(a) != SeverityCritical {
+		return false
+	}
+	if len(rule.Tenants) > 0 && !contains(rule.Tenants, device.Tenant) {
+		return false
+	}
+	if len(rule.Groups) > 0 && !contains(rule.Groups, a.Group) && !overlaps(rule.Groups, device.Groups) {
+		return false
+	}
+	if len(rule.Devices) > 0 {
+		for _, pattern := range rule.Devices {
+			if ok, _ := path.Match(pattern, a.DeviceID); ok && a.DeviceID != "" {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Source источник JSON-документа маршрутов (реализуется cache.Cache)
+type Source interface {
+	Get(key string, dest interface{}) error
+}
+
+// Refresh перечитывает маршруты из источника. Отсутствие ключа возвращает
+// маршруты конфигурации; при ошибке действуют прежние маршруты
+func (r *Router) Refresh(src Source, key string) error {
+	var routes Routes
+	err := src.Get(key, &routes)
+	if errors.Is(err, cache.ErrNotFound) {
+		routes, err = r.base, nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := routes.validate(r.sinks); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.routes = routes
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch периодически вызывает Refresh до отмены контекста
+func (r *Router) Watch(ctx context.Context, src Source, key string, interval time.Duration) {
+	if err := r.Refresh(src, key); err != nil {
+		log.Printf("Failed to load outbox routes: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.Refresh(src, key)
+			// Логируем только смену состояния, чтобы недоступный Redis не засыпал лог
+			if err != nil && !failing {
+				log.Printf("Failed to refresh outbox routes, keeping previous ones: %v", err)
+			} else if err == nil && failing {
+				log.Printf("Outbox routes refreshed")
+			}
+			failing = err != nil
+		}
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func overlaps(a, b []string) bool {
+	for _, v := range b {
+		if contains(a, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	"highload-service/internal/anomalies"
+)
+
+// Template шаблон тела webhook-запроса (text/template). Данные шаблона —
+// Event: {{.ID}}, {{.RecordedAt}}, {{.Severity}} и поля аномалии {{.Anomaly.DeviceID}}.
+// Кроме встроенных функций доступны:
+//
+//	json    значение в JSON: {{json .Anomaly.DeviceID}} → "sensor-1" с экранированием
+//	upper, lower
+//	default значение по умолчанию для пустого: {{default "unknown" .Anomaly.DeviceID}}
+//	round   округление до знаков: {{round .Anomaly.ZScoreCPU 2}}
+//	rfc3339 время в RFC 3339 (UTC), unix — в секундах Unix
+type Template struct {
+	tmpl        *template.Template
+	contentType string
+}
+
+// TemplateSpec настройка шаблона в OUTBOX_TEMPLATES
+type TemplateSpec struct {
+	Body string `json:"body"`
+	// ContentType тип тела запроса; по умолчанию application/json, и тогда
+	// результат шаблона обязан быть корректным JSON
+	ContentType string `json:"content_type,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"round": func(v float64, places int) float64 {
+		p := math.Pow(10, float64(places))
+		return math.Round(v*p) / p
+	},
+	"rfc3339": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"unix":    func(t time.Time) int64 { return t.Unix() },
+}
+
+// sampleEvent событие для проверки шаблонов при запуске
+var sampleEvent = Event{
+	ID:         "1704103200000-0",
+	RecordedAt: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+	Severity:   SeverityCritical,
+	Anomaly: anomalies.Anomaly{
+		ID:          "a-1",
+		DeviceID:    "sensor-1",
+		State:       anomalies.Open,
+		FirstSeen:   time.Date(2024, 1, 1, 9, 59, 0, 0, time.UTC),
+		LastSeen:    time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		Occurrences: 2,
+		ZScoreCPU:   5.5,
+	},
+}
+
+// NewTemplate разбирает шаблон получателя name и проверяет его на примере
+// события: ошибка в шаблоне обнаруживается при запуске, а не при первой аномалии
+func NewTemplate(name string, spec TemplateSpec) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(spec.Body)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{tmpl: tmpl, contentType: spec.ContentType}
+	if t.contentType == "" {
+		t.contentType = "application/json"
+	}
+	if _, err := t.Render(sampleEvent); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ContentType возвращает тип тела запроса
+func (t *Template) ContentType() string {
+	return t.contentType
+}
+
+// Render заполняет шаблон событием e
+func (t *Template) Render(e Event) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	if t.contentType == "application/json" && !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %s produced invalid JSON: %.200s", t.tmpl.Name(), buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseTemplates разбирает JSON-объект "получатель": шаблон (значение
+// OUTBOX_TEMPLATES). Шаблон задается строкой тела или объектом TemplateSpec
+func ParseTemplates(raw string) (map[string]*Template, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var specs map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, err
+	}
+	templates := make(map[string]*Template, len(specs))
+	for name, data := range specs {
+		var spec TemplateSpec
+		if err := json.Unmarshal(data, &spec.Body); err != nil {
+			if err := json.Unmarshal(data, &spec); err != nil {
+				return nil, fmt.Errorf("template %s: %w", name, err)
+			}
+		}
+		t, err := NewTemplate(name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
@@ -0,0 +1,120 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"highload-service/internal/codec"
+)
+
+// DefaultWebhookTimeout таймаут одного запроса к webhook
+const DefaultWebhookTimeout = 5 * time.Second
+
+// ParseWebhooks разбирает JSON-объект "имя": "URL" (значение OUTBOX_WEBHOOKS)
+func ParseWebhooks(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var hooks map[string]string
+	if err := json.Unmarshal([]byte(raw), &hooks); err != nil {
+		return nil, err
+	}
+	for name, target := range hooks {
+		u, err := url.Parse(target)
+		if name == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: invalid URL %q", name, target)
+		}
+	}
+	return hooks, nil
+}
+
+// Webhook получатель, отправляющий событие POST-запросом в формате JSON, по
+// шаблону или оповещением Alertmanager. Идентификатор события передается в
+// заголовке X-Event-ID
+type Webhook struct {
+	name         string
+	url          string
+	client       *http.Client
+	format       codec.Codec
+	template     *Template
+	alertmanager bool
+}
+
+// WebhookOption настраивает Webhook
+type WebhookOption func(*Webhook)
+
+// WithTemplate формирует тело запроса шаблоном t вместо Event в JSON
+func WithTemplate(t *Template) WebhookOption {
+	return func(w *Webhook) {
+		w.template = t
+	}
+}
+
+// WithAlertmanager отправляет событие оповещением API Alertmanager v2:
+// URL получателя — http://alertmanager:9093/api/v2/alerts
+func WithAlertmanager() WebhookOption {
+	return func(w *Webhook) {
+		w.alertmanager = true
+	}
+}
+
+// NewWebhook создает webhook-получатель
+func NewWebhook(name, url string, timeout time.Duration, opts ...WebhookOption) *Webhook {
+	w := &Webhook{name: name, url: url, client: &http.Client{Timeout: timeout}, format: codec.JSON}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Name возвращает имя получателя
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+// Deliver отправляет событие; любой ответ, кроме 2xx, считается ошибкой
+func (w *Webhook) Deliver(ctx context.Context, e Event) error {
+	var body []byte
+	var err error
+	contentType := w.format.ContentType()
+	switch {
+	case w.alertmanager:
+		body, err = json.Marshal([]Alert{NewAlert(e)})
+		contentType = "application/json"
+	case w.template != nil:
+		// Шаблон проверен при запуске; событие, на котором он все же не
+		// выполнился, не выполнится и при повторе
+		if body, err = w.template.Render(e); err != nil {
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+		contentType = w.template.ContentType()
+	default:
+		body, err = w.format.Marshal(e)
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Event-ID", e.ID)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %d", w.name, resp.StatusCode)
+	}
+	return nil
+}
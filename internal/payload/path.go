@@ -0,0 +1,130 @@
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path путь к значению в JSON-документе в записи JSONPath без фильтров и
+// подстановок: $ — корень документа, @ — текущая запись (records), далее
+// .name, ['name'] и [индекс]; отрицательный индекс считается с конца массива:
+//
+//	$.meta.serial  @.sensors[0].value  @['cpu load']  $.readings[-1]
+type Path struct {
+	raw   string
+	root  bool
+	steps []step
+}
+
+// step шаг пути: ключ объекта или индекс массива
+type step struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// ParsePath разбирает путь
+func ParsePath(raw string) (Path, error) {
+	p := Path{raw: raw}
+	if raw == "" || (raw[0] != '$' && raw[0] != '@') {
+		return Path{}, fmt.Errorf("path %q must start with $ or @", raw)
+	}
+	p.root = raw[0] == '$'
+	rest := raw[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return Path{}, fmt.Errorf("path %q: empty key", raw)
+			}
+			p.steps = append(p.steps, step{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("path %q: unclosed [", raw)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, step{key: inner[1 : len(inner)-1]})
+			} else {
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return Path{}, fmt.Errorf("path %q: invalid index %q", raw, inner)
+				}
+				p.steps = append(p.steps, step{index: i, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return Path{}, fmt.Errorf("path %q: unexpected %q", raw, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// String возвращает путь в исходной записи
+func (p Path) String() string {
+	return p.raw
+}
+
+// IsZero сообщает, что путь не задан
+func (p Path) IsZero() bool {
+	return p.raw == ""
+}
+
+// UnmarshalJSON разбирает путь из строки конфигурации
+func (p *Path) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := ParsePath(raw)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// lookup находит значение пути в документе root для записи record.
+// ok == false, если ключа или элемента нет
+func (p Path) lookup(root, record interface{}) (interface{}, bool) {
+	v := record
+	if p.root {
+		v = root
+	}
+	for _, s := range p.steps {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if s.isIndex {
+				return nil, false
+			}
+			var ok bool
+			if v, ok = node[s.key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if !s.isIndex {
+				return nil, false
+			}
+			i := s.index
+			if i < 0 {
+				i += len(node)
+			}
+			if i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
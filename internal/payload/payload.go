@@ -0,0 +1,326 @@
+// Package payload переводит JSON устройств сторонних производителей в
+// models.Metric по правилам извлечения из конфигурации (PAYLOAD_MAPPINGS),
+// поэтому новую модель датчиков можно подключить без изменения кода.
+//
+// Модель устройства указывается заголовком ModelHeader; для нее задаются
+// пути к полям метрики (Path). Без заголовка тело разбирается как обычно
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+// ModelHeader заголовок запроса с моделью устройства
+const ModelHeader = "X-Device-Model"
+
+// ErrUnknownModel для модели устройства нет правил
+var ErrUnknownModel = errors.New("unknown device model")
+
+// Форматы времени метрики
+const (
+	// RFC3339 строка RFC 3339 (по умолчанию)
+	RFC3339 = "rfc3339"
+	// Unix секунды Unix, допускается дробная часть
+	Unix = "unix"
+	// UnixMilli миллисекунды Unix
+	UnixMilli = "unix_ms"
+	// UnixNano наносекунды Unix
+	UnixNano = "unix_ns"
+)
+
+// Mapping правила извлечения метрики для одной модели устройства. Пути полей
+// без records задаются от корня документа; пустой путь оставляет поле нулевым,
+// а время без пути — временем приема. Числа принимаются и строками
+type Mapping struct {
+	// Records путь к массиву записей: каждая запись — отдельная метрика, и пути
+	// с @ отсчитываются от нее. Без него запись одна — весь документ
+	Records  Path `json:"records"`
+	DeviceID Path `json:"device_id"`
+	Region   Path `json:"region"`
+	// Timestamp путь ко времени в формате TimestampFormat
+	Timestamp       Path   `json:"timestamp"`
+	TimestampFormat string `json:"timestamp_format"`
+	CPU             Path   `json:"cpu"`
+	RPS             Path   `json:"rps"`
+	Seq             Path   `json:"seq"`
+	// Values пути к именованным показателям
+	Values map[string]Path `json:"values"`
+}
+
+// Mappings правила по моделям устройств. Не изменяется после создания и
+// безопасен для конкурентного использования
+type Mappings struct {
+	byModel map[string]Mapping
+}
+
+// Load читает правила из переменной (raw) или, если она пуста, из файла path.
+// Пустые raw и path означают, что перевод выключен (nil без ошибки)
+func Load(raw, path string) (*Mappings, error) {
+	if raw == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payload mappings: %w", err)
+		}
+		raw = string(data)
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return Parse(raw)
+}
+
+// Parse разбирает JSON-объект правил по именам моделей, например
+//
+//	{"acme-th200": {"device_id": "$.serial", "records": "$.readings",
+//	  "timestamp": "@.ts", "timestamp_format": "unix_ms",
+//	  "cpu": "@.load", "rps": "@.requests", "values": {"temperature": "@.t"}}}
+func Parse(raw string) (*Mappings, error) {
+	var byModel map[string]Mapping
+	if err := json.Unmarshal([]byte(raw), &byModel); err != nil {
+		return nil, err
+	}
+	for model, m := range byModel {
+		if model == "" {
+			return nil, errors.New("device model must not be empty")
+		}
+		switch m.TimestampFormat {
+		case "":
+			m.TimestampFormat = RFC3339
+		case RFC3339, Unix, UnixMilli, UnixNano:
+		default:
+			return nil, fmt.Errorf("model %s: unknown timestamp_format %q", model, m.TimestampFormat)
+		}
+		if m.CPU.IsZero() && m.RPS.IsZero() && len(m.Values) == 0 {
+			return nil, fmt.Errorf("model %s: no cpu, rps or values paths", model)
+		}
+		if len(m.Values) > models.MaxNamedValues {
+			return nil, fmt.Errorf("model %s: at most %d values are allowed", model, models.MaxNamedValues)
+		}
+		byModel[model] = m
+	}
+	return &Mappings{byModel: byModel}, nil
+}
+
+// Models возвращает имена моделей по алфавиту
+func (m *Mappings) Models() []string {
+	names := make([]string, 0, len(m.byModel))
+	for name := range m.byModel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode читает JSON-документ модели model из r и заполняет v: *models.Metric
+// (документ должен дать ровно одну запись) или *models.MetricsBatch
+func (m *Mappings) Decode(model string, r io.Reader, v interface{}) error {
+	mapping, ok := m.byModel[model]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownModel, model)
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	metrics, err := mapping.extract(doc)
+	if err != nil {
+		return err
+	}
+
+	switch dst := v.(type) {
+	case *models.Metric:
+		if len(metrics) != 1 {
+			return fmt.Errorf("expected one record, got %d", len(metrics))
+		}
+		*dst = metrics[0]
+	case *models.MetricsBatch:
+		dst.Metrics = metrics
+	default:
+		return fmt.Errorf("payload mappings decode metrics, got %T", v)
+	}
+	return nil
+}
+
+// extract переводит записи документа в метрики
+func (m Mapping) extract(doc interface{}) ([]models.Metric, error) {
+	records := []interface{}{doc}
+	if !m.Records.IsZero() {
+		v, ok := m.Records.lookup(doc, doc)
+		if !ok {
+			return nil, fmt.Errorf("records: %s not found", m.Records)
+		}
+		if records, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("records: %s is not an array", m.Records)
+		}
+	}
+
+	metrics := make([]models.Metric, len(records))
+	for i, record := range records {
+		metric, err := m.metric(doc, record)
+		if err != nil {
+			if m.Records.IsZero() {
+				return nil, err
+			}
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		metrics[i] = metric
+	}
+	return metrics, nil
+}
+
+// metric переводит одну запись
+func (m Mapping) metric(doc, record interface{}) (models.Metric, error) {
+	var metric models.Metric
+	var err error
+	field := func(name string, p Path, fill func(interface{}) error) {
+		if err != nil || p.IsZero() {
+			return
+		}
+		v, ok := p.lookup(doc, record)
+		if !ok {
+			err = fmt.Errorf("%s: %s not found", name, p)
+			return
+		}
+		if ferr := fill(v); ferr != nil {
+			err = fmt.Errorf("%s: %s %w", name, p, ferr)
+		}
+	}
+
+	field("device_id", m.DeviceID, func(v interface{}) (err error) {
+		metric.DeviceID, err = toString(v)
+		return err
+	})
+	field("region", m.Region, func(v interface{}) (err error) {
+		metric.Region, err = toString(v)
+		return err
+	})
+	field("timestamp", m.Timestamp, func(v interface{}) (err error) {
+		metric.Timestamp, err = toTime(v, m.TimestampFormat)
+		return err
+	})
+	field("cpu", m.CPU, func(v interface{}) (err error) {
+		metric.CPU, err = toFloat(v)
+		return err
+	})
+	field("rps", m.RPS, func(v interface{}) (err error) {
+		metric.RPS, err = toFloat(v)
+		return err
+	})
+	field("seq", m.Seq, func(v interface{}) error {
+		s, err := toString(v)
+		if err == nil {
+			metric.Seq, err = strconv.ParseUint(s, 10, 64)
+		}
+		if err != nil {
+			return errors.New("is not a sequence number")
+		}
+		return nil
+	})
+	for name, p := range m.Values {
+		field("values."+name, p, func(v interface{}) error {
+			f, err := toFloat(v)
+			if err == nil {
+				if metric.Values == nil {
+					metric.Values = make(map[string]float64, len(m.Values))
+				}
+				metric.Values[name] = f
+			}
+			return err
+		})
+	}
+	return metric, err
+}
+
+// toFloat число из числа, строки с числом или логического значения (1 и 0)
+func toFloat(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case json.Number:
+		return x.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, fmt.Errorf("is not a number: %q", x)
+		}
+		return f, nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("is not a number: %s", kind(v))
+}
+
+// toString строка из строки или числа (числовые серийные номера)
+func toString(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	}
+	return "", fmt.Errorf("is not a string: %s", kind(v))
+}
+
+// toTime время в формате format
+func toTime(v interface{}, format string) (time.Time, error) {
+	if format == RFC3339 {
+		s, ok := v.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("is not an RFC 3339 time: %s", kind(v))
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("is not an RFC 3339 time: %q", s)
+		}
+		return t, nil
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch format {
+	case Unix:
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	case UnixMilli:
+		return time.UnixMilli(int64(f)).UTC(), nil
+	}
+	// Наносекунды не помещаются в float64 точно: берется исходная запись числа
+	if n, ok := v.(json.Number); ok {
+		if ns, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+			return time.Unix(0, ns).UTC(), nil
+		}
+	}
+	return time.Unix(0, int64(f)).UTC(), nil
+}
+
+// kind тип JSON-значения для сообщений об ошибках
+func kind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	}
+	return "number"
+}
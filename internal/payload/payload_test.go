@@ -0,0 +1,104 @@
+package payload
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+const vendorMappings = `{
+	"acme-th200": {
+		"device_id": "$.serial",
+		"region": "$.site.region",
+		"records": "$.readings",
+		"timestamp": "@.ts",
+		"timestamp_format": "unix_ms",
+		"cpu": "@['cpu load']",
+		"rps": "@.requests",
+		"seq": "@.n",
+		"values": {"temperature": "@.sensors[-1].value"}
+	},
+	"globex": {"device_id": "$.id", "timestamp": "$.time", "cpu": "$.stats.cpu", "rps": "$.stats.rps"}
+}`
+
+func TestMappings_DecodeRecordsRelativeToDocument(t *testing.T) {
+	m, err := Parse(vendorMappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"serial": 1042, "site": {"region": "eu-west"}, "readings": [
+		{"ts": 1704110400000, "cpu load": "45.5", "requests": 500, "n": 7, "sensors": [{"value": 1}, {"value": -4.5}]},
+		{"ts": 1704110401000, "cpu load": 46, "requests": 510, "n": "8", "sensors": [{"value": true}]}
+	]}`
+	var batch models.MetricsBatch
+	if err := m.Decode("acme-th200", strings.NewReader(body), &batch); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	want := []models.Metric{
+		{DeviceID: "1042", Region: "eu-west", Timestamp: ts, CPU: 45.5, RPS: 500, Seq: 7, Values: map[string]float64{"temperature": -4.5}},
+		{DeviceID: "1042", Region: "eu-west", Timestamp: ts.Add(time.Second), CPU: 46, RPS: 510, Seq: 8, Values: map[string]float64{"temperature": 1}},
+	}
+	if len(batch.Metrics) != len(want) {
+		t.Fatalf("Expected %d metrics, got %+v", len(want), batch.Metrics)
+	}
+	for i, got := range batch.Metrics {
+		w := want[i]
+		if got.DeviceID != w.DeviceID || got.Region != w.Region || !got.Timestamp.Equal(w.Timestamp) ||
+			got.CPU != w.CPU || got.RPS != w.RPS || got.Seq != w.Seq || got.Values["temperature"] != w.Values["temperature"] {
+			t.Errorf("Metric %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	var metric models.Metric
+	err = m.Decode("globex", strings.NewReader(`{"id": "g-1", "time": "2024-01-01T12:00:00Z", "stats": {"cpu": 12, "rps": 3}}`), &metric)
+	if err != nil || metric.DeviceID != "g-1" || metric.CPU != 12 || metric.RPS != 3 || !metric.Timestamp.Equal(ts) {
+		t.Errorf("Unexpected single metric %+v (%v)", metric, err)
+	}
+	if err := m.Decode("acme-th200", strings.NewReader(body), &metric); err == nil {
+		t.Error("Expected a single metric to reject a document with two records")
+	}
+}
+
+func TestMappings_DecodeErrorsNameTheField(t *testing.T) {
+	m, err := Parse(vendorMappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metric models.Metric
+	for body, want := range map[string]string{
+		`{"id": "g-1", "time": "2024-01-01T12:00:00Z", "stats": {"cpu": 12}}`:            "rps: $.stats.rps not found",
+		`{"id": "g-1", "time": "2024-01-01T12:00:00Z", "stats": {"cpu": "x", "rps": 1}}`: `cpu: $.stats.cpu is not a number: "x"`,
+		`{"id": ["g-1"], "time": "2024-01-01T12:00:00Z", "stats": {"cpu": 1, "rps": 1}}`: "device_id: $.id is not a string: array",
+		`{"id": "g-1", "time": 1704110400, "stats": {"cpu": 1, "rps": 1}}`:               "timestamp: $.time is not an RFC 3339 time: number",
+	} {
+		if err := m.Decode("globex", strings.NewReader(body), &metric); err == nil || err.Error() != want {
+			t.Errorf("Expected error %q, got %v", want, err)
+		}
+	}
+	if err := m.Decode("initech", strings.NewReader(`{}`), &metric); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("Expected ErrUnknownModel, got %v", err)
+	}
+}
+
+func TestParse_RejectsInvalidRules(t *testing.T) {
+	for _, raw := range []string{
+		`{"m": {"cpu": "stats.cpu"}}`,
+		`{"m": {"cpu": "$.stats[x]"}}`,
+		`{"m": {"cpu": "$.stats[0"}}`,
+		`{"m": {"cpu": "$..cpu"}}`,
+		`{"m": {"cpu": "$.cpu", "timestamp_format": "iso"}}`,
+		`{"m": {"device_id": "$.id"}}`,
+		`{"": {"cpu": "$.cpu"}}`,
+	} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("Expected an error for %s", raw)
+		}
+	}
+	if m, err := Load("", ""); m != nil || err != nil {
+		t.Errorf("Expected no mappings without configuration, got %v (%v)", m, err)
+	}
+}
@@ -0,0 +1,245 @@
+// Package profiler реализует сторожевой таймер, который автоматически снимает
+// CPU- и heap-профили, когда p99 задержки обработчиков или глубина очереди
+// анализатора превышают пороги. Профили сохраняются с отметкой времени
+// на диск или в S3, чтобы кратковременные замедления в продакшене можно было разобрать постфактум
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+// maxSamples ограничивает число задержек, хранимых за один интервал проверки
+const maxSamples = 4096
+
+// minSamples минимальное число запросов за интервал, при котором p99 имеет смысл
+const minSamples = 20
+
+// Config настройки автоматического снятия профилей
+type Config struct {
+	Enabled bool
+	// Output каталог для профилей или s3://bucket/prefix
+	Output string
+	// P99Threshold порог p99 задержки обработчиков; 0 отключает проверку
+	P99Threshold time.Duration
+	// QueueThreshold порог глубины очереди анализатора; 0 отключает проверку
+	QueueThreshold int
+	// CheckInterval период проверки порогов
+	CheckInterval time.Duration
+	// CPUDuration длительность снятия CPU-профиля
+	CPUDuration time.Duration
+	// Cooldown минимальный интервал между снятиями профилей
+	Cooldown time.Duration
+	// MaxFiles количество хранимых профилей (для каталога)
+	MaxFiles int
+	// S3 параметры доступа к S3 для Output вида s3://
+	S3 S3Config
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	if c.Output == "" {
+		return fmt.Errorf("PROFILE_OUTPUT: must not be empty")
+	}
+	if c.P99Threshold == 0 && c.QueueThreshold == 0 {
+		return fmt.Errorf("PROFILE_P99_THRESHOLD or PROFILE_QUEUE_THRESHOLD must be set")
+	}
+	return nil
+}
+
+// QueueSource источник глубины очереди (реализуется analytics.Analyzer)
+type QueueSource interface {
+	QueueLength() int
+}
+
+// Store сохраняет снятый профиль под заданным именем
+type Store interface {
+	Save(ctx context.Context, name string, data []byte) error
+}
+
+// Option настраивает Watchdog
+type Option func(*Watchdog)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(w *Watchdog) {
+		w.clock = c
+	}
+}
+
+// Watchdog следит за задержками и очередью и снимает профили при превышении порогов
+type Watchdog struct {
+	cfg   Config
+	store Store
+	queue QueueSource
+	clock clock.Clock
+
+	mu        sync.Mutex
+	samples   []time.Duration
+	next      int
+	lastShot  time.Time
+	capturing atomic.Bool
+	captured  atomic.Int64
+}
+
+// New создает Watchdog; queue может быть nil, если проверка очереди не нужна
+func New(cfg Config, store Store, queue QueueSource, opts ...Option) *Watchdog {
+	w := &Watchdog{
+		cfg:     cfg,
+		store:   store,
+		queue:   queue,
+		clock:   clock.Real(),
+		samples: make([]time.Duration, 0, maxSamples),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Observe учитывает задержку одного запроса
+func (w *Watchdog) Observe(d time.Duration) {
+	w.mu.Lock()
+	if len(w.samples) < maxSamples {
+		w.samples = append(w.samples, d)
+	} else {
+		// Буфер заполнен: перезаписываем по кругу, сохраняя свежие значения
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % maxSamples
+	}
+	w.mu.Unlock()
+}
+
+// Middleware измеряет задержку обработчиков
+func (w *Watchdog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := w.clock.Now()
+		next.ServeHTTP(rw, r)
+		w.Observe(w.clock.Since(start))
+	})
+}
+
+// Captured возвращает количество снятых наборов профилей
+func (w *Watchdog) Captured() int64 {
+	return w.captured.Load()
+}
+
+// Run периодически проверяет пороги до отмены контекста
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reason := w.Check(); reason != "" {
+				// Снятие CPU-профиля занимает CPUDuration, не блокируем проверки
+				go func() {
+					if err := w.Capture(ctx, reason); err != nil {
+						log.Printf("Profile capture failed: %v", err)
+					}
+				}()
+			}
+		}
+	}
+}
+
+// Check сбрасывает накопленные задержки и возвращает причину снятия профиля
+// ("p99" или "queue") либо пустую строку, если пороги не превышены или действует cooldown
+func (w *Watchdog) Check() string {
+	w.mu.Lock()
+	p99 := percentile99(w.samples)
+	n := len(w.samples)
+	w.samples = w.samples[:0]
+	w.next = 0
+	inCooldown := !w.lastShot.IsZero() && w.clock.Since(w.lastShot) < w.cfg.Cooldown
+	w.mu.Unlock()
+
+	if inCooldown || w.capturing.Load() {
+		return ""
+	}
+	if w.cfg.P99Threshold > 0 && n >= minSamples && p99 >= w.cfg.P99Threshold {
+		log.Printf("p99 latency %s exceeded %s over %d requests, capturing profiles", p99, w.cfg.P99Threshold, n)
+		return "p99"
+	}
+	if w.cfg.QueueThreshold > 0 && w.queue != nil {
+		if depth := w.queue.QueueLength(); depth >= w.cfg.QueueThreshold {
+			log.Printf("Analyzer queue depth %d exceeded %d, capturing profiles", depth, w.cfg.QueueThreshold)
+			return "queue"
+		}
+	}
+	return ""
+}
+
+// Capture снимает CPU- и heap-профиль и сохраняет их в Store.
+// Одновременно выполняется не более одного снятия
+func (w *Watchdog) Capture(ctx context.Context, reason string) error {
+	if !w.capturing.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer w.capturing.Store(false)
+
+	now := w.clock.Now()
+	w.mu.Lock()
+	w.lastShot = now
+	w.mu.Unlock()
+
+	prefix := now.UTC().Format("20060102T150405Z") + "-" + reason
+
+	// Heap снимаем первым: он отражает состояние на момент превышения порога
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return fmt.Errorf("heap profile: %w", err)
+	}
+	if err := w.store.Save(ctx, prefix+"-heap.pprof", heap.Bytes()); err != nil {
+		return fmt.Errorf("save heap profile: %w", err)
+	}
+
+	var cpu bytes.Buffer
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		// CPU-профиль уже снимается (например, через /debug/pprof/profile)
+		log.Printf("CPU profile skipped: %v", err)
+	} else {
+		select {
+		case <-time.After(w.cfg.CPUDuration):
+		case <-ctx.Done():
+		}
+		pprof.StopCPUProfile()
+		if err := w.store.Save(ctx, prefix+"-cpu.pprof", cpu.Bytes()); err != nil {
+			return fmt.Errorf("save cpu profile: %w", err)
+		}
+	}
+
+	w.captured.Add(1)
+	metrics.ProfilesCaptured.WithLabelValues(reason).Inc()
+	log.Printf("Profiles captured: %s", prefix)
+	return nil
+}
+
+// percentile99 вычисляет p99; исходный срез не изменяется
+func percentile99(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*99 + 99) / 100
+	if idx > len(sorted) {
+		idx = len(sorted)
+	}
+	return sorted[idx-1]
+}
@@ -0,0 +1,172 @@
+package profiler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+)
+
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memStore) Save(_ context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	s.files[name] = data
+	return nil
+}
+
+type fixedQueue int
+
+func (q fixedQueue) QueueLength() int { return int(q) }
+
+func testConfig() Config {
+	return Config{
+		Enabled:        true,
+		Output:         "unused",
+		P99Threshold:   100 * time.Millisecond,
+		QueueThreshold: 80,
+		CheckInterval:  time.Second,
+		CPUDuration:    10 * time.Millisecond,
+		Cooldown:       time.Minute,
+	}
+}
+
+func TestWatchdog_TriggersOnP99(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	w := New(testConfig(), &memStore{}, fixedQueue(0), WithClock(clk))
+
+	for i := 0; i < 100; i++ {
+		w.Observe(time.Millisecond)
+	}
+	if reason := w.Check(); reason != "" {
+		t.Fatalf("Expected no trigger for fast requests, got %q", reason)
+	}
+
+	// 2 slow requests out of 100 push p99 above the threshold
+	for i := 0; i < 98; i++ {
+		w.Observe(time.Millisecond)
+	}
+	w.Observe(time.Second)
+	w.Observe(time.Second)
+	if reason := w.Check(); reason != "p99" {
+		t.Errorf("Expected p99 trigger, got %q", reason)
+	}
+}
+
+func TestWatchdog_IgnoresTooFewSamples(t *testing.T) {
+	w := New(testConfig(), &memStore{}, nil)
+	for i := 0; i < minSamples-1; i++ {
+		w.Observe(time.Second)
+	}
+	if reason := w.Check(); reason != "" {
+		t.Errorf("Expected no trigger below %d samples, got %q", minSamples, reason)
+	}
+}
+
+func TestWatchdog_TriggersOnQueueDepthWithCooldown(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	store := &memStore{}
+	w := New(testConfig(), store, fixedQueue(90), WithClock(clk))
+
+	reason := w.Check()
+	if reason != "queue" {
+		t.Fatalf("Expected queue trigger, got %q", reason)
+	}
+	if err := w.Capture(context.Background(), reason); err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+
+	for _, name := range []string{"20240102T030405Z-queue-heap.pprof", "20240102T030405Z-queue-cpu.pprof"} {
+		if len(store.files[name]) == 0 {
+			t.Errorf("Expected non-empty profile %s, got files %v", name, keys(store.files))
+		}
+	}
+
+	if reason := w.Check(); reason != "" {
+		t.Errorf("Expected cooldown to suppress trigger, got %q", reason)
+	}
+	clk.Advance(time.Minute)
+	if reason := w.Check(); reason != "queue" {
+		t.Errorf("Expected trigger after cooldown, got %q", reason)
+	}
+}
+
+func TestDirStore_PrunesOldest(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDirStore(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"20240101T000000Z-p99-heap.pprof", "20240102T000000Z-p99-heap.pprof", "20240103T000000Z-p99-heap.pprof"} {
+		if err := s.Save(context.Background(), name, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "20240101T000000Z-p99-heap.pprof")); !os.IsNotExist(err) {
+		t.Error("Expected oldest profile to be pruned")
+	}
+}
+
+func TestS3Store_SignedPut(t *testing.T) {
+	var gotPath, gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer srv.Close()
+
+	store, err := NewStore(Config{
+		Output: "s3://profiles/highload/",
+		S3: S3Config{
+			Region:          "eu-west-1",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			Endpoint:        srv.URL,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.(*S3Store).now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	if err := store.Save(context.Background(), "p.pprof", []byte("data")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if gotPath != "/profiles/highload/p.pprof" || gotBody != "data" {
+		t.Errorf("Unexpected upload path=%q body=%q", gotPath, gotBody)
+	}
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(gotAuth, wantPrefix) {
+		t.Errorf("Unexpected Authorization header %q", gotAuth)
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewStore создает хранилище по Output: s3://bucket/prefix или путь к каталогу
+func NewStore(cfg Config) (Store, error) {
+	if rest, ok := strings.CutPrefix(cfg.Output, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("PROFILE_OUTPUT: missing bucket in %q", cfg.Output)
+		}
+		return NewS3Store(cfg.S3, bucket, prefix)
+	}
+	return NewDirStore(cfg.Output, cfg.MaxFiles)
+}
+
+// DirStore сохраняет профили в локальный каталог
+type DirStore struct {
+	dir      string
+	maxFiles int
+}
+
+// NewDirStore создает каталог при необходимости; maxFiles <= 0 отключает очистку
+func NewDirStore(dir string, maxFiles int) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir, maxFiles: maxFiles}, nil
+}
+
+// Save атомарно записывает профиль и удаляет самые старые файлы сверх лимита
+func (s *DirStore) Save(_ context.Context, name string, data []byte) error {
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return s.prune()
+}
+
+// prune удаляет старые профили; имена начинаются с отметки времени,
+// поэтому лексикографический порядок совпадает с хронологическим
+func (s *DirStore) prune() error {
+	if s.maxFiles <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.pprof"))
+	if err != nil || len(files) <= s.maxFiles {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files[:len(files)-s.maxFiles] {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// S3Config параметры доступа к S3 или совместимому хранилищу (MinIO)
+type S3Config struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint переопределяет адрес, например http://minio:9000
+	Endpoint string
+}
+
+// S3Store загружает профили в S3 запросом PUT Object с подписью AWS SigV4
+type S3Store struct {
+	cfg    S3Config
+	bucket string
+	prefix string
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Store создает хранилище для бакета; объекты получают ключи prefix/name
+func NewS3Store(cfg S3Config, bucket, prefix string) (*S3Store, error) {
+	if cfg.Region == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 profile store requires AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return &S3Store{
+		cfg:    cfg,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+// Save загружает профиль (path-style адресация бакета)
+func (s *S3Store) Save(ctx context.Context, name string, data []byte) error {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+
+	base, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	segments := strings.Split(s.bucket+"/"+key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	objectPath := strings.TrimRight(base.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base.Scheme+"://"+base.Host+objectPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, objectPath, data)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 put %s: status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign добавляет заголовки AWS Signature Version 4
+func (s *S3Store) sign(req *http.Request, escapedPath string, payload []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if s.cfg.SessionToken != "" {
+		headers["x-amz-security-token"] = s.cfg.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, escapedPath, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,250 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Matcher условие на метку ряда: label="value" или label!="value"
+type Matcher struct {
+	Label string `json:"label"`
+	Equal bool   `json:"equal"`
+	Value string `json:"value"`
+}
+
+// Matches проверяет метки ряда; отсутствующая метка считается пустой строкой
+func (m Matcher) Matches(labels map[string]string) bool {
+	return (labels[m.Label] == m.Value) == m.Equal
+}
+
+// Expr разобранное выражение func(field{matchers}[window]) by (labels)
+type Expr struct {
+	Func     string
+	Field    string
+	Matchers []Matcher
+	Window   time.Duration
+	By       []string
+}
+
+// String возвращает каноническую запись выражения
+func (e *Expr) String() string {
+	var b strings.Builder
+	b.WriteString(e.Func + "(" + e.Field)
+	if len(e.Matchers) > 0 {
+		parts := make([]string, len(e.Matchers))
+		for i, m := range e.Matchers {
+			op := "="
+			if !m.Equal {
+				op = "!="
+			}
+			parts[i] = m.Label + op + strconv.Quote(m.Value)
+		}
+		b.WriteString("{" + strings.Join(parts, ", ") + "}")
+	}
+	b.WriteString("[" + formatWindow(e.Window) + "])")
+	if len(e.By) > 0 {
+		b.WriteString(" by (" + strings.Join(e.By, ", ") + ")")
+	}
+	return b.String()
+}
+
+// Parse разбирает выражение, например avg_over_time(cpu{region="eu-west"}[5m]) by (device)
+func Parse(input string) (*Expr, error) {
+	p := &parser{input: input}
+	return p.parse()
+}
+
+// parser рекурсивный разбор без отдельного лексера: грамматика умещается в одну функцию
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w at position %d: %s", ErrSyntax, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// ident читает идентификатор [a-zA-Z_][a-zA-Z0-9_]*
+func (p *parser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := rune(p.input[p.pos])
+		if c == '_' || unicode.IsLetter(c) || p.pos > start && unicode.IsDigit(c) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// accept пропускает tok, если выражение продолжается им
+func (p *parser) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(tok string) error {
+	if !p.accept(tok) {
+		return p.errorf("expected %q", tok)
+	}
+	return nil
+}
+
+func (p *parser) parse() (*Expr, error) {
+	e := &Expr{Func: p.ident()}
+	if _, ok := functions[e.Func]; !ok {
+		return nil, p.errorf("unknown function %q", e.Func)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if e.Field = p.ident(); e.Field == "" {
+		return nil, p.errorf("expected metric name")
+	}
+
+	if p.accept("{") {
+		for !p.accept("}") {
+			if len(e.Matchers) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			m, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			e.Matchers = append(e.Matchers, m)
+		}
+	}
+
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	end := strings.IndexByte(p.input[p.pos:], ']')
+	if end < 0 {
+		return nil, p.errorf("expected \"]\"")
+	}
+	window, err := parseWindow(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	e.Window = window
+	p.pos += end + 1
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		if p.ident() != "by" {
+			return nil, p.errorf("expected \"by\"")
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for !p.accept(")") {
+			if len(e.By) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			label := p.ident()
+			if !knownLabels[label] {
+				return nil, p.errorf("unknown label %q", label)
+			}
+			e.By = append(e.By, label)
+		}
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *parser) matcher() (Matcher, error) {
+	m := Matcher{Label: p.ident()}
+	if !knownLabels[m.Label] {
+		return m, p.errorf("unknown label %q", m.Label)
+	}
+	switch {
+	case p.accept("!="):
+	case p.accept("="):
+		m.Equal = true
+	default:
+		return m, p.errorf("expected \"=\" or \"!=\"")
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+		return m, p.errorf("expected quoted label value")
+	}
+	end := p.pos + 1
+	for end < len(p.input) && (p.input[end] != '"' || p.input[end-1] == '\\') {
+		end++
+	}
+	if end >= len(p.input) {
+		return m, p.errorf("unterminated string")
+	}
+	value, err := strconv.Unquote(p.input[p.pos : end+1])
+	if err != nil {
+		return m, p.errorf("invalid string %s", p.input[p.pos:end+1])
+	}
+	m.Value = value
+	p.pos = end + 1
+	return m, nil
+}
+
+// parseWindow разбирает длительность окна; кроме единиц time.ParseDuration
+// допускаются d (сутки) и w (неделя)
+func parseWindow(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d") || strings.HasSuffix(s, "w"):
+		unit := 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			unit *= 7
+		}
+		var n int
+		n, err = strconv.Atoi(s[:len(s)-1])
+		d = time.Duration(n) * unit
+	default:
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
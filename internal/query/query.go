@@ -0,0 +1,149 @@
+// Package query небольшой язык запросов в духе PromQL поверх агрегатов rollup:
+//
+//	avg_over_time(cpu[5m])
+//	max_over_time(rps{region="eu-west"}[1h]) by (device)
+//
+// Выражение без меток и группировки вычисляется по общим агрегатам всех уровней,
+// с метками или by — по рядам отдельных устройств (rollup.LabeledLevel)
+package query
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"highload-service/internal/rollup"
+)
+
+// ErrSyntax ошибка разбора выражения
+var ErrSyntax = errors.New("query syntax error")
+
+// functions функции над агрегатом окна
+var functions = map[string]func(rollup.Aggregate) float64{
+	"avg_over_time":   rollup.Aggregate.Avg,
+	"min_over_time":   func(a rollup.Aggregate) float64 { return a.Min },
+	"max_over_time":   func(a rollup.Aggregate) float64 { return a.Max },
+	"sum_over_time":   func(a rollup.Aggregate) float64 { return a.Sum },
+	"count_over_time": func(a rollup.Aggregate) float64 { return float64(a.Count) },
+}
+
+// knownLabels метки, по которым можно фильтровать и группировать
+var knownLabels = map[string]bool{rollup.LabelDevice: true, rollup.LabelRegion: true}
+
+// Source агрегаты, по которым вычисляются выражения (реализуется rollup.Rollup)
+type Source interface {
+	Aggregate(field string, from, to time.Time) (rollup.Aggregate, error)
+	AggregateByDevice(field string, from, to time.Time) ([]rollup.LabeledAggregate, error)
+}
+
+// Point значение выражения в момент Time
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series значения выражения для одного набора меток
+type Series struct {
+	Labels map[string]string `json:"labels"`
+	Points []Point           `json:"points"`
+}
+
+// Eval вычисляет выражение в момент at по окну [at-Window, at]
+func Eval(e *Expr, src Source, at time.Time) ([]Series, error) {
+	return EvalRange(e, src, at, at, 0)
+}
+
+// EvalRange вычисляет выражение в моменты from, from+step, ... до to включительно.
+// Ряды без значений в окне пропускаются
+func EvalRange(e *Expr, src Source, from, to time.Time, step time.Duration) ([]Series, error) {
+	fn := functions[e.Func]
+	byKey := make(map[string]*Series)
+	var order []string
+
+	for at := from; !at.After(to); at = at.Add(step) {
+		groups, err := evalAt(e, src, at)
+		if err != nil {
+			return nil, err
+		}
+		for key, g := range groups {
+			s, ok := byKey[key]
+			if !ok {
+				s = &Series{Labels: g.labels, Points: []Point{}}
+				byKey[key] = s
+				order = append(order, key)
+			}
+			s.Points = append(s.Points, Point{Time: at.UTC(), Value: fn(g.agg)})
+		}
+		if step <= 0 {
+			break
+		}
+	}
+
+	sort.Strings(order)
+	result := make([]Series, 0, len(order))
+	for _, key := range order {
+		result = append(result, *byKey[key])
+	}
+	return result, nil
+}
+
+// group агрегат одного набора меток by
+type group struct {
+	labels map[string]string
+	agg    rollup.Aggregate
+}
+
+func evalAt(e *Expr, src Source, at time.Time) (map[string]*group, error) {
+	from := at.Add(-e.Window)
+	if len(e.Matchers) == 0 && len(e.By) == 0 {
+		agg, err := src.Aggregate(e.Field, from, at)
+		if err != nil {
+			return nil, err
+		}
+		if agg.Count == 0 {
+			return nil, nil
+		}
+		return map[string]*group{"": {labels: map[string]string{}, agg: agg}}, nil
+	}
+
+	devices, err := src.AggregateByDevice(e.Field, from, at)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]*group)
+	for _, d := range devices {
+		if !matches(e.Matchers, d.Labels) {
+			continue
+		}
+		key, groupLabels := groupKey(e.By, d.Labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: groupLabels}
+			groups[key] = g
+		}
+		g.agg.Merge(d.Aggregate)
+	}
+	return groups, nil
+}
+
+func matches(matchers []Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupKey ключ и метки группы by для меток ряда
+func groupKey(by []string, labels map[string]string) (string, map[string]string) {
+	groupLabels := make(map[string]string, len(by))
+	parts := make([]string, len(by))
+	for i, label := range by {
+		groupLabels[label] = labels[label]
+		parts[i] = fmt.Sprintf("%s=%q", label, labels[label])
+	}
+	return strings.Join(parts, ","), groupLabels
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+	"highload-service/internal/rollup"
+)
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]string{
+		"avg_over_time(cpu[5m])": "avg_over_time(cpu[5m])",
+		` max_over_time( rps {region="eu-west", device!="s1"} [1d] ) `: `max_over_time(rps{region="eu-west", device!="s1"}[1d])`,
+		"count_over_time(cpu[90s]) by (region,device)":                 "count_over_time(cpu[1m30s]) by (region, device)",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if got := e.String(); got != want {
+			t.Errorf("%s: expected %s, got %s", in, want, got)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"avg(cpu[5m])",
+		"avg_over_time(cpu)",
+		"avg_over_time(cpu[0m])",
+		"avg_over_time(cpu{host=\"a\"}[5m])",
+		"avg_over_time(cpu{region=eu}[5m])",
+		"avg_over_time(cpu[5m]) by (host)",
+		"avg_over_time(cpu[5m]) without (device)",
+		"avg_over_time(cpu[5m]) by (device) extra",
+	} {
+		if _, err := Parse(in); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: expected syntax error, got %v", in, err)
+		}
+	}
+}
+
+func TestEval_GroupsDeviceSeries(t *testing.T) {
+	r := rollup.New()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		r.Observe(models.Metric{Timestamp: ts, DeviceID: "s1", Region: "eu-west", CPU: 10}, false)
+		r.Observe(models.Metric{Timestamp: ts, DeviceID: "s2", Region: "eu-west", CPU: 30}, false)
+		r.Observe(models.Metric{Timestamp: ts, DeviceID: "s3", Region: "us-east", CPU: float64(i)}, false)
+	}
+	at := base.Add(9*time.Minute + 30*time.Second)
+
+	eval := func(q string) []Series {
+		t.Helper()
+		e, err := Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := Eval(e, r, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	byRegion := eval("avg_over_time(cpu[5m]) by (region)")
+	if len(byRegion) != 2 || byRegion[0].Labels["region"] != "eu-west" || byRegion[0].Points[0].Value != 20 {
+		t.Fatalf("Unexpected by-region result %+v", byRegion)
+	}
+	// Minutes 5..9 of s3
+	if v := byRegion[1].Points[0].Value; v != 7 {
+		t.Errorf("Expected us-east average 7, got %v", v)
+	}
+
+	filtered := eval(`max_over_time(cpu{region="eu-west", device!="s2"}[5m])`)
+	if len(filtered) != 1 || filtered[0].Points[0].Value != 10 {
+		t.Errorf("Unexpected filtered result %+v", filtered)
+	}
+
+	total := eval("count_over_time(cpu[1h])")
+	if len(total) != 1 || total[0].Points[0].Value != 30 {
+		t.Errorf("Unexpected total %+v", total)
+	}
+
+	e, _ := Parse("sum_over_time(cpu[2m]) by (device)")
+	steps, err := EvalRange(e, r, base.Add(time.Minute), at, 4*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 || len(steps[0].Points) != 3 || steps[0].Points[0].Value != 20 {
+		t.Errorf("Unexpected range result %+v", steps)
+	}
+
+	if _, err := Eval(&Expr{Func: "avg_over_time", Field: "cpu", Window: 7 * time.Hour, By: []string{"device"}}, r, at); !errors.Is(err, rollup.ErrRangeTooLong) {
+		t.Errorf("Expected ErrRangeTooLong for device series, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// Package quota реализует квоты на прием метрик для каждого API-ключа.
+// Счетчики хранятся в Redis и переживают перезапуск, а все окна запроса
+// проверяются и учитываются одним скриптом Lua, поэтому квоты общие для всех
+// реплик и не превышаются при одновременных запросах
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+const (
+	// APIKeyHeader заголовок с API-ключом устройства
+	APIKeyHeader = "X-API-Key"
+	// AnonymousKey ключ, на который учитываются запросы без API-ключа
+	AnonymousKey = "anonymous"
+	// KeyPrefix префикс ключей квот в Redis
+	KeyPrefix = "quota:"
+)
+
+// Store атомарно учитывает запрос во всех окнах квоты, если ни одно из них не
+// исчерпано (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	ConsumeQuota(windows []cache.QuotaWindow) (used []int64, allowed bool, err error)
+}
+
+// Limits лимиты запросов на один API-ключ. Нулевое значение отключает лимит
+type Limits struct {
+	// Daily запросов за календарные сутки (UTC)
+	Daily int64
+	// Rolling запросов за окно RollingWindow
+	Rolling int64
+	// RollingWindow длина окна для Rolling (окна фиксированные, выровненные по времени)
+	RollingWindow time.Duration
+}
+
+// Enabled сообщает, задан ли хотя бы один лимит
+func (l Limits) Enabled() bool {
+	return l.Daily > 0 || (l.Rolling > 0 && l.RollingWindow > 0)
+}
+
+// Decision результат проверки квоты по самому строгому из лимитов
+type Decision struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Time
+	RetryAfter time.Duration
+}
+
+// Limiter проверяет и учитывает квоты. Лимиты можно менять на лету
+type Limiter struct {
+	store  Store
+	clock  clock.Clock
+	mu     sync.RWMutex
+	limits Limits
+}
+
+// NewLimiter создает ограничитель с заданными лимитами
+func NewLimiter(store Store, c clock.Clock, limits Limits) *Limiter {
+	if c == nil {
+		c = clock.Real()
+	}
+	return &Limiter{store: store, clock: c, limits: limits}
+}
+
+// Limits возвращает текущие лимиты
+func (l *Limiter) Limits() Limits {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.limits
+}
+
+// SetLimits заменяет лимиты; уже учтенные запросы в текущих окнах сохраняются
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+}
+
+// Allow учитывает один запрос для apiKey и сообщает, укладывается ли он в квоты.
+// Отклоненный запрос не учитывается ни в одном окне
+func (l *Limiter) Allow(apiKey string) (Decision, error) {
+	now := l.clock.Now().UTC()
+	limits := l.Limits()
+	decision := Decision{Allowed: true, Remaining: -1}
+
+	type window struct {
+		name  string
+		limit int64
+		start time.Time
+		end   time.Time
+	}
+	var windows []window
+	if limits.Daily > 0 {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		windows = append(windows, window{"daily", limits.Daily, start, start.AddDate(0, 0, 1)})
+	}
+	if limits.Rolling > 0 && limits.RollingWindow > 0 {
+		start := now.Truncate(limits.RollingWindow)
+		windows = append(windows, window{"rolling", limits.Rolling, start, start.Add(limits.RollingWindow)})
+	}
+	if len(windows) == 0 {
+		return decision, nil
+	}
+
+	stored := make([]cache.QuotaWindow, len(windows))
+	for i, w := range windows {
+		stored[i] = cache.QuotaWindow{
+			Key:   fmt.Sprintf("%s%s:%s:%d", KeyPrefix, apiKey, w.name, w.start.Unix()),
+			Limit: w.limit,
+			TTL:   w.end.Sub(now),
+		}
+	}
+	counts, allowed, err := l.store.ConsumeQuota(stored)
+	if err != nil {
+		return decision, fmt.Errorf("failed to update quota: %w", err)
+	}
+	decision.Allowed = allowed
+
+	for i, w := range windows {
+		used := counts[i]
+		remaining := w.limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		// В заголовках отражаем самый строгий лимит
+		if decision.Remaining < 0 || remaining < decision.Remaining {
+			decision.Limit = w.limit
+			decision.Remaining = remaining
+			decision.Reset = w.end
+		}
+		if !allowed && used >= w.limit {
+			if retry := w.end.Sub(now); retry > decision.RetryAfter {
+				decision.RetryAfter = retry
+				decision.Limit = w.limit
+				decision.Remaining = 0
+				decision.Reset = w.end
+			}
+		}
+	}
+	return decision, nil
+}
+
+// Middleware применяет квоты к запросам и выставляет заголовки X-Quota-*.
+// При ошибке хранилища запрос пропускается (fail-open), при отключенных лимитах заголовки не выставляются
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Limits().Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey := r.Header.Get(APIKeyHeader)
+		if apiKey == "" {
+			apiKey = AnonymousKey
+		}
+
+		decision, err := l.Allow(apiKey)
+		if err != nil {
+			log.Printf("Quota check failed, allowing request: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(decision.Limit, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(decision.Remaining, 10))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(decision.Reset.Unix(), 10))
+
+		if !decision.Allowed {
+			metrics.QuotaRejected.Inc()
+			// Округление вверх: клиент, выждавший Retry-After, не придет до конца окна
+			seconds := int64((decision.RetryAfter + time.Second - 1) / time.Second)
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Quota exceeded"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
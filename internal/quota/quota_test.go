@@ -0,0 +1,128 @@
+package quota
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func newTestLimiter(limits Limits) (*Limiter, *clock.Fake) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC))
+	return NewLimiter(cache.NewMemoryCache(clk), clk, limits), clk
+}
+
+func TestLimiter_RollingQuotaResets(t *testing.T) {
+	l, clk := newTestLimiter(Limits{Rolling: 2, RollingWindow: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if d, _ := l.Allow("key"); !d.Allowed {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+
+	d, _ := l.Allow("key")
+	if d.Allowed {
+		t.Fatal("Third request in the window should be rejected")
+	}
+	if d.RetryAfter != 30*time.Second {
+		t.Errorf("Expected retry after 30s (end of window), got %v", d.RetryAfter)
+	}
+
+	// Other keys are not affected
+	if d, _ := l.Allow("other"); !d.Allowed {
+		t.Error("Quota must be tracked per API key")
+	}
+
+	clk.Advance(30 * time.Second)
+	if d, _ := l.Allow("key"); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("Expected quota reset in the next window, got %+v", d)
+	}
+}
+
+func TestLimiter_ReportsTightestLimit(t *testing.T) {
+	l, _ := newTestLimiter(Limits{Daily: 100, Rolling: 5, RollingWindow: time.Minute})
+
+	d, _ := l.Allow("key")
+	if d.Limit != 5 || d.Remaining != 4 {
+		t.Errorf("Expected rolling limit 5 with 4 remaining, got %+v", d)
+	}
+}
+
+func TestMiddleware_HeadersAndRejection(t *testing.T) {
+	l, _ := newTestLimiter(Limits{Daily: 1})
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics", nil)
+	req.Header.Set(APIKeyHeader, "device-key")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("Expected 200 with 0 remaining, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "43170" {
+		t.Errorf("Expected Retry-After until midnight UTC, got %q", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMiddleware_RetryAfterRoundsUp(t *testing.T) {
+	l, clk := newTestLimiter(Limits{Rolling: 1, RollingWindow: time.Minute})
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodPost, "/metrics", nil)
+	req.Header.Set(APIKeyHeader, "device-key")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// 1.4s are left in the window: retrying after 1s would be rejected again
+	clk.Advance(28600 * time.Millisecond)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestLimiter_SetLimitsAtRuntime(t *testing.T) {
+	l, _ := newTestLimiter(Limits{})
+
+	if d, _ := l.Allow("key"); !d.Allowed || d.Remaining != -1 {
+		t.Fatalf("Expected unlimited decision, got %+v", d)
+	}
+
+	l.SetLimits(Limits{Rolling: 1, RollingWindow: time.Minute})
+	l.Allow("key")
+	if d, _ := l.Allow("key"); d.Allowed {
+		t.Errorf("Expected new limit to apply immediately, got %+v", d)
+	}
+}
+
+func TestLimiter_RejectedRequestsDoNotConsumeQuota(t *testing.T) {
+	l, clk := newTestLimiter(Limits{Daily: 3, Rolling: 1, RollingWindow: time.Minute})
+
+	l.Allow("key")
+	// Retries rejected by the rolling window must not eat into the daily quota
+	for i := 0; i < 5; i++ {
+		if d, _ := l.Allow("key"); d.Allowed {
+			t.Fatalf("Retry %d should be rejected by the rolling window", i)
+		}
+	}
+	clk.Advance(time.Minute)
+	if d, _ := l.Allow("key"); !d.Allowed || d.Remaining != 0 || d.Limit != 1 {
+		t.Errorf("Expected the second request allowed in the next window, got %+v", d)
+	}
+	clk.Advance(time.Minute)
+	if d, _ := l.Allow("key"); !d.Allowed {
+		t.Errorf("Expected the third daily request allowed, got %+v", d)
+	}
+}
@@ -0,0 +1,136 @@
+// Package regions агрегаты метрик и счетчики аномалий по географическим регионам
+package regions
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// Unknown регион метрик без поля region
+	Unknown = "unknown"
+	// Other регион, в который попадают метрики сверх лимита WithMaxRegions
+	Other = "other"
+	// DefaultMaxRegions лимит различных регионов по умолчанию
+	DefaultMaxRegions = 100
+)
+
+// Stats агрегаты региона
+type Stats struct {
+	Region     string             `json:"region"`
+	Metrics    int64              `json:"metrics"`
+	Anomalies  int64              `json:"anomalies"`
+	RollingAvg map[string]float64 `json:"rolling_avg"`
+	LastSeen   time.Time          `json:"last_seen"`
+}
+
+type region struct {
+	cpu, rps  *analytics.SlidingWindow
+	metrics   int64
+	anomalies int64
+	lastSeen  time.Time
+}
+
+// Option настраивает Aggregator
+type Option func(*Aggregator)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(a *Aggregator) {
+		a.clock = c
+	}
+}
+
+// WithMaxRegions ограничивает число различных регионов (и значений метки region в Prometheus);
+// метрики новых регионов сверх лимита учитываются в Other
+func WithMaxRegions(n int) Option {
+	return func(a *Aggregator) {
+		if n > 0 {
+			a.maxRegions = n
+		}
+	}
+}
+
+// Aggregator агрегаты всех регионов. Безопасен для конкурентного использования
+type Aggregator struct {
+	clock      clock.Clock
+	windowSize int
+	maxRegions int
+
+	mu      sync.Mutex
+	regions map[string]*region
+}
+
+// New создает агрегатор со скользящими окнами размера windowSize
+func New(windowSize int, opts ...Option) *Aggregator {
+	a := &Aggregator{
+		clock:      clock.Real(),
+		windowSize: windowSize,
+		maxRegions: DefaultMaxRegions,
+		regions:    make(map[string]*region),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Observe учитывает метрику и результат ее анализа в регионе метрики и возвращает
+// регион, в котором она учтена (с учетом Unknown и Other)
+func (a *Aggregator) Observe(m models.Metric, result models.AnalysisResult) string {
+	a.mu.Lock()
+	name := m.Region
+	if name == "" {
+		name = Unknown
+	}
+	r, ok := a.regions[name]
+	if !ok {
+		if len(a.regions) >= a.maxRegions {
+			name = Other
+			r = a.regions[Other]
+		}
+		if r == nil {
+			r = &region{cpu: analytics.NewSlidingWindow(a.windowSize), rps: analytics.NewSlidingWindow(a.windowSize)}
+			a.regions[name] = r
+		}
+	}
+	r.cpu.Add(m.CPU)
+	r.rps.Add(m.RPS)
+	r.metrics++
+	if result.AnomalyDetected {
+		r.anomalies++
+	}
+	r.lastSeen = a.clock.Now().UTC()
+	a.mu.Unlock()
+
+	metrics.RegionMetrics.WithLabelValues(name).Inc()
+	if result.AnomalyDetected {
+		metrics.RegionAnomalies.WithLabelValues(name).Inc()
+	}
+	return name
+}
+
+// List возвращает агрегаты регионов в порядке имен
+func (a *Aggregator) List() []Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]Stats, 0, len(a.regions))
+	for name, r := range a.regions {
+		list = append(list, Stats{
+			Region:     name,
+			Metrics:    r.metrics,
+			Anomalies:  r.anomalies,
+			RollingAvg: map[string]float64{"cpu": r.cpu.Mean(), "rps": r.rps.Mean()},
+			LastSeen:   r.lastSeen,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Region < list[j].Region })
+	return list
+}
@@ -0,0 +1,32 @@
+package regions
+
+import (
+	"testing"
+
+	"highload-service/internal/models"
+)
+
+func TestAggregator_PerRegionStats(t *testing.T) {
+	a := New(10, WithMaxRegions(2))
+	a.Observe(models.Metric{Region: "eu-west", CPU: 40, RPS: 100}, models.AnalysisResult{})
+	a.Observe(models.Metric{Region: "eu-west", CPU: 60, RPS: 300}, models.AnalysisResult{AnomalyDetected: true})
+	a.Observe(models.Metric{CPU: 10}, models.AnalysisResult{})
+	// The limit is reached: new regions are folded into "other"
+	a.Observe(models.Metric{Region: "us-east", CPU: 20}, models.AnalysisResult{AnomalyDetected: true})
+	a.Observe(models.Metric{Region: "ap-south", CPU: 30}, models.AnalysisResult{})
+
+	list := a.List()
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 regions, got %+v", list)
+	}
+	eu, other, unknown := list[0], list[1], list[2]
+	if eu.Region != "eu-west" || eu.Metrics != 2 || eu.Anomalies != 1 || eu.RollingAvg["cpu"] != 50 || eu.RollingAvg["rps"] != 200 {
+		t.Errorf("Unexpected eu-west stats %+v", eu)
+	}
+	if other.Region != Other || other.Metrics != 2 || other.Anomalies != 1 {
+		t.Errorf("Unexpected overflow stats %+v", other)
+	}
+	if unknown.Region != Unknown || unknown.Metrics != 1 {
+		t.Errorf("Unexpected stats for metrics without region %+v", unknown)
+	}
+}
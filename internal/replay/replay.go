@@ -0,0 +1,192 @@
+// Package replay ретроспективный анализ: прогон сохраненного диапазона метрик
+// через выбранную конфигурацию детектора.
+//
+// Прогон выполняется в отдельном анализаторе и не затрагивает рабочие окна,
+// трекер аномалий и метрики Prometheus, поэтому оповещения не отправляются.
+// Отчеты сохраняются под собственным префиксом KeyPrefix
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// KeyPrefix пространство имен отчетов в хранилище
+	KeyPrefix = "replay:"
+	// ReportTTL время хранения отчета
+	ReportTTL = 7 * 24 * time.Hour
+	// MaxFindings количество аномалий, сохраняемых в отчете; остальные только считаются
+	MaxFindings = 1000
+)
+
+var (
+	// ErrNotFound отчет не найден или истек
+	ErrNotFound = errors.New("replay report not found")
+	// ErrInvalidRequest некорректный диапазон времени или конфигурация детектора
+	ErrInvalidRequest = errors.New("invalid replay request")
+)
+
+// Archive источник сохраненных метрик (реализуется cache.Cache)
+type Archive interface {
+	GetMetricsRange(from, to time.Time) ([]models.Metric, error)
+}
+
+// Store хранилище отчетов (реализуется cache.Cache)
+type Store interface {
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+	Get(key string, dest interface{}) error
+}
+
+// Request параметры прогона
+type Request struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// DeviceID ограничивает прогон одним устройством; пустое значение — все устройства
+	DeviceID string `json:"device_id,omitempty"`
+	// Detector конфигурация детектора; если не задана, используется заданная WithDetector
+	Detector *analytics.DetectorConfig `json:"detector,omitempty"`
+}
+
+// Finding аномалия, которую обнаружил бы детектор
+type Finding struct {
+	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
+	CPU       float64   `json:"cpu"`
+	RPS       float64   `json:"rps"`
+	ZScoreCPU float64   `json:"z_score_cpu"`
+	ZScoreRPS float64   `json:"z_score_rps"`
+}
+
+// Report итог прогона
+type Report struct {
+	ID        string                   `json:"id"`
+	From      time.Time                `json:"from"`
+	To        time.Time                `json:"to"`
+	DeviceID  string                   `json:"device_id,omitempty"`
+	Detector  analytics.DetectorConfig `json:"detector"`
+	CreatedAt time.Time                `json:"created_at"`
+	Metrics   int                      `json:"metrics"`
+	Anomalies int                      `json:"anomalies"`
+	Findings  []Finding                `json:"findings"`
+	// Truncated в Findings попали только первые MaxFindings аномалий
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Option настраивает Replayer
+type Option func(*Replayer)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(r *Replayer) {
+		r.clock = c
+	}
+}
+
+// WithDetector задает конфигурацию детектора для запросов без явной конфигурации
+func WithDetector(c analytics.DetectorConfig) Option {
+	return func(r *Replayer) {
+		r.detector = c
+	}
+}
+
+// Replayer выполняет прогоны и хранит их отчеты
+type Replayer struct {
+	archive  Archive
+	store    Store
+	clock    clock.Clock
+	detector analytics.DetectorConfig
+	seq      atomic.Int64
+}
+
+// New создает Replayer, читающий метрики из archive и сохраняющий отчеты в store
+func New(archive Archive, store Store, opts ...Option) *Replayer {
+	r := &Replayer{
+		archive:  archive,
+		store:    store,
+		clock:    clock.Real(),
+		detector: analytics.DefaultDetectorConfig(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run прогоняет диапазон req через детектор и сохраняет отчет
+func (r *Replayer) Run(req Request) (Report, error) {
+	if req.From.IsZero() || req.To.IsZero() || !req.To.After(req.From) {
+		return Report{}, fmt.Errorf("%w: from must be before to", ErrInvalidRequest)
+	}
+	detector := r.detector
+	if req.Detector != nil {
+		detector = *req.Detector
+	}
+	if err := detector.Validate(); err != nil {
+		return Report{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	metrics, err := r.archive.GetMetricsRange(req.From, req.To)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to read archived metrics: %w", err)
+	}
+
+	now := r.clock.Now().UTC()
+	report := Report{
+		ID:        fmt.Sprintf("%d-%d", now.Unix(), r.seq.Add(1)),
+		From:      req.From.UTC(),
+		To:        req.To.UTC(),
+		DeviceID:  req.DeviceID,
+		Detector:  detector,
+		CreatedAt: now,
+		Findings:  []Finding{},
+	}
+
+	analyzer := analytics.NewAnalyzer(1, analytics.WithDetectorConfig(detector), analytics.WithClock(r.clock))
+	defer analyzer.Stop()
+	for _, m := range metrics {
+		if req.DeviceID != "" && m.DeviceID != req.DeviceID {
+			continue
+		}
+		report.Metrics++
+		result := analyzer.AnalyzeSync(m)
+		if !result.AnomalyDetected {
+			continue
+		}
+		report.Anomalies++
+		if len(report.Findings) == MaxFindings {
+			report.Truncated = true
+			continue
+		}
+		report.Findings = append(report.Findings, Finding{
+			Timestamp: m.Timestamp,
+			DeviceID:  m.DeviceID,
+			CPU:       m.CPU,
+			RPS:       m.RPS,
+			ZScoreCPU: result.ZScoreCPU,
+			ZScoreRPS: result.ZScoreRPS,
+		})
+	}
+
+	if err := r.store.SetWithTTL(KeyPrefix+report.ID, report, ReportTTL); err != nil {
+		return Report{}, fmt.Errorf("failed to store replay report: %w", err)
+	}
+	return report, nil
+}
+
+// Get возвращает сохраненный отчет
+func (r *Replayer) Get(id string) (Report, error) {
+	var report Report
+	err := r.store.Get(KeyPrefix+id, &report)
+	if errors.Is(err, cache.ErrNotFound) {
+		return Report{}, ErrNotFound
+	}
+	return report, err
+}
@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+func TestReplayer_RunStoresReportSeparately(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := cache.NewMemoryCache(clk)
+	base := clk.Now().Add(-30 * time.Minute)
+	for i := 0; i < 30; i++ {
+		cpu := 40 + float64(i%3)
+		if i == 25 {
+			cpu = 90
+		}
+		_ = store.CacheMetric(models.Metric{Timestamp: base.Add(time.Duration(i) * time.Second), DeviceID: "s1", CPU: cpu, RPS: 100})
+	}
+	_ = store.CacheMetric(models.Metric{Timestamp: base.Add(10 * time.Second).Add(time.Millisecond), DeviceID: "s2", CPU: 500})
+
+	r := New(store, store, WithClock(clk))
+	report, err := r.Run(Request{
+		From:     base,
+		To:       base.Add(time.Minute),
+		DeviceID: "s1",
+		Detector: &analytics.DetectorConfig{WindowSize: 20, ZScoreThreshold: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Metrics != 30 || report.Anomalies != 1 || len(report.Findings) != 1 || report.Findings[0].CPU != 90 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	stored, err := r.Get(report.ID)
+	if err != nil || stored.Anomalies != 1 || stored.Detector.WindowSize != 20 {
+		t.Errorf("Expected stored report, got %+v (err %v)", stored, err)
+	}
+	var raw Report
+	if err := store.Get(KeyPrefix+report.ID, &raw); err != nil {
+		t.Errorf("Expected report under %s prefix: %v", KeyPrefix, err)
+	}
+
+	if _, err := r.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReplayer_RejectsInvalidRequest(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	r := New(store, store)
+	now := time.Now()
+
+	for _, req := range []Request{
+		{From: now, To: now.Add(-time.Minute)},
+		{To: now},
+		{From: now.Add(-time.Minute), To: now, Detector: &analytics.DetectorConfig{WindowSize: 1, ZScoreThreshold: 2}},
+	} {
+		if _, err := r.Run(req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%+v: expected ErrInvalidRequest, got %v", req, err)
+		}
+	}
+}
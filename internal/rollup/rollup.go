@@ -0,0 +1,364 @@
+// Package rollup агрегирует сырые метрики в интервалы фиксированной длины (1m, 5m, 1h),
+// чтобы графики строились по сотням готовых точек, а не по тысячам сырых значений
+package rollup
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+const (
+	// FieldCPU загрузка CPU
+	FieldCPU = "cpu"
+	// FieldRPS запросы в секунду
+	FieldRPS = "rps"
+)
+
+var (
+	// ErrUnknownField запрошена метрика, которая не агрегируется
+	ErrUnknownField = errors.New("unknown metric")
+	// ErrUnknownResolution запрошено разрешение, для которого нет уровня агрегации
+	ErrUnknownResolution = errors.New("unsupported resolution")
+	// ErrRangeTooLong диапазон превышает время хранения уровня
+	ErrRangeTooLong = errors.New("range exceeds retention of the resolution")
+)
+
+// Level уровень агрегации: длина интервала и сколько интервалов хранится
+type Level struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// LabeledLevel уровень рядов отдельных устройств: только 1m за 6 часов, чтобы память
+// росла с числом устройств умеренно
+var LabeledLevel = Level{Resolution: time.Minute, Retention: 6 * time.Hour}
+
+const (
+	// LabelDevice метка ряда устройства
+	LabelDevice = "device"
+	// LabelRegion метка региона устройства
+	LabelRegion = "region"
+	// MaxLabeledSeries предел числа рядов устройств; метрики новых устройств сверх него
+	// попадают только в общие агрегаты
+	MaxLabeledSeries = 10000
+)
+
+// DefaultLevels уровни 1m за сутки, 5m за неделю и 1h за 30 дней
+func DefaultLevels() []Level {
+	return []Level{
+		{Resolution: time.Minute, Retention: 24 * time.Hour},
+		{Resolution: 5 * time.Minute, Retention: 7 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 30 * 24 * time.Hour},
+	}
+}
+
+// FormatResolution форматирует разрешение так, как оно задается в запросе (1m, 5m, 1h)
+func FormatResolution(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// bucket агрегат одного интервала; index — номер интервала от начала эпохи
+type bucket struct {
+	index     int64
+	count     int64
+	anomalies int64
+	sum       float64
+	min       float64
+	max       float64
+}
+
+// observe добавляет значение в непустой агрегат
+func (b *bucket) observe(v float64, anomaly bool) {
+	b.count++
+	b.sum += v
+	if anomaly {
+		b.anomalies++
+	}
+	if v < b.min {
+		b.min = v
+	}
+	if v > b.max {
+		b.max = v
+	}
+}
+
+// point агрегат как точка ряда
+func (b *bucket) point(res time.Duration) models.SeriesPoint {
+	return models.SeriesPoint{
+		Time:      time.Unix(0, b.index*int64(res)).UTC(),
+		Avg:       b.sum / float64(b.count),
+		Min:       b.min,
+		Max:       b.max,
+		Count:     b.count,
+		Anomalies: b.anomalies,
+	}
+}
+
+// Aggregate агрегат значений за период из нескольких интервалов
+type Aggregate struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Avg среднее значение за период
+func (a Aggregate) Avg() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Merge добавляет к агрегату другой агрегат
+func (a *Aggregate) Merge(b Aggregate) {
+	if b.Count == 0 {
+		return
+	}
+	if a.Count == 0 || b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if a.Count == 0 || b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Count += b.Count
+	a.Sum += b.Sum
+}
+
+// LabeledAggregate агрегат ряда одного устройства
+type LabeledAggregate struct {
+	Labels map[string]string
+	Aggregate
+}
+
+// ring кольцевой буфер интервалов одного уровня: интервал с номером i
+// хранится в ячейке i % len(slots) и вытесняет более старый
+type ring struct {
+	level Level
+	slots []bucket
+}
+
+func newRing(level Level) *ring {
+	n := int(level.Retention / level.Resolution)
+	if n < 1 {
+		n = 1
+	}
+	return &ring{level: level, slots: make([]bucket, n)}
+}
+
+// add добавляет значение в интервал времени t и возвращает его номер;
+// false — интервал уже вытеснен и значение не учтено
+func (r *ring) add(t time.Time, v float64, anomaly bool) (int64, bool) {
+	idx := t.UnixNano() / int64(r.level.Resolution)
+	b := &r.slots[idx%int64(len(r.slots))]
+	switch {
+	case b.count > 0 && b.index > idx:
+		// Ячейку уже занял более новый интервал, опоздавшее значение не хранится
+		return idx, false
+	case b.count == 0 || b.index < idx:
+		*b = bucket{index: idx, min: v, max: v}
+	}
+	b.observe(v, anomaly)
+	return idx, true
+}
+
+func (r *ring) points(from, to time.Time) []models.SeriesPoint {
+	res := int64(r.level.Resolution)
+	points := []models.SeriesPoint{}
+	for idx := from.UnixNano() / res; idx <= to.UnixNano()/res; idx++ {
+		b := r.slots[idx%int64(len(r.slots))]
+		if b.count == 0 || b.index != idx {
+			continue
+		}
+		points = append(points, b.point(r.level.Resolution))
+	}
+	return points
+}
+
+// aggregate сворачивает интервалы, начинающиеся внутри [from, to]
+func (r *ring) aggregate(from, to time.Time) Aggregate {
+	res := int64(r.level.Resolution)
+	first := from.UnixNano() / res
+	if first*res < from.UnixNano() {
+		first++
+	}
+	var agg Aggregate
+	for idx := first; idx <= to.UnixNano()/res; idx++ {
+		b := r.slots[idx%int64(len(r.slots))]
+		if b.count == 0 || b.index != idx {
+			continue
+		}
+		agg.Merge(Aggregate{Count: b.count, Sum: b.sum, Min: b.min, Max: b.max})
+	}
+	return agg
+}
+
+// labeledSeries ряды CPU и RPS одного устройства
+type labeledSeries struct {
+	labels map[string]string
+	rings  map[string]*ring
+}
+
+// Rollup агрегаты CPU и RPS на всех уровнях, а также ряды отдельных устройств
+// на уровне LabeledLevel. Безопасен для конкурентного использования
+type Rollup struct {
+	mu      sync.RWMutex
+	levels  []Level
+	series  map[string][]*ring
+	devices map[string]*labeledSeries
+
+	// store хранилище общих агрегатов; pending изменения, еще не слитые с ним
+	store   Store
+	pending map[pendingKey]*bucket
+}
+
+// New создает агрегатор с заданными уровнями; без уровней используются DefaultLevels
+func New(levels ...Level) *Rollup {
+	if len(levels) == 0 {
+		levels = DefaultLevels()
+	}
+	r := &Rollup{levels: levels, series: make(map[string][]*ring), devices: make(map[string]*labeledSeries)}
+	for _, field := range []string{FieldCPU, FieldRPS} {
+		for _, level := range levels {
+			r.series[field] = append(r.series[field], newRing(level))
+		}
+	}
+	return r
+}
+
+// Levels возвращает уровни агрегации от мелкого к крупному
+func (r *Rollup) Levels() []Level {
+	return append([]Level(nil), r.levels...)
+}
+
+// Observe добавляет метрику во все уровни; anomaly — в метрике найдена аномалия
+func (r *Rollup) Observe(m models.Metric, anomaly bool) {
+	if m.Timestamp.UnixNano() < 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.observe(FieldCPU, m.Timestamp, m.CPU, anomaly)
+	r.observe(FieldRPS, m.Timestamp, m.RPS, anomaly)
+
+	if m.DeviceID == "" {
+		return
+	}
+	d, ok := r.devices[m.DeviceID]
+	if !ok {
+		if len(r.devices) >= MaxLabeledSeries {
+			return
+		}
+		d = &labeledSeries{rings: map[string]*ring{FieldCPU: newRing(LabeledLevel), FieldRPS: newRing(LabeledLevel)}}
+		r.devices[m.DeviceID] = d
+	}
+	if m.Region != "" && d.labels[LabelRegion] != m.Region || d.labels == nil {
+		// Метки заменяются целиком: прежняя карта может читаться после AggregateByDevice
+		d.labels = map[string]string{LabelDevice: m.DeviceID}
+		if m.Region != "" {
+			d.labels[LabelRegion] = m.Region
+		}
+	}
+	d.rings[FieldCPU].add(m.Timestamp, m.CPU, anomaly)
+	d.rings[FieldRPS].add(m.Timestamp, m.RPS, anomaly)
+}
+
+// observe добавляет значение в общие интервалы field; вызывается под блокировкой
+func (r *Rollup) observe(field string, t time.Time, v float64, anomaly bool) {
+	for level, ring := range r.series[field] {
+		idx, ok := ring.add(t, v, anomaly)
+		if ok && r.store != nil {
+			r.addPending(pendingKey{field: field, level: level, index: idx}, v, anomaly)
+		}
+	}
+}
+
+// Resolve выбирает самый подробный уровень, который хранит span целиком
+// и отдает не больше maxPoints точек
+func (r *Rollup) Resolve(span time.Duration, maxPoints int) (time.Duration, error) {
+	for _, level := range r.levels {
+		if span <= level.Retention && span/level.Resolution <= time.Duration(maxPoints) {
+			return level.Resolution, nil
+		}
+	}
+	return 0, ErrRangeTooLong
+}
+
+// Series возвращает непустые интервалы метрики field с разрешением resolution,
+// пересекающиеся с [from, to], от старых к новым. С хранилищем интервалы
+// читаются из него и охватывают все реплики и время до перезапуска
+func (r *Rollup) Series(field string, resolution time.Duration, from, to time.Time) ([]models.SeriesPoint, error) {
+	rings, ok := r.series[field]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownField, field)
+	}
+	for level, ring := range rings {
+		if ring.level.Resolution != resolution {
+			continue
+		}
+		if to.Sub(from) > ring.level.Retention {
+			return nil, ErrRangeTooLong
+		}
+		if r.store != nil {
+			return r.storedSeries(field, level, from, to), nil
+		}
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return ring.points(from, to), nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownResolution, FormatResolution(resolution))
+}
+
+// Aggregate сворачивает общие интервалы метрики field, начинающиеся внутри [from, to].
+// Используется самый подробный уровень, который хранит период целиком
+func (r *Rollup) Aggregate(field string, from, to time.Time) (Aggregate, error) {
+	rings, ok := r.series[field]
+	if !ok {
+		return Aggregate{}, fmt.Errorf("%w %q", ErrUnknownField, field)
+	}
+	for _, ring := range rings {
+		if to.Sub(from) > ring.level.Retention {
+			continue
+		}
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return ring.aggregate(from, to), nil
+	}
+	return Aggregate{}, ErrRangeTooLong
+}
+
+// AggregateByDevice сворачивает ряды устройств за период [from, to];
+// устройства без значений в периоде не возвращаются
+func (r *Rollup) AggregateByDevice(field string, from, to time.Time) ([]LabeledAggregate, error) {
+	if _, ok := r.series[field]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownField, field)
+	}
+	if to.Sub(from) > LabeledLevel.Retention {
+		return nil, ErrRangeTooLong
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]LabeledAggregate, 0, len(r.devices))
+	for _, d := range r.devices {
+		agg := d.rings[field].aggregate(from, to)
+		if agg.Count == 0 {
+			continue
+		}
+		list = append(list, LabeledAggregate{Labels: d.labels, Aggregate: agg})
+	}
+	return list, nil
+}
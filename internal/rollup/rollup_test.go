@@ -0,0 +1,77 @@
+package rollup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/models"
+)
+
+func TestRollup_AggregatesIntoLevels(t *testing.T) {
+	r := New()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Ten minutes of samples every 10 seconds, CPU equal to the minute number
+	for i := 0; i < 60; i++ {
+		ts := base.Add(time.Duration(i) * 10 * time.Second)
+		r.Observe(models.Metric{Timestamp: ts, CPU: float64(ts.Minute()), RPS: 100}, false)
+	}
+
+	minutes, err := r.Series(FieldCPU, time.Minute, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minutes) != 10 {
+		t.Fatalf("Expected 10 one-minute points, got %d", len(minutes))
+	}
+	if p := minutes[3]; !p.Time.Equal(base.Add(3*time.Minute)) || p.Avg != 3 || p.Count != 6 {
+		t.Errorf("Unexpected point %+v", p)
+	}
+
+	fives, _ := r.Series(FieldCPU, 5*time.Minute, base, base.Add(time.Hour))
+	if len(fives) != 2 || fives[0].Min != 0 || fives[0].Max != 4 || fives[0].Avg != 2 || fives[1].Count != 30 {
+		t.Errorf("Unexpected 5m points %+v", fives)
+	}
+
+	if _, err := r.Series("memory", time.Minute, base, base); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Expected ErrUnknownField, got %v", err)
+	}
+	if _, err := r.Series(FieldCPU, 2*time.Minute, base, base); !errors.Is(err, ErrUnknownResolution) {
+		t.Errorf("Expected ErrUnknownResolution, got %v", err)
+	}
+}
+
+func TestRollup_OldIntervalsAreEvicted(t *testing.T) {
+	r := New(Level{Resolution: time.Minute, Retention: 10 * time.Minute})
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	r.Observe(models.Metric{Timestamp: base, CPU: 1}, false)
+	r.Observe(models.Metric{Timestamp: base.Add(10 * time.Minute), CPU: 2}, false)
+	// Late sample for the evicted interval must not overwrite the newer one
+	r.Observe(models.Metric{Timestamp: base.Add(30 * time.Second), CPU: 3}, false)
+
+	points, _ := r.Series(FieldCPU, time.Minute, base, base.Add(10*time.Minute))
+	if len(points) != 1 || points[0].Avg != 2 {
+		t.Errorf("Expected only the newer interval to survive, got %+v", points)
+	}
+}
+
+func TestRollup_Resolve(t *testing.T) {
+	r := New()
+	for _, c := range []struct {
+		span time.Duration
+		want time.Duration
+	}{
+		{6 * time.Hour, time.Minute},
+		{24 * time.Hour, 5 * time.Minute},
+		{7 * 24 * time.Hour, time.Hour},
+	} {
+		if got, err := r.Resolve(c.span, 1000); err != nil || got != c.want {
+			t.Errorf("span %s: expected %s, got %s (%v)", c.span, c.want, got, err)
+		}
+	}
+	if _, err := r.Resolve(90*24*time.Hour, 1000); !errors.Is(err, ErrRangeTooLong) {
+		t.Errorf("Expected ErrRangeTooLong, got %v", err)
+	}
+}
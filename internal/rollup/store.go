@@ -0,0 +1,186 @@
+package rollup
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/models"
+)
+
+const (
+	// KeyPrefix префикс хешей интервалов: rollup:<метрика>:<разрешение>:<номер интервала>
+	KeyPrefix = "rollup:"
+	// DefaultFlushInterval период слияния накопленных изменений с хранилищем
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Store хранилище агрегатов интервалов (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	MergeRollups(buckets []cache.RollupBucket) error
+	GetRollups(keys []string) ([]cache.RollupBucket, error)
+}
+
+// pendingKey интервал общего ряда: метрика, номер уровня и номер интервала
+type pendingKey struct {
+	field string
+	level int
+	index int64
+}
+
+// NewPersistent создает агрегатор, который сливает общие интервалы с хранилищем.
+// Агрегаты копятся в памяти и сливаются методом Flush (в фоне — Run); слияние
+// складывает счетчики, поэтому реплики пишут в одни и те же интервалы, а ряды
+// переживают перезапуск. Хеш интервала живет столько же, сколько хранится его уровень.
+// Ряды отдельных устройств остаются в памяти реплики
+func NewPersistent(store Store, levels ...Level) *Rollup {
+	r := New(levels...)
+	r.store = store
+	r.pending = make(map[pendingKey]*bucket)
+	return r
+}
+
+// key имя хеша интервала
+func (r *Rollup) key(k pendingKey) string {
+	return KeyPrefix + k.field + ":" + FormatResolution(r.levels[k.level].Resolution) + ":" + strconv.FormatInt(k.index, 10)
+}
+
+// addPending учитывает значение в изменениях интервала; вызывается под блокировкой
+func (r *Rollup) addPending(k pendingKey, v float64, anomaly bool) {
+	b, ok := r.pending[k]
+	if !ok {
+		b = &bucket{index: k.index, min: v, max: v}
+		r.pending[k] = b
+	}
+	b.observe(v, anomaly)
+}
+
+// merge добавляет к агрегату другой агрегат того же интервала
+func (b *bucket) merge(o bucket) {
+	if o.count == 0 {
+		return
+	}
+	if b.count == 0 || o.min < b.min {
+		b.min = o.min
+	}
+	if b.count == 0 || o.max > b.max {
+		b.max = o.max
+	}
+	b.count += o.count
+	b.anomalies += o.anomalies
+	b.sum += o.sum
+}
+
+// Flush сливает накопленные изменения с хранилищем. При ошибке изменения
+// возвращаются в накопитель и сливаются следующим вызовом
+func (r *Rollup) Flush() error {
+	if r.store == nil {
+		return nil
+	}
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = make(map[pendingKey]*bucket, len(batch))
+	r.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	buckets := make([]cache.RollupBucket, 0, len(batch))
+	for k, b := range batch {
+		buckets = append(buckets, cache.RollupBucket{
+			Key:       r.key(k),
+			Count:     b.count,
+			Anomalies: b.anomalies,
+			Sum:       b.sum,
+			Min:       b.min,
+			Max:       b.max,
+			TTL:       r.levels[k.level].Retention,
+		})
+	}
+
+	err := r.store.MergeRollups(buckets)
+	if err != nil {
+		r.mu.Lock()
+		for k, b := range batch {
+			if cur, ok := r.pending[k]; ok {
+				b.merge(*cur)
+			}
+			r.pending[k] = b
+		}
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// Run сливает изменения каждые interval до отмены ctx, после чего
+// выполняет последнее слияние
+func (r *Rollup) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Flush(); err != nil {
+				log.Printf("Failed to flush rollups on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Printf("Failed to flush rollups: %v", err)
+			}
+		}
+	}
+}
+
+// storedSeries читает интервалы уровня из хранилища вместе с еще не слитыми
+// изменениями этой реплики. Если хранилище недоступно, отдаются интервалы реплики
+func (r *Rollup) storedSeries(field string, level int, from, to time.Time) []models.SeriesPoint {
+	ring := r.series[field][level]
+	res := int64(ring.level.Resolution)
+	first, last := from.UnixNano()/res, to.UnixNano()/res
+	keys := make([]string, 0, last-first+1)
+	index := make(map[string]int64, last-first+1)
+	for idx := first; idx <= last; idx++ {
+		key := r.key(pendingKey{field: field, level: level, index: idx})
+		keys = append(keys, key)
+		index[key] = idx
+	}
+
+	stored, err := r.store.GetRollups(keys)
+	if err != nil {
+		log.Printf("Failed to read rollups, serving local intervals: %v", err)
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return ring.points(from, to)
+	}
+	buckets := make(map[int64]*bucket, len(stored))
+	for _, s := range stored {
+		idx := index[s.Key]
+		buckets[idx] = &bucket{index: idx, count: s.Count, anomalies: s.Anomalies, sum: s.Sum, min: s.Min, max: s.Max}
+	}
+
+	r.mu.RLock()
+	for idx := first; idx <= last; idx++ {
+		p, ok := r.pending[pendingKey{field: field, level: level, index: idx}]
+		if !ok {
+			continue
+		}
+		if b, ok := buckets[idx]; ok {
+			b.merge(*p)
+		} else {
+			cp := *p
+			buckets[idx] = &cp
+		}
+	}
+	r.mu.RUnlock()
+
+	points := []models.SeriesPoint{}
+	for idx := first; idx <= last; idx++ {
+		if b, ok := buckets[idx]; ok {
+			points = append(points, b.point(ring.level.Resolution))
+		}
+	}
+	return points
+}
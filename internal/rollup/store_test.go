@@ -0,0 +1,51 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/models"
+)
+
+func TestRollup_PersistsIntervalsAcrossReplicas(t *testing.T) {
+	store := cache.NewMemoryCache(nil)
+	a, b := NewPersistent(store), NewPersistent(store)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	a.Observe(models.Metric{Timestamp: base, CPU: 10}, false)
+	a.Observe(models.Metric{Timestamp: base.Add(10 * time.Second), CPU: 90}, true)
+	b.Observe(models.Metric{Timestamp: base.Add(20 * time.Second), CPU: 5}, false)
+	b.Observe(models.Metric{Timestamp: base.Add(time.Minute), CPU: 50}, true)
+
+	// Unflushed changes of a replica are already visible to its own reads
+	points, _ := a.Series(FieldCPU, time.Minute, base, base.Add(time.Hour))
+	if len(points) != 1 || points[0].Count != 2 || points[0].Anomalies != 1 {
+		t.Fatalf("Expected the local interval before flush, got %+v", points)
+	}
+
+	for _, r := range []*Rollup{a, b} {
+		if err := r.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	b.Observe(models.Metric{Timestamp: base.Add(30 * time.Second), CPU: 1}, false)
+
+	// A restarted replica reads intervals merged from both replicas
+	restarted := NewPersistent(store)
+	points, _ = restarted.Series(FieldCPU, time.Minute, base, base.Add(time.Hour))
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 stored intervals, got %+v", points)
+	}
+	if p := points[0]; p.Count != 3 || p.Min != 5 || p.Max != 90 || p.Avg != 35 || p.Anomalies != 1 {
+		t.Errorf("Unexpected merged interval %+v", p)
+	}
+	if p := points[1]; !p.Time.Equal(base.Add(time.Minute)) || p.Count != 1 || p.Anomalies != 1 {
+		t.Errorf("Unexpected second interval %+v", p)
+	}
+
+	hours, _ := b.Series(FieldCPU, time.Hour, base, base.Add(time.Hour))
+	if len(hours) != 1 || hours[0].Count != 5 || hours[0].Min != 1 || hours[0].Anomalies != 2 {
+		t.Errorf("Expected the hour to include unflushed changes of the replica, got %+v", hours)
+	}
+}
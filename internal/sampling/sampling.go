@@ -0,0 +1,263 @@
+// Package sampling адаптивное прореживание сохраняемых сырых метрик.
+//
+// Пока поток метрик тенанта не превышает целевой скорости, сохраняются все
+// метрики. Выше нее сохраняется каждая N-я обычная метрика, где N растет вместе
+// со скоростью, поэтому объем хранилища растет медленнее трафика. Аномальные
+// метрики сохраняются всегда вместе с Neighbors метриками устройства до и после
+// них, так что инциденты записываются полностью. Анализ получает все метрики:
+// прореживается только хранение
+package sampling
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/devices"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+const (
+	// MaxNeighbors наибольшее количество соседей аномалии с каждой стороны
+	MaxNeighbors = 100
+	// deviceIdleTTL через сколько забываются соседи молчащего устройства
+	deviceIdleTTL = 10 * time.Minute
+)
+
+// Policy правило прореживания
+type Policy struct {
+	// TargetRate сохраняемых обычных метрик в секунду на реплику; при большей
+	// скорости сохраняется 1 из ceil(скорость/TargetRate). 0 — сохранять все
+	TargetRate float64 `json:"target_rate"`
+	// Neighbors сколько метрик устройства до и после аномалии сохраняются всегда
+	Neighbors int `json:"neighbors,omitempty"`
+}
+
+// Config правило по умолчанию и правила тенантов из реестра устройств
+type Config struct {
+	Default Policy            `json:"default"`
+	Tenants map[string]Policy `json:"tenants,omitempty"`
+}
+
+// ParseConfig разбирает JSON-документ правил (значение SAMPLING_POLICY)
+func ParseConfig(raw string) (Config, error) {
+	var c Config
+	if raw == "" {
+		return c, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// Validate проверяет правила
+func (c Config) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for tenant, p := range c.Tenants {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+func (p Policy) validate() error {
+	if !(p.TargetRate >= 0) || math.IsInf(p.TargetRate, 0) {
+		return fmt.Errorf("target rate must be non-negative and finite, got %v", p.TargetRate)
+	}
+	if p.Neighbors < 0 || p.Neighbors > MaxNeighbors {
+		return fmt.Errorf("neighbors must be within [0, %d], got %d", MaxNeighbors, p.Neighbors)
+	}
+	return nil
+}
+
+// Enabled сообщает, прореживает ли хоть одно правило
+func (c Config) Enabled() bool {
+	if c.Default.TargetRate > 0 {
+		return true
+	}
+	for _, p := range c.Tenants {
+		if p.TargetRate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Directory реестр устройств для определения тенанта (реализуется devices.Registry)
+type Directory interface {
+	Get(id string) (devices.Device, bool)
+}
+
+// Option настраивает Sampler
+type Option func(*Sampler)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(s *Sampler) {
+		s.clock = c
+	}
+}
+
+// WithDirectory задает реестр устройств; без него действует правило по умолчанию
+func WithDirectory(d Directory) Option {
+	return func(s *Sampler) {
+		s.directory = d
+	}
+}
+
+// tenantState скорость потока тенанта и счетчик прореживания
+type tenantState struct {
+	second int64
+	count  int
+	// rate количество метрик за последнюю полную секунду
+	rate int
+	seq  uint64
+}
+
+// neighbor недавняя метрика устройства
+type neighbor struct {
+	metric models.Metric
+	stored bool
+}
+
+// deviceState соседи устройства: последние метрики и сколько еще сохранить после аномалии
+type deviceState struct {
+	recent   []neighbor
+	after    int
+	lastSeen time.Time
+}
+
+// Sampler решает, какие метрики сохранять. Безопасен для конкурентного использования
+type Sampler struct {
+	cfg       Config
+	directory Directory
+	clock     clock.Clock
+
+	mu        sync.Mutex
+	tenants   map[string]*tenantState
+	devices   map[string]*deviceState
+	lastSweep time.Time
+}
+
+// New создает Sampler с правилами cfg
+func New(cfg Config, opts ...Option) *Sampler {
+	s := &Sampler{
+		cfg:     cfg,
+		clock:   clock.Real(),
+		tenants: make(map[string]*tenantState),
+		devices: make(map[string]*deviceState),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Keep учитывает проанализированную метрику m и возвращает метрики, которые
+// нужно сохранить: m, если она выбрана или аномальна, и еще не сохраненных
+// соседей аномалии
+func (s *Sampler) Keep(m models.Metric, anomaly bool) []models.Metric {
+	tenant := ""
+	if s.directory != nil && m.DeviceID != "" {
+		if d, ok := s.directory.Get(m.DeviceID); ok {
+			tenant = d.Tenant
+		}
+	}
+	policy, ok := s.cfg.Tenants[tenant]
+	if !ok {
+		policy = s.cfg.Default
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) >= deviceIdleTTL {
+		s.sweep(now)
+	}
+
+	sampled := s.sample(tenant, policy, now)
+	if policy.Neighbors == 0 {
+		if sampled || anomaly {
+			return []models.Metric{m}
+		}
+		metrics.SampledOut.WithLabelValues(tenant).Inc()
+		return nil
+	}
+
+	d, ok := s.devices[m.DeviceID]
+	if !ok {
+		d = &deviceState{}
+		s.devices[m.DeviceID] = d
+	}
+	d.lastSeen = now
+
+	var keep []models.Metric
+	switch {
+	case anomaly:
+		for i := range d.recent {
+			if !d.recent[i].stored {
+				keep = append(keep, d.recent[i].metric)
+				d.recent[i].stored = true
+			}
+		}
+		d.after = policy.Neighbors
+		keep = append(keep, m)
+	case d.after > 0:
+		d.after--
+		keep = append(keep, m)
+	case sampled:
+		keep = append(keep, m)
+	default:
+		metrics.SampledOut.WithLabelValues(tenant).Inc()
+	}
+
+	if len(d.recent) >= policy.Neighbors {
+		// Вытесняемый сосед так и не понадобился
+		d.recent = append(d.recent[:0], d.recent[len(d.recent)-policy.Neighbors+1:]...)
+	}
+	d.recent = append(d.recent, neighbor{metric: m, stored: len(keep) > 0})
+	return keep
+}
+
+// sample решает по скорости потока тенанта, сохранять ли обычную метрику;
+// вызывается под блокировкой
+func (s *Sampler) sample(tenant string, policy Policy, now time.Time) bool {
+	if policy.TargetRate <= 0 {
+		return true
+	}
+	t, ok := s.tenants[tenant]
+	if !ok {
+		t = &tenantState{}
+		s.tenants[tenant] = t
+	}
+	switch second := now.Unix(); {
+	case second == t.second:
+	case second == t.second+1:
+		t.second, t.rate, t.count = second, t.count, 0
+	default:
+		t.second, t.rate, t.count = second, 0, 0
+	}
+	t.count++
+
+	n := uint64(math.Ceil(float64(max(t.rate, t.count)) / policy.TargetRate))
+	t.seq++
+	return n <= 1 || t.seq%n == 0
+}
+
+// sweep забывает соседей устройств, молчащих дольше deviceIdleTTL; вызывается под блокировкой
+func (s *Sampler) sweep(now time.Time) {
+	for id, d := range s.devices {
+		if now.Sub(d.lastSeen) >= deviceIdleTTL {
+			delete(s.devices, id)
+		}
+	}
+	s.lastSweep = now
+}
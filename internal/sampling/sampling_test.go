@@ -0,0 +1,86 @@
+package sampling
+
+import (
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/devices"
+	"highload-service/internal/models"
+)
+
+func TestSampler_ThinsNormalMetricsAndKeepsIncidents(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	registry := devices.NewRegistry(
+		devices.Device{ID: "bulk-1", Tenant: "bulk"},
+		devices.Device{ID: "vip-1", Tenant: "vip"},
+	)
+	cfg, err := ParseConfig(`{"default":{"target_rate":10,"neighbors":2},"tenants":{"vip":{"target_rate":0}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, WithClock(clk), WithDirectory(registry))
+
+	metric := func(device string, cpu float64) models.Metric {
+		return models.Metric{DeviceID: device, CPU: cpu, Timestamp: clk.Now()}
+	}
+
+	// Below the target rate everything is stored
+	for i := 0; i < 10; i++ {
+		if kept := s.Keep(metric("bulk-1", 1), false); len(kept) != 1 {
+			t.Fatalf("Expected metric %d below the target rate to be stored, got %d", i, len(kept))
+		}
+	}
+
+	// Once a second at 100 metrics/s has been measured, a tenth of them is stored
+	clk.Advance(time.Second)
+	for i := 0; i < 100; i++ {
+		s.Keep(metric("bulk-1", 1), false)
+	}
+	clk.Advance(time.Second)
+	stored := 0
+	for i := 0; i < 100; i++ {
+		stored += len(s.Keep(metric("bulk-1", float64(i)), false))
+	}
+	if stored < 8 || stored > 12 {
+		t.Errorf("Expected about 10 of 100 metrics to be stored, got %d", stored)
+	}
+
+	// The anomaly brings its unstored predecessors and the following neighbors
+	kept := s.Keep(metric("bulk-1", 100), true)
+	if len(kept) < 2 || len(kept) > 3 || kept[0].CPU < 98 || kept[len(kept)-1].CPU != 100 {
+		t.Fatalf("Expected the anomaly with its unstored predecessors, got %+v", kept)
+	}
+	for i := 0; i < 2; i++ {
+		if got := s.Keep(metric("bulk-1", 1), false); len(got) != 1 {
+			t.Errorf("Expected neighbor %d after the anomaly to be stored", i)
+		}
+	}
+	// Neighbors are stored only once
+	if got := s.Keep(metric("bulk-1", 100), true); len(got) != 1 {
+		t.Errorf("Expected already stored neighbors to be skipped, got %d metrics", len(got))
+	}
+
+	// The tenant without a target rate is never thinned
+	for i := 0; i < 100; i++ {
+		if got := s.Keep(metric("vip-1", 1), false); len(got) != 1 {
+			t.Fatalf("Expected all metrics of the vip tenant to be stored")
+		}
+	}
+}
+
+func TestParseConfig_Validation(t *testing.T) {
+	for _, raw := range []string{
+		`{"default":{"target_rate":-1}}`,
+		`{"default":{"target_rate":10,"neighbors":1000}}`,
+		`{"tenants":{"a":{"neighbors":-1}}}`,
+		`{"default":`,
+	} {
+		if _, err := ParseConfig(raw); err == nil {
+			t.Errorf("Expected %s to be rejected", raw)
+		}
+	}
+	if cfg, err := ParseConfig(""); err != nil || cfg.Enabled() {
+		t.Errorf("Expected an empty policy to disable sampling, got %+v %v", cfg, err)
+	}
+}
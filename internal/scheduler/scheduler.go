@@ -0,0 +1,290 @@
+// Package scheduler запускает фоновые задачи сервиса по расписанию.
+//
+// Задачи регистрируются в коде (Register), а расписания задаются в конфигурации
+// (SCHEDULE). В кластере общие задачи выполняет только лидер: реплика, удерживающая
+// блокировку в Redis; локальные задачи выполняются на каждой реплике. Запуск сдвигается на случайную задержку до jitter,
+// чтобы задачи с одинаковым расписанием не стартовали одновременно
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+const (
+	// LeaderKey ключ блокировки лидера в Redis
+	LeaderKey = "scheduler:leader"
+	// Off значение расписания, отключающее задачу
+	Off = "off"
+)
+
+// Job фоновая задача. ctx отменяется при остановке планировщика
+type Job func(ctx context.Context) error
+
+// Locker захватывает или продлевает блокировку key для owner (реализуется cache.RedisCache)
+type Locker interface {
+	AcquireLock(key, owner string, ttl time.Duration) (bool, error)
+}
+
+// Defaults расписания встроенных задач
+func Defaults() map[string]string {
+	return map[string]string{
+		"anomalies.prune": "@every 1h",
+		"metrics.compact": "@every 15m",
+		"stats.report":    "@hourly",
+	}
+}
+
+// ParseSchedule разбирает JSON-объект {"задача": "расписание"} и проверяет расписания
+func ParseSchedule(raw string) (map[string]string, error) {
+	specs := map[string]string{}
+	if raw == "" {
+		return specs, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, err
+	}
+	for name, spec := range specs {
+		if spec == Off {
+			continue
+		}
+		if _, err := ParseSpec(spec); err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+	}
+	return specs, nil
+}
+
+// Option настраивает Scheduler
+type Option func(*Scheduler)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// WithJitter задает верхнюю границу случайной задержки запуска
+func WithJitter(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.jitter = d
+	}
+}
+
+// WithLeaderElection включает выполнение задач только на лидере.
+// Лидерство удерживается блокировкой с временем жизни ttl и продлевается каждые ttl/3
+func WithLeaderElection(l Locker, node string, ttl time.Duration) Option {
+	return func(s *Scheduler) {
+		s.locker = l
+		s.node = node
+		s.leaderTTL = ttl
+	}
+}
+
+type registration struct {
+	job   Job
+	local bool
+}
+
+type entry struct {
+	name     string
+	job      Job
+	local    bool
+	spec     string
+	schedule Schedule
+}
+
+// Scheduler планировщик задач
+type Scheduler struct {
+	clock     clock.Clock
+	jitter    time.Duration
+	locker    Locker
+	node      string
+	leaderTTL time.Duration
+	leader    atomic.Bool
+
+	mu      sync.Mutex
+	jobs    map[string]registration
+	entries []*entry
+}
+
+// New создает планировщик. Без WithLeaderElection экземпляр считается лидером
+func New(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		clock: clock.Real(),
+		jobs:  make(map[string]registration),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.leader.Store(s.locker == nil)
+	return s
+}
+
+// Register регистрирует задачу name, выполняемую только на лидере.
+// Задача запускается, только если для нее задано расписание
+func (s *Scheduler) Register(name string, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = registration{job: job}
+}
+
+// RegisterLocal регистрирует задачу name, выполняемую на каждой реплике,
+// например очистку состояния в памяти процесса
+func (s *Scheduler) RegisterLocal(name string, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = registration{job: job, local: true}
+}
+
+// Schedule назначает расписание зарегистрированной задаче; Off отключает задачу
+func (s *Scheduler) Schedule(name, spec string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("unknown job %q", name)
+	}
+	if spec == Off {
+		return nil
+	}
+	schedule, err := ParseSpec(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	s.entries = append(s.entries, &entry{name: name, job: reg.job, local: reg.local, spec: spec, schedule: schedule})
+	return nil
+}
+
+// ScheduleAll назначает расписания из конфигурации в порядке имен задач
+func (s *Scheduler) ScheduleAll(specs map[string]string) error {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.Schedule(name, specs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsLeader сообщает, выполняет ли экземпляр задачи
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Run выполняет задачи по расписанию до отмены ctx
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	if s.locker != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.elect(ctx)
+		}()
+	}
+
+	s.mu.Lock()
+	entries := append([]*entry(nil), s.entries...)
+	s.mu.Unlock()
+	for _, e := range entries {
+		log.Printf("Scheduled job %s: %s", e.name, e.spec)
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			s.loop(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// RunNow выполняет задачу немедленно, независимо от расписания и лидерства
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	reg, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown job %q", name)
+	}
+	return s.execute(ctx, name, reg.job)
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	for {
+		next := e.schedule.Next(s.clock.Now())
+		if next.IsZero() {
+			log.Printf("Job %s will never run again: %s", e.name, e.spec)
+			return
+		}
+		wait := next.Sub(s.clock.Now())
+		if s.jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(s.jitter)))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !e.local && !s.leader.Load() {
+			metrics.SchedulerJobRuns.WithLabelValues(e.name, "skipped").Inc()
+			continue
+		}
+		s.execute(ctx, e.name, e.job)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, name string, job Job) error {
+	start := time.Now()
+	err := job(ctx)
+	metrics.SchedulerJobDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SchedulerJobRuns.WithLabelValues(name, "error").Inc()
+		log.Printf("Job %s failed: %v", name, err)
+		return err
+	}
+	metrics.SchedulerJobRuns.WithLabelValues(name, "success").Inc()
+	metrics.SchedulerJobLastSuccess.WithLabelValues(name).Set(float64(s.clock.Now().Unix()))
+	return nil
+}
+
+// elect захватывает и продлевает блокировку лидера. Ошибка Redis снимает лидерство:
+// лучше пропустить запуск, чем выполнить задачу на двух репликах
+func (s *Scheduler) elect(ctx context.Context) {
+	ticker := time.NewTicker(s.leaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		ok, err := s.locker.AcquireLock(LeaderKey, s.node, s.leaderTTL)
+		if err != nil {
+			log.Printf("Scheduler leader election failed: %v", err)
+		}
+		leader := ok && err == nil
+		if s.leader.Swap(leader) != leader {
+			log.Printf("Scheduler leadership on %s: %v", s.node, leader)
+		}
+		select {
+		case <-ctx.Done():
+			s.leader.Store(false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
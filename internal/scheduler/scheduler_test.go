@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+)
+
+func TestParseSpec_Next(t *testing.T) {
+	// Monday
+	base := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+	for _, c := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2024, 1, 2, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 6,7", time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"10-20/5 10 * * 1-5", time.Date(2024, 1, 1, 10, 10, 0, 0, time.UTC)},
+		{"@every 90s", base.Add(90 * time.Second)},
+	} {
+		s, err := ParseSpec(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if got := s.Next(base); !got.Equal(c.want) {
+			t.Errorf("%s: expected %s, got %s", c.spec, c.want, got)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every soon"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestScheduler_RunsOnlyOnLeader(t *testing.T) {
+	shared := cache.NewMemoryCache(nil)
+	var runs [2]atomic.Int32
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := range runs {
+		s := New(WithLeaderElection(shared, []string{"a", "b"}[i], time.Second))
+		i := i
+		s.Register("tick", func(context.Context) error {
+			runs[i].Add(1)
+			return nil
+		})
+		if err := s.ScheduleAll(map[string]string{"tick": "@every 10ms"}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	a, b := runs[0].Load(), runs[1].Load()
+	if (a == 0) == (b == 0) {
+		t.Errorf("Expected exactly one replica to run the job, got a=%d b=%d", a, b)
+	}
+}
+
+func TestScheduler_UnknownJob(t *testing.T) {
+	s := New()
+	if err := s.Schedule("missing", "@hourly"); err == nil {
+		t.Error("Expected error for unregistered job")
+	}
+	s.Register("noop", func(context.Context) error { return nil })
+	if err := s.Schedule("noop", Off); err != nil {
+		t.Errorf("Disabling a job should succeed, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package score сводная оценка здоровья устройств и всего парка (0–100) для
+// NOC-табло: одно число вместо z-score, частоты аномалий и пропусков данных.
+//
+// Оценка устройства = 100 × (1 − 0.4·severity − 0.4·anomaly_rate) × availability, где
+// severity — средняя тяжесть отклонений (|z| от 1σ до 5σ переводится в 0…1),
+// anomaly_rate — доля метрик с аномалией, availability — доля ожидаемых метрик,
+// пришедших за окно (ожидается одна метрика за ExpectedInterval). Все три
+// величины считаются по последнему окну Window
+package score
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultWindow окно, по которому считается оценка
+	DefaultWindow = 15 * time.Minute
+	// DefaultExpectedInterval ожидаемый интервал между метриками устройства
+	DefaultExpectedInterval = 10 * time.Second
+	// DefaultMaxDevices лимит отслеживаемых устройств
+	DefaultMaxDevices = 10000
+	// DefaultForgetAfter через сколько молчащее устройство перестает учитываться
+	DefaultForgetAfter = 24 * time.Hour
+	// Unknown устройство метрик без device_id
+	Unknown = "unknown"
+
+	// buckets количество интервалов окна; память на устройство не зависит от частоты метрик
+	buckets = 15
+	// severityFloor и severityCeil |z|, соответствующие нулевой и полной тяжести
+	severityFloor = 1
+	severityCeil  = 5
+	// severityWeight и anomalyWeight доли оценки, которые снимают отклонения и аномалии
+	severityWeight = 0.4
+	anomalyWeight  = 0.4
+)
+
+// ErrUnknownDevice устройство не присылало метрик или перестало учитываться
+var ErrUnknownDevice = errors.New("device has no recent metrics")
+
+// Score оценка устройства или парка
+type Score struct {
+	DeviceID string `json:"device_id,omitempty"`
+	// Score итоговая оценка 0–100, 100 — полностью здорово
+	Score float64 `json:"score"`
+	// Severity средняя тяжесть отклонений 0–1
+	Severity float64 `json:"severity"`
+	// AnomalyRate доля метрик с аномалией 0–1
+	AnomalyRate float64 `json:"anomaly_rate"`
+	// Availability доля ожидаемых метрик, пришедших за окно, 0–1
+	Availability float64   `json:"availability"`
+	Samples      int64     `json:"samples"`
+	Anomalies    int64     `json:"anomalies"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Fleet оценка парка — среднее оценок устройств
+type Fleet struct {
+	Score   float64 `json:"score"`
+	Devices int     `json:"devices"`
+	// Untracked метрики устройств сверх лимита, не вошедшие в оценки
+	Untracked int64 `json:"untracked"`
+}
+
+// Option настраивает Scorer
+type Option func(*Scorer)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(s *Scorer) {
+		s.clock = c
+	}
+}
+
+// WithWindow задает окно оценки
+func WithWindow(d time.Duration) Option {
+	return func(s *Scorer) {
+		if d > 0 {
+			s.window = d
+		}
+	}
+}
+
+// WithExpectedInterval задает ожидаемый интервал между метриками устройства
+func WithExpectedInterval(d time.Duration) Option {
+	return func(s *Scorer) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// WithMaxDevices ограничивает число отслеживаемых устройств; метрики новых
+// устройств сверх лимита только подсчитываются в Fleet.Untracked
+func WithMaxDevices(n int) Option {
+	return func(s *Scorer) {
+		if n > 0 {
+			s.maxDevices = n
+		}
+	}
+}
+
+type bucket struct {
+	// start начало интервала в наносекундах Unix; устаревший интервал обнуляется при записи
+	start     int64
+	samples   int64
+	anomalies int64
+	severity  float64
+}
+
+type device struct {
+	buckets   [buckets]bucket
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Scorer накапливает результаты анализа по устройствам. Безопасен для конкурентного использования
+type Scorer struct {
+	clock      clock.Clock
+	window     time.Duration
+	interval   time.Duration
+	maxDevices int
+
+	mu        sync.Mutex
+	devices   map[string]*device
+	untracked int64
+}
+
+// New создает Scorer
+func New(opts ...Option) *Scorer {
+	s := &Scorer{
+		clock:      clock.Real(),
+		window:     DefaultWindow,
+		interval:   DefaultExpectedInterval,
+		maxDevices: DefaultMaxDevices,
+		devices:    make(map[string]*device),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Observe учитывает результат анализа метрики m. Время берется по приходу метрики,
+// чтобы пропуски данных определялись и для устройств с неверными часами
+func (s *Scorer) Observe(m models.Metric, result models.AnalysisResult) {
+	id := m.DeviceID
+	if id == "" {
+		id = Unknown
+	}
+	now := s.clock.Now()
+	severity := math.Max(severityOf(result.ZScoreCPU), severityOf(result.ZScoreRPS))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[id]
+	if !ok {
+		if len(s.devices) >= s.maxDevices {
+			s.untracked++
+			return
+		}
+		d = &device{firstSeen: now}
+		s.devices[id] = d
+	}
+	d.lastSeen = now
+
+	width := s.bucketWidth()
+	start := now.UnixNano() / width * width
+	b := &d.buckets[(start/width)%buckets]
+	if b.start != start {
+		*b = bucket{start: start}
+	}
+	b.samples++
+	b.severity += severity
+	if result.AnomalyDetected {
+		b.anomalies++
+	}
+}
+
+// Device возвращает оценку устройства
+func (s *Scorer) Device(id string) (Score, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	d, ok := s.devices[id]
+	if !ok || now.Sub(d.lastSeen) > DefaultForgetAfter {
+		return Score{}, ErrUnknownDevice
+	}
+	return s.scoreLocked(id, d, now), nil
+}
+
+// Fleet возвращает оценку парка и до limit оценок устройств от худшей к лучшей
+// (limit <= 0 — все). Устройства, молчащие дольше DefaultForgetAfter, перестают учитываться
+func (s *Scorer) Fleet(limit int) (Fleet, []Score) {
+	s.mu.Lock()
+	now := s.clock.Now()
+	scores := make([]Score, 0, len(s.devices))
+	for id, d := range s.devices {
+		if now.Sub(d.lastSeen) > DefaultForgetAfter {
+			delete(s.devices, id)
+			continue
+		}
+		scores = append(scores, s.scoreLocked(id, d, now))
+	}
+	fleet := Fleet{Devices: len(scores), Untracked: s.untracked, Score: 100}
+	s.mu.Unlock()
+
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score < scores[j].Score
+		}
+		return scores[i].DeviceID < scores[j].DeviceID
+	})
+	if len(scores) > 0 {
+		sum := 0.0
+		for _, sc := range scores {
+			sum += sc.Score
+		}
+		fleet.Score = round(sum / float64(len(scores)))
+	}
+	if limit > 0 && len(scores) > limit {
+		scores = scores[:limit]
+	}
+	return fleet, scores
+}
+
+// scoreLocked считает оценку устройства по интервалам, попадающим в окно
+func (s *Scorer) scoreLocked(id string, d *device, now time.Time) Score {
+	sc := Score{DeviceID: id, LastSeen: d.lastSeen.UTC()}
+	width := s.bucketWidth()
+	oldest := now.Add(-s.window).UnixNano()
+	severity := 0.0
+	for _, b := range d.buckets {
+		if b.start+width <= oldest {
+			continue
+		}
+		sc.Samples += b.samples
+		sc.Anomalies += b.anomalies
+		severity += b.severity
+	}
+
+	// Устройство, появившееся недавно, не получает штраф за время до своего появления;
+	// молчание после последней метрики входит в ожидание и снижает availability
+	observed := now.Sub(d.firstSeen)
+	if observed > s.window {
+		observed = s.window
+	}
+	expected := float64(observed) / float64(s.interval)
+	sc.Availability = 1
+	if expected > float64(sc.Samples) {
+		sc.Availability = float64(sc.Samples) / expected
+	}
+	if sc.Samples > 0 {
+		sc.Severity = severity / float64(sc.Samples)
+		sc.AnomalyRate = float64(sc.Anomalies) / float64(sc.Samples)
+	}
+
+	sc.Score = round(100 * (1 - severityWeight*sc.Severity - anomalyWeight*sc.AnomalyRate) * sc.Availability)
+	sc.Severity = round(sc.Severity)
+	sc.AnomalyRate = round(sc.AnomalyRate)
+	sc.Availability = round(sc.Availability)
+	return sc
+}
+
+// bucketWidth ширина интервала; окно учитывается с точностью до интервала
+func (s *Scorer) bucketWidth() int64 {
+	if w := int64(s.window) / buckets; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// severityOf переводит |z| в тяжесть 0–1
+func severityOf(z float64) float64 {
+	z = math.Abs(z)
+	if math.IsNaN(z) || z <= severityFloor {
+		return 0
+	}
+	if z >= severityCeil {
+		return 1
+	}
+	return (z - severityFloor) / (severityCeil - severityFloor)
+}
+
+// round округляет до сотых для компактного JSON
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
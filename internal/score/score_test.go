@@ -0,0 +1,79 @@
+package score
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+func TestScorer_PenalizesDeviationsAnomaliesAndGaps(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := New(WithClock(clk), WithWindow(10*time.Minute), WithExpectedInterval(10*time.Second))
+
+	// 10 minutes of metrics every 10 seconds from three devices:
+	// healthy reports calm values, noisy has a 5σ anomaly in every other metric,
+	// flaky reports calm values but stops halfway
+	for i := 0; i < 60; i++ {
+		s.Observe(models.Metric{DeviceID: "healthy"}, models.AnalysisResult{ZScoreCPU: 0.5})
+		if i%2 == 0 {
+			s.Observe(models.Metric{DeviceID: "noisy"}, models.AnalysisResult{ZScoreRPS: -5, AnomalyDetected: true})
+		} else {
+			s.Observe(models.Metric{DeviceID: "noisy"}, models.AnalysisResult{ZScoreCPU: 1})
+		}
+		if i < 30 {
+			s.Observe(models.Metric{DeviceID: "flaky"}, models.AnalysisResult{})
+		}
+		clk.Advance(10 * time.Second)
+	}
+
+	healthy, err := s.Device("healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy.Score != 100 || healthy.Availability != 1 {
+		t.Errorf("Expected a perfect score for healthy, got %+v", healthy)
+	}
+	// severity 0.5 and anomaly rate 0.5: 100 × (1 − 0.2 − 0.2)
+	if noisy, _ := s.Device("noisy"); noisy.Score != 60 || noisy.Anomalies != 30 {
+		t.Errorf("Expected score 60 for noisy, got %+v", noisy)
+	}
+	// Half of the expected metrics are missing
+	if flaky, _ := s.Device("flaky"); flaky.Score != 50 || flaky.Availability != 0.5 {
+		t.Errorf("Expected score 50 for flaky, got %+v", flaky)
+	}
+
+	fleet, devices := s.Fleet(2)
+	if fleet.Devices != 3 || fleet.Score != 70 {
+		t.Errorf("Expected fleet score 70 over 3 devices, got %+v", fleet)
+	}
+	if len(devices) != 2 || devices[0].DeviceID != "flaky" || devices[1].DeviceID != "noisy" {
+		t.Errorf("Expected the two worst devices first, got %+v", devices)
+	}
+
+	// A device silent for the whole window scores zero; after a day it is forgotten
+	clk.Advance(10 * time.Minute)
+	if flaky, _ := s.Device("flaky"); flaky.Score != 0 {
+		t.Errorf("Expected score 0 for a silent device, got %+v", flaky)
+	}
+	clk.Advance(DefaultForgetAfter)
+	if _, err := s.Device("flaky"); !errors.Is(err, ErrUnknownDevice) {
+		t.Errorf("Expected ErrUnknownDevice for a forgotten device, got %v", err)
+	}
+	if fleet, devices := s.Fleet(0); fleet.Devices != 0 || fleet.Score != 100 || len(devices) != 0 {
+		t.Errorf("Expected an empty fleet, got %+v %+v", fleet, devices)
+	}
+}
+
+func TestScorer_MaxDevices(t *testing.T) {
+	s := New(WithMaxDevices(1))
+	s.Observe(models.Metric{DeviceID: "a"}, models.AnalysisResult{})
+	s.Observe(models.Metric{DeviceID: "b"}, models.AnalysisResult{})
+	s.Observe(models.Metric{}, models.AnalysisResult{})
+
+	if fleet, _ := s.Fleet(0); fleet.Devices != 1 || fleet.Untracked != 2 {
+		t.Errorf("Expected 1 tracked device and 2 untracked metrics, got %+v", fleet)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package selfmon
+
+import "time"
+
+// processCPUTime недоступно на этой платформе: загрузка CPU не анализируется
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,199 @@
+// Package selfmon следит за самим сервисом: периодически снимает загрузку CPU
+// процессом, объем резидентной памяти и количество горутин и прогоняет их
+// через тот же детектор аномалий, что и метрики устройств. Каждый показатель —
+// отдельное псевдоустройство "<Device>:cpu", "<Device>:rss", "<Device>:goroutines"
+// со значением в поле cpu метрики, поэтому аномалия показателя приходит
+// обычным оповещением, а ее z-score — z_score_cpu.
+//
+// Показатели анализируются собственным анализатором: они не смешиваются
+// с окнами и агрегатами парка
+package selfmon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultInterval период снятия показаний
+	DefaultInterval = 10 * time.Second
+	// DefaultDevice префикс псевдоустройств
+	DefaultDevice = "self"
+)
+
+// Показатели, по которым называются псевдоустройства
+const (
+	SignalCPU        = "cpu"
+	SignalRSS        = "rss"
+	SignalGoroutines = "goroutines"
+)
+
+// Config настройки самонаблюдения
+type Config struct {
+	Enabled bool
+	// Interval период снятия показаний
+	Interval time.Duration
+	// Device префикс идентификаторов псевдоустройств
+	Device string
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval < time.Second {
+		return fmt.Errorf("interval must be at least 1s, got %s", c.Interval)
+	}
+	if c.Device == "" {
+		return fmt.Errorf("device must not be empty")
+	}
+	return nil
+}
+
+// Sample показания процесса
+type Sample struct {
+	Time time.Time
+	// CPU загрузка процессом доступных ему ядер (GOMAXPROCS), проценты;
+	// -1, если время CPU процесса недоступно на этой платформе
+	CPU float64
+	// RSS резидентная память, байты
+	RSS uint64
+	// Goroutines количество горутин
+	Goroutines int
+}
+
+// Recorder получает результат анализа показателя (например, учет аномалий)
+type Recorder func(models.Metric, models.AnalysisResult)
+
+// Option настраивает Monitor
+type Option func(*Monitor)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(m *Monitor) {
+		m.clock = c
+	}
+}
+
+// WithRecorder добавляет получателя результатов анализа
+func WithRecorder(r Recorder) Option {
+	return func(m *Monitor) {
+		m.recorders = append(m.recorders, r)
+	}
+}
+
+// Monitor снимает показания процесса и анализирует их
+type Monitor struct {
+	cfg       Config
+	clock     clock.Clock
+	analyzer  *analytics.Analyzer
+	recorders []Recorder
+	// readCPU время CPU процесса; подменяется в тестах
+	readCPU func() (time.Duration, bool)
+
+	// Поля ниже принадлежат горутине Run
+	lastCPU  time.Duration
+	lastWall time.Time
+}
+
+// New создает Monitor с детектором detector
+func New(cfg Config, detector analytics.DetectorConfig, opts ...Option) *Monitor {
+	m := &Monitor{cfg: cfg, clock: clock.Real(), readCPU: processCPUTime}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.analyzer = analytics.NewAnalyzer(1,
+		analytics.WithClock(m.clock),
+		analytics.WithDetectorConfig(detector),
+		analytics.WithDeviceWindows(analytics.DeviceWindowsConfig{IdleTTL: 24 * time.Hour, MaxDevices: 3}),
+	)
+	return m
+}
+
+// Run снимает и анализирует показания каждые Interval до отмены контекста
+func (m *Monitor) Run(ctx context.Context) {
+	defer m.analyzer.Stop()
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.Sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Observe(m.Sample())
+		}
+	}
+}
+
+// Sample снимает показания. Загрузка CPU считается с предыдущего вызова,
+// поэтому первый вызов возвращает 0
+func (m *Monitor) Sample() Sample {
+	now := m.clock.Now()
+	s := Sample{Time: now, CPU: -1, RSS: residentMemory(), Goroutines: runtime.NumGoroutine()}
+	if used, ok := m.readCPU(); ok {
+		s.CPU = 0
+		if wall := now.Sub(m.lastWall); !m.lastWall.IsZero() && wall > 0 {
+			s.CPU = 100 * float64(used-m.lastCPU) / float64(wall) / float64(runtime.GOMAXPROCS(0))
+		}
+		m.lastCPU = used
+	}
+	m.lastWall = now
+	return s
+}
+
+// Observe анализирует показания и передает результаты получателям
+func (m *Monitor) Observe(s Sample) {
+	signals := []struct {
+		name  string
+		value float64
+	}{
+		{SignalCPU, s.CPU},
+		{SignalRSS, float64(s.RSS) / (1 << 20)},
+		{SignalGoroutines, float64(s.Goroutines)},
+	}
+	for _, sig := range signals {
+		if sig.value < 0 {
+			continue
+		}
+		metric := models.Metric{Timestamp: s.Time, DeviceID: m.cfg.Device + ":" + sig.name, CPU: sig.value}
+		result := m.analyzer.AnalyzeSync(metric)
+		if result.AnomalyDetected {
+			log.Printf("Self-monitoring: anomalous %s=%.1f (z=%.2f)", sig.name, sig.value, result.ZScoreCPU)
+		}
+		for _, record := range m.recorders {
+			record(metric, result)
+		}
+	}
+}
+
+// residentMemory возвращает резидентную память процесса. Вне Linux — память,
+// которую среда выполнения Go держит у ОС, что близко к RSS
+func residentMemory() uint64 {
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
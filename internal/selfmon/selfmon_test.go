@@ -0,0 +1,60 @@
+package selfmon
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+func TestMonitor_SampleMeasuresCPUBetweenCalls(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := New(Config{Enabled: true, Interval: 10 * time.Second, Device: "self"}, analytics.DefaultDetectorConfig(), WithClock(clk))
+	var used time.Duration
+	m.readCPU = func() (time.Duration, bool) { return used, true }
+
+	if s := m.Sample(); s.CPU != 0 || s.RSS == 0 || s.Goroutines == 0 {
+		t.Errorf("Expected a first sample with no CPU history, got %+v", s)
+	}
+	// Half a core-second per second of every available core
+	clk.Advance(10 * time.Second)
+	used += time.Duration(runtime.GOMAXPROCS(0)) * 5 * time.Second
+	if s := m.Sample(); s.CPU != 50 {
+		t.Errorf("Expected 50%% CPU, got %v", s.CPU)
+	}
+
+	m.readCPU = func() (time.Duration, bool) { return 0, false }
+	if s := m.Sample(); s.CPU != -1 {
+		t.Errorf("Expected CPU to be unavailable, got %v", s.CPU)
+	}
+}
+
+func TestMonitor_FlagsGoroutineLeak(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	var anomalies []string
+	m := New(Config{Enabled: true, Interval: 10 * time.Second, Device: "self"},
+		analytics.DetectorConfig{WindowSize: 20, ZScoreThreshold: 3},
+		WithClock(clk),
+		WithRecorder(func(metric models.Metric, result models.AnalysisResult) {
+			if result.AnomalyDetected {
+				anomalies = append(anomalies, metric.DeviceID)
+			}
+		}))
+
+	for i := 0; i < 20; i++ {
+		clk.Advance(10 * time.Second)
+		m.Observe(Sample{Time: clk.Now(), CPU: float64(20 + i%3), RSS: 64 << 20, Goroutines: 100 + i%5})
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("Expected a steady service to be quiet, got %v", anomalies)
+	}
+
+	clk.Advance(10 * time.Second)
+	m.Observe(Sample{Time: clk.Now(), CPU: 21, RSS: 64 << 20, Goroutines: 5000})
+	if len(anomalies) != 1 || anomalies[0] != "self:goroutines" {
+		t.Errorf("Expected only the goroutine count to be flagged, got %v", anomalies)
+	}
+}
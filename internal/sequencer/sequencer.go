@@ -0,0 +1,269 @@
+// Package sequencer упорядочивает метрики устройств по номеру в пакете.
+//
+// Устройства с пакетной передачей присылают метрики не по порядку, и
+// скользящая статистика окон искажается. Метрика с номером seq участвует в
+// упорядочивании своего устройства; метрики без номера или без устройства
+// проходят как есть. В режиме flag метрика с номером не больше уже принятого
+// помечается как опоздавшая и не анализируется. В режиме reorder метрики после
+// пропуска номера задерживаются в небольшом буфере, пока не придут пропущенные:
+// при переполнении буфера или по истечении MaxWait пропуск считается потерей,
+// и задержанные метрики выпускаются по порядку. Номер не больше Buffer, когда
+// ожидается номер больше 2·Buffer, означает перезапуск устройства: счет начинается заново
+package sequencer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+	"highload-service/internal/models"
+)
+
+// Режимы упорядочивания
+const (
+	ModeOff     = ""
+	ModeFlag    = "flag"
+	ModeReorder = "reorder"
+)
+
+const (
+	// MaxBuffer наибольший размер буфера устройства
+	MaxBuffer = 1024
+	// deviceIdleTTL через сколько забывается номер молчащего устройства
+	deviceIdleTTL = 10 * time.Minute
+)
+
+// Config настройки упорядочивания
+type Config struct {
+	Mode string
+	// Buffer сколько метрик устройства задерживается в ожидании пропущенного номера;
+	// номера до Buffer после номеров больше 2·Buffer начинают последовательность заново
+	Buffer int
+	// MaxWait сколько задержанная метрика ждет пропущенные номера
+	MaxWait time.Duration
+}
+
+// DefaultConfig упорядочивание выключено; буфер на 16 метрик и ожидание 2s для reorder
+func DefaultConfig() Config {
+	return Config{Mode: ModeOff, Buffer: 16, MaxWait: 2 * time.Second}
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	switch c.Mode {
+	case ModeOff:
+		return nil
+	case ModeFlag, ModeReorder:
+	default:
+		return fmt.Errorf("unknown mode %q, expected %s or %s", c.Mode, ModeFlag, ModeReorder)
+	}
+	if c.Buffer < 1 || c.Buffer > MaxBuffer {
+		return fmt.Errorf("buffer must be within [1, %d], got %d", MaxBuffer, c.Buffer)
+	}
+	if c.Mode == ModeReorder && c.MaxWait <= 0 {
+		return fmt.Errorf("max wait must be positive, got %s", c.MaxWait)
+	}
+	return nil
+}
+
+// Verdict решение о предложенной метрике
+type Verdict int
+
+const (
+	// Ready метрика выпущена и входит в возвращенные метрики
+	Ready Verdict = iota
+	// Held метрика задержана до прихода пропущенных номеров
+	Held
+	// Late номер метрики уже принят или пропущен; метрика не анализируется
+	Late
+)
+
+// Option настраивает Sequencer
+type Option func(*Sequencer)
+
+// WithClock задает источник времени
+func WithClock(c clock.Clock) Option {
+	return func(s *Sequencer) {
+		s.clock = c
+	}
+}
+
+// deviceState ожидаемый номер устройства и задержанные метрики по возрастанию номера
+type deviceState struct {
+	next     uint64
+	held     []models.Metric
+	heldAt   time.Time
+	lastSeen time.Time
+}
+
+// Sequencer упорядочивает метрики устройств. Безопасен для конкурентного использования
+type Sequencer struct {
+	cfg   Config
+	clock clock.Clock
+
+	mu        sync.Mutex
+	devices   map[string]*deviceState
+	lastSweep time.Time
+}
+
+// New создает Sequencer с настройками cfg
+func New(cfg Config, opts ...Option) *Sequencer {
+	s := &Sequencer{cfg: cfg, clock: clock.Real(), devices: make(map[string]*deviceState)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Offer предлагает принятую метрику и возвращает метрики, готовые к анализу,
+// по возрастанию номера: m при Ready и задержанные ранее метрики, которые она
+// освободила. При Held и Late m не возвращается
+func (s *Sequencer) Offer(m models.Metric) ([]models.Metric, Verdict) {
+	if m.Seq == 0 || m.DeviceID == "" || s.cfg.Mode == ModeOff {
+		return []models.Metric{m}, Ready
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) >= deviceIdleTTL {
+		s.sweep(now)
+	}
+
+	d, ok := s.devices[m.DeviceID]
+	if !ok {
+		// Первая метрика устройства задает начало последовательности
+		d = &deviceState{next: m.Seq}
+		s.devices[m.DeviceID] = d
+	}
+	d.lastSeen = now
+
+	var restarted []models.Metric
+	if buffer := uint64(s.cfg.Buffer); m.Seq <= buffer && d.next > 2*buffer {
+		// Устройство перезапустилось и начало счет заново: задержанные метрики
+		// прежней последовательности выпускаются первыми
+		metrics.Sequencing.WithLabelValues("restarted").Inc()
+		for len(d.held) > 0 {
+			restarted = append(restarted, d.skip(now)...)
+		}
+		d.next = m.Seq
+	}
+	if m.Seq < d.next {
+		metrics.Sequencing.WithLabelValues("late").Inc()
+		return nil, Late
+	}
+	if s.cfg.Mode == ModeFlag || m.Seq == d.next {
+		d.next = m.Seq + 1
+		return append(restarted, d.drain(m)...), Ready
+	}
+
+	// Пропуск номера: метрика ждет в буфере
+	i := sort.Search(len(d.held), func(i int) bool { return d.held[i].Seq >= m.Seq })
+	if i < len(d.held) && d.held[i].Seq == m.Seq {
+		metrics.Sequencing.WithLabelValues("late").Inc()
+		return nil, Late
+	}
+	if len(d.held) == 0 {
+		d.heldAt = now
+	}
+	d.held = append(d.held, models.Metric{})
+	copy(d.held[i+1:], d.held[i:])
+	d.held[i] = m
+	metrics.Sequencing.WithLabelValues("held").Inc()
+	if len(d.held) <= s.cfg.Buffer {
+		return nil, Held
+	}
+	// Буфер переполнен: пропущенные номера считаются потерянными
+	return d.skip(now), Held
+}
+
+// drain возвращает m и задержанные метрики, продолжающие последовательность без пропусков
+func (d *deviceState) drain(m models.Metric) []models.Metric {
+	ready := []models.Metric{m}
+	n := 0
+	for n < len(d.held) && d.held[n].Seq == d.next {
+		ready = append(ready, d.held[n])
+		d.next++
+		n++
+	}
+	d.held = append(d.held[:0], d.held[n:]...)
+	return ready
+}
+
+// skip отказывается ждать номера перед первой задержанной метрикой и выпускает
+// ее вместе с продолжающими ее метриками
+func (d *deviceState) skip(now time.Time) []models.Metric {
+	first := d.held[0]
+	metrics.Sequencing.WithLabelValues("skipped").Add(float64(first.Seq - d.next))
+	d.next = first.Seq + 1
+	d.held = d.held[1:]
+	ready := d.drain(first)
+	d.heldAt = now
+	return ready
+}
+
+// Expire выпускает задержанные метрики устройств, которые ждут пропущенные
+// номера дольше MaxWait
+func (s *Sequencer) Expire() []models.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	var ready []models.Metric
+	for _, d := range s.devices {
+		for len(d.held) > 0 && now.Sub(d.heldAt) >= s.cfg.MaxWait {
+			ready = append(ready, d.skip(now)...)
+		}
+	}
+	return ready
+}
+
+// Run выпускает задержанные метрики по истечении MaxWait, передавая их release,
+// до отмены ctx, после чего выпускает все задержанные метрики
+func (s *Sequencer) Run(ctx context.Context, release func(models.Metric)) {
+	interval := s.cfg.MaxWait / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, m := range s.Flush() {
+				release(m)
+			}
+			return
+		case <-ticker.C:
+			for _, m := range s.Expire() {
+				release(m)
+			}
+		}
+	}
+}
+
+// Flush выпускает все задержанные метрики, не дожидаясь пропущенных номеров
+func (s *Sequencer) Flush() []models.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ready []models.Metric
+	for _, d := range s.devices {
+		for len(d.held) > 0 {
+			ready = append(ready, d.skip(d.heldAt)...)
+		}
+	}
+	return ready
+}
+
+// sweep забывает устройства, которые молчат дольше deviceIdleTTL и ничего не ждут
+func (s *Sequencer) sweep(now time.Time) {
+	for id, d := range s.devices {
+		if len(d.held) == 0 && now.Sub(d.lastSeen) >= deviceIdleTTL {
+			delete(s.devices, id)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,113 @@
+package sequencer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+// seqs returns sequence numbers of metrics for compact comparisons.
+func seqs(metrics []models.Metric) string {
+	out := make([]uint64, len(metrics))
+	for i, m := range metrics {
+		out[i] = m.Seq
+	}
+	return fmt.Sprint(out)
+}
+
+func TestSequencer_ReordersWithinBuffer(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := New(Config{Mode: ModeReorder, Buffer: 3, MaxWait: time.Second}, WithClock(clk))
+	offer := func(seq uint64) ([]models.Metric, Verdict) {
+		return s.Offer(models.Metric{DeviceID: "d1", Seq: seq})
+	}
+
+	if ready, v := offer(1); v != Ready || seqs(ready) != "[1]" {
+		t.Fatalf("Expected the first metric to start the sequence, got %s %v", seqs(ready), v)
+	}
+	// 3 and 4 wait for 2, which releases all of them in order
+	for _, seq := range []uint64{4, 3} {
+		if ready, v := offer(seq); v != Held || len(ready) != 0 {
+			t.Fatalf("Expected %d to be held, got %s %v", seq, seqs(ready), v)
+		}
+	}
+	if ready, v := offer(2); v != Ready || seqs(ready) != "[2 3 4]" {
+		t.Fatalf("Expected 2 to release 3 and 4, got %s %v", seqs(ready), v)
+	}
+	if _, v := offer(3); v != Late {
+		t.Errorf("Expected a repeated number to be late, got %v", v)
+	}
+
+	// A full buffer gives up on the missing number 5
+	for _, seq := range []uint64{7, 6, 9} {
+		offer(seq)
+	}
+	if ready, v := offer(8); v != Held || seqs(ready) != "[6 7 8 9]" {
+		t.Fatalf("Expected an overflow to skip 5, got %s %v", seqs(ready), v)
+	}
+	if _, v := offer(5); v != Late {
+		t.Errorf("Expected the skipped number to be late, got %v", v)
+	}
+
+	// Held metrics are released after MaxWait even without new arrivals
+	offer(12)
+	if ready := s.Expire(); len(ready) != 0 {
+		t.Fatalf("Expected nothing to expire yet, got %s", seqs(ready))
+	}
+	clk.Advance(time.Second)
+	if ready := s.Expire(); seqs(ready) != "[12]" {
+		t.Errorf("Expected 12 to be released after MaxWait, got %s", seqs(ready))
+	}
+
+	// A small number long after the start means the device restarted counting
+	offer(20)
+	if ready, v := offer(1); v != Ready || seqs(ready) != "[20 1]" {
+		t.Errorf("Expected a restart to release held metrics first, got %s %v", seqs(ready), v)
+	}
+
+	// Metrics without a number or device are not sequenced
+	if ready, v := s.Offer(models.Metric{DeviceID: "d1"}); v != Ready || len(ready) != 1 {
+		t.Errorf("Expected an unnumbered metric to pass, got %d %v", len(ready), v)
+	}
+}
+
+func TestSequencer_FlagModeMarksLateMetrics(t *testing.T) {
+	s := New(Config{Mode: ModeFlag, Buffer: 16})
+	for _, c := range []struct {
+		device string
+		seq    uint64
+		want   Verdict
+	}{
+		{"d1", 5, Ready},
+		{"d1", 7, Ready},
+		{"d1", 6, Late},
+		{"d2", 6, Ready},
+		{"d1", 7, Late},
+		{"d1", 8, Ready},
+	} {
+		if _, v := s.Offer(models.Metric{DeviceID: c.device, Seq: c.seq}); v != c.want {
+			t.Errorf("%s seq %d: expected %v, got %v", c.device, c.seq, c.want, v)
+		}
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, c := range []struct {
+		cfg Config
+		ok  bool
+	}{
+		{DefaultConfig(), true},
+		{Config{Mode: ModeFlag, Buffer: 4}, true},
+		{Config{Mode: ModeReorder, Buffer: 4, MaxWait: time.Second}, true},
+		{Config{Mode: ModeReorder, Buffer: 4}, false},
+		{Config{Mode: ModeReorder, Buffer: 0, MaxWait: time.Second}, false},
+		{Config{Mode: "strict", Buffer: 4}, false},
+	} {
+		if err := c.cfg.Validate(); (err == nil) != c.ok {
+			t.Errorf("%+v: expected ok=%v, got %v", c.cfg, c.ok, err)
+		}
+	}
+}
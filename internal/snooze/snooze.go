@@ -0,0 +1,259 @@
+// Package snooze временно исключает устройства из учета аномалий и оповещений.
+//
+// Метрики отложенного устройства по-прежнему принимаются, сохраняются и
+// обучают окна детектора, но их аномалии не считаются и не оповещаются.
+// Так заведомо нестабильное устройство (например, на обслуживании) не
+// засоряет счетчики и не будит дежурных.
+//
+// Отложения хранятся в памяти реплики и, с WithStore, в хеше Redis со сроком
+// жизни MaxDuration, общем для всех реплик. Реплика, принявшая запрос,
+// применяет отложение сразу, остальные — при следующем Refresh (Watch).
+// Проверка метрики не обращается к Redis. Пока хранилище недоступно,
+// изменения действуют на своей реплике и записываются при восстановлении
+package snooze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+	"highload-service/internal/models"
+)
+
+const (
+	// DefaultDuration длительность отложения, если она не указана
+	DefaultDuration = time.Hour
+	// MaxDuration наибольшая длительность отложения
+	MaxDuration = 7 * 24 * time.Hour
+	// StoreKey хеш отложений: поле — устройство, значение — срок в RFC 3339
+	StoreKey = "snooze:devices"
+	// DefaultRefreshInterval период перечитывания отложений других реплик
+	DefaultRefreshInterval = 5 * time.Second
+)
+
+// ErrInvalidDuration длительность вне (0, MaxDuration]
+var ErrInvalidDuration = errors.New("invalid snooze duration")
+
+// Store общее хранилище отложений (реализуется cache.RedisCache и cache.MemoryCache)
+type Store interface {
+	UpdateHashes(updates []cache.HashUpdate, ttl time.Duration) error
+	GetHash(key string) (map[string]string, error)
+}
+
+// Option настраивает Registry
+type Option func(*Registry)
+
+// WithStore хранит отложения в s, общем для реплик
+func WithStore(s Store) Option {
+	return func(r *Registry) {
+		r.store = s
+	}
+}
+
+// Registry действующие отложения
+type Registry struct {
+	clock clock.Clock
+	store Store
+
+	mu    sync.RWMutex
+	until map[string]time.Time
+	// recent изменения реплики с начала последнего Refresh: прочитанный им
+	// хеш мог их еще не содержать. Нулевой срок — снятое отложение
+	recent map[string]time.Time
+	// unsynced изменения, которые не удалось записать в хранилище
+	unsynced map[string]time.Time
+}
+
+// New создает пустой Registry
+func New(c clock.Clock, opts ...Option) *Registry {
+	if c == nil {
+		c = clock.Real()
+	}
+	r := &Registry{
+		clock:    c,
+		until:    make(map[string]time.Time),
+		recent:   make(map[string]time.Time),
+		unsynced: make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Snooze откладывает устройство на d от текущего момента; повторный вызов
+// заменяет прежний срок
+func (r *Registry) Snooze(deviceID string, d time.Duration) (models.Snooze, error) {
+	if d <= 0 || d > MaxDuration {
+		return models.Snooze{}, fmt.Errorf("%w: %s (must be within (0, %s])", ErrInvalidDuration, d, MaxDuration)
+	}
+	until := r.clock.Now().Add(d).UTC()
+
+	// Запись в хранилище предшествует локальной: Refresh, прочитавший хеш до
+	// записи, увидит изменение в recent
+	r.save(deviceID, until)
+	r.mu.Lock()
+	r.until[deviceID] = until
+	r.recent[deviceID] = until
+	r.mu.Unlock()
+	return models.Snooze{DeviceID: deviceID, Until: until}, nil
+}
+
+// Cancel снимает отложение и возвращает его; false, если устройство не было отложено
+func (r *Registry) Cancel(deviceID string) (models.Snooze, bool) {
+	r.save(deviceID, time.Time{})
+	r.mu.Lock()
+	until, ok := r.until[deviceID]
+	delete(r.until, deviceID)
+	r.recent[deviceID] = time.Time{}
+	r.mu.Unlock()
+	if !ok || !r.clock.Now().Before(until) {
+		return models.Snooze{}, false
+	}
+	return models.Snooze{DeviceID: deviceID, Until: until}, true
+}
+
+// Snoozed сообщает, отложено ли устройство сейчас
+func (r *Registry) Snoozed(deviceID string) bool {
+	if r == nil || deviceID == "" {
+		return false
+	}
+	r.mu.RLock()
+	until, ok := r.until[deviceID]
+	r.mu.RUnlock()
+	return ok && r.clock.Now().Before(until)
+}
+
+// List возвращает действующие отложения по возрастанию срока и удаляет истекшие
+func (r *Registry) List() []models.Snooze {
+	now := r.clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]models.Snooze, 0, len(r.until))
+	for id, until := range r.until {
+		if !now.Before(until) {
+			delete(r.until, id)
+			continue
+		}
+		list = append(list, models.Snooze{DeviceID: id, Until: until})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Until.Equal(list[j].Until) {
+			return list[i].Until.Before(list[j].Until)
+		}
+		return list[i].DeviceID < list[j].DeviceID
+	})
+	return list
+}
+
+// save записывает изменение в хранилище; при ошибке оно остается в unsynced
+func (r *Registry) save(deviceID string, until time.Time) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.UpdateHashes([]cache.HashUpdate{update(map[string]time.Time{deviceID: until})}, MaxDuration); err != nil {
+		log.Printf("Failed to store snooze of device %q, keeping it on this replica: %v", deviceID, err)
+		r.mu.Lock()
+		r.unsynced[deviceID] = until
+		r.mu.Unlock()
+	}
+}
+
+// Refresh записывает несохраненные изменения и перечитывает отложения всех
+// реплик. При ошибке действуют прежние отложения
+func (r *Registry) Refresh() error {
+	if r.store == nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.recent = make(map[string]time.Time)
+	pending := make(map[string]time.Time, len(r.unsynced))
+	for id, until := range r.unsynced {
+		pending[id] = until
+	}
+	r.mu.Unlock()
+
+	if len(pending) > 0 {
+		if err := r.store.UpdateHashes([]cache.HashUpdate{update(pending)}, MaxDuration); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		for id, until := range pending {
+			if r.unsynced[id].Equal(until) {
+				delete(r.unsynced, id)
+			}
+		}
+		r.mu.Unlock()
+	}
+	fields, err := r.store.GetHash(StoreKey)
+	if err != nil {
+		return err
+	}
+
+	now := r.clock.Now()
+	until := make(map[string]time.Time, len(fields))
+	for id, v := range fields {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil && now.Before(t) {
+			until[id] = t.UTC()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, changes := range []map[string]time.Time{r.unsynced, r.recent} {
+		for id, t := range changes {
+			if t.IsZero() {
+				delete(until, id)
+			} else {
+				until[id] = t
+			}
+		}
+	}
+	r.until = until
+	return nil
+}
+
+// Watch периодически вызывает Refresh до отмены контекста
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if err := r.Refresh(); err != nil {
+		log.Printf("Failed to load snoozes: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.Refresh()
+			// Логируем только смену состояния, чтобы недоступный Redis не засыпал лог
+			if err != nil && !failing {
+				log.Printf("Failed to refresh snoozes, keeping previous ones: %v", err)
+			} else if err == nil && failing {
+				log.Printf("Snoozes refreshed")
+			}
+			failing = err != nil
+		}
+	}
+}
+
+// update изменение хеша отложений: сроки записываются, нулевые сроки удаляются
+func update(changes map[string]time.Time) cache.HashUpdate {
+	u := cache.HashUpdate{Key: StoreKey, Set: make(map[string]string)}
+	for id, until := range changes {
+		if until.IsZero() {
+			u.Del = append(u.Del, id)
+			continue
+		}
+		u.Set[id] = until.Format(time.RFC3339Nano)
+	}
+	return u
+}
@@ -0,0 +1,92 @@
+package snooze
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/cache"
+	"highload-service/internal/clock"
+)
+
+func TestRegistry_SharedAcrossReplicas(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	shared := cache.NewMemoryCache(clk)
+	a := New(clk, WithStore(shared))
+	b := New(clk, WithStore(shared))
+
+	if _, err := a.Snooze("sensor-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// The replica that took the request applies the snooze at once, others after a refresh
+	if !a.Snoozed("sensor-1") || b.Snoozed("sensor-1") {
+		t.Fatal("Expected the snooze only on the first replica before a refresh")
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Snoozed("sensor-1") || len(b.List()) != 1 {
+		t.Fatalf("Expected the snooze on the second replica, got %+v", b.List())
+	}
+
+	if _, ok := b.Cancel("sensor-1"); !ok {
+		t.Fatal("Expected the snooze to be cancelled")
+	}
+	a.Refresh()
+	if a.Snoozed("sensor-1") {
+		t.Error("Expected the cancel to reach the first replica")
+	}
+
+	// Expired snoozes are not read back
+	a.Snooze("sensor-2", time.Minute)
+	clk.Advance(2 * time.Minute)
+	b.Refresh()
+	if b.Snoozed("sensor-2") || len(b.List()) != 0 {
+		t.Errorf("Expected no active snoozes, got %+v", b.List())
+	}
+}
+
+// flakyStore fails while down is set
+type flakyStore struct {
+	*cache.MemoryCache
+	down bool
+}
+
+func (s *flakyStore) UpdateHashes(updates []cache.HashUpdate, ttl time.Duration) error {
+	if s.down {
+		return errors.New("connection refused")
+	}
+	return s.MemoryCache.UpdateHashes(updates, ttl)
+}
+
+func (s *flakyStore) GetHash(key string) (map[string]string, error) {
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	return s.MemoryCache.GetHash(key)
+}
+
+func TestRegistry_KeepsSnoozesWhileStoreIsDown(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := &flakyStore{MemoryCache: cache.NewMemoryCache(clk), down: true}
+	a := New(clk, WithStore(store))
+	b := New(clk, WithStore(store))
+
+	a.Snooze("sensor-1", time.Hour)
+	if err := a.Refresh(); err == nil {
+		t.Fatal("Expected the refresh to fail")
+	}
+	if !a.Snoozed("sensor-1") {
+		t.Fatal("Expected the snooze to apply on its replica while the store is down")
+	}
+
+	// Once the store is back the snooze is written and reaches other replicas
+	store.down = false
+	if err := a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	b.Refresh()
+	if !a.Snoozed("sensor-1") || !b.Snoozed("sensor-1") {
+		t.Error("Expected the snooze on both replicas after the store recovered")
+	}
+}
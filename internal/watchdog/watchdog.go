@@ -0,0 +1,200 @@
+// Package watchdog следит за воркерами анализатора: по отметкам воркеров
+// находит упавшие (паника) и зависшие на одной пачке дольше порога и
+// перезапускает их, а число горутин сравнивает с базовым, снятым при первой
+// проверке, чтобы заметить утечку. События пишутся в журнал и в метрики
+// highload_watchdog_*
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+	"highload-service/internal/metrics"
+)
+
+// События сторожа (метка event метрики highload_watchdog_events_total)
+const (
+	EventWorkerDied      = "worker_died"
+	EventWorkerStuck     = "worker_stuck"
+	EventWorkerRestarted = "worker_restarted"
+	EventGoroutineLeak   = "goroutine_leak"
+)
+
+// leakStacks количество групп одинаковых стеков в журнале при утечке
+const leakStacks = 5
+
+// Config настройки сторожа
+type Config struct {
+	Enabled bool
+	// Interval период проверки
+	Interval time.Duration
+	// StuckAfter сколько воркер может обрабатывать одну пачку, прежде чем
+	// считается зависшим
+	StuckAfter time.Duration
+	// GoroutineSlack на сколько горутин можно превысить базовое количество,
+	// прежде чем это считается утечкой
+	GoroutineSlack int
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("WATCHDOG_INTERVAL: must be positive, got %s", c.Interval)
+	}
+	if c.StuckAfter <= 0 {
+		return fmt.Errorf("WATCHDOG_STUCK_AFTER: must be positive, got %s", c.StuckAfter)
+	}
+	if c.GoroutineSlack < 0 {
+		return fmt.Errorf("WATCHDOG_GOROUTINE_SLACK: must not be negative, got %d", c.GoroutineSlack)
+	}
+	return nil
+}
+
+// Workers воркеры под наблюдением (реализуется analytics.Analyzer)
+type Workers interface {
+	WorkerStatuses() []analytics.WorkerStatus
+	ReplaceWorker(id int) error
+}
+
+// Option настраивает Watchdog
+type Option func(*Watchdog)
+
+// WithClock задает источник времени; должен совпадать с часами анализатора
+func WithClock(c clock.Clock) Option {
+	return func(w *Watchdog) {
+		w.clock = c
+	}
+}
+
+// WithGoroutineCount задает источник количества горутин (по умолчанию
+// runtime.NumGoroutine)
+func WithGoroutineCount(count func() int) Option {
+	return func(w *Watchdog) {
+		w.goroutines = count
+	}
+}
+
+// Report итог одной проверки
+type Report struct {
+	// Died упавшие воркеры
+	Died []int
+	// Stuck зависшие воркеры
+	Stuck []int
+	// Restarted воркеры, вместо которых запущены новые
+	Restarted []int
+	// Goroutines количество горутин; Leak — превышен базовый уровень с запасом
+	Goroutines int
+	Leak       bool
+}
+
+// Watchdog сторож воркеров. Check вызывается из одной горутины (Run)
+type Watchdog struct {
+	cfg        Config
+	workers    Workers
+	clock      clock.Clock
+	goroutines func() int
+
+	baseline int
+	leaking  bool
+}
+
+// New создает сторожа воркеров
+func New(cfg Config, workers Workers, opts ...Option) *Watchdog {
+	w := &Watchdog{
+		cfg:        cfg,
+		workers:    workers,
+		clock:      clock.Real(),
+		goroutines: runtime.NumGoroutine,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Run выполняет проверки с периодом Interval до отмены контекста
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check проверяет воркеры и количество горутин. Базовое количество горутин
+// снимается при первой проверке, когда сервис уже запущен
+func (w *Watchdog) Check() Report {
+	var report Report
+	now := w.clock.Now()
+	for _, s := range w.workers.WorkerStatuses() {
+		switch {
+		case s.Dead:
+			report.Died = append(report.Died, s.ID)
+			metrics.WatchdogEvents.WithLabelValues(EventWorkerDied).Inc()
+			log.Printf("Watchdog: analyzer worker %d died, restarting", s.ID)
+		case !s.BusySince.IsZero() && now.Sub(s.BusySince) >= w.cfg.StuckAfter:
+			report.Stuck = append(report.Stuck, s.ID)
+			metrics.WatchdogEvents.WithLabelValues(EventWorkerStuck).Inc()
+			log.Printf("Watchdog: analyzer worker %d stuck on a batch for %s, replacing", s.ID, now.Sub(s.BusySince).Round(time.Millisecond))
+		default:
+			continue
+		}
+		if err := w.workers.ReplaceWorker(s.ID); err != nil {
+			log.Printf("Watchdog: failed to replace analyzer worker %d: %v", s.ID, err)
+			continue
+		}
+		report.Restarted = append(report.Restarted, s.ID)
+		metrics.WatchdogEvents.WithLabelValues(EventWorkerRestarted).Inc()
+	}
+	metrics.WatchdogStuckWorkers.Set(float64(len(report.Stuck)))
+
+	report.Goroutines = w.goroutines()
+	if w.baseline == 0 {
+		w.baseline = report.Goroutines
+		metrics.WatchdogGoroutineBaseline.Set(float64(w.baseline))
+		log.Printf("Watchdog: goroutine baseline %d", w.baseline)
+	}
+	limit := w.baseline + w.cfg.GoroutineSlack
+	report.Leak = report.Goroutines > limit
+	switch {
+	case report.Leak && !w.leaking:
+		// Событие одно на эпизод: пока горутин больше порога, оно не повторяется
+		w.leaking = true
+		metrics.WatchdogEvents.WithLabelValues(EventGoroutineLeak).Inc()
+		log.Printf("Watchdog: %d goroutines exceed baseline %d by more than %d; top stacks:\n%s",
+			report.Goroutines, w.baseline, w.cfg.GoroutineSlack, topStacks(leakStacks))
+	case !report.Leak && w.leaking && report.Goroutines <= w.baseline+w.cfg.GoroutineSlack/2:
+		w.leaking = false
+		log.Printf("Watchdog: goroutine count back to %d (baseline %d)", report.Goroutines, w.baseline)
+	}
+	return report
+}
+
+// topStacks возвращает n самых частых групп одинаковых стеков горутин
+func topStacks(n int) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return err.Error()
+	}
+	// Формат debug=1: заголовок, затем группы, отсортированные по убыванию
+	// количества и разделенные пустой строкой
+	groups := strings.Split(strings.TrimSpace(buf.String()), "\n\n")
+	if len(groups) > n+1 {
+		groups = groups[:n+1]
+	}
+	return strings.Join(groups, "\n\n")
+}
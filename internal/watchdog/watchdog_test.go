@@ -0,0 +1,91 @@
+package watchdog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/clock"
+)
+
+type fakeWorkers struct {
+	statuses []analytics.WorkerStatus
+	replaced []int
+	err      error
+}
+
+func (f *fakeWorkers) WorkerStatuses() []analytics.WorkerStatus { return f.statuses }
+
+func (f *fakeWorkers) ReplaceWorker(id int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.replaced = append(f.replaced, id)
+	return nil
+}
+
+func testConfig() Config {
+	return Config{Enabled: true, Interval: time.Second, StuckAfter: 30 * time.Second, GoroutineSlack: 100}
+}
+
+func TestWatchdog_RestartsDeadAndStuckWorkers(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(now)
+	workers := &fakeWorkers{statuses: []analytics.WorkerStatus{
+		{ID: 0, LastBeat: now},
+		{ID: 1, LastBeat: now.Add(-time.Minute), BusySince: now.Add(-time.Minute)},
+		{ID: 2, LastBeat: now, BusySince: now.Add(-time.Second)},
+		{ID: 3, LastBeat: now.Add(-time.Second), Dead: true},
+	}}
+	w := New(testConfig(), workers, WithClock(clk), WithGoroutineCount(func() int { return 10 }))
+
+	report := w.Check()
+	if len(report.Stuck) != 1 || report.Stuck[0] != 1 {
+		t.Errorf("Expected worker 1 stuck, got %v", report.Stuck)
+	}
+	if len(report.Died) != 1 || report.Died[0] != 3 {
+		t.Errorf("Expected worker 3 dead, got %v", report.Died)
+	}
+	if len(workers.replaced) != 2 || len(report.Restarted) != 2 {
+		t.Errorf("Expected workers 1 and 3 replaced, got %v", workers.replaced)
+	}
+
+	workers.err = errors.New("refused")
+	if report := w.Check(); len(report.Restarted) != 0 || len(report.Stuck) != 1 {
+		t.Errorf("Expected a refused replacement to be reported as not restarted, got %+v", report)
+	}
+}
+
+func TestWatchdog_TracksGoroutineLeakEpisodes(t *testing.T) {
+	count := 50
+	w := New(testConfig(), &fakeWorkers{}, WithGoroutineCount(func() int { return count }))
+	if report := w.Check(); report.Leak {
+		t.Fatal("Expected the first check to set the baseline")
+	}
+	count = 151
+	if report := w.Check(); !report.Leak {
+		t.Fatal("Expected a leak above baseline plus slack")
+	}
+
+	// Dropping just below the limit does not end the episode; half the slack does
+	count = 140
+	if w.Check(); !w.leaking {
+		t.Error("Expected the episode to continue just below the limit")
+	}
+	count = 90
+	if w.Check(); w.leaking {
+		t.Error("Expected the episode to end below half the slack")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	cfg := testConfig()
+	cfg.StuckAfter = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for zero WATCHDOG_STUCK_AFTER")
+	}
+}
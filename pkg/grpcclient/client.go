@@ -0,0 +1,90 @@
+// Package grpcclient клиент gRPC-сервиса приема и запросов highload.v1.Metrics
+// для серверных производителей метрик. Типы сообщений — псевдонимы типов
+// сервиса, поэтому производители вне модуля создают и читают их напрямую
+package grpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"highload-service/internal/grpcapi"
+	"highload-service/internal/models"
+)
+
+type (
+	// Metric метрика устройства
+	Metric = models.Metric
+	// AnalysisResult результат анализа метрики
+	AnalysisResult = models.AnalysisResult
+	// BatchResponse ответ на пакет метрик
+	BatchResponse = models.BatchResponse
+	// BatchStats сводная статистика пакета
+	BatchStats = models.BatchStats
+	// Stats счетчики сервиса и статистика окон анализатора
+	Stats = grpcapi.Stats
+)
+
+// Option настраивает Client
+type Option func(*Client)
+
+// WithAPIKey передает ключ API с каждым вызовом (метаданные x-api-key);
+// нужен, если на сервере включен middleware auth
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// Client типизированный клиент сервиса Metrics
+type Client struct {
+	cc     grpc.ClientConnInterface
+	apiKey string
+}
+
+// NewClient создает клиент поверх соединения cc; одно соединение HTTP/2
+// обслуживает конкурентные вызовы
+func NewClient(cc grpc.ClientConnInterface, opts ...Option) *Client {
+	c := &Client{cc: cc}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SubmitMetric анализирует метрику, как POST /metrics
+func (c *Client) SubmitMetric(ctx context.Context, m Metric, opts ...grpc.CallOption) (AnalysisResult, error) {
+	var result AnalysisResult
+	err := c.invoke(ctx, "SubmitMetric", &m, &result, opts)
+	return result, err
+}
+
+// SubmitBatch анализирует пакет метрик, как POST /metrics/batch
+func (c *Client) SubmitBatch(ctx context.Context, metrics []Metric, opts ...grpc.CallOption) (BatchResponse, error) {
+	var resp BatchResponse
+	err := c.invoke(ctx, "SubmitBatch", &models.MetricsBatch{Metrics: metrics}, &resp, opts)
+	return resp, err
+}
+
+// GetStats возвращает счетчики сервиса и статистику общих окон или окон
+// устройства deviceID
+func (c *Client) GetStats(ctx context.Context, deviceID string, opts ...grpc.CallOption) (Stats, error) {
+	var stats Stats
+	err := c.invoke(ctx, "GetStats", &grpcapi.StatsRequest{DeviceID: deviceID}, &stats, opts)
+	return stats, err
+}
+
+// GetLatest возвращает последние count сохраненных метрик; 0 — 50 метрик
+func (c *Client) GetLatest(ctx context.Context, count int, opts ...grpc.CallOption) ([]Metric, error) {
+	var resp grpcapi.LatestResponse
+	err := c.invoke(ctx, "GetLatest", &grpcapi.LatestRequest{Count: int32(count)}, &resp, opts)
+	return resp.Metrics, err
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, reply interface{}, opts []grpc.CallOption) error {
+	if c.apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, grpcapi.APIKeyMetadata, c.apiKey)
+	}
+	return c.cc.Invoke(ctx, "/"+grpcapi.ServiceName+"/"+method, req, reply, append(opts, grpc.ForceCodec(grpcapi.Codec{}))...)
+}
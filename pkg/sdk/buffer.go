@@ -0,0 +1,383 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBufferFull локальная очередь заполнена, метрика не принята
+	ErrBufferFull = errors.New("sdk: buffer is full")
+	// ErrClosed буфер закрыт
+	ErrClosed = errors.New("sdk: buffer is closed")
+)
+
+// spillPattern шаблон файлов с пакетами, сохраненными на диск
+const spillPattern = "spill-*.json.gz"
+
+// BufferConfig настройки асинхронной отправки. Нулевые значения заменяются значениями по умолчанию
+type BufferConfig struct {
+	// QueueSize размер локальной очереди метрик (10000)
+	QueueSize int
+	// MaxBatch максимальный размер пакета (500)
+	MaxBatch int
+	// FlushInterval период отправки неполного пакета (1s)
+	FlushInterval time.Duration
+	// MaxRetries количество повторов пакета перед сохранением на диск (5)
+	MaxRetries int
+	// InitialBackoff и MaxBackoff границы экспоненциальной задержки между повторами (200ms, 30s)
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// SpillDir каталог для пакетов, которые не удалось отправить; пустое значение отключает сохранение
+	SpillDir string
+	// MaxSpillFiles ограничение количества файлов в SpillDir (1000)
+	MaxSpillFiles int
+	// DisableCompression отключает gzip-сжатие пакетов
+	DisableCompression bool
+}
+
+func (c BufferConfig) withDefaults() BufferConfig {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10000
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 500
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = 5
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 200 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.MaxSpillFiles <= 0 {
+		c.MaxSpillFiles = 1000
+	}
+	return c
+}
+
+// Stats счетчики буфера
+type Stats struct {
+	// Sent метрики, принятые сервером
+	Sent int64
+	// Retries повторные попытки отправки пакетов
+	Retries int64
+	// Spilled метрики, сохраненные на диск
+	Spilled int64
+	// Replayed метрики, отправленные с диска после восстановления связи
+	Replayed int64
+	// Dropped метрики, потерянные окончательно (отклонены сервером или не поместились на диск)
+	Dropped int64
+}
+
+// Buffer асинхронно отправляет метрики пакетами
+type Buffer struct {
+	client *Client
+	cfg    BufferConfig
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Metric
+	flush  chan chan struct{}
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	seq      atomic.Int64
+	sent     atomic.Int64
+	retries  atomic.Int64
+	spilled  atomic.Int64
+	replayed atomic.Int64
+	dropped  atomic.Int64
+}
+
+// NewBuffer запускает асинхронную отправку через client.
+// Пакеты, сохраненные в SpillDir предыдущим запуском, будут отправлены повторно
+func NewBuffer(client *Client, cfg BufferConfig) (*Buffer, error) {
+	cfg = cfg.withDefaults()
+	if cfg.SpillDir != "" {
+		if err := os.MkdirAll(cfg.SpillDir, 0o755); err != nil {
+			return nil, fmt.Errorf("sdk: spill dir: %w", err)
+		}
+	}
+
+	// Копия клиента, чтобы настройка сжатия не влияла на синхронные вызовы
+	bc := *client
+	bc.compress = !cfg.DisableCompression
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Buffer{
+		client: &bc,
+		cfg:    cfg,
+		queue:  make(chan Metric, cfg.QueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go b.run()
+	return b, nil
+}
+
+// Enqueue ставит метрику в очередь. Не блокирует: при заполненной очереди возвращает ErrBufferFull
+func (b *Buffer) Enqueue(m Metric) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	select {
+	case b.queue <- m:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Flush отправляет все метрики, накопленные к моменту вызова
+func (b *Buffer) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case b.flush <- ack:
+	case <-b.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close прекращает прием метрик и отправляет остаток очереди. Если ctx истекает
+// раньше, неотправленные пакеты сохраняются на диск
+func (b *Buffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		<-b.done
+		return ctx.Err()
+	}
+}
+
+// Stats возвращает счетчики буфера
+func (b *Buffer) Stats() Stats {
+	return Stats{
+		Sent:     b.sent.Load(),
+		Retries:  b.retries.Load(),
+		Spilled:  b.spilled.Load(),
+		Replayed: b.replayed.Load(),
+		Dropped:  b.dropped.Load(),
+	}
+}
+
+func (b *Buffer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Metric, 0, b.cfg.MaxBatch)
+	for {
+		select {
+		case m, ok := <-b.queue:
+			if !ok {
+				b.send(batch)
+				return
+			}
+			if batch = append(batch, m); len(batch) >= b.cfg.MaxBatch {
+				batch = b.send(batch)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				batch = b.send(batch)
+			}
+			b.replaySpilled()
+		case ack := <-b.flush:
+			for drained := false; !drained; {
+				select {
+				case m, ok := <-b.queue:
+					if !ok {
+						drained = true
+						break
+					}
+					if batch = append(batch, m); len(batch) >= b.cfg.MaxBatch {
+						batch = b.send(batch)
+					}
+				default:
+					drained = true
+				}
+			}
+			batch = b.send(batch)
+			close(ack)
+		}
+	}
+}
+
+// send доставляет пакет или сохраняет его на диск и возвращает пустой срез для следующего пакета
+func (b *Buffer) send(batch []Metric) []Metric {
+	if len(batch) == 0 {
+		return batch
+	}
+	err := b.deliver(batch)
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		b.sent.Add(int64(len(batch)))
+	case errors.As(err, &apiErr) && !apiErr.Temporary():
+		// Сервер отклонил пакет: повтор с диска закончится тем же
+		b.dropped.Add(int64(len(batch)))
+	default:
+		b.spill(batch)
+	}
+	return batch[:0]
+}
+
+// deliver отправляет пакет с повторами и экспоненциальной задержкой
+func (b *Buffer) deliver(batch []Metric) error {
+	backoff := b.cfg.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := b.client.SendBatch(b.ctx, batch)
+		if err == nil {
+			return nil
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			return err
+		}
+		if attempt >= b.cfg.MaxRetries {
+			return err
+		}
+
+		// Полный джиттер, чтобы шлюзы после сбоя не повторяли запросы синхронно
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		if apiErr != nil && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		b.retries.Add(1)
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		}
+		if backoff *= 2; backoff > b.cfg.MaxBackoff {
+			backoff = b.cfg.MaxBackoff
+		}
+	}
+}
+
+// spill сохраняет пакет на диск; без SpillDir или при превышении лимита пакет теряется
+func (b *Buffer) spill(batch []Metric) {
+	if b.cfg.SpillDir == "" || len(b.spillFiles()) >= b.cfg.MaxSpillFiles {
+		b.dropped.Add(int64(len(batch)))
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(batch); err != nil || zw.Close() != nil {
+		b.dropped.Add(int64(len(batch)))
+		return
+	}
+
+	// Имя начинается с времени, поэтому сортировка по имени дает порядок записи
+	name := fmt.Sprintf("spill-%020d-%06d.json.gz", time.Now().UnixNano(), b.seq.Add(1))
+	tmp := filepath.Join(b.cfg.SpillDir, "."+name)
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		b.dropped.Add(int64(len(batch)))
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(b.cfg.SpillDir, name)); err != nil {
+		os.Remove(tmp)
+		b.dropped.Add(int64(len(batch)))
+		return
+	}
+	b.spilled.Add(int64(len(batch)))
+}
+
+// spillFiles возвращает сохраненные пакеты от старых к новым
+func (b *Buffer) spillFiles() []string {
+	if b.cfg.SpillDir == "" {
+		return nil
+	}
+	files, _ := filepath.Glob(filepath.Join(b.cfg.SpillDir, spillPattern))
+	sort.Strings(files)
+	return files
+}
+
+// replaySpilled досылает сохраненные пакеты, пока сервер их принимает.
+// Одна попытка на пакет: при временной ошибке досылка откладывается до следующего тика
+func (b *Buffer) replaySpilled() {
+	for _, file := range b.spillFiles() {
+		if b.ctx.Err() != nil {
+			return
+		}
+		batch, err := readSpill(file)
+		if err != nil {
+			// Поврежденный файл не будет прочитан и в следующий раз
+			os.Remove(file)
+			continue
+		}
+
+		_, err = b.client.SendBatch(b.ctx, batch)
+		var apiErr *APIError
+		switch {
+		case err == nil:
+			b.replayed.Add(int64(len(batch)))
+		case errors.As(err, &apiErr) && !apiErr.Temporary():
+			b.dropped.Add(int64(len(batch)))
+		default:
+			return
+		}
+		os.Remove(file)
+	}
+}
+
+func readSpill(path string) ([]Metric, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var batch []Metric
+	err = json.NewDecoder(zr).Decode(&batch)
+	return batch, err
+}
@@ -0,0 +1,187 @@
+// Package sdk клиентская библиотека для отправки метрик в Highload Service.
+//
+// Client отправляет метрики синхронно. Для шлюзов на нестабильных каналах
+// предназначен Buffer: метрики копятся в локальной очереди, отправляются пакетами
+// со сжатием и повторами, а при недоступности сервера сохраняются на диск
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric метрика устройства
+type Metric struct {
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	CPU       float64   `json:"cpu"`
+	RPS       float64   `json:"rps"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	Region    string    `json:"region,omitempty"`
+}
+
+// AnalysisResult результат анализа метрики сервером
+type AnalysisResult struct {
+	Timestamp       time.Time `json:"timestamp"`
+	RollingAvgCPU   float64   `json:"rolling_avg_cpu"`
+	RollingAvgRPS   float64   `json:"rolling_avg_rps"`
+	ZScoreCPU       float64   `json:"z_score_cpu"`
+	ZScoreRPS       float64   `json:"z_score_rps"`
+	IsAnomalyCPU    bool      `json:"is_anomaly_cpu"`
+	IsAnomalyRPS    bool      `json:"is_anomaly_rps"`
+	AnomalyDetected bool      `json:"anomaly_detected"`
+}
+
+// BatchResult ответ на отправку пакета
+type BatchResult struct {
+	Processed int `json:"processed"`
+	// Rejected метрики, не прошедшие проверку или анализ на сервере
+	Rejected       int              `json:"rejected"`
+	AnomaliesFound int              `json:"anomalies_found"`
+	Results        []AnalysisResult `json:"results"`
+}
+
+// APIError ответ сервера с кодом ошибки
+type APIError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter значение заголовка Retry-After, если сервер его прислал
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("highload api: status %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary сообщает, имеет ли смысл повторить запрос позже
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Option настраивает Client
+type Option func(*Client)
+
+// WithAPIKey задает API-ключ (заголовок X-API-Key)
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithTenant задает тенанта (заголовок X-Tenant-ID)
+func WithTenant(tenant string) Option {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+// WithHTTPClient задает HTTP-клиент вместо клиента с таймаутом 10 секунд
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithCompression включает gzip-сжатие тел запросов
+func WithCompression(enabled bool) Option {
+	return func(c *Client) {
+		c.compress = enabled
+	}
+}
+
+// Client синхронный клиент API
+type Client struct {
+	baseURL  string
+	apiKey   string
+	tenant   string
+	compress bool
+	http     *http.Client
+}
+
+// NewClient создает клиент для сервиса по адресу baseURL (например, http://localhost:8080)
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Send отправляет одну метрику и возвращает результат анализа
+func (c *Client) Send(ctx context.Context, m Metric) (AnalysisResult, error) {
+	var result AnalysisResult
+	err := c.post(ctx, "/metrics", m, &result)
+	return result, err
+}
+
+// SendBatch отправляет пакет метрик
+func (c *Client) SendBatch(ctx context.Context, batch []Metric) (BatchResult, error) {
+	var result BatchResult
+	err := c.post(ctx, "/metrics/batch", map[string][]Metric{"metrics": batch}, &result)
+	return result, err
+}
+
+func (c *Client) post(ctx context.Context, path string, payload, dest interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if c.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var errBody struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &errBody) == nil && errBody.Error != "" {
+			apiErr.Message = errBody.Error
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
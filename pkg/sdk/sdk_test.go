@@ -0,0 +1,193 @@
+package sdk
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"highload-service/internal/analytics"
+	"highload-service/internal/handlers"
+)
+
+// fakeServer accepts batches while up and answers 503 while down.
+type fakeServer struct {
+	*httptest.Server
+	up       atomic.Bool
+	failNext atomic.Int32
+	mu       sync.Mutex
+	received int
+	gzipped  bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{}
+	s.up.Store(true)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.up.Load() || s.failNext.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch struct {
+			Metrics []Metric `json:"metrics"`
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Expected gzip body: %v", err)
+			return
+		}
+		json.NewDecoder(zr).Decode(&batch)
+		s.mu.Lock()
+		s.received += len(batch.Metrics)
+		s.gzipped = r.Header.Get("Content-Encoding") == "gzip"
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(BatchResult{Processed: len(batch.Metrics)})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+func fastConfig() BufferConfig {
+	return BufferConfig{
+		MaxBatch:       10,
+		FlushInterval:  10 * time.Millisecond,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+}
+
+func TestClient_SendAgainstServer(t *testing.T) {
+	router := mux.NewRouter()
+	handlers.NewHandler(analytics.NewAnalyzer(10), nil).RegisterRoutes(router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithAPIKey("gateway-1"), WithCompression(true))
+	result, err := client.Send(context.Background(), Metric{CPU: 40, RPS: 100, DeviceID: "sensor-1"})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if result.RollingAvgCPU != 40 {
+		t.Errorf("Expected rolling average 40, got %+v", result)
+	}
+
+	batch, err := client.SendBatch(context.Background(), []Metric{{CPU: 1, RPS: 2}, {CPU: 3, RPS: 4}})
+	if err != nil || batch.Processed != 2 {
+		t.Errorf("Expected compressed batch of 2 to be processed, got %+v (%v)", batch, err)
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Quota exceeded"})
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL).Send(context.Background(), Metric{CPU: 1})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError, got %v", err)
+	}
+	if apiErr.Message != "Quota exceeded" || apiErr.RetryAfter != 7*time.Second || !apiErr.Temporary() {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+}
+
+func TestBuffer_BatchesWithRetries(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.failNext.Store(2)
+
+	b, err := NewBuffer(NewClient(srv.URL), fastConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		if err := b.Enqueue(Metric{CPU: float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := b.Stats()
+	if srv.count() != 25 || stats.Sent != 25 || stats.Retries != 2 {
+		t.Errorf("Expected 25 metrics delivered after 2 retries, got server=%d stats=%+v", srv.count(), stats)
+	}
+	if !srv.gzipped {
+		t.Error("Expected batches to be gzip-compressed")
+	}
+	if err := b.Enqueue(Metric{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestBuffer_SpillsAndReplays(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.up.Store(false)
+
+	cfg := fastConfig()
+	cfg.SpillDir = t.TempDir()
+	b, err := NewBuffer(NewClient(srv.URL), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	for i := 0; i < 15; i++ {
+		b.Enqueue(Metric{CPU: float64(i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := b.Stats(); stats.Spilled != 15 || stats.Sent != 0 {
+		t.Fatalf("Expected all 15 metrics spilled while the server is down, got %+v", stats)
+	}
+
+	srv.up.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Stats().Replayed < 15 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := b.Stats(); stats.Replayed != 15 || srv.count() != 15 {
+		t.Errorf("Expected spilled metrics replayed once the server is back, got server=%d stats=%+v", srv.count(), stats)
+	}
+}
+
+func TestBuffer_FullQueue(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.up.Store(false)
+
+	cfg := fastConfig()
+	cfg.QueueSize = 1
+	cfg.FlushInterval = time.Hour
+	b, _ := NewBuffer(NewClient(srv.URL), cfg)
+	defer b.Close(context.Background())
+
+	var full bool
+	for i := 0; i < 100 && !full; i++ {
+		full = errors.Is(b.Enqueue(Metric{}), ErrBufferFull)
+	}
+	if !full {
+		t.Error("Expected ErrBufferFull once the local queue is exhausted")
+	}
+}